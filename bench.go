@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runBench implements `shorty bench`. It seeds a running instance with
+// synthetic links, then sends redirects and creates at a fixed rate and
+// reports how long they took. The rate is held whatever the latency, so a
+// slow server shows up as a slow percentile or as missed requests rather
+// than as a lower rate. It returns the process exit code.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	target := fs.String("target", "", "base URL of the shorty instance, e.g. http://localhost:9130")
+	links := fs.Int("links", 1000, "number of links to seed before the run")
+	rps := fs.Int("rps", 500, "requests per second to send")
+	duration := fs.Duration("duration", 30*time.Second, "how long to send requests for")
+	createShare := fs.Float64("create", 0.1, "share of requests that create a link rather than follow one")
+	concurrency := fs.Int("concurrency", 64, "most requests in flight at once")
+	key := fs.String("key", os.Getenv("SHORTY_API_KEY"), "admin API key, to delete the links made by the run (default $SHORTY_API_KEY)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each HTTP request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *target == "" || *links <= 0 || *rps <= 0 || *duration <= 0 || *concurrency <= 0 || *createShare < 0 || *createShare > 1 {
+		fmt.Fprintln(os.Stderr, "usage: shorty bench --target http://localhost:9130 [--links 10000] [--rps 500] [--duration 30s]")
+		return 2
+	}
+
+	c := newAPIClient(*target, *key, *timeout)
+	c.http.Transport = &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        *concurrency,
+		MaxIdleConnsPerHost: *concurrency,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	b, err := newBencher(c, *concurrency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bench failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Seeding %d links...\n", *links)
+	start := time.Now()
+	if err := b.seed(*links); err != nil {
+		fmt.Fprintf(os.Stderr, "seeding failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Seeded %d links in %v\n", *links, time.Since(start).Round(time.Millisecond))
+
+	fmt.Printf("Sending %d requests/s for %v, %.0f%% creates...\n", *rps, *duration, *createShare*100)
+	b.run(*rps, *duration, *createShare)
+	b.report(os.Stdout, *duration)
+
+	if *key != "" {
+		fmt.Printf("Deleting %d links...\n", len(b.codes))
+		if failed := b.cleanup(); failed > 0 {
+			fmt.Fprintf(os.Stderr, "%d links couldn't be deleted\n", failed)
+		}
+	} else {
+		fmt.Printf("Left %d links behind; pass --key to delete them after the run\n", len(b.codes))
+	}
+	return 0
+}
+
+// Kinds of request the bench sends.
+const (
+	benchRedirect = "redirect"
+	benchCreate   = "create"
+)
+
+type bencher struct {
+	client      *apiClient
+	concurrency int
+	// nonce keeps each run's long URLs apart, as shorty hands back the
+	// existing link for a long URL it has seen before.
+	nonce string
+	next  atomic.Int64
+
+	mu        sync.Mutex
+	codes     []string
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	missed    int
+}
+
+func newBencher(c *apiClient, concurrency int) (*bencher, error) {
+	nonce := make([]byte, 6)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &bencher{
+		client:      c,
+		concurrency: concurrency,
+		nonce:       fmt.Sprintf("%x", nonce),
+		latencies:   map[string][]time.Duration{},
+		errors:      map[string]int{},
+	}, nil
+}
+
+// create makes a link to a new synthetic long URL and remembers its code.
+func (b *bencher) create() error {
+	n := b.next.Add(1)
+	link, err := b.client.createLink(fmt.Sprintf("https://bench.example/%s/%d", b.nonce, n))
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.codes = append(b.codes, link.ShortURL)
+	b.mu.Unlock()
+	return nil
+}
+
+// redirect follows a random link made by the run.
+func (b *bencher) redirect() error {
+	b.mu.Lock()
+	code := b.codes[mathrand.Intn(len(b.codes))]
+	b.mu.Unlock()
+
+	resp, err := b.client.http.Get(b.client.shortLink(code))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return fmt.Errorf("redirect of '%s' returned status %d", code, resp.StatusCode)
+	}
+	return nil
+}
+
+// seed creates n links, concurrency at a time. It stops at the first
+// failure, which is most likely a wrong target or a rate limit.
+func (b *bencher) seed(n int) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	work := make(chan struct{})
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				if err := b.create(); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		work <- struct{}{}
+	}
+	close(work)
+	wg.Wait()
+	return firstErr
+}
+
+// run sends rps requests a second for duration. A request that is due
+// while all concurrency slots are busy is counted as missed, not queued.
+func (b *bencher) run(rps int, duration time.Duration, createShare float64) {
+	slots := make(chan struct{}, b.concurrency)
+	var wg sync.WaitGroup
+	interval := time.Second / time.Duration(rps)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	for {
+		select {
+		case <-deadline:
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			b.mu.Lock()
+			b.missed++
+			b.mu.Unlock()
+			continue
+		}
+		kind, do := benchRedirect, b.redirect
+		if mathrand.Float64() < createShare {
+			kind, do = benchCreate, b.create
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			start := time.Now()
+			err := do()
+			b.record(kind, time.Since(start), err)
+		}()
+	}
+}
+
+func (b *bencher) record(kind string, latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.errors[kind]++
+		return
+	}
+	b.latencies[kind] = append(b.latencies[kind], latency)
+}
+
+// report prints the rate and latency percentiles of each kind of request.
+func (b *bencher) report(w io.Writer, duration time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprintf(w, "%-9s %8s %7s %9s %9s %9s %9s %9s\n", "", "ok", "errors", "req/s", "p50", "p90", "p99", "max")
+	for _, kind := range []string{benchRedirect, benchCreate} {
+		latencies := b.latencies[kind]
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(w, "%-9s %8d %7d %9.1f %9v %9v %9v %9v\n", kind, len(latencies), b.errors[kind],
+			float64(len(latencies))/duration.Seconds(),
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100))
+	}
+	if b.missed > 0 {
+		fmt.Fprintf(w, "Missed %d requests with all %d connections busy\n", b.missed, b.concurrency)
+	}
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(10 * time.Microsecond)
+}
+
+// cleanup deletes every link the run made and returns how many it
+// couldn't.
+func (b *bencher) cleanup() int {
+	var wg sync.WaitGroup
+	var failed atomic.Int64
+	work := make(chan string)
+	for i := 0; i < b.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for code := range work {
+				// For good, so the run doesn't fill the trash.
+				if err := b.client.do(http.MethodDelete, "/api/v1/links/"+code+"?permanent=true", nil, http.StatusNoContent, nil); err != nil {
+					failed.Add(1)
+				}
+			}
+		}()
+	}
+	for _, code := range b.codes {
+		work <- code
+	}
+	close(work)
+	wg.Wait()
+	return int(failed.Load())
+}
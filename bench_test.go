@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/server"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := percentile(latencies, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
+
+func TestBench(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Database.Name = filepath.Join(t.TempDir(), "bench.db")
+	cfg.ShortURL.Length = 8
+	cfg.ShortURL.Charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	cfg.API.AdminKey = "secret"
+	st, err := store.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	srv, err := server.New(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	b, err := newBencher(newAPIClient(ts.URL, "secret", time.Second), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.seed(20); err != nil {
+		t.Fatalf("seed returned an error: %v", err)
+	}
+	b.run(100, 300*time.Millisecond, 0.5)
+
+	var out bytes.Buffer
+	b.report(&out, 300*time.Millisecond)
+	if len(b.latencies[benchRedirect]) == 0 || len(b.latencies[benchCreate]) == 0 || len(b.errors) > 0 {
+		t.Errorf("run recorded %d redirects, %d creates and errors %v", len(b.latencies[benchRedirect]), len(b.latencies[benchCreate]), b.errors)
+	}
+	if !strings.Contains(out.String(), "redirect") || !strings.Contains(out.String(), "p99") {
+		t.Errorf("report is missing rows:\n%s", out.String())
+	}
+
+	if failed := b.cleanup(); failed > 0 {
+		t.Errorf("cleanup failed to delete %d links", failed)
+	}
+	if count, err := st.Count(context.Background()); err != nil || count != 0 {
+		t.Errorf("cleanup left %d links, %v", count, err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/server"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// apiClient talks to a running shorty instance over the JSON API. It is
+// shared by the `client` and `probe` subcommands.
+type apiClient struct {
+	baseURL string
+	key     string
+	http    *http.Client
+}
+
+func newAPIClient(baseURL, key string, timeout time.Duration) *apiClient {
+	return &apiClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		key:     key,
+		http: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// shortLink returns the public URL of a short code on this instance.
+func (c *apiClient) shortLink(shortURL string) string {
+	return c.baseURL + "/_/" + shortURL
+}
+
+func (c *apiClient) createLink(longURL string) (store.LinkStats, error) {
+	var link store.LinkStats
+	body, err := json.Marshal(server.CreateLinkRequest{URL: longURL})
+	if err != nil {
+		return link, err
+	}
+	err = c.do(http.MethodPost, "/api/v1/links", bytes.NewReader(body), http.StatusCreated, &link)
+	return link, err
+}
+
+func (c *apiClient) getLink(shortURL string) (store.LinkStats, error) {
+	var link store.LinkStats
+	err := c.do(http.MethodGet, "/api/v1/links/"+shortURL, nil, http.StatusOK, &link)
+	return link, err
+}
+
+func (c *apiClient) expand(shortURL string) (server.ExpandResult, error) {
+	var result server.ExpandResult
+	err := c.do(http.MethodGet, "/api/v1/expand/"+shortURL, nil, http.StatusOK, &result)
+	return result, err
+}
+
+// shareLink asks for a signed stats page URL for shortURL. A zero ttl
+// leaves the lifetime to the server.
+func (c *apiClient) shareLink(shortURL string, ttl time.Duration) (server.ShareLink, error) {
+	var link server.ShareLink
+	path := "/api/v1/links/" + shortURL + "/share"
+	if ttl > 0 {
+		path += "?ttl=" + url.QueryEscape(ttl.String())
+	}
+	err := c.do(http.MethodPost, path, nil, http.StatusCreated, &link)
+	return link, err
+}
+
+func (c *apiClient) deleteLink(shortURL string) error {
+	return c.do(http.MethodDelete, "/api/v1/links/"+shortURL, nil, http.StatusNoContent, nil)
+}
+
+// do sends a request to the API and decodes the JSON response into out when
+// out is non-nil. Any status other than want is returned as an error.
+func (c *apiClient) do(method, path string, body io.Reader, want int, out interface{}) error {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.key)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return unexpectedStatus(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// unexpectedStatus turns a non-success response into an error, using the
+// API error envelope's code and message when the body has one.
+func unexpectedStatus(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	var envelope server.APIErrorResponse
+	if json.Unmarshal(msg, &envelope) == nil && envelope.Error.Code != "" {
+		return fmt.Errorf("unexpected status %d: %s (%s)", resp.StatusCode, envelope.Error.Message, envelope.Error.Code)
+	}
+	return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// runClient implements `shorty client shorten <url>` and
+// `shorty client expand <code>` against a remote instance. The base URL and
+// API key come from the client section of the config file, the SHORTY_URL
+// and SHORTY_API_KEY environment variables, or flags, in increasing order of
+// precedence.
+func runClient(args []string) int {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	configPath := fs.String("config", "shorty.config", "path to the config file")
+	baseURL := fs.String("url", "", "base URL of the shorty instance (default client.baseURL or $SHORTY_URL)")
+	key := fs.String("key", "", "API key (default client.apiKey or $SHORTY_API_KEY)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each HTTP request")
+	ttl := fs.Duration("ttl", 0, "how long a share link works (default share.ttl on the server)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shorty client [flags] shorten <url>")
+		fmt.Fprintln(fs.Output(), "       shorty client [flags] expand <code>")
+		fmt.Fprintln(fs.Output(), "       shorty client [flags] share <code>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg := &config.Config{}
+	if _, err := os.Stat(*configPath); err == nil {
+		if cfg, err = config.Load(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	*baseURL = firstNonEmpty(*baseURL, os.Getenv("SHORTY_URL"), cfg.Client.BaseURL)
+	*key = firstNonEmpty(*key, os.Getenv("SHORTY_API_KEY"), cfg.Client.APIKey)
+	if *baseURL == "" {
+		fmt.Fprintln(os.Stderr, "No instance configured: set client.baseURL, $SHORTY_URL or -url")
+		return 2
+	}
+
+	c := newAPIClient(*baseURL, *key, *timeout)
+	switch fs.Arg(0) {
+	case "shorten":
+		link, err := c.createLink(fs.Arg(1))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "shorten failed: %v\n", err)
+			return 1
+		}
+		fmt.Println(c.shortLink(link.ShortURL))
+	case "expand":
+		result, err := c.expand(bareCode(fs.Arg(1)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "expand failed: %v\n", err)
+			return 1
+		}
+		fmt.Println(result.LongURL)
+	case "share":
+		link, err := c.shareLink(bareCode(fs.Arg(1)), *ttl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "share failed: %v\n", err)
+			return 1
+		}
+		fmt.Println(link.URL)
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
+
+// bareCode accepts a full short link as well as a bare code.
+func bareCode(code string) string {
+	if i := strings.LastIndex(code, "/_/"); i >= 0 {
+		return code[i+len("/_/"):]
+	}
+	return code
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/server"
+	"github.com/donuts-are-good/shorty/shortytest"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestAPIClient(t *testing.T) {
+	writeJSON := func(w http.ResponseWriter, status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/links":
+			var req server.CreateLinkRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			writeJSON(w, http.StatusCreated, store.LinkStats{ShortURL: "abc123", LongURL: req.URL})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/links/abc123":
+			writeJSON(w, http.StatusOK, store.LinkStats{ShortURL: "abc123", LongURL: "https://example.com", VisitCount: 7})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/expand/abc123":
+			writeJSON(w, http.StatusOK, server.ExpandResult{ShortURL: "abc123", LongURL: "https://example.com", Status: server.LinkStatusActive})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/links/abc123/share":
+			writeJSON(w, http.StatusCreated, server.ShareLink{URL: "/_/abc123/stats?ttl=" + r.URL.Query().Get("ttl")})
+		case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/links/abc123":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	c := newAPIClient(ts.URL+"/", "secret", time.Second)
+
+	link, err := c.createLink("https://example.com")
+	if err != nil {
+		t.Fatalf("createLink returned an error: %v", err)
+	}
+	if link.ShortURL != "abc123" || link.LongURL != "https://example.com" {
+		t.Errorf("createLink returned unexpected link: %+v", link)
+	}
+	if got := c.shortLink(link.ShortURL); got != ts.URL+"/_/abc123" {
+		t.Errorf("shortLink returned wrong URL: got %v want %v", got, ts.URL+"/_/abc123")
+	}
+
+	link, err = c.getLink("abc123")
+	if err != nil {
+		t.Fatalf("getLink returned an error: %v", err)
+	}
+	if link.VisitCount != 7 {
+		t.Errorf("getLink returned wrong visit count: got %v want %v", link.VisitCount, 7)
+	}
+
+	result, err := c.expand("abc123")
+	if err != nil {
+		t.Fatalf("expand returned an error: %v", err)
+	}
+	if result.LongURL != "https://example.com" {
+		t.Errorf("expand returned wrong URL: got %v want %v", result.LongURL, "https://example.com")
+	}
+
+	share, err := c.shareLink(bareCode(ts.URL+"/_/abc123"), 72*time.Hour)
+	if err != nil {
+		t.Fatalf("shareLink returned an error: %v", err)
+	}
+	if share.URL != "/_/abc123/stats?ttl=72h0m0s" {
+		t.Errorf("shareLink returned wrong URL: got %v", share.URL)
+	}
+
+	if _, err := c.getLink("missing"); err == nil {
+		t.Error("Expected an error for a missing link, got nil")
+	}
+
+	if err := c.deleteLink("abc123"); err != nil {
+		t.Errorf("deleteLink returned an error: %v", err)
+	}
+
+	c.key = "wrong"
+	if err := c.deleteLink("abc123"); err == nil {
+		t.Error("Expected an error when deleting with a bad key, got nil")
+	}
+}
+
+func TestAPIClientServer(t *testing.T) {
+	srv := shortytest.New(t, shortytest.Options{})
+	srv.Seed(t, shortytest.Link{ShortURL: "abc123", LongURL: "https://example.com"})
+	c := newAPIClient(srv.URL, "", 10*time.Second)
+
+	result, err := c.expand("abc123")
+	if err != nil {
+		t.Fatalf("expand returned an error: %v", err)
+	}
+	if result.LongURL != "https://example.com" {
+		t.Errorf("expand returned wrong URL: got %v want %v", result.LongURL, "https://example.com")
+	}
+
+	link, err := c.createLink("https://example.org")
+	if err != nil {
+		t.Fatalf("createLink returned an error: %v", err)
+	}
+	if got := c.shortLink(link.ShortURL); !strings.HasPrefix(got, srv.URL+"/_/") {
+		t.Errorf("shortLink returned unexpected link: %v", got)
+	}
+}
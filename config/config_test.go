@@ -0,0 +1,224 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	var v struct {
+		Timeout Duration `json:"timeout"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"timeout": "1m30s"}`), &v); err != nil {
+		t.Fatalf("Unmarshal returned an error: %v", err)
+	}
+	if v.Timeout.Duration != 90*time.Second {
+		t.Errorf("Unmarshal returned wrong duration: got %v want %v", v.Timeout.Duration, 90*time.Second)
+	}
+
+	if err := json.Unmarshal([]byte(`{"timeout": 30}`), &v); err == nil {
+		t.Error("Expected an error for a numeric duration, got nil")
+	}
+
+	if err := json.Unmarshal([]byte(`{"timeout": "soon"}`), &v); err == nil {
+		t.Error("Expected an error for an invalid duration, got nil")
+	}
+}
+
+func TestDurationOr(t *testing.T) {
+	var unset Duration
+	if got := unset.Or(time.Second); got != time.Second {
+		t.Errorf("Or returned wrong default: got %v want %v", got, time.Second)
+	}
+
+	set := Duration{5 * time.Second}
+	if got := set.Or(time.Second); got != 5*time.Second {
+		t.Errorf("Or returned wrong value: got %v want %v", got, 5*time.Second)
+	}
+}
+
+func TestDatabaseDSN(t *testing.T) {
+	cfg := &Config{}
+	cfg.Database.Name = "./url_mapping.db"
+	want := "./url_mapping.db?_busy_timeout=5000&_journal_mode=WAL&_synchronous=NORMAL"
+	if got := cfg.DatabaseDSN(); got != want {
+		t.Errorf("DatabaseDSN returned wrong DSN: got %v want %v", got, want)
+	}
+
+	cfg.Database.Name = "file:test.db?cache=shared"
+	cfg.Database.JournalMode = "DELETE"
+	cfg.Database.BusyTimeout = Duration{250 * time.Millisecond}
+	cfg.Database.Synchronous = "FULL"
+	want = "file:test.db?cache=shared&_busy_timeout=250&_journal_mode=DELETE&_synchronous=FULL"
+	if got := cfg.DatabaseDSN(); got != want {
+		t.Errorf("DatabaseDSN returned wrong DSN: got %v want %v", got, want)
+	}
+
+	cfg.Database.Driver = DriverModernc
+	want = "file:test.db?cache=shared&_pragma=busy_timeout%28250%29&_pragma=journal_mode%28DELETE%29&_pragma=synchronous%28FULL%29"
+	if got := cfg.DatabaseDSN(); got != want {
+		t.Errorf("DatabaseDSN for modernc returned wrong DSN: got %v want %v", got, want)
+	}
+}
+
+func TestReadDatabaseDSN(t *testing.T) {
+	cfg := &Config{}
+	if got := cfg.ReadDatabaseDSN(); got != "" {
+		t.Errorf("ReadDatabaseDSN without a replica returned %v, want \"\"", got)
+	}
+	cfg.Database.ReadName = "/litefs/url_mapping.db"
+	want := "/litefs/url_mapping.db?_busy_timeout=5000&_query_only=1"
+	if got := cfg.ReadDatabaseDSN(); got != want {
+		t.Errorf("ReadDatabaseDSN returned wrong DSN: got %v want %v", got, want)
+	}
+
+	cfg.Database.Driver = DriverModernc
+	want = "/litefs/url_mapping.db?_pragma=busy_timeout%285000%29&_pragma=query_only%281%29"
+	if got := cfg.ReadDatabaseDSN(); got != want {
+		t.Errorf("ReadDatabaseDSN for modernc returned wrong DSN: got %v want %v", got, want)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	cfg := &Config{}
+	cfg.Server.Port = ":9130"
+	cfg.Server.ReadTimeout = Duration{time.Second}
+	listeners := cfg.Listeners()
+	if len(listeners) != 1 || listeners[0].Network != "tcp" || listeners[0].Address != ":9130" {
+		t.Fatalf("Listeners without server.listeners returned %+v", listeners)
+	}
+	if l := listeners[0]; l.ReadTimeout.Duration != time.Second || l.WriteTimeout.Duration != DefaultWriteTimeout || l.MaxHeaderBytes != DefaultMaxHeaderBytes {
+		t.Errorf("Listeners didn't fill in the server's limits: %+v", l)
+	}
+
+	cfg.Server.Listeners = []Listener{
+		{Address: ":80", RedirectHTTPS: true},
+		{Network: "unix", Address: "/run/shorty.sock", ReadTimeout: Duration{time.Minute}},
+	}
+	listeners = cfg.Listeners()
+	if len(listeners) != 2 || listeners[0].Address != ":80" || !listeners[0].RedirectHTTPS {
+		t.Fatalf("Listeners returned %+v", listeners)
+	}
+	if l := listeners[1]; l.Network != "unix" || l.ReadTimeout.Duration != time.Minute {
+		t.Errorf("Listeners didn't keep the listener's own settings: %+v", l)
+	}
+	if cfg.Server.Listeners[0].Network != "" {
+		t.Error("Listeners changed the config it read")
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	cfg := &Config{}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "sho.rt"
+	if got := cfg.PublicURL(req); got != "http://sho.rt" {
+		t.Errorf("PublicURL returned wrong URL: got %v want %v", got, "http://sho.rt")
+	}
+
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if got := cfg.PublicURL(req); got != "https://sho.rt" {
+		t.Errorf("PublicURL returned wrong URL: got %v want %v", got, "https://sho.rt")
+	}
+
+	cfg.Server.PublicURL = "https://links.example/"
+	if got := cfg.PublicURL(req); got != "https://links.example" {
+		t.Errorf("PublicURL returned wrong URL: got %v want %v", got, "https://links.example")
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	cfg := &Config{}
+	cfg.Database.Name = "urls.db"
+	cfg.Tenants = []Tenant{
+		{Name: "acme", Hosts: []string{"go.acme.com"}, Database: "acme.db"},
+		{Name: "example", Hosts: []string{"l.example.org", "links.example.org"}, Database: "example.db"},
+	}
+	if err := cfg.ValidateTenants(); err != nil {
+		t.Errorf("ValidateTenants returned an error: %v", err)
+	}
+
+	for name, tenants := range map[string][]Tenant{
+		"No Name":         {{Hosts: []string{"go.acme.com"}, Database: "acme.db"}},
+		"Bad Name":        {{Name: "acme corp", Hosts: []string{"go.acme.com"}, Database: "acme.db"}},
+		"Duplicate Name":  {{Name: "acme", Hosts: []string{"a.com"}, Database: "a.db"}, {Name: "acme", Hosts: []string{"b.com"}, Database: "b.db"}},
+		"No Hosts":        {{Name: "acme", Database: "acme.db"}},
+		"Host With Port":  {{Name: "acme", Hosts: []string{"go.acme.com:8080"}, Database: "acme.db"}},
+		"Shared Host":     {{Name: "a", Hosts: []string{"go.acme.com"}, Database: "a.db"}, {Name: "b", Hosts: []string{"GO.acme.com"}, Database: "b.db"}},
+		"No Database":     {{Name: "acme", Hosts: []string{"go.acme.com"}}},
+		"Main Database":   {{Name: "acme", Hosts: []string{"go.acme.com"}, Database: "urls.db"}},
+		"Shared Database": {{Name: "a", Hosts: []string{"a.com"}, Database: "x.db"}, {Name: "b", Hosts: []string{"b.com"}, Database: "x.db"}},
+		"Negative Length": {{Name: "acme", Hosts: []string{"go.acme.com"}, Database: "acme.db", CodeLength: -1}},
+	} {
+		cfg.Tenants = tenants
+		if err := cfg.ValidateTenants(); err == nil {
+			t.Errorf("ValidateTenants accepted %s", name)
+		}
+	}
+
+	cfg.Database.Backend = BackendMemory
+	cfg.Tenants = []Tenant{{Name: "acme", Hosts: []string{"go.acme.com"}}}
+	if err := cfg.ValidateTenants(); err != nil {
+		t.Errorf("ValidateTenants wanted a database for the memory backend: %v", err)
+	}
+}
+
+func TestForTenant(t *testing.T) {
+	cfg := &Config{}
+	cfg.Database.Name = "urls.db"
+	cfg.Database.ReadName = "replica.db"
+	cfg.Server.PublicURL = "https://sho.rt"
+	cfg.API.AdminKey = "secret"
+	cfg.Backup.Dir = "backups"
+	cfg.GRPC.Port = ":9131"
+	cfg.Digest.To = []string{"ops@example.com"}
+	cfg.ShortURL.Length = 7
+	tenant := Tenant{Name: "acme", Hosts: []string{"go.acme.com"}, Database: "acme.db"}
+	cfg.Tenants = []Tenant{tenant}
+
+	c := cfg.ForTenant(tenant)
+	if c.Database.Name != "acme.db" || c.Database.ReadName != "" || c.Server.PublicURL != "" {
+		t.Errorf("ForTenant kept the main database or URL: %+v %+v", c.Database, c.Server)
+	}
+	if len(c.Server.AllowedHosts) != 1 || c.Server.AllowedHosts[0] != "go.acme.com" {
+		t.Errorf("ForTenant allowed hosts %v want the tenant's", c.Server.AllowedHosts)
+	}
+	if c.API.AdminKey != "secret" || c.ShortURL.Length != 7 {
+		t.Error("ForTenant didn't share the rest of the config")
+	}
+	if c.Backup.Dir != filepath.Join("backups", "acme") || c.Backup.S3.Prefix != "acme/" {
+		t.Errorf("ForTenant returned backups in %q, %q", c.Backup.Dir, c.Backup.S3.Prefix)
+	}
+	if c.GRPC.Port != "" || len(c.Digest.To) != 0 || len(c.Tenants) != 0 {
+		t.Error("ForTenant kept parts of the main domain")
+	}
+	if cfg.Database.Name != "urls.db" || cfg.GRPC.Port != ":9131" {
+		t.Error("ForTenant changed the config it read")
+	}
+
+	tenant.AdminKey = "acme-secret"
+	tenant.PublicURL = "https://go.acme.com"
+	if c := cfg.ForTenant(tenant); c.API.AdminKey != "acme-secret" || c.Server.PublicURL != "https://go.acme.com" {
+		t.Errorf("ForTenant ignored the tenant's admin key or URL: %q, %q", c.API.AdminKey, c.Server.PublicURL)
+	}
+
+	cfg.Analytics.ClickLog = true
+	off := false
+	tenant.RedirectStatus = 301
+	tenant.CodeLength = 4
+	tenant.Analytics = &off
+	tenant.Templates = "themes/acme"
+	c = cfg.ForTenant(tenant)
+	if c.RedirectStatus() != 301 || c.ShortURL.Length != 4 || c.Theme.Templates != "themes/acme" {
+		t.Errorf("ForTenant ignored the tenant's defaults: %d, %d, %q", c.RedirectStatus(), c.ShortURL.Length, c.Theme.Templates)
+	}
+	if !c.Links.NoAnalytics || c.Analytics.ClickLog {
+		t.Error("ForTenant left analytics on for a tenant without them")
+	}
+	if cfg.RedirectStatus() != 302 || cfg.Links.NoAnalytics {
+		t.Error("ForTenant changed the main domain's defaults")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// runDB implements `shorty db vacuum|analyze|integrity-check`.
+func runDB(args []string) int {
+	fs := flag.NewFlagSet("db", flag.ContinueOnError)
+	configPath := fs.String("config", "shorty.config", "path to the config file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: shorty db [-config path] vacuum|analyze|integrity-check")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if cfg.Backend() != config.BackendSQLite {
+		fmt.Fprintf(os.Stderr, "shorty db only works on SQLite, not the %s backend\n", cfg.Backend())
+		return 1
+	}
+	db, err := store.OpenDB(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	switch fs.Arg(0) {
+	case "vacuum":
+		if err := store.Vacuum(ctx, db); err != nil {
+			fmt.Fprintf(os.Stderr, "Vacuum failed: %v\n", err)
+			return 1
+		}
+		fmt.Println("Database vacuumed.")
+	case "analyze":
+		if err := store.Analyze(ctx, db); err != nil {
+			fmt.Fprintf(os.Stderr, "Analyze failed: %v\n", err)
+			return 1
+		}
+		fmt.Println("Database analyzed.")
+	case "integrity-check":
+		problems, err := store.IntegrityCheck(ctx, db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Integrity check failed: %v\n", err)
+			return 1
+		}
+		if len(problems) > 0 {
+			for _, problem := range problems {
+				fmt.Println(problem)
+			}
+			return 1
+		}
+		fmt.Println("ok")
+	default:
+		fs.Usage()
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a socket
+// activated service. The rest follow it in order.
+const listenFDsStart = 3
+
+// serveHTTP starts an HTTP server for each configured listener and returns
+// them, so they can be shut down together. Each server's Addr is set to
+// where its socket ended up. Certificates are loaded and
+// sockets opened before anything is served, so a mistake in any listener
+// stops startup.
+func serveHTTP(cfg *config.Config, handler http.Handler) ([]*http.Server, error) {
+	listeners := cfg.Listeners()
+	activated, err := activatedListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*http.Server, len(listeners))
+	sockets := make([]net.Listener, len(listeners))
+	closeSockets := func() {
+		for _, l := range sockets {
+			if l != nil {
+				l.Close()
+			}
+		}
+	}
+	for i, lc := range listeners {
+		srv := &http.Server{
+			Handler:        handler,
+			ReadTimeout:    lc.ReadTimeout.Duration,
+			WriteTimeout:   lc.WriteTimeout.Duration,
+			IdleTimeout:    lc.IdleTimeout.Duration,
+			MaxHeaderBytes: lc.MaxHeaderBytes,
+		}
+		if lc.RedirectHTTPS {
+			srv.Handler = http.HandlerFunc(redirectHTTPS)
+		}
+		if lc.CertFile != "" || lc.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(lc.CertFile, lc.KeyFile)
+			if err != nil {
+				closeSockets()
+				return nil, fmt.Errorf("failed to load the certificate for %s: %v", lc.Address, err)
+			}
+			srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+
+		// Sockets passed in by systemd take the place of the configured
+		// addresses, in order.
+		if i < len(activated) {
+			sockets[i] = activated[i]
+		} else if sockets[i], err = listen(lc.Network, lc.Address); err != nil {
+			closeSockets()
+			return nil, err
+		}
+		srv.Addr = sockets[i].Addr().String()
+		servers[i] = srv
+	}
+
+	for i, srv := range servers {
+		go func(srv *http.Server, l net.Listener) {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ServeTLS(l, "", "")
+			} else {
+				err = srv.Serve(l)
+			}
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}(srv, sockets[i])
+	}
+	return servers, nil
+}
+
+// listen opens a socket on address. A unix socket left behind by a server
+// that didn't shut down cleanly is replaced.
+func listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if info, err := os.Stat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+	return l, nil
+}
+
+// redirectHTTPS sends the request to the same URL over HTTPS on the
+// default port.
+func redirectHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// activatedListeners returns the sockets passed in by systemd, if any. It
+// follows sd_listen_fds(3): the sockets are only meant for this process if
+// LISTEN_PID names it, and the variables are cleared so child processes
+// don't mistake them for theirs.
+func activatedListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to use the socket passed by systemd: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestServeHTTP(t *testing.T) {
+	// Unix socket paths are limited to about a hundred bytes, which
+	// t.TempDir can exceed.
+	dir, err := os.MkdirTemp("", "shorty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "shorty.sock")
+
+	cfg := &config.Config{}
+	cfg.Server.Listeners = []config.Listener{
+		{Address: "127.0.0.1:0"},
+		{Network: "unix", Address: sock},
+		{Address: "127.0.0.1:0", RedirectHTTPS: true},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	servers, err := serveHTTP(cfg, handler)
+	if err != nil {
+		t.Fatalf("serveHTTP returned an error: %v", err)
+	}
+	if len(servers) != 3 {
+		t.Fatalf("serveHTTP started %d servers, want 3", len(servers))
+	}
+	defer func() {
+		for _, srv := range servers {
+			srv.Shutdown(context.Background())
+		}
+	}()
+	// Addr holds where each socket ended up.
+	addrs := make([]string, len(servers))
+	for i, srv := range servers {
+		addrs[i] = srv.Addr
+	}
+
+	if resp, err := http.Get("http://" + addrs[0] + "/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("GET over TCP returned %v, %v", resp, err)
+	}
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	if resp, err := unixClient.Get("http://shorty/"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("GET over the unix socket returned %v, %v", resp, err)
+	}
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get("http://" + addrs[2] + "/abc123?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != "https://127.0.0.1/abc123?x=1" {
+		t.Errorf("redirecting listener returned %v to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestServeHTTPBadCertificate(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.Listeners = []config.Listener{
+		{Address: "127.0.0.1:0"},
+		{Address: "127.0.0.1:0", CertFile: "missing.pem", KeyFile: "missing.key"},
+	}
+	if _, err := serveHTTP(cfg, http.NotFoundHandler()); err == nil {
+		t.Error("serveHTTP with a missing certificate returned no error")
+	}
+}
+
+func TestActivatedListenersOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	if listeners, err := activatedListeners(); err != nil || listeners != nil {
+		t.Errorf("activatedListeners returned %v, %v want none", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Error("activatedListeners cleared LISTEN_FDS meant for another process")
+	}
+}
+
+// TestActivatedListeners runs the test binary again with sockets on fds 3
+// and 4, as systemd would start shorty.
+func TestActivatedListeners(t *testing.T) {
+	if os.Getenv("SHORTY_TEST_ACTIVATED") == "1" {
+		// systemd fills in LISTEN_PID after forking.
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listeners, err := activatedListeners()
+		if err != nil {
+			t.Fatalf("activatedListeners returned an error: %v", err)
+		}
+		var got []string
+		for _, l := range listeners {
+			got = append(got, l.Addr().String())
+			l.Close()
+		}
+		if want := os.Getenv("SHORTY_TEST_ADDRS"); strings.Join(got, ",") != want {
+			t.Errorf("activatedListeners returned sockets on %v, want %v", got, want)
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Error("activatedListeners left LISTEN_FDS set")
+		}
+		return
+	}
+
+	var addrs []string
+	var files []*os.File
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		addrs = append(addrs, l.Addr().String())
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivatedListeners$", "-test.v")
+	cmd.Env = append(os.Environ(), "SHORTY_TEST_ACTIVATED=1", "SHORTY_TEST_ADDRS="+strings.Join(addrs, ","), "LISTEN_FDS=2")
+	cmd.ExtraFiles = files
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "--- PASS") {
+		t.Errorf("activated run failed: %v\n%s", err, out)
+	}
+}
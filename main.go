@@ -1,463 +1,211 @@
 package main
 
 import (
-	"crypto/rand"
-	"database/sql"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"sort"
+	"os/signal"
 	"strings"
-	"text/template"
+	"syscall"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/server"
+	"github.com/donuts-are-good/shorty/store"
 )
 
-var db *sql.DB
-
-type Config struct {
-	Database struct {
-		Name string `json:"name"`
-	} `json:"database"`
-	Server struct {
-		Port string `json:"port"`
-	} `json:"server"`
-	Routes struct {
-		Index    string `json:"index"`
-		Create   string `json:"create"`
-		Redirect string `json:"redirect"`
-		Stats    string `json:"stats"`
-	} `json:"routes"`
-	ShortURL struct {
-		Length  int    `json:"length"`
-		Charset string `json:"charset"`
-	} `json:"shortURL"`
-}
-
-var cfg Config
+// shutdownTimeout bounds how long requests in flight and queued background
+// jobs get to finish at shutdown.
+const shutdownTimeout = 30 * time.Second
 
 func main() {
-
-	cfgFile, err := os.Open("shorty.config")
-	if err != nil {
-		log.Fatalf("Failed to open config file: %v", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "probe":
+			os.Exit(runProbe(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "db":
+			os.Exit(runDB(os.Args[2:]))
+		case "client":
+			os.Exit(runClient(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
 	}
-	defer cfgFile.Close()
 
-	bytes, err := io.ReadAll(cfgFile)
-	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
-	}
+	configPath := flag.String("config", "shorty.config", "path to the config file")
+	dev := flag.Bool("dev", false, "re-parse templates from disk on every request")
+	ephemeral := flag.Bool("ephemeral", false, "keep links in memory instead of the database, for demos")
+	flag.Parse()
 
-	err = json.Unmarshal(bytes, &cfg)
+	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to parse config file: %v", err)
+		log.Fatal(err)
 	}
-
-	db, err = sql.Open("sqlite3", cfg.Database.Name)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	if *dev {
+		cfg.Theme.Reload = true
 	}
-	defer db.Close()
-
-	_, err = os.Stat(cfg.Database.Name)
-	if os.IsNotExist(err) {
-		_, err = db.Exec(`CREATE TABLE url_mapping (
-			short_url TEXT PRIMARY KEY,
-			long_url TEXT NOT NULL,
-			visit_count INTEGER DEFAULT 0,
-			created_at TEXT DEFAULT CURRENT_TIMESTAMP
-		)`)
-		if err != nil {
-			log.Fatalf("Failed to create table: %v", err)
-		}
-		fmt.Println("Database initialized.")
-	} else {
-		// Check if the created_at column exists
-		var columnExists bool
-		err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('url_mapping') WHERE name='created_at'`).Scan(&columnExists)
-		if err != nil {
-			log.Fatalf("Failed to check for created_at column: %v", err)
-		}
-
-		// Add the created_at column if it doesn't exist
-		if !columnExists {
-			_, err = db.Exec(`ALTER TABLE url_mapping ADD COLUMN created_at TEXT`)
-			if err != nil {
-				log.Fatalf("Failed to add created_at column: %v", err)
-			}
-			// Update existing rows with the current timestamp
-			_, err = db.Exec(`UPDATE url_mapping SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL`)
-			if err != nil {
-				log.Fatalf("Failed to update existing rows with timestamp: %v", err)
-			}
-			fmt.Println("Added created_at column to existing database and updated existing rows.")
-		}
-
-		var count int
-		err = db.QueryRow(`SELECT COUNT(*) FROM url_mapping`).Scan(&count)
-		if err != nil {
-			log.Fatalf("Failed to query count: %v", err)
-		}
-		fmt.Printf("Database loaded with %d links.\n", count)
+	if *ephemeral {
+		cfg.Database.Backend = config.BackendMemory
 	}
 
-	http.HandleFunc("/", handleIndex)
-	http.HandleFunc("/create", handleCreate)
-	http.HandleFunc("/_/", func(w http.ResponseWriter, r *http.Request) {
-		path := strings.TrimPrefix(r.URL.Path, "/_/")
-		if strings.HasSuffix(path, "/stats") {
-			shortURL := strings.TrimSuffix(path, "/stats")
-			handleLinkStats(w, r, shortURL)
-		} else {
-			handleRedirect(w, r)
-		}
-	})
-	http.HandleFunc("/stats", handleStats)
-
-	log.Fatal(http.ListenAndServe(cfg.Server.Port, nil))
-}
-
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	log.Println("Handling index request")
-	if r.URL.Path != "/" {
-		log.Println("Redirecting to root from:", r.URL.Path)
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
+	if err := cfg.ValidateTenants(); err != nil {
+		log.Fatal(err)
 	}
-	http.ServeFile(w, r, "index.html")
-}
-
-func handleCreate(w http.ResponseWriter, r *http.Request) {
-	log.Println("Handling create request")
-	if r.Method != http.MethodPost {
-		log.Println("Not a POST request, redirecting to index")
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
+	if cfg.Backend() == config.BackendMemory {
+		fmt.Println("Keeping links in memory; they will be lost at exit.")
 	}
 
-	if r.Method != http.MethodPost {
-		http.Redirect(w, r, "/", http.StatusFound)
-		return
-	}
-
-	if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
-		http.Error(w, "Invalid Content-Type", http.StatusBadRequest)
-		return
-	}
-
-	longURL := r.FormValue("url")
-
-	_, err := url.ParseRequestURI(longURL)
+	st, err := openStore(cfg)
 	if err != nil {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
-		return
+		log.Fatal(err)
 	}
+	defer st.Close()
 
-	if len(longURL) > 2048 {
-		http.Error(w, "URL is too long", http.StatusBadRequest)
-		return
-	}
-
-	shortURL, err := createShortURL(longURL)
+	count, err := st.Count(context.Background())
 	if err != nil {
-		log.Printf("Error creating short URL: %v", err)
-		http.Error(w, "Failed to create short URL", http.StatusInternalServerError)
-		return
+		log.Fatalf("Failed to query count: %v", err)
 	}
-	log.Println("Created short URL:", shortURL)
+	fmt.Printf("Database loaded with %d links.\n", count)
 
-	data := struct {
-		ShortURL string
-	}{
-		ShortURL: shortURL,
-	}
-
-	tmpl, err := template.ParseFiles("short.html")
+	srv, err := server.New(cfg, st)
 	if err != nil {
-		http.Error(w, "Error loading template", http.StatusInternalServerError)
-		return
+		log.Fatal(err)
 	}
-
-	if err := tmpl.Execute(w, data); err != nil {
-		log.Printf("Failed to render template: %v", err)
-		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+	servers := []*server.Server{srv}
+	for _, t := range cfg.Tenants {
+		tenantSrv, tenantStore, err := openTenant(cfg, t)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer tenantStore.Close()
+		for _, host := range t.Hosts {
+			srv.AddTenant(host, tenantSrv)
+		}
+		servers = append(servers, tenantSrv)
 	}
-}
 
-func handleRedirect(w http.ResponseWriter, r *http.Request) {
-	log.Println("Handling redirect request")
-	shortURL := strings.TrimPrefix(r.URL.Path, "/_/")
-	log.Printf("Extracted short URL: '%s'", shortURL)
-
-	if shortURL == "" {
-		log.Println("Empty short URL, redirecting to root")
-		http.Redirect(w, r, "/?error="+url.QueryEscape("Empty short URL"), http.StatusFound)
-		return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for _, s := range servers {
+		if err := s.Start(ctx); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
 	}
 
-	longURL, err := getLongURL(shortURL)
+	httpServers, err := serveHTTP(cfg, srv)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No long URL found for short URL '%s'", shortURL)
-			http.Redirect(w, r, "/?error="+url.QueryEscape("Short URL not found"), http.StatusFound)
-		} else {
-			log.Printf("Error fetching long URL for short URL '%s': %v", shortURL, err)
-			http.Redirect(w, r, "/?error="+url.QueryEscape("Error fetching URL"), http.StatusFound)
-		}
-		return
+		log.Fatal(err)
 	}
 
-	if longURL == "" {
-		log.Printf("Empty long URL for short URL '%s'", shortURL)
-		http.Redirect(w, r, "/?error="+url.QueryEscape("Invalid short URL"), http.StatusFound)
-		return
+	// On SIGINT or SIGTERM, stop taking requests, let the ones in flight
+	// finish, then run the background jobs they queued.
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down the HTTP server: %v", err)
+		}
 	}
-
-	log.Printf("Found long URL for '%s': '%s'", shortURL, longURL)
-
-	// Update visit count directly in the database
-	result, err := db.Exec(`UPDATE url_mapping SET visit_count = visit_count + 1 WHERE short_url = ?`, shortURL)
-	if err != nil {
-		log.Printf("Error updating visit count for short URL '%s': %v", shortURL, err)
-	} else {
-		rowsAffected, _ := result.RowsAffected()
-		log.Printf("Updated visit count for '%s', rows affected: %d", shortURL, rowsAffected)
+	for _, s := range servers {
+		if err := s.Drain(shutdownCtx); err != nil {
+			log.Printf("Error draining background jobs: %v", err)
+		}
 	}
-
-	log.Printf("Redirecting to long URL: '%s'", longURL)
-	http.Redirect(w, r, longURL, http.StatusFound)
-	log.Printf("Redirect completed for short URL: '%s'", shortURL)
 }
 
-func handleStats(w http.ResponseWriter, r *http.Request) {
-	log.Println("Handling stats request")
-
-	stats, err := getStats()
-	if err != nil {
-		log.Printf("Error fetching stats: %v", err)
-		http.Error(w, "Error fetching stats", http.StatusInternalServerError)
-		return
+// openStore opens the configured database backend.
+func openStore(cfg *config.Config) (store.Store, error) {
+	var st store.Store
+	var err error
+	switch cfg.Backend() {
+	case config.BackendSQLite:
+		st, err = openSQLite(cfg)
+	case config.BackendBolt:
+		st, err = store.OpenBolt(cfg.Database.Name)
+	case config.BackendMemory:
+		st = store.NewMemory()
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", cfg.Backend())
 	}
-
-	tmpl, err := template.ParseFiles("stats.html")
 	if err != nil {
-		log.Printf("Error parsing stats template: %v", err)
-		http.Error(w, "Error loading template", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-
-	w.WriteHeader(http.StatusOK) // Explicitly set 200 OK status
-	if err := tmpl.Execute(w, stats); err != nil {
-		log.Printf("Error executing stats template: %v", err)
-		// Don't write an error response here, as headers are already sent
-	}
-}
-
-func createShortURL(longURL string) (string, error) {
-	// First, check if the long URL already exists
-	var existingShortURL string
-	err := db.QueryRow(`SELECT short_url FROM url_mapping WHERE long_url = ? ORDER BY rowid ASC LIMIT 1`, longURL).Scan(&existingShortURL)
-	if err == nil {
-		// If we found an existing short URL, return it
-		log.Printf("Found existing short URL '%s' for long URL '%s'", existingShortURL, longURL)
-		return existingShortURL, nil
-	} else if err != sql.ErrNoRows {
-		// If there was an error other than "no rows", return it
-		log.Printf("Error checking for existing long URL: %v", err)
-		return "", err
-	}
-
-	// If we didn't find an existing short URL, create a new one
-	for {
-		shortURL := randomString(cfg.ShortURL.Length)
-		log.Printf("Generated random short URL: '%s'", shortURL)
-		exists, err := shortURLExists(shortURL)
+	if cfg.Cache.BloomFilter {
+		filtered, err := store.NewBloomFiltered(context.Background(), st)
 		if err != nil {
-			log.Printf("Error checking if short URL exists: %v", err)
-			return "", err
-		}
-		if !exists {
-			_, err := db.Exec(`INSERT INTO url_mapping (short_url, long_url, created_at) VALUES (?, ?, datetime('now'))`, shortURL, longURL)
-			if err != nil {
-				log.Printf("Error inserting short URL '%s' into DB: %v", shortURL, err)
-				return "", err
-			}
-			log.Printf("Successfully saved short URL to DB: '%s' -> '%s'", shortURL, longURL)
-			return shortURL, nil
+			st.Close()
+			return nil, fmt.Errorf("failed to load short URLs into the Bloom filter: %v", err)
 		}
+		st = filtered
 	}
+	return st, nil
 }
 
-func getLongURL(shortURL string) (string, error) {
-	var longURL string
-	err := db.QueryRow(`SELECT long_url FROM url_mapping WHERE short_url = ?`, shortURL).Scan(&longURL)
+// openTenant opens the database of tenant t and builds its server.
+func openTenant(cfg *config.Config, t config.Tenant) (*server.Server, store.Store, error) {
+	tenantCfg := cfg.ForTenant(t)
+	st, err := openStore(tenantCfg)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No long URL found in DB for short URL '%s'", shortURL)
-		} else {
-			log.Printf("Error querying DB for short URL '%s': %v", shortURL, err)
-		}
-		return "", err
+		return nil, nil, fmt.Errorf("tenant %s: %v", t.Name, err)
 	}
-	log.Printf("Fetched long URL from DB for '%s': '%s'", shortURL, longURL)
-	return longURL, nil
-}
-
-func shortURLExists(shortURL string) (bool, error) {
-	var exists bool
-	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM url_mapping WHERE short_url=?)`, shortURL).Scan(&exists)
+	count, err := st.Count(context.Background())
 	if err != nil {
-		return false, err
+		st.Close()
+		return nil, nil, fmt.Errorf("tenant %s: failed to query count: %v", t.Name, err)
 	}
-	return exists, nil
-}
-
-func randomString(length int) string {
-	b := make([]byte, length)
-	_, _ = rand.Read(b)
-	for i := range b {
-		b[i] = cfg.ShortURL.Charset[b[i]%byte(len(cfg.ShortURL.Charset))]
+	fmt.Printf("Tenant %s loaded with %d links, serving %s.\n", t.Name, count, strings.Join(t.Hosts, ", "))
+	srv, err := server.New(tenantCfg, st)
+	if err != nil {
+		st.Close()
+		return nil, nil, fmt.Errorf("tenant %s: %v", t.Name, err)
 	}
-	return string(b)
+	return srv, st, nil
 }
 
-// Add these new types to support the stats
-type LinkStats struct {
-	ShortURL   string
-	LongURL    string
-	VisitCount int
-	CreatedAt  time.Time
-}
-
-func (l LinkStats) FormattedCreatedAt() string {
-	return l.CreatedAt.Format("2006-01-02 15:04:05")
-}
-
-type Stats struct {
-	TotalLinks       int
-	TotalClicks      int
-	ClicksToday      int
-	PopularLinks     []LinkStats
-	RecentLinks      []LinkStats
-	MostClickedLinks []LinkStats
-}
-
-// Add the getStats function
-func getStats() (Stats, error) {
-	var stats Stats
-	var err error
-
-	// Get total links
-	err = db.QueryRow("SELECT COUNT(*) FROM url_mapping").Scan(&stats.TotalLinks)
+// openSQLite opens and migrates the configured SQLite database, and its
+// read replica if there is one.
+func openSQLite(cfg *config.Config) (store.Store, error) {
+	db, err := store.OpenDB(cfg)
 	if err != nil {
-		return stats, err
+		return nil, err
 	}
 
-	// Get total clicks
-	err = db.QueryRow("SELECT COALESCE(SUM(visit_count), 0) FROM url_mapping").Scan(&stats.TotalClicks)
+	applied, err := store.Migrate(db)
 	if err != nil {
-		return stats, err
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
-
-	// Get clicks today
-	today := time.Now().Format("2006-01-02")
-	err = db.QueryRow("SELECT COALESCE(SUM(visit_count), 0) FROM url_mapping WHERE DATE(created_at) = ?", today).Scan(&stats.ClicksToday)
-	if err != nil {
-		return stats, err
+	if applied > 0 {
+		fmt.Printf("Applied %d database migration(s).\n", applied)
 	}
-
-	// Get all links, ordered by visit count
-	rows, err := db.Query("SELECT short_url, long_url, visit_count, created_at FROM url_mapping ORDER BY visit_count DESC")
-	if err != nil {
-		return stats, err
+	if _, err := store.EnsureSearchIndex(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up the search index: %v", err)
 	}
-	defer rows.Close()
 
-	var allLinks []LinkStats
-	for rows.Next() {
-		var link LinkStats
-		var createdAtStr string
-		err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr)
-		if err != nil {
-			return stats, err
-		}
-		link.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr)
-		if err != nil {
-			return stats, fmt.Errorf("error parsing created_at time: %v", err)
-		}
-		allLinks = append(allLinks, link)
-	}
-
-	// Populate stats
-	stats.PopularLinks = allLinks[:min(10, len(allLinks))]
-	stats.MostClickedLinks = allLinks[:min(10, len(allLinks))]
-
-	// Sort by creation time for recent links
-	sort.Slice(allLinks, func(i, j int) bool {
-		return allLinks[i].CreatedAt.After(allLinks[j].CreatedAt)
-	})
-	stats.RecentLinks = allLinks[:min(10, len(allLinks))]
-
-	return stats, nil
-}
-
-// Helper function for slicing
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-// Add this new function to handle individual link stats
-func handleLinkStats(w http.ResponseWriter, r *http.Request, shortURL string) {
-	log.Printf("Handling stats request for short URL: %s", shortURL)
-
-	linkStats, err := getLinkStats(shortURL)
-	if err != nil {
-		log.Printf("Error fetching stats for short URL %s: %v", shortURL, err)
-		http.Error(w, "Error fetching link stats", http.StatusInternalServerError)
-		return
-	}
-
-	tmpl, err := template.ParseFiles("link_stats.html")
+	var st store.Store
+	st, err = store.NewSQLite(db, cfg.QueryTimeout())
 	if err != nil {
-		log.Printf("Error parsing link stats template: %v", err)
-		http.Error(w, "Error loading template", http.StatusInternalServerError)
-		return
-	}
-
-	if err := tmpl.Execute(w, linkStats); err != nil {
-		log.Printf("Error executing link stats template: %v", err)
-		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare statements: %v", err)
 	}
-}
-
-// Add this new function to fetch stats for a specific link
-func getLinkStats(shortURL string) (LinkStats, error) {
-	var stats LinkStats
-	var createdAtStr string
-
-	err := db.QueryRow(`
-		SELECT short_url, long_url, visit_count, created_at 
-		FROM url_mapping 
-		WHERE short_url = ?
-	`, shortURL).Scan(&stats.ShortURL, &stats.LongURL, &stats.VisitCount, &createdAtStr)
-
+	replicaDB, err := store.OpenReplicaDB(cfg)
 	if err != nil {
-		return stats, err
+		return nil, err
 	}
-
-	stats.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr)
-	if err != nil {
-		return stats, fmt.Errorf("error parsing created_at time: %v", err)
+	if replicaDB != nil {
+		replica, err := store.NewSQLite(replicaDB, cfg.QueryTimeout())
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare statements on the read replica: %v", err)
+		}
+		st = store.NewReplicated(st, replica)
+		fmt.Println("Reading redirects and stats from the read replica.")
 	}
-
-	return stats, nil
+	return st, nil
 }
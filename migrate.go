@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// runMigrate implements `shorty migrate`, which applies pending migrations
+// (or, with -status, only reports them) and exits.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := fs.String("config", "shorty.config", "path to the config file")
+	status := fs.Bool("status", false, "print the schema version and pending migrations without applying them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if cfg.Backend() != config.BackendSQLite {
+		fmt.Fprintf(os.Stderr, "shorty migrate only works on SQLite, not the %s backend\n", cfg.Backend())
+		return 1
+	}
+	db, err := store.OpenDB(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	if *status {
+		current, err := store.SchemaVersion(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read schema version: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Schema version: %d\n", current)
+		pending, err := store.Pending(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read pending migrations: %v\n", err)
+			return 1
+		}
+		for _, m := range pending {
+			fmt.Printf("Pending: %d %s\n", m.Version, m.Description)
+		}
+		return 0
+	}
+
+	applied, err := store.Migrate(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Applied %d migration(s).\n", applied)
+	return 0
+}
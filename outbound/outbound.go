@@ -0,0 +1,205 @@
+// Package outbound is the HTTP client shorty uses for every request to a
+// user-supplied URL: health checks, reachability checks and anything else
+// that fetches link destinations. Those URLs come from strangers, so the
+// client refuses to connect to loopback, private, link-local and other
+// internal addresses, bounds every request by a timeout and caps how much
+// of a response it reads. Without that, anyone could use a short link to
+// probe the network shorty runs in.
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// Limits used when Config leaves them unset.
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxBodyBytes = 1 << 20
+	DefaultMaxRedirects = 5
+)
+
+const userAgent = "shorty-link-check"
+
+var (
+	// ErrBlockedAddress is returned when a URL resolves to an internal
+	// address.
+	ErrBlockedAddress = errors.New("destination address is not allowed")
+	// ErrTooManyRedirects is returned when a URL redirects more than
+	// Config.MaxRedirects times.
+	ErrTooManyRedirects = errors.New("destination redirects too many times")
+	// ErrTooLarge is returned by Get when the response body is bigger than
+	// Config.MaxBodyBytes.
+	ErrTooLarge = errors.New("response body is too large")
+)
+
+// Config sets the client's limits. Zero values fall back to the defaults.
+type Config struct {
+	Timeout      time.Duration
+	MaxBodyBytes int64
+	MaxRedirects int
+	// AllowPrivate lets requests reach internal addresses. Only switch it
+	// on when every user who can create links is trusted.
+	AllowPrivate bool
+}
+
+// Client makes requests to user-supplied URLs. It is safe for concurrent
+// use.
+type Client struct {
+	http         *http.Client
+	maxBodyBytes int64
+}
+
+// New returns a client with the limits in cfg. It ignores proxy settings
+// from the environment, so a proxy can't be used to reach what the client
+// refuses to.
+func New(cfg Config) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if cfg.MaxRedirects <= 0 {
+		cfg.MaxRedirects = DefaultMaxRedirects
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = denyInternal
+	}
+	return &Client{
+		http: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: cfg.Timeout,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= cfg.MaxRedirects {
+					return ErrTooManyRedirects
+				}
+				return nil
+			},
+		},
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+}
+
+// Status returns the status rawURL answers with, after redirects. HEAD is
+// tried first; servers that don't allow it get a GET, whose body is
+// discarded.
+func (c *Client) Status(ctx context.Context, rawURL string) (int, error) {
+	status, err := c.status(ctx, http.MethodHead, rawURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.status(ctx, http.MethodGet, rawURL)
+	}
+	return status, err
+}
+
+func (c *Client) status(ctx context.Context, method, rawURL string) (int, error) {
+	resp, err := c.do(ctx, method, rawURL)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// Response is a fetched page.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Get fetches rawURL. It returns ErrTooLarge rather than read more than
+// Config.MaxBodyBytes of the body.
+func (c *Client) Get(ctx context.Context, rawURL string) (*Response, error) {
+	resp, err := c.do(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > c.maxBodyBytes {
+		return nil, ErrTooLarge
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, nil
+}
+
+// Post sends body to rawURL as contentType, for webhooks, and returns the
+// status it answers with. The response body is discarded.
+func (c *Client) Post(ctx context.Context, rawURL, contentType string, body []byte) (int, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, c.maxBodyBytes))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (c *Client) do(ctx context.Context, method, rawURL string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", req.URL.Scheme)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// carrierNAT is the shared address space of RFC 6598, which net.IP doesn't
+// count as private.
+var carrierNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// blockedIP reports whether ip is loopback, private, link-local or
+// otherwise not a public unicast address.
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		carrierNAT.Contains(ip)
+}
+
+// denyInternal is a net.Dialer Control function. It runs after DNS
+// resolution, on the address actually being dialled, so a public hostname
+// that resolves to an internal address is caught too.
+func denyInternal(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || blockedIP(ip) {
+		return ErrBlockedAddress
+	}
+	return nil
+}
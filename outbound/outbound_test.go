@@ -0,0 +1,130 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBlockedIP(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.64.0.1":      true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"93.184.216.34":   false,
+		"2606:4700::1111": false,
+	}
+	for addr, want := range tests {
+		if got := blockedIP(net.ParseIP(addr)); got != want {
+			t.Errorf("blockedIP(%s) returned %v want %v", addr, got, want)
+		}
+	}
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/big":
+			w.Write([]byte(strings.Repeat("a", 2048)))
+		default:
+			w.Write([]byte("hello"))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestBlocksInternalAddresses(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(Config{})
+
+	if _, err := c.Status(context.Background(), ts.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Status of a loopback URL returned %v, want %v", err, ErrBlockedAddress)
+	}
+	if _, err := c.Get(context.Background(), ts.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Get of a loopback URL returned %v, want %v", err, ErrBlockedAddress)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(Config{AllowPrivate: true})
+
+	tests := map[string]int{
+		"/":        http.StatusOK,
+		"/gone":    http.StatusNotFound,
+		"/no-head": http.StatusOK,
+	}
+	for path, want := range tests {
+		if status, err := c.Status(context.Background(), ts.URL+path); err != nil || status != want {
+			t.Errorf("Status(%q) returned %v, %v want %v", path, status, err, want)
+		}
+	}
+
+	if _, err := c.Status(context.Background(), ts.URL+"/loop"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Status of a redirect loop returned %v, want %v", err, ErrTooManyRedirects)
+	}
+	if _, err := c.Status(context.Background(), "myapp://item/1"); err == nil {
+		t.Error("Expected an error for a non-HTTP URL, got nil")
+	}
+}
+
+func TestGet(t *testing.T) {
+	ts := newTestServer(t)
+	c := New(Config{AllowPrivate: true, MaxBodyBytes: 1024})
+
+	resp, err := c.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(resp.Body) != "hello" {
+		t.Errorf("Get returned %v %q", resp.StatusCode, resp.Body)
+	}
+
+	if _, err := c.Get(context.Background(), ts.URL+"/big"); err != ErrTooLarge {
+		t.Errorf("Get of an oversized body returned %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestPost(t *testing.T) {
+	var gotType, gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotType, gotBody = r.Header.Get("Content-Type"), string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(ts.Close)
+
+	if _, err := New(Config{}).Post(context.Background(), ts.URL, "application/json", nil); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("Post to a loopback URL returned %v, want %v", err, ErrBlockedAddress)
+	}
+
+	status, err := New(Config{AllowPrivate: true}).Post(context.Background(), ts.URL, "application/json", []byte(`{"ok":true}`))
+	if err != nil || status != http.StatusAccepted {
+		t.Errorf("Post returned %v, %v want %v", status, err, http.StatusAccepted)
+	}
+	if gotType != "application/json" || gotBody != `{"ok":true}` {
+		t.Errorf("Post sent %q %q", gotType, gotBody)
+	}
+}
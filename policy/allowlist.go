@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register("allowlist", newAllowlist)
+}
+
+// allowlist only lets links point at the configured hosts. An entry of
+// "*.example.com" matches any subdomain of example.com but not example.com
+// itself. Redirects are checked too, so links created before the list was
+// tightened stop working.
+type allowlist struct {
+	hosts []string
+}
+
+type allowlistConfig struct {
+	Hosts []string `json:"hosts"`
+}
+
+func newAllowlist(config json.RawMessage) (Policy, error) {
+	var c allowlistConfig
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &c); err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+	if len(c.Hosts) == 0 {
+		return nil, errors.New("hosts must list at least one host")
+	}
+	a := &allowlist{}
+	for _, host := range c.Hosts {
+		a.hosts = append(a.hosts, strings.ToLower(strings.TrimSpace(host)))
+	}
+	return a, nil
+}
+
+func (a *allowlist) allowed(longURL string) bool {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range a.hosts {
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *allowlist) CheckCreate(ctx context.Context, longURL, alias string) (string, error) {
+	if !a.allowed(longURL) {
+		return "", errors.New("destination host is not on the allowlist")
+	}
+	return longURL, nil
+}
+
+func (a *allowlist) CheckRedirect(r *http.Request, shortURL, longURL string) (string, error) {
+	if !a.allowed(longURL) {
+		return "", errors.New("destination host is not on the allowlist")
+	}
+	return longURL, nil
+}
@@ -0,0 +1,43 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowlist(t *testing.T) {
+	p, err := New("allowlist", json.RawMessage(`{"hosts": ["example.com", "*.corp.example"]}`))
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	allowed := []string{"https://example.com/page", "https://EXAMPLE.com", "https://wiki.corp.example/x"}
+	for _, longURL := range allowed {
+		if _, err := p.CheckCreate(context.Background(), longURL, ""); err != nil {
+			t.Errorf("CheckCreate(%q) returned an error: %v", longURL, err)
+		}
+	}
+
+	refused := []string{"https://evil.example/", "https://example.com.evil.example/", "https://corp.example/", "not a url"}
+	for _, longURL := range refused {
+		if _, err := p.CheckCreate(context.Background(), longURL, ""); err == nil {
+			t.Errorf("CheckCreate(%q) should have failed", longURL)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/_/abc123", nil)
+	if _, err := p.CheckRedirect(req, "abc123", "https://evil.example/"); err == nil {
+		t.Error("CheckRedirect should refuse hosts that are not on the allowlist")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("no-such-policy", nil); err == nil {
+		t.Error("Expected an error for an unknown policy, got nil")
+	}
+	if _, err := New("allowlist", nil); err == nil {
+		t.Error("Expected an error for an allowlist without hosts, got nil")
+	}
+}
@@ -0,0 +1,96 @@
+// Package policy is shorty's registry of compiled-in link policy plugins.
+// A policy can rewrite or veto links as they are created and as they are
+// visited. Policies are switched on and configured in the "plugins" section
+// of shorty.config:
+//
+//	"plugins": [
+//		{"name": "allowlist", "config": {"hosts": ["example.com", "*.example.org"]}}
+//	]
+//
+// Programs embedding shorty can add their own by calling Register from an
+// init function.
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Policy decides whether links may be created and followed.
+type Policy interface {
+	// CheckCreate runs before a link is stored. It returns the long URL to
+	// store, or an error explaining why the link is refused.
+	CheckCreate(ctx context.Context, longURL, alias string) (string, error)
+	// CheckRedirect runs before a visitor is redirected. It returns the
+	// destination to use, or an error explaining why the redirect is
+	// refused.
+	CheckRedirect(r *http.Request, shortURL, longURL string) (string, error)
+}
+
+// HoldError is what CheckCreate returns to let a link be created but hold
+// it for a moderator to review, rather than refuse it outright. It suits
+// checks that can be wrong, such as blocklists and reputation lookups.
+// While moderation is off, nobody would review the link, so it is refused.
+type HoldError struct {
+	Reason string
+}
+
+func (e *HoldError) Error() string {
+	return "held for review: " + e.Reason
+}
+
+// Hold returns a HoldError giving reason.
+func Hold(reason string) error {
+	return &HoldError{Reason: reason}
+}
+
+// Factory builds a policy from the "config" value of its plugins entry,
+// which is nil when the entry has none.
+type Factory func(config json.RawMessage) (Policy, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a policy available under name. It panics if name is
+// already taken, since that is a programming error.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("policy: Register called twice for " + name)
+	}
+	factories[name] = f
+}
+
+// New builds the policy registered under name.
+func New(name string, config json.RawMessage) (Policy, error) {
+	mu.RLock()
+	f, ok := factories[name]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown policy plugin '%s'", name)
+	}
+	p, err := f(config)
+	if err != nil {
+		return nil, fmt.Errorf("policy plugin '%s': %v", name, err)
+	}
+	return p, nil
+}
+
+// Names returns the registered policy names in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// runProbe implements `shorty probe`. It creates a throwaway link on a
+// running instance, follows it, checks that the click was counted and then
+// deletes the link again. It returns the process exit code.
+func runProbe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ContinueOnError)
+	baseURL := fs.String("url", "", "base URL of the shorty instance, e.g. https://short.example")
+	key := fs.String("key", os.Getenv("SHORTY_API_KEY"), "admin API key used to delete the probe link (default $SHORTY_API_KEY)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each HTTP request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *baseURL == "" || *key == "" {
+		fmt.Fprintln(os.Stderr, "usage: shorty probe --url https://short.example --key <admin API key>")
+		return 2
+	}
+
+	p := &prober{client: newAPIClient(*baseURL, *key, *timeout)}
+
+	start := time.Now()
+	if err := p.run(); err != nil {
+		fmt.Fprintf(os.Stderr, "probe failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("probe ok (%v)\n", time.Since(start).Round(time.Millisecond))
+	return 0
+}
+
+type prober struct {
+	client *apiClient
+}
+
+func (p *prober) run() (err error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	target := fmt.Sprintf("https://example.com/?shorty-probe=%x", nonce)
+
+	created, err := p.client.createLink(target)
+	if err != nil {
+		return fmt.Errorf("create: %v", err)
+	}
+	defer func() {
+		if delErr := p.client.deleteLink(created.ShortURL); delErr != nil && err == nil {
+			err = fmt.Errorf("delete: %v", delErr)
+		}
+	}()
+
+	resp, err := p.client.http.Get(p.client.shortLink(created.ShortURL))
+	if err != nil {
+		return fmt.Errorf("redirect: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return fmt.Errorf("redirect: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	if location := resp.Header.Get("Location"); location != target {
+		return fmt.Errorf("redirect: got location '%s', want '%s'", location, target)
+	}
+
+	after, err := p.client.getLink(created.ShortURL)
+	if err != nil {
+		return fmt.Errorf("stats: %v", err)
+	}
+	if after.VisitCount != created.VisitCount+1 {
+		return fmt.Errorf("stats: visit count is %d, want %d", after.VisitCount, created.VisitCount+1)
+	}
+
+	return nil
+}
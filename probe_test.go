@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/shortytest"
+)
+
+func TestProbe(t *testing.T) {
+	srv := shortytest.New(t, shortytest.Options{AdminKey: "secret"})
+
+	p := &prober{client: newAPIClient(srv.URL, "secret", 10*time.Second)}
+	if err := p.run(); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	p = &prober{client: newAPIClient(srv.URL, "wrong", 10*time.Second)}
+	if err := p.run(); err == nil {
+		t.Error("probe with a bad key should fail")
+	}
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Every account, a basicAuth user or an API key, can take out what shorty
+// keeps about it and have it erased, to answer data subject requests. The
+// export lists the links the account created, with their visits and
+// clicks per day, as JSON or CSV. Deleting the account deletes those links
+// for good or, in anonymize mode, keeps them redirecting but forgets who
+// made them. Either way the account's API key usage and team membership
+// go too. The login or key itself lives in the config, where an admin
+// removes it.
+
+const (
+	accountDelete    = "delete"
+	accountAnonymize = "anonymize"
+)
+
+// AccountExport is everything shorty keeps about one account.
+type AccountExport struct {
+	Account    string        `json:"account"`
+	ExportedAt time.Time     `json:"exportedAt"`
+	Team       string        `json:"team,omitempty"`
+	Links      []AccountLink `json:"links"`
+	// Usage is the account's API key usage, month by month, newest first.
+	Usage []store.Usage `json:"usage"`
+}
+
+// AccountLink is a link an account created, with its clicks per day,
+// oldest first. Days without clicks are left out, and there are none
+// without analytics.clickLog.
+type AccountLink struct {
+	store.LinkStats
+	Clicks []store.ClickCount `json:"clicks"`
+}
+
+// AccountDeletion reports what deleting an account did.
+type AccountDeletion struct {
+	Account string `json:"account"`
+	Mode    string `json:"mode"`
+	// Links is how many links were deleted or anonymized.
+	Links int `json:"links"`
+}
+
+// accountPage is the data for account.html.
+type accountPage struct {
+	Account    string
+	Team       string
+	VisitCount int
+	// Links are the account's links, newest first.
+	Links     []store.LinkStats
+	Message   string
+	CSRFToken string
+}
+
+type ownerKey struct{}
+
+// withOwner records in ctx the account a link is being created by.
+func withOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// ownerFrom returns the account recorded in ctx, if any.
+func ownerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
+// withAccount records in ctx the account r comes from and its team, so
+// the link it creates belongs to them.
+func (s *Server) withAccount(ctx context.Context, r *http.Request) context.Context {
+	if name, ok := s.accountName(r); ok {
+		ctx = withOwner(ctx, name)
+	}
+	return s.withMemberTeam(ctx, r)
+}
+
+// validAccountMode reports whether mode is a way to delete an account.
+func validAccountMode(mode string) bool {
+	return mode == accountDelete || mode == accountAnonymize
+}
+
+// accountExport gathers what shorty keeps about the account called name.
+func (s *Server) accountExport(ctx context.Context, name string) (AccountExport, error) {
+	export := AccountExport{Account: name, ExportedAt: time.Now().UTC(), Links: []AccountLink{}, Usage: []store.Usage{}}
+	team, ok, err := s.teamOf(ctx, name)
+	if err != nil {
+		return AccountExport{}, err
+	}
+	if ok {
+		export.Team = team.Name
+	}
+	links, err := s.store.OwnerLinks(ctx, name)
+	if err != nil {
+		return AccountExport{}, err
+	}
+	for _, link := range links {
+		clicks, err := s.store.ClickSeries(ctx, link.ShortURL, time.Time{}, store.Daily)
+		if err != nil {
+			return AccountExport{}, err
+		}
+		if clicks == nil {
+			clicks = []store.ClickCount{}
+		}
+		export.Links = append(export.Links, AccountLink{LinkStats: link, Clicks: clicks})
+	}
+	usage, err := s.store.Usage(ctx, name)
+	if err != nil {
+		return AccountExport{}, err
+	}
+	export.Usage = append(export.Usage, usage...)
+	return export, nil
+}
+
+// writeAccountExport sends export as a download in format, "json" or
+// "csv". The CSV has one row per link and day with clicks, and one row
+// with an empty day for a link without any.
+func writeAccountExport(w http.ResponseWriter, export AccountExport, format string) error {
+	w.Header().Set("Cache-Control", "no-store")
+	if format != "csv" {
+		w.Header().Set("Content-Disposition", `attachment; filename="shorty-account.json"`)
+		writeJSON(w, http.StatusOK, export)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="shorty-account.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"short_url", "long_url", "created_at", "visits", "day", "clicks"})
+	for _, link := range export.Links {
+		row := []string{link.ShortURL, link.LongURL, link.CreatedAt.Format(time.RFC3339), strconv.Itoa(link.VisitCount)}
+		if len(link.Clicks) == 0 {
+			cw.Write(append(row, "", ""))
+		}
+		for _, day := range link.Clicks {
+			cw.Write(append(row[:4:4], day.Time.Format("2006-01-02"), strconv.Itoa(day.Clicks)))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// deleteAccount deletes or anonymizes the links of the account called
+// name and forgets its usage and team membership.
+func (s *Server) deleteAccount(ctx context.Context, name, mode string) (AccountDeletion, error) {
+	links, err := s.store.OwnerLinks(ctx, name)
+	if err != nil {
+		return AccountDeletion{}, err
+	}
+	for _, link := range links {
+		if mode == accountDelete {
+			if _, err := s.deleteLink(ctx, link.ShortURL, true); err != nil {
+				return AccountDeletion{}, err
+			}
+			continue
+		}
+		if err := s.store.SetOwner(ctx, link.ShortURL, ""); err != nil {
+			return AccountDeletion{}, err
+		}
+		if err := s.store.SetAPIKey(ctx, link.ShortURL, ""); err != nil {
+			return AccountDeletion{}, err
+		}
+	}
+	if err := s.store.DeleteUsage(ctx, name); err != nil {
+		return AccountDeletion{}, err
+	}
+	if _, err := s.store.RemoveTeamMember(ctx, name); err != nil {
+		return AccountDeletion{}, err
+	}
+	logf(ctx, "Deleted account %s: %d link(s) %sd", name, len(links), mode)
+	return AccountDeletion{Account: name, Mode: mode, Links: len(links)}, nil
+}
+
+// handleAPIAccountExport serves GET /api/v1/account/export to the account
+// the request comes from.
+func (s *Server) handleAPIAccountExport(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API account export request")
+	name, ok := s.accountName(r)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Format must be json or csv")
+		return
+	}
+
+	export, err := s.accountExport(r.Context(), name)
+	if err != nil {
+		logf(r.Context(), "Error exporting account %s: %v", name, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error exporting account")
+		return
+	}
+	if err := writeAccountExport(w, export, format); err != nil {
+		logf(r.Context(), "Error writing export of account %s: %v", name, err)
+	}
+}
+
+// handleAPIAccount serves DELETE /api/v1/account, which deletes the
+// account the request comes from.
+func (s *Server) handleAPIAccount(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API account request")
+	name, ok := s.accountName(r)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = accountDelete
+	}
+	if !validAccountMode(mode) {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Mode must be delete or anonymize")
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, sourceAPI))
+	deletion, err := s.deleteAccount(ctx, name, mode)
+	if err != nil {
+		logf(ctx, "Error deleting account %s: %v", name, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error deleting account")
+		return
+	}
+	writeJSON(w, http.StatusOK, deletion)
+}
+
+// handleAccount shows the basicAuth user logged in their account, and
+// deletes it when they confirm.
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling account request")
+	user, ok := s.basicAuthUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty account"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showAccount(w, r, user)
+	case http.MethodPost:
+		s.closeAccount(w, r, user)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showAccount(w http.ResponseWriter, r *http.Request, user string) {
+	ctx := r.Context()
+	if format := r.URL.Query().Get("export"); format != "" {
+		if format != "json" && format != "csv" {
+			httpError(w, "Export must be json or csv", http.StatusBadRequest)
+			return
+		}
+		export, err := s.accountExport(ctx, user)
+		if err != nil {
+			logf(ctx, "Error exporting account %s: %v", user, err)
+			httpError(w, "Error exporting account", http.StatusInternalServerError)
+			return
+		}
+		if err := writeAccountExport(w, export, format); err != nil {
+			logf(ctx, "Error writing export of account %s: %v", user, err)
+		}
+		return
+	}
+
+	page := accountPage{Account: user, Message: r.URL.Query().Get("message")}
+	team, ok, err := s.teamOf(ctx, user)
+	if err != nil {
+		logf(ctx, "Error looking up the team of %s: %v", user, err)
+		httpError(w, "Error fetching account", http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		page.Team = team.Name
+	}
+	links, err := s.store.OwnerLinks(ctx, user)
+	if err != nil {
+		logf(ctx, "Error fetching the links of %s: %v", user, err)
+		httpError(w, "Error fetching account", http.StatusInternalServerError)
+		return
+	}
+	for i := len(links) - 1; i >= 0; i-- {
+		page.VisitCount += links[i].VisitCount
+		page.Links = append(page.Links, links[i])
+	}
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("account.html")
+	if err != nil {
+		logf(ctx, "Error loading account template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing account template: %v", err)
+	}
+}
+
+// closeAccount deletes the account of user once they have typed its name
+// to confirm, then sends the browser back to the account page.
+func (s *Server) closeAccount(w http.ResponseWriter, r *http.Request, user string) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected account request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	mode := r.PostFormValue("mode")
+	if !validAccountMode(mode) {
+		httpError(w, "Mode must be delete or anonymize", http.StatusBadRequest)
+		return
+	}
+	if r.PostFormValue("confirm") != user {
+		httpError(w, "Type your username to confirm", http.StatusBadRequest)
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, sourceWeb))
+	deletion, err := s.deleteAccount(ctx, user, mode)
+	if err != nil {
+		logf(ctx, "Error deleting account %s: %v", user, err)
+		httpError(w, "Error deleting account", http.StatusInternalServerError)
+		return
+	}
+	note := "Deleted " + strconv.Itoa(deletion.Links) + " link(s)"
+	if mode == accountAnonymize {
+		note = "Anonymized " + strconv.Itoa(deletion.Links) + " link(s)"
+	}
+	http.Redirect(w, r, "/account?"+url.Values{"message": {note}}.Encode(), http.StatusSeeOther)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestAccount(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.BasicAuth.Users = map[string]string{"alice": "hunter2"}
+	srv.cfg.API.Keys = []config.APIKey{{Name: "ci", Key: "ci-key"}}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		req := newCreateRequest(t, "url=https://example.com")
+		req.SetBasicAuth("alice", "hunter2")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	}
+	req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer ci-key")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	var created linkResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceLinks, err := st.OwnerLinks(ctx, "alice")
+	if err != nil || len(aliceLinks) != 2 || aliceLinks[0].ShortURL == aliceLinks[1].ShortURL {
+		t.Fatalf("OwnerLinks returned %+v, %v want two links of their own", aliceLinks, err)
+	}
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/_/"+aliceLinks[0].ShortURL, nil))
+
+	t.Run("Export", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/account?export=json", nil)
+		req.SetBasicAuth("alice", "hunter2")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if got := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+			t.Errorf("handler returned Content-Disposition %q", got)
+		}
+		var export AccountExport
+		if err := json.NewDecoder(rr.Body).Decode(&export); err != nil {
+			t.Fatal(err)
+		}
+		if export.Account != "alice" || len(export.Links) != 2 || export.Links[0].VisitCount != 1 || export.Links[0].Clicks == nil {
+			t.Errorf("export returned %+v", export)
+		}
+
+		req = httptest.NewRequest("GET", "/api/v1/account/export?format=csv", nil)
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+			t.Errorf("handler returned Content-Type %q want text/csv", got)
+		}
+		records, err := csv.NewReader(rr.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 || records[0][0] != "short_url" || records[1][0] != created.ShortURL {
+			t.Errorf("CSV export returned %v", records)
+		}
+
+		req = httptest.NewRequest("GET", "/api/v1/account/export?format=xml", nil)
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidForm)
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/account/export", nil))
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/account", nil)
+		req.SetBasicAuth("alice", "hunter2")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		for _, link := range aliceLinks {
+			if !strings.Contains(rr.Body.String(), link.ShortURL) {
+				t.Errorf("account page does not list %s", link.ShortURL)
+			}
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/account", nil))
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Anonymize", func(t *testing.T) {
+		if err := st.RecordUsage(ctx, "ci", "2024-01", 1, 0); err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("DELETE", "/api/v1/account?mode=anonymize", nil)
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		var deletion AccountDeletion
+		if err := json.NewDecoder(rr.Body).Decode(&deletion); err != nil {
+			t.Fatal(err)
+		}
+		if deletion != (AccountDeletion{Account: "ci", Mode: accountAnonymize, Links: 1}) {
+			t.Errorf("delete returned %+v", deletion)
+		}
+		if links, err := st.OwnerLinks(ctx, "ci"); err != nil || len(links) != 0 {
+			t.Errorf("OwnerLinks after anonymizing returned %+v, %v", links, err)
+		}
+		if opts, err := st.Options(ctx, created.ShortURL); err != nil || opts.APIKey != "" {
+			t.Errorf("Options after anonymizing returned %+v, %v", opts, err)
+		}
+		if usage, err := st.Usage(ctx, "ci"); err != nil || len(usage) != 0 {
+			t.Errorf("Usage after anonymizing returned %+v, %v", usage, err)
+		}
+		if _, err := st.LongURL(ctx, created.ShortURL); err != nil {
+			t.Errorf("anonymizing removed the link: %v", err)
+		}
+
+		req = httptest.NewRequest("DELETE", "/api/v1/account?mode=shred", nil)
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidForm)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		post := func(form url.Values) *httptest.ResponseRecorder {
+			token := strings.Repeat("ab", csrfTokenBytes)
+			form.Set(csrfFieldName, token)
+			req := httptest.NewRequest("POST", "/account", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+			req.SetBasicAuth("alice", "hunter2")
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			return rr
+		}
+
+		if rr := post(url.Values{"mode": {"delete"}, "confirm": {"bob"}}); rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		rr := post(url.Values{"mode": {"delete"}, "confirm": {"alice"}})
+		if status := rr.Code; status != http.StatusSeeOther {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+		}
+		for _, link := range aliceLinks {
+			if _, err := st.LongURL(ctx, link.ShortURL); err != store.ErrNotFound {
+				t.Errorf("LongURL of a deleted account's link returned %v want ErrNotFound", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// /admin is where admins manage links from a browser: every link with its
+// destination, tags and expiry, which can be edited in place, disabled or
+// deleted, one at a time or several at once. It logs in like the dashboard,
+// with the admin key as the basic auth password, and is off while no admin
+// key is set. Changes are posted back to /admin as forms, so they carry the
+// CSRF token, and land in the audit log like API changes.
+
+const (
+	adminPageSize = 50
+	maxTagLength  = 64
+	maxTags       = 20
+	// adminTimeFormat is how expiry times are read from and written to
+	// datetime-local inputs, in UTC.
+	adminTimeFormat = "2006-01-02T15:04"
+)
+
+// adminLink is one row of admin.html.
+type adminLink struct {
+	store.LinkStats
+	Active    bool
+	Flagged   bool
+	TakenDown bool
+	Expired   bool
+	ExpiresAt string
+	Tags      string
+}
+
+// adminPage is the data for admin.html.
+type adminPage struct {
+	Links     []adminLink
+	Total     int
+	Query     string
+	Tag       string
+	Page      int
+	PrevURL   string
+	NextURL   string
+	Message   string
+	Error     string
+	CSRFToken string
+	// Moderation links to the moderation queue.
+	Moderation bool
+}
+
+// adminListURL returns the admin page showing page of the links matching
+// query and tag. A note, if given, is shown on top under the name in
+// param, "message" or "error".
+func adminListURL(query, tag string, page int, param, note string) string {
+	v := url.Values{}
+	if query != "" {
+		v.Set("q", query)
+	}
+	if tag != "" {
+		v.Set("tag", tag)
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	if note != "" {
+		v.Set(param, note)
+	}
+	if len(v) == 0 {
+		return "/admin"
+	}
+	return "/admin?" + v.Encode()
+}
+
+// parseTags splits a comma-separated list of tags, dropping blanks.
+func parseTags(value string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tags may be at most %d characters", maxTagLength)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("a link may have at most %d tags", maxTags)
+	}
+	return tags, nil
+}
+
+// parseExpiry reads an expiry time from the admin form, in UTC. An empty
+// value means the link never expires.
+func parseExpiry(value string) (*time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(adminTimeFormat, value)
+	if err != nil {
+		return nil, errors.New("expiry must be a date and time like 2024-12-31T23:59")
+	}
+	return &t, nil
+}
+
+// linkEdit is a change to a link made from the admin page.
+type linkEdit struct {
+	LongURL   string
+	Tags      []string
+	ExpiresAt *time.Time
+}
+
+// editLink applies edit to shortURL and records it in the audit log. A new
+// long URL goes through the create hooks, like one given at creation.
+func (s *Server) editLink(ctx context.Context, shortURL string, edit linkEdit) error {
+	before := s.auditSnapshot(ctx, shortURL)
+	current, err := s.store.LongURL(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	var hold string
+	if edit.LongURL != current {
+		var longURL string
+		if longURL, hold, err = s.runCreateHooks(ctx, edit.LongURL, shortURL); err != nil {
+			return err
+		}
+		if err := s.store.SetLongURL(ctx, shortURL, longURL); err != nil {
+			return err
+		}
+	}
+	opts, err := s.store.Options(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	if !sameTime(opts.ExpiresAt, edit.ExpiresAt) {
+		opts.ExpiresAt = edit.ExpiresAt
+		if err := s.store.SetOptions(ctx, shortURL, opts); err != nil {
+			return err
+		}
+	}
+	if err := s.store.SetTags(ctx, shortURL, edit.Tags); err != nil {
+		return err
+	}
+	if before != nil {
+		s.audit(ctx, shortURL, store.AuditUpdate, before, s.auditSnapshot(ctx, shortURL))
+	}
+	if hold != "" {
+		return s.flagLink(ctx, shortURL, flagSourcePolicy, hold)
+	}
+	return nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// adminLinks returns the links matching query and tag, newest first. The
+// query is looked up with SearchLinks, so it matches tags too.
+func (s *Server) adminLinks(ctx context.Context, query, tag string) ([]store.LinkStats, error) {
+	var links []store.LinkStats
+	var err error
+	if query != "" {
+		links, err = s.store.SearchLinks(ctx, query, -1)
+	} else {
+		links, err = s.store.Links(ctx)
+		// Links come oldest first.
+		for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+			links[i], links[j] = links[j], links[i]
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tagged map[string]bool
+	if tag != "" {
+		codes, err := s.store.TaggedLinks(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+		tagged = make(map[string]bool, len(codes))
+		for _, code := range codes {
+			tagged[code] = true
+		}
+	}
+
+	var matched []store.LinkStats
+	for _, link := range links {
+		if tagged == nil || tagged[link.ShortURL] {
+			matched = append(matched, link)
+		}
+	}
+	return matched, nil
+}
+
+func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling admin request")
+	if s.cfg.API.AdminKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showAdmin(w, r)
+	case http.MethodPost:
+		s.updateAdmin(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showAdmin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	page := adminPage{
+		Query:   strings.TrimSpace(query.Get("q")),
+		Tag:     strings.TrimSpace(query.Get("tag")),
+		Message: query.Get("message"),
+		Error:   query.Get("error"),
+		Page:    1,
+
+		Moderation: s.cfg.Moderation.Enabled,
+	}
+	if n, err := strconv.Atoi(query.Get("page")); err == nil && n > 1 {
+		page.Page = n
+	}
+
+	links, err := s.adminLinks(ctx, page.Query, page.Tag)
+	if err != nil {
+		logf(ctx, "Error fetching links for the admin page: %v", err)
+		httpError(w, "Error fetching links", http.StatusInternalServerError)
+		return
+	}
+	page.Total = len(links)
+	start := (page.Page - 1) * adminPageSize
+	if start > len(links) {
+		start = len(links)
+	}
+	end := start + adminPageSize
+	if end > len(links) {
+		end = len(links)
+	}
+	if page.Page > 1 {
+		page.PrevURL = adminListURL(page.Query, page.Tag, page.Page-1, "", "")
+	}
+	if end < len(links) {
+		page.NextURL = adminListURL(page.Query, page.Tag, page.Page+1, "", "")
+	}
+
+	now := time.Now()
+	for _, link := range links[start:end] {
+		opts, err := s.store.Options(ctx, link.ShortURL)
+		if err != nil {
+			logf(ctx, "Error fetching options for short URL %s: %v", link.ShortURL, err)
+			httpError(w, "Error fetching links", http.StatusInternalServerError)
+			return
+		}
+		tags, err := s.store.Tags(ctx, link.ShortURL)
+		if err != nil {
+			logf(ctx, "Error fetching tags for short URL %s: %v", link.ShortURL, err)
+			httpError(w, "Error fetching links", http.StatusInternalServerError)
+			return
+		}
+		row := adminLink{LinkStats: link, Active: !opts.Disabled, Flagged: opts.Flagged, TakenDown: opts.TakenDown, Expired: opts.Expired(now), Tags: strings.Join(tags, ", ")}
+		if opts.ExpiresAt != nil {
+			row.ExpiresAt = opts.ExpiresAt.UTC().Format(adminTimeFormat)
+		}
+		page.Links = append(page.Links, row)
+	}
+
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("admin.html")
+	if err != nil {
+		logf(ctx, "Error loading admin template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing admin template: %v", err)
+	}
+}
+
+// updateAdmin applies a form posted from the admin page, then sends the
+// browser back to the list it came from.
+func (s *Server) updateAdmin(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected admin request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, "admin"))
+	query, tag := r.PostFormValue("q"), r.PostFormValue("tag")
+	page, _ := strconv.Atoi(r.PostFormValue("page"))
+	back := func(message string) {
+		http.Redirect(w, r, adminListURL(query, tag, page, "message", message), http.StatusSeeOther)
+	}
+	fail := func(message string) {
+		http.Redirect(w, r, adminListURL(query, tag, page, "error", message), http.StatusSeeOther)
+	}
+
+	action := r.PostFormValue("action")
+	codes := r.PostForm["code"]
+	if len(codes) == 0 {
+		fail("No links selected")
+		return
+	}
+
+	switch action {
+	case "save":
+		shortURL := codes[0]
+		tags, err := parseTags(r.PostFormValue("tags"))
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		expiresAt, err := parseExpiry(r.PostFormValue("expires_at"))
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		longURL := strings.TrimSpace(r.PostFormValue("long_url"))
+		if !validLongURL(longURL) {
+			fail("Invalid URL")
+			return
+		}
+		if len(longURL) > 2048 {
+			fail("URL is too long")
+			return
+		}
+		edit := linkEdit{LongURL: longURL, Tags: tags, ExpiresAt: expiresAt}
+		if err := s.editLink(ctx, shortURL, edit); err != nil {
+			switch {
+			case err == store.ErrNotFound:
+				fail("Short URL " + shortURL + " not found")
+			case isRejected(err):
+				logf(ctx, "Create hook rejected new long URL of %s: %v", shortURL, err)
+				fail(err.Error())
+			default:
+				logf(ctx, "Error saving short URL %s: %v", shortURL, err)
+				fail("Failed to save " + shortURL)
+			}
+			return
+		}
+		logf(ctx, "Saved short URL %s from the admin page", shortURL)
+		back("Saved " + shortURL)
+
+	case "enable", "disable":
+		active, done := action == "enable", "Disabled"
+		if active {
+			done = "Enabled"
+		}
+		n, err := s.eachAdminLink(ctx, codes, func(shortURL string) error {
+			return s.setActive(ctx, shortURL, active)
+		})
+		if err != nil {
+			fail(fmt.Sprintf("Failed to %s some links", action))
+			return
+		}
+		back(fmt.Sprintf("%s %d link(s)", done, n))
+
+	case "delete":
+		n, err := s.eachAdminLink(ctx, codes, func(shortURL string) error {
+			deleted, err := s.deleteLink(ctx, shortURL, false)
+			if err == nil && !deleted {
+				return store.ErrNotFound
+			}
+			return err
+		})
+		if err != nil {
+			fail("Failed to delete some links")
+			return
+		}
+		back(fmt.Sprintf("Deleted %d link(s)", n))
+
+	case "tag":
+		add, err := parseTags(r.PostFormValue("add_tags"))
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		if len(add) == 0 {
+			fail("No tags given")
+			return
+		}
+		n, err := s.eachAdminLink(ctx, codes, func(shortURL string) error {
+			return s.addTags(ctx, shortURL, add)
+		})
+		if err != nil {
+			fail("Failed to tag some links")
+			return
+		}
+		back(fmt.Sprintf("Tagged %d link(s)", n))
+
+	default:
+		fail("Unknown action")
+	}
+}
+
+// addTags adds tags to the ones shortURL already has and records the change
+// in the audit log.
+func (s *Server) addTags(ctx context.Context, shortURL string, tags []string) error {
+	current, err := s.store.Tags(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	before := s.auditSnapshot(ctx, shortURL)
+	if err := s.store.SetTags(ctx, shortURL, append(current, tags...)); err != nil {
+		return err
+	}
+	if before != nil {
+		s.audit(ctx, shortURL, store.AuditUpdate, before, s.auditSnapshot(ctx, shortURL))
+	}
+	return nil
+}
+
+// eachAdminLink calls fn with each of codes and returns how many it
+// changed. Links that are gone are skipped; any other error stops it.
+func (s *Server) eachAdminLink(ctx context.Context, codes []string, fn func(shortURL string) error) (int, error) {
+	var n int
+	for _, shortURL := range codes {
+		if err := fn(shortURL); err != nil {
+			if err == store.ErrNotFound {
+				continue
+			}
+			logf(ctx, "Error updating short URL %s from the admin page: %v", shortURL, err)
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// postAdmin posts form to /admin as a logged in admin with a valid CSRF
+// token.
+func postAdmin(srv *Server, form url.Values) *httptest.ResponseRecorder {
+	token := strings.Repeat("ab", csrfTokenBytes)
+	form.Set(csrfFieldName, token)
+	req := httptest.NewRequest("POST", "/admin", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	req.SetBasicAuth("admin", "secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleAdmin(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "ghi789"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Off Without Admin Key", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/admin", nil))
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Audit.Enabled = true
+
+	t.Run("Wrong Password", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin", nil)
+		req.SetBasicAuth("admin", "guess")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+		if rr.Header().Get("WWW-Authenticate") == "" {
+			t.Error("handler did not ask for basic auth")
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin?q=DEF", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, "https://example.com/def456") || strings.Contains(body, "https://example.com/abc123") {
+			t.Errorf("handler did not filter the links by the search: %s", body)
+		}
+		if !strings.Contains(body, `name="csrf_token"`) {
+			t.Error("handler returned a page without a CSRF token")
+		}
+	})
+
+	t.Run("Missing CSRF Token", func(t *testing.T) {
+		form := url.Values{"action": {"delete"}, "code": {"abc123"}}
+		req := httptest.NewRequest("POST", "/admin", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+		if exists, err := st.Exists(ctx, "abc123"); err != nil || !exists {
+			t.Errorf("Exists after a rejected delete returned %v, %v want true", exists, err)
+		}
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		rr := postAdmin(srv, url.Values{
+			"action":     {"save"},
+			"code":       {"abc123"},
+			"long_url":   {"https://example.org/new"},
+			"tags":       {"spring, launch, spring"},
+			"expires_at": {"2030-01-02T03:04"},
+		})
+		if status := rr.Code; status != http.StatusSeeOther {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+		}
+		if longURL, err := st.LongURL(ctx, "abc123"); err != nil || longURL != "https://example.org/new" {
+			t.Errorf("LongURL after saving returned %q, %v", longURL, err)
+		}
+		if tags, err := st.Tags(ctx, "abc123"); err != nil || strings.Join(tags, ",") != "launch,spring" {
+			t.Errorf("Tags after saving returned %v, %v want [launch spring]", tags, err)
+		}
+		want := time.Date(2030, 1, 2, 3, 4, 0, 0, time.UTC)
+		if opts, err := st.Options(ctx, "abc123"); err != nil || opts.ExpiresAt == nil || !opts.ExpiresAt.Equal(want) {
+			t.Errorf("Options after saving returned %+v, %v want expiry %v", opts, err, want)
+		}
+		if entries, err := st.AuditLog(ctx, "abc123", 10); err != nil || len(entries) != 1 || entries[0].Action != store.AuditUpdate {
+			t.Errorf("AuditLog after saving returned %+v, %v", entries, err)
+		}
+	})
+
+	t.Run("Save Invalid URL", func(t *testing.T) {
+		rr := postAdmin(srv, url.Values{"action": {"save"}, "code": {"def456"}, "long_url": {"not a url"}})
+		if location := rr.Header().Get("Location"); !strings.Contains(location, "error=") {
+			t.Errorf("handler redirected to %q, want an error", location)
+		}
+		if longURL, err := st.LongURL(ctx, "def456"); err != nil || longURL != "https://example.com/def456" {
+			t.Errorf("LongURL after a rejected save returned %q, %v", longURL, err)
+		}
+	})
+
+	t.Run("Filter By Tag", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin?tag=launch", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		body := rr.Body.String()
+		if !strings.Contains(body, "https://example.org/new") || strings.Contains(body, "https://example.com/def456") {
+			t.Errorf("handler did not filter the links by tag: %s", body)
+		}
+	})
+
+	t.Run("Bulk Disable", func(t *testing.T) {
+		rr := postAdmin(srv, url.Values{"action": {"disable"}, "code": {"abc123", "def456"}})
+		if status := rr.Code; status != http.StatusSeeOther {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+		}
+		for _, shortURL := range []string{"abc123", "def456"} {
+			if opts, err := st.Options(ctx, shortURL); err != nil || !opts.Disabled {
+				t.Errorf("Options of %s after a bulk disable returned %+v, %v", shortURL, opts, err)
+			}
+		}
+		if opts, err := st.Options(ctx, "ghi789"); err != nil || opts.Disabled {
+			t.Errorf("Options of an unselected link returned %+v, %v", opts, err)
+		}
+	})
+
+	t.Run("Bulk Tag", func(t *testing.T) {
+		postAdmin(srv, url.Values{"action": {"tag"}, "code": {"abc123", "ghi789"}, "add_tags": {"promo"}})
+		if links, err := st.TaggedLinks(ctx, "promo"); err != nil || strings.Join(links, ",") != "abc123,ghi789" {
+			t.Errorf("TaggedLinks after a bulk tag returned %v, %v", links, err)
+		}
+		if tags, err := st.Tags(ctx, "abc123"); err != nil || len(tags) != 3 {
+			t.Errorf("Tags after a bulk tag returned %v, %v want the old tags kept", tags, err)
+		}
+	})
+
+	t.Run("Bulk Delete", func(t *testing.T) {
+		postAdmin(srv, url.Values{"action": {"delete"}, "code": {"def456", "ghi789"}})
+		if count, err := st.Count(ctx); err != nil || count != 1 {
+			t.Errorf("Count after a bulk delete returned %v, %v want 1", count, err)
+		}
+	})
+}
+
+func TestRedirectExpired(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := st.SetOptions(ctx, "abc123", store.Options{ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/abc123", nil))
+	if status := rr.Code; status != http.StatusGone {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusGone)
+	}
+}
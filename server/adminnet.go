@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// With api.adminNetworks set, the management pages and APIs only answer
+// clients whose address falls in one of the listed ranges, on top of the
+// admin key or login they already ask for. That keeps the management plane
+// on an intranet while links stay public. The address checked is the one
+// the connection comes from, so behind a reverse proxy the proxy has to do
+// the restricting.
+
+// parseNetworks reads api.adminNetworks. Entries are CIDR ranges such as
+// "10.0.0.0/8", or single addresses.
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid api.adminNetworks entry %q: not an address or CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid api.adminNetworks entry %q: %v", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// fromAdminNetwork reports whether r comes from one of api.adminNetworks,
+// or whether the list is empty.
+func (s *Server) fromAdminNetwork(r *http.Request) bool {
+	if len(s.adminNetworks) == 0 {
+		return true
+	}
+	ip := net.ParseIP(remoteHost(r))
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.adminNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// management guards a management page with api.adminNetworks.
+func (s *Server) management(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.fromAdminNetwork(r) {
+			logf(r.Context(), "Refused %s from %s outside api.adminNetworks", r.URL.Path, remoteHost(r))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeAPIError(w, http.StatusForbidden, errCodeForbidden, "Forbidden")
+				return
+			}
+			httpError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := parseNetworks([]string{"10.0.0.0/8", " 192.0.2.7 ", "fd00::/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("parseNetworks returned an error: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.7/32", "fd00::/8", "2001:db8::1/128"}
+	if len(networks) != len(want) {
+		t.Fatalf("parseNetworks returned %v want %v", networks, want)
+	}
+	for i, network := range networks {
+		if network.String() != want[i] {
+			t.Errorf("parseNetworks returned %v at %d want %v", network, i, want[i])
+		}
+	}
+
+	for _, entry := range []string{"intranet", "10.0.0.0/33", ""} {
+		if _, err := parseNetworks([]string{entry}); err == nil {
+			t.Errorf("parseNetworks(%q) returned no error", entry)
+		}
+	}
+}
+
+func TestAdminNetworks(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.API.AdminNetworks = []string{"not a network"}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted an invalid api.adminNetworks entry")
+	}
+
+	srv, _ := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	var err error
+	if srv.adminNetworks, err = parseNetworks([]string{"10.0.0.0/8", "2001:db8::/32"}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Inside", func(t *testing.T) {
+		for _, remoteAddr := range []string{"10.1.2.3:4000", "[2001:db8::5]:4000"} {
+			if rr := request("/admin", remoteAddr); rr.Code != http.StatusOK {
+				t.Errorf("handler returned wrong status code for %s: got %v want %v", remoteAddr, rr.Code, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("Outside", func(t *testing.T) {
+		for _, path := range []string{"/admin", "/admin/takedowns", "/dashboard", "/stats"} {
+			if rr := request(path, "192.0.2.1:4000"); rr.Code != http.StatusForbidden {
+				t.Errorf("handler returned wrong status code for %s: got %v want %v", path, rr.Code, http.StatusForbidden)
+			}
+		}
+		checkAPIError(t, request("/api/v1/admin/audit", "192.0.2.1:4000"), http.StatusForbidden, errCodeForbidden)
+	})
+
+	t.Run("Public Routes", func(t *testing.T) {
+		if rr := request("/", "192.0.2.1:4000"); rr.Code != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Alert rules watch one link each and notify an email address, a webhook
+// or both when its visits cross a threshold: "tell me when this passes
+// 1,000 clicks" or "tell me when it goes a week without one". A checker
+// compares every rule with the link's visit count once a minute, so it
+// works with or without the click log. Webhooks are posted through the
+// outbound client, so a rule can't be used to reach internal addresses.
+
+const alertCheckInterval = time.Minute
+
+// evaluateAlert updates alert for a link that now has visits and reports
+// whether the alert should fire and whether it changed. Click rules fire
+// once. Idle rules fire after Threshold days without a visit and re-arm on
+// the next visit.
+func evaluateAlert(alert *store.Alert, visits int, now time.Time) (fire, changed bool) {
+	switch alert.Kind {
+	case store.AlertClicks:
+		return alert.FiredAt == nil && visits >= alert.Threshold, false
+	case store.AlertIdle:
+		if visits != alert.LastCount {
+			alert.LastCount = visits
+			alert.LastChange = now
+			alert.FiredAt = nil
+			return false, true
+		}
+		quiet := time.Duration(alert.Threshold) * 24 * time.Hour
+		return alert.FiredAt == nil && now.Sub(alert.LastChange) >= quiet, false
+	}
+	return false, false
+}
+
+// alertMessage describes why alert fired on link.
+func (s *Server) alertMessage(alert store.Alert, link store.LinkStats) string {
+	shortLink := strings.TrimSuffix(s.cfg.Server.PublicURL, "/") + "/_/" + link.ShortURL
+	if alert.Kind == store.AlertIdle {
+		return fmt.Sprintf("%s has had no clicks for %d day(s) (%s)", shortLink, alert.Threshold, link.LongURL)
+	}
+	return fmt.Sprintf("%s has %d clicks, past its alert at %d (%s)", shortLink, link.VisitCount, alert.Threshold, link.LongURL)
+}
+
+// alertPayload is the JSON posted to an alert's webhook. Text holds the
+// same message as the email, for chat webhooks that show it as is.
+type alertPayload struct {
+	Alert      store.Alert `json:"alert"`
+	LongURL    string      `json:"longURL"`
+	VisitCount int         `json:"visitCount"`
+	Text       string      `json:"text"`
+}
+
+// deliverAlert sends alert to its email address and webhook. It succeeds
+// when at least one of them got it, so a broken webhook doesn't make the
+// email go out again every minute.
+func (s *Server) deliverAlert(ctx context.Context, alert store.Alert, link store.LinkStats) error {
+	message := s.alertMessage(alert, link)
+	var errs []error
+	delivered := false
+
+	if alert.Email != "" {
+		if err := s.sendEmail([]string{alert.Email}, "shorty alert: "+message, message+"\n"); err != nil {
+			errs = append(errs, fmt.Errorf("error emailing %s: %v", alert.Email, err))
+		} else {
+			delivered = true
+		}
+	}
+	if alert.Webhook != "" {
+		body, err := json.Marshal(alertPayload{Alert: alert, LongURL: link.LongURL, VisitCount: link.VisitCount, Text: message})
+		if err != nil {
+			return err
+		}
+		status, err := s.outbound.Post(ctx, alert.Webhook, "application/json", body)
+		if err == nil && status/100 != 2 {
+			err = fmt.Errorf("webhook returned %d", status)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error posting to webhook: %v", err))
+		} else {
+			delivered = true
+		}
+	}
+
+	if !delivered {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Printf("Error delivering alert %d on short URL '%s': %v", alert.ID, alert.ShortURL, err)
+	}
+	return nil
+}
+
+// checkAlerts evaluates every alert rule against its link's visit count,
+// delivers the ones that are due on the job pool and saves what changed.
+func (s *Server) checkAlerts(ctx context.Context, now time.Time) error {
+	alerts, err := s.store.Alerts(ctx, "")
+	if err != nil {
+		return err
+	}
+	links := make(map[string]store.LinkStats)
+	for _, alert := range alerts {
+		link, ok := links[alert.ShortURL]
+		if !ok {
+			link, err = s.store.Link(ctx, alert.ShortURL)
+			if err == store.ErrNotFound {
+				// Trashed links keep their rules but aren't watched.
+				continue
+			}
+			if err != nil {
+				log.Printf("Error fetching short URL '%s' for its alerts: %v", alert.ShortURL, err)
+				continue
+			}
+			links[alert.ShortURL] = link
+		}
+
+		fire, changed := evaluateAlert(&alert, link.VisitCount, now)
+		if fire {
+			// The check waits for the delivery, so the next one can't
+			// fire the alert again while it is being retried.
+			err := s.jobs.run(ctx, &job{
+				name:     "alert delivery",
+				attempts: 3,
+				run: func(ctx context.Context) error {
+					return s.deliverAlert(ctx, alert, link)
+				},
+			})
+			if err != nil {
+				log.Printf("Error delivering alert %d on short URL '%s': %v", alert.ID, alert.ShortURL, err)
+			} else {
+				log.Printf("Alert %d on short URL '%s' fired", alert.ID, alert.ShortURL)
+				firedAt := now.UTC()
+				alert.FiredAt = &firedAt
+				changed = true
+			}
+		}
+		if changed {
+			if err := s.store.UpdateAlert(ctx, alert); err != nil {
+				log.Printf("Error saving alert %d on short URL '%s': %v", alert.ID, alert.ShortURL, err)
+			}
+		}
+	}
+	return nil
+}
+
+// startAlerts runs checkAlerts every minute until ctx is cancelled.
+func (s *Server) startAlerts(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(alertCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if !s.lead(ctx, "alerts", alertCheckInterval) {
+					continue
+				}
+				if err := s.checkAlerts(ctx, now); err != nil {
+					log.Printf("Error checking alerts: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+type createAlertRequest struct {
+	Kind      string `json:"kind"`
+	Threshold int    `json:"threshold"`
+	Email     string `json:"email"`
+	Webhook   string `json:"webhook"`
+}
+
+// validateAlert checks a new alert rule and returns it cleaned up.
+func validateAlert(req createAlertRequest) (createAlertRequest, error) {
+	if req.Kind != store.AlertClicks && req.Kind != store.AlertIdle {
+		return req, fmt.Errorf("kind must be %q or %q", store.AlertClicks, store.AlertIdle)
+	}
+	if req.Threshold <= 0 {
+		return req, errors.New("threshold must be a positive number")
+	}
+	if req.Email == "" && req.Webhook == "" {
+		return req, errors.New("an alert needs an email address, a webhook or both")
+	}
+	if req.Email != "" {
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			return req, errors.New("invalid email address")
+		}
+		req.Email = addr.Address
+	}
+	if req.Webhook != "" {
+		u, err := url.Parse(req.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return req, errors.New("webhook must be an http or https URL")
+		}
+	}
+	return req, nil
+}
+
+// handleAPIAlerts serves /api/v1/links/{shortURL}/alerts: GET lists the
+// link's alert rules and POST adds one. DELETE on
+// /api/v1/links/{shortURL}/alerts/{id} removes a rule.
+func (s *Server) handleAPIAlerts(w http.ResponseWriter, r *http.Request, shortURL, id string) {
+	logf(r.Context(), "Handling alerts request for short URL: '%s'", shortURL)
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	if id != "" {
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", http.MethodDelete)
+			writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		alertID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Alert not found")
+			return
+		}
+		deleted, err := s.store.DeleteAlert(r.Context(), shortURL, alertID)
+		if err != nil {
+			logf(r.Context(), "Error deleting alert %d on short URL '%s': %v", alertID, shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error deleting alert")
+			return
+		}
+		if !deleted {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Alert not found")
+			return
+		}
+		logf(r.Context(), "Deleted alert %d on short URL '%s' via API", alertID, shortURL)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if _, err := s.store.Link(r.Context(), shortURL); err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		} else if err != nil {
+			logf(r.Context(), "Error fetching short URL '%s': %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading alerts")
+			return
+		}
+		alerts, err := s.store.Alerts(r.Context(), shortURL)
+		if err != nil {
+			logf(r.Context(), "Error reading alerts on short URL '%s': %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading alerts")
+			return
+		}
+		if alerts == nil {
+			alerts = []store.Alert{}
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Alerts []store.Alert `json:"alerts"`
+		}{alerts})
+
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+		var req createAlertRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body")
+			return
+		}
+		req, err := validateAlert(req)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidAlert, err.Error())
+			return
+		}
+		if req.Email != "" && !s.emailConfigured() {
+			writeAPIError(w, http.StatusServiceUnavailable, errCodeEmailNotConfigured, "Email is not configured")
+			return
+		}
+
+		link, err := s.store.Link(r.Context(), shortURL)
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		} else if err != nil {
+			logf(r.Context(), "Error fetching short URL '%s': %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error creating alert")
+			return
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		alert := store.Alert{
+			ShortURL:   shortURL,
+			Kind:       req.Kind,
+			Threshold:  req.Threshold,
+			Email:      req.Email,
+			Webhook:    req.Webhook,
+			LastCount:  link.VisitCount,
+			LastChange: now,
+			CreatedAt:  now,
+		}
+		if alert.ID, err = s.store.CreateAlert(r.Context(), alert); err != nil {
+			logf(r.Context(), "Error creating alert on short URL '%s': %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error creating alert")
+			return
+		}
+		logf(r.Context(), "Created %s alert %d on short URL '%s' via API", alert.Kind, alert.ID, shortURL)
+		writeJSON(w, http.StatusCreated, alert)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/outbound"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestEvaluateAlert(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	fired := now.Add(-time.Hour)
+	tests := []struct {
+		name        string
+		alert       store.Alert
+		visits      int
+		fire        bool
+		changed     bool
+		wantFiredAt bool
+	}{
+		{"Clicks Below Threshold", store.Alert{Kind: store.AlertClicks, Threshold: 1000}, 999, false, false, false},
+		{"Clicks Reached", store.Alert{Kind: store.AlertClicks, Threshold: 1000}, 1000, true, false, false},
+		{"Clicks Already Fired", store.Alert{Kind: store.AlertClicks, Threshold: 1000, FiredAt: &fired}, 1500, false, false, true},
+		{"Idle Visited", store.Alert{Kind: store.AlertIdle, Threshold: 7, LastCount: 5, LastChange: now.AddDate(0, 0, -8), FiredAt: &fired}, 6, false, true, false},
+		{"Idle Too Soon", store.Alert{Kind: store.AlertIdle, Threshold: 7, LastCount: 5, LastChange: now.AddDate(0, 0, -6)}, 5, false, false, false},
+		{"Idle Quiet", store.Alert{Kind: store.AlertIdle, Threshold: 7, LastCount: 5, LastChange: now.AddDate(0, 0, -7)}, 5, true, false, false},
+		{"Idle Already Fired", store.Alert{Kind: store.AlertIdle, Threshold: 7, LastCount: 5, LastChange: now.AddDate(0, 0, -30), FiredAt: &fired}, 5, false, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := tt.alert
+			fire, changed := evaluateAlert(&alert, tt.visits, now)
+			if fire != tt.fire || changed != tt.changed {
+				t.Errorf("evaluateAlert returned %v, %v want %v, %v", fire, changed, tt.fire, tt.changed)
+			}
+			if (alert.FiredAt != nil) != tt.wantFiredAt {
+				t.Errorf("evaluateAlert left FiredAt %v", alert.FiredAt)
+			}
+		})
+	}
+}
+
+func TestValidateAlert(t *testing.T) {
+	valid := []createAlertRequest{
+		{Kind: store.AlertClicks, Threshold: 1000, Email: "Ops <ops@example.com>"},
+		{Kind: store.AlertIdle, Threshold: 7, Webhook: "https://hooks.example.com/x"},
+	}
+	for _, req := range valid {
+		if _, err := validateAlert(req); err != nil {
+			t.Errorf("validateAlert(%+v) returned an error: %v", req, err)
+		}
+	}
+	if req, _ := validateAlert(valid[0]); req.Email != "ops@example.com" {
+		t.Errorf("validateAlert kept the email address as %q", req.Email)
+	}
+
+	invalid := []createAlertRequest{
+		{Kind: "sometimes", Threshold: 1, Email: "ops@example.com"},
+		{Kind: store.AlertClicks, Threshold: 0, Email: "ops@example.com"},
+		{Kind: store.AlertClicks, Threshold: 10},
+		{Kind: store.AlertClicks, Threshold: 10, Email: "not an address"},
+		{Kind: store.AlertClicks, Threshold: 10, Webhook: "ftp://example.com"},
+	}
+	for _, req := range invalid {
+		if _, err := validateAlert(req); err == nil {
+			t.Errorf("validateAlert(%+v) returned no error", req)
+		}
+	}
+}
+
+func expectLink(mock sqlmock.Sqlmock, shortURL string, visits int) {
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+		WithArgs(shortURL).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow(shortURL, "https://example.com", visits, time.Now().Format("2006-01-02 15:04:05")))
+}
+
+func TestCheckAlerts(t *testing.T) {
+	srv, mock := newMockServer(t)
+	startJobs(t, srv)
+	srv.outbound = outbound.New(outbound.Config{AllowPrivate: true})
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+	srv.cfg.Email.Host = "smtp.example.com"
+	srv.cfg.Email.From = "shorty@example.com"
+
+	var emailed []string
+	sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		emailed = append(emailed, to...)
+		return nil
+	}
+	t.Cleanup(func() { sendMail = smtp.SendMail })
+
+	payloads := make(chan alertPayload, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload alertPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		payloads <- payload
+	}))
+	defer webhook.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	old := now.AddDate(0, 0, -10).Format("2006-01-02 15:04:05")
+	mock.ExpectQuery("FROM alerts").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "short_url", "kind", "threshold", "email", "webhook", "last_count", "last_change", "fired_at", "created_at"}).
+			AddRow(1, "popular", store.AlertClicks, 100, "ops@example.com", "", 0, old, "", old).
+			AddRow(2, "quiet1", store.AlertIdle, 7, "", webhook.URL, 3, old, "", old).
+			AddRow(3, "gone12", store.AlertClicks, 1, "ops@example.com", "", 0, old, "", old))
+	expectLink(mock, "popular", 150)
+	mock.ExpectExec("UPDATE alerts SET last_count").
+		WithArgs(0, old, now.Format("2006-01-02 15:04:05"), int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectLink(mock, "quiet1", 3)
+	mock.ExpectExec("UPDATE alerts SET last_count").
+		WithArgs(3, old, now.Format("2006-01-02 15:04:05"), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+		WithArgs("gone12").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}))
+
+	if err := srv.checkAlerts(context.Background(), now); err != nil {
+		t.Fatalf("checkAlerts returned an error: %v", err)
+	}
+	if len(emailed) != 1 || emailed[0] != "ops@example.com" {
+		t.Errorf("checkAlerts emailed %v", emailed)
+	}
+	select {
+	case payload := <-payloads:
+		if payload.Alert.ID != 2 || payload.VisitCount != 3 || !strings.Contains(payload.Text, "https://sho.rt/_/quiet1 has had no clicks for 7 day(s)") {
+			t.Errorf("Unexpected webhook payload: %+v", payload)
+		}
+	default:
+		t.Error("checkAlerts didn't post to the webhook")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandleAPIAlerts(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.API.AdminKey = "secret"
+
+	alerts := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/links/abc123/alerts", nil))
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		expectLink(mock, "abc123", 42)
+		mock.ExpectExec("INSERT INTO alerts").
+			WithArgs("abc123", store.AlertIdle, 7, "", "https://hooks.example.com/x", 42, sqlmock.AnyArg(), "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(5, 1))
+
+		rr := alerts("POST", "/api/v1/links/abc123/alerts", `{"kind":"idle","threshold":7,"webhook":"https://hooks.example.com/x"}`)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		var alert store.Alert
+		if err := json.NewDecoder(rr.Body).Decode(&alert); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if alert.ID != 5 || alert.Kind != store.AlertIdle || alert.Threshold != 7 {
+			t.Errorf("handler returned unexpected alert: %+v", alert)
+		}
+	})
+
+	t.Run("Invalid Rule", func(t *testing.T) {
+		checkAPIError(t, alerts("POST", "/api/v1/links/abc123/alerts", `{"kind":"clicks","threshold":-1,"email":"ops@example.com"}`), http.StatusBadRequest, errCodeInvalidAlert)
+	})
+
+	t.Run("Email Not Configured", func(t *testing.T) {
+		checkAPIError(t, alerts("POST", "/api/v1/links/abc123/alerts", `{"kind":"clicks","threshold":1000,"email":"ops@example.com"}`), http.StatusServiceUnavailable, errCodeEmailNotConfigured)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		expectLink(mock, "abc123", 42)
+		now := time.Now().UTC().Format("2006-01-02 15:04:05")
+		mock.ExpectQuery("FROM alerts").
+			WithArgs("abc123", "abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "short_url", "kind", "threshold", "email", "webhook", "last_count", "last_change", "fired_at", "created_at"}).
+				AddRow(5, "abc123", store.AlertIdle, 7, "", "https://hooks.example.com/x", 42, now, "", now))
+
+		rr := alerts("GET", "/api/v1/links/abc123/alerts", "")
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var resp struct {
+			Alerts []store.Alert `json:"alerts"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(resp.Alerts) != 1 || resp.Alerts[0].ID != 5 || resp.Alerts[0].FiredAt != nil {
+			t.Errorf("handler returned unexpected alerts: %+v", resp.Alerts)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM alerts").
+			WithArgs("abc123", int64(5)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		if status := alerts("DELETE", "/api/v1/links/abc123/alerts/5", "").Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+	})
+
+	t.Run("Delete Missing", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM alerts").
+			WithArgs("abc123", int64(6)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		checkAPIError(t, alerts("DELETE", "/api/v1/links/abc123/alerts/6", ""), http.StatusNotFound, errCodeNotFound)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
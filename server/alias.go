@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Custom aliases let a user pick the short code instead of getting a random
+// one. They share the url_mapping keyspace with generated codes.
+
+const maxAliasLength = 64
+
+var (
+	errAliasInvalid = errors.New("aliases may only contain letters, digits, '-' and '_', up to 64 characters")
+	errAliasTaken   = errors.New("alias is already taken")
+)
+
+// validateAlias checks that alias is safe to use as a path segment.
+func validateAlias(alias string) error {
+	if alias == "" || len(alias) > maxAliasLength {
+		return errAliasInvalid
+	}
+	for _, c := range alias {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return errAliasInvalid
+		}
+	}
+	return nil
+}
+
+// createAlias stores longURL under the requested alias. Unlike
+// createShortURL it never reuses an existing mapping for the same long URL,
+// since the caller asked for this particular name.
+func (s *Server) createAlias(ctx context.Context, alias, longURL string) error {
+	if err := validateAlias(alias); err != nil {
+		return err
+	}
+
+	exists, err := s.store.Exists(ctx, alias)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errAliasTaken
+	}
+
+	err = s.store.Create(ctx, alias, longURL)
+	if err == store.ErrExists {
+		// Lost a race with another request for the same alias.
+		return errAliasTaken
+	}
+	if err != nil {
+		return err
+	}
+	logf(ctx, "Saved alias to DB: '%s' -> '%s'", alias, longURL)
+	return nil
+}
+
+// shortenURL creates a mapping for longURL under alias when one is given,
+// or under a generated code otherwise, and returns the short URL. Create
+// hooks run first and may rewrite or reject the link.
+func (s *Server) shortenURL(ctx context.Context, longURL, alias string) (string, error) {
+	return s.createLink(ctx, longURL, alias, linkOptions{})
+}
+
+// linkOptions are the extras a link can be created with through the JSON
+// API.
+type linkOptions struct {
+	Targets  store.Targets
+	Variants []store.Variant
+	Card     store.Card
+	Options  store.Options
+}
+
+func (o linkOptions) isZero() bool {
+	return o.Targets.IsZero() && len(o.Variants) == 0 && o.Card.IsZero() && o.Options.IsZero()
+}
+
+// createLink is shortenURL with per-platform targets, A/B variants, a
+// preview card and per-link options. A link with any of them always gets a code of its own
+// rather than reusing an existing mapping for the same long URL, so they
+// never change someone else's link.
+func (s *Server) createLink(ctx context.Context, longURL, alias string, opts linkOptions) (string, error) {
+	if s.cfg.Links.StripTracking {
+		longURL = stripTracking(longURL)
+		opts.Targets.IOS = stripTracking(opts.Targets.IOS)
+		opts.Targets.Android = stripTracking(opts.Targets.Android)
+		for i := range opts.Variants {
+			opts.Variants[i].URL = stripTracking(opts.Variants[i].URL)
+		}
+	}
+
+	// Every destination goes through the hooks, and a hold on any of
+	// them holds the link.
+	var holds []string
+	check := func(u string) (string, error) {
+		u, hold, err := s.runCreateHooks(ctx, u, alias)
+		if hold != "" {
+			holds = append(holds, hold)
+		}
+		return u, err
+	}
+
+	longURL, err := check(longURL)
+	if err != nil {
+		return "", err
+	}
+	targets := opts.Targets
+	if targets.IOS != "" {
+		if targets.IOS, err = check(targets.IOS); err != nil {
+			return "", err
+		}
+	}
+	if targets.Android != "" {
+		if targets.Android, err = check(targets.Android); err != nil {
+			return "", err
+		}
+	}
+	if opts.Options.FallbackURL != "" {
+		if opts.Options.FallbackURL, err = check(opts.Options.FallbackURL); err != nil {
+			return "", err
+		}
+	}
+	variants := make([]store.Variant, len(opts.Variants))
+	for i, v := range opts.Variants {
+		if v.URL, err = check(v.URL); err != nil {
+			return "", err
+		}
+		variants[i] = v
+	}
+
+	var shortURL string
+	created := true
+	switch {
+	case alias != "":
+		err = s.createAlias(ctx, alias, longURL)
+		shortURL = alias
+	// A held link gets a code of its own, so holding it leaves links
+	// made earlier alone, and so does a link made with an API key, by an
+	// account or for a team, which belongs to them.
+	case opts.isZero() && len(holds) == 0 && !attributed(ctx):
+		shortURL, created, err = s.createShortURL(ctx, longURL)
+	default:
+		shortURL, err = s.generateShortURL(ctx, longURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return shortURL, nil
+	}
+
+	// links.noAnalytics is only a default: a click limit needs the count.
+	if s.cfg.Links.NoAnalytics && opts.Options.MaxClicks == 0 {
+		opts.Options.NoAnalytics = true
+	}
+
+	if !targets.IsZero() {
+		if err := s.store.SetTargets(ctx, shortURL, targets); err != nil {
+			return "", err
+		}
+	}
+	if len(variants) > 0 {
+		if err := s.store.SetVariants(ctx, shortURL, variants); err != nil {
+			return "", err
+		}
+	}
+	if !opts.Card.IsZero() {
+		if err := s.store.SetCard(ctx, shortURL, opts.Card); err != nil {
+			return "", err
+		}
+	}
+	if !opts.Options.IsZero() {
+		if err := s.store.SetOptions(ctx, shortURL, opts.Options); err != nil {
+			return "", err
+		}
+	}
+	if key := apiKeyFrom(ctx); key != "" {
+		if err := s.store.SetAPIKey(ctx, shortURL, key); err != nil {
+			return "", err
+		}
+		s.recordUsage(ctx, key, 1, 0)
+	}
+	if team := teamFrom(ctx); team != "" {
+		if err := s.store.SetTeam(ctx, shortURL, team); err != nil {
+			return "", err
+		}
+	}
+	if owner := ownerFrom(ctx); owner != "" {
+		if err := s.store.SetOwner(ctx, shortURL, owner); err != nil {
+			return "", err
+		}
+	}
+
+	after := &linkSnapshot{LongURL: longURL, Active: true, Variants: variants, Options: opts.Options}
+	if !targets.IsZero() {
+		after.Targets = &targets
+	}
+	if !opts.Card.IsZero() {
+		after.Card = &opts.Card
+	}
+	s.audit(ctx, shortURL, store.AuditCreate, nil, after)
+	if len(holds) > 0 {
+		if err := s.flagLink(ctx, shortURL, flagSourcePolicy, strings.Join(holds, "; ")); err != nil {
+			return "", err
+		}
+	}
+	s.publishLink(shortURL, longURL)
+	s.events.emit(busEvent{Type: eventLinkCreated, ShortURL: shortURL, LongURL: longURL, At: time.Now().UTC()})
+	return shortURL, nil
+}
+
+// aliasErrorStatus maps alias errors to an HTTP status code and API error
+// code. ok is false for any other error.
+func aliasErrorStatus(err error) (status int, code string, ok bool) {
+	switch err {
+	case errAliasInvalid:
+		return http.StatusBadRequest, errCodeAliasInvalid, true
+	case errAliasTaken:
+		return http.StatusConflict, errCodeAliasTaken, true
+	}
+	return 0, "", false
+}
+
+type aliasAvailability struct {
+	Alias     string `json:"alias"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// handleAPIAlias serves GET /api/v1/alias/{name}/available, which the create
+// form calls while the user types a custom alias.
+func (s *Server) handleAPIAlias(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/alias/")
+	if !strings.HasSuffix(path, "/available") {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	alias := strings.TrimSuffix(path, "/available")
+	result := aliasAvailability{Alias: alias}
+
+	if err := validateAlias(alias); err != nil {
+		result.Reason = err.Error()
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+
+	exists, err := s.store.Exists(r.Context(), alias)
+	if err != nil {
+		logf(r.Context(), "Error checking alias '%s': %v", alias, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error checking alias")
+		return
+	}
+	if exists {
+		result.Reason = errAliasTaken.Error()
+	} else {
+		result.Available = true
+	}
+	writeJSON(w, http.StatusOK, result)
+}
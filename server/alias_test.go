@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateAlias(t *testing.T) {
+	valid := []string{"a", "my-link", "Launch_2024", strings.Repeat("x", maxAliasLength)}
+	for _, alias := range valid {
+		if err := validateAlias(alias); err != nil {
+			t.Errorf("validateAlias(%q) returned an error: %v", alias, err)
+		}
+	}
+
+	invalid := []string{"", "has space", "slash/y", "dot.com", "ünïcode", strings.Repeat("x", maxAliasLength+1)}
+	for _, alias := range invalid {
+		if err := validateAlias(alias); err == nil {
+			t.Errorf("validateAlias(%q) should have failed", alias)
+		}
+	}
+}
+
+func TestCreateAlias(t *testing.T) {
+	srv, st := newMemoryServer(t)
+
+	t.Run("Available", func(t *testing.T) {
+		shortURL, err := srv.shortenURL(context.Background(), "https://example.com", "launch")
+		if err != nil {
+			t.Fatalf("shortenURL returned an error: %v", err)
+		}
+		if shortURL != "launch" {
+			t.Errorf("shortenURL returned wrong short URL: got %v want %v", shortURL, "launch")
+		}
+		if longURL, err := st.LongURL(context.Background(), "launch"); err != nil || longURL != "https://example.com" {
+			t.Errorf("store has %q, %v for launch", longURL, err)
+		}
+	})
+
+	t.Run("Taken", func(t *testing.T) {
+		err := srv.createAlias(context.Background(), "launch", "https://example.com")
+		if err != errAliasTaken {
+			t.Errorf("Expected errAliasTaken, got %v", err)
+		}
+	})
+}
+
+func TestHandleAPIAlias(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	if err := st.Create(context.Background(), "taken", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, path string) aliasAvailability {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIAlias).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var result aliasAvailability
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("Available", func(t *testing.T) {
+		if result := check(t, "/api/v1/alias/fresh/available"); !result.Available {
+			t.Errorf("Expected alias to be available: %+v", result)
+		}
+	})
+
+	t.Run("Taken", func(t *testing.T) {
+		if result := check(t, "/api/v1/alias/taken/available"); result.Available || result.Reason == "" {
+			t.Errorf("Expected alias to be taken: %+v", result)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		if result := check(t, "/api/v1/alias/no.dots/available"); result.Available {
+			t.Errorf("Expected invalid alias to be unavailable: %+v", result)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With anomalies.window set and the click log on, the click log is scanned
+// every minute for links that got at least anomalies.minClicks clicks in
+// the window from at most anomalies.maxIPs addresses, the mark of a script
+// or a click farm. Such links are logged, flagged for anomalies.flagFor
+// and listed at /api/v1/admin/anomalies and on the dashboard. With
+// anomalies.rateLimit set, each address may follow a flagged link only that
+// many times a minute.
+
+const anomalyScanInterval = time.Minute
+
+const (
+	defaultAnomalyLimit = 100
+	maxAnomalyLimit     = 1000
+)
+
+// anomalyTracker holds the flagged links and counts redirects to them.
+type anomalyTracker struct {
+	mu      sync.Mutex
+	flagged map[string]time.Time
+	minute  time.Time
+	hits    map[string]int
+}
+
+// flaggedLink is a link flagged for a click burst, until Until.
+type flaggedLink struct {
+	ShortURL string    `json:"shortURL"`
+	Until    time.Time `json:"until"`
+}
+
+// flag marks shortURL as flagged until until, and reports whether it was
+// newly flagged rather than still flagged from an earlier burst.
+func (a *anomalyTracker) flag(shortURL string, until, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.flagged == nil {
+		a.flagged = make(map[string]time.Time)
+	}
+	previous, ok := a.flagged[shortURL]
+	a.flagged[shortURL] = until
+	return !ok || !previous.After(now)
+}
+
+// list returns the links flagged at now, by short URL, and forgets the
+// ones whose flag has run out.
+func (a *anomalyTracker) list(now time.Time) []flaggedLink {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	links := []flaggedLink{}
+	for shortURL, until := range a.flagged {
+		if !until.After(now) {
+			delete(a.flagged, shortURL)
+			continue
+		}
+		links = append(links, flaggedLink{ShortURL: shortURL, Until: until})
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ShortURL < links[j].ShortURL })
+	return links
+}
+
+// allow counts a redirect from ip to shortURL and reports whether it is
+// within limit redirects this minute. Links that aren't flagged are never
+// limited.
+func (a *anomalyTracker) allow(shortURL, ip string, limit int, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if until, ok := a.flagged[shortURL]; !ok || !until.After(now) {
+		return true
+	}
+	if minute := now.Truncate(time.Minute); minute != a.minute || a.hits == nil {
+		a.minute = minute
+		a.hits = make(map[string]int)
+	}
+	key := shortURL + " " + ip
+	a.hits[key]++
+	return a.hits[key] <= limit
+}
+
+// allowRedirect reports whether the visitor making r may follow shortURL
+// under anomalies.rateLimit.
+func (s *Server) allowRedirect(r *http.Request, shortURL string) bool {
+	if s.cfg.Anomalies.RateLimit <= 0 {
+		return true
+	}
+	return s.anomalies.allow(shortURL, s.clientIP(r), s.cfg.Anomalies.RateLimit, time.Now())
+}
+
+// detectAnomalies looks for click bursts in the last anomalies.window,
+// flags the links that got them and logs the newly flagged ones.
+func (s *Server) detectAnomalies(ctx context.Context) error {
+	minClicks := s.cfg.Anomalies.MinClicks
+	if minClicks <= 0 {
+		minClicks = config.DefaultAnomalyMinClicks
+	}
+	maxIPs := s.cfg.Anomalies.MaxIPs
+	if maxIPs <= 0 {
+		maxIPs = config.DefaultAnomalyMaxIPs
+	}
+	flagFor := s.cfg.Anomalies.FlagFor.Or(config.DefaultAnomalyFlagFor)
+
+	now := time.Now().UTC()
+	bursts, err := s.store.ClickBursts(ctx, now.Add(-s.cfg.Anomalies.Window.Duration), minClicks, maxIPs)
+	if err != nil {
+		return err
+	}
+	// Every replica flags bursts for its own rate limiting, but only one
+	// logs them.
+	record := len(bursts) > 0 && s.lead(ctx, "anomalies", anomalyScanInterval)
+	for _, burst := range bursts {
+		if !s.anomalies.flag(burst.ShortURL, now.Add(flagFor), now) {
+			continue
+		}
+		log.Printf("Short URL '%s' got %d clicks from %d address(es) since %s, flagging it", burst.ShortURL, burst.Clicks, burst.UniqueIPs, burst.WindowStart.Format(time.RFC3339))
+		if !record {
+			continue
+		}
+		burst.DetectedAt = now
+		if err := s.store.RecordAnomaly(ctx, burst); err != nil {
+			log.Printf("Error logging click burst on short URL '%s': %v", burst.ShortURL, err)
+		}
+	}
+	return nil
+}
+
+// startAnomalyDetection runs detectAnomalies every minute until ctx is
+// cancelled. It does nothing without a window or the click log.
+func (s *Server) startAnomalyDetection(ctx context.Context) {
+	if s.cfg.Anomalies.Window.Duration <= 0 || !s.cfg.Analytics.ClickLog {
+		return
+	}
+	log.Printf("Watching for click bursts over %v", s.cfg.Anomalies.Window.Duration)
+
+	go func() {
+		ticker := time.NewTicker(anomalyScanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.detectAnomalies(ctx); err != nil {
+					log.Printf("Error looking for click bursts: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// handleAdminAnomalies serves GET /api/v1/admin/anomalies: the links
+// flagged now and the logged bursts, newest first, capped with ?limit=.
+func (s *Server) handleAdminAnomalies(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling anomalies request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := defaultAnomalyLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Limit must be a positive number")
+			return
+		}
+		if n < maxAnomalyLimit {
+			limit = n
+		} else {
+			limit = maxAnomalyLimit
+		}
+	}
+
+	anomalies, err := s.store.Anomalies(r.Context(), limit)
+	if err != nil {
+		logf(r.Context(), "Error reading anomalies: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading anomalies")
+		return
+	}
+	if anomalies == nil {
+		anomalies = []store.Anomaly{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Flagged   []flaggedLink   `json:"flagged"`
+		Anomalies []store.Anomaly `json:"anomalies"`
+	}{s.anomalies.list(time.Now()), anomalies})
+}
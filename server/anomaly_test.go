@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestAnomalyTracker(t *testing.T) {
+	var a anomalyTracker
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if !a.allow("abc123", "192.0.2.1", 1, now) || !a.allow("abc123", "192.0.2.1", 1, now) {
+		t.Error("allow limited a link that isn't flagged")
+	}
+	if !a.flag("abc123", now.Add(time.Hour), now) {
+		t.Error("flag didn't report a new flag")
+	}
+	if a.flag("abc123", now.Add(2*time.Hour), now.Add(time.Minute)) {
+		t.Error("flag reported a link that was still flagged as new")
+	}
+
+	if !a.allow("abc123", "192.0.2.1", 2, now) || !a.allow("abc123", "192.0.2.1", 2, now) {
+		t.Error("allow limited visits within the limit")
+	}
+	if a.allow("abc123", "192.0.2.1", 2, now) {
+		t.Error("allow let a visit over the limit through")
+	}
+	if !a.allow("abc123", "192.0.2.2", 2, now) {
+		t.Error("allow limited another address")
+	}
+	if !a.allow("abc123", "192.0.2.1", 2, now.Add(time.Minute)) {
+		t.Error("allow didn't reset the count the next minute")
+	}
+
+	if links := a.list(now); len(links) != 1 || links[0].ShortURL != "abc123" {
+		t.Errorf("list returned %+v", links)
+	}
+	if links := a.list(now.Add(3 * time.Hour)); len(links) != 0 {
+		t.Errorf("list kept an expired flag: %+v", links)
+	}
+	if !a.allow("abc123", "192.0.2.1", 0, now.Add(3*time.Hour)) {
+		t.Error("allow limited a link whose flag ran out")
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Anomalies.Window.Duration = 10 * time.Minute
+
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"short_url", "clicks", "unique_ips"}).AddRow("abc123", 250, 1)
+	}
+	mock.ExpectQuery("SELECT short_url, COUNT\\(\\*\\) AS clicks").
+		WithArgs(sqlmock.AnyArg(), 100, 3).
+		WillReturnRows(rows())
+	mock.ExpectExec("INSERT INTO anomalies").
+		WithArgs("abc123", 250, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// The link is still flagged on the next scan, so it isn't logged again.
+	mock.ExpectQuery("SELECT short_url, COUNT\\(\\*\\) AS clicks").
+		WillReturnRows(rows())
+
+	for i := 0; i < 2; i++ {
+		if err := srv.detectAnomalies(context.Background()); err != nil {
+			t.Fatalf("detectAnomalies returned an error: %v", err)
+		}
+	}
+	if links := srv.anomalies.list(time.Now()); len(links) != 1 || links[0].ShortURL != "abc123" {
+		t.Errorf("detectAnomalies flagged %+v", links)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRedirectRateLimit(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Anomalies.RateLimit = 1
+	srv.anomalies.flag("abc123", time.Now().Add(time.Hour), time.Now())
+
+	mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+		WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"long_url"}).AddRow("https://example.com"))
+	expectOptions(mock, "abc123", store.Options{})
+	expectNoVariants(mock, "abc123")
+	mock.ExpectExec("UPDATE url_mapping SET visit_count").
+		WithArgs("abc123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	for _, want := range []int{http.StatusFound, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/_/abc123", nil)
+		req.RemoteAddr = "192.0.2.1:5555"
+		rr := httptest.NewRecorder()
+		srv.handleRedirect(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, want)
+		}
+		if want == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Error("handler didn't set Retry-After")
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandleAdminAnomalies(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.anomalies.flag("abc123", time.Now().Add(time.Hour), time.Now())
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/admin/anomalies", nil))
+
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, short_url, clicks, unique_ips, window_start, detected_at").
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "short_url", "clicks", "unique_ips", "window_start", "detected_at"}).
+				AddRow(1, "abc123", 250, 1, "2024-01-01 11:50:00", "2024-01-01 12:00:00"))
+
+		req := httptest.NewRequest("GET", "/api/v1/admin/anomalies?limit=5", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var body struct {
+			Flagged   []flaggedLink   `json:"flagged"`
+			Anomalies []store.Anomaly `json:"anomalies"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Flagged) != 1 || body.Flagged[0].ShortURL != "abc123" {
+			t.Errorf("handler returned unexpected flagged links: %+v", body.Flagged)
+		}
+		if len(body.Anomalies) != 1 || body.Anomalies[0].Clicks != 250 {
+			t.Errorf("handler returned unexpected anomalies: %+v", body.Anomalies)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,572 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// The JSON API lives under /api/v1/ and mirrors what the HTML pages do, so
+// scripts and monitoring tools don't have to scrape templates.
+
+// API error codes. Clients branch on these, so existing codes must not
+// change meaning.
+const (
+	errCodeAliasInvalid        = "alias_invalid"
+	errCodeAliasTaken          = "alias_taken"
+	errCodeBackupNotConfigured = "backup_not_configured"
+	errCodeBatchTooLarge       = "batch_too_large"
+	errCodeBodyTooLarge        = "body_too_large"
+	errCodeClickLogDisabled    = "click_log_disabled"
+	errCodeEmailNotConfigured  = "email_not_configured"
+	errCodeForbidden           = "forbidden"
+	errCodeInternal            = "internal_error"
+	errCodeInvalidAlert        = "invalid_alert"
+	errCodeInvalidBatch        = "invalid_batch"
+	errCodeInvalidCard         = "invalid_card"
+	errCodeInvalidForm         = "invalid_form"
+	errCodeInvalidJSON         = "invalid_json"
+	errCodeInvalidMaxClicks    = "invalid_max_clicks"
+	errCodeInvalidURL          = "invalid_url"
+	errCodeInvalidUTM          = "invalid_utm"
+	errCodeInvalidVariants     = "invalid_variants"
+	errCodeKeyspaceExhausted   = "keyspace_exhausted"
+	errCodeLinkRejected        = "link_rejected"
+	errCodeMethodNotAllowed    = "method_not_allowed"
+	errCodeNotFound            = "not_found"
+	errCodeNotSupported        = "not_supported"
+	errCodeQuotaExceeded       = "quota_exceeded"
+	errCodeUnauthorized        = "unauthorized"
+	errCodeShareNotConfigured  = "share_not_configured"
+	errCodeURLTooLong          = "url_too_long"
+)
+
+// APIError is the body of every API error response, wrapped as
+// {"error": {...}} by APIErrorResponse.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RequestID identifies the request in the server's log.
+	RequestID string `json:"requestID,omitempty"`
+}
+
+type APIErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// CreateLinkRequest is the body of POST /api/v1/links. URL may be left out
+// of a split link, which then uses its first variant's URL. The per-link
+// options sit alongside the other fields.
+type CreateLinkRequest struct {
+	URL      string          `json:"url"`
+	Alias    string          `json:"alias,omitempty"`
+	Targets  *store.Targets  `json:"targets,omitempty"`
+	Variants []store.Variant `json:"variants,omitempty"`
+	Card     *store.Card     `json:"card,omitempty"`
+	store.Options
+}
+
+// linkResponse is a link as the API returns it: its stats plus any
+// per-platform targets, A/B variants, preview card and options.
+type linkResponse struct {
+	store.LinkStats
+	BotVisitCount *int            `json:"botVisitCount,omitempty"`
+	Active        bool            `json:"active"`
+	Flagged       bool            `json:"flagged,omitempty"`
+	Targets       *store.Targets  `json:"targets,omitempty"`
+	Variants      []store.Variant `json:"variants,omitempty"`
+	Card          *store.Card     `json:"card,omitempty"`
+	store.Options
+}
+
+// updateLinkRequest is the body of PATCH /api/v1/links/{shortURL}. Fields
+// left out are left alone; an empty card removes the link's card.
+type updateLinkRequest struct {
+	Active *bool       `json:"active"`
+	Card   *store.Card `json:"card"`
+}
+
+func (s *Server) handleAPILinks(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API links request")
+	if r.Method == http.MethodGet {
+		s.handleAPIRecentLinks(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	key, keyed := s.apiKeyFor(r)
+	var created int
+	if keyed {
+		if !hasScope(key, scopeCreate) {
+			writeAPIError(w, http.StatusForbidden, errCodeForbidden, "This API key may not create links")
+			return
+		}
+		var ok bool
+		if created, ok = s.checkCreateQuota(w, r, key); !ok {
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	var req CreateLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body is too large")
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body")
+		return
+	}
+
+	if req.URL == "" && len(req.Variants) > 0 {
+		req.URL = req.Variants[0].URL
+	}
+
+	if !validLongURL(req.URL) {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidURL, "Invalid URL")
+		return
+	}
+
+	if len(req.URL) > 2048 {
+		writeAPIError(w, http.StatusBadRequest, errCodeURLTooLong, "URL is too long")
+		return
+	}
+
+	var targets store.Targets
+	if req.Targets != nil {
+		targets = *req.Targets
+	}
+	if err := validateTargets(targets); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidURL, err.Error())
+		return
+	}
+
+	if req.FallbackURL != "" {
+		if _, err := url.ParseRequestURI(req.FallbackURL); err != nil || len(req.FallbackURL) > 2048 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidURL, "Invalid fallback URL")
+			return
+		}
+	}
+
+	trimUTM(&req.Options)
+	if err := validateUTM(req.Options); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidUTM, err.Error())
+		return
+	}
+
+	if err := validateClickCap(req.Options); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidMaxClicks, err.Error())
+		return
+	}
+
+	variants, err := normalizeVariants(req.Variants)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidVariants, err.Error())
+		return
+	}
+
+	var card store.Card
+	if req.Card != nil {
+		if card, err = normalizeCard(*req.Card); err != nil {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidCard, err.Error())
+			return
+		}
+	}
+
+	ctx := withSource(withActor(r.Context(), s.requestActor(r, sourceAPI)), sourceAPI)
+	if keyed {
+		ctx = withAPIKey(ctx, key.Name)
+	}
+	ctx = s.withAccount(ctx, r)
+	shortURL, err := s.createLink(ctx, req.URL, strings.TrimSpace(req.Alias), linkOptions{Targets: targets, Variants: variants, Card: card, Options: req.Options})
+	if err != nil {
+		if status, code, ok := aliasErrorStatus(err); ok {
+			writeAPIError(w, status, code, err.Error())
+			return
+		}
+		if isRejected(err) {
+			writeAPIError(w, http.StatusForbidden, errCodeLinkRejected, err.Error())
+			return
+		}
+		if err == errKeyspaceExhausted {
+			w.Header().Set("Retry-After", exhaustedRetryAfter)
+			writeAPIError(w, http.StatusServiceUnavailable, errCodeKeyspaceExhausted, "No free short URL found, try again shortly")
+			return
+		}
+		logf(ctx, "Error creating short URL: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create short URL")
+		return
+	}
+	logln(ctx, "Created short URL via API:", shortURL)
+
+	linkStats, err := s.store.Link(r.Context(), shortURL)
+	if err != nil {
+		logf(ctx, "Error fetching stats for new short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create short URL")
+		return
+	}
+
+	if keyed && key.MonthlyCreates > 0 {
+		_, reset := usageMonth(time.Now())
+		setRateLimitHeaders(w, key.MonthlyCreates, created+1, reset)
+	}
+	if wantsText(r) {
+		writeText(w, http.StatusCreated, s.cfg.PublicURL(r)+"/_/"+shortURL)
+		return
+	}
+	resp := linkResponse{LinkStats: linkStats, Active: true, Variants: variants, Options: req.Options}
+	if !targets.IsZero() {
+		resp.Targets = &targets
+	}
+	if !card.IsZero() {
+		resp.Card = &card
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) handleAPILink(w http.ResponseWriter, r *http.Request) {
+	shortURL := strings.TrimPrefix(r.URL.Path, "/api/v1/links/")
+	if code, ok := strings.CutSuffix(shortURL, "/share"); ok && code != "" && !strings.Contains(code, "/") {
+		s.handleAPIShare(w, r, code)
+		return
+	}
+	if code, ok := strings.CutSuffix(shortURL, "/report"); ok && code != "" && !strings.Contains(code, "/") {
+		s.handleAPIReport(w, r, code)
+		return
+	}
+	// A link may be called batch, so only POST, which links don't answer,
+	// goes to the batch endpoint.
+	if shortURL == "batch" && r.Method == http.MethodPost {
+		s.handleAPIBatch(w, r)
+		return
+	}
+	if code, rest, ok := strings.Cut(shortURL, "/alerts"); ok && code != "" && !strings.Contains(code, "/") && (rest == "" || strings.HasPrefix(rest, "/")) {
+		s.handleAPIAlerts(w, r, code, strings.TrimPrefix(rest, "/"))
+		return
+	}
+	logf(r.Context(), "Handling API link request for short URL: '%s'", shortURL)
+
+	if shortURL == "" || strings.Contains(shortURL, "/") {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !s.authorizedLinkStats(r, shortURL) {
+			writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+		s.writeLink(w, r, shortURL)
+
+	case http.MethodPatch:
+		if !s.authorizedAdmin(r) {
+			writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+		var req updateLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Active == nil && req.Card == nil) {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidJSON, "Body must set \"active\" or \"card\"")
+			return
+		}
+		var card store.Card
+		if req.Card != nil {
+			var err error
+			if card, err = normalizeCard(*req.Card); err != nil {
+				writeAPIError(w, http.StatusBadRequest, errCodeInvalidCard, err.Error())
+				return
+			}
+		}
+		ctx := withActor(r.Context(), s.requestActor(r, "api"))
+		var err error
+		if req.Card != nil {
+			err = s.setCard(ctx, shortURL, card)
+		}
+		if err == nil && req.Active != nil {
+			err = s.setActive(ctx, shortURL, *req.Active)
+		}
+		if err != nil {
+			if err == store.ErrNotFound {
+				writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+				return
+			}
+			logf(ctx, "Error updating short URL %s: %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to update short URL")
+			return
+		}
+		if req.Active != nil {
+			logf(ctx, "Set short URL %s active=%v via API", shortURL, *req.Active)
+		}
+		if req.Card != nil {
+			logf(ctx, "Set the card of short URL %s via API", shortURL)
+		}
+		s.writeLink(w, r, shortURL)
+
+	case http.MethodDelete:
+		if !s.authorizedAdmin(r) {
+			writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+		ctx := withActor(r.Context(), s.requestActor(r, "api"))
+		deleted, err := s.deleteLink(ctx, shortURL, r.URL.Query().Get("permanent") == "true")
+		if err != nil {
+			logf(ctx, "Error deleting short URL %s: %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to delete short URL")
+			return
+		}
+		if !deleted {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		}
+		logln(ctx, "Deleted short URL via API:", shortURL)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// setActive disables or re-enables shortURL and records the change in the
+// audit log.
+func (s *Server) setActive(ctx context.Context, shortURL string, active bool) error {
+	before := s.auditSnapshot(ctx, shortURL)
+	if err := s.store.SetActive(ctx, shortURL, active); err != nil {
+		return err
+	}
+	if before != nil {
+		after := *before
+		after.Active = active
+		after.Options.Disabled = !active
+		action := store.AuditDisable
+		if active {
+			action = store.AuditEnable
+		}
+		s.audit(ctx, shortURL, action, before, &after)
+	}
+	return nil
+}
+
+// writeLink answers with the link as GET /api/v1/links/{shortURL} returns
+// it.
+func (s *Server) writeLink(w http.ResponseWriter, r *http.Request, shortURL string) {
+	linkStats, err := s.store.Link(r.Context(), shortURL)
+	if err != nil {
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		}
+		logf(r.Context(), "Error fetching stats for short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching link stats")
+		return
+	}
+	resp := linkResponse{LinkStats: linkStats}
+	targets, err := s.store.Targets(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching targets for short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching link stats")
+		return
+	}
+	if !targets.IsZero() {
+		resp.Targets = &targets
+	}
+	if resp.Variants, err = s.store.Variants(r.Context(), shortURL); err != nil {
+		logf(r.Context(), "Error fetching variants for short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching link stats")
+		return
+	}
+	if resp.Options, err = s.store.Options(r.Context(), shortURL); err != nil {
+		logf(r.Context(), "Error fetching options for short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching link stats")
+		return
+	}
+	card, err := s.store.Card(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching the card of short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching link stats")
+		return
+	}
+	if !card.IsZero() {
+		resp.Card = &card
+	}
+	resp.Active = !resp.Options.Disabled
+	resp.Flagged = resp.Options.Flagged
+	if s.cfg.Analytics.FilterBots {
+		bots, err := s.store.BotVisits(r.Context(), shortURL)
+		if err != nil {
+			logf(r.Context(), "Error fetching bot visits for short URL %s: %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching link stats")
+			return
+		}
+		resp.BotVisitCount = &bots
+	}
+	writeRevalidatedJSON(w, r, resp, false)
+}
+
+// Link statuses reported by the expand API.
+const (
+	LinkStatusActive   = "active"
+	LinkStatusDisabled = "disabled"
+	LinkStatusExpired  = "expired"
+	LinkStatusFlagged  = "flagged"
+	LinkStatusRemoved  = "removed"
+	LinkStatusNotFound = "not_found"
+)
+
+// maxExpandBatch caps how many short URLs one batch expand call may look up.
+const maxExpandBatch = 100
+
+// ExpandResult describes one link returned by the expand API.
+type ExpandResult struct {
+	ShortURL  string     `json:"shortURL"`
+	LongURL   string     `json:"longURL,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Status    string     `json:"status"`
+}
+
+type expandBatchRequest struct {
+	ShortURLs []string `json:"shortURLs"`
+}
+
+// expandShortURL looks up where a short URL points without following it or
+// counting a visit.
+func (s *Server) expandShortURL(ctx context.Context, shortURL string) (ExpandResult, error) {
+	result := ExpandResult{ShortURL: shortURL}
+	linkStats, err := s.store.Link(ctx, shortURL)
+	if err == store.ErrNotFound {
+		result.Status = LinkStatusNotFound
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	opts, err := s.store.Options(ctx, shortURL)
+	if err != nil {
+		return result, err
+	}
+	result.LongURL = linkStats.LongURL
+	result.CreatedAt = &linkStats.CreatedAt
+	result.Status = LinkStatusActive
+	if opts.TakenDown {
+		result.Status = LinkStatusRemoved
+	} else if opts.Disabled {
+		result.Status = LinkStatusDisabled
+	} else if opts.Flagged {
+		result.Status = LinkStatusFlagged
+	} else if opts.Expired(time.Now()) {
+		result.Status = LinkStatusExpired
+	}
+	return result, nil
+}
+
+// handleAPIExpand serves GET /api/v1/expand/{shortURL} and the batch form
+// POST /api/v1/expand with {"shortURLs": [...]}.
+func (s *Server) handleAPIExpand(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API expand request")
+
+	if r.URL.Path == "/api/v1/expand" || r.URL.Path == "/api/v1/expand/" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+		var req expandBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body")
+			return
+		}
+		if len(req.ShortURLs) > maxExpandBatch {
+			writeAPIError(w, http.StatusBadRequest, errCodeBatchTooLarge, fmt.Sprintf("At most %d short URLs per request", maxExpandBatch))
+			return
+		}
+
+		results := make([]ExpandResult, 0, len(req.ShortURLs))
+		for _, shortURL := range req.ShortURLs {
+			result, err := s.expandShortURL(r.Context(), shortURL)
+			if err != nil {
+				logf(r.Context(), "Error expanding short URL %s: %v", shortURL, err)
+				writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error expanding short URLs")
+				return
+			}
+			results = append(results, result)
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Results []ExpandResult `json:"results"`
+		}{results})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	shortURL := strings.TrimPrefix(r.URL.Path, "/api/v1/expand/")
+	if strings.Contains(shortURL, "/") {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+
+	result, err := s.expandShortURL(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error expanding short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error expanding short URL")
+		return
+	}
+	status := http.StatusOK
+	if result.Status == LinkStatusNotFound {
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, result)
+}
+
+// authorizedAdmin reports whether the request carries the configured admin
+// API key, or an api.keys entry with the admin scope, as a bearer token.
+// With neither configured, admin calls are refused.
+func (s *Server) authorizedAdmin(r *http.Request) bool {
+	if key, ok := s.apiKeyFor(r); ok {
+		return hasScope(key, scopeAdmin)
+	}
+	if s.cfg.API.AdminKey == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.API.AdminKey)) == 1
+}
+
+// writeAPIError writes an error in the API's JSON envelope.
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, APIErrorResponse{APIError{Code: code, Message: message, RequestID: w.Header().Get(requestIDHeader)}})
+}
+
+// handleAPINotFound answers requests for unknown API paths, which would
+// otherwise fall through to the HTML index page.
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestHandleAPILinks(t *testing.T) {
+	srv, mock := newMockServer(t)
+
+	t.Run("Valid URL", func(t *testing.T) {
+		longURL := "https://example.com"
+		shortURL := "abc123"
+
+		mock.ExpectQuery("SELECT short_url FROM url_mapping WHERE long_url").
+			WithArgs(longURL).
+			WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow(shortURL))
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs(shortURL).
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+				AddRow(shortURL, longURL, 3, time.Now().Format("2006-01-02 15:04:05")))
+
+		req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "https://example.com"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILinks).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+
+		var link store.LinkStats
+		if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if link.ShortURL != shortURL || link.VisitCount != 3 {
+			t.Errorf("handler returned unexpected link: %+v", link)
+		}
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "not-a-url"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILinks).ServeHTTP(rr, req)
+
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidURL)
+	})
+
+	t.Run("Alias Taken", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs("launch").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "https://example.com", "alias": "launch"}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILinks).ServeHTTP(rr, req)
+
+		checkAPIError(t, rr, http.StatusConflict, errCodeAliasTaken)
+	})
+
+	t.Run("Wrong Method", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/api/v1/links", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILinks).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusMethodNotAllowed {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandleAPILink(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.API.AdminKey = "secret"
+
+	t.Run("Not Found", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("missing").
+			WillReturnError(sql.ErrNoRows)
+
+		req := httptest.NewRequest("GET", "/api/v1/links/missing", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILink).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Delete Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/v1/links/abc123", nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILink).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		mock.ExpectExec("UPDATE url_mapping SET active").
+			WithArgs(false, false, "abc123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+				AddRow("abc123", "https://example.com", 5, "2024-01-02 03:04:05"))
+		mock.ExpectQuery("SELECT COALESCE\\(ios_url").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"ios_url", "android_url"}).AddRow("", ""))
+		expectNoVariants(mock, "abc123")
+		expectOptions(mock, "abc123", store.Options{Disabled: true})
+		expectNoCard(mock, "abc123")
+
+		req := httptest.NewRequest("PATCH", "/api/v1/links/abc123", strings.NewReader(`{"active": false}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILink).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var link linkResponse
+		if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+			t.Fatal(err)
+		}
+		if link.Active {
+			t.Errorf("handler returned an active link after disabling it: %+v", link)
+		}
+	})
+
+	t.Run("Update Without Active", func(t *testing.T) {
+		req := httptest.NewRequest("PATCH", "/api/v1/links/abc123", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILink).ServeHTTP(rr, req)
+
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidJSON)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM url_mapping WHERE short_url").
+			WithArgs("abc123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		req := httptest.NewRequest("DELETE", "/api/v1/links/abc123", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPILink).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandleAPIExpand(t *testing.T) {
+	srv, mock := newMockServer(t)
+
+	linkRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("abc123", "https://example.com", 5, "2024-01-02 03:04:05")
+	}
+
+	t.Run("Single", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(linkRows())
+		expectOptions(mock, "abc123", store.Options{})
+
+		req := httptest.NewRequest("GET", "/api/v1/expand/abc123", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIExpand).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var result ExpandResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.LongURL != "https://example.com" || result.Status != LinkStatusActive {
+			t.Errorf("handler returned unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("Single Not Found", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("missing").
+			WillReturnError(sql.ErrNoRows)
+
+		req := httptest.NewRequest("GET", "/api/v1/expand/missing", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIExpand).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(linkRows())
+		expectOptions(mock, "abc123", store.Options{Disabled: true})
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("missing").
+			WillReturnError(sql.ErrNoRows)
+
+		req := httptest.NewRequest("POST", "/api/v1/expand", strings.NewReader(`{"shortURLs": ["abc123", "missing"]}`))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIExpand).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var body struct {
+			Results []ExpandResult `json:"results"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Results) != 2 || body.Results[0].Status != LinkStatusDisabled || body.Results[1].Status != LinkStatusNotFound {
+			t.Errorf("handler returned unexpected results: %+v", body.Results)
+		}
+	})
+
+	t.Run("Batch Too Large", func(t *testing.T) {
+		codes := make([]string, maxExpandBatch+1)
+		payload, _ := json.Marshal(expandBatchRequest{ShortURLs: codes})
+
+		req := httptest.NewRequest("POST", "/api/v1/expand", strings.NewReader(string(payload)))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIExpand).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandleAPINotFound(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/nope", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handleAPINotFound).ServeHTTP(rr, req)
+
+	checkAPIError(t, rr, http.StatusNotFound, errCodeNotFound)
+}
+
+// checkAPIError asserts that rr holds an API error envelope with the given
+// status and code.
+func checkAPIError(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rr.Code != status {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, status)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("handler returned wrong content type: got %v want %v", ct, "application/json")
+	}
+	var envelope APIErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	if envelope.Error.Code != code {
+		t.Errorf("handler returned wrong error code: got %v want %v", envelope.Error.Code, code)
+	}
+	if envelope.Error.Message == "" {
+		t.Error("handler returned an empty error message")
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With audit.enabled set, every change to a link is written to the audit
+// log with who made it and the link before and after, so changes to
+// production links can be traced. Admins read the log from
+// /api/v1/admin/audit.
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type actorKey struct{}
+
+// withActor records in ctx who is making a change, for the audit log.
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return "unknown"
+}
+
+// requestActor names who sent r: "admin" for calls made with the admin key,
+// otherwise the channel it came in on and the client's address.
+func (s *Server) requestActor(r *http.Request, channel string) string {
+	if key, ok := s.apiKeyFor(r); ok {
+		return "key " + key.Name
+	}
+	if s.authorizedAdmin(r) {
+		return "admin"
+	}
+	return channel + " " + s.clientIP(r)
+}
+
+// remoteHost returns the address r came from, without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// linkSnapshot is a link as the audit log records it.
+type linkSnapshot struct {
+	LongURL   string          `json:"longURL"`
+	Active    bool            `json:"active"`
+	Flagged   bool            `json:"flagged,omitempty"`
+	TakenDown bool            `json:"takenDown,omitempty"`
+	Targets   *store.Targets  `json:"targets,omitempty"`
+	Variants  []store.Variant `json:"variants,omitempty"`
+	Card      *store.Card     `json:"card,omitempty"`
+	Tags      []string        `json:"tags,omitempty"`
+	store.Options
+}
+
+// auditSnapshot reads the current state of shortURL for an audit entry. It
+// returns nil when auditing is off or the link can't be read.
+func (s *Server) auditSnapshot(ctx context.Context, shortURL string) *linkSnapshot {
+	if !s.cfg.Audit.Enabled {
+		return nil
+	}
+	longURL, err := s.store.LongURL(ctx, shortURL)
+	if err != nil {
+		if err != store.ErrNotFound {
+			logf(ctx, "Error reading short URL '%s' for the audit log: %v", shortURL, err)
+		}
+		return nil
+	}
+	snap := &linkSnapshot{LongURL: longURL}
+	targets, err := s.store.Targets(ctx, shortURL)
+	if err == nil && !targets.IsZero() {
+		snap.Targets = &targets
+	}
+	if err == nil {
+		snap.Variants, err = s.store.Variants(ctx, shortURL)
+	}
+	if err == nil {
+		var card store.Card
+		if card, err = s.store.Card(ctx, shortURL); err == nil && !card.IsZero() {
+			snap.Card = &card
+		}
+	}
+	if err == nil {
+		snap.Tags, err = s.store.Tags(ctx, shortURL)
+	}
+	if err == nil {
+		snap.Options, err = s.store.Options(ctx, shortURL)
+	}
+	if err != nil {
+		logf(ctx, "Error reading short URL '%s' for the audit log: %v", shortURL, err)
+		return nil
+	}
+	snap.Active = !snap.Options.Disabled
+	snap.Flagged = snap.Options.Flagged
+	snap.TakenDown = snap.Options.TakenDown
+	return snap
+}
+
+// audit writes an entry to the audit log when auditing is on. A failure is
+// logged rather than undoing the change.
+func (s *Server) audit(ctx context.Context, shortURL, action string, before, after *linkSnapshot) {
+	if !s.cfg.Audit.Enabled {
+		return
+	}
+	entry := store.AuditEntry{
+		ShortURL:  shortURL,
+		Action:    action,
+		Actor:     actorFrom(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			logf(ctx, "Error encoding audit entry for short URL '%s': %v", shortURL, err)
+			return
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			logf(ctx, "Error encoding audit entry for short URL '%s': %v", shortURL, err)
+			return
+		}
+	}
+	if err := s.store.RecordAudit(ctx, entry); err != nil {
+		logf(ctx, "Error recording %s of short URL '%s' in the audit log: %v", action, shortURL, err)
+	}
+}
+
+// handleAdminAudit serves GET /api/v1/admin/audit, optionally filtered to
+// one link with ?shortURL= and capped with ?limit=.
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling audit log request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Limit must be a positive number")
+			return
+		}
+		if n < maxAuditLimit {
+			limit = n
+		} else {
+			limit = maxAuditLimit
+		}
+	}
+
+	entries, err := s.store.AuditLog(r.Context(), r.URL.Query().Get("shortURL"), limit)
+	if err != nil {
+		logf(r.Context(), "Error reading audit log: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading audit log")
+		return
+	}
+	if entries == nil {
+		entries = []store.AuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Entries []store.AuditEntry `json:"entries"`
+	}{entries})
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestAuditCreate(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Audit.Enabled = true
+
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("launch").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO url_mapping").
+		WithArgs("launch", "https://example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("launch", store.AuditCreate, "grpc", "", `{"longURL":"https://example.com","active":true}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if _, err := srv.shortenURL(withActor(context.Background(), "grpc"), "https://example.com", "launch"); err != nil {
+		t.Fatalf("shortenURL returned an error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRequestActor(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+
+	req := httptest.NewRequest("POST", "/api/v1/links", nil)
+	req.RemoteAddr = "192.0.2.1:5555"
+	if actor := srv.requestActor(req, "api"); actor != "api 192.0.2.1" {
+		t.Errorf("requestActor returned %q want %q", actor, "api 192.0.2.1")
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if actor := srv.requestActor(req, "api"); actor != "admin" {
+		t.Errorf("requestActor returned %q want %q", actor, "admin")
+	}
+	if actor := actorFrom(context.Background()); actor != "unknown" {
+		t.Errorf("actorFrom returned %q for a context without an actor", actor)
+	}
+}
+
+func TestHandleAdminAudit(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.API.AdminKey = "secret"
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/admin/audit", nil))
+
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("One Link", func(t *testing.T) {
+		mock.ExpectQuery("SELECT id, short_url, action, actor, before, after, created_at").
+			WithArgs("abc123", "abc123", 5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "short_url", "action", "actor", "before", "after", "created_at"}).
+				AddRow(2, "abc123", "disable", "admin", `{"active":true}`, `{"active":false}`, "2024-01-02 03:04:05").
+				AddRow(1, "abc123", "create", "web 192.0.2.1", "", `{"active":true}`, "2024-01-01 03:04:05"))
+
+		req := httptest.NewRequest("GET", "/api/v1/admin/audit?shortURL=abc123&limit=5", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var body struct {
+			Entries []store.AuditEntry `json:"entries"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Entries) != 2 || body.Entries[0].Action != store.AuditDisable || body.Entries[1].Before != nil {
+			t.Errorf("handler returned unexpected entries: %+v", body.Entries)
+		}
+	})
+
+	t.Run("Bad Limit", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/admin/audit?limit=none", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidForm)
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+const backupPrefix = "shorty-"
+
+// createBackup snapshots db into dir under a timestamped name and returns
+// the path of the new file.
+func createBackup(ctx context.Context, db *sql.DB, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := backupPrefix + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	path := filepath.Join(dir, name)
+
+	// Write to a temporary name first so a crash never leaves a partial file
+	// that looks like a finished backup.
+	tmpPath := path + ".tmp"
+	if err := store.Backup(ctx, db, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return path, nil
+}
+
+// pruneBackups deletes all but the newest keep backups in dir. A keep of
+// zero or less keeps everything.
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(backups)-keep; i++ {
+		if err := os.Remove(filepath.Join(dir, backups[i])); err != nil {
+			return err
+		}
+		log.Printf("Removed old backup %s", backups[i])
+	}
+	return nil
+}
+
+// listBackups returns the names of the finished backups in dir, oldest
+// first.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, ".db") {
+			names = append(names, name)
+		}
+	}
+	// Timestamps in the names sort lexically in time order.
+	sort.Strings(names)
+	return names, nil
+}
+
+// runScheduledBackup takes one backup into the configured directory,
+// copies it offsite when S3 is configured, applies the retention limits and
+// returns the new backup's path.
+func (s *Server) runScheduledBackup(ctx context.Context, db *sql.DB) (string, error) {
+	path, err := createBackup(ctx, db, s.cfg.Backup.Dir)
+	if err != nil {
+		return "", err
+	}
+	logf(ctx, "Wrote backup %s", path)
+
+	if s.cfg.Backup.S3.Bucket != "" {
+		if err := s.uploadBackup(ctx, path); err != nil {
+			return path, fmt.Errorf("offsite upload failed: %v", err)
+		}
+	}
+	return path, pruneBackups(s.cfg.Backup.Dir, s.cfg.Backup.Keep)
+}
+
+// startBackups takes a backup every backup.interval until ctx is cancelled.
+// It does nothing unless both a directory and an interval are configured.
+func (s *Server) startBackups(ctx context.Context, db *sql.DB) {
+	interval := s.cfg.Backup.Interval.Duration
+	if s.cfg.Backup.Dir == "" || interval <= 0 {
+		return
+	}
+	log.Printf("Scheduled backups to %s every %v", s.cfg.Backup.Dir, interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !s.lead(ctx, "backups", interval) {
+					continue
+				}
+				if _, err := s.runScheduledBackup(ctx, db); err != nil {
+					log.Printf("Error running scheduled backup: %v", err)
+					s.opsAlert(opsBackupFailed, fmt.Sprintf("Scheduled backup failed: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+// handleAdminBackup serves /api/v1/admin/backup. POST writes a snapshot to
+// the configured backup directory; GET streams a fresh snapshot back as a
+// download.
+func (s *Server) handleAdminBackup(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling backup request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	db, ok := s.sqliteDB()
+	if !ok {
+		writeAPIError(w, http.StatusNotImplemented, errCodeNotSupported, "Backups need the SQLite store")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if s.cfg.Backup.Dir == "" {
+			writeAPIError(w, http.StatusServiceUnavailable, errCodeBackupNotConfigured, "Backup directory is not configured")
+			return
+		}
+		path, err := s.runScheduledBackup(r.Context(), db)
+		if err != nil {
+			logf(r.Context(), "Error creating backup: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create backup")
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			logf(r.Context(), "Error reading backup %s: %v", path, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create backup")
+			return
+		}
+		writeJSON(w, http.StatusCreated, struct {
+			Name      string    `json:"name"`
+			Size      int64     `json:"size"`
+			CreatedAt time.Time `json:"createdAt"`
+		}{info.Name(), info.Size(), info.ModTime().UTC()})
+
+	case http.MethodGet:
+		tmpDir, err := os.MkdirTemp("", "shorty-backup")
+		if err != nil {
+			logf(r.Context(), "Error creating temp dir for backup: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create backup")
+			return
+		}
+		defer os.RemoveAll(tmpDir)
+
+		path, err := createBackup(r.Context(), db, tmpDir)
+		if err != nil {
+			logf(r.Context(), "Error creating backup: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create backup")
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			logf(r.Context(), "Error opening backup: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to create backup")
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+		if _, err := io.Copy(w, f); err != nil {
+			logf(r.Context(), "Error streaming backup: %v", err)
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestCreateBackup(t *testing.T) {
+	srcDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srcDB.Close()
+	if _, err := store.Migrate(srcDB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcDB.Exec(`INSERT INTO url_mapping (short_url, long_url) VALUES ('abc123', 'https://example.com')`); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path, err := createBackup(context.Background(), srcDB, dir)
+	if err != nil {
+		t.Fatalf("createBackup returned an error: %v", err)
+	}
+
+	backupDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backupDB.Close()
+
+	var longURL string
+	if err := backupDB.QueryRow(`SELECT long_url FROM url_mapping WHERE short_url = 'abc123'`).Scan(&longURL); err != nil {
+		t.Fatalf("Backup is missing the seeded link: %v", err)
+	}
+	if longURL != "https://example.com" {
+		t.Errorf("Backup returned wrong long URL: got %v want %v", longURL, "https://example.com")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"shorty-20240101T000000Z.db",
+		"shorty-20240102T000000Z.db",
+		"shorty-20240103T000000Z.db",
+		"unrelated.txt",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneBackups(dir, 2); err != nil {
+		t.Fatalf("pruneBackups returned an error: %v", err)
+	}
+
+	backups, err := listBackups(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0] != names[1] || backups[1] != names[2] {
+		t.Errorf("pruneBackups kept the wrong backups: %v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unrelated.txt")); err != nil {
+		t.Error("pruneBackups removed a file that is not a backup")
+	}
+}
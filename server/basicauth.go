@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// basicAuth puts the routes under basicAuth.routes behind a login, for
+// small deployments that want, say, only their team to create links. Any
+// of basicAuth.users may log in, and so may the admin key, as a bearer
+// token or as the password, so the admin pages and API keep working under
+// a protected prefix.
+
+const defaultBasicAuthRealm = "shorty"
+
+func validateBasicAuth(routes []string, users map[string]string) error {
+	for _, route := range routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("basicAuth.routes entry %q must start with /", route)
+		}
+	}
+	if len(routes) > 0 && len(users) == 0 {
+		return fmt.Errorf("basicAuth.routes is set but basicAuth.users is empty")
+	}
+	return nil
+}
+
+// underPrefix reports whether path is prefix or below it. "/create"
+// covers "/create" and "/create/x" but not "/created".
+func underPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// basicAuthProtected reports whether path is under one of basicAuth.routes.
+func (s *Server) basicAuthProtected(path string) bool {
+	for _, route := range s.cfg.BasicAuth.Routes {
+		if underPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// basicAuthorized reports whether r logs in as one of basicAuth.users or
+// carries the admin key.
+func (s *Server) basicAuthorized(r *http.Request) bool {
+	if s.cfg.API.AdminKey != "" && s.authorizedDashboard(r) {
+		return true
+	}
+	_, ok := s.basicAuthUser(r)
+	return ok
+}
+
+// basicAuthUser returns the basicAuth user r logs in as.
+func (s *Server) basicAuthUser(r *http.Request) (string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	want, known := s.cfg.BasicAuth.Users[username]
+	match := subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+	return username, known && match
+}
+
+// requireBasicAuth wraps next so requests under basicAuth.routes need a
+// login.
+func (s *Server) requireBasicAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.basicAuthProtected(r.URL.Path) && !s.basicAuthorized(r) {
+			realm := s.cfg.BasicAuth.Realm
+			if realm == "" {
+				realm = defaultBasicAuthRealm
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+				return
+			}
+			httpError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
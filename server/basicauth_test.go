@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestUnderPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix string
+		want         bool
+	}{
+		{"/create", "/create", true},
+		{"/create/x", "/create", true},
+		{"/created", "/create", false},
+		{"/api/v1/shorten", "/api/", true},
+		{"/api", "/api/", true},
+		{"/", "/", true},
+		{"/_/abc123", "/", true},
+	}
+	for _, tt := range tests {
+		if got := underPrefix(tt.path, tt.prefix); got != tt.want {
+			t.Errorf("underPrefix(%q, %q) = %v want %v", tt.path, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.BasicAuth.Routes = []string{"/"}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted basicAuth.routes without basicAuth.users")
+	}
+	cfg.BasicAuth.Routes = []string{"create"}
+	cfg.BasicAuth.Users = map[string]string{"alice": "hunter2"}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted a basicAuth.routes entry without a leading /")
+	}
+
+	srv, _ := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.BasicAuth.Routes = []string{"/", "/api/"}
+	srv.cfg.BasicAuth.Users = map[string]string{"alice": "hunter2"}
+	srv.cfg.BasicAuth.Realm = "team links"
+
+	request := func(path string, auth func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if auth != nil {
+			auth(req)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("No Login", func(t *testing.T) {
+		rr := request("/", nil)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+		if got, want := rr.Header().Get("WWW-Authenticate"), `Basic realm="team links"`; got != want {
+			t.Errorf("handler returned WWW-Authenticate %q want %q", got, want)
+		}
+		checkAPIError(t, request("/api/v1/expand/abc123", nil), http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Wrong Password", func(t *testing.T) {
+		rr := request("/", func(r *http.Request) { r.SetBasicAuth("alice", "hunter3") })
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("User", func(t *testing.T) {
+		rr := request("/", func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") })
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+
+	t.Run("Admin Key", func(t *testing.T) {
+		rr := request("/admin", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") })
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+
+	t.Run("Unprotected Routes", func(t *testing.T) {
+		srv.cfg.BasicAuth.Routes = []string{"/create"}
+		if rr := request("/", nil); rr.Code != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if rr := request("/create", nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+}
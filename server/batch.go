@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// POST /api/v1/links/batch applies one change to many links at once, for
+// cleanups too large for the admin page: delete, tag, expire, disable or
+// enable. The links are named by their codes or picked by a filter, and
+// with dryRun set the answer lists what would change without changing it.
+// Every link changed lands in the audit log like a single API change.
+
+const maxBatchCodes = 1000
+
+// Batch actions.
+const (
+	batchDelete  = "delete"
+	batchTag     = "tag"
+	batchExpire  = "expire"
+	batchDisable = "disable"
+	batchEnable  = "enable"
+)
+
+// batchFilter picks links like the admin page's search: query matches the
+// short or long URL or a tag, case-insensitively, and tag a tag. CreatedBefore
+// keeps links created before then.
+type batchFilter struct {
+	Query         string     `json:"query,omitempty"`
+	Tag           string     `json:"tag,omitempty"`
+	CreatedBefore *time.Time `json:"createdBefore,omitempty"`
+}
+
+type batchRequest struct {
+	Action string       `json:"action"`
+	Codes  []string     `json:"codes,omitempty"`
+	Filter *batchFilter `json:"filter,omitempty"`
+	// Tags are added by the tag action.
+	Tags []string `json:"tags,omitempty"`
+	// ExpiresAt is when the expire action makes the links expire, now if
+	// unset.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Permanent makes the delete action skip the trash.
+	Permanent bool `json:"permanent,omitempty"`
+	DryRun    bool `json:"dryRun,omitempty"`
+}
+
+type batchResponse struct {
+	Action string `json:"action"`
+	DryRun bool   `json:"dryRun"`
+	// Codes are the links changed, or that would be in a dry run.
+	Codes []string `json:"codes"`
+	// NotFound are the requested codes with no live link.
+	NotFound []string `json:"notFound,omitempty"`
+}
+
+// validate checks req names an action, and links either by code or by a
+// filter that narrows them down.
+func (req *batchRequest) validate() error {
+	switch req.Action {
+	case batchDelete, batchExpire, batchDisable, batchEnable:
+	case batchTag:
+		tags, err := parseTags(strings.Join(req.Tags, ","))
+		if err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return errors.New("the tag action needs tags")
+		}
+		req.Tags = tags
+	default:
+		return fmt.Errorf("unknown action %q: want delete, tag, expire, disable or enable", req.Action)
+	}
+	if (len(req.Codes) > 0) == (req.Filter != nil) {
+		return errors.New("give either codes or a filter")
+	}
+	if f := req.Filter; f != nil && strings.TrimSpace(f.Query) == "" && strings.TrimSpace(f.Tag) == "" && f.CreatedBefore == nil {
+		return errors.New("the filter must set query, tag or createdBefore")
+	}
+	return nil
+}
+
+// batchCodes returns the live links req names, and the codes it names
+// that have none.
+func (s *Server) batchCodes(ctx context.Context, req batchRequest) (codes, notFound []string, err error) {
+	if req.Filter == nil {
+		seen := make(map[string]bool, len(req.Codes))
+		for _, shortURL := range req.Codes {
+			if seen[shortURL] {
+				continue
+			}
+			seen[shortURL] = true
+			if _, err := s.store.Link(ctx, shortURL); err == store.ErrNotFound {
+				notFound = append(notFound, shortURL)
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+			codes = append(codes, shortURL)
+		}
+		return codes, notFound, nil
+	}
+
+	links, err := s.adminLinks(ctx, strings.TrimSpace(req.Filter.Query), strings.TrimSpace(req.Filter.Tag))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, link := range links {
+		if before := req.Filter.CreatedBefore; before != nil && !link.CreatedAt.Before(*before) {
+			continue
+		}
+		codes = append(codes, link.ShortURL)
+	}
+	return codes, nil, nil
+}
+
+// applyBatch makes req's change to shortURL.
+func (s *Server) applyBatch(ctx context.Context, req batchRequest, shortURL string) error {
+	switch req.Action {
+	case batchDelete:
+		deleted, err := s.deleteLink(ctx, shortURL, req.Permanent)
+		if err == nil && !deleted {
+			return store.ErrNotFound
+		}
+		return err
+	case batchTag:
+		return s.addTags(ctx, shortURL, req.Tags)
+	case batchExpire:
+		at := time.Now()
+		if req.ExpiresAt != nil {
+			at = *req.ExpiresAt
+		}
+		return s.expireLink(ctx, shortURL, at)
+	default:
+		return s.setActive(ctx, shortURL, req.Action == batchEnable)
+	}
+}
+
+// handleAPIBatch serves POST /api/v1/links/batch.
+func (s *Server) handleAPIBatch(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API batch request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body is too large")
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body")
+		return
+	}
+	if len(req.Codes) > maxBatchCodes {
+		writeAPIError(w, http.StatusBadRequest, errCodeBatchTooLarge, fmt.Sprintf("At most %d codes per request, or use a filter", maxBatchCodes))
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidBatch, err.Error())
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, "api"))
+	codes, notFound, err := s.batchCodes(ctx, req)
+	if err != nil {
+		logf(ctx, "Error finding links for a batch %s: %v", req.Action, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error finding links")
+		return
+	}
+	resp := batchResponse{Action: req.Action, DryRun: req.DryRun, Codes: codes, NotFound: notFound}
+	if req.DryRun {
+		if resp.Codes == nil {
+			resp.Codes = []string{}
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	resp.Codes = []string{}
+	for _, shortURL := range codes {
+		if err := s.applyBatch(ctx, req, shortURL); err == store.ErrNotFound {
+			// Gone since it was looked up.
+			resp.NotFound = append(resp.NotFound, shortURL)
+			continue
+		} else if err != nil {
+			logf(ctx, "Error applying a batch %s to short URL %s after %d link(s): %v", req.Action, shortURL, len(resp.Codes), err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("Failed at %s after changing %d link(s)", shortURL, len(resp.Codes)))
+			return
+		}
+		resp.Codes = append(resp.Codes, shortURL)
+	}
+	logf(ctx, "Applied a batch %s to %d link(s) via API", req.Action, len(resp.Codes))
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestHandleAPIBatch(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	ctx := context.Background()
+	for _, shortURL := range []string{"promo1", "promo2", "docs"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/links/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder) batchResponse {
+		t.Helper()
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+		}
+		var resp batchResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	checkAPIError(t, batch("", `{"action": "delete", "codes": ["docs"]}`), http.StatusUnauthorized, errCodeUnauthorized)
+	for name, body := range map[string]string{
+		"Unknown Action":   `{"action": "rename", "codes": ["docs"]}`,
+		"No Links":         `{"action": "delete"}`,
+		"Codes And Filter": `{"action": "delete", "codes": ["docs"], "filter": {"query": "promo"}}`,
+		"Empty Filter":     `{"action": "delete", "filter": {}}`,
+		"Tag Without Tags": `{"action": "tag", "codes": ["docs"]}`,
+		"Tag Too Long":     `{"action": "tag", "codes": ["docs"], "tags": ["` + strings.Repeat("x", maxTagLength+1) + `"]}`,
+	} {
+		if rr := batch("secret", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	resp := decode(batch("secret", `{"action": "disable", "filter": {"query": "PROMO"}, "dryRun": true}`))
+	if !resp.DryRun || strings.Join(resp.Codes, ",") != "promo2,promo1" {
+		t.Errorf("dry run returned %+v", resp)
+	}
+	if opts, err := st.Options(ctx, "promo1"); err != nil || opts.Disabled {
+		t.Errorf("Options after a dry run returned %+v, %v want the link untouched", opts, err)
+	}
+
+	resp = decode(batch("secret", `{"action": "disable", "filter": {"query": "promo"}}`))
+	if strings.Join(resp.Codes, ",") != "promo2,promo1" {
+		t.Errorf("disable returned %+v", resp)
+	}
+	if opts, err := st.Options(ctx, "promo1"); err != nil || !opts.Disabled {
+		t.Errorf("Options after a batch disable returned %+v, %v", opts, err)
+	}
+
+	resp = decode(batch("secret", `{"action": "tag", "codes": ["docs", "missing"], "tags": ["keep"]}`))
+	if strings.Join(resp.Codes, ",") != "docs" || strings.Join(resp.NotFound, ",") != "missing" {
+		t.Errorf("tag returned %+v", resp)
+	}
+	if tags, err := st.Tags(ctx, "docs"); err != nil || strings.Join(tags, ",") != "keep" {
+		t.Errorf("Tags after a batch tag returned %v, %v", tags, err)
+	}
+
+	decode(batch("secret", `{"action": "expire", "filter": {"tag": "keep"}}`))
+	if opts, err := st.Options(ctx, "docs"); err != nil || !opts.Expired(time.Now()) {
+		t.Errorf("Options after a batch expire returned %+v, %v", opts, err)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	decode(batch("secret", `{"action": "delete", "filter": {"createdBefore": "`+future+`", "query": "promo"}}`))
+	if _, err := st.Link(ctx, "promo1"); err != store.ErrNotFound {
+		t.Errorf("Link after a batch delete returned %v, want ErrNotFound", err)
+	}
+	if count, err := st.Count(ctx); err != nil || count != 1 {
+		t.Errorf("Count after a batch delete returned %v, %v want 1", count, err)
+	}
+}
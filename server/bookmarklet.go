@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GET /shorten?url=...&key=... shortens a link in one request a browser
+// can make from a bookmark, so a bookmarklet can shorten the page it is
+// clicked on. A link made with a GET could be made by any page a user
+// visits, so the endpoint always takes an API key, passed in the query
+// since a bookmarklet can't set headers.
+
+// Formats /shorten answers in.
+const (
+	shortenText = "text"
+	shortenHTML = "html"
+)
+
+// shortenFormat picks the format a /shorten reply is written in: the
+// format parameter, or HTML for a browser and plain text for anything else.
+func shortenFormat(r *http.Request) (string, bool) {
+	switch format := r.URL.Query().Get("format"); format {
+	case shortenText, shortenHTML:
+		return format, true
+	case "":
+		if strings.Contains(r.Header.Get("Accept"), "text/html") {
+			return shortenHTML, true
+		}
+		return shortenText, true
+	}
+	return "", false
+}
+
+func (s *Server) handleShorten(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling shorten request")
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The key is in the URL, so the page must not pass it on to the
+	// sites it links to, and nothing may keep a copy.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	query := r.URL.Query()
+	format, ok := shortenFormat(r)
+	if !ok {
+		httpError(w, "format must be text or html", http.StatusBadRequest)
+		return
+	}
+	if token := query.Get("key"); token != "" && r.Header.Get("Authorization") == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	key, keyed := s.apiKeyFor(r)
+	if !keyed {
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !hasScope(key, scopeCreate) {
+		httpError(w, "This API key may not create links", http.StatusForbidden)
+		return
+	}
+
+	longURL := query.Get("url")
+	if !validLongURL(longURL) {
+		httpError(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+	if len(longURL) > 2048 {
+		httpError(w, "URL is too long", http.StatusBadRequest)
+		return
+	}
+
+	if key.MonthlyCreates > 0 {
+		month, reset := usageMonth(time.Now())
+		usage, err := s.monthUsage(r.Context(), key.Name, month)
+		if err != nil {
+			logf(r.Context(), "Error reading usage of API key %s: %v", key.Name, err)
+			httpError(w, "Error reading API key usage", http.StatusInternalServerError)
+			return
+		}
+		if usage.Creates >= key.MonthlyCreates {
+			logf(r.Context(), "API key %s is over its monthly quota of %d links", key.Name, key.MonthlyCreates)
+			w.Header().Set("Retry-After", retryAfter(reset))
+			httpError(w, "This API key has created its monthly quota of links", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	ctx := withSource(withActor(r.Context(), "key "+key.Name), sourceAPI)
+	ctx = s.withAccount(withAPIKey(ctx, key.Name), r)
+	shortURL, err := s.createLink(ctx, longURL, "", linkOptions{})
+	if err != nil {
+		if isRejected(err) {
+			logf(ctx, "Create hook rejected '%s': %v", longURL, err)
+			httpError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err == errKeyspaceExhausted {
+			w.Header().Set("Retry-After", exhaustedRetryAfter)
+			httpError(w, "No free short URL found, please try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		logf(ctx, "Error creating short URL: %v", err)
+		httpError(w, "Failed to create short URL", http.StatusInternalServerError)
+		return
+	}
+	logln(ctx, "Created short URL via bookmarklet:", shortURL)
+
+	link := s.cfg.PublicURL(r) + "/_/" + shortURL
+	if format == shortenText {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(link + "\n"))
+		return
+	}
+
+	tmpl, err := s.templates.lookup("shorten.html")
+	if err != nil {
+		logf(ctx, "Error loading shorten template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Link    string
+		LongURL string
+	}{
+		Link:    link,
+		LongURL: longURL,
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		logf(ctx, "Failed to render template: %v", err)
+		httpError(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestHandleShorten(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+	srv.cfg.API.Keys = []config.APIKey{
+		{Name: "me", Key: "my-key", MonthlyCreates: 2},
+		{Name: "stats", Key: "stats-key", Scopes: []string{scopeStats}},
+	}
+	ctx := context.Background()
+
+	shorten := func(query url.Values, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/shorten?"+query.Encode(), nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := shorten(url.Values{"url": {"https://example.com/page"}, "key": {"my-key"}}, "")
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	link := strings.TrimSpace(rr.Body.String())
+	shortURL, ok := strings.CutPrefix(link, "https://sho.rt/_/")
+	if !ok || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("handler returned %q as %s", link, rr.Header().Get("Content-Type"))
+	}
+	if opts, err := st.Options(ctx, shortURL); err != nil || opts.APIKey != "me" {
+		t.Errorf("Options returned %+v, %v want the link to belong to the key", opts, err)
+	}
+
+	rr = shorten(url.Values{"url": {"https://example.com/other"}, "key": {"my-key"}}, "text/html,application/xhtml+xml")
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "https://sho.rt/_/") || rr.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("handler returned a page without the link or leaking the key: %s", rr.Body.String())
+	}
+
+	for name, tc := range map[string]struct {
+		query url.Values
+		want  int
+	}{
+		"No Key":      {url.Values{"url": {"https://example.com"}}, http.StatusUnauthorized},
+		"Wrong Key":   {url.Values{"url": {"https://example.com"}, "key": {"nope"}}, http.StatusUnauthorized},
+		"Stats Key":   {url.Values{"url": {"https://example.com"}, "key": {"stats-key"}}, http.StatusForbidden},
+		"Invalid URL": {url.Values{"url": {"not a url"}, "key": {"my-key"}}, http.StatusBadRequest},
+		"Bad Format":  {url.Values{"url": {"https://example.com"}, "key": {"my-key"}, "format": {"xml"}}, http.StatusBadRequest},
+		"Over Quota":  {url.Values{"url": {"https://example.com/third"}, "key": {"my-key"}}, http.StatusTooManyRequests},
+	} {
+		if rr := shorten(tc.query, ""); rr.Code != tc.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", name, rr.Code, tc.want)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/shorten", nil))
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With analytics.filterBots set, visits from crawlers, link unfurlers,
+// uptime monitors and scripted clients are told apart by their user agent.
+// They still get redirected, but are counted in a bot visit count of their
+// own instead of the link's visit count, don't use up a click limit and
+// are left out of click analytics. With analytics.verifyBots also set,
+// visitors claiming to be a search engine crawler are checked with a
+// reverse and forward DNS lookup, so impostors can be told apart.
+
+// Reasons a visitor was taken for a bot, as recorded in the click log.
+const (
+	botUserAgent   = "user-agent"
+	botCrawler     = "crawler"
+	botFakeCrawler = "fake-crawler"
+)
+
+// botAgents are fragments of bot user agents, in lower case.
+var botAgents = []string{
+	"bot", "crawl", "spider", "slurp", "scrapy",
+	"facebookexternalhit", "embedly", "preview", "whatsapp", "skypeuripreview",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "java/", "okhttp", "libwww", "httpclient",
+	"headless", "phantomjs", "lighthouse", "pingdom", "uptime", "monitor", "feedfetcher",
+}
+
+// searchCrawlers maps the user agent token of search engine crawlers to
+// the domains their addresses resolve back to.
+var searchCrawlers = map[string][]string{
+	"googlebot":   {".googlebot.com", ".google.com"},
+	"bingbot":     {".search.msn.com"},
+	"applebot":    {".applebot.apple.com"},
+	"yandexbot":   {".yandex.ru", ".yandex.net", ".yandex.com"},
+	"baiduspider": {".crawl.baidu.com", ".crawl.baidu.jp"},
+}
+
+// crawlerLookupTimeout bounds the DNS lookups of one verification.
+const crawlerLookupTimeout = 2 * time.Second
+
+// maxCrawlerCache is how many verified addresses are remembered before the
+// cache starts over.
+const maxCrawlerCache = 1024
+
+// DNS lookups, replaced in tests.
+var (
+	lookupAddr = net.DefaultResolver.LookupAddr
+	lookupHost = net.DefaultResolver.LookupHost
+)
+
+// crawlerCache remembers the verdict on each address that claimed to be a
+// search engine crawler.
+type crawlerCache struct {
+	mu       sync.Mutex
+	verdicts map[string]string
+}
+
+// detectBot returns why the visitor making r looks like a bot, or "" for a
+// person or when bot filtering is off.
+func (s *Server) detectBot(r *http.Request) string {
+	if !s.cfg.Analytics.FilterBots {
+		return ""
+	}
+	agent := strings.ToLower(r.UserAgent())
+	if agent == "" {
+		return botUserAgent
+	}
+	if s.cfg.Analytics.VerifyBots {
+		for token, domains := range searchCrawlers {
+			if strings.Contains(agent, token) {
+				return s.verifyCrawler(r.Context(), remoteHost(r), token, domains)
+			}
+		}
+	}
+	for _, fragment := range botAgents {
+		if strings.Contains(agent, fragment) {
+			return botUserAgent
+		}
+	}
+	return ""
+}
+
+// verifyCrawler checks that ip reverse-resolves to one of domains and that
+// the name resolves back to ip.
+func (s *Server) verifyCrawler(ctx context.Context, ip, token string, domains []string) string {
+	s.crawlers.mu.Lock()
+	verdict, ok := s.crawlers.verdicts[ip]
+	s.crawlers.mu.Unlock()
+	if ok {
+		return verdict
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, crawlerLookupTimeout)
+	defer cancel()
+	verdict = botFakeCrawler
+	names, _ := lookupAddr(ctx, ip)
+	for _, name := range names {
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+		if !hasDomain(name, domains) {
+			continue
+		}
+		addrs, err := lookupHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr == ip {
+				verdict = botCrawler
+			}
+		}
+	}
+	if verdict == botFakeCrawler {
+		logf(ctx, "Visitor %s claims to be %s but its address doesn't belong to it", s.anonymizeIP(ip), token)
+	}
+
+	s.crawlers.mu.Lock()
+	if s.crawlers.verdicts == nil || len(s.crawlers.verdicts) >= maxCrawlerCache {
+		s.crawlers.verdicts = make(map[string]string)
+	}
+	s.crawlers.verdicts[ip] = verdict
+	s.crawlers.mu.Unlock()
+	return verdict
+}
+
+func hasDomain(name string, domains []string) bool {
+	for _, domain := range domains {
+		if strings.HasSuffix(name, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestDetectBot(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Analytics.FilterBots = true
+
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36": "",
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                    botUserAgent,
+		"facebookexternalhit/1.1": botUserAgent,
+		"curl/8.4.0":              botUserAgent,
+		"":                        botUserAgent,
+	}
+	for agent, want := range tests {
+		req := httptest.NewRequest("GET", "/_/abc123", nil)
+		req.Header.Set("User-Agent", agent)
+		if got := srv.detectBot(req); got != want {
+			t.Errorf("detectBot(%q) = %q, want %q", agent, got, want)
+		}
+	}
+
+	srv.cfg.Analytics.FilterBots = false
+	req := httptest.NewRequest("GET", "/_/abc123", nil)
+	req.Header.Set("User-Agent", "curl/8.4.0")
+	if got := srv.detectBot(req); got != "" {
+		t.Errorf("detectBot with filtering off = %q, want none", got)
+	}
+}
+
+func TestVerifyCrawler(t *testing.T) {
+	oldAddr, oldHost := lookupAddr, lookupHost
+	t.Cleanup(func() { lookupAddr, lookupHost = oldAddr, oldHost })
+	lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		switch addr {
+		case "192.0.2.1":
+			return []string{"crawl-192-0-2-1.googlebot.com."}, nil
+		case "192.0.2.2":
+			return []string{"crawl.evil.example."}, nil
+		}
+		return nil, errors.New("no PTR record")
+	}
+	lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "crawl-192-0-2-1.googlebot.com" {
+			return []string{"192.0.2.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Analytics.FilterBots = true
+	srv.cfg.Analytics.VerifyBots = true
+
+	tests := map[string]string{
+		"192.0.2.1": botCrawler,
+		"192.0.2.2": botFakeCrawler,
+		"192.0.2.3": botFakeCrawler,
+	}
+	for ip, want := range tests {
+		req := httptest.NewRequest("GET", "/_/abc123", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; Googlebot/2.1)")
+		if got := srv.detectBot(req); got != want {
+			t.Errorf("detectBot from %s = %q, want %q", ip, got, want)
+		}
+	}
+	if got := srv.crawlers.verdicts["192.0.2.1"]; got != botCrawler {
+		t.Errorf("verdict for 192.0.2.1 wasn't cached: %q", got)
+	}
+}
+
+func TestRedirectBot(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Analytics.FilterBots = true
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetOptions(ctx, "abc123", store.Options{MaxClicks: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/_/abc123", nil)
+	req.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+	}
+	// The bot is counted apart, and doesn't use up the click limit.
+	if link, err := st.Link(ctx, "abc123"); err != nil || link.VisitCount != 0 {
+		t.Errorf("link has %d visits, %v want 0", link.VisitCount, err)
+	}
+	if visits, err := st.BotVisits(ctx, "abc123"); err != nil || visits != 1 {
+		t.Errorf("link has %d bot visits, %v want 1", visits, err)
+	}
+}
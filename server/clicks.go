@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With analytics.clickLog set, every counted visit is also written to the
+// click log with its time, referrer, user agent, address and, behind a
+// proxy that sends one, country. The dashboard draws its charts and recent
+// clicks from it.
+
+// maxClickField caps the referrer and user agent kept for a click.
+const maxClickField = 512
+
+// maxCountryField caps the country kept for a click, which proxies send as
+// a two-letter code.
+const maxCountryField = 8
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// recordClick logs a counted visit to shortURL, with why the visitor looks
+// like a bot if they do, unless the visitor asked not to be tracked. It
+// goes to the click log, the analytics sink and the event bus, whichever
+// are set up. A failure is logged rather than failing the redirect.
+func (s *Server) recordClick(r *http.Request, shortURL, bot string) {
+	if (!s.cfg.Analytics.ClickLog && s.sink == nil && s.events == nil) || s.doNotTrack(r) {
+		return
+	}
+	click := store.Click{
+		ShortURL:  shortURL,
+		Referrer:  truncate(r.Referer(), maxClickField),
+		UserAgent: truncate(r.UserAgent(), maxClickField),
+		IP:        s.clientIP(r),
+		Bot:       bot,
+		At:        time.Now().UTC(),
+	}
+	if header := s.cfg.Analytics.CountryHeader; header != "" {
+		click.Country = truncate(strings.ToUpper(strings.TrimSpace(r.Header.Get(header))), maxCountryField)
+	}
+	if s.sink != nil {
+		s.sink.add(click)
+	}
+	s.events.emit(clickEvent(click))
+	if !s.cfg.Analytics.ClickLog {
+		return
+	}
+	if err := s.store.RecordClick(r.Context(), click); err != nil {
+		logf(r.Context(), "Error logging click on short URL '%s': %v", shortURL, err)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// With cluster.enabled set, several replicas can serve one database behind
+// a load balancer. Links, visit counts and click logs already live in the
+// store: visits are written as they happen rather than buffered, and
+// redirects read the store on every visit, so nothing is counted twice.
+// The caches that would serve a stale link, the Bloom filter and hot
+// links, are refused in cluster mode rather than shared, and there are no
+// shared cache backends. What would go wrong is the scheduled jobs: every
+// replica would send the digest, fire alerts and take backups. In cluster
+// mode each job first takes a lease in the store, so one replica runs it
+// at a time; if that replica goes away, another takes over once its lease
+// runs out.
+
+// newInstanceID names this replica for job leases.
+func newInstanceID(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "shorty"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// validateCluster checks that settings replicas must share are set, and
+// that none that only work on a lone instance are.
+func validateCluster(enabled bool, nodeID int, bloomFilter bool, hotLinks int, anonymizeIPs, ipHashKey string) error {
+	if nodeID < 0 || nodeID > maxNodeID {
+		return fmt.Errorf("cluster.nodeID must be between 0 and %d", maxNodeID)
+	}
+	if enabled && bloomFilter {
+		return errors.New("cache.bloomFilter can't be used in cluster mode, as it misses links made by other replicas")
+	}
+	if enabled && hotLinks > 0 {
+		return errors.New("cache.hotLinks can't be used in cluster mode, as it keeps serving links other replicas disable or change")
+	}
+	if enabled && anonymizeIPs == anonymizeHash && ipHashKey == "" {
+		return errors.New("analytics.ipHashKey must be set in cluster mode, or each replica hashes addresses differently")
+	}
+	return nil
+}
+
+// lead reports whether this replica should run job now. Outside cluster
+// mode it always should. In cluster mode it takes or renews the job's lease
+// for two runs, every being how often the job runs, so the replica holding
+// it keeps it while it is alive.
+func (s *Server) lead(ctx context.Context, job string, every time.Duration) bool {
+	if !s.cfg.Cluster.Enabled {
+		return true
+	}
+	ok, err := s.store.AcquireLease(ctx, job, s.instanceID, 2*every)
+	if err != nil {
+		log.Printf("Error taking the lease for %s: %v", job, err)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNewInstanceID(t *testing.T) {
+	if id := newInstanceID("replica-1"); id != "replica-1" {
+		t.Errorf("newInstanceID kept %q, want %q", id, "replica-1")
+	}
+	a, b := newInstanceID(""), newInstanceID("")
+	if a == "" || a == b {
+		t.Errorf("newInstanceID returned %q and %q, want two different IDs", a, b)
+	}
+}
+
+func TestValidateCluster(t *testing.T) {
+	if err := validateCluster(true, 0, false, 0, anonymizeHash, ""); err == nil {
+		t.Error("validateCluster accepted hashing with a random key in cluster mode")
+	}
+	if err := validateCluster(false, 0, false, 0, anonymizeHash, ""); err != nil {
+		t.Errorf("validateCluster outside cluster mode returned an error: %v", err)
+	}
+	if err := validateCluster(true, 0, false, 0, anonymizeHash, "shared"); err != nil {
+		t.Errorf("validateCluster with a shared key returned an error: %v", err)
+	}
+	if err := validateCluster(true, 0, false, 0, anonymizeTruncate, ""); err != nil {
+		t.Errorf("validateCluster with truncation returned an error: %v", err)
+	}
+	if err := validateCluster(true, maxNodeID+1, false, 0, anonymizeTruncate, ""); err == nil {
+		t.Error("validateCluster accepted a node ID past the largest")
+	}
+	if err := validateCluster(true, 0, true, 0, anonymizeTruncate, ""); err == nil {
+		t.Error("validateCluster accepted a Bloom filter in cluster mode")
+	}
+	if err := validateCluster(true, 0, false, 100, anonymizeTruncate, ""); err == nil {
+		t.Error("validateCluster accepted hot links in cluster mode")
+	}
+	if err := validateCluster(false, 0, false, 100, anonymizeTruncate, ""); err != nil {
+		t.Errorf("validateCluster with hot links outside cluster mode returned an error: %v", err)
+	}
+}
+
+func TestLead(t *testing.T) {
+	srv, mock := newMockServer(t)
+	ctx := context.Background()
+
+	if !srv.lead(ctx, "backups", time.Hour) {
+		t.Error("lead returned false outside cluster mode")
+	}
+
+	srv.cfg.Cluster.Enabled = true
+	srv.instanceID = "replica-1"
+	mock.ExpectExec("INSERT INTO leases").
+		WithArgs("backups", "replica-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if !srv.lead(ctx, "backups", time.Hour) {
+		t.Error("lead returned false with the lease taken")
+	}
+
+	mock.ExpectExec("INSERT INTO leases").
+		WithArgs("backups", "replica-1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if srv.lead(ctx, "backups", time.Hour) {
+		t.Error("lead returned true with the lease held elsewhere")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestNewRejectsClusterWithRandomHashKey(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	cfg := *srv.cfg
+	cfg.Cluster.Enabled = true
+	cfg.Analytics.AnonymizeIPs = anonymizeHash
+	if _, err := New(&cfg, srv.store); err == nil || !strings.Contains(err.Error(), "ipHashKey") {
+		t.Errorf("New returned %v, want an error about analytics.ipHashKey", err)
+	}
+}
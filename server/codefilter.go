@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"strings"
+)
+
+// Generated codes can spell out words nobody wants on a printed flyer, and
+// characters like 0 and O are easy to mix up when a code is read aloud.
+// shortURL.blockedWords lists words generated codes must not contain, and
+// shortURL.charset may name one of charsetPresets instead of spelling out
+// its characters. Custom aliases are chosen by people and aren't filtered.
+
+// charsetPresets are the character sets shortURL.charset can name.
+var charsetPresets = map[string]string{
+	// alphanumeric is every letter and digit.
+	"alphanumeric": "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	// unambiguous leaves out 0, O, o, 1, l and I.
+	"unambiguous": "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789",
+	// spoken is unambiguous without capitals, for codes read out loud.
+	"spoken": "abcdefghijkmnpqrstuvwxyz23456789",
+}
+
+// charset returns the characters random codes are drawn from, which the
+// source recorded in ctx may override.
+func (s *Server) charset(ctx context.Context) string {
+	charset := s.cfg.ShortURL.Charset
+	if override := s.sourceCodes(ctx).Charset; override != "" {
+		charset = override
+	}
+	if preset, ok := charsetPresets[charset]; ok {
+		return preset
+	}
+	return charset
+}
+
+// lookalikes reads digits in a code as the letters they stand in for, so
+// "a55" is caught as well as "ass".
+var lookalikes = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b")
+
+// blockedCode reports whether code contains one of shortURL.blockedWords,
+// ignoring case and reading digits as look-alike letters.
+func (s *Server) blockedCode(code string) bool {
+	if len(s.cfg.ShortURL.BlockedWords) == 0 {
+		return false
+	}
+	code = strings.ToLower(code)
+	readable := lookalikes.Replace(code)
+	for _, word := range s.cfg.ShortURL.BlockedWords {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		if strings.Contains(code, word) || strings.Contains(readable, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestBlockedCode(t *testing.T) {
+	s := &Server{cfg: &config.Config{}}
+	s.cfg.ShortURL.BlockedWords = []string{"ass", " Tit "}
+
+	tests := map[string]bool{
+		"xASSx":  true,
+		"a55ign": true,
+		"T1Tq":   true,
+		"abc123": false,
+		"":       false,
+	}
+	for code, want := range tests {
+		if got := s.blockedCode(code); got != want {
+			t.Errorf("blockedCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestCharsetPresets(t *testing.T) {
+	for name, charset := range charsetPresets {
+		if name == "alphanumeric" {
+			continue
+		}
+		if strings.ContainsAny(charset, "0O1lI") {
+			t.Errorf("preset %q contains an ambiguous character: %s", name, charset)
+		}
+	}
+
+	s := &Server{cfg: &config.Config{}}
+	s.cfg.ShortURL.Charset = "spoken"
+	if got := s.charset(context.Background()); got != charsetPresets["spoken"] {
+		t.Errorf("charset() = %q, want the spoken preset", got)
+	}
+	s.cfg.ShortURL.Charset = "abc"
+	if got := s.charset(context.Background()); got != "abc" {
+		t.Errorf("charset() = %q, want %q", got, "abc")
+	}
+}
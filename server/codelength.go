@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// As an instance fills up, random codes of the configured length collide
+// more and more often. adaptiveLength tracks how many collisions each new
+// link ran into and makes generated codes one character longer whenever
+// the average climbs past shortURL.maxCollisionRate, so creation never
+// grinds through retry after retry. The extra length is kept in memory and
+// relearned after a restart.
+//
+// Creating a single link gives up after shortURL.maxRetries attempts with
+// errKeyspaceExhausted, which callers report as 503 Service Unavailable.
+// By then the length has grown, so trying again shortly usually works.
+
+// collisionSmoothing weighs each new observation in the running average.
+const collisionSmoothing = 0.1
+
+// exhaustedRetryAfter is the Retry-After, in seconds, sent with
+// errKeyspaceExhausted.
+const exhaustedRetryAfter = "5"
+
+var errKeyspaceExhausted = errors.New("no free short URL found, try again shortly")
+
+// maxRetries returns how many codes creating one link may try.
+func (s *Server) maxRetries() int {
+	if s.cfg.ShortURL.MaxRetries <= 0 {
+		return config.DefaultMaxRetries
+	}
+	return s.cfg.ShortURL.MaxRetries
+}
+
+type adaptiveLength struct {
+	mu    sync.Mutex
+	extra int
+	rate  float64
+}
+
+// length returns the code length to generate, given the configured one.
+func (a *adaptiveLength) length(base int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return base + a.extra
+}
+
+// observe records that creating a link took collisions retries, and bumps
+// the length when the running average exceeds maxRate.
+func (a *adaptiveLength) observe(collisions int, base int, maxRate float64) {
+	if maxRate <= 0 {
+		maxRate = config.DefaultMaxCollisionRate
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rate += collisionSmoothing * (float64(collisions) - a.rate)
+	if a.rate > maxRate {
+		log.Printf("Short URL collision rate is %.2f per link, raising generated code length from %d to %d", a.rate, base+a.extra, base+a.extra+1)
+		a.extra++
+		a.rate = 0
+	}
+}
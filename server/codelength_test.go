@@ -0,0 +1,70 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAdaptiveLength(t *testing.T) {
+	t.Run("Stays at the configured length while collisions are rare", func(t *testing.T) {
+		var a adaptiveLength
+		for i := 0; i < 100; i++ {
+			a.observe(i%10/9, 6, 0.5)
+		}
+		if got := a.length(6); got != 6 {
+			t.Errorf("length = %d, want 6", got)
+		}
+	})
+
+	t.Run("Grows by one when collisions become frequent", func(t *testing.T) {
+		var a adaptiveLength
+		for i := 0; i < 10; i++ {
+			a.observe(1, 6, 0.5)
+		}
+		if got := a.length(6); got != 7 {
+			t.Errorf("length = %d, want 7", got)
+		}
+	})
+
+	t.Run("Zero threshold uses the default", func(t *testing.T) {
+		var a adaptiveLength
+		a.observe(20, 6, 0)
+		if got := a.length(6); got != 7 {
+			t.Errorf("length = %d, want 7", got)
+		}
+	})
+}
+
+func TestCreateKeyspaceExhausted(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.ShortURL.MaxRetries = 3
+	srv.cfg.ShortURL.MaxCollisionRate = 0.25
+
+	mock.ExpectQuery("SELECT short_url FROM url_mapping WHERE long_url").
+		WithArgs("https://example.com").
+		WillReturnError(sql.ErrNoRows)
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "https://example.com"}`)))
+
+	checkAPIError(t, rr, http.StatusServiceUnavailable, errCodeKeyspaceExhausted)
+	if got := rr.Header().Get("Retry-After"); got != exhaustedRetryAfter {
+		t.Errorf("Retry-After = %q, want %q", got, exhaustedRetryAfter)
+	}
+	if got := srv.codeLength.length(srv.cfg.ShortURL.Length); got != srv.cfg.ShortURL.Length+1 {
+		t.Errorf("length = %d, want %d", got, srv.cfg.ShortURL.Length+1)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
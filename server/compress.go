@@ -0,0 +1,226 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// With server.compress set, text responses such as pages and API JSON are
+// gzip or deflate compressed for clients that accept it. Responses too
+// small to gain from it, partial responses and the live stats stream are
+// sent as they are.
+
+// minCompressSize is the smallest body worth compressing.
+const minCompressSize = 1024
+
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}}
+)
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if the client accepts neither.
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				ok = false
+			}
+		}
+		if _, seen := accepted[name]; !seen {
+			accepted[name] = ok
+		}
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		if ok, listed := accepted[enc]; listed {
+			if ok {
+				return enc
+			}
+			continue
+		}
+		if accepted["*"] {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressible reports whether responses of contentType are text that
+// compresses well.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	switch {
+	case mediaType == "text/event-stream":
+		// Stream events have to reach the client one by one.
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/json",
+		mediaType == "application/javascript",
+		mediaType == "application/xml",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter compresses a response in encoding once it is known to be
+// compressible text of at least minCompressSize bytes. Until then the
+// start of the body is held back in buf.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{ResponseWriter: w, encoding: encoding}
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.decided || c.status != 0 {
+		return
+	}
+	c.status = status
+	h := c.Header()
+	// Bodiless and partial responses, bodies already encoded and types
+	// that don't compress go out untouched.
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || h.Get("Content-Encoding") != "" ||
+		(h.Get("Content-Type") != "" && !compressible(h.Get("Content-Type"))) {
+		c.passThrough()
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.decided {
+		if c.enc != nil {
+			return c.enc.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if c.Header().Get("Content-Type") == "" {
+		// As net/http would, before the first byte goes out.
+		c.Header().Set("Content-Type", http.DetectContentType(c.buf))
+		if !compressible(c.Header().Get("Content-Type")) {
+			return len(p), c.passThrough()
+		}
+	}
+	if len(c.buf) >= minCompressSize {
+		return len(p), c.startCompressing()
+	}
+	return len(p), nil
+}
+
+// passThrough sends the response uncompressed, with whatever has been
+// held back so far.
+func (c *compressWriter) passThrough() error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	_, err := c.ResponseWriter.Write(c.buf)
+	c.buf = nil
+	return err
+}
+
+func (c *compressWriter) startCompressing() error {
+	c.decided = true
+	h := c.Header()
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	// The compressed body is a different representation, so a strong ETag
+	// of the plain one no longer holds.
+	if tag := h.Get("ETag"); tag != "" && !strings.HasPrefix(tag, "W/") {
+		h.Set("ETag", "W/"+tag)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+
+	switch c.encoding {
+	case "gzip":
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(c.ResponseWriter)
+		c.enc = gz
+	default:
+		fw := flateWriters.Get().(*flate.Writer)
+		fw.Reset(c.ResponseWriter)
+		c.enc = fw
+	}
+	_, err := c.enc.Write(c.buf)
+	c.buf = nil
+	return err
+}
+
+// Flush sends what has been written so far. A response flushed before
+// the size is known is compressed, so it can carry on streaming.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		if c.status == 0 {
+			// Nothing to send yet, and flushing would commit the headers.
+			return
+		}
+		c.startCompressing()
+	}
+	switch enc := c.enc.(type) {
+	case *gzip.Writer:
+		enc.Flush()
+	case *flate.Writer:
+		enc.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response: a small body still held back goes out as
+// it is, and a compressed one is terminated.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.status == 0 {
+			// The handler wrote nothing at all.
+			return nil
+		}
+		return c.passThrough()
+	}
+	if c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	switch enc := c.enc.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	case *flate.Writer:
+		flateWriters.Put(enc)
+	}
+	c.enc = nil
+	return err
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
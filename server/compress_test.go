@@ -0,0 +1,105 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip;q=1.0", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"br", ""},
+		{"*", "gzip"},
+		{"*, gzip;q=0", "deflate"},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		if got := acceptedEncoding(tt.header); got != tt.want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	big := strings.Repeat(`{"shortURL":"abc123","visitCount":1}`, 100)
+	serve := func(encoding, contentType, body string, status int) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		cw := newCompressWriter(rr, encoding)
+		cw.Header().Set("Content-Type", contentType)
+		cw.Header().Set("ETag", `"abc"`)
+		cw.WriteHeader(status)
+		io.WriteString(cw, body)
+		cw.Close()
+		return rr
+	}
+
+	rr := serve("gzip", "application/json", big, http.StatusOK)
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("ETag") != `W/"abc"` {
+		t.Fatalf("large JSON went out with headers %v", rr.Header())
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(gz); string(got) != big {
+		t.Error("gzipped body doesn't match")
+	}
+
+	rr = serve("deflate", "text/html; charset=utf-8", big, http.StatusNotFound)
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Encoding") != "deflate" {
+		t.Fatalf("large page went out as %d with headers %v", rr.Code, rr.Header())
+	}
+	if got, _ := io.ReadAll(flate.NewReader(rr.Body)); string(got) != big {
+		t.Error("deflated body doesn't match")
+	}
+
+	for _, tt := range []struct {
+		name, contentType, body string
+	}{
+		{"small", "application/json", `{"ok":true}`},
+		{"image", "image/png", big},
+		{"stream", "text/event-stream", big},
+	} {
+		rr := serve("gzip", tt.contentType, tt.body, http.StatusOK)
+		if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != tt.body || rr.Header().Get("ETag") != `"abc"` {
+			t.Errorf("%s response was compressed or changed: %v", tt.name, rr.Header())
+		}
+	}
+}
+
+func TestServeHTTPCompress(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Server.Compress = true
+
+	req := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("OpenAPI document went out with headers %v", rr.Header())
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); !strings.Contains(string(body), `"openapi"`) {
+		t.Error("gzipped OpenAPI document doesn't decode")
+	}
+
+	req = httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "" || !strings.Contains(rr.Body.String(), `"openapi"`) {
+		t.Errorf("OpenAPI document was compressed for a client that didn't ask")
+	}
+}
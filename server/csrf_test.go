@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSRFToken(t *testing.T) {
+	t.Run("New Token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		rr := httptest.NewRecorder()
+
+		token, err := csrfToken(rr, req)
+		if err != nil {
+			t.Fatalf("csrfToken returned an error: %v", err)
+		}
+		if len(token) != csrfTokenBytes*2 {
+			t.Errorf("csrfToken returned wrong length: got %v want %v", len(token), csrfTokenBytes*2)
+		}
+
+		cookies := rr.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != csrfCookieName || cookies[0].Value != token {
+			t.Errorf("csrfToken did not set the expected cookie: %v", cookies)
+		}
+	})
+
+	t.Run("Existing Token", func(t *testing.T) {
+		existing := strings.Repeat("cd", csrfTokenBytes)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: existing})
+		rr := httptest.NewRecorder()
+
+		token, err := csrfToken(rr, req)
+		if err != nil {
+			t.Fatalf("csrfToken returned an error: %v", err)
+		}
+		if token != existing {
+			t.Errorf("csrfToken did not reuse the cookie: got %v want %v", token, existing)
+		}
+		if len(rr.Result().Cookies()) != 0 {
+			t.Error("csrfToken should not reset an existing cookie")
+		}
+	})
+}
+
+func TestValidCSRF(t *testing.T) {
+	token := strings.Repeat("ab", csrfTokenBytes)
+
+	tests := []struct {
+		name   string
+		cookie string
+		field  string
+		want   bool
+	}{
+		{"Matching", token, token, true},
+		{"Mismatched", token, strings.Repeat("cd", csrfTokenBytes), false},
+		{"Missing Field", token, "", false},
+		{"Missing Cookie", "", token, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/create", strings.NewReader(csrfFieldName+"="+tt.field))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if got := validCSRF(req); got != tt.want {
+				t.Errorf("validCSRF returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// /dashboard is a live view for admins: clicks per hour over the last day
+// and per day over the last month, the most clicked links and the latest
+// clicks, kept up to date from the stats stream. Browsers log in with HTTP
+// basic auth, using the admin key as the password. The dashboard is off
+// while no admin key is set.
+
+const (
+	dashboardHours        = 24
+	dashboardDays         = 30
+	dashboardRecentClicks = 20
+)
+
+// chartBar is one interval of a dashboard chart. Height is a percentage of
+// the busiest interval.
+type chartBar struct {
+	Key    string
+	Label  string
+	Clicks int
+	Height int
+}
+
+// dashboardPage is the data for dashboard.html.
+type dashboardPage struct {
+	store.Stats
+	ClickLog     bool
+	Hourly       []chartBar
+	Daily        []chartBar
+	RecentClicks []store.Click
+	Flagged      []flaggedLink
+}
+
+// chartBars lays series out as n intervals of step from start, filling in
+// the ones without clicks.
+func chartBars(series []store.ClickCount, start time.Time, step time.Duration, n int, key, label string) []chartBar {
+	counts := make(map[time.Time]int, len(series))
+	for _, count := range series {
+		counts[count.Time.UTC()] = count.Clicks
+	}
+	bars := make([]chartBar, n)
+	most := 0
+	for i := range bars {
+		t := start.Add(time.Duration(i) * step)
+		bars[i] = chartBar{Key: t.Format(key), Label: t.Format(label), Clicks: counts[t]}
+		if bars[i].Clicks > most {
+			most = bars[i].Clicks
+		}
+	}
+	if most > 0 {
+		for i := range bars {
+			bars[i].Height = bars[i].Clicks * 100 / most
+		}
+	}
+	return bars
+}
+
+// authorizedDashboard reports whether r carries the admin key, either as a
+// bearer token or as the basic auth password.
+func (s *Server) authorizedDashboard(r *http.Request) bool {
+	if s.authorizedAdmin(r) {
+		return true
+	}
+	_, password, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg.API.AdminKey)) == 1
+}
+
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling dashboard request")
+	if s.cfg.API.AdminKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty dashboard"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	stats, err := s.stats(r.Context())
+	if err != nil {
+		logf(r.Context(), "Error fetching stats: %v", err)
+		httpError(w, "Error fetching stats", http.StatusInternalServerError)
+		return
+	}
+	page := dashboardPage{Stats: stats, ClickLog: s.cfg.Analytics.ClickLog, Flagged: s.anomalies.list(time.Now())}
+
+	// Charts and recent clicks come from the click log; a failure leaves
+	// them empty rather than taking the page down.
+	if page.ClickLog {
+		now := time.Now().UTC()
+		hourStart := now.Truncate(time.Hour).Add(-(dashboardHours - 1) * time.Hour)
+		hourly, err := s.store.ClickSeries(r.Context(), "", hourStart, store.Hourly)
+		if err != nil {
+			logf(r.Context(), "Error fetching hourly clicks: %v", err)
+		}
+		page.Hourly = chartBars(hourly, hourStart, time.Hour, dashboardHours, "2006-01-02T15", "15:04")
+
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		dayStart := today.AddDate(0, 0, -(dashboardDays - 1))
+		daily, err := s.store.ClickSeries(r.Context(), "", dayStart, store.Daily)
+		if err != nil {
+			logf(r.Context(), "Error fetching daily clicks: %v", err)
+		}
+		page.Daily = chartBars(daily, dayStart, 24*time.Hour, dashboardDays, "2006-01-02", "Jan 2")
+
+		if page.RecentClicks, err = s.store.RecentClicks(r.Context(), "", dashboardRecentClicks); err != nil {
+			logf(r.Context(), "Error fetching recent clicks: %v", err)
+		}
+	}
+
+	tmpl, err := s.templates.lookup("dashboard.html")
+	if err != nil {
+		logf(r.Context(), "Error loading dashboard template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	// The page is per-admin and goes stale quickly.
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(r.Context(), "Error executing dashboard template: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func expectStats(mock sqlmock.Sqlmock) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	mock.ExpectQuery("SELECT COUNT.*, COALESCE.*FROM url_mapping").WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).AddRow(10, 100))
+	mock.ExpectQuery("SELECT COALESCE.*FROM url_mapping WHERE created_at >= .*").WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(5))
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at FROM url_mapping .* ORDER BY visit_count DESC LIMIT").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("abc123", "https://example.com", 50, now))
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at FROM url_mapping .* ORDER BY created_at DESC LIMIT").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("abc123", "https://example.com", 50, now))
+}
+
+func TestHandleDashboard(t *testing.T) {
+	t.Run("Off Without Admin Key", func(t *testing.T) {
+		srv, _ := newMemoryServer(t)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/dashboard", nil))
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Wrong Password", func(t *testing.T) {
+		srv, _ := newMemoryServer(t)
+		srv.cfg.API.AdminKey = "secret"
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		req.SetBasicAuth("admin", "guess")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+		if rr.Header().Get("WWW-Authenticate") == "" {
+			t.Error("handler did not ask for basic auth")
+		}
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		srv, mock := newMockServer(t)
+		srv.cfg.API.AdminKey = "secret"
+		srv.cfg.Analytics.ClickLog = true
+
+		hour := time.Now().UTC().Truncate(time.Hour)
+		expectStats(mock)
+		mock.ExpectQuery("FROM clicks").
+			WithArgs("%Y-%m-%d %H:00:00", sqlmock.AnyArg(), "", "", "hour", sqlmock.AnyArg(), "", "").
+			WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).AddRow(hour.Format("2006-01-02 15:04:05"), 7))
+		mock.ExpectQuery("FROM clicks").
+			WithArgs("%Y-%m-%d 00:00:00", sqlmock.AnyArg(), "", "", "day", sqlmock.AnyArg(), "", "").
+			WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}))
+		mock.ExpectQuery("SELECT short_url, referrer, user_agent, ip, country, clicked_at FROM clicks").
+			WithArgs("", "", dashboardRecentClicks).
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "referrer", "user_agent", "ip", "country", "clicked_at"}).
+				AddRow("abc123", "https://news.example", "", "", "", "2024-01-02 03:04:05"))
+
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		for _, want := range []string{"https://news.example", `data-key="` + hour.Format("2006-01-02T15") + `" data-clicks="7"`, `data-visits="abc123"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("dashboard is missing %q", want)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Escapes Links", func(t *testing.T) {
+		srv, st := newMemoryServer(t)
+		srv.cfg.API.AdminKey = "secret"
+		srv.cfg.Analytics.ClickLog = true
+		ctx := context.Background()
+		if err := st.Create(ctx, "abc123", `https://example.com/"><script>alert(1)</script>`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := st.RecordVisit(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.RecordClick(ctx, store.Click{ShortURL: "abc123", Referrer: "<script>alert(2)</script>", At: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("GET", "/dashboard", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		if strings.Contains(body, "<script>alert") {
+			t.Error("dashboard has an unescaped long URL or referrer")
+		}
+		if !strings.Contains(body, "&lt;script&gt;alert(1)") || !strings.Contains(body, "&lt;script&gt;alert(2)") {
+			t.Error("dashboard is missing the escaped long URL or referrer")
+		}
+	})
+}
+
+func TestChartBars(t *testing.T) {
+	start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	series := []store.ClickCount{
+		{Time: start.Add(time.Hour), Clicks: 4},
+		{Time: start.Add(2 * time.Hour), Clicks: 2},
+	}
+	bars := chartBars(series, start, time.Hour, 3, "2006-01-02T15", "15:04")
+
+	want := []chartBar{
+		{Key: "2024-01-02T10", Label: "10:00", Clicks: 0, Height: 0},
+		{Key: "2024-01-02T11", Label: "11:00", Clicks: 4, Height: 100},
+		{Key: "2024-01-02T12", Label: "12:00", Clicks: 2, Height: 50},
+	}
+	for i := range want {
+		if bars[i] != want[i] {
+			t.Errorf("bar %d = %+v, want %+v", i, bars[i], want[i])
+		}
+	}
+}
+
+func TestRedirectNoAnalytics(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Analytics.ClickLog = true
+
+	// Neither the visit count nor the click log is touched.
+	mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+		WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"long_url"}).AddRow("https://example.com"))
+	expectOptions(mock, "abc123", store.Options{NoAnalytics: true})
+	expectNoVariants(mock, "abc123")
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/abc123", nil))
+
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+	}
+	if location := rr.Header().Get("Location"); location != "https://example.com" {
+		t.Errorf("handler redirected to %q want %q", location, "https://example.com")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRedirectRecordsClick(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Analytics.ClickLog = true
+	srv.cfg.Analytics.CountryHeader = "CF-IPCountry"
+
+	mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+		WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"long_url"}).AddRow("https://example.com"))
+	expectOptions(mock, "abc123", store.Options{})
+	expectNoVariants(mock, "abc123")
+	mock.ExpectExec("UPDATE url_mapping SET visit_count").
+		WithArgs("abc123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO clicks").
+		WithArgs("abc123", "https://news.example", "test-agent", "192.0.2.1", "NL", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := httptest.NewRequest("GET", "/_/abc123", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Referer", "https://news.example")
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("CF-IPCountry", "nl")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With digest.to and the email section set, a summary of the past week
+// goes out every digest.weekday at digest.hour UTC: new links, clicks, the
+// most clicked links and broken targets. It is rendered from digest.txt,
+// which themes can override like the pages. Admins can preview it with
+// GET /api/v1/admin/digest and send it straight away with POST.
+
+const (
+	digestPeriod   = 7 * 24 * time.Hour
+	digestNewLinks = 20
+	digestTopLinks = 10
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// digestWeekday returns digest.weekday, Monday when it is unset.
+func digestWeekday(name string) (time.Weekday, error) {
+	if name == "" {
+		return time.Monday, nil
+	}
+	weekday, ok := weekdays[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown digest.weekday %q", name)
+	}
+	return weekday, nil
+}
+
+// validateDigest checks digest.weekday and digest.hour.
+func validateDigest(weekday string, hour int) error {
+	if _, err := digestWeekday(weekday); err != nil {
+		return err
+	}
+	if hour < 0 || hour > 23 {
+		return fmt.Errorf("digest.hour must be between 0 and 23, got %d", hour)
+	}
+	return nil
+}
+
+// nextDigest returns the first weekday at hour:00 UTC after now.
+func nextDigest(now time.Time, weekday time.Weekday, hour int) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+	next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// digestData is the data for digest.txt.
+type digestData struct {
+	Since, Until time.Time
+	BaseURL      string
+	NewLinks     []store.LinkStats
+	NewLinkCount int
+	MoreNewLinks int
+	// ClickLog is set when Clicks and TopLinks cover the week. Without the
+	// click log they are all-time figures.
+	ClickLog    bool
+	Clicks      int
+	TopLinks    []store.LinkClicks
+	BrokenLinks []store.BrokenLink
+	TotalLinks  int
+}
+
+// buildDigest gathers the figures for the week up to until.
+func (s *Server) buildDigest(ctx context.Context, until time.Time) (digestData, error) {
+	data := digestData{
+		Since:    until.Add(-digestPeriod).UTC(),
+		Until:    until.UTC(),
+		BaseURL:  strings.TrimSuffix(s.cfg.Server.PublicURL, "/"),
+		ClickLog: s.cfg.Analytics.ClickLog,
+	}
+
+	stats, err := s.store.Stats(ctx)
+	if err != nil {
+		return data, fmt.Errorf("error fetching stats: %v", err)
+	}
+	data.TotalLinks = stats.TotalLinks
+
+	newLinks, err := s.store.LinksSince(ctx, data.Since)
+	if err != nil {
+		return data, fmt.Errorf("error fetching new links: %v", err)
+	}
+	data.NewLinkCount = len(newLinks)
+	if len(newLinks) > digestNewLinks {
+		data.MoreNewLinks = len(newLinks) - digestNewLinks
+		newLinks = newLinks[:digestNewLinks]
+	}
+	data.NewLinks = newLinks
+
+	if data.ClickLog {
+		series, err := s.store.ClickSeries(ctx, "", data.Since, store.Daily)
+		if err != nil {
+			return data, fmt.Errorf("error fetching clicks: %v", err)
+		}
+		for _, count := range series {
+			data.Clicks += count.Clicks
+		}
+		if data.TopLinks, err = s.store.TopLinks(ctx, data.Since, digestTopLinks); err != nil {
+			return data, fmt.Errorf("error fetching top links: %v", err)
+		}
+	} else {
+		data.Clicks = stats.TotalClicks
+		for _, link := range stats.MostClickedLinks {
+			if len(data.TopLinks) == digestTopLinks || link.VisitCount == 0 {
+				break
+			}
+			data.TopLinks = append(data.TopLinks, store.LinkClicks{ShortURL: link.ShortURL, LongURL: link.LongURL, Clicks: link.VisitCount})
+		}
+	}
+
+	if data.BrokenLinks, err = s.store.BrokenLinks(ctx); err != nil {
+		return data, fmt.Errorf("error fetching broken links: %v", err)
+	}
+	return data, nil
+}
+
+// renderDigest builds the digest for the week up to until and returns its
+// subject and body.
+func (s *Server) renderDigest(ctx context.Context, until time.Time) (string, string, error) {
+	data, err := s.buildDigest(ctx, until)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl, err := s.templates.lookup("digest.txt")
+	if err != nil {
+		return "", "", err
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", "", fmt.Errorf("error rendering digest: %v", err)
+	}
+	subject := fmt.Sprintf("shorty weekly digest: %d new link(s), %d click(s)", data.NewLinkCount, data.Clicks)
+	return subject, body.String(), nil
+}
+
+// sendDigest emails the digest for the week up to now to digest.to.
+func (s *Server) sendDigest(ctx context.Context) error {
+	subject, body, err := s.renderDigest(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := s.sendEmail(s.cfg.Digest.To, subject, body); err != nil {
+		return fmt.Errorf("error sending digest: %v", err)
+	}
+	logf(ctx, "Sent the weekly digest to %d recipient(s)", len(s.cfg.Digest.To))
+	return nil
+}
+
+// startDigest sends the digest every week until ctx is cancelled. It does
+// nothing without recipients or an SMTP server.
+func (s *Server) startDigest(ctx context.Context) {
+	if len(s.cfg.Digest.To) == 0 {
+		return
+	}
+	if !s.emailConfigured() {
+		log.Println("digest.to is set but email.host or email.from isn't, not sending digests")
+		return
+	}
+	weekday, _ := digestWeekday(s.cfg.Digest.Weekday)
+	log.Printf("Sending the weekly digest on %ss at %02d:00 UTC", weekday, s.cfg.Digest.Hour)
+
+	go func() {
+		for {
+			timer := time.NewTimer(time.Until(nextDigest(time.Now(), weekday, s.cfg.Digest.Hour)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				// The lease outlives the few seconds between replicas
+				// waking up, and is long gone by next week.
+				if !s.lead(ctx, "digest", time.Hour) {
+					continue
+				}
+				s.jobs.submit(&job{name: "weekly digest", attempts: 3, run: s.sendDigest})
+			}
+		}
+	}()
+}
+
+// handleAdminDigest serves GET /api/v1/admin/digest, which previews this
+// week's digest, and POST, which sends it to digest.to now.
+func (s *Server) handleAdminDigest(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling digest request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subject, body, err := s.renderDigest(r.Context(), time.Now())
+		if err != nil {
+			logf(r.Context(), "Error rendering digest: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error rendering digest")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Subject: %s\n\n%s", subject, body)
+
+	case http.MethodPost:
+		if !s.emailConfigured() || len(s.cfg.Digest.To) == 0 {
+			writeAPIError(w, http.StatusServiceUnavailable, errCodeEmailNotConfigured, "Email or digest recipients are not configured")
+			return
+		}
+		if err := s.sendDigest(r.Context()); err != nil {
+			logf(r.Context(), "Error sending digest: %v", err)
+			writeAPIError(w, http.StatusBadGateway, errCodeInternal, "Error sending digest")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestNextDigest(t *testing.T) {
+	// 2024-01-03 is a Wednesday.
+	now := time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		weekday time.Weekday
+		hour    int
+		want    time.Time
+	}{
+		{time.Monday, 8, time.Date(2024, 1, 8, 8, 0, 0, 0, time.UTC)},
+		{time.Wednesday, 11, time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC)},
+		{time.Wednesday, 10, time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)},
+		{time.Saturday, 0, time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextDigest(now, tt.weekday, tt.hour); !got.Equal(tt.want) {
+			t.Errorf("nextDigest(%v, %d) = %v, want %v", tt.weekday, tt.hour, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDigest(t *testing.T) {
+	if err := validateDigest("", 0); err != nil {
+		t.Errorf("validateDigest of the defaults returned an error: %v", err)
+	}
+	if err := validateDigest("Friday", 23); err != nil {
+		t.Errorf("validateDigest returned an error: %v", err)
+	}
+	if err := validateDigest("someday", 8); err == nil {
+		t.Error("validateDigest accepted an unknown weekday")
+	}
+	if err := validateDigest("monday", 24); err == nil {
+		t.Error("validateDigest accepted hour 24")
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	if err := srv.sendEmail([]string{"ops@example.com"}, "Hi", "Body"); err == nil {
+		t.Error("sendEmail without an SMTP server returned no error")
+	}
+
+	srv.cfg.Email.Host = "smtp.example.com"
+	srv.cfg.Email.From = "shorty@example.com"
+	var addr, from string
+	var to []string
+	var msg []byte
+	sendMail = func(a string, auth smtp.Auth, f string, t []string, m []byte) error {
+		addr, from, to, msg = a, f, t, m
+		return nil
+	}
+	t.Cleanup(func() { sendMail = smtp.SendMail })
+
+	if err := srv.sendEmail([]string{"ops@example.com", "boss@example.com"}, "Weekly\r\nBcc: evil@example.com", "line one\nline two\n"); err != nil {
+		t.Fatalf("sendEmail returned an error: %v", err)
+	}
+	if addr != "smtp.example.com:587" || from != "shorty@example.com" || len(to) != 2 {
+		t.Errorf("sendEmail sent to %s from %s to %v", addr, from, to)
+	}
+	message := string(msg)
+	if !strings.Contains(message, "To: ops@example.com, boss@example.com\r\n") {
+		t.Errorf("message is missing the To header:\n%s", message)
+	}
+	if strings.Contains(message, "\r\nBcc:") {
+		t.Errorf("subject added a header:\n%s", message)
+	}
+	if !strings.HasSuffix(message, "\r\n\r\nline one\r\nline two\r\n") {
+		t.Errorf("message body doesn't use CRLF line endings:\n%q", message)
+	}
+}
+
+func expectDigest(mock sqlmock.Sqlmock) {
+	expectStats(mock)
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at FROM url_mapping WHERE created_at >=").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("fresh1", "https://example.com/new", 3, time.Now().Format("2006-01-02 15:04:05")))
+	mock.ExpectQuery("FROM link_health").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "status_code", "error", "checked_at"}).
+			AddRow("dead12", "https://example.com/gone", 404, "", time.Now().Format("2006-01-02 15:04:05")))
+}
+
+func TestHandleAdminDigest(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+
+	digest := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/v1/admin/digest", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/admin/digest", nil))
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Preview", func(t *testing.T) {
+		expectDigest(mock)
+		rr := digest("GET")
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		for _, want := range []string{
+			"Subject: shorty weekly digest: 1 new link(s), 100 click(s)",
+			"NEW LINKS: 1",
+			"https://sho.rt/_/fresh1 -> https://example.com/new",
+			"TOP LINKS OF ALL TIME",
+			"50  https://sho.rt/_/abc123 -> https://example.com",
+			"https://sho.rt/_/dead12 -> https://example.com/gone (HTTP 404)",
+			"10 links in total.",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("digest is missing %q:\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("Send Without Email", func(t *testing.T) {
+		checkAPIError(t, digest("POST"), http.StatusServiceUnavailable, errCodeEmailNotConfigured)
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		srv.cfg.Email.Host = "smtp.example.com"
+		srv.cfg.Email.From = "shorty@example.com"
+		srv.cfg.Digest.To = []string{"ops@example.com"}
+		var sent []byte
+		sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+			sent = msg
+			return nil
+		}
+		t.Cleanup(func() { sendMail = smtp.SendMail })
+
+		expectDigest(mock)
+		if status := digest("POST").Code; status != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+		if !strings.Contains(string(sent), "NEW LINKS: 1") {
+			t.Errorf("sent digest is missing its body:\n%s", sent)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBuildDigestFromClickLog(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Analytics.ClickLog = true
+
+	expectStats(mock)
+	mock.ExpectQuery("WHERE created_at >=").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}))
+	mock.ExpectQuery("FROM clicks").
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).
+			AddRow("2024-01-01 00:00:00", 4).AddRow("2024-01-02 00:00:00", 6))
+	mock.ExpectQuery("JOIN url_mapping m").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), digestTopLinks).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "clicks"}).AddRow("abc123", "https://example.com", 9))
+	mock.ExpectQuery("FROM link_health").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "status_code", "error", "checked_at"}))
+
+	data, err := srv.buildDigest(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("buildDigest returned an error: %v", err)
+	}
+	if data.Clicks != 10 || len(data.TopLinks) != 1 || data.TopLinks[0].Clicks != 9 {
+		t.Errorf("buildDigest returned %+v", data)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Discord notifications post short messages to a channel webhook when
+// something worth a human's attention happens to a link, for teams that
+// don't run a metrics stack.
+
+var discordClient = &http.Client{Timeout: 10 * time.Second}
+
+type discordMessage struct {
+	Content string `json:"content"`
+	// AllowedMentions is sent empty, so nothing in a message, such as the
+	// reason a visitor gave when reporting a link, pings anyone.
+	AllowedMentions discordMentions `json:"allowed_mentions"`
+}
+
+type discordMentions struct {
+	Parse []string `json:"parse"`
+}
+
+// postDiscord sends content to the configured webhook.
+func postDiscord(ctx context.Context, webhookURL, content string) error {
+	body, err := json.Marshal(discordMessage{Content: content, AllowedMentions: discordMentions{Parse: []string{}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := discordClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("discord webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// notifyDiscord posts content on the job pool so a slow webhook never
+// delays the request that triggered it, retrying a few times if Discord
+// fails. It does nothing when no webhook is configured.
+func (s *Server) notifyDiscord(content string) {
+	webhookURL := s.cfg.Integrations.Discord.WebhookURL
+	if webhookURL == "" {
+		return
+	}
+	s.jobs.submit(&job{
+		name:     "Discord notification",
+		attempts: 3,
+		run: func(ctx context.Context) error {
+			return postDiscord(ctx, webhookURL, content)
+		},
+	})
+}
+
+// isMilestone reports whether count is one of the configured click
+// milestones.
+func (s *Server) isMilestone(count int) bool {
+	for _, m := range s.cfg.Integrations.Discord.Milestones {
+		if count == m {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMilestone reads the visit count of a link that was just visited and
+// announces it when it lands exactly on a milestone. The count is read after
+// the increment, so under heavy concurrent traffic a milestone can
+// occasionally be skipped; it is never announced twice.
+func (s *Server) checkMilestone(ctx context.Context, baseURL, shortURL string) {
+	if s.cfg.Integrations.Discord.WebhookURL == "" || len(s.cfg.Integrations.Discord.Milestones) == 0 {
+		return
+	}
+	link, err := s.store.Link(ctx, shortURL)
+	if err != nil {
+		logf(ctx, "Error checking click milestone for '%s': %v", shortURL, err)
+		return
+	}
+	if s.isMilestone(link.VisitCount) {
+		logf(ctx, "Short URL '%s' reached %d clicks", shortURL, link.VisitCount)
+		s.notifyDiscord(fmt.Sprintf("%s/_/%s reached %d clicks (%s)", baseURL, shortURL, link.VisitCount, link.LongURL))
+	}
+}
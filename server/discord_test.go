@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCheckMilestone(t *testing.T) {
+	srv, mock := newMockServer(t)
+	startJobs(t, srv)
+
+	messages := make(chan discordMessage, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg discordMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		messages <- msg
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	srv.cfg.Integrations.Discord.WebhookURL = webhook.URL
+	srv.cfg.Integrations.Discord.Milestones = []int{100, 1000}
+
+	t.Run("Milestone Reached", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+				AddRow("abc123", "https://example.com", 100, time.Now().Format("2006-01-02 15:04:05")))
+
+		srv.checkMilestone(context.Background(), "https://sho.rt", "abc123")
+
+		select {
+		case msg := <-messages:
+			if !strings.Contains(msg.Content, "https://sho.rt/_/abc123 reached 100 clicks") {
+				t.Errorf("Unexpected notification: %v", msg.Content)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the Discord notification")
+		}
+	})
+
+	t.Run("Between Milestones", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+				AddRow("abc123", "https://example.com", 101, time.Now().Format("2006-01-02 15:04:05")))
+
+		srv.checkMilestone(context.Background(), "https://sho.rt", "abc123")
+
+		select {
+		case msg := <-messages:
+			t.Errorf("Unexpected notification: %v", msg.Content)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPostDiscordError(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unknown Webhook", http.StatusNotFound)
+	}))
+	defer webhook.Close()
+
+	if err := postDiscord(context.Background(), webhook.URL, "hello"); err == nil {
+		t.Error("Expected an error for a rejected webhook")
+	}
+}
+
+func TestFlagLinkNotifiesDiscord(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	startJobs(t, srv)
+
+	messages := make(chan []byte, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read webhook body: %v", err)
+		}
+		messages <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer webhook.Close()
+
+	srv.cfg.Integrations.Discord.WebhookURL = webhook.URL
+	srv.cfg.Server.PublicURL = "https://sho.rt/"
+	srv.cfg.Moderation.Enabled = true
+	srv.OnCreate(holdSuspicious)
+
+	if rr := createAliasViaAPI(srv, "https://suspicious.example/", "held01"); rr.Code != http.StatusCreated {
+		t.Fatalf("creating a held link returned %v: %s", rr.Code, rr.Body)
+	}
+
+	select {
+	case body := <-messages:
+		var msg discordMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("Failed to decode webhook body: %v", err)
+		}
+		if want := "https://sho.rt/_/held01 was held for review (policy): on a blocklist"; msg.Content != want {
+			t.Errorf("Unexpected notification: got %q want %q", msg.Content, want)
+		}
+		if !strings.Contains(string(body), `"allowed_mentions":{"parse":[]}`) {
+			t.Errorf("Notification doesn't turn off mentions: %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the Discord notification")
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Creating a link only reuses an existing one for exactly the same long
+// URL, so links to one page pile up under trivially different spellings:
+// a trailing slash, an upper case host, trackers, query parameters in
+// another order. /admin/duplicates groups links by their normalized
+// destination and merges a group into the one link an admin keeps. The
+// merged codes are deleted or, to keep links already handed out working,
+// left as redirects to the kept link, so their visits count there from
+// then on.
+
+// normalizeTarget returns the form of longURL links are grouped by: the
+// scheme and host lowercased, default ports, trackers, fragments and
+// trailing slashes dropped, and query parameters sorted. URLs it can't
+// parse come back unchanged.
+func normalizeTarget(longURL string) string {
+	u, err := url.Parse(stripTracking(strings.TrimSpace(longURL)))
+	if err != nil || u.Host == "" {
+		return longURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	u.Host = host
+	if port != "" {
+		u.Host += ":" + port
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.RawQuery = u.Query().Encode()
+	u.ForceQuery = false
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
+
+// duplicateGroup is a set of links whose destinations normalize the same,
+// most visited first.
+type duplicateGroup struct {
+	Target string
+	Links  []store.LinkStats
+}
+
+// duplicatesPage is the data for duplicates.html.
+type duplicatesPage struct {
+	Groups    []duplicateGroup
+	Message   string
+	Error     string
+	CSRFToken string
+}
+
+// duplicateGroups returns every group of two or more live links with the
+// same normalized destination, the groups with the most links first.
+func (s *Server) duplicateGroups(ctx context.Context) ([]duplicateGroup, error) {
+	links, err := s.store.Links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byTarget := make(map[string][]store.LinkStats)
+	for _, link := range links {
+		target := normalizeTarget(link.LongURL)
+		byTarget[target] = append(byTarget[target], link)
+	}
+
+	var groups []duplicateGroup
+	for target, links := range byTarget {
+		if len(links) < 2 {
+			continue
+		}
+		sort.SliceStable(links, func(i, j int) bool { return links[i].VisitCount > links[j].VisitCount })
+		groups = append(groups, duplicateGroup{Target: target, Links: links})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Links) != len(groups[j].Links) {
+			return len(groups[i].Links) > len(groups[j].Links)
+		}
+		return groups[i].Target < groups[j].Target
+	})
+	return groups, nil
+}
+
+// mergeLinks folds codes into keep, which must all lead to the same
+// normalized destination. The tags of the merged links are added to keep.
+// With redirect set the merged links are pointed at keepURL, keep's
+// public short URL, instead of being deleted. It returns how many links it
+// merged.
+func (s *Server) mergeLinks(ctx context.Context, keep, keepURL string, codes []string, redirect bool) (int, error) {
+	kept, err := s.store.Link(ctx, keep)
+	if err != nil {
+		return 0, err
+	}
+	target := normalizeTarget(kept.LongURL)
+	tags, err := s.store.Tags(ctx, keep)
+	if err != nil {
+		return 0, err
+	}
+	addedTags := false
+
+	var others []string
+	for _, shortURL := range codes {
+		if shortURL != keep {
+			others = append(others, shortURL)
+		}
+	}
+	n, err := s.eachAdminLink(ctx, others, func(shortURL string) error {
+		link, err := s.store.Link(ctx, shortURL)
+		if err != nil {
+			return err
+		}
+		if normalizeTarget(link.LongURL) != target {
+			return fmt.Errorf("%s does not lead to %s", shortURL, target)
+		}
+		merged, err := s.store.Tags(ctx, shortURL)
+		if err != nil {
+			return err
+		}
+		if len(merged) > 0 {
+			tags, addedTags = append(tags, merged...), true
+		}
+		if !redirect {
+			deleted, err := s.deleteLink(ctx, shortURL, false)
+			if err == nil && !deleted {
+				return store.ErrNotFound
+			}
+			return err
+		}
+		before := s.auditSnapshot(ctx, shortURL)
+		if err := s.store.SetLongURL(ctx, shortURL, keepURL); err != nil {
+			return err
+		}
+		if before != nil {
+			s.audit(ctx, shortURL, store.AuditUpdate, before, s.auditSnapshot(ctx, shortURL))
+		}
+		return nil
+	})
+	if !addedTags {
+		return n, err
+	}
+	before := s.auditSnapshot(ctx, keep)
+	if tagErr := s.store.SetTags(ctx, keep, dedupeTags(tags)); tagErr != nil && err == nil {
+		return n, tagErr
+	}
+	if before != nil {
+		s.audit(ctx, keep, store.AuditUpdate, before, s.auditSnapshot(ctx, keep))
+	}
+	return n, err
+}
+
+// dedupeTags drops repeated tags, keeping the first of each.
+func dedupeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var kept []string
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling duplicates request")
+	if s.cfg.API.AdminKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showDuplicates(w, r)
+	case http.MethodPost:
+		s.mergeDuplicates(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showDuplicates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := duplicatesPage{Message: r.URL.Query().Get("message"), Error: r.URL.Query().Get("error")}
+
+	var err error
+	if page.Groups, err = s.duplicateGroups(ctx); err != nil {
+		logf(ctx, "Error fetching duplicate links: %v", err)
+		httpError(w, "Error fetching links", http.StatusInternalServerError)
+		return
+	}
+
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("duplicates.html")
+	if err != nil {
+		logf(ctx, "Error loading duplicates template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing duplicates template: %v", err)
+	}
+}
+
+// mergeDuplicates merges a group posted from the duplicates page, then
+// sends the browser back to the report.
+func (s *Server) mergeDuplicates(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected duplicates request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, "admin"))
+	back := func(param, note string) {
+		http.Redirect(w, r, "/admin/duplicates?"+url.Values{param: {note}}.Encode(), http.StatusSeeOther)
+	}
+
+	keep := r.PostFormValue("keep")
+	codes := r.PostForm["code"]
+	if keep == "" || len(codes) < 2 {
+		back("error", "Pick the link to keep and at least one to merge into it")
+		return
+	}
+	redirect := r.PostFormValue("redirect") == "true"
+	n, err := s.mergeLinks(ctx, keep, s.cfg.PublicURL(r)+"/_/"+keep, codes, redirect)
+	switch {
+	case err == store.ErrNotFound:
+		back("error", "Short URL "+keep+" not found")
+	case err != nil:
+		logf(ctx, "Error merging links into %s: %v", keep, err)
+		back("error", fmt.Sprintf("Merged %d link(s) into %s, then failed to merge the rest", n, keep))
+	default:
+		logf(ctx, "Merged %d link(s) into %s from the duplicates page", n, keep)
+		back("message", fmt.Sprintf("Merged %d link(s) into %s", n, keep))
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestNormalizeTarget(t *testing.T) {
+	same := []string{
+		"https://example.com/page",
+		"HTTPS://Example.COM/page/",
+		"https://example.com:443/page",
+		"https://example.com/page#top",
+		"https://example.com/page?utm_source=news",
+	}
+	for _, longURL := range same {
+		if got := normalizeTarget(longURL); got != "https://example.com/page" {
+			t.Errorf("normalizeTarget(%q) = %q", longURL, got)
+		}
+	}
+	if a, b := normalizeTarget("https://example.com/?b=2&a=1"), normalizeTarget("https://example.com?a=1&b=2"); a != b {
+		t.Errorf("query parameter order matters: %q != %q", a, b)
+	}
+	for _, pair := range [][2]string{
+		{"https://example.com/page", "http://example.com/page"},
+		{"https://example.com/Page", "https://example.com/page"},
+		{"https://example.com/?a=1", "https://example.com/?a=2"},
+	} {
+		if normalizeTarget(pair[0]) == normalizeTarget(pair[1]) {
+			t.Errorf("normalizeTarget treats %q and %q as the same", pair[0], pair[1])
+		}
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+	ctx := context.Background()
+	for shortURL, longURL := range map[string]string{
+		"main":  "https://example.com/page",
+		"slash": "https://example.com/page/",
+		"utm":   "https://example.com/page?utm_source=news",
+		"other": "https://example.org/",
+	} {
+		if err := st.Create(ctx, shortURL, longURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.SetTags(ctx, "utm", []string{"news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := srv.duplicateGroups(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Links) != 3 || groups[0].Target != "https://example.com/page" {
+		t.Fatalf("duplicateGroups returned %+v", groups)
+	}
+
+	merge := func(form url.Values) *httptest.ResponseRecorder {
+		token := strings.Repeat("ab", csrfTokenBytes)
+		form.Set(csrfFieldName, token)
+		req := httptest.NewRequest("POST", "/admin/duplicates", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A link to somewhere else is refused, and stops the merge.
+	rr := merge(url.Values{"keep": {"main"}, "code": {"main", "other"}, "redirect": {"true"}})
+	if location := rr.Header().Get("Location"); !strings.Contains(location, "error=") {
+		t.Errorf("merging an unrelated link redirected to %s", location)
+	}
+	if longURL, err := st.LongURL(ctx, "other"); err != nil || longURL != "https://example.org/" {
+		t.Errorf("LongURL of an unrelated link returned %q, %v", longURL, err)
+	}
+
+	rr = merge(url.Values{"keep": {"main"}, "code": {"main", "slash"}, "redirect": {"true"}})
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+	if longURL, err := st.LongURL(ctx, "slash"); err != nil || longURL != "https://sho.rt/_/main" {
+		t.Errorf("LongURL of a merged link returned %q, %v want a redirect to the kept link", longURL, err)
+	}
+
+	merge(url.Values{"keep": {"main"}, "code": {"main", "utm"}})
+	if _, err := st.Link(ctx, "utm"); err != store.ErrNotFound {
+		t.Errorf("Link of a merged link returned %v, want ErrNotFound", err)
+	}
+	if tags, err := st.Tags(ctx, "main"); err != nil || strings.Join(tags, ",") != "news" {
+		t.Errorf("Tags of the kept link returned %v, %v want the merged link's tags", tags, err)
+	}
+
+	if groups, err := srv.duplicateGroups(ctx); err != nil || len(groups) != 0 {
+		t.Errorf("duplicateGroups after merging returned %+v, %v", groups, err)
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// Email goes out through the SMTP server in the email section of the
+// config. net/smtp upgrades to TLS when the server offers STARTTLS, and
+// only sends the username and password over TLS or to localhost.
+
+// sendMail is smtp.SendMail, replaced in tests.
+var sendMail = smtp.SendMail
+
+// emailConfigured reports whether an SMTP server and sender are set.
+func (s *Server) emailConfigured() bool {
+	return s.cfg.Email.Host != "" && s.cfg.Email.From != ""
+}
+
+// sendEmail sends a plain text email to every address in to.
+func (s *Server) sendEmail(to []string, subject, body string) error {
+	if !s.emailConfigured() {
+		return fmt.Errorf("email.host and email.from must be set to send email")
+	}
+	port := s.cfg.Email.Port
+	if port <= 0 {
+		port = config.DefaultSMTPPort
+	}
+	var auth smtp.Auth
+	if s.cfg.Email.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Email.Username, s.cfg.Email.Password, s.cfg.Email.Host)
+	}
+
+	// Header values are stripped of line breaks so a subject can't add
+	// headers of its own.
+	header := strings.NewReplacer("\r", "", "\n", " ")
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", header.Replace(s.cfg.Email.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", header.Replace(strings.Join(to, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", header.Replace(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+
+	addr := net.JoinHostPort(s.cfg.Email.Host, strconv.Itoa(port))
+	return sendMail(addr, auth, s.cfg.Email.From, to, []byte(msg.String()))
+}
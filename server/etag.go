@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Stats pages and API responses carry an ETag of their body and
+// Cache-Control: no-cache, so a browser or CDN may keep them but has to
+// check back each time. While the figures haven't changed, that check is a
+// 304 Not Modified with no body. Redirects are never cached, as each visit
+// must reach shorty to be counted.
+
+// etag returns a strong entity tag for body.
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists tag. Weak tags
+// match too, as the comparison for If-None-Match is the weak one.
+func etagMatches(ifNoneMatch, tag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRevalidated writes body with an ETag, or answers 304 Not Modified
+// to a GET that already has it. private keeps shared caches such as CDNs
+// from storing the response, for pages that need a login.
+func serveRevalidated(w http.ResponseWriter, r *http.Request, contentType string, body []byte, private bool) {
+	tag := etag(body)
+	w.Header().Set("ETag", tag)
+	if private {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// writeRevalidatedJSON is writeJSON with a 200 status and an ETag.
+func writeRevalidatedJSON(w http.ResponseWriter, r *http.Request, v interface{}, private bool) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logf(r.Context(), "Error encoding JSON response: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error encoding response")
+		return
+	}
+	// Match json.Encoder, which writeJSON uses.
+	serveRevalidated(w, r, "application/json", append(body, '\n'), private)
+}
+
+// executeRevalidated renders tmpl with data and serves it with an ETag.
+func executeRevalidated(w http.ResponseWriter, r *http.Request, tmpl *template.Template, data interface{}, private bool) error {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	serveRevalidated(w, r, "text/html; charset=utf-8", buf.Bytes(), private)
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tag := etag([]byte("body"))
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{"", false},
+		{tag, true},
+		{"W/" + tag, true},
+		{`"other", ` + tag, true},
+		{"*", true},
+		{`"other"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, tag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
+
+func TestServeRevalidated(t *testing.T) {
+	serve := func(method, ifNoneMatch string, private bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/stats", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		serveRevalidated(rr, req, "application/json", []byte(`{"clicks":1}`), private)
+		return rr
+	}
+
+	rr := serve("GET", "", false)
+	tag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"clicks":1}` || tag == "" {
+		t.Fatalf("first response was %d %q with ETag %q", rr.Code, rr.Body.String(), tag)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control is %q, want no-cache", cc)
+	}
+
+	rr = serve("GET", tag, false)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("revalidation returned %d %q, want an empty 304", rr.Code, rr.Body.String())
+	}
+	if rr = serve("GET", `"stale"`, false); rr.Code != http.StatusOK {
+		t.Errorf("a stale ETag got %d, want 200", rr.Code)
+	}
+	// Only reads are answered with a 304.
+	if rr = serve("PATCH", tag, false); rr.Code != http.StatusOK {
+		t.Errorf("a PATCH got %d, want 200", rr.Code)
+	}
+	if rr = serve("GET", "", true); rr.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Errorf("a private response has Cache-Control %q", rr.Header().Get("Cache-Control"))
+	}
+}
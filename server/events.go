@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With events.bus set, every counted click and every new link is published
+// as a JSON event to events.topic on NATS or Kafka, so a data warehouse or
+// fraud detection can follow along without polling the database. NATS is
+// spoken to directly; Kafka through a Kafka REST Proxy. Events are queued
+// and published in the background, so a slow bus never delays a redirect.
+// Delivery is at most once: when the queue is full or the bus is down,
+// events are dropped and logged.
+
+// Message buses.
+const (
+	busNATS  = "nats"
+	busKafka = "kafka"
+)
+
+// Event types.
+const (
+	eventClick       = "click"
+	eventLinkCreated = "link.created"
+)
+
+const (
+	eventQueueSize = 4096
+	// maxEventBatch is how many queued events are published together.
+	maxEventBatch = 100
+)
+
+var eventsClient = &http.Client{Timeout: 10 * time.Second}
+
+// eventTopic matches a topic both NATS and Kafka accept.
+var eventTopic = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// busEvent is one event as published. Addresses are anonymized as
+// configured before they get here.
+type busEvent struct {
+	Type      string    `json:"type"`
+	ShortURL  string    `json:"shortURL"`
+	LongURL   string    `json:"longURL,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Bot       string    `json:"bot,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+func clickEvent(click store.Click) busEvent {
+	return busEvent{
+		Type:      eventClick,
+		ShortURL:  click.ShortURL,
+		Referrer:  click.Referrer,
+		UserAgent: click.UserAgent,
+		IP:        click.IP,
+		Country:   click.Country,
+		Bot:       click.Bot,
+		At:        click.At,
+	}
+}
+
+// eventPublisher sends events to a message bus.
+type eventPublisher interface {
+	publish(ctx context.Context, events []busEvent) error
+	close() error
+}
+
+// eventBus queues events for the publisher.
+type eventBus struct {
+	queue chan busEvent
+	pub   eventPublisher
+}
+
+// newEventBus sets up the configured bus, or returns nil without one.
+func newEventBus(cfg *config.Config) (*eventBus, error) {
+	if cfg.Events.Bus == "" {
+		return nil, nil
+	}
+	if !eventTopic.MatchString(cfg.Events.Topic) {
+		return nil, fmt.Errorf("invalid events.topic %q", cfg.Events.Topic)
+	}
+	u, err := url.Parse(cfg.Events.URL)
+	if err != nil || u.Host == "" {
+		return nil, errors.New("invalid events.url")
+	}
+
+	var pub eventPublisher
+	switch cfg.Events.Bus {
+	case busNATS:
+		if u.Scheme != "nats" {
+			return nil, errors.New("events.url must be a nats:// URL for NATS")
+		}
+		pub = newNATSPublisher(u, cfg.Events.Topic)
+	case busKafka:
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, errors.New("events.url must be the http or https URL of a Kafka REST Proxy")
+		}
+		pub = &kafkaPublisher{url: u, topic: cfg.Events.Topic}
+	default:
+		return nil, fmt.Errorf("unknown events.bus %q", cfg.Events.Bus)
+	}
+	return &eventBus{queue: make(chan busEvent, eventQueueSize), pub: pub}, nil
+}
+
+// emit queues event, or drops it if the queue is full. It does nothing on
+// a nil bus.
+func (b *eventBus) emit(event busEvent) {
+	if b == nil {
+		return
+	}
+	select {
+	case b.queue <- event:
+	default:
+		log.Printf("Event queue is full, dropped %s event on short URL '%s'", event.Type, event.ShortURL)
+	}
+}
+
+// batch returns first with the events queued behind it, up to
+// maxEventBatch.
+func (b *eventBus) batch(first busEvent) []busEvent {
+	events := []busEvent{first}
+	for len(events) < maxEventBatch {
+		select {
+		case event := <-b.queue:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+	return events
+}
+
+// publish sends events, giving up after 10 seconds.
+func (b *eventBus) publish(ctx context.Context, events []busEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return b.pub.publish(ctx, events)
+}
+
+// send publishes events straight away, logging a failure.
+func (b *eventBus) send(ctx context.Context, events []busEvent) {
+	if err := b.publish(ctx, events); err != nil {
+		log.Printf("Error publishing %d event(s): %v", len(events), err)
+	}
+}
+
+// drain publishes what is left in the queue and disconnects.
+func (b *eventBus) drain() {
+	for {
+		select {
+		case event := <-b.queue:
+			b.send(context.Background(), b.batch(event))
+		default:
+			if err := b.pub.close(); err != nil {
+				log.Printf("Error disconnecting from the event bus: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// startEvents publishes queued events on the job pool until ctx is
+// cancelled, then drains the queue itself, as the pool may be draining
+// too.
+func (s *Server) startEvents(ctx context.Context) {
+	if s.events == nil {
+		return
+	}
+	log.Printf("Publishing click and link events to %s topic '%s'", s.cfg.Events.Bus, s.cfg.Events.Topic)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				s.events.drain()
+				return
+			case event := <-s.events.queue:
+				events := s.events.batch(event)
+				// Batches are published one at a time, in order.
+				err := s.jobs.run(ctx, &job{
+					name:     "event publish",
+					attempts: 3,
+					run: func(ctx context.Context) error {
+						return s.events.publish(ctx, events)
+					},
+				})
+				if err != nil && err != ctx.Err() {
+					log.Printf("Error publishing %d event(s): %v", len(events), err)
+				}
+			}
+		}
+	}()
+}
+
+// kafkaPublisher produces events through a Kafka REST Proxy, keyed by
+// short URL so each link's events stay in order.
+type kafkaPublisher struct {
+	url   *url.URL
+	topic string
+}
+
+type kafkaRecord struct {
+	Key   string   `json:"key"`
+	Value busEvent `json:"value"`
+}
+
+func (k *kafkaPublisher) publish(ctx context.Context, events []busEvent) error {
+	records := make([]kafkaRecord, len(events))
+	for i, event := range events {
+		records[i] = kafkaRecord{Key: event.ShortURL, Value: event}
+	}
+	body, err := json.Marshal(struct {
+		Records []kafkaRecord `json:"records"`
+	}{records})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url.JoinPath("topics", k.topic).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := eventsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("kafka REST proxy returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (k *kafkaPublisher) close() error {
+	return nil
+}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestNewEventBus(t *testing.T) {
+	cfg := &config.Config{}
+	if bus, err := newEventBus(cfg); bus != nil || err != nil {
+		t.Errorf("newEventBus without a bus returned %v, %v", bus, err)
+	}
+
+	tests := []struct {
+		bus, url, topic string
+		ok              bool
+	}{
+		{busNATS, "nats://localhost:4222", "shorty.events", true},
+		{busKafka, "http://kafka-rest:8082", "shorty-events", true},
+		{busNATS, "http://localhost:4222", "shorty.events", false},
+		{busKafka, "nats://localhost:4222", "shorty.events", false},
+		{busNATS, "nats://localhost:4222", "shorty events", false},
+		{"rabbitmq", "amqp://localhost", "shorty.events", false},
+	}
+	for _, tt := range tests {
+		cfg.Events.Bus, cfg.Events.URL, cfg.Events.Topic = tt.bus, tt.url, tt.topic
+		if _, err := newEventBus(cfg); (err == nil) != tt.ok {
+			t.Errorf("newEventBus(%q, %q, %q) returned %v", tt.bus, tt.url, tt.topic, err)
+		}
+	}
+}
+
+func TestEventBusBatch(t *testing.T) {
+	bus := &eventBus{queue: make(chan busEvent, maxEventBatch+10)}
+	for i := 0; i < maxEventBatch+5; i++ {
+		bus.emit(busEvent{Type: eventClick, ShortURL: strconv.Itoa(i)})
+	}
+	first := <-bus.queue
+	if events := bus.batch(first); len(events) != maxEventBatch || events[0].ShortURL != "0" {
+		t.Errorf("batch returned %d events starting at %q, want %d from 0", len(events), events[0].ShortURL, maxEventBatch)
+	}
+	if events := bus.batch(<-bus.queue); len(events) != 5 {
+		t.Errorf("batch returned %d events, want the 5 left", len(events))
+	}
+
+	// A nil bus ignores events.
+	var none *eventBus
+	none.emit(busEvent{Type: eventClick})
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	var got struct {
+		Records []kafkaRecord `json:"records"`
+	}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/shorty-events" {
+			t.Errorf("event posted to %s", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("event posted as %q", ct)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("event body is not JSON: %v", err)
+		}
+		w.Write([]byte(`{"offsets":[]}`))
+	}))
+	defer proxy.Close()
+
+	cfg := &config.Config{}
+	cfg.Events.Bus, cfg.Events.URL, cfg.Events.Topic = busKafka, proxy.URL, "shorty-events"
+	bus, err := newEventBus(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := []busEvent{
+		{Type: eventLinkCreated, ShortURL: "abc", LongURL: "https://example.com", At: at},
+		clickEvent(store.Click{ShortURL: "abc", Country: "NL", At: at}),
+	}
+	if err := bus.pub.publish(context.Background(), events); err != nil {
+		t.Fatalf("publish returned an error: %v", err)
+	}
+	if len(got.Records) != 2 || got.Records[0].Key != "abc" || got.Records[0].Value != events[0] || got.Records[1].Value.Country != "NL" {
+		t.Errorf("proxy got %+v", got.Records)
+	}
+}
+
+// fakeNATS accepts one connection, checks the login and sends each
+// published payload to msgs.
+func fakeNATS(t *testing.T) (addr string, msgs <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	out := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {\"server_id\":\"test\",\"auth_required\":true}\r\n"))
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(out)
+				return
+			}
+			switch {
+			case strings.HasPrefix(line, "CONNECT "):
+				var msg natsConnect
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &msg)
+				if msg.User != "shorty" || msg.Pass != "secret" {
+					conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+					return
+				}
+			case line == "PING\r\n":
+				conn.Write([]byte("PONG\r\n"))
+				// Ask for a PONG back, as a server does now and then.
+				conn.Write([]byte("PING\r\n"))
+			case strings.HasPrefix(line, "PUB "):
+				fields := strings.Fields(line)
+				n, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				out <- fields[1] + " " + string(payload[:n])
+			}
+		}
+	}()
+	return ln.Addr().String(), out
+}
+
+func TestNATSPublisher(t *testing.T) {
+	addr, msgs := fakeNATS(t)
+	cfg := &config.Config{}
+	cfg.Events.Bus, cfg.Events.URL, cfg.Events.Topic = busNATS, "nats://shorty:secret@"+addr, "shorty.events"
+	bus, err := newEventBus(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	event := busEvent{Type: eventLinkCreated, ShortURL: "abc", LongURL: "https://example.com", At: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	if err := bus.pub.publish(ctx, []busEvent{event, event}); err != nil {
+		t.Fatalf("publish returned an error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-msgs:
+			subject, payload, _ := strings.Cut(msg, " ")
+			var got busEvent
+			if err := json.Unmarshal([]byte(payload), &got); err != nil || subject != "shorty.events" || got != event {
+				t.Errorf("NATS got %q", msg)
+			}
+		case <-ctx.Done():
+			t.Fatal("NATS got no message")
+		}
+	}
+	if err := bus.pub.close(); err != nil {
+		t.Errorf("close returned an error: %v", err)
+	}
+}
+
+func TestNATSPublisherRefused(t *testing.T) {
+	addr, _ := fakeNATS(t)
+	u, err := url.Parse("nats://shorty:wrong@" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := newNATSPublisher(u, "shorty.events")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pub.publish(ctx, []busEvent{{Type: eventClick}}); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("publish with a wrong password returned %v", err)
+	}
+}
+
+func TestRecordClickEmitsEvent(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.events = &eventBus{queue: make(chan busEvent, 1)}
+
+	req := httptest.NewRequest("GET", "/abc", nil)
+	req.Header.Set("Referer", "https://news.example")
+	srv.recordClick(req, "abc", "")
+	select {
+	case event := <-srv.events.queue:
+		if event.Type != eventClick || event.ShortURL != "abc" || event.Referrer != "https://news.example" {
+			t.Errorf("recordClick emitted %+v", event)
+		}
+	default:
+		t.Error("recordClick emitted no event")
+	}
+}
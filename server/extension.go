@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// A browser extension shortens the current tab through the JSON API with
+// an API key. Browsers only let extension code read the API's answers when
+// the API allows the extension's origin, which api.allowedOrigins does.
+// GET /api/v1/links lists the caller's recent links for the extension's
+// popup, and both it and creating a link answer with plain short links,
+// ready for the clipboard, to clients that ask for text/plain.
+
+const (
+	defaultRecentLinks = 20
+	maxRecentLinks     = 100
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight.
+const corsMaxAge = "600"
+
+// validateOrigins checks api.allowedOrigins are "*" or scheme://host
+// origins without a path.
+func validateOrigins(origins []string) error {
+	for _, origin := range origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("invalid api.allowedOrigins entry %q: want scheme://host, such as chrome-extension://<id>", origin)
+		}
+	}
+	return nil
+}
+
+// allowedOrigin reports whether browser code from origin may call the API.
+func (s *Server) allowedOrigin(origin string) bool {
+	for _, allowed := range s.cfg.API.AllowedOrigins {
+		if allowed == "*" || strings.TrimSuffix(allowed, "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCORS sets the CORS headers on API requests from an allowed origin
+// and answers their preflights, which carry no credentials and so come
+// before any login check. It returns true when it answered r.
+func (s *Server) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	if !s.allowedOrigin(origin) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Request-ID")
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, PUT, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Accept")
+	w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// wantsText reports whether r asks for a plain text answer rather than
+// JSON.
+func wantsText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
+
+// writeText writes lines as a text/plain answer, one per line.
+func writeText(w http.ResponseWriter, status int, lines ...string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// handleAPIRecentLinks serves GET /api/v1/links?mine=true, the links the
+// account or browser session the request comes from made, latest first and
+// capped with ?limit=. mine is the only listing there is, so it may be left
+// out.
+func (s *Server) handleAPIRecentLinks(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API recent links request")
+	if mine := r.URL.Query().Get("mine"); mine != "" && mine != "true" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Only your own links can be listed, with mine=true")
+		return
+	}
+
+	limit := defaultRecentLinks
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Limit must be a positive number")
+			return
+		}
+		if n < maxRecentLinks {
+			limit = n
+		} else {
+			limit = maxRecentLinks
+		}
+	}
+
+	recent, ok, err := s.recentLinks(r.Context(), r, limit)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if err != nil {
+		logf(r.Context(), "Error reading recent links: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading links")
+		return
+	}
+	if recent == nil {
+		recent = []store.LinkStats{}
+	}
+
+	if wantsText(r) {
+		lines := make([]string, len(recent))
+		for i, link := range recent {
+			lines[i] = s.cfg.PublicURL(r) + "/_/" + link.ShortURL
+		}
+		writeText(w, http.StatusOK, lines...)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Links []store.LinkStats `json:"links"`
+	}{recent})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestValidateOrigins(t *testing.T) {
+	if err := validateOrigins([]string{"*", "chrome-extension://abcdefghijklmnop", "moz-extension://0b1c2d3e/", "https://app.example.com"}); err != nil {
+		t.Errorf("validateOrigins returned an error: %v", err)
+	}
+	for _, origin := range []string{"", "example.com", "https://example.com/path", "https://example.com?x=1"} {
+		if err := validateOrigins([]string{origin}); err == nil {
+			t.Errorf("validateOrigins accepted %q", origin)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.API.AllowedOrigins = []string{"chrome-extension://abc"}
+	// A preflight carries no credentials, so it must get through basic
+	// auth.
+	srv.cfg.BasicAuth.Routes = []string{"/"}
+	srv.cfg.BasicAuth.Users = map[string]string{"alice": "hunter2"}
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/api/v1/links", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := preflight("chrome-extension://abc")
+	if status := rr.Code; status != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "chrome-extension://abc" {
+		t.Errorf("handler allowed origin %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(got, "Authorization") {
+		t.Errorf("handler allowed headers %q", got)
+	}
+
+	rr = preflight("https://evil.example")
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("handler allowed an unlisted origin: %q", got)
+	}
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/links", nil)
+	req.Header.Set("Origin", "chrome-extension://abc")
+	req.SetBasicAuth("alice", "hunter2")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "chrome-extension://abc" {
+		t.Errorf("handler allowed origin %q", got)
+	}
+
+	cfg := &config.Config{}
+	cfg.API.AllowedOrigins = []string{"chrome-extension://abc/popup.html"}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted an origin with a path")
+	}
+}
+
+func TestExtensionLinks(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+	srv.cfg.API.Keys = []config.APIKey{{Name: "ext", Key: "ext-key"}, {Name: "other", Key: "other-key"}}
+
+	send := func(method, path, key, accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var links []string
+	for _, longURL := range []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"} {
+		rr := send("POST", "/api/v1/links", "ext-key", "text/plain", `{"url": "`+longURL+`"}`)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		link := strings.TrimSuffix(rr.Body.String(), "\n")
+		if !strings.HasPrefix(link, "https://sho.rt/_/") || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+			t.Fatalf("handler returned %q as %s", rr.Body.String(), rr.Header().Get("Content-Type"))
+		}
+		links = append(links, link)
+	}
+	send("POST", "/api/v1/links", "other-key", "", `{"url": "https://example.com/other"}`)
+
+	rr := send("GET", "/api/v1/links?limit=2", "ext-key", "", "")
+	var recent struct {
+		Links []store.LinkStats `json:"links"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&recent); err != nil {
+		t.Fatal(err)
+	}
+	if len(recent.Links) != 2 || recent.Links[0].LongURL != "https://example.com/3" || recent.Links[1].LongURL != "https://example.com/2" {
+		t.Errorf("recent links returned %+v", recent.Links)
+	}
+
+	rr = send("GET", "/api/v1/links", "ext-key", "text/plain", "")
+	if got, want := rr.Body.String(), links[2]+"\n"+links[1]+"\n"+links[0]+"\n"; got != want {
+		t.Errorf("recent links returned %q want %q", got, want)
+	}
+
+	checkAPIError(t, send("GET", "/api/v1/links", "", "", ""), http.StatusUnauthorized, errCodeUnauthorized)
+	checkAPIError(t, send("GET", "/api/v1/links?limit=0", "ext-key", "", ""), http.StatusBadRequest, errCodeInvalidForm)
+}
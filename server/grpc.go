@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/shortypb"
+	"github.com/donuts-are-good/shorty/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements the Shorty gRPC service on top of the same store
+// the JSON API uses.
+type grpcServer struct {
+	shortypb.UnimplementedShortyServer
+	s *Server
+}
+
+func linkToProto(link store.LinkStats) *shortypb.Link {
+	return &shortypb.Link{
+		ShortUrl:   link.ShortURL,
+		LongUrl:    link.LongURL,
+		VisitCount: int64(link.VisitCount),
+		CreatedAt:  timestamppb.New(link.CreatedAt),
+	}
+}
+
+func (g grpcServer) CreateLink(ctx context.Context, req *shortypb.CreateLinkRequest) (*shortypb.Link, error) {
+	if !validLongURL(req.Url) {
+		return nil, status.Error(codes.InvalidArgument, "invalid URL")
+	}
+	if len(req.Url) > 2048 {
+		return nil, status.Error(codes.InvalidArgument, "URL is too long")
+	}
+
+	// API keys have the same scopes and quotas as over the JSON API.
+	r := rpcRequest(ctx)
+	createCtx := withSource(withActor(ctx, g.s.rpcActor(r)), sourceGRPC)
+	if key, keyed := g.s.apiKeyFor(r); keyed {
+		if !hasScope(key, scopeCreate) {
+			return nil, status.Error(codes.PermissionDenied, "this API key may not create links")
+		}
+		_, _, ok, err := g.s.createQuota(ctx, key)
+		if err != nil {
+			log.Printf("Error reading usage of API key %s: %v", key.Name, err)
+			return nil, status.Error(codes.Internal, "error reading API key usage")
+		}
+		if !ok {
+			return nil, status.Error(codes.ResourceExhausted, "this API key has created its monthly quota of links")
+		}
+		createCtx = withAPIKey(createCtx, key.Name)
+	}
+
+	shortURL, err := g.s.shortenURL(createCtx, req.Url, strings.TrimSpace(req.Alias))
+	switch err {
+	case nil:
+	case errAliasInvalid:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errAliasTaken:
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case errKeyspaceExhausted:
+		return nil, status.Error(codes.Unavailable, err.Error())
+	default:
+		if isRejected(err) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		log.Printf("Error creating short URL via gRPC: %v", err)
+		return nil, status.Error(codes.Internal, "failed to create short URL")
+	}
+	log.Println("Created short URL via gRPC:", shortURL)
+
+	link, err := g.s.store.Link(ctx, shortURL)
+	if err != nil {
+		log.Printf("Error fetching stats for new short URL %s: %v", shortURL, err)
+		return nil, status.Error(codes.Internal, "failed to create short URL")
+	}
+	return linkToProto(link), nil
+}
+
+func (g grpcServer) ExpandLink(ctx context.Context, req *shortypb.ExpandLinkRequest) (*shortypb.ExpandLinkResponse, error) {
+	result, err := g.s.expandShortURL(ctx, req.ShortUrl)
+	if err != nil {
+		log.Printf("Error expanding short URL %s: %v", req.ShortUrl, err)
+		return nil, status.Error(codes.Internal, "error expanding short URL")
+	}
+	if result.Status == LinkStatusNotFound {
+		return nil, status.Error(codes.NotFound, "short URL not found")
+	}
+	return &shortypb.ExpandLinkResponse{
+		ShortUrl:  result.ShortURL,
+		LongUrl:   result.LongURL,
+		CreatedAt: timestamppb.New(*result.CreatedAt),
+	}, nil
+}
+
+func (g grpcServer) DeleteLink(ctx context.Context, req *shortypb.DeleteLinkRequest) (*shortypb.DeleteLinkResponse, error) {
+	r := rpcRequest(ctx)
+	if !g.s.authorizedAdmin(r) {
+		return nil, status.Error(codes.Unauthenticated, "admin key required")
+	}
+	deleted, err := g.s.deleteLink(withActor(ctx, g.s.rpcActor(r)), req.ShortUrl, false)
+	if err != nil {
+		log.Printf("Error deleting short URL %s: %v", req.ShortUrl, err)
+		return nil, status.Error(codes.Internal, "failed to delete short URL")
+	}
+	if !deleted {
+		return nil, status.Error(codes.NotFound, "short URL not found")
+	}
+	log.Println("Deleted short URL via gRPC:", req.ShortUrl)
+	return &shortypb.DeleteLinkResponse{}, nil
+}
+
+func (g grpcServer) GetStats(ctx context.Context, req *shortypb.GetStatsRequest) (*shortypb.Link, error) {
+	if !g.s.authorizedLinkStats(rpcRequest(ctx), req.ShortUrl) {
+		return nil, status.Error(codes.Unauthenticated, "admin key, stats key or share signature required")
+	}
+	link, err := g.s.store.Link(ctx, req.ShortUrl)
+	if err == store.ErrNotFound {
+		return nil, status.Error(codes.NotFound, "short URL not found")
+	}
+	if err != nil {
+		log.Printf("Error fetching stats for short URL %s: %v", req.ShortUrl, err)
+		return nil, status.Error(codes.Internal, "error fetching link stats")
+	}
+	return linkToProto(link), nil
+}
+
+// rpcRequest turns the metadata of a gRPC call into the HTTP request the
+// JSON API would have been sent, so both transports authorize calls with
+// the same checks. Metadata becomes headers, except "expires" and "sig",
+// which carry a share link's signature as its query string does.
+func rpcRequest(ctx context.Context) *http.Request {
+	r := (&http.Request{Method: http.MethodPost, URL: &url.URL{}, Header: http.Header{}}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	query := url.Values{}
+	for name, values := range md {
+		for _, value := range values {
+			switch name {
+			case "expires", "sig":
+				query.Add(name, value)
+			default:
+				r.Header.Add(name, value)
+			}
+		}
+	}
+	r.URL.RawQuery = query.Encode()
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r
+}
+
+// rpcActor is requestActor for gRPC calls, which are recorded as "grpc"
+// without a client address.
+func (s *Server) rpcActor(r *http.Request) string {
+	if key, ok := s.apiKeyFor(r); ok {
+		return "key " + key.Name
+	}
+	if s.authorizedAdmin(r) {
+		return "admin"
+	}
+	return sourceGRPC
+}
+
+// GRPCServer returns a gRPC server with the Shorty service registered, for
+// programs that want to serve it on a listener of their own.
+func (s *Server) GRPCServer() *grpc.Server {
+	server := grpc.NewServer()
+	shortypb.RegisterShortyServer(server, grpcServer{s: s})
+	return server
+}
+
+// startGRPC serves the gRPC API on grpc.port in the background. It does
+// nothing when no port is configured.
+func (s *Server) startGRPC() error {
+	if s.cfg.GRPC.Port == "" {
+		return nil
+	}
+	lis, err := net.Listen("tcp", s.cfg.GRPC.Port)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serving gRPC on %s", lis.Addr())
+
+	go func() {
+		if err := s.GRPCServer().Serve(lis); err != nil {
+			log.Fatalf("gRPC server stopped: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/shortypb"
+	"github.com/donuts-are-good/shorty/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCTestClient serves the gRPC API over an in-memory listener.
+func newGRPCTestClient(t *testing.T, srv *Server) shortypb.ShortyClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := srv.GRPCServer()
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial gRPC server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return shortypb.NewShortyClient(conn)
+}
+
+func TestGRPCServer(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.API.AdminKey = "secret"
+
+	client := newGRPCTestClient(t, srv)
+	ctx := context.Background()
+	created := time.Now().Format("2006-01-02 15:04:05")
+
+	t.Run("CreateLink", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url FROM url_mapping WHERE long_url").
+			WithArgs("https://example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url"}).AddRow("abc123"))
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+				AddRow("abc123", "https://example.com", 0, created))
+
+		link, err := client.CreateLink(ctx, &shortypb.CreateLinkRequest{Url: "https://example.com"})
+		if err != nil {
+			t.Fatalf("CreateLink returned an error: %v", err)
+		}
+		if link.ShortUrl != "abc123" {
+			t.Errorf("CreateLink returned wrong short URL: got %v want %v", link.ShortUrl, "abc123")
+		}
+	})
+
+	t.Run("CreateLink Invalid URL", func(t *testing.T) {
+		_, err := client.CreateLink(ctx, &shortypb.CreateLinkRequest{Url: "not-a-url"})
+		if code := status.Code(err); code != codes.InvalidArgument {
+			t.Errorf("CreateLink returned wrong code: got %v want %v", code, codes.InvalidArgument)
+		}
+	})
+
+	t.Run("GetStats Not Found", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("missing").
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := client.GetStats(ctx, &shortypb.GetStatsRequest{ShortUrl: "missing"})
+		if code := status.Code(err); code != codes.NotFound {
+			t.Errorf("GetStats returned wrong code: got %v want %v", code, codes.NotFound)
+		}
+	})
+
+	t.Run("ExpandLink", func(t *testing.T) {
+		mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+			WithArgs("abc123").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+				AddRow("abc123", "https://example.com", 4, created))
+		expectOptions(mock, "abc123", store.Options{})
+
+		result, err := client.ExpandLink(ctx, &shortypb.ExpandLinkRequest{ShortUrl: "abc123"})
+		if err != nil {
+			t.Fatalf("ExpandLink returned an error: %v", err)
+		}
+		if result.LongUrl != "https://example.com" {
+			t.Errorf("ExpandLink returned wrong long URL: got %v want %v", result.LongUrl, "https://example.com")
+		}
+	})
+
+	t.Run("DeleteLink Unauthenticated", func(t *testing.T) {
+		_, err := client.DeleteLink(ctx, &shortypb.DeleteLinkRequest{ShortUrl: "abc123"})
+		if code := status.Code(err); code != codes.Unauthenticated {
+			t.Errorf("DeleteLink returned wrong code: got %v want %v", code, codes.Unauthenticated)
+		}
+	})
+
+	t.Run("DeleteLink", func(t *testing.T) {
+		mock.ExpectExec("DELETE FROM url_mapping").
+			WithArgs("abc123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		authCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+		if _, err := client.DeleteLink(authCtx, &shortypb.DeleteLinkRequest{ShortUrl: "abc123"}); err != nil {
+			t.Errorf("DeleteLink returned an error: %v", err)
+		}
+	})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGRPCPrivateStats(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.API.Keys = []config.APIKey{{Name: "ci", Key: "ci-key"}, {Name: "reports", Key: "stats-key", Scopes: []string{scopeStats}}}
+	srv.cfg.Share.PrivateStats = true
+	srv.cfg.Share.Secret = "share-secret"
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	client := newGRPCTestClient(t, srv)
+	expires := time.Now().Add(time.Hour).Unix()
+
+	for _, tt := range []struct {
+		name string
+		md   []string
+		want codes.Code
+	}{
+		{"Anonymous", nil, codes.Unauthenticated},
+		{"Create Key", []string{"authorization", "Bearer ci-key"}, codes.Unauthenticated},
+		{"Admin Key", []string{"authorization", "Bearer secret"}, codes.OK},
+		{"Stats Key", []string{"authorization", "Bearer stats-key"}, codes.OK},
+		{"Share", []string{"expires", strconv.FormatInt(expires, 10), "sig", srv.statsSignature("abc123", expires)}, codes.OK},
+		{"Wrong Share", []string{"expires", strconv.FormatInt(expires, 10), "sig", srv.statsSignature("def456", expires)}, codes.Unauthenticated},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.GetStats(metadata.AppendToOutgoingContext(ctx, tt.md...), &shortypb.GetStatsRequest{ShortUrl: "abc123"})
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("GetStats returned %v want %v", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGRPCAPIKeys(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.Keys = []config.APIKey{
+		{Name: "ci", Key: "ci-key", MonthlyCreates: 1},
+		{Name: "reports", Key: "stats-key", Scopes: []string{scopeStats}},
+		{Name: "ops", Key: "ops-key", Scopes: []string{scopeAdmin}},
+	}
+	client := newGRPCTestClient(t, srv)
+	ctx := context.Background()
+	as := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+key)
+	}
+
+	link, err := client.CreateLink(as("ci-key"), &shortypb.CreateLinkRequest{Url: "https://example.com/a"})
+	if err != nil {
+		t.Fatalf("CreateLink with a create key returned an error: %v", err)
+	}
+	if opts, err := st.Options(ctx, link.ShortUrl); err != nil || opts.APIKey != "ci" {
+		t.Errorf("link created with a key has API key %q, %v want ci", opts.APIKey, err)
+	}
+	if _, err := client.CreateLink(as("ci-key"), &shortypb.CreateLinkRequest{Url: "https://example.com/b"}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("CreateLink over quota returned %v want %v", status.Code(err), codes.ResourceExhausted)
+	}
+	if _, err := client.CreateLink(as("stats-key"), &shortypb.CreateLinkRequest{Url: "https://example.com/c"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateLink with a stats key returned %v want %v", status.Code(err), codes.PermissionDenied)
+	}
+
+	if _, err := client.DeleteLink(as("ci-key"), &shortypb.DeleteLinkRequest{ShortUrl: link.ShortUrl}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("DeleteLink with a create key returned %v want %v", status.Code(err), codes.Unauthenticated)
+	}
+	if _, err := client.DeleteLink(as("ops-key"), &shortypb.DeleteLinkRequest{ShortUrl: link.ShortUrl}); err != nil {
+		t.Errorf("DeleteLink with an admin key returned an error: %v", err)
+	}
+}
@@ -0,0 +1,559 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling index request")
+	if r.URL.Path != "/" {
+		logln(r.Context(), "Redirecting to root from:", r.URL.Path)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	token, err := csrfToken(w, r)
+	if err != nil {
+		logf(r.Context(), "Error generating CSRF token: %v", err)
+		httpError(w, "Error generating CSRF token", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("index.html")
+	if err != nil {
+		logf(r.Context(), "Error loading index template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	// The page works without them, so failing to read the recent links
+	// only leaves them out.
+	recent, _, err := s.recentLinks(r.Context(), r, recentIndexLinks)
+	if err != nil {
+		logf(r.Context(), "Error reading recent links: %v", err)
+	}
+
+	data := struct {
+		CSRFToken   string
+		PublicURL   string
+		RecentLinks []store.LinkStats
+	}{
+		CSRFToken:   token,
+		PublicURL:   s.cfg.PublicURL(r),
+		RecentLinks: recent,
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logf(r.Context(), "Error executing index template: %v", err)
+	}
+}
+
+// validLongURL reports whether longURL is an absolute http or https URL.
+// Other schemes, such as javascript:, are refused, since long URLs are shown
+// as links on the stats and admin pages.
+func validLongURL(longURL string) bool {
+	u, err := url.ParseRequestURI(longURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling create request")
+	if r.Method != http.MethodPost {
+		logln(r.Context(), "Not a POST request, redirecting to index")
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		httpError(w, "Invalid Content-Type", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			httpError(w, "Request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected create request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	longURL := r.FormValue("url")
+
+	if !validLongURL(longURL) {
+		httpError(w, "Invalid URL", http.StatusBadRequest)
+		return
+	}
+
+	if len(longURL) > 2048 {
+		httpError(w, "URL is too long", http.StatusBadRequest)
+		return
+	}
+
+	alias := strings.TrimSpace(r.FormValue("alias"))
+
+	opts := store.Options{
+		UTMSource:   r.FormValue("utm_source"),
+		UTMMedium:   r.FormValue("utm_medium"),
+		UTMCampaign: r.FormValue("utm_campaign"),
+	}
+	trimUTM(&opts)
+	if err := validateUTM(opts); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var err error
+	if opts.MaxClicks, err = parseMaxClicks(r.FormValue("max_clicks")); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.NoAnalytics = r.FormValue("no_analytics") != ""
+	if err := validateClickCap(opts); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := withSource(withActor(r.Context(), s.requestActor(r, sourceWeb)), sourceWeb)
+	ctx = s.withAccount(ctx, r)
+	shortURL, err := s.createLink(ctx, longURL, alias, linkOptions{Options: opts})
+	if err != nil {
+		if status, _, ok := aliasErrorStatus(err); ok {
+			httpError(w, err.Error(), status)
+			return
+		}
+		if isRejected(err) {
+			logf(ctx, "Create hook rejected '%s': %v", longURL, err)
+			httpError(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err == errKeyspaceExhausted {
+			w.Header().Set("Retry-After", exhaustedRetryAfter)
+			httpError(w, "No free short URL found, please try again shortly", http.StatusServiceUnavailable)
+			return
+		}
+		logf(ctx, "Error creating short URL: %v", err)
+		httpError(w, "Failed to create short URL", http.StatusInternalServerError)
+		return
+	}
+	logln(ctx, "Created short URL:", shortURL)
+	s.recordSessionLink(ctx, w, r, shortURL)
+
+	data := struct {
+		ShortURL string
+	}{
+		ShortURL: shortURL,
+	}
+
+	tmpl, err := s.templates.lookup("short.html")
+	if err != nil {
+		logf(ctx, "Error loading short template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logf(ctx, "Failed to render template: %v", err)
+		httpError(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
+
+// redirectMethods are the methods short links answer.
+const redirectMethods = "GET, HEAD, OPTIONS"
+
+func (s *Server) handleRedirect(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling redirect request")
+	// Every visit has to reach shorty to be counted, and the link may
+	// change, so nothing on the way may keep the redirect.
+	w.Header().Set("Cache-Control", "no-store")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.Header().Set("Allow", redirectMethods)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", redirectMethods)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/_/")
+	// Anything after the code is a subpath, which only prefix links accept.
+	shortURL, subpath, hasSubpath := strings.Cut(path, "/")
+	logf(r.Context(), "Extracted short URL: '%s'", shortURL)
+
+	if shortURL == "" {
+		logln(r.Context(), "Empty short URL, redirecting to root")
+		http.Redirect(w, r, "/?error="+url.QueryEscape("Empty short URL"), http.StatusFound)
+		return
+	}
+
+	if !s.allowRedirect(r, shortURL) {
+		logf(r.Context(), "Rate limited %s on flagged short URL '%s'", s.clientIP(r), shortURL)
+		w.Header().Set("Retry-After", "60")
+		httpError(w, "Too many visits to this link, please try again in a minute", http.StatusTooManyRequests)
+		return
+	}
+
+	longURL, err := s.store.LongURL(r.Context(), shortURL)
+	if err != nil {
+		if err == store.ErrNotFound {
+			logf(r.Context(), "No long URL found for short URL '%s'", shortURL)
+			s.notFound(w, r, path)
+		} else {
+			logf(r.Context(), "Error fetching long URL for short URL '%s': %v", shortURL, err)
+			http.Redirect(w, r, "/?error="+url.QueryEscape("Error fetching URL"), http.StatusFound)
+		}
+		return
+	}
+
+	if longURL == "" {
+		logf(r.Context(), "Empty long URL for short URL '%s'", shortURL)
+		http.Redirect(w, r, "/?error="+url.QueryEscape("Invalid short URL"), http.StatusFound)
+		return
+	}
+
+	logf(r.Context(), "Found long URL for '%s': '%s'", shortURL, longURL)
+
+	// Without its options there's no telling whether the link was taken
+	// down, disabled or expired, so it isn't followed.
+	opts, err := s.store.Options(r.Context(), shortURL)
+	if err != nil {
+		if err == store.ErrNotFound {
+			logf(r.Context(), "Short URL '%s' was deleted while redirecting", shortURL)
+			s.notFound(w, r, path)
+		} else {
+			logf(r.Context(), "Error fetching options for short URL '%s': %v", shortURL, err)
+			http.Redirect(w, r, "/?error="+url.QueryEscape("Error fetching URL"), http.StatusFound)
+		}
+		return
+	}
+	if opts.TakenDown {
+		logf(r.Context(), "Short URL '%s' was taken down", shortURL)
+		s.linkRemoved(w, shortURL)
+		return
+	}
+	if opts.Disabled {
+		logf(r.Context(), "Short URL '%s' is disabled", shortURL)
+		httpError(w, "This link has been disabled", http.StatusGone)
+		return
+	}
+	if opts.Flagged {
+		logf(r.Context(), "Short URL '%s' is held for review", shortURL)
+		httpError(w, "This link is waiting for review", http.StatusForbidden)
+		return
+	}
+	if opts.Expired(time.Now()) {
+		logf(r.Context(), "Short URL '%s' has expired", shortURL)
+		httpError(w, "This link has expired", http.StatusGone)
+		return
+	}
+	if hasSubpath && !opts.Prefix {
+		logf(r.Context(), "Short URL '%s' is not a prefix link, ignoring subpath '%s'", shortURL, subpath)
+		s.notFound(w, r, path)
+		return
+	}
+	if !s.allowKeyRedirect(w, r, shortURL, opts) {
+		return
+	}
+
+	// While the long URL is down everyone goes to the fallback. Otherwise
+	// platform targets win over A/B variants, so only visitors who would
+	// get the long URL take part in the split.
+	var variant store.Variant
+	split := false
+	if fallback, ok := s.fallbackFor(r.Context(), shortURL, opts); ok {
+		longURL = fallback
+	} else {
+		destination := s.destinationFor(r, shortURL, longURL)
+		if destination == longURL {
+			if variant, split = s.variantFor(r, shortURL); split {
+				destination = variant.URL
+			}
+		}
+		longURL = destination
+	}
+
+	if hasSubpath {
+		longURL = joinSubpath(longURL, subpath)
+	}
+	// UTM tags go on before the visitor's query string, so a visitor can't
+	// override the link's campaign.
+	if utm := utmQuery(opts); len(utm) > 0 {
+		longURL = mergeQuery(longURL, utm)
+	}
+	if opts.PassQuery && r.URL.RawQuery != "" {
+		longURL = mergeQuery(longURL, r.URL.Query())
+	}
+
+	longURL, err = s.runRedirectHooks(r, shortURL, longURL)
+	if err != nil {
+		logf(r.Context(), "Redirect hook refused short URL '%s': %v", shortURL, err)
+		http.Redirect(w, r, "/?error="+url.QueryEscape(err.Error()), http.StatusFound)
+		return
+	}
+
+	if s.answerPreviewBot(w, r, shortURL, longURL) {
+		return
+	}
+
+	// Links created with analytics off leave no trace of the visit: no
+	// count, no click log, no live stats and no variant tally.
+	if opts.NoAnalytics {
+		logf(r.Context(), "Redirecting to long URL: '%s'", longURL)
+		http.Redirect(w, r, longURL, s.cfg.RedirectStatus())
+		return
+	}
+
+	// Link checkers and preview bots ask with HEAD where a link goes
+	// without following it, which isn't a visit unless links.countHead
+	// says so. A link out of clicks still answers as it would a visit.
+	if r.Method == http.MethodHead && !s.cfg.Links.CountHead {
+		if opts.MaxClicks > 0 {
+			if link, err := s.store.Link(r.Context(), shortURL); err == nil && link.VisitCount >= opts.MaxClicks {
+				s.limitReached(w, shortURL, opts.MaxClicks)
+				return
+			}
+		}
+		logf(r.Context(), "Answering HEAD for short URL '%s' without counting it", shortURL)
+		http.Redirect(w, r, longURL, s.cfg.RedirectStatus())
+		return
+	}
+
+	// Update visit count directly in the database. A capped link that
+	// has used up its clicks doesn't count the visit, so the check and
+	// the increment are one statement. Bots are counted apart.
+	bot := s.detectBot(r)
+	var found bool
+	if bot != "" {
+		found, err = s.store.RecordBotVisit(r.Context(), shortURL)
+	} else {
+		found, err = s.store.RecordVisit(r.Context(), shortURL)
+	}
+	if err != nil {
+		logf(r.Context(), "Error updating visit count for short URL '%s': %v", shortURL, err)
+	} else if found {
+		s.recordClick(r, shortURL, bot)
+		if bot == "" {
+			s.checkMilestone(r.Context(), s.cfg.PublicURL(r), shortURL)
+			s.publishClick(r.Context(), shortURL)
+		}
+	} else if opts.MaxClicks > 0 {
+		logf(r.Context(), "Short URL '%s' has reached its limit of %d clicks", shortURL, opts.MaxClicks)
+		s.limitReached(w, shortURL, opts.MaxClicks)
+		return
+	}
+	if split && bot == "" {
+		if err := s.store.RecordVariantVisit(r.Context(), shortURL, variant.Name); err != nil {
+			logf(r.Context(), "Error updating visit count for variant '%s' of '%s': %v", variant.Name, shortURL, err)
+		}
+	}
+
+	logf(r.Context(), "Redirecting to long URL: '%s'", longURL)
+	http.Redirect(w, r, longURL, s.cfg.RedirectStatus())
+	logf(r.Context(), "Redirect completed for short URL: '%s'", shortURL)
+}
+
+// validateRedirectStatus checks links.redirectStatus is a redirect status.
+// 0 is the default, 302.
+func validateRedirectStatus(status int) error {
+	switch status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return fmt.Errorf("links.redirectStatus must be 301, 302, 307 or 308, not %d", status)
+}
+
+// notFound answers a visit to a short URL that doesn't exist: a not-found
+// hook may handle it, otherwise the visitor goes back to the index page.
+func (s *Server) notFound(w http.ResponseWriter, r *http.Request, shortURL string) {
+	if s.runNotFoundHooks(w, r, shortURL) {
+		return
+	}
+	http.Redirect(w, r, "/?error="+url.QueryEscape("Short URL not found"), http.StatusFound)
+}
+
+// statsPage is the data for stats.html.
+type statsPage struct {
+	store.Stats
+	BrokenLinks []store.BrokenLink
+	Heatmap     *heatmapTable
+	Trending    []trendingList
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling stats request")
+
+	stats, err := s.stats(r.Context())
+	if err != nil {
+		logf(r.Context(), "Error fetching stats: %v", err)
+		httpError(w, "Error fetching stats", http.StatusInternalServerError)
+		return
+	}
+
+	// A missing broken links section shouldn't take the whole page down.
+	broken, err := s.store.BrokenLinks(r.Context())
+	if err != nil {
+		logf(r.Context(), "Error fetching broken links: %v", err)
+	}
+	page := statsPage{Stats: stats, BrokenLinks: broken, Heatmap: s.pageHeatmap(r, ""), Trending: s.pageTrending(r)}
+
+	tmpl, err := s.templates.lookup("stats.html")
+	if err != nil {
+		logf(r.Context(), "Error loading stats template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	if err := executeRevalidated(w, r, tmpl, page, false); err != nil {
+		logf(r.Context(), "Error executing stats template: %v", err)
+		httpError(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
+
+// createShortURL returns the existing short URL for longURL, or stores it
+// under a new random code.
+func (s *Server) createShortURL(ctx context.Context, longURL string) (shortURL string, created bool, err error) {
+	// First, check if the long URL already exists
+	existingShortURL, err := s.store.ShortURLFor(ctx, longURL)
+	if err == nil {
+		// If we found an existing short URL, return it
+		logf(ctx, "Found existing short URL '%s' for long URL '%s'", existingShortURL, longURL)
+		return existingShortURL, false, nil
+	} else if err != store.ErrNotFound {
+		// If there was an error other than "no rows", return it
+		logf(ctx, "Error checking for existing long URL: %v", err)
+		return "", false, err
+	}
+
+	// If we didn't find an existing short URL, create a new one
+	shortURL, err = s.generateShortURL(ctx, longURL)
+	return shortURL, err == nil, err
+}
+
+// generateShortURL stores longURL under a new code, even if another code
+// already points at it. Codes are random unless shortURL.strategy is
+// "sequential".
+func (s *Server) generateShortURL(ctx context.Context, longURL string) (string, error) {
+	if s.cfg.ShortURL.Strategy == strategySequential {
+		return s.sequentialShortURL(ctx, longURL)
+	}
+	length := s.codeLength.length(s.cfg.ShortURL.Length)
+	collisions := 0
+	for attempt := 0; ; attempt++ {
+		if attempt >= s.maxRetries() {
+			s.codeLength.observe(collisions, s.cfg.ShortURL.Length, s.cfg.ShortURL.MaxCollisionRate)
+			logf(ctx, "Error creating short URL: gave up after %d attempts at length %d", attempt, length)
+			return "", errKeyspaceExhausted
+		}
+		shortURL := s.sourceCodes(ctx).Prefix + randomString(length, s.charset(ctx))
+		if s.blockedCode(shortURL) {
+			logf(ctx, "Generated short URL '%s' contains a blocked word, trying another", shortURL)
+			continue
+		}
+		logf(ctx, "Generated random short URL: '%s'", shortURL)
+		exists, err := s.store.Exists(ctx, shortURL)
+		if err != nil {
+			logf(ctx, "Error checking if short URL exists: %v", err)
+			return "", err
+		}
+		if exists {
+			collisions++
+			continue
+		}
+		err = s.store.Create(ctx, shortURL, longURL)
+		if err == store.ErrExists {
+			collisions++
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		s.codeLength.observe(collisions, s.cfg.ShortURL.Length, s.cfg.ShortURL.MaxCollisionRate)
+		return shortURL, nil
+	}
+}
+
+func randomString(length int, charset string) string {
+	b := make([]byte, length)
+	_, _ = rand.Read(b)
+	for i := range b {
+		b[i] = charset[b[i]%byte(len(charset))]
+	}
+	return string(b)
+}
+
+// linkStatsPage is the data for link_stats.html. Embedding LinkStats keeps
+// themes written against the plain link working.
+type linkStatsPage struct {
+	store.LinkStats
+	Variants  []store.Variant
+	Heatmap   *heatmapTable
+	BotVisits *int
+}
+
+// handleLinkStats renders the stats page of one link, with its variants,
+// bot visits and click heatmap when there are any.
+func (s *Server) handleLinkStats(w http.ResponseWriter, r *http.Request, shortURL string) {
+	logf(r.Context(), "Handling stats request for short URL: %s", shortURL)
+	if !s.authorizedLinkStats(r, shortURL) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty stats"`)
+		httpError(w, "This link's stats are private, ask its owner for a share link", http.StatusUnauthorized)
+		return
+	}
+
+	linkStats, err := s.store.Link(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching stats for short URL %s: %v", shortURL, err)
+		httpError(w, "Error fetching link stats", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("link_stats.html")
+	if err != nil {
+		logf(r.Context(), "Error loading link stats template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+
+	variants, err := s.store.Variants(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching variants for short URL %s: %v", shortURL, err)
+		httpError(w, "Error fetching link stats", http.StatusInternalServerError)
+		return
+	}
+
+	page := linkStatsPage{LinkStats: linkStats, Variants: variants, Heatmap: s.pageHeatmap(r, shortURL)}
+	if s.cfg.Analytics.FilterBots {
+		if bots, err := s.store.BotVisits(r.Context(), shortURL); err != nil {
+			logf(r.Context(), "Error fetching bot visits for short URL '%s': %v", shortURL, err)
+		} else {
+			page.BotVisits = &bots
+		}
+	}
+	if err := executeRevalidated(w, r, tmpl, page, s.cfg.Share.PrivateStats); err != nil {
+		logf(r.Context(), "Error executing link stats template: %v", err)
+		httpError(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/outbound"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Health checks request every link's long URL now and then, so dead
+// destinations show up on the stats page and links with a fallback URL
+// can route around them.
+
+// checkTarget requests longURL and reports how it answered.
+func checkTarget(ctx context.Context, client *outbound.Client, longURL string) store.Health {
+	health := store.Health{CheckedAt: time.Now().UTC()}
+	status, err := client.Status(ctx, longURL)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.StatusCode = status
+	return health
+}
+
+// checkLinks health-checks the long URL of every http and https link on
+// the job pool, up to health.concurrency at a time, and waits for the
+// checks to finish.
+func (s *Server) checkLinks(ctx context.Context) error {
+	links, err := s.store.Links(ctx)
+	if err != nil {
+		return err
+	}
+
+	concurrency := s.cfg.Health.Concurrency
+	if concurrency <= 0 {
+		concurrency = config.DefaultHealthConcurrency
+	}
+	timeout := s.cfg.Health.Timeout.Or(config.DefaultHealthTimeout)
+
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	checked, broken := 0, 0
+	var queueErr error
+	for _, link := range links {
+		if u, err := url.Parse(link.LongURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		link := link
+		wg.Add(1)
+		queueErr = s.jobs.wait(ctx, &job{
+			name:     "health check",
+			attempts: 1,
+			run: func(jobCtx context.Context) error {
+				checkCtx, cancel := context.WithTimeout(jobCtx, timeout)
+				health := checkTarget(checkCtx, s.outbound, link.LongURL)
+				cancel()
+				if err := s.store.SetHealth(jobCtx, link.ShortURL, health); err != nil {
+					return fmt.Errorf("error saving health of short URL '%s': %v", link.ShortURL, err)
+				}
+				mu.Lock()
+				checked++
+				if !health.Healthy() {
+					broken++
+					log.Printf("Health check of '%s' failed: '%s' answered %s", link.ShortURL, link.LongURL, health.Describe())
+				}
+				mu.Unlock()
+				return nil
+			},
+			done: func(error) {
+				<-slots
+				wg.Done()
+			},
+		})
+		if queueErr != nil {
+			<-slots
+			wg.Done()
+			break
+		}
+	}
+	wg.Wait()
+
+	log.Printf("Health checked %d link(s), %d broken", checked, broken)
+	if queueErr != nil {
+		return queueErr
+	}
+	return ctx.Err()
+}
+
+// startHealthChecks runs checkLinks every health.interval until ctx is
+// cancelled. It does nothing when no interval is set.
+func (s *Server) startHealthChecks(ctx context.Context) {
+	interval := s.cfg.Health.Interval.Duration
+	if interval <= 0 {
+		return
+	}
+	log.Printf("Scheduled link health checks every %v", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !s.lead(ctx, "health", interval) {
+					continue
+				}
+				if err := s.checkLinks(ctx); err != nil {
+					log.Printf("Error running health checks: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// fallbackFor returns the link's fallback URL when its long URL failed the
+// last health check. Only links with a fallback are looked up.
+func (s *Server) fallbackFor(ctx context.Context, shortURL string, opts store.Options) (string, bool) {
+	if opts.FallbackURL == "" {
+		return "", false
+	}
+	health, err := s.store.Health(ctx, shortURL)
+	if err == store.ErrNotFound {
+		return "", false
+	}
+	if err != nil {
+		logf(ctx, "Error fetching health of short URL '%s': %v", shortURL, err)
+		return "", false
+	}
+	if health.Healthy() {
+		return "", false
+	}
+	logf(ctx, "Long URL of '%s' is down (%s), using fallback '%s'", shortURL, health.Describe(), opts.FallbackURL)
+	return opts.FallbackURL, true
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/outbound"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestCheckTarget(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}
+	}))
+	defer target.Close()
+
+	tests := map[string]int{
+		"/":        http.StatusOK,
+		"/gone":    http.StatusNotFound,
+		"/no-head": http.StatusOK,
+	}
+	client := outbound.New(outbound.Config{AllowPrivate: true})
+	for path, want := range tests {
+		health := checkTarget(context.Background(), client, target.URL+path)
+		if health.StatusCode != want || health.Error != "" {
+			t.Errorf("checkTarget(%q) returned %+v, want status %v", path, health, want)
+		}
+	}
+
+	health := checkTarget(context.Background(), client, "http://127.0.0.1:1/")
+	if health.Healthy() || health.Error == "" {
+		t.Errorf("checkTarget of an unreachable host returned %+v, want an error", health)
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer target.Close()
+
+	srv, mock := newMockServer(t)
+	startJobs(t, srv)
+	srv.cfg.Health.Concurrency = 1
+	srv.outbound = outbound.New(outbound.Config{AllowPrivate: true})
+
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at FROM url_mapping WHERE deleted_at = '' ORDER BY rowid").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("app", "myapp://item/1", 0, "2024-01-02 03:04:05").
+			AddRow("dead01", target.URL, 0, "2024-01-02 03:04:05"))
+	mock.ExpectExec("INSERT INTO link_health").
+		WithArgs("dead01", http.StatusNotFound, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := srv.checkLinks(context.Background()); err != nil {
+		t.Fatalf("checkLinks returned an error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRedirectFallback(t *testing.T) {
+	srv, mock := newMockServer(t)
+
+	mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+		WithArgs("docs").
+		WillReturnRows(sqlmock.NewRows([]string{"long_url"}).AddRow("https://example.com/docs"))
+	expectOptions(mock, "docs", store.Options{FallbackURL: "https://mirror.example/docs"})
+	mock.ExpectQuery("SELECT status_code, error, checked_at FROM link_health").
+		WithArgs("docs").
+		WillReturnRows(sqlmock.NewRows([]string{"status_code", "error", "checked_at"}).AddRow(503, "", "2024-01-02 03:04:05"))
+	mock.ExpectExec("UPDATE url_mapping SET visit_count").
+		WithArgs("docs").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/docs", nil))
+
+	if location := rr.Header().Get("Location"); location != "https://mirror.example/docs" {
+		t.Errorf("handler returned wrong redirect location: got %v want %v", location, "https://mirror.example/docs")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStatsEscapesBrokenLinks(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	longURL := `https://example.com/"><script>alert(1)</script>`
+	if err := st.Create(ctx, "abc123", longURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetHealth(ctx, "abc123", store.Health{Error: "<b>refused</b>", CheckedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/stats", nil))
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "Broken Links") {
+		t.Fatal("stats page doesn't list the broken link")
+	}
+	if strings.Contains(body, "<script>alert") || strings.Contains(body, "<b>refused") {
+		t.Error("stats page doesn't escape broken links")
+	}
+}
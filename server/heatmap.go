@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Heatmaps show when visitors click: by hour of the day and day of the
+// week, for one link or all of them. They are counted from the click log,
+// so they need analytics.clickLog, and are in UTC.
+
+// heatmapCell is one hour of one weekday. Level runs from 0 for no clicks
+// to 4 for the busiest hour, for shading.
+type heatmapCell struct {
+	Hour   int
+	Clicks int
+	Level  int
+}
+
+type heatmapRow struct {
+	Day   string
+	Cells []heatmapCell
+}
+
+// heatmapTable is a heatmap laid out for the stats templates.
+type heatmapTable struct {
+	Hours []int
+	Rows  []heatmapRow
+}
+
+func newHeatmapTable(h store.Heatmap) *heatmapTable {
+	most := 0
+	for _, day := range h {
+		for _, clicks := range day {
+			if clicks > most {
+				most = clicks
+			}
+		}
+	}
+	table := &heatmapTable{Hours: make([]int, 24)}
+	for hour := range table.Hours {
+		table.Hours[hour] = hour
+	}
+	for weekday, day := range h {
+		row := heatmapRow{Day: time.Weekday(weekday).String()[:3], Cells: make([]heatmapCell, 24)}
+		for hour, clicks := range day {
+			cell := heatmapCell{Hour: hour, Clicks: clicks}
+			if clicks > 0 {
+				cell.Level = 1 + clicks*3/most
+			}
+			row.Cells[hour] = cell
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// pageHeatmap returns the heatmap for a stats page, or nil when the click
+// log is off or can't be read.
+func (s *Server) pageHeatmap(r *http.Request, shortURL string) *heatmapTable {
+	if !s.cfg.Analytics.ClickLog {
+		return nil
+	}
+	heatmap, err := s.store.ClickHeatmap(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching click heatmap: %v", err)
+		return nil
+	}
+	return newHeatmapTable(heatmap)
+}
+
+type heatmapResponse struct {
+	ShortURL  string        `json:"shortURL,omitempty"`
+	Heatmap   store.Heatmap `json:"heatmap"`
+	ByHour    [24]int       `json:"byHour"`
+	ByWeekday [7]int        `json:"byWeekday"`
+}
+
+// handleAPIHeatmap serves GET /api/v1/stats/heatmap, for every link or for
+// the one named by ?shortURL=.
+func (s *Server) handleAPIHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.cfg.Analytics.ClickLog {
+		writeAPIError(w, http.StatusServiceUnavailable, errCodeClickLogDisabled, "The click log is turned off")
+		return
+	}
+
+	shortURL := r.URL.Query().Get("shortURL")
+	if shortURL != "" {
+		if _, err := s.store.Link(r.Context(), shortURL); err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		} else if err != nil {
+			logf(r.Context(), "Error fetching short URL '%s': %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching heatmap")
+			return
+		}
+	}
+	heatmap, err := s.store.ClickHeatmap(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching click heatmap: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching heatmap")
+		return
+	}
+	writeRevalidatedJSON(w, r, heatmapResponse{
+		ShortURL:  shortURL,
+		Heatmap:   heatmap,
+		ByHour:    heatmap.ByHour(),
+		ByWeekday: heatmap.ByWeekday(),
+	}, false)
+}
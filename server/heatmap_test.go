@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestNewHeatmapTable(t *testing.T) {
+	var h store.Heatmap
+	h[1][9] = 8
+	h[1][10] = 2
+	table := newHeatmapTable(h)
+
+	if len(table.Rows) != 7 || table.Rows[1].Day != "Mon" {
+		t.Fatalf("heatmap table has unexpected rows: %+v", table.Rows)
+	}
+	want := map[int]int{9: 4, 10: 1, 11: 0}
+	for hour, level := range want {
+		if got := table.Rows[1].Cells[hour].Level; got != level {
+			t.Errorf("level at Monday %d:00 = %d, want %d", hour, got, level)
+		}
+	}
+}
+
+func TestHandleAPIHeatmap(t *testing.T) {
+	t.Run("Click Log Off", func(t *testing.T) {
+		srv, _ := newMemoryServer(t)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/heatmap", nil))
+		checkAPIError(t, rr, http.StatusServiceUnavailable, errCodeClickLogDisabled)
+	})
+
+	t.Run("Unknown Link", func(t *testing.T) {
+		srv, _ := newMemoryServer(t)
+		srv.cfg.Analytics.ClickLog = true
+
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/heatmap?shortURL=missing", nil))
+		checkAPIError(t, rr, http.StatusNotFound, errCodeNotFound)
+	})
+
+	t.Run("All Links", func(t *testing.T) {
+		srv, mock := newMockServer(t)
+		srv.cfg.Analytics.ClickLog = true
+		mock.ExpectQuery("FROM clicks").
+			WithArgs("", "").
+			WillReturnRows(sqlmock.NewRows([]string{"weekday", "hour", "count"}).AddRow(2, 14, 5).AddRow(3, 14, 1))
+
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/heatmap", nil))
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var resp heatmapResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Heatmap[2][14] != 5 || resp.ByHour[14] != 6 || resp.ByWeekday[3] != 1 {
+			t.Errorf("handler returned unexpected heatmap: %+v", resp)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
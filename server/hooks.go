@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/policy"
+)
+
+// CreateHook runs before a link is stored, whatever interface it was
+// created through. It returns the long URL to store, which lets a hook
+// rewrite the destination, or an error to reject the link. Returning a
+// policy.HoldError creates the link but holds it for review instead. ctx is
+// the request's context, so values set by middleware are visible to the
+// hook.
+type CreateHook func(ctx context.Context, longURL, alias string) (string, error)
+
+// RedirectHook runs before a visitor is sent to longURL. It returns the
+// destination to redirect to, or an error to refuse the redirect.
+type RedirectHook func(r *http.Request, shortURL, longURL string) (string, error)
+
+// NotFoundHook runs when a visited short URL doesn't exist. It reports
+// whether it wrote the response itself; otherwise the visitor is sent back
+// to the index page as usual.
+type NotFoundHook func(w http.ResponseWriter, r *http.Request, shortURL string) bool
+
+// Middleware wraps the server's handler, in the form used by most Go
+// routers.
+type Middleware func(http.Handler) http.Handler
+
+// hooks holds the functions registered with OnCreate, OnRedirect and
+// OnNotFound. They run in registration order.
+type hooks struct {
+	create   []CreateHook
+	redirect []RedirectHook
+	notFound []NotFoundHook
+}
+
+// OnCreate registers a hook that runs before every link is created. Hooks
+// must be registered before the server starts handling requests.
+func (s *Server) OnCreate(h CreateHook) {
+	s.hooks.create = append(s.hooks.create, h)
+}
+
+// OnRedirect registers a hook that runs before every redirect.
+func (s *Server) OnRedirect(h RedirectHook) {
+	s.hooks.redirect = append(s.hooks.redirect, h)
+}
+
+// OnNotFound registers a hook that runs when a short URL isn't found.
+func (s *Server) OnNotFound(h NotFoundHook) {
+	s.hooks.notFound = append(s.hooks.notFound, h)
+}
+
+// Use wraps every request the server handles in mw. The first middleware
+// registered is the outermost. Like the hooks, middleware must be added
+// before the server starts handling requests.
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+	var h http.Handler = s.requireBasicAuth(s.mux)
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	s.handler = h
+}
+
+// rejectedError wraps the error a hook used to refuse a link or redirect,
+// so handlers can tell a refusal apart from a storage failure.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+
+func (e *rejectedError) Unwrap() error { return e.err }
+
+func isRejected(err error) bool {
+	var rejected *rejectedError
+	return errors.As(err, &rejected)
+}
+
+// runCreateHooks returns the long URL as the create hooks leave it. When
+// hooks asked to hold the link for review, hold gives their reasons; with
+// moderation off a hold is a refusal like any other.
+func (s *Server) runCreateHooks(ctx context.Context, longURL, alias string) (rewritten, hold string, err error) {
+	var holds []string
+	for _, h := range s.hooks.create {
+		next, err := h(ctx, longURL, alias)
+		var held *policy.HoldError
+		if s.cfg.Moderation.Enabled && errors.As(err, &held) {
+			holds = append(holds, held.Reason)
+			continue
+		}
+		if err != nil {
+			return "", "", &rejectedError{err}
+		}
+		longURL = next
+	}
+	return longURL, strings.Join(holds, "; "), nil
+}
+
+func (s *Server) runRedirectHooks(r *http.Request, shortURL, longURL string) (string, error) {
+	for _, h := range s.hooks.redirect {
+		var err error
+		if longURL, err = h(r, shortURL, longURL); err != nil {
+			return "", &rejectedError{err}
+		}
+	}
+	return longURL, nil
+}
+
+func (s *Server) runNotFoundHooks(w http.ResponseWriter, r *http.Request, shortURL string) bool {
+	for _, h := range s.hooks.notFound {
+		if h(w, r, shortURL) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestCreateHooks(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.OnCreate(func(ctx context.Context, longURL, alias string) (string, error) {
+		if strings.Contains(longURL, "blocked.example") {
+			return "", errors.New("destination is blocked")
+		}
+		return strings.Replace(longURL, "http://", "https://", 1), nil
+	})
+
+	t.Run("Rewrite", func(t *testing.T) {
+		if _, err := srv.shortenURL(context.Background(), "http://example.com", "launch"); err != nil {
+			t.Fatalf("shortenURL returned an error: %v", err)
+		}
+		if longURL, err := st.LongURL(context.Background(), "launch"); err != nil || longURL != "https://example.com" {
+			t.Errorf("store has %q, %v for launch", longURL, err)
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		req := newCreateRequest(t, "url=https://blocked.example/page")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+	})
+}
+
+func TestRedirectHooks(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	for _, shortURL := range []string{"abc123", "refused"} {
+		if err := st.Create(context.Background(), shortURL, "https://example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.OnRedirect(func(r *http.Request, shortURL, longURL string) (string, error) {
+		if shortURL == "refused" {
+			return "", errors.New("link disabled")
+		}
+		return longURL + "?ref=shorty", nil
+	})
+
+	t.Run("Rewrite", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/abc123", nil))
+
+		if location := rr.Header().Get("Location"); location != "https://example.com?ref=shorty" {
+			t.Errorf("handler returned wrong redirect location: got %v want %v", location, "https://example.com?ref=shorty")
+		}
+	})
+
+	t.Run("Refuse", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/refused", nil))
+
+		if location := rr.Header().Get("Location"); !strings.HasPrefix(location, "/?error=") {
+			t.Errorf("handler returned wrong redirect location: got %v", location)
+		}
+		// A refused redirect isn't counted.
+		if link, err := st.Link(context.Background(), "refused"); err != nil || link.VisitCount != 0 {
+			t.Errorf("link has %d visits, %v want 0", link.VisitCount, err)
+		}
+	})
+}
+
+func TestNotFoundHook(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.OnNotFound(func(w http.ResponseWriter, r *http.Request, shortURL string) bool {
+		http.Error(w, "No such link: "+shortURL, http.StatusNotFound)
+		return true
+	})
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/missing", nil))
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestUse(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	srv.Use(tag("outer"), tag("inner"))
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+
+	if strings.Join(order, ",") != "outer,inner" {
+		t.Errorf("middleware ran in wrong order: got %v want %v", order, []string{"outer", "inner"})
+	}
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestPolicyPlugins(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Plugins = []config.Plugin{{Name: "allowlist", Config: json.RawMessage(`{"hosts": ["example.com"]}`)}}
+
+	srv, err := New(srv.cfg, srv.store)
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	if _, err := srv.shortenURL(context.Background(), "https://evil.example/", ""); !isRejected(err) {
+		t.Errorf("Expected the allowlist to reject the link, got %v", err)
+	}
+
+	srv.cfg.Plugins = []config.Plugin{{Name: "no-such-policy"}}
+	if _, err := New(srv.cfg, srv.store); err == nil {
+		t.Error("Expected an error for an unknown plugin, got nil")
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// With server.allowedHosts set, requests whose Host header isn't listed are
+// refused before any handler sees them. Without server.publicURL, short
+// URLs are built from the request's host, so a forged Host header could
+// otherwise put links to somewhere else into responses a cache keeps.
+
+// hostPatterns is server.allowedHosts, lowercased. "*.example.com" matches
+// any subdomain of example.com, but not example.com itself.
+type hostPatterns []string
+
+// parseAllowedHosts reads server.allowedHosts. Entries are host names or
+// addresses without a port, or a host name behind a "*." wildcard.
+func parseAllowedHosts(entries []string) (hostPatterns, error) {
+	var patterns hostPatterns
+	for _, entry := range entries {
+		host := strings.ToLower(strings.TrimSpace(entry))
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		name := strings.TrimPrefix(host, "*.")
+		if net.ParseIP(host) == nil && (name == "" || strings.ContainsAny(name, ":/*")) {
+			return nil, fmt.Errorf("invalid server.allowedHosts entry %q: want a host name or address without a port, or \"*.\" and a host name", entry)
+		}
+		patterns = append(patterns, host)
+	}
+	return patterns, nil
+}
+
+// allows reports whether host, as requestHost returns it, matches one of
+// the patterns. No patterns allow any host.
+func (p hostPatterns) allows(host string) bool {
+	if len(p) == 0 {
+		return true
+	}
+	for _, pattern := range p {
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestParseAllowedHosts(t *testing.T) {
+	patterns, err := parseAllowedHosts([]string{"Sho.rt", "*.sho.rt", "127.0.0.1", "[::1]"})
+	if err != nil {
+		t.Fatalf("parseAllowedHosts returned an error: %v", err)
+	}
+	for host, want := range map[string]bool{
+		"sho.rt":      true,
+		"a.sho.rt":    true,
+		"a.b.sho.rt":  true,
+		"127.0.0.1":   true,
+		"::1":         true,
+		"evilsho.rt":  false,
+		"sho.rt.evil": false,
+		"":            false,
+	} {
+		if got := patterns.allows(host); got != want {
+			t.Errorf("allows(%q) = %v want %v", host, got, want)
+		}
+	}
+	if !hostPatterns(nil).allows("anything.example") {
+		t.Error("an empty allowlist refused a host")
+	}
+
+	for _, entry := range []string{"", "*.", "sho.rt:8080", "https://sho.rt", "a.*.sho.rt", "*"} {
+		if _, err := parseAllowedHosts([]string{entry}); err == nil {
+			t.Errorf("parseAllowedHosts accepted %q", entry)
+		}
+	}
+}
+
+func TestAllowedHosts(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.AllowedHosts = []string{"sho.rt", "*.sho.rt"}
+	srv, err := New(cfg, store.NewMemory())
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+	tenant, _ := newMemoryServer(t)
+	srv.AddTenant("go.acme.com", tenant)
+
+	for host, want := range map[string]int{
+		"sho.rt:8080": http.StatusOK,
+		"www.sho.rt":  http.StatusOK,
+		"go.acme.com": http.StatusOK,
+		"evil.com":    http.StatusMisdirectedRequest,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", host, status, want)
+		}
+	}
+
+	cfg.Server.AllowedHosts = []string{"sho.rt:8080"}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted an allowed host with a port")
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// With cache.hotLinks set, the most visited links are kept in memory, so a
+// cold start or a viral link doesn't send every redirect to the database.
+// The cache is loaded at startup and reloaded every cache.hotLinksRefresh,
+// which also picks up changes made by other replicas.
+
+// preloadHotLinks reloads the hot link cache.
+func (s *Server) preloadHotLinks(ctx context.Context) {
+	loaded, err := s.hot.Preload(ctx, s.cfg.Cache.HotLinks)
+	if err != nil {
+		log.Printf("Error preloading hot links: %v", err)
+		return
+	}
+	log.Printf("Preloaded %d hot link(s) into memory", loaded)
+}
+
+// startHotLinks loads the hot link cache, then reloads it every
+// cache.hotLinksRefresh until ctx is cancelled. It does nothing when the
+// cache is off.
+func (s *Server) startHotLinks(ctx context.Context) {
+	if s.hot == nil {
+		return
+	}
+	interval := s.cfg.Cache.HotLinksRefresh.Or(config.DefaultHotLinksRefresh)
+	s.preloadHotLinks(ctx)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.preloadHotLinks(ctx)
+			}
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestStartHotLinks(t *testing.T) {
+	srv, mock := newMockServer(t)
+	if srv.hot != nil {
+		t.Fatal("hot link cache is on without cache.hotLinks")
+	}
+	srv.cfg.Cache.HotLinks = 10
+	srv.hot = store.NewHotCached(srv.store)
+	srv.store = srv.hot
+
+	mock.ExpectQuery("SELECT short_url, long_url, pass_query, .* ORDER BY visit_count DESC LIMIT").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "pass_query", "prefix", "utm_source", "utm_medium", "utm_campaign", "fallback_url", "max_clicks", "no_analytics", "expires_at", "active", "flagged", "taken_down", "api_key"}).
+			AddRow("abc", "https://example.com", false, false, "", "", "", "", 0, false, "", true, false, false, ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.startHotLinks(ctx)
+
+	// Served from memory, with no query expected.
+	if longURL, err := srv.store.LongURL(ctx, "abc"); err != nil || longURL != "https://example.com" {
+		t.Errorf("LongURL returned %q, %v", longURL, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
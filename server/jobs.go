@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// Background work triggered by requests and schedules, such as health
+// checks, rollups, webhook and email notifications, analytics sink
+// flushes and event bus batches, runs on one pool of
+// jobs.workers workers instead of a goroutine each, so a burst of work
+// can't pile up goroutines or connections. A job that fails is retried
+// with exponential backoff until it runs out of attempts. The workers
+// start with Start, and jobs queued before then wait for them. Drain stops
+// the pool at shutdown once the queued jobs are done.
+
+var errPoolClosed = errors.New("job pool is closed")
+
+// jobBackoff is the wait before the first retry, doubling with each
+// retry after it up to maxJobBackoff.
+var jobBackoff = time.Second
+
+const maxJobBackoff = time.Minute
+
+// job is a unit of background work.
+type job struct {
+	name string
+	// attempts is how many times run is tried before the job fails.
+	attempts int
+	run      func(ctx context.Context) error
+	// done, if set, is called once with the job's final result.
+	done func(err error)
+
+	attempt int
+}
+
+// jobPool runs jobs on a fixed number of workers.
+type jobPool struct {
+	queue chan *job
+	stop  chan struct{}
+	ctx   context.Context
+	// cancel aborts running jobs when a drain runs out of time.
+	cancel context.CancelFunc
+
+	// mu is held for reading while queueing and for writing while
+	// closing, so no job is queued once the workers may have stopped.
+	mu      sync.RWMutex
+	closed  bool
+	size    int
+	started sync.Once
+	workers sync.WaitGroup
+}
+
+func newJobPool(workers, queueSize int) *jobPool {
+	if workers <= 0 {
+		workers = config.DefaultJobWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = config.DefaultJobQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobPool{
+		queue:  make(chan *job, queueSize),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		size:   workers,
+	}
+}
+
+// start launches the workers, once.
+func (p *jobPool) start() {
+	p.started.Do(func() {
+		for i := 0; i < p.size; i++ {
+			p.workers.Add(1)
+			go p.work()
+		}
+	})
+}
+
+// backoff is how long to wait before the attempt after attempt.
+func backoff(attempt int) time.Duration {
+	d := jobBackoff << (attempt - 1)
+	if d <= 0 || d > maxJobBackoff {
+		return maxJobBackoff
+	}
+	return d
+}
+
+// submit queues j and reports whether it was taken. It doesn't wait: when
+// the queue is full or the pool is draining, the job is dropped.
+func (p *jobPool) submit(j *job) bool {
+	if !p.offer(j) {
+		log.Printf("Job queue is full or closed, dropped %s job", j.name)
+		return false
+	}
+	return true
+}
+
+// offer queues j if there is room.
+func (p *jobPool) offer(j *job) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return false
+	}
+	select {
+	case p.queue <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait queues j, waiting for room in the queue until ctx is done, for
+// producers of many jobs that shouldn't drop any.
+func (p *jobPool) wait(ctx context.Context, j *job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errPoolClosed
+	}
+	select {
+	case p.queue <- j:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run queues j, waiting for room like wait, and then waits for its final
+// result, for loops that mustn't start their next piece of work before the
+// last is done.
+func (p *jobPool) run(ctx context.Context, j *job) error {
+	result := make(chan error, 1)
+	done := j.done
+	j.done = func(err error) {
+		if done != nil {
+			done(err)
+		}
+		result <- err
+	}
+	if err := p.wait(ctx, j); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *jobPool) work() {
+	defer p.workers.Done()
+	for {
+		select {
+		case j := <-p.queue:
+			p.runJob(j)
+		case <-p.stop:
+			// Finish what is queued, then stop.
+			for {
+				select {
+				case j := <-p.queue:
+					p.runJob(j)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// runJob tries j once, then retries it later, or reports its result.
+func (p *jobPool) runJob(j *job) {
+	j.attempt++
+	err := j.run(p.ctx)
+	if err == nil || j.attempt >= j.attempts || p.ctx.Err() != nil {
+		if err != nil {
+			log.Printf("Error running %s job, giving up after %d attempt(s): %v", j.name, j.attempt, err)
+		}
+		if j.done != nil {
+			j.done(err)
+		}
+		return
+	}
+
+	delay := backoff(j.attempt)
+	log.Printf("Error running %s job, retrying in %v: %v", j.name, delay, err)
+	time.AfterFunc(delay, func() {
+		if !p.offer(j) {
+			log.Printf("Dropped retry of %s job", j.name)
+			if j.done != nil {
+				j.done(err)
+			}
+		}
+	})
+}
+
+// drain stops taking jobs and waits for the queued and running ones to
+// finish, starting the workers for them if start never ran. If ctx ends
+// first, running jobs are cancelled and queued ones fail. Retries still
+// waiting out their backoff are dropped.
+func (p *jobPool) drain(ctx context.Context) error {
+	p.start()
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// Drain stops the background job pool, waiting until the jobs already
+// queued have run or ctx is done. Call it at shutdown, after the HTTP
+// server has stopped taking requests.
+func (s *Server) Drain(ctx context.Context) error {
+	return s.jobs.drain(ctx)
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) returned %v want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+// startJobs starts srv's job pool, as Start would, until the test ends.
+func startJobs(t *testing.T, srv *Server) {
+	t.Helper()
+	srv.jobs.start()
+	t.Cleanup(func() { srv.Drain(context.Background()) })
+}
+
+func TestJobPoolRetries(t *testing.T) {
+	defer func(d time.Duration) { jobBackoff = d }(jobBackoff)
+	jobBackoff = time.Millisecond
+
+	pool := newJobPool(2, 10)
+	pool.start()
+	defer pool.drain(context.Background())
+
+	var runs int32
+	result := make(chan error, 1)
+	pool.submit(&job{
+		name:     "flaky",
+		attempts: 3,
+		run: func(ctx context.Context) error {
+			if atomic.AddInt32(&runs, 1) < 3 {
+				return errors.New("not yet")
+			}
+			return nil
+		},
+		done: func(err error) { result <- err },
+	})
+	select {
+	case err := <-result:
+		if err != nil || atomic.LoadInt32(&runs) != 3 {
+			t.Errorf("job finished with %v after %d run(s), want success after 3", err, runs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job never finished")
+	}
+
+	failing := errors.New("always")
+	pool.submit(&job{
+		name:     "broken",
+		attempts: 2,
+		run:      func(ctx context.Context) error { return failing },
+		done:     func(err error) { result <- err },
+	})
+	select {
+	case err := <-result:
+		if err != failing {
+			t.Errorf("job finished with %v want %v", err, failing)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job never gave up")
+	}
+}
+
+func TestJobPoolStart(t *testing.T) {
+	pool := newJobPool(1, 10)
+	ran := make(chan struct{})
+	pool.submit(&job{name: "early", attempts: 1, run: func(ctx context.Context) error {
+		close(ran)
+		return nil
+	}})
+	select {
+	case <-ran:
+		t.Fatal("a job ran before the pool started")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pool.start()
+	pool.start()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a job queued before start never ran")
+	}
+	if err := pool.drain(context.Background()); err != nil {
+		t.Errorf("drain returned an error: %v", err)
+	}
+}
+
+func TestJobPoolRun(t *testing.T) {
+	defer func(d time.Duration) { jobBackoff = d }(jobBackoff)
+	jobBackoff = time.Millisecond
+
+	pool := newJobPool(1, 10)
+	pool.start()
+	defer pool.drain(context.Background())
+
+	var runs, done int
+	err := pool.run(context.Background(), &job{
+		name:     "flaky",
+		attempts: 2,
+		run: func(ctx context.Context) error {
+			if runs++; runs < 2 {
+				return errors.New("not yet")
+			}
+			return nil
+		},
+		done: func(err error) { done++ },
+	})
+	if err != nil || runs != 2 || done != 1 {
+		t.Errorf("run returned %v after %d run(s) and %d done call(s), want success after 2 and 1", err, runs, done)
+	}
+
+	failing := errors.New("always")
+	err = pool.run(context.Background(), &job{
+		name:     "broken",
+		attempts: 1,
+		run:      func(ctx context.Context) error { return failing },
+	})
+	if err != failing {
+		t.Errorf("run returned %v want %v", err, failing)
+	}
+}
+
+func TestJobPoolDrain(t *testing.T) {
+	pool := newJobPool(1, 10)
+	release := make(chan struct{})
+	var ran int32
+	for i := 0; i < 5; i++ {
+		pool.submit(&job{name: "queued", attempts: 1, run: func(ctx context.Context) error {
+			<-release
+			atomic.AddInt32(&ran, 1)
+			return nil
+		}})
+	}
+
+	drained := make(chan error)
+	go func() { drained <- pool.drain(context.Background()) }()
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("drain returned an error: %v", err)
+	}
+	if ran != 5 {
+		t.Errorf("drain finished after %d of 5 queued jobs", ran)
+	}
+	if pool.submit(&job{name: "late", attempts: 1, run: func(context.Context) error { return nil }}) {
+		t.Error("a drained pool took a job")
+	}
+	if err := pool.wait(context.Background(), &job{name: "late", attempts: 1}); err != errPoolClosed {
+		t.Errorf("wait on a drained pool returned %v want %v", err, errPoolClosed)
+	}
+}
+
+func TestJobPoolDrainTimeout(t *testing.T) {
+	pool := newJobPool(1, 10)
+	pool.submit(&job{name: "slow", attempts: 1, run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("drain returned %v want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestJobPoolFull(t *testing.T) {
+	pool := newJobPool(1, 1)
+	pool.start()
+	release := make(chan struct{})
+	block := func(ctx context.Context) error { <-release; return nil }
+	defer func() {
+		close(release)
+		pool.drain(context.Background())
+	}()
+
+	started := make(chan struct{})
+	pool.submit(&job{name: "running", attempts: 1, run: func(ctx context.Context) error { close(started); return block(ctx) }})
+	<-started
+	if !pool.submit(&job{name: "queued", attempts: 1, run: block}) {
+		t.Fatal("pool didn't queue a job with room for it")
+	}
+	if pool.submit(&job{name: "extra", attempts: 1, run: block}) {
+		t.Error("a full pool took a job")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.wait(ctx, &job{name: "extra", attempts: 1, run: block}); err != context.DeadlineExceeded {
+		t.Errorf("wait on a full pool returned %v want %v", err, context.DeadlineExceeded)
+	}
+}
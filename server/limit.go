@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// A link created with a click cap stops redirecting once it has been
+// visited that many times, which suits giveaways and invite links. Later
+// visitors get limit.html, which themes can override like any other page.
+
+// limitPage is the data for limit.html.
+type limitPage struct {
+	ShortURL  string
+	MaxClicks int
+}
+
+// parseMaxClicks reads the click cap field of the create form. An empty
+// field means no cap.
+func parseMaxClicks(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	maxClicks, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("click limit must be a whole number")
+	}
+	return maxClicks, validateMaxClicks(maxClicks)
+}
+
+func validateMaxClicks(maxClicks int) error {
+	if maxClicks < 0 {
+		return fmt.Errorf("click limit can't be negative")
+	}
+	return nil
+}
+
+// validateClickCap checks the click cap of opts can be enforced: a link
+// without analytics doesn't count its visits.
+func validateClickCap(opts store.Options) error {
+	if err := validateMaxClicks(opts.MaxClicks); err != nil {
+		return err
+	}
+	if opts.NoAnalytics && opts.MaxClicks > 0 {
+		return fmt.Errorf("a link without analytics can't have a click limit")
+	}
+	return nil
+}
+
+// limitReached answers a visit to a link that has used up its clicks.
+func (s *Server) limitReached(w http.ResponseWriter, shortURL string, maxClicks int) {
+	tmpl, err := s.templates.lookup("limit.html")
+	if err != nil {
+		log.Printf("Error loading limit template: %v", err)
+		httpError(w, "This link has reached its click limit", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusGone)
+	if err := tmpl.Execute(w, limitPage{ShortURL: shortURL, MaxClicks: maxClicks}); err != nil {
+		log.Printf("Error executing limit template: %v", err)
+	}
+}
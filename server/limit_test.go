@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestRedirectClickLimit(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "invite", "https://example.com/invite"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetOptions(ctx, "invite", store.Options{MaxClicks: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		wantStatus int
+	}{
+		{"Under Limit", http.StatusFound},
+		{"Limit Reached", http.StatusGone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/invite", nil))
+
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusGone && !strings.Contains(rr.Body.String(), "reached its limit") {
+				t.Errorf("handler returned unexpected body: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleAPILinksMaxClicks(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+
+	body := `{"url": "https://example.com", "maxClicks": -1}`
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body)))
+
+	checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidMaxClicks)
+}
+
+func TestCreateNoAnalyticsWithClickLimit(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+
+	body := `{"url": "https://example.com", "maxClicks": 10, "noAnalytics": true}`
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body)))
+	checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidMaxClicks)
+
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, newCreateRequest(t, "url=https://example.com&max_clicks=10&no_analytics=1"))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestParseMaxClicks(t *testing.T) {
+	tests := map[string]int{"": 0, " 25 ": 25}
+	for value, want := range tests {
+		if got, err := parseMaxClicks(value); err != nil || got != want {
+			t.Errorf("parseMaxClicks(%q) returned %v, %v want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"ten", "-3", "1.5"} {
+		if _, err := parseMaxClicks(value); err == nil {
+			t.Errorf("parseMaxClicks(%q) returned no error", value)
+		}
+	}
+}
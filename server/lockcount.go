@@ -0,0 +1,412 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// lockCountingStore counts the calls to the store it wraps that fail because
+// SQLite couldn't get a lock, for the ops.locksPerMinute alert. Lock errors
+// can come out of any call, so every call goes through count.
+type lockCountingStore struct {
+	store.Store
+	ops *opsMonitor
+}
+
+// Unwrap returns the store behind the counter.
+func (l *lockCountingStore) Unwrap() store.Store {
+	return l.Store
+}
+
+// count counts err if it's a lock error and returns it unchanged.
+func (l *lockCountingStore) count(err error) error {
+	if store.Locked(err) {
+		l.ops.countLock()
+	}
+	return err
+}
+
+func (l *lockCountingStore) LongURL(ctx context.Context, shortURL string) (string, error) {
+	v, err := l.Store.LongURL(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) ShortURLFor(ctx context.Context, longURL string) (string, error) {
+	v, err := l.Store.ShortURLFor(ctx, longURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Exists(ctx context.Context, shortURL string) (bool, error) {
+	v, err := l.Store.Exists(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) NextID(ctx context.Context) (uint64, error) {
+	v, err := l.Store.NextID(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Create(ctx context.Context, shortURL, longURL string) error {
+	return l.count(l.Store.Create(ctx, shortURL, longURL))
+}
+
+func (l *lockCountingStore) Delete(ctx context.Context, shortURL string) (bool, error) {
+	v, err := l.Store.Delete(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Trash(ctx context.Context, shortURL string) (bool, error) {
+	v, err := l.Store.Trash(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Restore(ctx context.Context, shortURL string) error {
+	return l.count(l.Store.Restore(ctx, shortURL))
+}
+
+func (l *lockCountingStore) Trashed(ctx context.Context) ([]store.TrashedLink, error) {
+	v, err := l.Store.Trashed(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) PurgeTrash(ctx context.Context, cutoff time.Time) (int64, error) {
+	v, err := l.Store.PurgeTrash(ctx, cutoff)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetActive(ctx context.Context, shortURL string, active bool) error {
+	return l.count(l.Store.SetActive(ctx, shortURL, active))
+}
+
+func (l *lockCountingStore) SetLongURL(ctx context.Context, shortURL, longURL string) error {
+	return l.count(l.Store.SetLongURL(ctx, shortURL, longURL))
+}
+
+func (l *lockCountingStore) RecordVisit(ctx context.Context, shortURL string) (bool, error) {
+	v, err := l.Store.RecordVisit(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) RecordBotVisit(ctx context.Context, shortURL string) (bool, error) {
+	v, err := l.Store.RecordBotVisit(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) BotVisits(ctx context.Context, shortURL string) (int, error) {
+	v, err := l.Store.BotVisits(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Targets(ctx context.Context, shortURL string) (store.Targets, error) {
+	v, err := l.Store.Targets(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetTargets(ctx context.Context, shortURL string, targets store.Targets) error {
+	return l.count(l.Store.SetTargets(ctx, shortURL, targets))
+}
+
+func (l *lockCountingStore) Card(ctx context.Context, shortURL string) (store.Card, error) {
+	v, err := l.Store.Card(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetCard(ctx context.Context, shortURL string, card store.Card) error {
+	return l.count(l.Store.SetCard(ctx, shortURL, card))
+}
+
+func (l *lockCountingStore) Options(ctx context.Context, shortURL string) (store.Options, error) {
+	v, err := l.Store.Options(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetOptions(ctx context.Context, shortURL string, opts store.Options) error {
+	return l.count(l.Store.SetOptions(ctx, shortURL, opts))
+}
+
+func (l *lockCountingStore) Variants(ctx context.Context, shortURL string) ([]store.Variant, error) {
+	v, err := l.Store.Variants(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetVariants(ctx context.Context, shortURL string, variants []store.Variant) error {
+	return l.count(l.Store.SetVariants(ctx, shortURL, variants))
+}
+
+func (l *lockCountingStore) RecordVariantVisit(ctx context.Context, shortURL, name string) error {
+	return l.count(l.Store.RecordVariantVisit(ctx, shortURL, name))
+}
+
+func (l *lockCountingStore) Tags(ctx context.Context, shortURL string) ([]string, error) {
+	v, err := l.Store.Tags(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetTags(ctx context.Context, shortURL string, tags []string) error {
+	return l.count(l.Store.SetTags(ctx, shortURL, tags))
+}
+
+func (l *lockCountingStore) TaggedLinks(ctx context.Context, tag string) ([]string, error) {
+	v, err := l.Store.TaggedLinks(ctx, tag)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SearchLinks(ctx context.Context, query string, limit int) ([]store.LinkStats, error) {
+	v, err := l.Store.SearchLinks(ctx, query, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) FlagLink(ctx context.Context, shortURL, source, reason string) error {
+	return l.count(l.Store.FlagLink(ctx, shortURL, source, reason))
+}
+
+func (l *lockCountingStore) ClearFlag(ctx context.Context, shortURL string) (bool, error) {
+	v, err := l.Store.ClearFlag(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) FlaggedLinks(ctx context.Context) ([]store.FlaggedLink, error) {
+	v, err := l.Store.FlaggedLinks(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) BanDomain(ctx context.Context, domain, reason string) error {
+	return l.count(l.Store.BanDomain(ctx, domain, reason))
+}
+
+func (l *lockCountingStore) UnbanDomain(ctx context.Context, domain string) (bool, error) {
+	v, err := l.Store.UnbanDomain(ctx, domain)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) BannedDomains(ctx context.Context) ([]store.BannedDomain, error) {
+	v, err := l.Store.BannedDomains(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) CreateTakedown(ctx context.Context, takedown store.Takedown) (int64, error) {
+	v, err := l.Store.CreateTakedown(ctx, takedown)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) TakeDown(ctx context.Context, shortURL string, id int64) error {
+	return l.count(l.Store.TakeDown(ctx, shortURL, id))
+}
+
+func (l *lockCountingStore) Takedowns(ctx context.Context) ([]store.Takedown, error) {
+	v, err := l.Store.Takedowns(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetAPIKey(ctx context.Context, shortURL, key string) error {
+	return l.count(l.Store.SetAPIKey(ctx, shortURL, key))
+}
+
+func (l *lockCountingStore) RecordUsage(ctx context.Context, key, month string, creates, redirects int) error {
+	return l.count(l.Store.RecordUsage(ctx, key, month, creates, redirects))
+}
+
+func (l *lockCountingStore) Usage(ctx context.Context, key string) ([]store.Usage, error) {
+	v, err := l.Store.Usage(ctx, key)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) DeleteUsage(ctx context.Context, key string) error {
+	return l.count(l.Store.DeleteUsage(ctx, key))
+}
+
+func (l *lockCountingStore) SetOwner(ctx context.Context, shortURL, owner string) error {
+	return l.count(l.Store.SetOwner(ctx, shortURL, owner))
+}
+
+func (l *lockCountingStore) OwnerLinks(ctx context.Context, owner string) ([]store.LinkStats, error) {
+	v, err := l.Store.OwnerLinks(ctx, owner)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) AddSessionLink(ctx context.Context, session, shortURL string) error {
+	return l.count(l.Store.AddSessionLink(ctx, session, shortURL))
+}
+
+func (l *lockCountingStore) SessionLinks(ctx context.Context, session string, limit int) ([]store.LinkStats, error) {
+	v, err := l.Store.SessionLinks(ctx, session, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) PurgeSessionLinks(ctx context.Context, cutoff time.Time) (int64, error) {
+	v, err := l.Store.PurgeSessionLinks(ctx, cutoff)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) CreateTeam(ctx context.Context, name string) error {
+	return l.count(l.Store.CreateTeam(ctx, name))
+}
+
+func (l *lockCountingStore) DeleteTeam(ctx context.Context, name string) (bool, error) {
+	v, err := l.Store.DeleteTeam(ctx, name)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Teams(ctx context.Context) ([]store.Team, error) {
+	v, err := l.Store.Teams(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetTeamMember(ctx context.Context, team, member string) error {
+	return l.count(l.Store.SetTeamMember(ctx, team, member))
+}
+
+func (l *lockCountingStore) RemoveTeamMember(ctx context.Context, member string) (bool, error) {
+	v, err := l.Store.RemoveTeamMember(ctx, member)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetTeam(ctx context.Context, shortURL, team string) error {
+	return l.count(l.Store.SetTeam(ctx, shortURL, team))
+}
+
+func (l *lockCountingStore) TeamLinks(ctx context.Context, team string) ([]store.LinkStats, error) {
+	v, err := l.Store.TeamLinks(ctx, team)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Health(ctx context.Context, shortURL string) (store.Health, error) {
+	v, err := l.Store.Health(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) SetHealth(ctx context.Context, shortURL string, health store.Health) error {
+	return l.count(l.Store.SetHealth(ctx, shortURL, health))
+}
+
+func (l *lockCountingStore) BrokenLinks(ctx context.Context) ([]store.BrokenLink, error) {
+	v, err := l.Store.BrokenLinks(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Links(ctx context.Context) ([]store.LinkStats, error) {
+	v, err := l.Store.Links(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) LinksSince(ctx context.Context, since time.Time) ([]store.LinkStats, error) {
+	v, err := l.Store.LinksSince(ctx, since)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) TopLinks(ctx context.Context, since time.Time, limit int) ([]store.LinkClicks, error) {
+	v, err := l.Store.TopLinks(ctx, since, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) RecordAudit(ctx context.Context, entry store.AuditEntry) error {
+	return l.count(l.Store.RecordAudit(ctx, entry))
+}
+
+func (l *lockCountingStore) AuditLog(ctx context.Context, shortURL string, limit int) ([]store.AuditEntry, error) {
+	v, err := l.Store.AuditLog(ctx, shortURL, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) RecordClick(ctx context.Context, click store.Click) error {
+	return l.count(l.Store.RecordClick(ctx, click))
+}
+
+func (l *lockCountingStore) RecentClicks(ctx context.Context, shortURL string, limit int) ([]store.Click, error) {
+	v, err := l.Store.RecentClicks(ctx, shortURL, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) ClickSeries(ctx context.Context, shortURL string, since time.Time, interval store.Interval) ([]store.ClickCount, error) {
+	v, err := l.Store.ClickSeries(ctx, shortURL, since, interval)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) ClickHeatmap(ctx context.Context, shortURL string) (store.Heatmap, error) {
+	v, err := l.Store.ClickHeatmap(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) ClickBursts(ctx context.Context, since time.Time, minClicks, maxIPs int) ([]store.Anomaly, error) {
+	v, err := l.Store.ClickBursts(ctx, since, minClicks, maxIPs)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) RollupClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	v, err := l.Store.RollupClicks(ctx, cutoff)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) PurgeClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	v, err := l.Store.PurgeClicks(ctx, cutoff)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) PurgeRollups(ctx context.Context, cutoff time.Time) (int64, error) {
+	v, err := l.Store.PurgeRollups(ctx, cutoff)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) ClickRollups(ctx context.Context, shortURL string, since time.Time) ([]store.ClickRollup, error) {
+	v, err := l.Store.ClickRollups(ctx, shortURL, since)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) RecordAnomaly(ctx context.Context, anomaly store.Anomaly) error {
+	return l.count(l.Store.RecordAnomaly(ctx, anomaly))
+}
+
+func (l *lockCountingStore) Anomalies(ctx context.Context, limit int) ([]store.Anomaly, error) {
+	v, err := l.Store.Anomalies(ctx, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) CreateAlert(ctx context.Context, alert store.Alert) (int64, error) {
+	v, err := l.Store.CreateAlert(ctx, alert)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Alerts(ctx context.Context, shortURL string) ([]store.Alert, error) {
+	v, err := l.Store.Alerts(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) UpdateAlert(ctx context.Context, alert store.Alert) error {
+	return l.count(l.Store.UpdateAlert(ctx, alert))
+}
+
+func (l *lockCountingStore) DeleteAlert(ctx context.Context, shortURL string, id int64) (bool, error) {
+	v, err := l.Store.DeleteAlert(ctx, shortURL, id)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	v, err := l.Store.AcquireLease(ctx, name, holder, ttl)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Link(ctx context.Context, shortURL string) (store.LinkStats, error) {
+	v, err := l.Store.Link(ctx, shortURL)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Stats(ctx context.Context) (store.Stats, error) {
+	v, err := l.Store.Stats(ctx)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	return l.count(l.Store.ForEachShortURL(ctx, fn))
+}
+
+func (l *lockCountingStore) HotLinks(ctx context.Context, limit int) ([]store.HotLink, error) {
+	v, err := l.Store.HotLinks(ctx, limit)
+	return v, l.count(err)
+}
+
+func (l *lockCountingStore) Count(ctx context.Context) (int, error) {
+	v, err := l.Store.Count(ctx)
+	return v, l.count(err)
+}
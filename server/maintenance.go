@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// runMaintenance runs the maintenance tasks enabled in the config once.
+func (s *Server) runMaintenance(ctx context.Context, db *sql.DB) {
+	m := s.cfg.Maintenance
+	if m.IntegrityCheck {
+		problems, err := store.IntegrityCheck(ctx, db)
+		switch {
+		case err != nil:
+			log.Printf("Error running integrity check: %v", err)
+		case len(problems) > 0:
+			log.Printf("Integrity check found %d problem(s): %s", len(problems), strings.Join(problems, "; "))
+		default:
+			log.Println("Integrity check passed")
+		}
+	}
+	if m.Analyze {
+		if err := store.Analyze(ctx, db); err != nil {
+			log.Printf("Error running analyze: %v", err)
+		} else {
+			log.Println("Database analyzed")
+		}
+	}
+	if m.Vacuum {
+		if err := store.Vacuum(ctx, db); err != nil {
+			log.Printf("Error running vacuum: %v", err)
+		} else {
+			log.Println("Database vacuumed")
+		}
+	}
+}
+
+// startMaintenance runs the configured maintenance tasks every
+// maintenance.interval until ctx is cancelled. It does nothing when no
+// interval is set.
+func (s *Server) startMaintenance(ctx context.Context, db *sql.DB) {
+	interval := s.cfg.Maintenance.Interval.Duration
+	if interval <= 0 {
+		return
+	}
+	log.Printf("Scheduled database maintenance every %v", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.lead(ctx, "maintenance", interval) {
+					s.runMaintenance(ctx, db)
+				}
+			}
+		}
+	}()
+}
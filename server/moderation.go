@@ -0,0 +1,531 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With moderation.enabled set, questionable links wait in a moderation
+// queue instead of going live or being refused. A link lands there when a
+// policy plugin or create hook answers policy.Hold, say from a blocklist
+// or a Safe Browsing lookup, or when moderation.reportThreshold visitors
+// have reported it, either on the /report/{shortURL} form or through
+// POST /api/v1/links/{shortURL}/report. A queued link answers 403 instead
+// of redirecting, and one reported moderation.disableThreshold times is
+// disabled outright. At /admin/moderation a moderator approves it,
+// disables it, or bans its domain, which disables every link to the domain
+// and refuses new ones.
+
+// What put a link in the moderation queue.
+const (
+	flagSourcePolicy = "policy"
+	flagSourceReport = "report"
+)
+
+// Limits on the reasons given for holding a link, in characters.
+const (
+	maxReportReason = 200
+	maxFlagReason   = 1000
+)
+
+// truncateRunes cuts s to at most n characters, without splitting one.
+func truncateRunes(s string, n int) string {
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
+
+// flagLink holds shortURL for review, records it in the audit log and
+// tells the Discord channel, if there is one.
+func (s *Server) flagLink(ctx context.Context, shortURL, source, reason string) error {
+	reason = truncateRunes(reason, maxFlagReason)
+	before := s.auditSnapshot(ctx, shortURL)
+	if err := s.store.FlagLink(ctx, shortURL, source, reason); err != nil {
+		return err
+	}
+	logf(ctx, "Held short URL '%s' for review (%s): %s", shortURL, source, reason)
+	shortLink := strings.TrimSuffix(s.cfg.Server.PublicURL, "/") + "/_/" + shortURL
+	s.notifyDiscord(fmt.Sprintf("%s was held for review (%s): %s", shortLink, source, reason))
+	if before != nil {
+		s.audit(ctx, shortURL, store.AuditFlag, before, s.auditSnapshot(ctx, shortURL))
+	}
+	return nil
+}
+
+// approveLink takes shortURL out of the moderation queue and switches it
+// back on if reports disabled it, so it redirects again. It reports whether
+// the link was queued.
+func (s *Server) approveLink(ctx context.Context, shortURL string) (bool, error) {
+	before := s.auditSnapshot(ctx, shortURL)
+	opts, err := s.store.Options(ctx, shortURL)
+	if err != nil {
+		return false, err
+	}
+	cleared, err := s.store.ClearFlag(ctx, shortURL)
+	if err != nil || !cleared {
+		return cleared, err
+	}
+	if opts.Disabled {
+		if err := s.store.SetActive(ctx, shortURL, true); err != nil {
+			return true, err
+		}
+	}
+	s.reports.forget(shortURL)
+	if before != nil {
+		s.audit(ctx, shortURL, store.AuditApprove, before, s.auditSnapshot(ctx, shortURL))
+	}
+	return true, nil
+}
+
+// linkHost returns the lowercased host of longURL, or "" if it has none.
+func linkHost(longURL string) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// normalizeDomain cleans up a domain typed by a moderator. A full URL is
+// cut down to its host.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if strings.Contains(domain, "://") {
+		domain = linkHost(domain)
+	}
+	return strings.TrimSuffix(domain, ".")
+}
+
+// inDomain reports whether host is domain or one of its subdomains.
+func inDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// checkBannedDomain is the create hook that refuses links to banned
+// domains.
+func (s *Server) checkBannedDomain(ctx context.Context, longURL, alias string) (string, error) {
+	host := linkHost(longURL)
+	if host == "" {
+		return longURL, nil
+	}
+	banned, err := s.store.BannedDomains(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to check banned domains: %v", err)
+	}
+	for _, b := range banned {
+		if inDomain(host, b.Domain) {
+			return "", fmt.Errorf("links to %s are not allowed", b.Domain)
+		}
+	}
+	return longURL, nil
+}
+
+// banDomain bans domain and disables every live link to it or its
+// subdomains, taking them out of the moderation queue. It returns how
+// many links it disabled.
+func (s *Server) banDomain(ctx context.Context, domain, reason string) (int, error) {
+	if err := s.store.BanDomain(ctx, domain, reason); err != nil {
+		return 0, err
+	}
+	logf(ctx, "Banned domain %s: %s", domain, reason)
+
+	links, err := s.store.Links(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, link := range links {
+		if !inDomain(linkHost(link.LongURL), domain) {
+			continue
+		}
+		opts, err := s.store.Options(ctx, link.ShortURL)
+		if err != nil {
+			return n, err
+		}
+		if !opts.Disabled {
+			if err := s.setActive(ctx, link.ShortURL, false); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if opts.Flagged {
+			if _, err := s.store.ClearFlag(ctx, link.ShortURL); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// reportTracker counts the addresses that reported each link. Counts are
+// kept in memory, per replica, until a moderator decides on the link or
+// reports disable it.
+type reportTracker struct {
+	mu      sync.Mutex
+	reports map[string]map[string]string
+}
+
+// add records a report of shortURL from ip. It returns how many addresses
+// have reported the link and the distinct reasons they gave.
+func (t *reportTracker) add(shortURL, ip, reason string) (int, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.reports == nil {
+		t.reports = make(map[string]map[string]string)
+	}
+	if t.reports[shortURL] == nil {
+		t.reports[shortURL] = make(map[string]string)
+	}
+	t.reports[shortURL][ip] = reason
+	var reasons []string
+	seen := make(map[string]bool)
+	for _, reason := range t.reports[shortURL] {
+		if reason != "" && !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	return len(t.reports[shortURL]), reasons
+}
+
+// forget drops the reports of shortURL.
+func (t *reportTracker) forget(shortURL string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.reports, shortURL)
+}
+
+// reportLink records a report of shortURL from the client of r. Once
+// moderation.reportThreshold addresses have reported the link it is held
+// for review, and once moderation.disableThreshold have it is disabled too.
+// Reports of a disabled link are ignored.
+func (s *Server) reportLink(r *http.Request, shortURL, reason string) error {
+	ctx := r.Context()
+	reason = strings.TrimSpace(reason)
+	reason = truncateRunes(reason, maxReportReason)
+	opts, err := s.store.Options(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	if opts.Disabled {
+		return nil
+	}
+
+	ip := s.clientIP(r)
+	logf(ctx, "Short URL '%s' reported by %s: %s", shortURL, ip, reason)
+	count, reasons := s.reports.add(shortURL, ip, reason)
+
+	threshold := s.cfg.Moderation.ReportThreshold
+	if threshold <= 0 {
+		threshold = config.DefaultReportThreshold
+	}
+	limit := s.cfg.Moderation.DisableThreshold
+	disable := limit > 0 && count >= limit
+
+	ctx = withActor(ctx, s.requestActor(r, "report"))
+	if !opts.Flagged && (count >= threshold || disable) {
+		if err := s.flagLink(ctx, shortURL, flagSourceReport, strings.Join(reasons, "; ")); err != nil {
+			return err
+		}
+	}
+	if disable {
+		if err := s.setActive(ctx, shortURL, false); err != nil {
+			return err
+		}
+		s.reports.forget(shortURL)
+		logf(ctx, "Disabled short URL '%s' after %d reports", shortURL, count)
+	}
+	return nil
+}
+
+// reportRequest is the body of POST /api/v1/links/{shortURL}/report.
+type reportRequest struct {
+	Reason string `json:"reason"`
+}
+
+// handleAPIReport serves POST /api/v1/links/{shortURL}/report, where
+// visitors report a link as abusive. It needs no key.
+func (s *Server) handleAPIReport(w http.ResponseWriter, r *http.Request, shortURL string) {
+	logf(r.Context(), "Handling report for short URL: '%s'", shortURL)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.cfg.Moderation.Enabled {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	var req reportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body is too large")
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidJSON, "Invalid JSON body")
+		return
+	}
+
+	if err := s.reportLink(r, shortURL, req.Reason); err != nil {
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		}
+		logf(r.Context(), "Error recording report of short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error recording report")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// reportPage is the data for report.html.
+type reportPage struct {
+	ShortURL        string
+	Sent            bool
+	MaxReasonLength int
+	CSRFToken       string
+}
+
+// handleReport serves /report/{shortURL}, a form where anyone who was sent
+// a short link can report it as malicious.
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling report request")
+	shortURL := strings.TrimPrefix(r.URL.Path, "/report/")
+	if !s.cfg.Moderation.Enabled || shortURL == "" || strings.Contains(shortURL, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	page := reportPage{ShortURL: shortURL, MaxReasonLength: maxReportReason}
+	switch r.Method {
+	case http.MethodGet:
+		exists, err := s.store.Exists(ctx, shortURL)
+		if err != nil {
+			logf(ctx, "Error checking short URL %s: %v", shortURL, err)
+			httpError(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+		if err := r.ParseForm(); err != nil {
+			httpError(w, "Invalid form body", http.StatusBadRequest)
+			return
+		}
+		if !validCSRF(r) {
+			logln(ctx, "Rejected report with missing or invalid CSRF token")
+			httpError(w, "Invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		if err := s.reportLink(r, shortURL, r.PostFormValue("reason")); err != nil {
+			if err == store.ErrNotFound {
+				http.NotFound(w, r)
+				return
+			}
+			logf(ctx, "Error recording report of short URL %s: %v", shortURL, err)
+			httpError(w, "Error recording report", http.StatusInternalServerError)
+			return
+		}
+		page.Sent = true
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	tmpl, err := s.templates.lookup("report.html")
+	if err != nil {
+		logf(ctx, "Error loading report template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing report template: %v", err)
+	}
+}
+
+// moderationPage is the data for moderation.html.
+type moderationPage struct {
+	Links     []moderationLink
+	Banned    []store.BannedDomain
+	Message   string
+	Error     string
+	CSRFToken string
+}
+
+// moderationLink is one row of the moderation queue.
+type moderationLink struct {
+	store.FlaggedLink
+	Host     string
+	Disabled bool
+}
+
+func (s *Server) handleModeration(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling moderation request")
+	if s.cfg.API.AdminKey == "" || !s.cfg.Moderation.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showModeration(w, r)
+	case http.MethodPost:
+		s.updateModeration(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showModeration(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := moderationPage{Message: r.URL.Query().Get("message"), Error: r.URL.Query().Get("error")}
+
+	flagged, err := s.store.FlaggedLinks(ctx)
+	if err != nil {
+		logf(ctx, "Error fetching the moderation queue: %v", err)
+		httpError(w, "Error fetching the moderation queue", http.StatusInternalServerError)
+		return
+	}
+	for _, link := range flagged {
+		opts, err := s.store.Options(ctx, link.ShortURL)
+		if err != nil && err != store.ErrNotFound {
+			logf(ctx, "Error fetching options for short URL %s: %v", link.ShortURL, err)
+			httpError(w, "Error fetching the moderation queue", http.StatusInternalServerError)
+			return
+		}
+		page.Links = append(page.Links, moderationLink{FlaggedLink: link, Host: linkHost(link.LongURL), Disabled: opts.Disabled})
+	}
+	if page.Banned, err = s.store.BannedDomains(ctx); err != nil {
+		logf(ctx, "Error fetching banned domains: %v", err)
+		httpError(w, "Error fetching the moderation queue", http.StatusInternalServerError)
+		return
+	}
+
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("moderation.html")
+	if err != nil {
+		logf(ctx, "Error loading moderation template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing moderation template: %v", err)
+	}
+}
+
+// updateModeration applies a moderator's decision, then sends the browser
+// back to the queue.
+func (s *Server) updateModeration(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected moderation request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, "admin"))
+	back := func(param, note string) {
+		http.Redirect(w, r, "/admin/moderation?"+url.Values{param: {note}}.Encode(), http.StatusSeeOther)
+	}
+	shortURL := r.PostFormValue("code")
+
+	switch r.PostFormValue("action") {
+	case "approve":
+		if _, err := s.approveLink(ctx, shortURL); err != nil {
+			logf(ctx, "Error approving short URL %s: %v", shortURL, err)
+			back("error", "Failed to approve "+shortURL)
+			return
+		}
+		logf(ctx, "Approved short URL %s", shortURL)
+		back("message", "Approved "+shortURL)
+
+	case "disable":
+		if err := s.setActive(ctx, shortURL, false); err != nil && err != store.ErrNotFound {
+			logf(ctx, "Error disabling short URL %s: %v", shortURL, err)
+			back("error", "Failed to disable "+shortURL)
+			return
+		}
+		if _, err := s.store.ClearFlag(ctx, shortURL); err != nil {
+			logf(ctx, "Error taking short URL %s out of the moderation queue: %v", shortURL, err)
+			back("error", "Failed to disable "+shortURL)
+			return
+		}
+		s.reports.forget(shortURL)
+		logf(ctx, "Disabled short URL %s from the moderation queue", shortURL)
+		back("message", "Disabled "+shortURL)
+
+	case "ban":
+		domain := normalizeDomain(r.PostFormValue("domain"))
+		if domain == "" || strings.ContainsAny(domain, "/ ") {
+			back("error", "Invalid domain")
+			return
+		}
+		n, err := s.banDomain(ctx, domain, strings.TrimSpace(r.PostFormValue("reason")))
+		if err != nil {
+			logf(ctx, "Error banning domain %s: %v", domain, err)
+			back("error", "Failed to ban "+domain)
+			return
+		}
+		back("message", fmt.Sprintf("Banned %s and disabled %d link(s)", domain, n))
+
+	case "unban":
+		domain := normalizeDomain(r.PostFormValue("domain"))
+		if _, err := s.store.UnbanDomain(ctx, domain); err != nil {
+			logf(ctx, "Error lifting the ban on domain %s: %v", domain, err)
+			back("error", "Failed to unban "+domain)
+			return
+		}
+		logf(ctx, "Lifted the ban on domain %s", domain)
+		back("message", "Unbanned "+domain)
+
+	default:
+		back("error", "Unknown action")
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/policy"
+)
+
+// postModeration posts form to /admin/moderation as a logged in admin with
+// a valid CSRF token.
+func postModeration(srv *Server, form url.Values) *httptest.ResponseRecorder {
+	token := strings.Repeat("ab", csrfTokenBytes)
+	form.Set(csrfFieldName, token)
+	req := httptest.NewRequest("POST", "/admin/moderation", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	req.SetBasicAuth("admin", "secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+// holdSuspicious holds links to suspicious.example for review.
+func holdSuspicious(ctx context.Context, longURL, alias string) (string, error) {
+	if linkHost(longURL) == "suspicious.example" {
+		return "", policy.Hold("on a blocklist")
+	}
+	return longURL, nil
+}
+
+func createAliasViaAPI(srv *Server, longURL, alias string) *httptest.ResponseRecorder {
+	body := `{"url": "` + longURL + `", "alias": "` + alias + `"}`
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body)))
+	return rr
+}
+
+func TestHoldForReview(t *testing.T) {
+	t.Run("Refused While Moderation Is Off", func(t *testing.T) {
+		srv, st := newMemoryServer(t)
+		srv.OnCreate(holdSuspicious)
+
+		rr := createAliasViaAPI(srv, "https://suspicious.example/", "held01")
+		checkAPIError(t, rr, http.StatusForbidden, errCodeLinkRejected)
+		if exists, err := st.Exists(context.Background(), "held01"); err != nil || exists {
+			t.Errorf("Exists of a refused link returned %v, %v want false", exists, err)
+		}
+	})
+
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Moderation.Enabled = true
+	srv.OnCreate(holdSuspicious)
+	ctx := context.Background()
+
+	if rr := createAliasViaAPI(srv, "https://suspicious.example/", "held01"); rr.Code != http.StatusCreated {
+		t.Fatalf("creating a held link returned %v: %s", rr.Code, rr.Body)
+	}
+	if rr := createAliasViaAPI(srv, "https://example.com/", "fine01"); rr.Code != http.StatusCreated {
+		t.Fatalf("creating a link returned %v: %s", rr.Code, rr.Body)
+	}
+
+	t.Run("Held Link Doesn't Redirect", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/held01", nil))
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/moderation", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		if !strings.Contains(body, "held01") || !strings.Contains(body, "on a blocklist") || strings.Contains(body, "fine01") {
+			t.Errorf("handler returned an unexpected queue: %s", body)
+		}
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		rr := postModeration(srv, url.Values{"action": {"approve"}, "code": {"held01"}})
+		if status := rr.Code; status != http.StatusSeeOther {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+		}
+		if opts, err := st.Options(ctx, "held01"); err != nil || opts.Flagged || opts.Disabled {
+			t.Errorf("Options after approving returned %+v, %v", opts, err)
+		}
+	})
+}
+
+func TestReportLink(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Moderation.ReportThreshold = 2
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/links/abc123/report", strings.NewReader(`{"reason": "phishing"}`))
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Off", func(t *testing.T) {
+		checkAPIError(t, report("192.0.2.1"), http.StatusNotFound, errCodeNotFound)
+	})
+
+	srv.cfg.Moderation.Enabled = true
+
+	t.Run("Below Threshold", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if rr := report("192.0.2.1"); rr.Code != http.StatusAccepted {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+			}
+		}
+		if opts, err := st.Options(ctx, "abc123"); err != nil || opts.Flagged {
+			t.Errorf("Options after reports from one address returned %+v, %v", opts, err)
+		}
+	})
+
+	t.Run("Threshold Reached", func(t *testing.T) {
+		report("192.0.2.2")
+		flagged, err := st.FlaggedLinks(ctx)
+		if err != nil || len(flagged) != 1 || flagged[0].Source != flagSourceReport || flagged[0].Reason != "phishing" {
+			t.Errorf("FlaggedLinks after reports from two addresses returned %+v, %v", flagged, err)
+		}
+	})
+
+	t.Run("Missing Link", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/links/missing/report", strings.NewReader(`{}`))
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		checkAPIError(t, rr, http.StatusNotFound, errCodeNotFound)
+	})
+
+	t.Run("Approve Resets Reports", func(t *testing.T) {
+		if _, err := srv.approveLink(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+		report("192.0.2.3")
+		if opts, err := st.Options(ctx, "abc123"); err != nil || opts.Flagged {
+			t.Errorf("Options after one report of an approved link returned %+v, %v", opts, err)
+		}
+	})
+
+	t.Run("Disable Threshold", func(t *testing.T) {
+		srv.cfg.Moderation.DisableThreshold = 4
+		report("192.0.2.4")
+		report("192.0.2.5")
+		if opts, err := st.Options(ctx, "abc123"); err != nil || !opts.Flagged || opts.Disabled {
+			t.Errorf("Options after three reports returned %+v, %v want flagged", opts, err)
+		}
+		report("192.0.2.6")
+		if opts, err := st.Options(ctx, "abc123"); err != nil || !opts.Flagged || !opts.Disabled {
+			t.Errorf("Options after four reports returned %+v, %v want flagged and disabled", opts, err)
+		}
+
+		if _, err := srv.approveLink(ctx, "abc123"); err != nil {
+			t.Fatal(err)
+		}
+		if opts, err := st.Options(ctx, "abc123"); err != nil || opts.Flagged || opts.Disabled {
+			t.Errorf("Options after approving returned %+v, %v want active", opts, err)
+		}
+	})
+}
+
+func TestHandleReport(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Off", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/report/abc123", nil))
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	srv.cfg.Moderation.Enabled = true
+	srv.cfg.Moderation.ReportThreshold = 1
+
+	t.Run("Form", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/report/abc123", nil))
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if body := rr.Body.String(); !strings.Contains(body, `action="/report/abc123"`) || !strings.Contains(body, `name="csrf_token"`) {
+			t.Errorf("handler returned an unexpected form: %s", body)
+		}
+	})
+
+	t.Run("Missing Link", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/report/missing", nil))
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Missing CSRF Token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/report/abc123", strings.NewReader("reason=spam"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("Submit", func(t *testing.T) {
+		token := strings.Repeat("ab", csrfTokenBytes)
+		form := url.Values{csrfFieldName: {token}, "reason": {"phishing"}}
+		req := httptest.NewRequest("POST", "/report/abc123", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if !strings.Contains(rr.Body.String(), "Thanks") {
+			t.Errorf("handler did not thank the reporter: %s", rr.Body)
+		}
+		flagged, err := st.FlaggedLinks(ctx)
+		if err != nil || len(flagged) != 1 || flagged[0].Reason != "phishing" {
+			t.Errorf("FlaggedLinks after a report returned %+v, %v", flagged, err)
+		}
+	})
+}
+
+func TestBanDomain(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Moderation.Enabled = true
+	srv.OnCreate(srv.checkBannedDomain)
+	ctx := context.Background()
+	for shortURL, longURL := range map[string]string{
+		"abc123": "https://www.bad.example/a",
+		"def456": "https://bad.example/b",
+		"ghi789": "https://notbad.example/c",
+	} {
+		if err := st.Create(ctx, shortURL, longURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.FlagLink(ctx, "abc123", flagSourceReport, "spam"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postModeration(srv, url.Values{"action": {"ban"}, "domain": {"bad.example"}, "reason": {"spam"}})
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+	for shortURL, disabled := range map[string]bool{"abc123": true, "def456": true, "ghi789": false} {
+		if opts, err := st.Options(ctx, shortURL); err != nil || opts.Disabled != disabled || opts.Flagged {
+			t.Errorf("Options of %s after the ban returned %+v, %v want disabled %v", shortURL, opts, err, disabled)
+		}
+	}
+
+	rr = createAliasViaAPI(srv, "https://sub.bad.example/", "new001")
+	checkAPIError(t, rr, http.StatusForbidden, errCodeLinkRejected)
+
+	postModeration(srv, url.Values{"action": {"unban"}, "domain": {"bad.example"}})
+	if rr := createAliasViaAPI(srv, "https://sub.bad.example/", "new001"); rr.Code != http.StatusCreated {
+		t.Errorf("creating a link after the unban returned %v: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"phishing", 5, "phish"},
+		{"phishing", 8, "phishing"},
+		{"phishing", 20, "phishing"},
+		{"héhé", 3, "héh"},
+		{"日本語のサイト", 2, "日本"},
+		{"", 2, ""},
+	} {
+		if got := truncateRunes(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateRunes(%q, %d) = %q want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
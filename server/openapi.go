@@ -0,0 +1,71 @@
+package server
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"net/http"
+)
+
+// The OpenAPI document is maintained by hand next to the handlers it
+// describes. openapi_test.go checks that every API route is covered so the
+// two don't drift apart.
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// handleOpenAPI serves the API specification so clients can be generated
+// from it.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+// swaggerUIPage loads Swagger UI from a CDN and points it at the spec. It
+// is a plain constant rather than a theme template since it isn't meant to
+// be restyled.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>shorty API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5.17.14/swagger-ui-bundle.js" crossorigin></script>
+    <script>
+        window.ui = SwaggerUIBundle({ url: '/api/v1/openapi.json', dom_id: '#swagger-ui' });
+    </script>
+</body>
+</html>
+`
+
+// handleAPIDocs serves the Swagger UI page to admins. Browsers can't send a
+// bearer token on a plain page load, so HTTP basic auth with the admin key as
+// the password is accepted here as well.
+func (s *Server) handleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API docs request")
+	if !s.authorizedAdmin(r) && !s.basicAuthAdmin(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
+
+// basicAuthAdmin reports whether the request carries the admin key as its
+// basic auth password. The username is ignored.
+func (s *Server) basicAuthAdmin(r *http.Request) bool {
+	if s.cfg.API.AdminKey == "" {
+		return false
+	}
+	_, password, ok := r.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(s.cfg.API.AdminKey)) == 1
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	// Every API route registered in main should be documented.
+	routes := map[string][]string{
+		"/api/v1/links":                        {"post"},
+		"/api/v1/links/batch":                  {"post"},
+		"/api/v1/links/{shortURL}":             {"get", "patch", "delete"},
+		"/api/v1/links/{shortURL}/share":       {"post"},
+		"/api/v1/links/{shortURL}/report":      {"post"},
+		"/api/v1/links/{shortURL}/alerts":      {"get", "post"},
+		"/api/v1/links/{shortURL}/alerts/{id}": {"delete"},
+		"/api/v1/expand":                       {"post"},
+		"/api/v1/expand/{shortURL}":            {"get"},
+		"/api/v1/alias/{alias}/available":      {"get"},
+		"/api/v1/teams/{name}":                 {"get"},
+		"/api/v1/account":                      {"delete"},
+		"/api/v1/account/export":               {"get"},
+		"/api/v1/usage":                        {"get"},
+		"/api/v1/stats/stream":                 {"get"},
+		"/api/v1/stats/heatmap":                {"get"},
+		"/api/v1/stats/rollups":                {"get"},
+		"/api/v1/stats/trending":               {"get"},
+		"/api/v1/admin/backup":                 {"get", "post"},
+		"/api/v1/admin/audit":                  {"get"},
+		"/api/v1/admin/anomalies":              {"get"},
+		"/api/v1/admin/search":                 {"get"},
+		"/api/v1/admin/digest":                 {"get", "post"},
+		"/api/v1/admin/usage":                  {"get"},
+		"/api/v1/admin/trash":                  {"get"},
+		"/api/v1/admin/trash/{shortURL}":       {"post"},
+		"/api/v1/openapi.json":                 {"get"},
+	}
+	for path, methods := range routes {
+		ops, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("openapi.json is missing path %s", path)
+			continue
+		}
+		for _, method := range methods {
+			if _, ok := ops[method]; !ok {
+				t.Errorf("openapi.json is missing %s %s", method, path)
+			}
+		}
+	}
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(handleOpenAPI).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("handler returned wrong content type: got %v want %v", ct, "application/json")
+	}
+}
+
+func TestHandleAPIDocs(t *testing.T) {
+	srv := &Server{cfg: &config.Config{}}
+	srv.cfg.API.AdminKey = "secret"
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/docs", nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIDocs).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+		if rr.Header().Get("WWW-Authenticate") == "" {
+			t.Error("Expected a WWW-Authenticate challenge")
+		}
+	})
+
+	t.Run("Basic Auth", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/docs", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIDocs).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+
+	t.Run("Bearer Token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/docs", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleAPIDocs).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Ops alerts are shorty watching itself, for single-binary deployments
+// without a monitoring stack. With ops.email or ops.webhook set, a check
+// runs every minute and raises an alert when the server answered
+// ops.errorsPerMinute or more 5xx responses, ops.locksPerMinute or more store
+// calls failed with SQLITE_BUSY or SQLITE_LOCKED, or the database reached
+// ops.maxDatabaseMB. Failed scheduled backups raise one straight away. The
+// same alert is sent at most once per ops.cooldown.
+
+const opsCheckInterval = time.Minute
+
+// Kinds of ops alert.
+const (
+	opsErrorRate    = "error_rate"
+	opsLocked       = "database_locked"
+	opsBackupFailed = "backup_failed"
+	opsDatabaseSize = "database_size"
+)
+
+var opsClient = &http.Client{Timeout: 10 * time.Second}
+
+// opsMonitor counts the errors seen since the last check and remembers when
+// each kind of alert was last sent.
+type opsMonitor struct {
+	mu     sync.Mutex
+	errors int
+	locks  int
+	sent   map[string]time.Time
+}
+
+func (o *opsMonitor) countError() {
+	o.mu.Lock()
+	o.errors++
+	o.mu.Unlock()
+}
+
+func (o *opsMonitor) countLock() {
+	o.mu.Lock()
+	o.locks++
+	o.mu.Unlock()
+}
+
+// take returns the counts since the last call and resets them.
+func (o *opsMonitor) take() (errors, locks int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	errors, locks = o.errors, o.locks
+	o.errors, o.locks = 0, 0
+	return errors, locks
+}
+
+// due reports whether an alert of kind may be sent at now, and if so
+// records it as sent.
+func (o *opsMonitor) due(kind string, now time.Time, cooldown time.Duration) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.sent == nil {
+		o.sent = make(map[string]time.Time)
+	}
+	if last, ok := o.sent[kind]; ok && now.Sub(last) < cooldown {
+		return false
+	}
+	o.sent[kind] = now
+	return true
+}
+
+// statusRecorder remembers the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// opsEnabled reports whether ops alerts have somewhere to go.
+func (s *Server) opsEnabled() bool {
+	return len(s.cfg.Ops.Email) > 0 || s.cfg.Ops.Webhook != ""
+}
+
+// opsPayload is the JSON posted to ops.webhook.
+type opsPayload struct {
+	Kind string    `json:"kind"`
+	Host string    `json:"host"`
+	Text string    `json:"text"`
+	At   time.Time `json:"at"`
+}
+
+// opsAlert sends message to ops.email and ops.webhook on the job pool,
+// unless an alert of the same kind went out within ops.cooldown.
+func (s *Server) opsAlert(kind, message string) {
+	if !s.opsEnabled() {
+		return
+	}
+	now := time.Now().UTC()
+	if !s.ops.due(kind, now, s.cfg.Ops.Cooldown.Or(config.DefaultOpsCooldown)) {
+		log.Printf("Holding back ops alert %s: %s", kind, message)
+		return
+	}
+	log.Printf("Sending ops alert %s: %s", kind, message)
+	host, _ := os.Hostname()
+
+	if len(s.cfg.Ops.Email) > 0 {
+		subject := fmt.Sprintf("shorty ops alert on %s: %s", host, message)
+		s.jobs.submit(&job{
+			name:     "ops alert email",
+			attempts: 3,
+			run: func(ctx context.Context) error {
+				return s.sendEmail(s.cfg.Ops.Email, subject, message+"\n")
+			},
+		})
+	}
+	if webhookURL := s.cfg.Ops.Webhook; webhookURL != "" {
+		payload := opsPayload{Kind: kind, Host: host, Text: message, At: now}
+		s.jobs.submit(&job{
+			name:     "ops alert webhook",
+			attempts: 3,
+			run: func(ctx context.Context) error {
+				return postOpsWebhook(ctx, webhookURL, payload)
+			},
+		})
+	}
+}
+
+// postOpsWebhook posts payload to webhookURL. Like the Discord webhook, it
+// is set by the operator, so it doesn't go through the outbound client.
+func postOpsWebhook(ctx context.Context, webhookURL string, payload opsPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := opsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ops webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// checkOps raises the alerts whose thresholds were crossed since the last
+// check.
+func (s *Server) checkOps(ctx context.Context) error {
+	serverErrors, locks := s.ops.take()
+	if limit := s.cfg.Ops.ErrorsPerMinute; limit > 0 && serverErrors >= limit {
+		s.opsAlert(opsErrorRate, fmt.Sprintf("%d server errors in the last minute", serverErrors))
+	}
+	if limit := s.cfg.Ops.LocksPerMinute; limit > 0 && locks >= limit {
+		s.opsAlert(opsLocked, fmt.Sprintf("%d locked database errors in the last minute", locks))
+	}
+
+	db, ok := s.sqliteDB()
+	if !ok || s.cfg.Ops.MaxDatabaseMB <= 0 || !s.lead(ctx, "ops", opsCheckInterval) {
+		return nil
+	}
+	size, err := store.Size(ctx, db)
+	if err != nil {
+		return fmt.Errorf("error reading database size: %v", err)
+	}
+	if mb := size >> 20; mb >= s.cfg.Ops.MaxDatabaseMB {
+		s.opsAlert(opsDatabaseSize, fmt.Sprintf("Database is %d MB, ops.maxDatabaseMB is %d MB", mb, s.cfg.Ops.MaxDatabaseMB))
+	}
+	return nil
+}
+
+// startOpsAlerts runs checkOps every minute until ctx is cancelled. It does
+// nothing while ops alerts have nowhere to go.
+func (s *Server) startOpsAlerts(ctx context.Context) {
+	if !s.opsEnabled() {
+		return
+	}
+	if len(s.cfg.Ops.Email) > 0 && !s.emailConfigured() {
+		log.Println("ops.email is set but email.host or email.from isn't, ops alerts won't be emailed")
+	}
+	log.Println("Watching for server errors, database locks and database size")
+
+	go func() {
+		ticker := time.NewTicker(opsCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.checkOps(ctx); err != nil {
+					log.Printf("Error checking ops alerts: %v", err)
+				}
+			}
+		}
+	}()
+}
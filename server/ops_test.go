@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestOpsMonitorDue(t *testing.T) {
+	var ops opsMonitor
+	now := time.Now()
+	if !ops.due(opsErrorRate, now, time.Hour) {
+		t.Error("First alert was held back")
+	}
+	if ops.due(opsErrorRate, now.Add(30*time.Minute), time.Hour) {
+		t.Error("Alert within the cooldown wasn't held back")
+	}
+	if !ops.due(opsBackupFailed, now.Add(30*time.Minute), time.Hour) {
+		t.Error("Alert of another kind was held back")
+	}
+	if !ops.due(opsErrorRate, now.Add(time.Hour), time.Hour) {
+		t.Error("Alert after the cooldown was held back")
+	}
+}
+
+// busyError is shaped like modernc.org/sqlite's error for SQLITE_BUSY.
+type busyError struct{}
+
+func (busyError) Error() string { return "database is locked (5) (SQLITE_BUSY)" }
+func (busyError) Code() int     { return 5 }
+
+// busyStore fails every Create with busyError.
+type busyStore struct {
+	*store.Memory
+}
+
+func (busyStore) Create(ctx context.Context, shortURL, longURL string) error {
+	return busyError{}
+}
+
+func TestLockCountingStore(t *testing.T) {
+	var ops opsMonitor
+	st := &lockCountingStore{Store: busyStore{store.NewMemory()}, ops: &ops}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := st.Create(ctx, "abc123", "https://example.com"); err != (busyError{}) {
+			t.Errorf("Create returned %v, want the store's error", err)
+		}
+	}
+	if _, err := st.LongURL(ctx, "abc123"); err != store.ErrNotFound {
+		t.Errorf("LongURL returned %v, want %v", err, store.ErrNotFound)
+	}
+	if err := st.SetActive(ctx, "abc123", false); err == nil {
+		t.Error("SetActive of a missing link returned no error")
+	}
+
+	if _, locks := ops.take(); locks != 2 {
+		t.Errorf("lockCountingStore counted %d locks, want 2", locks)
+	}
+}
+
+func TestCheckOps(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	startJobs(t, srv)
+
+	payloads := make(chan opsPayload, 4)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload opsPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+		payloads <- payload
+	}))
+	defer webhook.Close()
+
+	srv.cfg.Ops.Webhook = webhook.URL
+	srv.cfg.Ops.ErrorsPerMinute = 2
+	srv.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/boom" {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	for i := 0; i < 2; i++ {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/boom", nil))
+	}
+
+	if err := srv.checkOps(context.Background()); err != nil {
+		t.Fatalf("checkOps returned an error: %v", err)
+	}
+	select {
+	case payload := <-payloads:
+		if payload.Kind != opsErrorRate || payload.Text != "2 server errors in the last minute" {
+			t.Errorf("Unexpected ops alert: %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkOps didn't post an ops alert")
+	}
+
+	// The counts start over, and the cooldown holds back a repeat.
+	for i := 0; i < 3; i++ {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/boom", nil))
+	}
+	srv.opsAlert(opsBackupFailed, "Scheduled backup failed: disk full")
+	if err := srv.checkOps(context.Background()); err != nil {
+		t.Fatalf("checkOps returned an error: %v", err)
+	}
+	select {
+	case payload := <-payloads:
+		if payload.Kind != opsBackupFailed {
+			t.Errorf("Unexpected ops alert: %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("opsAlert didn't post the backup alert")
+	}
+	select {
+	case payload := <-payloads:
+		t.Errorf("Ops alert wasn't held back: %+v", payload)
+	default:
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+)
+
+// A link can hand parts of the visited URL on to its destination: prefix
+// links append anything after the short code to the destination's path,
+// and links with passQuery add the visitor's query parameters.
+
+// joinSubpath appends subpath to the path of longURL, so a prefix link for
+// https://example.com/docs sends /_/docs/guide/intro to
+// https://example.com/docs/guide/intro.
+func joinSubpath(longURL, subpath string) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return longURL
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + subpath
+	u.RawPath = ""
+	return u.String()
+}
+
+// mergeQuery appends query to longURL. Parameters longURL already has keep
+// their value, so a visitor can't override what the link's owner set.
+func mergeQuery(longURL string, query url.Values) string {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return longURL
+	}
+	existing := u.Query()
+	extra := url.Values{}
+	for key, values := range query {
+		if _, ok := existing[key]; !ok {
+			extra[key] = values
+		}
+	}
+	if len(extra) == 0 {
+		return longURL
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+	return u.String()
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestJoinSubpath(t *testing.T) {
+	tests := []struct {
+		longURL, subpath, want string
+	}{
+		{"https://example.com/docs", "guide/intro", "https://example.com/docs/guide/intro"},
+		{"https://example.com/docs/", "guide", "https://example.com/docs/guide"},
+		{"https://example.com", "guide", "https://example.com/guide"},
+		{"https://example.com/docs?lang=en", "guide", "https://example.com/docs/guide?lang=en"},
+		{"https://example.com/docs", "", "https://example.com/docs/"},
+	}
+	for _, tt := range tests {
+		if got := joinSubpath(tt.longURL, tt.subpath); got != tt.want {
+			t.Errorf("joinSubpath(%q, %q) returned wrong URL: got %v want %v", tt.longURL, tt.subpath, got, tt.want)
+		}
+	}
+}
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		longURL, query, want string
+	}{
+		{"https://example.com/promo", "src=email", "https://example.com/promo?src=email"},
+		{"https://example.com/promo?ref=shorty", "src=email", "https://example.com/promo?ref=shorty&src=email"},
+		{"https://example.com/promo?src=owner", "src=visitor", "https://example.com/promo?src=owner"},
+		{"https://example.com/promo#top", "a=1&b=2", "https://example.com/promo?a=1&b=2#top"},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got := mergeQuery(tt.longURL, query); got != tt.want {
+			t.Errorf("mergeQuery(%q, %q) returned wrong URL: got %v want %v", tt.longURL, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "docs", "https://example.com/docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	visit := func(t *testing.T, target string, passQuery, prefix bool) string {
+		t.Helper()
+		if err := st.SetOptions(ctx, "docs", store.Options{PassQuery: passQuery, Prefix: prefix}); err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		return rr.Header().Get("Location")
+	}
+
+	tests := []struct {
+		name, target      string
+		passQuery, prefix bool
+		want              string
+	}{
+		{"Query Passed", "/_/docs?src=email", true, false, "https://example.com/docs?src=email"},
+		{"Query Dropped", "/_/docs?src=email", false, false, "https://example.com/docs"},
+		{"Prefix", "/_/docs/guide/intro", false, true, "https://example.com/docs/guide/intro"},
+		{"Prefix And Query", "/_/docs/guide?src=email", true, true, "https://example.com/docs/guide?src=email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visit(t, tt.target, tt.passQuery, tt.prefix); got != tt.want {
+				t.Errorf("handler returned wrong redirect location: got %v want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Not A Prefix Link", func(t *testing.T) {
+		if got := visit(t, "/_/docs/guide", false, false); !strings.HasPrefix(got, "/?error=") {
+			t.Errorf("handler returned wrong redirect location: got %v", got)
+		}
+	})
+
+	// Only the visits that redirected were counted.
+	if link, err := st.Link(ctx, "docs"); err != nil || link.VisitCount != len(tests) {
+		t.Errorf("link has %d visits, %v want %d", link.VisitCount, err, len(tests))
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Pasting a short link into a chat makes the app fetch it for a preview,
+// often from several servers at once, and every fetch would count as a
+// visit. analytics.previewBots picks what these link unfurlers get:
+// "count", the default, treats them like any other visitor, so only
+// analytics.filterBots keeps them apart; "ignore" redirects them without
+// counting or logging the visit; "card" answers with card.html, a small
+// page of Open Graph tags describing the destination, and doesn't count
+// the visit either. Unfurlers are told by their user agent whether or not
+// bot filtering is on.
+//
+// A link can also have a card of its own, a title, description and image
+// set through the API, so a shared link previews as the brand wants rather
+// than as the destination's page. Unfurlers always get that card, whatever
+// analytics.previewBots says, while browsers are redirected as usual.
+
+const (
+	previewCount  = "count"
+	previewIgnore = "ignore"
+	previewCard   = "card"
+)
+
+// Limits on a link's card.
+const (
+	maxCardTitle       = 200
+	maxCardDescription = 1000
+)
+
+// previewAgents are fragments of the user agents of link unfurlers, in
+// lower case.
+var previewAgents = []string{
+	"slackbot", "slack-imgproxy", "twitterbot", "whatsapp", "facebookexternalhit", "facebot",
+	"discordbot", "telegrambot", "linkedinbot", "skypeuripreview", "embedly", "iframely",
+	"pinterestbot", "redditbot", "mastodon", "vkshare", "bitlybot",
+}
+
+// validatePreviewBots checks analytics.previewBots names a known mode.
+func validatePreviewBots(mode string) error {
+	switch mode {
+	case "", previewCount, previewIgnore, previewCard:
+		return nil
+	}
+	return fmt.Errorf("unknown analytics.previewBots %q, want %q, %q or %q", mode, previewCount, previewIgnore, previewCard)
+}
+
+// isPreviewBot reports whether r comes from a link unfurler.
+func isPreviewBot(r *http.Request) bool {
+	agent := strings.ToLower(r.UserAgent())
+	for _, fragment := range previewAgents {
+		if strings.Contains(agent, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeCard trims card and checks it fits: the image must be an http
+// or https URL.
+func normalizeCard(card store.Card) (store.Card, error) {
+	card.Title = strings.TrimSpace(card.Title)
+	card.Description = strings.TrimSpace(card.Description)
+	card.Image = strings.TrimSpace(card.Image)
+	if utf8.RuneCountInString(card.Title) > maxCardTitle {
+		return card, fmt.Errorf("card title is longer than %d characters", maxCardTitle)
+	}
+	if utf8.RuneCountInString(card.Description) > maxCardDescription {
+		return card, fmt.Errorf("card description is longer than %d characters", maxCardDescription)
+	}
+	if card.Image != "" {
+		u, err := url.ParseRequestURI(card.Image)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return card, fmt.Errorf("card image must be an http or https URL")
+		}
+		if len(card.Image) > 2048 {
+			return card, fmt.Errorf("card image URL is too long")
+		}
+	}
+	return card, nil
+}
+
+// answerPreviewBot answers a link unfurler asking for shortURL, which
+// leads to longURL, with the link's card or as analytics.previewBots says.
+// It reports whether it answered; if not, the visit goes on as anyone's.
+func (s *Server) answerPreviewBot(w http.ResponseWriter, r *http.Request, shortURL, longURL string) bool {
+	if !isPreviewBot(r) {
+		return false
+	}
+	card, err := s.store.Card(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching the card of short URL '%s': %v", shortURL, err)
+	}
+	switch {
+	case !card.IsZero() || s.cfg.Analytics.PreviewBots == previewCard:
+		logf(r.Context(), "Answering link unfurler for short URL '%s' with a card", shortURL)
+		s.previewCard(w, r, shortURL, longURL, card)
+	case s.cfg.Analytics.PreviewBots == previewIgnore:
+		logf(r.Context(), "Redirecting link unfurler for short URL '%s' without counting it", shortURL)
+		http.Redirect(w, r, longURL, s.cfg.RedirectStatus())
+	default:
+		return false
+	}
+	return true
+}
+
+// setCard replaces the card of shortURL and records it in the audit log.
+func (s *Server) setCard(ctx context.Context, shortURL string, card store.Card) error {
+	before := s.auditSnapshot(ctx, shortURL)
+	if err := s.store.SetCard(ctx, shortURL, card); err != nil {
+		return err
+	}
+	if before != nil {
+		after := *before
+		after.Card = nil
+		if !card.IsZero() {
+			after.Card = &card
+		}
+		s.audit(ctx, shortURL, store.AuditUpdate, before, &after)
+	}
+	return nil
+}
+
+// cardPage is the data for card.html.
+type cardPage struct {
+	// ShortURL is the link's full public URL.
+	ShortURL string
+	// LongURL is empty unless it's an http or https URL, as escaping
+	// doesn't stop a javascript: URL from running in the refresh or link.
+	LongURL     string
+	Title       string
+	Description string
+	// Image is empty unless the link's card sets one.
+	Image string
+}
+
+// previewCard answers an unfurler asking for shortURL with card.html,
+// filled in from card and, where it leaves them out, from longURL, where
+// the link leads.
+func (s *Server) previewCard(w http.ResponseWriter, r *http.Request, shortURL, longURL string, card store.Card) {
+	page := cardPage{
+		ShortURL:    s.cfg.PublicURL(r) + "/_/" + shortURL,
+		Title:       longURL,
+		Description: longURL,
+		Image:       card.Image,
+	}
+	if validLongURL(longURL) {
+		page.LongURL = longURL
+	}
+	if u, err := url.Parse(longURL); err == nil && u.Host != "" {
+		page.Title = strings.TrimPrefix(u.Hostname(), "www.")
+	}
+	if card.Title != "" {
+		page.Title = card.Title
+	}
+	if card.Description != "" {
+		page.Description = card.Description
+	}
+
+	tmpl, err := s.templates.lookup("card.html")
+	if err != nil {
+		logf(r.Context(), "Error loading card template: %v", err)
+		http.Redirect(w, r, longURL, s.cfg.RedirectStatus())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(r.Context(), "Error executing card template: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestPreviewBots(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://www.example.com/launch?a=1&b=2"); err != nil {
+		t.Fatal(err)
+	}
+	visit := func(agent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/_/abc123", nil)
+		req.Header.Set("User-Agent", agent)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	visits := func() int {
+		link, err := st.Link(ctx, "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return link.VisitCount
+	}
+	const slack = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	const browser = "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"
+
+	// Counted like anyone else by default.
+	if rr := visit(slack); rr.Code != http.StatusFound || visits() != 1 {
+		t.Errorf("unfurler by default got %v and made %d visits want 302 and 1", rr.Code, visits())
+	}
+
+	srv.cfg.Analytics.PreviewBots = previewIgnore
+	if rr := visit(slack); rr.Code != http.StatusFound || visits() != 1 {
+		t.Errorf("ignored unfurler got %v and made %d visits want 302 and 1", rr.Code, visits())
+	}
+
+	srv.cfg.Analytics.PreviewBots = previewCard
+	rr := visit(slack)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || visits() != 1 {
+		t.Errorf("unfurler asking for a card got %v and made %d visits want 200 and 1", rr.Code, visits())
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="example.com">`,
+		`<meta property="og:url" content="http://example.com/_/abc123">`,
+		`<meta property="og:description" content="https://www.example.com/launch?a=1&amp;b=2">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("card is missing %s:\n%s", want, body)
+		}
+	}
+
+	if rr := visit(browser); rr.Code != http.StatusFound || visits() != 2 {
+		t.Errorf("browser got %v and made %d visits want 302 and 2", rr.Code, visits())
+	}
+}
+
+func TestPreviewCardScriptURL(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Analytics.PreviewBots = previewCard
+	if err := st.Create(context.Background(), "abc123", "javascript:alert(1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/_/abc123", nil)
+	req.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unfurler asking for a card got %v want 200", rr.Code)
+	}
+	if strings.Contains(body, "http-equiv") || strings.Contains(body, "href=") {
+		t.Errorf("card of a javascript: link refreshes to or links it:\n%s", body)
+	}
+}
+
+func TestValidatePreviewBots(t *testing.T) {
+	for _, mode := range []string{"", previewCount, previewIgnore, previewCard} {
+		if err := validatePreviewBots(mode); err != nil {
+			t.Errorf("validatePreviewBots(%q) returned an error: %v", mode, err)
+		}
+	}
+	if err := validatePreviewBots("hide"); err == nil {
+		t.Error("validatePreviewBots accepted hide")
+	}
+}
+
+func TestLinkCard(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	ctx := context.Background()
+	api := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	visit := func(agent string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/_/launch", nil)
+		req.Header.Set("User-Agent", agent)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	const slack = "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"
+	const browser = "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0"
+
+	checkAPIError(t, api("POST", "/api/v1/links", `{"url": "https://example.com/a", "card": {"image": "javascript:alert(1)"}}`), http.StatusBadRequest, errCodeInvalidCard)
+	checkAPIError(t, api("POST", "/api/v1/links", `{"url": "https://example.com/a", "card": {"title": "`+strings.Repeat("x", maxCardTitle+1)+`"}}`), http.StatusBadRequest, errCodeInvalidCard)
+
+	rr := api("POST", "/api/v1/links", `{"url": "https://example.com/launch", "alias": "launch", "card": {"title": " Example 2.0 ", "image": "https://cdn.example.com/launch.png"}}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("creating a link with a card got %v: %s", rr.Code, rr.Body)
+	}
+	var link linkResponse
+	if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	want := store.Card{Title: "Example 2.0", Image: "https://cdn.example.com/launch.png"}
+	if link.Card == nil || *link.Card != want {
+		t.Errorf("created link has card %+v want %+v", link.Card, want)
+	}
+
+	// Unfurlers get the card even though previewBots is left at count.
+	rr = visit(slack)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK {
+		t.Errorf("unfurler got %v want 200", rr.Code)
+	}
+	for _, want := range []string{
+		`<meta property="og:title" content="Example 2.0">`,
+		`<meta property="og:description" content="https://example.com/launch">`,
+		`<meta property="og:image" content="https://cdn.example.com/launch.png">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("card is missing %s:\n%s", want, body)
+		}
+	}
+	if rr := visit(browser); rr.Code != http.StatusFound {
+		t.Errorf("browser got %v want 302", rr.Code)
+	}
+	if stats, err := st.Link(ctx, "launch"); err != nil || stats.VisitCount != 1 {
+		t.Errorf("link has %d visits, %v want 1", stats.VisitCount, err)
+	}
+
+	checkAPIError(t, api("PATCH", "/api/v1/links/launch", `{"card": {"image": "/launch.png"}}`), http.StatusBadRequest, errCodeInvalidCard)
+	checkAPIError(t, api("PATCH", "/api/v1/links/missing", `{"card": {"title": "Missing"}}`), http.StatusNotFound, errCodeNotFound)
+	if rr := api("PATCH", "/api/v1/links/launch", `{"card": {"description": "Everything new"}}`); rr.Code != http.StatusOK {
+		t.Fatalf("replacing the card got %v: %s", rr.Code, rr.Body)
+	}
+	if body := visit(slack).Body.String(); !strings.Contains(body, `<meta property="og:description" content="Everything new">`) ||
+		!strings.Contains(body, `<meta property="og:title" content="example.com">`) || strings.Contains(body, "og:image") {
+		t.Errorf("replaced card is wrong:\n%s", body)
+	}
+
+	if rr := api("PATCH", "/api/v1/links/launch", `{"card": {}}`); rr.Code != http.StatusOK {
+		t.Fatalf("removing the card got %v: %s", rr.Code, rr.Body)
+	}
+	if rr := visit(slack); rr.Code != http.StatusFound {
+		t.Errorf("unfurler after removing the card got %v want 302", rr.Code)
+	}
+	if card, err := st.Card(ctx, "launch"); err != nil || !card.IsZero() {
+		t.Errorf("Card after removing it returned %+v, %v", card, err)
+	}
+}
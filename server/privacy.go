@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// analytics.anonymizeIPs keeps visitors' addresses out of the database and
+// the log. "truncate" keeps the /24 of an IPv4 address and the /48 of an
+// IPv6 one; "hash" keeps a keyed hash, which still tells visitors apart
+// for unique counts and burst detection. With analytics.honorDoNotTrack
+// set, visitors who send DNT or Sec-GPC aren't written to the click log at
+// all, though their visit is still counted.
+
+const (
+	anonymizeTruncate = "truncate"
+	anonymizeHash     = "hash"
+)
+
+// validateAnonymizeIPs checks analytics.anonymizeIPs names a known mode.
+func validateAnonymizeIPs(mode string) error {
+	switch mode {
+	case "", anonymizeTruncate, anonymizeHash:
+		return nil
+	}
+	return fmt.Errorf("unknown analytics.anonymizeIPs %q, want %q or %q", mode, anonymizeTruncate, anonymizeHash)
+}
+
+// newIPHashKey returns analytics.ipHashKey, or a random key when it is
+// empty. A random key changes on every restart, so hashes can't be
+// matched across restarts either.
+func newIPHashKey(configured string) ([]byte, error) {
+	if configured != "" {
+		return []byte(configured), nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate IP hash key: %v", err)
+	}
+	return key, nil
+}
+
+// clientIP returns the address r came from as it may be stored or
+// logged, anonymized as analytics.anonymizeIPs asks.
+func (s *Server) clientIP(r *http.Request) string {
+	return s.anonymizeIP(remoteHost(r))
+}
+
+func (s *Server) anonymizeIP(host string) string {
+	switch s.cfg.Analytics.AnonymizeIPs {
+	case anonymizeTruncate:
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return ""
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	case anonymizeHash:
+		mac := hmac.New(sha256.New, s.ipHashKey)
+		mac.Write([]byte(host))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return host
+}
+
+// doNotTrack reports whether the visitor asked not to be tracked, with DNT
+// or Global Privacy Control, and analytics.honorDoNotTrack says to listen.
+func (s *Server) doNotTrack(r *http.Request) bool {
+	if !s.cfg.Analytics.HonorDoNotTrack {
+		return false
+	}
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+
+	if got := srv.anonymizeIP("192.0.2.123"); got != "192.0.2.123" {
+		t.Errorf("anonymizeIP with anonymizing off returned %q", got)
+	}
+
+	srv.cfg.Analytics.AnonymizeIPs = anonymizeTruncate
+	tests := map[string]string{
+		"192.0.2.123":              "192.0.2.0",
+		"2001:db8:1234:5678::abcd": "2001:db8:1234::",
+		"not-an-ip":                "",
+	}
+	for host, want := range tests {
+		if got := srv.anonymizeIP(host); got != want {
+			t.Errorf("truncated anonymizeIP(%q) = %q, want %q", host, got, want)
+		}
+	}
+
+	srv.cfg.Analytics.AnonymizeIPs = anonymizeHash
+	srv.ipHashKey = []byte("secret")
+	first, again, other := srv.anonymizeIP("192.0.2.1"), srv.anonymizeIP("192.0.2.1"), srv.anonymizeIP("192.0.2.2")
+	if len(first) != 32 || first != again || first == other {
+		t.Errorf("hashed anonymizeIP returned %q, %q and %q", first, again, other)
+	}
+	srv.ipHashKey = []byte("other")
+	if srv.anonymizeIP("192.0.2.1") == first {
+		t.Error("hashed anonymizeIP ignored the key")
+	}
+}
+
+func TestValidateAnonymizeIPs(t *testing.T) {
+	for _, mode := range []string{"", anonymizeTruncate, anonymizeHash} {
+		if err := validateAnonymizeIPs(mode); err != nil {
+			t.Errorf("validateAnonymizeIPs(%q) returned an error: %v", mode, err)
+		}
+	}
+	if err := validateAnonymizeIPs("scramble"); err == nil {
+		t.Error("validateAnonymizeIPs accepted an unknown mode")
+	}
+}
+
+func TestRedirectPrivacy(t *testing.T) {
+	ctx := context.Background()
+	redirect := func(t *testing.T, header string, setup func(srv *Server)) *store.Memory {
+		t.Helper()
+		srv, st := newMemoryServer(t)
+		srv.cfg.Analytics.ClickLog = true
+		setup(srv)
+		if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("GET", "/_/abc123", nil)
+		req.RemoteAddr = "192.0.2.123:1234"
+		if header != "" {
+			req.Header.Set(header, "1")
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+		}
+		if link, err := st.Link(ctx, "abc123"); err != nil || link.VisitCount != 1 {
+			t.Errorf("link has %d visits, %v want 1", link.VisitCount, err)
+		}
+		return st
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		st := redirect(t, "", func(srv *Server) { srv.cfg.Analytics.AnonymizeIPs = anonymizeTruncate })
+		if clicks, err := st.RecentClicks(ctx, "abc123", 10); err != nil || len(clicks) != 1 || clicks[0].IP != "192.0.2.0" {
+			t.Errorf("click log has %+v, %v want one click from 192.0.2.0", clicks, err)
+		}
+	})
+
+	for _, header := range []string{"DNT", "Sec-GPC"} {
+		t.Run(header, func(t *testing.T) {
+			// The visit is counted, but no click is logged.
+			st := redirect(t, header, func(srv *Server) { srv.cfg.Analytics.HonorDoNotTrack = true })
+			if clicks, err := st.RecentClicks(ctx, "abc123", 10); err != nil || len(clicks) != 0 {
+				t.Errorf("click log has %+v, %v want none", clicks, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Every HTTP request gets an ID, so an operator can match a user's report
+// with the log. A valid X-Request-ID from a proxy in front is kept;
+// otherwise a random one is made. The ID is sent back in the X-Request-ID
+// header, shown on error pages and in API errors, and put at the start of
+// every log line written while handling the request.
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs taken from the request, so a client can't
+// flood the log through the header.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID records the request's ID in ctx.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside a
+// request. Hooks and middleware can use it to tag their own logs.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id is safe to log and echo back: printable
+// ASCII without spaces, up to maxRequestIDLength bytes.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random 16-character hex ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("Error generating request ID: %v", err)
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// assignRequestID picks the ID for r and sets it on the response.
+func assignRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(withRequestID(r.Context(), id))
+}
+
+// logf is log.Printf with the request ID from ctx, if any, in front.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	log.Output(2, requestIDPrefix(ctx)+fmt.Sprintf(format, args...))
+}
+
+// logln is log.Println with the request ID from ctx, if any, in front.
+func logln(ctx context.Context, args ...interface{}) {
+	log.Output(2, requestIDPrefix(ctx)+fmt.Sprintln(args...))
+}
+
+func requestIDPrefix(ctx context.Context) string {
+	if id := RequestID(ctx); id != "" {
+		return "[" + id + "] "
+	}
+	return ""
+}
+
+// httpError is http.Error with the request ID set on w added to the
+// message, so a visitor can quote it.
+func httpError(w http.ResponseWriter, message string, status int) {
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		message += " (request ID " + id + ")"
+	}
+	http.Error(w, message, status)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := map[string]bool{
+		"":                           false,
+		"abc-123":                    true,
+		"req_5f0c:1":                 true,
+		"has space":                  false,
+		"new\nline":                  false,
+		"café":                       false,
+		strings.Repeat("a", 128):     true,
+		strings.Repeat("a", 129):     false,
+		"00f6b8a1-2a54-4c4b-9b5e-1f": true,
+	}
+	for id, want := range tests {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+
+	var seen string
+	srv.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestID(r.Context())
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	t.Run("From Proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/nope", nil)
+		req.Header.Set("X-Request-ID", "proxy-42")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get("X-Request-ID"); got != "proxy-42" {
+			t.Errorf("handler returned request ID %q, want %q", got, "proxy-42")
+		}
+		if seen != "proxy-42" {
+			t.Errorf("middleware saw request ID %q, want %q", seen, "proxy-42")
+		}
+		var envelope APIErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+			t.Fatalf("Failed to decode error response: %v", err)
+		}
+		if envelope.Error.RequestID != "proxy-42" {
+			t.Errorf("API error has request ID %q, want %q", envelope.Error.RequestID, "proxy-42")
+		}
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/nope", nil)
+		req.Header.Set("X-Request-ID", "not valid")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		id := rr.Header().Get("X-Request-ID")
+		if len(id) != 16 || id == "not valid" {
+			t.Errorf("handler returned request ID %q, want a new 16-character ID", id)
+		}
+		if seen != id {
+			t.Errorf("middleware saw request ID %q, want %q", seen, id)
+		}
+	})
+
+	t.Run("Error Page", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/create", strings.NewReader("url=x"))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Request-ID", "page-7")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if !strings.Contains(rr.Body.String(), "(request ID page-7)") {
+			t.Errorf("error page doesn't show the request ID: %q", rr.Body.String())
+		}
+	})
+}
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	output, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(output)
+		log.SetFlags(flags)
+	})
+
+	logf(withRequestID(context.Background(), "abc"), "Handling %s", "something")
+	logln(context.Background(), "Outside a request:", 1)
+	if got, want := buf.String(), "[abc] Handling something\nOutside a request: 1\n"; got != want {
+		t.Errorf("log output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// analytics.clickRetentionDays and analytics.rollupRetentionDays bound how
+// long raw clicks and daily rollups are kept, for privacy policies and disk
+// budgets. An hourly purger deletes whatever is older. Both are off when
+// zero, which keeps data forever.
+
+const analyticsPurgeInterval = time.Hour
+
+// purgeAnalytics deletes the clicks and rollups that have outlived their
+// retention. Days are counted back from midnight UTC today.
+func (s *Server) purgeAnalytics(ctx context.Context) {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if days := s.cfg.Analytics.ClickRetentionDays; days > 0 {
+		purged, err := s.store.PurgeClicks(ctx, today.AddDate(0, 0, -days))
+		if err != nil {
+			log.Printf("Error purging old clicks: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d click(s) older than %d day(s)", purged, days)
+		}
+	}
+	if days := s.cfg.Analytics.RollupRetentionDays; days > 0 {
+		purged, err := s.store.PurgeRollups(ctx, today.AddDate(0, 0, -days))
+		if err != nil {
+			log.Printf("Error purging old click rollups: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d click rollup(s) older than %d day(s)", purged, days)
+		}
+	}
+}
+
+// startAnalyticsPurger runs purgeAnalytics every analyticsPurgeInterval
+// until ctx is cancelled. It does nothing when neither retention is set.
+func (s *Server) startAnalyticsPurger(ctx context.Context) {
+	if s.cfg.Analytics.ClickRetentionDays <= 0 && s.cfg.Analytics.RollupRetentionDays <= 0 {
+		return
+	}
+	if days := s.cfg.Analytics.ClickRetentionDays; days > 0 {
+		log.Printf("Keeping clicks for %d day(s)", days)
+		if rollupDays := s.cfg.Analytics.RollupDays; rollupDays >= days {
+			log.Printf("Clicks are purged before they are %d day(s) old, so they are never rolled up", rollupDays)
+		}
+	}
+	if days := s.cfg.Analytics.RollupRetentionDays; days > 0 {
+		log.Printf("Keeping click rollups for %d day(s)", days)
+	}
+
+	go func() {
+		s.purgeAnalytics(ctx)
+		ticker := time.NewTicker(analyticsPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.lead(ctx, "purge", analyticsPurgeInterval) {
+					s.purgeAnalytics(ctx)
+				}
+			}
+		}
+	}()
+}
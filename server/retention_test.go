@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPurgeAnalytics(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Analytics.ClickRetentionDays = 30
+	srv.cfg.Analytics.RollupRetentionDays = 365
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec("DELETE FROM clicks WHERE clicked_at").
+		WithArgs(today.AddDate(0, 0, -30).Format("2006-01-02 15:04:05")).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec("DELETE FROM click_rollups WHERE day").
+		WithArgs(today.AddDate(0, 0, -365).Format("2006-01-02")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	srv.purgeAnalytics(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With analytics.rollupDays set, clicks older than that many days are
+// compacted into one rollup per link and day: its clicks, unique
+// addresses, top referrer and top country. Daily charts keep reading the
+// rolled up days, while the click log stays a bounded size. Hourly charts,
+// heatmaps and recent clicks only see the raw clicks that remain.
+
+const rollupInterval = time.Hour
+
+const (
+	defaultRollupDays = 90
+	maxRollupDays     = 3650
+)
+
+// rollupClicks compacts the clicks made before midnight UTC
+// analytics.rollupDays days ago.
+func (s *Server) rollupClicks(ctx context.Context) error {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	compacted, err := s.store.RollupClicks(ctx, today.AddDate(0, 0, -s.cfg.Analytics.RollupDays))
+	if err != nil {
+		return fmt.Errorf("error rolling up clicks: %v", err)
+	}
+	if compacted > 0 {
+		log.Printf("Rolled up %d click(s)", compacted)
+	}
+	return nil
+}
+
+// submitRollup queues rollupClicks on the job pool, with a few retries in
+// case the database is busy.
+func (s *Server) submitRollup() {
+	s.jobs.submit(&job{name: "click rollup", attempts: 3, run: s.rollupClicks})
+}
+
+// startClickRollup runs rollupClicks every rollupInterval until ctx is
+// cancelled. As whole days are compacted, most runs find nothing to do. It
+// does nothing without analytics.rollupDays.
+func (s *Server) startClickRollup(ctx context.Context) {
+	if s.cfg.Analytics.RollupDays <= 0 {
+		return
+	}
+	log.Printf("Rolling up clicks older than %d day(s)", s.cfg.Analytics.RollupDays)
+
+	go func() {
+		s.submitRollup()
+		ticker := time.NewTicker(rollupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.lead(ctx, "rollup", rollupInterval) {
+					s.submitRollup()
+				}
+			}
+		}
+	}()
+}
+
+// handleAPIRollups serves GET /api/v1/stats/rollups, the rolled up days
+// for every link or for the one named by ?shortURL=, going back ?days=.
+func (s *Server) handleAPIRollups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	days := defaultRollupDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Days must be a positive number")
+			return
+		}
+		if n < maxRollupDays {
+			days = n
+		} else {
+			days = maxRollupDays
+		}
+	}
+
+	shortURL := r.URL.Query().Get("shortURL")
+	if shortURL != "" {
+		if _, err := s.store.Link(r.Context(), shortURL); err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+			return
+		} else if err != nil {
+			logf(r.Context(), "Error fetching short URL '%s': %v", shortURL, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching rollups")
+			return
+		}
+	}
+	rollups, err := s.store.ClickRollups(r.Context(), shortURL, time.Now().UTC().AddDate(0, 0, -days))
+	if err != nil {
+		logf(r.Context(), "Error fetching click rollups: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching rollups")
+		return
+	}
+	if rollups == nil {
+		rollups = []store.ClickRollup{}
+	}
+	writeRevalidatedJSON(w, r, struct {
+		Rollups []store.ClickRollup `json:"rollups"`
+	}{rollups}, false)
+}
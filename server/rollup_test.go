@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestRollupClicks(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Analytics.RollupDays = 30
+
+	now := time.Now().UTC()
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -30).Format("2006-01-02 15:04:05")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO click_rollups").
+		WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM clicks WHERE clicked_at").
+		WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectCommit()
+
+	srv.rollupClicks(context.Background())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHandleAPIRollups(t *testing.T) {
+	t.Run("Bad Days", func(t *testing.T) {
+		srv, _ := newMemoryServer(t)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/rollups?days=-1", nil))
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidForm)
+	})
+
+	t.Run("Unknown Link", func(t *testing.T) {
+		srv, _ := newMemoryServer(t)
+
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/rollups?shortURL=missing", nil))
+		checkAPIError(t, rr, http.StatusNotFound, errCodeNotFound)
+	})
+
+	t.Run("All Links", func(t *testing.T) {
+		srv, mock := newMockServer(t)
+		mock.ExpectQuery("FROM click_rollups").
+			WithArgs(sqlmock.AnyArg(), "", "").
+			WillReturnRows(sqlmock.NewRows([]string{"short_url", "day", "clicks", "uniques", "top_referrer", "top_country"}).
+				AddRow("abc123", "2024-01-01", 40, 12, "https://news.example", "NL"))
+
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/rollups?days=7", nil))
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var resp struct {
+			Rollups []store.ClickRollup `json:"rollups"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Rollups) != 1 || resp.Rollups[0].Clicks != 40 || resp.Rollups[0].TopCountry != "NL" {
+			t.Errorf("handler returned unexpected rollups: %+v", resp.Rollups)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// fakeS3 is just enough of the S3 API to exercise offsite backups.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]time.Time
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=access/") {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodPut:
+		io.Copy(io.Discard, r.Body)
+		f.objects[key] = time.Now()
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		type object struct {
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+		}
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []object `xml:"Contents"`
+		}
+		for k, modified := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				result.Contents = append(result.Contents, object{k, modified})
+			}
+		}
+		xml.NewEncoder(w).Encode(result)
+	}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestUploadBackup(t *testing.T) {
+	fake := &fakeS3{objects: map[string]time.Time{
+		"shorty/shorty-20240101T000000Z.db": time.Now(),
+		"shorty/shorty-20240102T000000Z.db": time.Now(),
+		"shorty/notes.txt":                  time.Now(),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	srv := &Server{cfg: &config.Config{}}
+	srv.cfg.Backup.S3.Endpoint = server.URL
+	srv.cfg.Backup.S3.Bucket = "bucket"
+	srv.cfg.Backup.S3.Prefix = "shorty/"
+	srv.cfg.Backup.S3.AccessKey = "access"
+	srv.cfg.Backup.S3.SecretKey = "secret"
+	srv.cfg.Backup.S3.PathStyle = true
+	srv.cfg.Backup.S3.Keep = 2
+
+	local := filepath.Join(t.TempDir(), "shorty-20240103T000000Z.db")
+	if err := os.WriteFile(local, []byte("backup"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.uploadBackup(context.Background(), local); err != nil {
+		t.Fatalf("uploadBackup returned an error: %v", err)
+	}
+
+	want := []string{
+		"shorty/notes.txt",
+		"shorty/shorty-20240102T000000Z.db",
+		"shorty/shorty-20240103T000000Z.db",
+	}
+	got := fake.keys()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Bucket has wrong objects after upload: got %v want %v", got, want)
+	}
+}
+
+func TestS3SignatureIsDeterministic(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Backup.S3.Endpoint = "https://s3.amazonaws.com"
+	cfg.Backup.S3.Bucket = "examplebucket"
+	cfg.Backup.S3.AccessKey = "AKIDEXAMPLE"
+	cfg.Backup.S3.SecretKey = "secret"
+
+	client, err := newS3Client(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	sign := func() string {
+		req, _ := http.NewRequest("GET", client.objectURL("shorty/a.db").String(), nil)
+		client.sign(req, nil)
+		return req.Header.Get("Authorization")
+	}
+
+	first := sign()
+	if first != sign() {
+		t.Error("Signing the same request twice produced different signatures")
+	}
+	if !strings.Contains(first, "Credential=AKIDEXAMPLE/20240102/us-east-1/s3/aws4_request") {
+		t.Errorf("Authorization header has wrong credential scope: %v", first)
+	}
+	if host := client.objectURL("k").Host; host != "examplebucket.s3.amazonaws.com" {
+		t.Errorf("Virtual-hosted URL has wrong host: %v", host)
+	}
+}
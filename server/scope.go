@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// Each api.keys entry lists the scopes it is good for, so a leaked key
+// can only do what its job needed: a CI job that shortens release links
+// gets "create" and can't delete anything. "admin" covers everything the
+// admin key can do, and the other scopes with it.
+
+// Scopes an API key can be given in api.keys.
+const (
+	// scopeCreate creates links through the JSON API.
+	scopeCreate = "create"
+	// scopeStats reads link stats, even when share.privateStats keeps
+	// them from the public.
+	scopeStats = "stats"
+	// scopeAdmin does anything the admin key does.
+	scopeAdmin = "admin"
+)
+
+// defaultScopes are the scopes of a key that lists none.
+var defaultScopes = []string{scopeCreate}
+
+func validScope(scope string) bool {
+	switch scope {
+	case scopeCreate, scopeStats, scopeAdmin:
+		return true
+	}
+	return false
+}
+
+// hasScope reports whether key may do what scope covers.
+func hasScope(key config.APIKey, scope string) bool {
+	scopes := key.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultScopes
+	}
+	for _, s := range scopes {
+		if s == scope || s == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// keyScoped reports whether r carries an API key with scope.
+func (s *Server) keyScoped(r *http.Request, scope string) bool {
+	key, ok := s.apiKeyFor(r)
+	return ok && hasScope(key, scope)
+}
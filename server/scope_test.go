@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		scope  string
+		want   bool
+	}{
+		{nil, scopeCreate, true},
+		{nil, scopeStats, false},
+		{nil, scopeAdmin, false},
+		{[]string{scopeStats}, scopeCreate, false},
+		{[]string{scopeStats}, scopeStats, true},
+		{[]string{scopeAdmin}, scopeCreate, true},
+		{[]string{scopeAdmin}, scopeStats, true},
+	}
+	for _, tt := range tests {
+		if got := hasScope(config.APIKey{Scopes: tt.scopes}, tt.scope); got != tt.want {
+			t.Errorf("hasScope(%v, %s) = %v want %v", tt.scopes, tt.scope, got, tt.want)
+		}
+	}
+
+	if err := validateAPIKeys([]config.APIKey{{Name: "ci", Key: "k1", Scopes: []string{"delete"}}}, ""); err == nil {
+		t.Error("validateAPIKeys accepted an unknown scope")
+	}
+}
+
+func TestScopedKeys(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Share.PrivateStats = true
+	srv.cfg.API.Keys = []config.APIKey{
+		{Name: "ci", Key: "ci-key"},
+		{Name: "metrics", Key: "metrics-key", Scopes: []string{scopeStats}},
+		{Name: "ops", Key: "ops-key", Scopes: []string{scopeAdmin}},
+	}
+	if err := st.Create(context.Background(), "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		for _, token := range []string{"ci-key", "ops-key"} {
+			if rr := request("POST", "/api/v1/links", token, `{"url": "https://example.com/new"}`); rr.Code != http.StatusCreated {
+				t.Errorf("create with %s returned %v want %v", token, rr.Code, http.StatusCreated)
+			}
+		}
+		checkAPIError(t, request("POST", "/api/v1/links", "metrics-key", `{"url": "https://example.com/new"}`), http.StatusForbidden, errCodeForbidden)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		for _, token := range []string{"metrics-key", "ops-key"} {
+			if rr := request("GET", "/api/v1/links/abc123", token, ""); rr.Code != http.StatusOK {
+				t.Errorf("stats with %s returned %v want %v", token, rr.Code, http.StatusOK)
+			}
+		}
+		checkAPIError(t, request("GET", "/api/v1/links/abc123", "ci-key", ""), http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Admin", func(t *testing.T) {
+		for _, token := range []string{"ci-key", "metrics-key"} {
+			checkAPIError(t, request("DELETE", "/api/v1/links/abc123", token, ""), http.StatusUnauthorized, errCodeUnauthorized)
+		}
+		if rr := request("GET", "/api/v1/admin/audit", "ops-key", ""); rr.Code != http.StatusOK {
+			t.Errorf("audit with ops-key returned %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+}
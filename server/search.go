@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// GET /api/v1/admin/search finds links for admins and scripts, like the
+// admin page's search box: ?q= matches the short URL, long URL or a tag,
+// ignoring case. Where the database has a full-text index it answers from
+// that rather than reading every link.
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 1000
+)
+
+func (s *Server) handleAdminSearch(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling search request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Search needs a query in ?q=")
+		return
+	}
+	limit := defaultSearchLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Limit must be a positive number")
+			return
+		}
+		if n < maxSearchLimit {
+			limit = n
+		} else {
+			limit = maxSearchLimit
+		}
+	}
+
+	links, err := s.store.SearchLinks(r.Context(), query, limit)
+	if err != nil {
+		logf(r.Context(), "Error searching links: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error searching links")
+		return
+	}
+	if links == nil {
+		links = []store.LinkStats{}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Links []store.LinkStats `json:"links"`
+	}{links})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestAdminSearch(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "ghi789"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.SetTags(ctx, "abc123", []string{"launch"}); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/admin/search"+query, nil)
+		if admin {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	checkAPIError(t, search("?q=example", false), http.StatusUnauthorized, errCodeUnauthorized)
+	checkAPIError(t, search("", true), http.StatusBadRequest, errCodeInvalidForm)
+	checkAPIError(t, search("?q=example&limit=0", true), http.StatusBadRequest, errCodeInvalidForm)
+
+	for query, want := range map[string]string{
+		"?q=EXAMPLE":         "ghi789,def456,abc123",
+		"?q=example&limit=2": "ghi789,def456",
+		"?q=launch":          "abc123",
+		"?q=nothing":         "",
+	} {
+		rr := search(query, true)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusOK)
+		}
+		var body struct {
+			Links []store.LinkStats `json:"links"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, link := range body.Links {
+			got = append(got, link.ShortURL)
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: handler returned %v want %s", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With shortURL.strategy set to "sequential", codes are base62-encoded
+// numbers from an ever-increasing sequence instead of random strings, so
+// creating a link takes one insert and never has to retry on a collision.
+// shortURL.sequenceOffset and shortURL.scramble hide how many links exist
+// and make neighbouring codes look unrelated. That is obfuscation, not
+// security: don't rely on codes being unguessable in this mode.
+
+// In cluster mode, a round-trip to the shared sequence for every new link
+// would make the database the bottleneck, so each replica makes its own
+// numbers instead, Snowflake style: the seconds since snowflakeEpoch, then
+// cluster.nodeID, then a counter of the links made in that second. Replicas
+// with different node IDs can't make the same number. The numbers are large,
+// so codes are 9 characters long, however few links there are.
+
+// Short URL strategies.
+const (
+	strategyRandom     = "random"
+	strategySequential = "sequential"
+)
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// maxSequentialLength is the longest code whose range, 62^10, still fits
+// in a uint64.
+const maxSequentialLength = 10
+
+// scrambleFactor is odd and not a multiple of 31, so multiplying by it is a
+// permutation of the codes of any given length.
+const scrambleFactor = 0x9E3779B97F4A7C15
+
+// Snowflake number layout: 32 bits of seconds, good for 136 years, then
+// the node ID and the counter.
+const (
+	snowflakeNodeBits = 10
+	snowflakeSeqBits  = 12
+	maxNodeID         = 1<<snowflakeNodeBits - 1
+)
+
+var snowflakeEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// snowflake makes sequence numbers for one replica without asking the
+// store.
+type snowflake struct {
+	mu   sync.Mutex
+	node uint64
+	last int64
+	seq  uint64
+}
+
+// next returns the number for a link made at now. Past 4096 links in a
+// second, or when the clock steps back, it borrows from the seconds ahead
+// rather than waiting; a restart soon after may then repeat a number, which
+// sequentialShortURL skips like a taken code.
+func (f *snowflake) next(now time.Time) uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := int64(now.Sub(snowflakeEpoch) / time.Second)
+	if t < f.last {
+		t = f.last
+	}
+	if t == f.last {
+		f.seq++
+		if f.seq == 1<<snowflakeSeqBits {
+			t++
+			f.seq = 0
+		}
+	} else {
+		f.seq = 0
+	}
+	f.last = t
+	return uint64(t)<<(snowflakeNodeBits+snowflakeSeqBits) | f.node<<snowflakeSeqBits | f.seq
+}
+
+func validateStrategy(strategy string, length int) error {
+	switch strategy {
+	case "", strategyRandom:
+		return nil
+	case strategySequential:
+		if length > maxSequentialLength {
+			return fmt.Errorf("shortURL.length can be at most %d with the sequential strategy", maxSequentialLength)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown shortURL.strategy %q", strategy)
+}
+
+// encodeSequential turns id into a code at least minLength characters
+// long. Codes grow by one character each time the ids outgrow the current
+// length. Within a length, the offset and scramble are applied modulo the
+// number of codes of that length, so distinct ids always get distinct
+// codes.
+func encodeSequential(id uint64, minLength int, offset uint64, scramble bool) (string, error) {
+	length := minLength
+	if length < 1 {
+		length = 1
+	}
+	space := uint64(1)
+	for i := 0; i < length; i++ {
+		space *= 62
+	}
+	for id >= space {
+		if length == maxSequentialLength {
+			return "", fmt.Errorf("sequence %d is past the largest code", id)
+		}
+		length++
+		space *= 62
+	}
+
+	n := id
+	if scramble {
+		hi, lo := bits.Mul64(n, scrambleFactor)
+		_, n = bits.Div64(hi%space, lo, space)
+	}
+	n = (n%space + offset%space) % space
+
+	code := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		code[i] = base62[n%62]
+		n /= 62
+	}
+	return string(code), nil
+}
+
+// nextID returns the next number in the sequence: from the store, or made
+// locally in cluster mode.
+func (s *Server) nextID(ctx context.Context) (uint64, error) {
+	if s.cfg.Cluster.Enabled {
+		return s.ids.next(time.Now()), nil
+	}
+	return s.store.NextID(ctx)
+}
+
+// sequentialShortURL stores longURL under the code for the next number in
+// the sequence. A custom alias or another replica may already hold that
+// code, or it may contain a blocked word, in which case the number after it
+// is used.
+func (s *Server) sequentialShortURL(ctx context.Context, longURL string) (string, error) {
+	for {
+		id, err := s.nextID(ctx)
+		if err != nil {
+			return "", err
+		}
+		shortURL, err := encodeSequential(id, s.cfg.ShortURL.Length, s.cfg.ShortURL.SequenceOffset, s.cfg.ShortURL.Scramble)
+		if err != nil {
+			return "", err
+		}
+		shortURL = s.sourceCodes(ctx).Prefix + shortURL
+		if s.blockedCode(shortURL) {
+			logf(ctx, "Sequential short URL '%s' contains a blocked word, skipping it", shortURL)
+			continue
+		}
+		err = s.store.Create(ctx, shortURL, longURL)
+		if err == store.ErrExists {
+			logf(ctx, "Sequential short URL '%s' is taken by an alias, skipping it", shortURL)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return shortURL, nil
+	}
+}
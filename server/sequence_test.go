@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEncodeSequential(t *testing.T) {
+	tests := []struct {
+		id   uint64
+		want string
+	}{
+		{0, "000"},
+		{61, "00z"},
+		{62, "010"},
+		{62 * 62 * 62, "1000"},
+	}
+	for _, tt := range tests {
+		if got, err := encodeSequential(tt.id, 3, 0, false); err != nil || got != tt.want {
+			t.Errorf("encodeSequential(%d) returned %q, %v want %q", tt.id, got, err, tt.want)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for id := uint64(0); id < 62*62*2; id++ {
+		code, err := encodeSequential(id, 2, 12345, true)
+		if err != nil {
+			t.Fatalf("encodeSequential(%d) returned an error: %v", id, err)
+		}
+		if seen[code] {
+			t.Fatalf("encodeSequential(%d) repeated code %q", id, code)
+		}
+		seen[code] = true
+	}
+
+	if first, _ := encodeSequential(1, 6, 0, true); first == "000001" {
+		t.Errorf("scrambled code for 1 was not scrambled: %q", first)
+	}
+	if _, err := encodeSequential(^uint64(0), 6, 0, false); err == nil {
+		t.Error("Expected an error past the largest code, got nil")
+	}
+}
+
+func TestValidateStrategy(t *testing.T) {
+	for _, strategy := range []string{"", "random", "sequential"} {
+		if err := validateStrategy(strategy, 8); err != nil {
+			t.Errorf("validateStrategy(%q) returned %v", strategy, err)
+		}
+	}
+	if err := validateStrategy("snowflake", 8); err == nil {
+		t.Error("Expected an error for an unknown strategy, got nil")
+	}
+	if err := validateStrategy("sequential", 11); err == nil {
+		t.Error("Expected an error for a sequential code longer than 10, got nil")
+	}
+}
+
+func TestSequentialShortURL(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.ShortURL.Strategy = strategySequential
+	srv.cfg.ShortURL.Length = 4
+
+	mock.ExpectExec("INSERT INTO short_url_sequence").
+		WillReturnResult(sqlmock.NewResult(63, 1))
+	mock.ExpectExec("DELETE FROM short_url_sequence").
+		WithArgs(63).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO url_mapping").
+		WithArgs("0011", "https://example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	shortURL, err := srv.generateShortURL(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("generateShortURL returned an error: %v", err)
+	}
+	if shortURL != "0011" {
+		t.Errorf("generateShortURL returned %q want %q", shortURL, "0011")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSnowflake(t *testing.T) {
+	now := snowflakeEpoch.Add(time.Hour)
+	a := &snowflake{node: 1}
+	b := &snowflake{node: 2}
+
+	seen := make(map[uint64]bool)
+	for i := 0; i < 5000; i++ {
+		for _, f := range []*snowflake{a, b} {
+			id := f.next(now)
+			if seen[id] {
+				t.Fatalf("snowflake node %d repeated %d", f.node, id)
+			}
+			seen[id] = true
+		}
+	}
+	// Past 4096 numbers in a second, a borrows from the next one.
+	if a.last != 3601 {
+		t.Errorf("snowflake is at second %d want 3601", a.last)
+	}
+	// The clock stepping back doesn't repeat numbers either.
+	if id := a.next(now.Add(-time.Minute)); seen[id] {
+		t.Errorf("snowflake repeated %d after the clock stepped back", id)
+	}
+
+	id := (&snowflake{node: maxNodeID}).next(snowflakeEpoch.AddDate(100, 0, 0))
+	if code, err := encodeSequential(id, 4, 0, true); err != nil || len(code) != 9 {
+		t.Errorf("encodeSequential(%d) returned %q, %v want a 9-character code", id, code, err)
+	}
+}
+
+func TestSequentialShortURLCluster(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.ShortURL.Strategy = strategySequential
+	srv.cfg.ShortURL.Length = 4
+	srv.cfg.Cluster.Enabled = true
+	srv.ids.node = 3
+
+	// No round-trip to the sequence, only the insert.
+	mock.ExpectExec("INSERT INTO url_mapping").
+		WithArgs(sqlmock.AnyArg(), "https://example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	shortURL, err := srv.generateShortURL(context.Background(), "https://example.com")
+	if err != nil {
+		t.Fatalf("generateShortURL returned an error: %v", err)
+	}
+	if len(shortURL) != 9 {
+		t.Errorf("generateShortURL returned %q want a 9-character code", shortURL)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
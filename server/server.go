@@ -0,0 +1,303 @@
+// Package server serves shorty's web pages, JSON API, gRPC API and
+// integrations on top of a store.Store.
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/outbound"
+	"github.com/donuts-are-good/shorty/policy"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Server is a shorty instance. It implements http.Handler, so it can be
+// passed to an http.Server or mounted inside a larger program.
+type Server struct {
+	cfg       *config.Config
+	store     store.Store
+	templates *templateCache
+	static    fs.FS
+	mux       *http.ServeMux
+	outbound  *outbound.Client
+
+	codeLength adaptiveLength
+	stream     statsStream
+	crawlers   crawlerCache
+	anomalies  anomalyTracker
+	reports    reportTracker
+	ops        opsMonitor
+	ipHashKey  []byte
+	instanceID string
+	ids        snowflake
+	sink       *clickSink
+	events     *eventBus
+	jobs       *jobPool
+	hot        *store.HotCached
+	statsCache statsCache
+
+	// adminNetworks is api.adminNetworks, parsed.
+	adminNetworks []*net.IPNet
+	// allowedHosts is server.allowedHosts, parsed.
+	allowedHosts hostPatterns
+
+	hooks      hooks
+	middleware []Middleware
+	handler    http.Handler
+
+	// tenants are the servers of the other short domains, by host.
+	tenants map[string]*Server
+}
+
+// New builds a server from cfg and st. The templates are parsed and the
+// configured policy plugins set up here, so a broken theme or plugin config
+// is reported before any traffic is served.
+func New(cfg *config.Config, st store.Store) (*Server, error) {
+	s := &Server{
+		cfg:    cfg,
+		store:  st,
+		static: newThemeFS(cfg.Theme.Static, defaultStatic),
+		mux:    http.NewServeMux(),
+		outbound: outbound.New(outbound.Config{
+			Timeout:      cfg.Outbound.Timeout.Duration,
+			MaxBodyBytes: cfg.Outbound.MaxBodyBytes,
+			MaxRedirects: cfg.Outbound.MaxRedirects,
+			AllowPrivate: cfg.Outbound.AllowPrivate,
+		}),
+	}
+
+	if err := validateStrategy(cfg.ShortURL.Strategy, cfg.ShortURL.Length); err != nil {
+		return nil, err
+	}
+	if err := validateSources(cfg.ShortURL.Sources); err != nil {
+		return nil, err
+	}
+	if err := validateDigest(cfg.Digest.Weekday, cfg.Digest.Hour); err != nil {
+		return nil, err
+	}
+	if err := validateAnonymizeIPs(cfg.Analytics.AnonymizeIPs); err != nil {
+		return nil, err
+	}
+	if err := validatePreviewBots(cfg.Analytics.PreviewBots); err != nil {
+		return nil, err
+	}
+	if err := validateCluster(cfg.Cluster.Enabled, cfg.Cluster.NodeID, cfg.Cache.BloomFilter, cfg.Cache.HotLinks, cfg.Analytics.AnonymizeIPs, cfg.Analytics.IPHashKey); err != nil {
+		return nil, err
+	}
+	if err := validateSink(cfg.Analytics.Sink.URL, cfg.Analytics.Sink.Table); err != nil {
+		return nil, err
+	}
+	if err := validateAPIKeys(cfg.API.Keys, cfg.API.AdminKey); err != nil {
+		return nil, err
+	}
+	if err := validateBasicAuth(cfg.BasicAuth.Routes, cfg.BasicAuth.Users); err != nil {
+		return nil, err
+	}
+	if err := validateTeamMembers(cfg.BasicAuth.Users, cfg.API.Keys); err != nil {
+		return nil, err
+	}
+	if err := validateRedirectStatus(cfg.Links.RedirectStatus); err != nil {
+		return nil, err
+	}
+	if err := validateOrigins(cfg.API.AllowedOrigins); err != nil {
+		return nil, err
+	}
+	adminNetworks, err := parseNetworks(cfg.API.AdminNetworks)
+	if err != nil {
+		return nil, err
+	}
+	s.adminNetworks = adminNetworks
+	if s.allowedHosts, err = parseAllowedHosts(cfg.Server.AllowedHosts); err != nil {
+		return nil, err
+	}
+	if cfg.Ops.LocksPerMinute > 0 && s.opsEnabled() {
+		st = &lockCountingStore{Store: st, ops: &s.ops}
+		s.store = st
+	}
+	if cfg.Cache.HotLinks > 0 {
+		s.hot = store.NewHotCached(st)
+		s.store = s.hot
+	}
+	s.instanceID = newInstanceID(cfg.Cluster.InstanceID)
+	s.ids.node = uint64(cfg.Cluster.NodeID)
+	s.sink = newClickSink(cfg)
+	events, err := newEventBus(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.events = events
+	if cfg.Analytics.AnonymizeIPs == anonymizeHash {
+		key, err := newIPHashKey(cfg.Analytics.IPHashKey)
+		if err != nil {
+			return nil, err
+		}
+		s.ipHashKey = key
+	}
+
+	templates, err := loadTemplates(newThemeFS(cfg.Theme.Templates, defaultTemplates), cfg.Theme.Reload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %v", err)
+	}
+	s.templates = templates
+
+	if cfg.Moderation.Enabled {
+		s.OnCreate(s.checkBannedDomain)
+	}
+	for _, p := range cfg.Plugins {
+		pol, err := policy.New(p.Name, p.Config)
+		if err != nil {
+			return nil, err
+		}
+		s.OnCreate(pol.CheckCreate)
+		s.OnRedirect(pol.CheckRedirect)
+	}
+	if cfg.Links.Verify {
+		timeout := cfg.Links.VerifyTimeout.Or(config.DefaultVerifyTimeout)
+		s.OnCreate(func(ctx context.Context, longURL, alias string) (string, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return longURL, verifyReachable(ctx, s.outbound, longURL)
+		})
+	}
+
+	s.jobs = newJobPool(cfg.Jobs.Workers, cfg.Jobs.QueueSize)
+	s.routes()
+	s.handler = s.requireBasicAuth(s.mux)
+	return s, nil
+}
+
+func (s *Server) routes() {
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/create", s.handleCreate)
+	s.mux.HandleFunc("/shorten", s.handleShorten)
+	s.mux.HandleFunc("/_/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/_/")
+		if strings.HasSuffix(path, "/stats") {
+			shortURL := strings.TrimSuffix(path, "/stats")
+			s.handleLinkStats(w, r, shortURL)
+		} else {
+			s.handleRedirect(w, r)
+		}
+	})
+	s.mux.HandleFunc("/stats", s.management(s.handleStats))
+	s.mux.HandleFunc("/dashboard", s.management(s.handleDashboard))
+	s.mux.HandleFunc("/admin", s.management(s.handleAdmin))
+	s.mux.HandleFunc("/admin/moderation", s.management(s.handleModeration))
+	s.mux.HandleFunc("/admin/stale", s.management(s.handleStale))
+	s.mux.HandleFunc("/admin/duplicates", s.management(s.handleDuplicates))
+	s.mux.HandleFunc("/admin/takedowns", s.management(s.handleTakedowns))
+	s.mux.HandleFunc("/admin/teams", s.management(s.handleTeams))
+	s.mux.HandleFunc("/team", s.handleTeam)
+	s.mux.HandleFunc("/account", s.handleAccount)
+	s.mux.HandleFunc("/report/", s.handleReport)
+	s.mux.HandleFunc("/api/v1/stats/stream", s.management(s.handleStatsStream))
+	s.mux.HandleFunc("/api/v1/stats/heatmap", s.management(s.handleAPIHeatmap))
+	s.mux.HandleFunc("/api/v1/stats/rollups", s.management(s.handleAPIRollups))
+	s.mux.HandleFunc("/api/v1/stats/trending", s.management(s.handleAPITrending))
+	s.mux.HandleFunc("/api/v1/links", s.handleAPILinks)
+	s.mux.HandleFunc("/api/v1/links/", s.handleAPILink)
+	s.mux.HandleFunc("/api/v1/expand", s.handleAPIExpand)
+	s.mux.HandleFunc("/api/v1/expand/", s.handleAPIExpand)
+	s.mux.HandleFunc("/api/v1/alias/", s.handleAPIAlias)
+	s.mux.HandleFunc("/api/v1/usage", s.handleAPIUsage)
+	s.mux.HandleFunc("/api/v1/teams/", s.handleAPITeam)
+	s.mux.HandleFunc("/api/v1/account", s.handleAPIAccount)
+	s.mux.HandleFunc("/api/v1/account/export", s.handleAPIAccountExport)
+	s.mux.HandleFunc("/api/v1/admin/backup", s.management(s.handleAdminBackup))
+	s.mux.HandleFunc("/api/v1/admin/audit", s.management(s.handleAdminAudit))
+	s.mux.HandleFunc("/api/v1/admin/trash", s.management(s.handleAdminTrash))
+	s.mux.HandleFunc("/api/v1/admin/trash/", s.management(s.handleAdminTrash))
+	s.mux.HandleFunc("/api/v1/admin/anomalies", s.management(s.handleAdminAnomalies))
+	s.mux.HandleFunc("/api/v1/admin/search", s.management(s.handleAdminSearch))
+	s.mux.HandleFunc("/api/v1/admin/digest", s.management(s.handleAdminDigest))
+	s.mux.HandleFunc("/api/v1/admin/usage", s.management(s.handleAdminUsage))
+	s.mux.HandleFunc("/api/v1/openapi.json", handleOpenAPI)
+	s.mux.HandleFunc("/api/v1/docs", s.handleAPIDocs)
+	s.mux.HandleFunc("/api/integrations/slack", s.handleSlackCommand)
+	s.mux.HandleFunc("/api/", handleAPINotFound)
+	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(s.static))))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := requestHost(r)
+	if tenant, ok := s.tenants[host]; ok {
+		tenant.ServeHTTP(w, r)
+		return
+	}
+	if !s.allowedHosts.allows(host) {
+		httpError(w, "Unknown host", http.StatusMisdirectedRequest)
+		return
+	}
+	r = assignRequestID(w, r)
+	if s.handleCORS(w, r) {
+		return
+	}
+	if s.cfg.Server.Compress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding := acceptedEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+			cw := newCompressWriter(w, encoding)
+			defer cw.Close()
+			w = cw
+		}
+	}
+	if s.cfg.Ops.ErrorsPerMinute > 0 && s.opsEnabled() {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		s.handler.ServeHTTP(rec, r)
+		if rec.status >= http.StatusInternalServerError {
+			s.ops.countError()
+		}
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+// Start launches the background work enabled in the config: the job pool's
+// workers, the hot link cache, scheduled maintenance, backups, link health checks, trash and
+// session sweeps, analytics jobs, the analytics sink and event bus, alerts, and the
+// gRPC API.
+// Maintenance and backups need the SQLite store and are skipped for other
+// backends.
+func (s *Server) Start(ctx context.Context) error {
+	s.jobs.start()
+	if db, ok := s.sqliteDB(); ok {
+		s.startMaintenance(ctx, db)
+		s.startBackups(ctx, db)
+	}
+	s.startHotLinks(ctx)
+	s.startHealthChecks(ctx)
+	s.startTrashSweeper(ctx)
+	s.startSessionSweeper(ctx)
+	s.startAnomalyDetection(ctx)
+	s.startClickRollup(ctx)
+	s.startAnalyticsPurger(ctx)
+	s.startDigest(ctx)
+	s.startAlerts(ctx)
+	s.startOpsAlerts(ctx)
+	s.startSink(ctx)
+	s.startEvents(ctx)
+	return s.startGRPC()
+}
+
+// sqliteDB returns the database behind the built-in SQLite store, looking
+// through wrappers such as a read replica, Bloom filter or hot link cache.
+func (s *Server) sqliteDB() (*sql.DB, bool) {
+	st := s.store
+	for {
+		w, ok := st.(interface{ Unwrap() store.Store })
+		if !ok {
+			break
+		}
+		st = w.Unwrap()
+	}
+	sq, ok := st.(*store.SQLite)
+	if !ok {
+		return nil, false
+	}
+	return sq.DB(), true
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Browsers that use the create form without an account get a session
+// cookie, and the links made in that session, new or handed out again,
+// are remembered so the index page and GET /api/v1/links?mine=true can
+// list them. Accounts and API keys list the links they own instead. A
+// session's links are forgotten sessionMaxAge after they were made, when
+// the cookie would have expired anyway.
+
+const (
+	sessionCookieName = "shorty_session"
+	sessionIDBytes    = 16
+	sessionMaxAge     = 30 * 24 * time.Hour
+	// recentIndexLinks is how many recent links the index page shows.
+	recentIndexLinks     = 10
+	sessionSweepInterval = time.Hour
+)
+
+// requestSession returns the session r belongs to, if it has one.
+func requestSession(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || len(cookie.Value) != sessionIDBytes*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(cookie.Value); err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// sessionID returns the session r belongs to, or starts one with a fresh
+// cookie on the response.
+func sessionID(w http.ResponseWriter, r *http.Request) (string, error) {
+	if session, ok := requestSession(r); ok {
+		return session, nil
+	}
+	b := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	session := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return session, nil
+}
+
+// recordSessionLink remembers shortURL as the latest link of the session r
+// belongs to, starting one if needed. Requests from an account don't need
+// it, since their links have an owner. Failing only loses the link from the
+// session's list, so it is logged rather than failing the request.
+func (s *Server) recordSessionLink(ctx context.Context, w http.ResponseWriter, r *http.Request, shortURL string) {
+	if _, ok := s.accountName(r); ok {
+		return
+	}
+	session, err := sessionID(w, r)
+	if err != nil {
+		logf(ctx, "Error starting a session: %v", err)
+		return
+	}
+	if err := s.store.AddSessionLink(ctx, session, shortURL); err != nil {
+		logf(ctx, "Error recording short URL '%s' for the session: %v", shortURL, err)
+	}
+}
+
+// recentLinks returns up to limit links the account or session r comes
+// from made, latest first. ok is false when r has neither.
+func (s *Server) recentLinks(ctx context.Context, r *http.Request, limit int) (links []store.LinkStats, ok bool, err error) {
+	if name, ok := s.accountName(r); ok {
+		owned, err := s.store.OwnerLinks(ctx, name)
+		if err != nil {
+			return nil, true, err
+		}
+		for i := len(owned) - 1; i >= 0 && len(links) < limit; i-- {
+			links = append(links, owned[i])
+		}
+		return links, true, nil
+	}
+	if session, ok := requestSession(r); ok {
+		links, err := s.store.SessionLinks(ctx, session, limit)
+		return links, true, err
+	}
+	return nil, false, nil
+}
+
+// sweepSessions forgets the session links older than sessionMaxAge.
+func (s *Server) sweepSessions(ctx context.Context) {
+	purged, err := s.store.PurgeSessionLinks(ctx, time.Now().Add(-sessionMaxAge))
+	if err != nil {
+		log.Printf("Error purging old session links: %v", err)
+	} else if purged > 0 {
+		log.Printf("Purged %d session link(s)", purged)
+	}
+}
+
+// startSessionSweeper runs sweepSessions every sessionSweepInterval until
+// ctx is cancelled.
+func (s *Server) startSessionSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.lead(ctx, "sessions", sessionSweepInterval) {
+					s.sweepSessions(ctx)
+				}
+			}
+		}
+	}()
+}
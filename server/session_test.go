@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestSessionLinks(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+
+	create := func(longURL string, session *http.Cookie) *http.Cookie {
+		req := newCreateRequest(t, "url="+longURL)
+		if session != nil {
+			req.AddCookie(session)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Name == sessionCookieName {
+				session = cookie
+			}
+		}
+		return session
+	}
+	mine := func(session *http.Cookie, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/links"+query, nil)
+		if session != nil {
+			req.AddCookie(session)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	longURLs := func(rr *httptest.ResponseRecorder) []string {
+		var recent struct {
+			Links []store.LinkStats `json:"links"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&recent); err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, link := range recent.Links {
+			urls = append(urls, link.LongURL)
+		}
+		return urls
+	}
+
+	alice := create("https://example.com/a", nil)
+	if alice == nil || !alice.HttpOnly {
+		t.Fatalf("create form started no session: %+v", alice)
+	}
+	if again := create("https://example.com/b", alice); again.Value != alice.Value {
+		t.Error("create form started a new session for a browser that had one")
+	}
+
+	// Bob is handed the link Alice made, and it is his as well.
+	bob := create("https://example.com/a", nil)
+	if bob.Value == alice.Value {
+		t.Fatal("two browsers share a session")
+	}
+
+	if got := longURLs(mine(alice, "?mine=true")); strings.Join(got, ",") != "https://example.com/b,https://example.com/a" {
+		t.Errorf("alice's recent links are %v", got)
+	}
+	if got := longURLs(mine(bob, "")); strings.Join(got, ",") != "https://example.com/a" {
+		t.Errorf("bob's recent links are %v", got)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(alice)
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "your recent links") || !strings.Contains(body, "https://example.com/b") {
+		t.Error("index page does not list the session's links")
+	}
+
+	checkAPIError(t, mine(alice, "?mine=false"), http.StatusBadRequest, errCodeInvalidForm)
+	checkAPIError(t, mine(nil, "?mine=true"), http.StatusUnauthorized, errCodeUnauthorized)
+	checkAPIError(t, mine(&http.Cookie{Name: sessionCookieName, Value: "forged"}, ""), http.StatusUnauthorized, errCodeUnauthorized)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// A share link is a link stats page URL signed with share.secret and good
+// until it expires, so an owner can show a client a link's stats without
+// handing out the admin key. With share.privateStats set, link stats pages
+// need the admin key or a valid share link; otherwise they stay public and
+// share links are just a convenience.
+
+const maxShareTTL = 365 * 24 * time.Hour
+
+// ShareLink is a signed link stats page URL.
+type ShareLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// statsSignature signs shortURL's stats page until expires.
+func (s *Server) statsSignature(shortURL string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.Share.Secret))
+	mac.Write([]byte(shortURL + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shareLink returns a signed URL for shortURL's stats page that works
+// until now plus ttl.
+func (s *Server) shareLink(r *http.Request, shortURL string, ttl time.Duration) ShareLink {
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second).UTC()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("sig", s.statsSignature(shortURL, expiresAt.Unix()))
+	return ShareLink{
+		URL:       s.cfg.PublicURL(r) + "/_/" + shortURL + "/stats?" + query.Encode(),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// validShare reports whether r carries an unexpired signature for
+// shortURL's stats page.
+func (s *Server) validShare(r *http.Request, shortURL string) bool {
+	if s.cfg.Share.Secret == "" {
+		return false
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+	sig, err := hex.DecodeString(r.URL.Query().Get("sig"))
+	if err != nil {
+		return false
+	}
+	want, _ := hex.DecodeString(s.statsSignature(shortURL, expires))
+	return hmac.Equal(sig, want)
+}
+
+// authorizedLinkStats reports whether r may see shortURL's stats page.
+func (s *Server) authorizedLinkStats(r *http.Request, shortURL string) bool {
+	if !s.cfg.Share.PrivateStats {
+		return true
+	}
+	return s.authorizedDashboard(r) || s.keyScoped(r, scopeStats) || s.validShare(r, shortURL)
+}
+
+// handleAPIShare serves POST /api/v1/links/{shortURL}/share, which signs
+// a share link for the link's stats page. ?ttl= sets how long it works,
+// share.ttl by default.
+func (s *Server) handleAPIShare(w http.ResponseWriter, r *http.Request, shortURL string) {
+	logf(r.Context(), "Handling share request for short URL: '%s'", shortURL)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if s.cfg.Share.Secret == "" {
+		writeAPIError(w, http.StatusServiceUnavailable, errCodeShareNotConfigured, "Share links are not configured")
+		return
+	}
+
+	ttl := s.cfg.Share.TTL.Or(config.DefaultShareTTL)
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 || d > maxShareTTL {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "TTL must be a duration like \"72h\", up to a year")
+			return
+		}
+		ttl = d
+	}
+
+	if _, err := s.store.Link(r.Context(), shortURL); err == store.ErrNotFound {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL not found")
+		return
+	} else if err != nil {
+		logf(r.Context(), "Error fetching short URL '%s': %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error creating share link")
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.shareLink(r, shortURL, ttl))
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestShareLink(t *testing.T) {
+	srv, _ := newMemoryServer(t)
+	srv.cfg.Share.Secret = "secret"
+	srv.cfg.Server.PublicURL = "https://sho.rt"
+
+	link := srv.shareLink(httptest.NewRequest("POST", "/", nil), "abc123", time.Hour)
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "sho.rt" || u.Path != "/_/abc123/stats" {
+		t.Errorf("shareLink returned %v", link.URL)
+	}
+	if until := time.Until(link.ExpiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("shareLink expires at %v, want in an hour", link.ExpiresAt)
+	}
+
+	request := func(shortURL string, query url.Values) *http.Request {
+		return httptest.NewRequest("GET", "/_/"+shortURL+"/stats?"+query.Encode(), nil)
+	}
+	if !srv.validShare(request("abc123", u.Query()), "abc123") {
+		t.Error("validShare rejected a fresh share link")
+	}
+	if srv.validShare(request("def456", u.Query()), "def456") {
+		t.Error("validShare accepted a share link for another link")
+	}
+
+	tampered := u.Query()
+	tampered.Set("expires", "99999999999")
+	if srv.validShare(request("abc123", tampered), "abc123") {
+		t.Error("validShare accepted a share link with a changed expiry")
+	}
+
+	expired := srv.shareLink(httptest.NewRequest("POST", "/", nil), "abc123", -time.Minute)
+	eu, _ := url.Parse(expired.URL)
+	if srv.validShare(request("abc123", eu.Query()), "abc123") {
+		t.Error("validShare accepted an expired share link")
+	}
+
+	srv.cfg.Share.Secret = "rotated"
+	if srv.validShare(request("abc123", u.Query()), "abc123") {
+		t.Error("validShare accepted a share link signed with an old secret")
+	}
+}
+
+func TestPrivateLinkStats(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Share.Secret = "secret"
+	srv.cfg.Share.PrivateStats = true
+	srv.cfg.API.AdminKey = "admin"
+	if err := st.Create(context.Background(), "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/abc123/stats", nil))
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+
+	link := srv.shareLink(httptest.NewRequest("POST", "/", nil), "abc123", time.Hour)
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", link.URL, nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+}
+
+func TestHandleAPIShare(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "admin"
+	if err := st.Create(context.Background(), "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	share := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links/abc123/share", nil))
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Not Configured", func(t *testing.T) {
+		checkAPIError(t, share("/api/v1/links/abc123/share"), http.StatusServiceUnavailable, errCodeShareNotConfigured)
+	})
+
+	srv.cfg.Share.Secret = "secret"
+
+	t.Run("Bad TTL", func(t *testing.T) {
+		checkAPIError(t, share("/api/v1/links/abc123/share?ttl=forever"), http.StatusBadRequest, errCodeInvalidForm)
+	})
+
+	t.Run("Unknown Link", func(t *testing.T) {
+		checkAPIError(t, share("/api/v1/links/missing/share"), http.StatusNotFound, errCodeNotFound)
+	})
+
+	t.Run("Created", func(t *testing.T) {
+		rr := share("/api/v1/links/abc123/share?ttl=72h")
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		var link ShareLink
+		if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+			t.Fatal(err)
+		}
+		if until := time.Until(link.ExpiresAt); until < 71*time.Hour || until > 72*time.Hour {
+			t.Errorf("handler returned a link expiring at %v, want in 72 hours", link.ExpiresAt)
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With analytics.sink.url set, counted visits are also sent to ClickHouse,
+// so heavy analytics queries run there instead of on the database serving
+// redirects. Clicks are buffered and inserted in batches over ClickHouse's
+// HTTP interface as JSONEachRow, every analytics.sink.flushInterval or as
+// soon as analytics.sink.batchSize are waiting. While ClickHouse is down
+// the clicks are kept and retried, up to maxSinkBacklog batches, after
+// which the oldest are dropped. The sink works with or without
+// analytics.clickLog; turn the click log off to keep clicks out of the
+// serving database altogether.
+
+// maxSinkBacklog is how many batches are kept while the sink is down.
+const maxSinkBacklog = 100
+
+var sinkClient = &http.Client{Timeout: 30 * time.Second}
+
+// sinkTable matches a table name, optionally with its database.
+var sinkTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func validateSink(rawURL, table string) error {
+	if rawURL == "" {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("analytics.sink.url must be an http or https URL")
+	}
+	if table != "" && !sinkTable.MatchString(table) {
+		return fmt.Errorf("invalid analytics.sink.table %q", table)
+	}
+	return nil
+}
+
+// sinkRow is one click as inserted into ClickHouse.
+type sinkRow struct {
+	ShortURL  string `json:"short_url"`
+	At        string `json:"at"`
+	Referrer  string `json:"referrer"`
+	UserAgent string `json:"user_agent"`
+	IP        string `json:"ip"`
+	Country   string `json:"country"`
+	Bot       string `json:"bot"`
+}
+
+// clickSink buffers clicks for ClickHouse.
+type clickSink struct {
+	url       string
+	table     string
+	user      string
+	password  string
+	batchSize int
+
+	mu      sync.Mutex
+	pending []store.Click
+	full    chan struct{}
+}
+
+func newClickSink(cfg *config.Config) *clickSink {
+	if cfg.Analytics.Sink.URL == "" {
+		return nil
+	}
+	table := cfg.Analytics.Sink.Table
+	if table == "" {
+		table = config.DefaultSinkTable
+	}
+	batchSize := cfg.Analytics.Sink.BatchSize
+	if batchSize <= 0 {
+		batchSize = config.DefaultSinkBatchSize
+	}
+	return &clickSink{
+		url:       cfg.Analytics.Sink.URL,
+		table:     table,
+		user:      cfg.Analytics.Sink.User,
+		password:  cfg.Analytics.Sink.Password,
+		batchSize: batchSize,
+		full:      make(chan struct{}, 1),
+	}
+}
+
+// add queues click for the next flush.
+func (k *clickSink) add(click store.Click) {
+	k.mu.Lock()
+	k.pending = append(k.pending, click)
+	k.trim()
+	ready := len(k.pending) >= k.batchSize
+	k.mu.Unlock()
+
+	if ready {
+		select {
+		case k.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// trim drops the oldest clicks past the backlog. k.mu must be held.
+func (k *clickSink) trim() {
+	if over := len(k.pending) - maxSinkBacklog*k.batchSize; over > 0 {
+		k.pending = k.pending[over:]
+		log.Printf("Analytics sink backlog is full, dropped %d click(s)", over)
+	}
+}
+
+// flush inserts the queued clicks, a batch at a time. A batch that can't be
+// inserted goes back in the queue for the next flush.
+func (k *clickSink) flush(ctx context.Context) error {
+	for {
+		k.mu.Lock()
+		n := len(k.pending)
+		if n > k.batchSize {
+			n = k.batchSize
+		}
+		batch := append([]store.Click(nil), k.pending[:n]...)
+		k.pending = k.pending[n:]
+		k.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		if err := k.insert(ctx, batch); err != nil {
+			k.mu.Lock()
+			k.pending = append(batch, k.pending...)
+			k.trim()
+			k.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// insert posts clicks to ClickHouse in one INSERT.
+func (k *clickSink) insert(ctx context.Context, clicks []store.Click) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, c := range clicks {
+		row := sinkRow{
+			ShortURL:  c.ShortURL,
+			At:        c.At.UTC().Format("2006-01-02 15:04:05"),
+			Referrer:  c.Referrer,
+			UserAgent: c.UserAgent,
+			IP:        c.IP,
+			Country:   c.Country,
+			Bot:       c.Bot,
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	u, err := url.Parse(k.url)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("query", "INSERT INTO "+k.table+" FORMAT JSONEachRow")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if k.user != "" {
+		req.SetBasicAuth(k.user, k.password)
+	}
+
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("analytics sink returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// startSink flushes the analytics sink on the job pool every
+// analytics.sink.flushInterval, or sooner when a batch fills up, until ctx
+// is cancelled. What is queued then gets one last try.
+func (s *Server) startSink(ctx context.Context) {
+	if s.sink == nil {
+		return
+	}
+	log.Println("Sending clicks to the analytics sink")
+	go func() {
+		ticker := time.NewTicker(s.cfg.Analytics.Sink.FlushInterval.Or(config.DefaultSinkFlushInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				if err := s.sink.flush(ctx); err != nil {
+					log.Printf("Error flushing the analytics sink: %v", err)
+				}
+				cancel()
+				return
+			case <-ticker.C:
+			case <-s.sink.full:
+			}
+			// A batch that fails goes back in the queue for the next
+			// flush, so each flush is tried once.
+			err := s.jobs.run(ctx, &job{name: "analytics sink flush", attempts: 1, run: s.sink.flush})
+			if err != nil && err != ctx.Err() {
+				log.Printf("Error flushing the analytics sink: %v", err)
+			}
+		}
+	}()
+}
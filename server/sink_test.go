@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestValidateSink(t *testing.T) {
+	if err := validateSink("", "not a table"); err != nil {
+		t.Errorf("validateSink without a URL returned an error: %v", err)
+	}
+	if err := validateSink("http://clickhouse:8123", "analytics.clicks"); err != nil {
+		t.Errorf("validateSink returned an error: %v", err)
+	}
+	if err := validateSink("clickhouse:8123", ""); err == nil {
+		t.Error("validateSink accepted a URL without a scheme")
+	}
+	if err := validateSink("http://clickhouse:8123", "clicks; DROP TABLE clicks"); err == nil {
+		t.Error("validateSink accepted an invalid table name")
+	}
+}
+
+func TestClickSink(t *testing.T) {
+	var queries []string
+	var rows []sinkRow
+	fail := true
+	ch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "shorty" || password != "secret" {
+			t.Errorf("sink sent credentials %q, %q", user, password)
+		}
+		if fail {
+			http.Error(w, "Code: 241. DB::Exception: Memory limit exceeded", http.StatusInternalServerError)
+			return
+		}
+		queries = append(queries, r.URL.Query().Get("query"))
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row sinkRow
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Errorf("sink sent an invalid row %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+	}))
+	defer ch.Close()
+
+	cfg := &config.Config{}
+	cfg.Analytics.Sink.URL = ch.URL
+	cfg.Analytics.Sink.User = "shorty"
+	cfg.Analytics.Sink.Password = "secret"
+	cfg.Analytics.Sink.BatchSize = 2
+	sink := newClickSink(cfg)
+
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, code := range []string{"a", "b", "c"} {
+		sink.add(store.Click{ShortURL: code, Referrer: "https://example.com", Country: "NL", At: at})
+	}
+	select {
+	case <-sink.full:
+	default:
+		t.Error("sink didn't signal a full batch")
+	}
+
+	ctx := context.Background()
+	if err := sink.flush(ctx); err == nil {
+		t.Error("Expected an error from a failing sink, got nil")
+	}
+	if len(sink.pending) != 3 {
+		t.Errorf("sink kept %d clicks after a failed flush, want 3", len(sink.pending))
+	}
+
+	fail = false
+	if err := sink.flush(ctx); err != nil {
+		t.Fatalf("flush returned an error: %v", err)
+	}
+	if len(queries) != 2 || queries[0] != "INSERT INTO clicks FORMAT JSONEachRow" {
+		t.Errorf("sink ran %q, want two inserts into clicks", queries)
+	}
+	want := sinkRow{ShortURL: "a", At: "2024-03-01 12:30:00", Referrer: "https://example.com", Country: "NL"}
+	if len(rows) != 3 || rows[0] != want || rows[2].ShortURL != "c" {
+		t.Errorf("sink inserted %+v", rows)
+	}
+	if len(sink.pending) != 0 {
+		t.Errorf("sink kept %d clicks after flushing", len(sink.pending))
+	}
+}
+
+func TestClickSinkBacklog(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Analytics.Sink.URL = "http://clickhouse:8123"
+	cfg.Analytics.Sink.BatchSize = 1
+	sink := newClickSink(cfg)
+	for i := 0; i < maxSinkBacklog+5; i++ {
+		sink.add(store.Click{ShortURL: "a"})
+	}
+	if len(sink.pending) != maxSinkBacklog {
+		t.Errorf("sink kept %d clicks, want %d", len(sink.pending), maxSinkBacklog)
+	}
+}
+
+func TestRecordClickSinkOnly(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Analytics.Sink.URL = "http://clickhouse:8123"
+	srv.sink = newClickSink(srv.cfg)
+
+	// Without the click log, the store isn't touched.
+	srv.recordClick(httptest.NewRequest("GET", "/abc", nil), "abc", "")
+	if len(srv.sink.pending) != 1 || srv.sink.pending[0].ShortURL != "abc" {
+		t.Errorf("sink has %+v, want the click on abc", srv.sink.pending)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
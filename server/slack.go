@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Slack slash commands POST a form to /api/integrations/slack. Requests are
+// authenticated with Slack's signing secret, see
+// https://api.slack.com/authentication/verifying-requests-from-slack.
+
+// slackMaxSkew is how old a signed request may be before it is treated as a
+// replay.
+const slackMaxSkew = 5 * time.Minute
+
+// slackNow is overridden in tests.
+var slackNow = time.Now
+
+type slackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// verifySlackSignature checks the X-Slack-Signature header against body.
+func verifySlackSignature(r *http.Request, body []byte, secret string) bool {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := slackNow().Sub(time.Unix(ts, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// handleSlackCommand implements `/shorty <url> [alias]`. Problems with the
+// user's input are answered with an ephemeral message rather than an HTTP
+// error, since Slack only shows a generic failure for non-200 responses.
+func (s *Server) handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling Slack command")
+	secret := s.cfg.Integrations.Slack.SigningSecret
+	if secret == "" {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes()))
+	if err != nil {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, "Request body is too large")
+		return
+	}
+	if !verifySlackSignature(r, body, secret) {
+		logln(r.Context(), "Rejected Slack command with invalid signature")
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Invalid Slack signature")
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Invalid form body")
+		return
+	}
+
+	args := strings.Fields(form.Get("text"))
+	if len(args) == 0 || len(args) > 2 {
+		writeSlackReply(w, false, "Usage: "+form.Get("command")+" <url> [alias]")
+		return
+	}
+	longURL := args[0]
+	// Slack wraps URLs it recognises as <https://...> or <https://...|label>.
+	longURL = strings.TrimSuffix(strings.TrimPrefix(longURL, "<"), ">")
+	if i := strings.Index(longURL, "|"); i >= 0 {
+		longURL = longURL[:i]
+	}
+	if !validLongURL(longURL) || len(longURL) > 2048 {
+		writeSlackReply(w, false, "That doesn't look like a valid URL.")
+		return
+	}
+	alias := ""
+	if len(args) == 2 {
+		alias = args[1]
+	}
+
+	ctx := withSource(withActor(r.Context(), sourceSlack+" @"+form.Get("user_name")), sourceSlack)
+	shortURL, err := s.shortenURL(ctx, longURL, alias)
+	if err != nil {
+		if _, _, ok := aliasErrorStatus(err); ok {
+			writeSlackReply(w, false, "Can't use that alias: "+err.Error()+".")
+			return
+		}
+		if isRejected(err) {
+			writeSlackReply(w, false, "That link isn't allowed: "+err.Error()+".")
+			return
+		}
+		if err == errKeyspaceExhausted {
+			writeSlackReply(w, false, "Couldn't find a free short link, please try again shortly.")
+			return
+		}
+		logf(ctx, "Error creating short URL from Slack: %v", err)
+		writeSlackReply(w, false, "Sorry, something went wrong creating that link.")
+		return
+	}
+	logf(ctx, "Created short URL via Slack for user %s: %s", form.Get("user_id"), shortURL)
+
+	writeSlackReply(w, s.cfg.Integrations.Slack.InChannel, s.cfg.PublicURL(r)+"/_/"+shortURL)
+}
+
+func writeSlackReply(w http.ResponseWriter, inChannel bool, text string) {
+	responseType := "ephemeral"
+	if inChannel {
+		responseType = "in_channel"
+	}
+	writeJSON(w, http.StatusOK, slackResponse{ResponseType: responseType, Text: text})
+}
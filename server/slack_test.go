@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSlackRequest builds a slash command request signed with secret at ts.
+func newSlackRequest(text, secret string, ts time.Time) *http.Request {
+	body := url.Values{"command": {"/shorty"}, "text": {text}, "user_id": {"U123"}}.Encode()
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	req := httptest.NewRequest("POST", "/api/integrations/slack", strings.NewReader(body))
+	req.Host = "sho.rt"
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandleSlackCommand(t *testing.T) {
+	srv, st := newMemoryServer(t)
+
+	srv.cfg.Integrations.Slack.SigningSecret = "slack-secret"
+	now := time.Now()
+	if err := st.Create(context.Background(), "abc123", "https://example.com/page"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := func(t *testing.T, rr *httptest.ResponseRecorder) slackResponse {
+		t.Helper()
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var resp slackResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("Shorten", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleSlackCommand).ServeHTTP(rr, newSlackRequest("<https://example.com/page>", "slack-secret", now))
+
+		resp := reply(t, rr)
+		if resp.Text != "http://sho.rt/_/abc123" {
+			t.Errorf("handler returned wrong text: got %v want %v", resp.Text, "http://sho.rt/_/abc123")
+		}
+		if resp.ResponseType != "ephemeral" {
+			t.Errorf("handler returned wrong response type: got %v want %v", resp.ResponseType, "ephemeral")
+		}
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleSlackCommand).ServeHTTP(rr, newSlackRequest("not-a-url", "slack-secret", now))
+
+		if resp := reply(t, rr); !strings.Contains(resp.Text, "valid URL") {
+			t.Errorf("handler returned unexpected text: %v", resp.Text)
+		}
+	})
+
+	t.Run("Bad Signature", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleSlackCommand).ServeHTTP(rr, newSlackRequest("https://example.com", "wrong-secret", now))
+
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Stale Timestamp", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(srv.handleSlackCommand).ServeHTTP(rr, newSlackRequest("https://example.com", "slack-secret", now.Add(-10*time.Minute)))
+
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+}
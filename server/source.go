@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// Links come in through the web form, the JSON API, gRPC and Slack.
+// shortURL.sources can give the codes generated for each of them their own
+// prefix or charset, so machine-made links can be told apart from the ones
+// people made, and found again to expire them in bulk.
+
+// Sources a link can be created from, as used in shortURL.sources.
+const (
+	sourceWeb   = "web"
+	sourceAPI   = "api"
+	sourceGRPC  = "grpc"
+	sourceSlack = "slack"
+)
+
+type sourceKey struct{}
+
+// withSource records in ctx which source a link is being created from.
+func withSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// sourceCodes returns the settings for codes generated for the source
+// recorded in ctx.
+func (s *Server) sourceCodes(ctx context.Context) config.SourceCodes {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return s.cfg.ShortURL.Sources[source]
+}
+
+// validateSources checks shortURL.sources names known sources and that the
+// prefixes and charsets only use characters that are safe in a path.
+func validateSources(sources map[string]config.SourceCodes) error {
+	for source, codes := range sources {
+		switch source {
+		case sourceWeb, sourceAPI, sourceGRPC, sourceSlack:
+		default:
+			return fmt.Errorf("unknown source %q in shortURL.sources", source)
+		}
+		if codes.Prefix != "" && validateAlias(codes.Prefix) != nil {
+			return fmt.Errorf("shortURL.sources.%s.prefix: %v", source, errAliasInvalid)
+		}
+		charset := codes.Charset
+		if preset, ok := charsetPresets[charset]; ok {
+			charset = preset
+		}
+		for _, c := range charset {
+			if validateAlias(string(c)) != nil {
+				return fmt.Errorf("shortURL.sources.%s.charset may only contain letters, digits, '-' and '_'", source)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestGenerateShortURLSourcePrefix(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.ShortURL.Sources = map[string]config.SourceCodes{
+		sourceAPI: {Prefix: "api-", Charset: "x"},
+	}
+
+	ctx := withSource(context.Background(), sourceAPI)
+	shortURL, err := srv.generateShortURL(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if shortURL != "api-xxxxxx" {
+		t.Errorf("shortURL = %q, want %q", shortURL, "api-xxxxxx")
+	}
+	if longURL, err := st.LongURL(ctx, "api-xxxxxx"); err != nil || longURL != "https://example.com" {
+		t.Errorf("store has %q, %v for api-xxxxxx", longURL, err)
+	}
+
+	if got := srv.sourceCodes(withSource(context.Background(), sourceWeb)); got.Prefix != "" {
+		t.Errorf("web source got prefix %q, want none", got.Prefix)
+	}
+}
+
+func TestValidateSources(t *testing.T) {
+	valid := map[string]config.SourceCodes{
+		sourceAPI:   {Prefix: "api-"},
+		sourceSlack: {Charset: "spoken"},
+	}
+	if err := validateSources(valid); err != nil {
+		t.Errorf("validateSources returned %v", err)
+	}
+
+	tests := map[string]map[string]config.SourceCodes{
+		"Unknown source": {"email": {Prefix: "e-"}},
+		"Bad prefix":     {sourceAPI: {Prefix: "api/"}},
+		"Bad charset":    {sourceWeb: {Charset: "ab?"}},
+	}
+	for name, sources := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := validateSources(sources); err == nil || !strings.Contains(err.Error(), "shortURL.sources") {
+				t.Errorf("validateSources returned %v, want a shortURL.sources error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// /admin/stale lists the links nobody uses, to keep the database tidy:
+// links older than ?days= that were never clicked and, with the click log
+// on, those that weren't clicked in that time either. They can be expired
+// or deleted a few at a time or all at once. It logs in like the admin
+// page, and every change lands in the audit log.
+
+const (
+	defaultStaleDays = 90
+	maxStaleDays     = 3650
+)
+
+// staleLink is one row of stale.html.
+type staleLink struct {
+	store.LinkStats
+	// NeverClicked is set for links with no visits at all, rather than
+	// none in the report's window.
+	NeverClicked bool
+	Disabled     bool
+}
+
+// stalePage is the data for stale.html.
+type stalePage struct {
+	Links     []staleLink
+	Days      int
+	ClickLog  bool
+	Message   string
+	Error     string
+	CSRFToken string
+}
+
+// staleListURL returns the stale link report going back days, with a note
+// shown on top under the name in param, "message" or "error".
+func staleListURL(days int, param, note string) string {
+	v := url.Values{}
+	if days != defaultStaleDays {
+		v.Set("days", strconv.Itoa(days))
+	}
+	if note != "" {
+		v.Set(param, note)
+	}
+	if len(v) == 0 {
+		return "/admin/stale"
+	}
+	return "/admin/stale?" + v.Encode()
+}
+
+// parseStaleDays reads the report's window from value, falling back to
+// defaultStaleDays when it is empty or not a positive number.
+func parseStaleDays(value string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n <= 0 {
+		return defaultStaleDays
+	}
+	if n > maxStaleDays {
+		return maxStaleDays
+	}
+	return n
+}
+
+// staleLinks returns the live, unexpired links created more than days ago
+// that were never clicked or, with the click log on, had no clicks from
+// people in the last days, oldest first.
+func (s *Server) staleLinks(ctx context.Context, days int) ([]staleLink, error) {
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+	links, err := s.store.Links(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var clicked map[string]bool
+	if s.cfg.Analytics.ClickLog {
+		top, err := s.store.TopLinks(ctx, cutoff, -1)
+		if err != nil {
+			return nil, err
+		}
+		clicked = make(map[string]bool, len(top))
+		for _, link := range top {
+			clicked[link.ShortURL] = true
+		}
+	}
+
+	var stale []staleLink
+	for _, link := range links {
+		if !link.CreatedAt.Before(cutoff) {
+			continue
+		}
+		never := link.VisitCount == 0
+		if !never && (clicked == nil || clicked[link.ShortURL]) {
+			continue
+		}
+		opts, err := s.store.Options(ctx, link.ShortURL)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Expired(now) {
+			continue
+		}
+		stale = append(stale, staleLink{LinkStats: link, NeverClicked: never, Disabled: opts.Disabled})
+	}
+	return stale, nil
+}
+
+// expireLink makes shortURL expire at at and records it in the audit log.
+func (s *Server) expireLink(ctx context.Context, shortURL string, at time.Time) error {
+	before := s.auditSnapshot(ctx, shortURL)
+	opts, err := s.store.Options(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	at = at.UTC().Truncate(time.Second)
+	opts.ExpiresAt = &at
+	if err := s.store.SetOptions(ctx, shortURL, opts); err != nil {
+		return err
+	}
+	if before != nil {
+		s.audit(ctx, shortURL, store.AuditUpdate, before, s.auditSnapshot(ctx, shortURL))
+	}
+	return nil
+}
+
+func (s *Server) handleStale(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling stale links request")
+	if s.cfg.API.AdminKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showStale(w, r)
+	case http.MethodPost:
+		s.updateStale(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showStale(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	page := stalePage{
+		Days:     parseStaleDays(query.Get("days")),
+		ClickLog: s.cfg.Analytics.ClickLog,
+		Message:  query.Get("message"),
+		Error:    query.Get("error"),
+	}
+
+	var err error
+	if page.Links, err = s.staleLinks(ctx, page.Days); err != nil {
+		logf(ctx, "Error fetching stale links: %v", err)
+		httpError(w, "Error fetching links", http.StatusInternalServerError)
+		return
+	}
+
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("stale.html")
+	if err != nil {
+		logf(ctx, "Error loading stale links template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing stale links template: %v", err)
+	}
+}
+
+// updateStale expires or deletes the selected stale links, or all of them
+// when the form says so, then sends the browser back to the report.
+func (s *Server) updateStale(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected stale links request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, "admin"))
+	days := parseStaleDays(r.PostFormValue("days"))
+	back := func(message string) {
+		http.Redirect(w, r, staleListURL(days, "message", message), http.StatusSeeOther)
+	}
+	fail := func(message string) {
+		http.Redirect(w, r, staleListURL(days, "error", message), http.StatusSeeOther)
+	}
+
+	codes := r.PostForm["code"]
+	if r.PostFormValue("all") == "true" {
+		// The list is worked out again rather than trusting the form, so
+		// a link clicked since the page was loaded is spared.
+		stale, err := s.staleLinks(ctx, days)
+		if err != nil {
+			logf(ctx, "Error fetching stale links: %v", err)
+			fail("Error fetching links")
+			return
+		}
+		codes = nil
+		for _, link := range stale {
+			codes = append(codes, link.ShortURL)
+		}
+	}
+	if len(codes) == 0 {
+		fail("No links selected")
+		return
+	}
+
+	switch r.PostFormValue("action") {
+	case "expire":
+		n, err := s.eachAdminLink(ctx, codes, func(shortURL string) error {
+			return s.expireLink(ctx, shortURL, time.Now())
+		})
+		if err != nil {
+			fail("Failed to expire some links")
+			return
+		}
+		back(fmt.Sprintf("Expired %d link(s)", n))
+
+	case "delete":
+		n, err := s.eachAdminLink(ctx, codes, func(shortURL string) error {
+			deleted, err := s.deleteLink(ctx, shortURL, false)
+			if err == nil && !deleted {
+				return store.ErrNotFound
+			}
+			return err
+		})
+		if err != nil {
+			fail("Failed to delete some links")
+			return
+		}
+		back(fmt.Sprintf("Deleted %d link(s)", n))
+
+	default:
+		fail("Unknown action")
+	}
+}
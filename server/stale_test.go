@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// postStale posts form to /admin/stale as a logged in admin with a valid
+// CSRF token.
+func postStale(srv *Server, form url.Values) *httptest.ResponseRecorder {
+	token := strings.Repeat("ab", csrfTokenBytes)
+	form.Set(csrfFieldName, token)
+	req := httptest.NewRequest("POST", "/admin/stale", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	req.SetBasicAuth("admin", "secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestStaleLinks(t *testing.T) {
+	// Links can only be backdated in a real database.
+	cfg := &config.Config{}
+	cfg.Database.Name = filepath.Join(t.TempDir(), "stale.db")
+	cfg.API.AdminKey = "secret"
+	st, err := store.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	srv, err := New(cfg, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Everything but fresh was made a year ago. unused was never clicked,
+	// dormant last got a click months ago and busy got one yesterday.
+	for _, shortURL := range []string{"unused", "dormant", "busy", "fresh"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db, err := sql.Open("sqlite3", cfg.Database.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	old := time.Now().UTC().AddDate(-1, 0, 0).Format("2006-01-02 15:04:05")
+	if _, err := db.Exec("UPDATE url_mapping SET created_at = ? WHERE short_url != 'fresh'", old); err != nil {
+		t.Fatal(err)
+	}
+	for shortURL, at := range map[string]time.Time{"dormant": time.Now().AddDate(0, -6, 0), "busy": time.Now().AddDate(0, 0, -1)} {
+		if _, err := st.RecordVisit(ctx, shortURL); err != nil {
+			t.Fatal(err)
+		}
+		if err := st.RecordClick(ctx, store.Click{ShortURL: shortURL, At: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	codes := func() string {
+		stale, err := srv.staleLinks(ctx, defaultStaleDays)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, link := range stale {
+			got = append(got, link.ShortURL)
+		}
+		return strings.Join(got, ",")
+	}
+
+	if got := codes(); got != "unused" {
+		t.Errorf("stale links without the click log are %s, want unused", got)
+	}
+	srv.cfg.Analytics.ClickLog = true
+	if got := codes(); got != "unused,dormant" {
+		t.Errorf("stale links with the click log are %s, want unused,dormant", got)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/stale", nil)
+	req.SetBasicAuth("admin", "secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	if body := rr.Body.String(); !strings.Contains(body, "https://example.com/dormant") || strings.Contains(body, "https://example.com/busy") {
+		t.Errorf("handler listed the wrong links: %s", body)
+	}
+
+	rr = postStale(srv, url.Values{"action": {"expire"}, "code": {"dormant"}})
+	if status := rr.Code; status != http.StatusSeeOther {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+	}
+	if opts, err := st.Options(ctx, "dormant"); err != nil || !opts.Expired(time.Now()) {
+		t.Errorf("Options after expiring returned %+v, %v", opts, err)
+	}
+	if got := codes(); got != "unused" {
+		t.Errorf("stale links after expiring are %s, want unused", got)
+	}
+
+	postStale(srv, url.Values{"action": {"delete"}, "all": {"true"}})
+	if _, err := st.Link(ctx, "unused"); err != store.ErrNotFound {
+		t.Errorf("Link after deleting all returned %v, want ErrNotFound", err)
+	}
+	if count, err := st.Count(ctx); err != nil || count != 3 {
+		t.Errorf("Count after deleting all returned %v, %v want 3", count, err)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With cache.statsTTL set, the figures on the stats page and dashboard are
+// kept for that long, so a busy stats page costs a few queries per TTL
+// rather than per view. The figures can be up to a TTL out of date.
+
+// statsCache holds the last figures read from the store.
+type statsCache struct {
+	// mu is held while the figures are read, so when they expire one
+	// request reads them again and the rest wait for its result.
+	mu      sync.Mutex
+	stats   store.Stats
+	expires time.Time
+}
+
+// stats returns the stats page figures, from the cache while they are
+// fresh.
+func (s *Server) stats(ctx context.Context) (store.Stats, error) {
+	ttl := s.cfg.Cache.StatsTTL.Duration
+	if ttl <= 0 {
+		return s.store.Stats(ctx)
+	}
+
+	c := &s.statsCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Before(c.expires) {
+		return c.stats, nil
+	}
+	stats, err := s.store.Stats(ctx)
+	if err != nil {
+		return stats, err
+	}
+	c.stats, c.expires = stats, now.Add(ttl)
+	return stats, nil
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStatsCache(t *testing.T) {
+	srv, mock := newMockServer(t)
+	srv.cfg.Cache.StatsTTL.Duration = time.Minute
+	ctx := context.Background()
+
+	// Only the first call reads the store.
+	expectStats(mock)
+	for i := 0; i < 3; i++ {
+		stats, err := srv.stats(ctx)
+		if err != nil || stats.TotalLinks != 10 {
+			t.Fatalf("stats returned %+v, %v", stats, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// Once they expire, the figures are read again.
+	srv.statsCache.expires = time.Now().Add(-time.Second)
+	expectStats(mock)
+	if _, err := srv.stats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// /api/v1/stats/stream pushes clicks and new links to whoever is watching
+// as Server-Sent Events, so the stats page can count along live. Nothing is
+// looked up or sent while nobody is subscribed.
+
+// streamBuffer is how many events a slow subscriber may fall behind before
+// new ones are dropped for it.
+const streamBuffer = 64
+
+// streamKeepAlive is how often an idle stream gets a comment, so proxies
+// don't close it.
+const streamKeepAlive = 15 * time.Second
+
+type streamEvent struct {
+	// Type is the SSE event name: "click" or "link".
+	Type       string    `json:"-"`
+	ShortURL   string    `json:"shortURL"`
+	LongURL    string    `json:"longURL,omitempty"`
+	VisitCount int       `json:"visitCount,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// statsStream fans events out to the open streams.
+type statsStream struct {
+	mu   sync.Mutex
+	subs map[chan streamEvent]struct{}
+}
+
+func (st *statsStream) subscribe() (<-chan streamEvent, func()) {
+	ch := make(chan streamEvent, streamBuffer)
+	st.mu.Lock()
+	if st.subs == nil {
+		st.subs = make(map[chan streamEvent]struct{})
+	}
+	st.subs[ch] = struct{}{}
+	st.mu.Unlock()
+	return ch, func() {
+		st.mu.Lock()
+		delete(st.subs, ch)
+		st.mu.Unlock()
+	}
+}
+
+// watched reports whether any stream is open.
+func (st *statsStream) watched() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.subs) > 0
+}
+
+// publish sends ev to every open stream, skipping those that are full.
+func (st *statsStream) publish(ev streamEvent) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for ch := range st.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// publishClick tells the open streams shortURL was visited, with its new
+// visit count.
+func (s *Server) publishClick(ctx context.Context, shortURL string) {
+	if !s.stream.watched() {
+		return
+	}
+	link, err := s.store.Link(ctx, shortURL)
+	if err != nil {
+		logf(ctx, "Error fetching visit count of '%s' for the stats stream: %v", shortURL, err)
+		return
+	}
+	s.stream.publish(streamEvent{Type: "click", ShortURL: shortURL, VisitCount: link.VisitCount, At: time.Now().UTC()})
+}
+
+// publishLink tells the open streams a link was created.
+func (s *Server) publishLink(shortURL, longURL string) {
+	if !s.stream.watched() {
+		return
+	}
+	s.stream.publish(streamEvent{Type: "link", ShortURL: shortURL, LongURL: longURL, At: time.Now().UTC()})
+}
+
+// handleStatsStream serves GET /api/v1/stats/stream.
+func (s *Server) handleStatsStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, errCodeNotSupported, "Streaming is not supported")
+		return
+	}
+	// The stream stays open far longer than server.writeTimeout allows.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+		logf(r.Context(), "Error clearing write deadline for the stats stream: %v", err)
+	}
+
+	events, unsubscribe := s.stream.subscribe()
+	defer unsubscribe()
+	logln(r.Context(), "Opened stats stream")
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logln(r.Context(), "Closed stats stream")
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				logf(r.Context(), "Error encoding stats stream event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStatsStream(t *testing.T) {
+	srv, mock := newMockServer(t)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/stats/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != ": connected" {
+		t.Fatalf("stream opened with %q, want a connected comment", lines.Text())
+	}
+
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at").
+		WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("abc123", "https://example.com", 7, "2024-01-01 00:00:00"))
+	srv.publishClick(context.Background(), "abc123")
+	srv.publishLink("def456", "https://example.org")
+
+	want := []string{
+		"event: click",
+		`data: {"shortURL":"abc123","visitCount":7,"at":`,
+		"",
+		"event: link",
+		`data: {"shortURL":"def456","longURL":"https://example.org","at":`,
+	}
+	lines.Scan() // blank line after the connected comment
+	for _, prefix := range want {
+		if !lines.Scan() {
+			t.Fatalf("stream ended early: %v", lines.Err())
+		}
+		if !strings.HasPrefix(lines.Text(), prefix) {
+			t.Errorf("stream line = %q, want prefix %q", lines.Text(), prefix)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestStatsStreamUnwatched(t *testing.T) {
+	srv, mock := newMockServer(t)
+
+	// No stream is open, so the click isn't looked up.
+	srv.publishClick(context.Background(), "abc123")
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unfulfilled expectations: %s", err)
+	}
+}
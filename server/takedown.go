@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Admins record takedown notices, say from an abuse desk or a rights
+// holder, at /admin/takedowns. A notice names one short URL or a whole
+// domain, and every link it covers is disabled. Visitors to a taken down
+// link get removed.html, which themes can override, instead of a redirect.
+// Switching the link back on lifts the takedown.
+
+// maxTakedownField caps the requester, reference and reason of a notice.
+const maxTakedownField = 1000
+
+// takedownsPage is the data for takedowns.html.
+type takedownsPage struct {
+	Takedowns []store.Takedown
+	Message   string
+	Error     string
+	CSRFToken string
+}
+
+// removedPage is the data for removed.html.
+type removedPage struct {
+	ShortURL string
+}
+
+// takeDown records takedown and disables every link it covers, taking
+// them out of the moderation queue. It returns how many links it took
+// down, or store.ErrNotFound if a notice against one link names a link
+// that doesn't exist.
+func (s *Server) takeDown(ctx context.Context, takedown store.Takedown) (int, error) {
+	var targets []string
+	switch takedown.Kind {
+	case store.TakedownLink:
+		exists, err := s.store.Exists(ctx, takedown.Target)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, store.ErrNotFound
+		}
+		targets = []string{takedown.Target}
+	case store.TakedownDomain:
+		links, err := s.store.Links(ctx)
+		if err != nil {
+			return 0, err
+		}
+		for _, link := range links {
+			if inDomain(linkHost(link.LongURL), takedown.Target) {
+				targets = append(targets, link.ShortURL)
+			}
+		}
+	default:
+		return 0, fmt.Errorf("unknown takedown kind %q", takedown.Kind)
+	}
+
+	id, err := s.store.CreateTakedown(ctx, takedown)
+	if err != nil {
+		return 0, err
+	}
+	logf(ctx, "Recorded takedown notice %d against %s %s", id, takedown.Kind, takedown.Target)
+
+	var n int
+	for _, shortURL := range targets {
+		before := s.auditSnapshot(ctx, shortURL)
+		if err := s.store.TakeDown(ctx, shortURL, id); err != nil {
+			return n, err
+		}
+		if _, err := s.store.ClearFlag(ctx, shortURL); err != nil {
+			return n, err
+		}
+		s.reports.forget(shortURL)
+		if before != nil {
+			s.audit(ctx, shortURL, store.AuditTakedown, before, s.auditSnapshot(ctx, shortURL))
+		}
+		n++
+	}
+	return n, nil
+}
+
+// linkRemoved answers a visit to a link taken down under a notice.
+func (s *Server) linkRemoved(w http.ResponseWriter, shortURL string) {
+	tmpl, err := s.templates.lookup("removed.html")
+	if err != nil {
+		log.Printf("Error loading removed template: %v", err)
+		httpError(w, "This link has been removed", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusGone)
+	if err := tmpl.Execute(w, removedPage{ShortURL: shortURL}); err != nil {
+		log.Printf("Error executing removed template: %v", err)
+	}
+}
+
+func (s *Server) handleTakedowns(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling takedowns request")
+	if s.cfg.API.AdminKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showTakedowns(w, r)
+	case http.MethodPost:
+		s.recordTakedown(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showTakedowns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := takedownsPage{Message: r.URL.Query().Get("message"), Error: r.URL.Query().Get("error")}
+
+	var err error
+	if page.Takedowns, err = s.store.Takedowns(ctx); err != nil {
+		logf(ctx, "Error fetching takedown notices: %v", err)
+		httpError(w, "Error fetching takedown notices", http.StatusInternalServerError)
+		return
+	}
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("takedowns.html")
+	if err != nil {
+		logf(ctx, "Error loading takedowns template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing takedowns template: %v", err)
+	}
+}
+
+// recordTakedown records the notice posted from takedowns.html, then sends
+// the browser back to the list.
+func (s *Server) recordTakedown(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected takedown request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ctx := withActor(r.Context(), s.requestActor(r, "admin"))
+	back := func(param, note string) {
+		http.Redirect(w, r, "/admin/takedowns?"+url.Values{param: {note}}.Encode(), http.StatusSeeOther)
+	}
+
+	takedown := store.Takedown{
+		Kind:      r.PostFormValue("kind"),
+		Target:    strings.TrimSpace(r.PostFormValue("target")),
+		Requester: strings.TrimSpace(r.PostFormValue("requester")),
+		Reference: strings.TrimSpace(r.PostFormValue("reference")),
+		Reason:    strings.TrimSpace(r.PostFormValue("reason")),
+		CreatedAt: time.Now().UTC(),
+	}
+	switch takedown.Kind {
+	case store.TakedownLink:
+		// Accept a full short link as well as its code.
+		takedown.Target = takedown.Target[strings.LastIndex(takedown.Target, "/")+1:]
+		if takedown.Target == "" {
+			back("error", "Enter the short URL to take down")
+			return
+		}
+	case store.TakedownDomain:
+		takedown.Target = normalizeDomain(takedown.Target)
+		if takedown.Target == "" || strings.ContainsAny(takedown.Target, "/ ") {
+			back("error", "Invalid domain")
+			return
+		}
+	default:
+		back("error", "Unknown takedown kind")
+		return
+	}
+	if len(takedown.Requester) > maxTakedownField || len(takedown.Reference) > maxTakedownField || len(takedown.Reason) > maxTakedownField {
+		back("error", fmt.Sprintf("Requester, reference and reason may be at most %d characters", maxTakedownField))
+		return
+	}
+
+	n, err := s.takeDown(ctx, takedown)
+	if err == store.ErrNotFound {
+		back("error", "No such short URL: "+takedown.Target)
+		return
+	}
+	if err != nil {
+		logf(ctx, "Error recording takedown of %s %s: %v", takedown.Kind, takedown.Target, err)
+		back("error", "Failed to record the takedown of "+takedown.Target)
+		return
+	}
+	back("message", fmt.Sprintf("Recorded the takedown of %s and disabled %d link(s)", takedown.Target, n))
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// postTakedown posts form to /admin/takedowns as a logged in admin with a
+// valid CSRF token.
+func postTakedown(srv *Server, form url.Values) *httptest.ResponseRecorder {
+	token := strings.Repeat("ab", csrfTokenBytes)
+	form.Set(csrfFieldName, token)
+	req := httptest.NewRequest("POST", "/admin/takedowns", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	req.SetBasicAuth("admin", "secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleTakedowns(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	for shortURL, longURL := range map[string]string{
+		"abc123": "https://example.com/a",
+		"def456": "https://cdn.bad.example/b",
+		"ghi789": "https://bad.example/c",
+	} {
+		if err := st.Create(ctx, shortURL, longURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Off Without Admin Key", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/takedowns", nil))
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+		}
+	})
+
+	srv.cfg.API.AdminKey = "secret"
+
+	t.Run("Link", func(t *testing.T) {
+		rr := postTakedown(srv, url.Values{
+			"kind":      {"link"},
+			"target":    {"https://sho.rt/_/abc123"},
+			"requester": {"abuse@example.net"},
+			"reference": {"T-1"},
+			"reason":    {"phishing"},
+		})
+		if status := rr.Code; status != http.StatusSeeOther {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+		}
+		if opts, err := st.Options(ctx, "abc123"); err != nil || !opts.TakenDown || !opts.Disabled {
+			t.Errorf("Options after a takedown returned %+v, %v", opts, err)
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/abc123", nil))
+		if status := rr.Code; status != http.StatusGone {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusGone)
+		}
+		if !strings.Contains(rr.Body.String(), "removed following an abuse report") {
+			t.Errorf("handler did not serve the removal notice: %s", rr.Body)
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/expand/abc123", nil))
+		var result ExpandResult
+		if err := json.NewDecoder(rr.Body).Decode(&result); err != nil || result.Status != LinkStatusRemoved {
+			t.Errorf("expand returned %+v, %v want status %s", result, err, LinkStatusRemoved)
+		}
+	})
+
+	t.Run("Missing Link", func(t *testing.T) {
+		rr := postTakedown(srv, url.Values{"kind": {"link"}, "target": {"missing"}})
+		if location := rr.Header().Get("Location"); !strings.Contains(location, "error=") {
+			t.Errorf("handler redirected to %q, want an error", location)
+		}
+	})
+
+	t.Run("Domain", func(t *testing.T) {
+		postTakedown(srv, url.Values{"kind": {"domain"}, "target": {"BAD.example"}})
+		for _, shortURL := range []string{"def456", "ghi789"} {
+			if opts, err := st.Options(ctx, shortURL); err != nil || !opts.TakenDown {
+				t.Errorf("Options of %s after a domain takedown returned %+v, %v", shortURL, opts, err)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/takedowns", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		body := rr.Body.String()
+		for _, want := range []string{"abuse@example.net", "T-1", "domain bad.example", "def456"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("handler returned a list without %q: %s", want, body)
+			}
+		}
+	})
+
+	t.Run("Re-enable Lifts Takedown", func(t *testing.T) {
+		postAdmin(srv, url.Values{"action": {"enable"}, "code": {"abc123"}})
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/abc123", nil))
+		if status := rr.Code; status != http.StatusFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusFound)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// platformFor guesses the visitor's platform from its User-Agent. It
+// returns "" for desktops and anything it doesn't recognise, which get the
+// link's long URL.
+func platformFor(userAgent string) string {
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"), strings.Contains(userAgent, "iPod"):
+		return store.PlatformIOS
+	case strings.Contains(userAgent, "Android"):
+		return store.PlatformAndroid
+	}
+	return ""
+}
+
+// validateTargets checks each target the way long URLs are checked. Custom
+// schemes such as myapp://item/1 are accepted so targets can be app deep
+// links.
+func validateTargets(targets store.Targets) error {
+	checks := []struct{ name, target string }{
+		{"iOS", targets.IOS},
+		{"Android", targets.Android},
+	}
+	for _, c := range checks {
+		if c.target == "" {
+			continue
+		}
+		if _, err := url.ParseRequestURI(c.target); err != nil {
+			return fmt.Errorf("invalid %s target URL", c.name)
+		}
+		if len(c.target) > 2048 {
+			return fmt.Errorf("%s target URL is too long", c.name)
+		}
+	}
+	return nil
+}
+
+// destinationFor returns where a visitor to shortURL should be sent: the
+// target for their platform when the link has one, else longURL. Links are
+// only checked for targets when the visitor is on a platform that can have
+// one, so desktop redirects cost no extra query.
+func (s *Server) destinationFor(r *http.Request, shortURL, longURL string) string {
+	platform := platformFor(r.UserAgent())
+	if platform == "" {
+		return longURL
+	}
+	targets, err := s.store.Targets(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching targets for short URL '%s': %v", shortURL, err)
+		return longURL
+	}
+	if target := targets.For(platform); target != "" {
+		logf(r.Context(), "Using %s target for '%s': '%s'", platform, shortURL, target)
+		return target
+	}
+	return longURL
+}
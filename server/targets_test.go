@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestPlatformFor(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15": store.PlatformIOS,
+		"Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15":          store.PlatformIOS,
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36":                 store.PlatformAndroid,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36":                "",
+		"": "",
+	}
+	for userAgent, want := range tests {
+		if got := platformFor(userAgent); got != want {
+			t.Errorf("platformFor(%q) returned wrong platform: got %q want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	if err := validateTargets(store.Targets{IOS: "https://apps.apple.com/app/id1", Android: "myapp://item/1"}); err != nil {
+		t.Errorf("validateTargets returned an error: %v", err)
+	}
+	if err := validateTargets(store.Targets{Android: "not a url"}); err == nil {
+		t.Error("Expected an error for an invalid target, got nil")
+	}
+}
+
+func TestRedirectTargets(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "app", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetTargets(ctx, "app", store.Targets{IOS: "https://apps.apple.com/app/id1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	visit := func(t *testing.T, userAgent string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/_/app", nil)
+		req.Header.Set("User-Agent", userAgent)
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr.Header().Get("Location")
+	}
+
+	if got := visit(t, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"); got != "https://apps.apple.com/app/id1" {
+		t.Errorf("iOS visitor got wrong destination: got %v want %v", got, "https://apps.apple.com/app/id1")
+	}
+	if got := visit(t, "Mozilla/5.0 (Linux; Android 14; Pixel 8)"); got != "https://example.com" {
+		t.Errorf("Android visitor without a target got wrong destination: got %v want %v", got, "https://example.com")
+	}
+	if got := visit(t, ""); got != "https://example.com" {
+		t.Errorf("Desktop visitor got wrong destination: got %v want %v", got, "https://example.com")
+	}
+	if link, err := st.Link(ctx, "app"); err != nil || link.VisitCount != 3 {
+		t.Errorf("link has %d visits, %v want 3", link.VisitCount, err)
+	}
+}
+
+func TestHandleAPILinksTargets(t *testing.T) {
+	srv, st := newMemoryServer(t)
+
+	body := `{"url": "https://example.com", "targets": {"ios": "https://apps.apple.com/app/id1"}}`
+	req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	var link linkResponse
+	if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+		t.Fatal(err)
+	}
+	if link.Targets == nil || link.Targets.IOS != "https://apps.apple.com/app/id1" {
+		t.Errorf("handler returned wrong targets: %+v", link.Targets)
+	}
+	if targets, err := st.Targets(context.Background(), link.ShortURL); err != nil || targets.IOS != "https://apps.apple.com/app/id1" {
+		t.Errorf("store has targets %+v, %v", targets, err)
+	}
+}
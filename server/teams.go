@@ -0,0 +1,404 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Teams own links together, so a department doesn't have to share one
+// login. Admins set teams up at /admin/teams and put basicAuth users and
+// API keys in them by name. A link created by a member, through the web
+// form or the JSON API, belongs to the member's team, and every member
+// sees the team's links and their totals at /team or through
+// /api/v1/teams/{name}. A member is in at most one team.
+
+// topTeamLinks is how many of its most visited links a team's stats list.
+const topTeamLinks = 10
+
+// TeamStats is what a team's links add up to.
+type TeamStats struct {
+	Team       string   `json:"team"`
+	Members    []string `json:"members"`
+	LinkCount  int      `json:"linkCount"`
+	VisitCount int      `json:"visitCount"`
+	// TopLinks are the most visited links, most visited first, and Links
+	// all of them, newest first.
+	TopLinks []store.LinkStats `json:"topLinks"`
+	Links    []store.LinkStats `json:"links"`
+}
+
+// teamPage is the data for team.html.
+type teamPage struct {
+	Member string
+	TeamStats
+}
+
+// teamsPage is the data for teams.html.
+type teamsPage struct {
+	Teams     []TeamStats
+	Message   string
+	Error     string
+	CSRFToken string
+}
+
+// validateTeamMembers checks no API key is named like a basicAuth user,
+// since team membership goes by name.
+func validateTeamMembers(users map[string]string, keys []config.APIKey) error {
+	for _, key := range keys {
+		if _, ok := users[key.Name]; ok {
+			return fmt.Errorf("api key %q is named like a basicAuth user", key.Name)
+		}
+	}
+	return nil
+}
+
+// accountName returns the account r comes from, which is also the name it
+// is a team member under: its API key's or its basicAuth user's.
+func (s *Server) accountName(r *http.Request) (string, bool) {
+	if key, ok := s.apiKeyFor(r); ok {
+		return key.Name, true
+	}
+	return s.basicAuthUser(r)
+}
+
+// knownMember reports whether name is a basicAuth user or an API key.
+func (s *Server) knownMember(name string) bool {
+	if _, ok := s.cfg.BasicAuth.Users[name]; ok {
+		return true
+	}
+	_, ok := s.apiKeyNamed(name)
+	return ok
+}
+
+// teamOf returns the team member is in.
+func (s *Server) teamOf(ctx context.Context, member string) (store.Team, bool, error) {
+	teams, err := s.store.Teams(ctx)
+	if err != nil {
+		return store.Team{}, false, err
+	}
+	for _, team := range teams {
+		for _, m := range team.Members {
+			if m == member {
+				return team, true, nil
+			}
+		}
+	}
+	return store.Team{}, false, nil
+}
+
+type teamKey struct{}
+
+// withTeam records in ctx the team a link is being created for.
+func withTeam(ctx context.Context, team string) context.Context {
+	return context.WithValue(ctx, teamKey{}, team)
+}
+
+// teamFrom returns the team recorded in ctx, if any.
+func teamFrom(ctx context.Context) string {
+	team, _ := ctx.Value(teamKey{}).(string)
+	return team
+}
+
+// withMemberTeam records in ctx the team of the member r comes from, so
+// the link it creates belongs to the team. A failed lookup is logged and
+// the link made without a team.
+func (s *Server) withMemberTeam(ctx context.Context, r *http.Request) context.Context {
+	member, ok := s.accountName(r)
+	if !ok {
+		return ctx
+	}
+	team, ok, err := s.teamOf(ctx, member)
+	if err != nil {
+		logf(ctx, "Error looking up the team of %s: %v", member, err)
+		return ctx
+	}
+	if !ok {
+		return ctx
+	}
+	return withTeam(ctx, team.Name)
+}
+
+// attributed reports whether a link created with ctx belongs to an API
+// key, an account or a team, and so needs a code of its own.
+func attributed(ctx context.Context) bool {
+	return apiKeyFrom(ctx) != "" || ownerFrom(ctx) != "" || teamFrom(ctx) != ""
+}
+
+// teamStats adds up the links team owns.
+func (s *Server) teamStats(ctx context.Context, team store.Team) (TeamStats, error) {
+	links, err := s.store.TeamLinks(ctx, team.Name)
+	if err != nil {
+		return TeamStats{}, err
+	}
+	stats := TeamStats{
+		Team:      team.Name,
+		Members:   team.Members,
+		LinkCount: len(links),
+		TopLinks:  []store.LinkStats{},
+		Links:     []store.LinkStats{},
+	}
+	if stats.Members == nil {
+		stats.Members = []string{}
+	}
+	for i := len(links) - 1; i >= 0; i-- {
+		stats.VisitCount += links[i].VisitCount
+		stats.Links = append(stats.Links, links[i])
+	}
+	top := append([]store.LinkStats(nil), links...)
+	sort.SliceStable(top, func(i, j int) bool { return top[i].VisitCount > top[j].VisitCount })
+	if len(top) > topTeamLinks {
+		top = top[:topTeamLinks]
+	}
+	stats.TopLinks = append(stats.TopLinks, top...)
+	return stats, nil
+}
+
+// handleTeam shows the team of the basicAuth user logged in.
+func (s *Server) handleTeam(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling team request")
+	user, ok := s.basicAuthUser(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty team"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	team, ok, err := s.teamOf(ctx, user)
+	if err != nil {
+		logf(ctx, "Error looking up the team of %s: %v", user, err)
+		httpError(w, "Error fetching team", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		httpError(w, "You are not in a team", http.StatusForbidden)
+		return
+	}
+	stats, err := s.teamStats(ctx, team)
+	if err != nil {
+		logf(ctx, "Error fetching the links of team %s: %v", team.Name, err)
+		httpError(w, "Error fetching team", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("team.html")
+	if err != nil {
+		logf(ctx, "Error loading team template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, teamPage{Member: user, TeamStats: stats}); err != nil {
+		logf(ctx, "Error executing team template: %v", err)
+	}
+}
+
+// handleAPITeam serves GET /api/v1/teams/{name} to the admin and the
+// team's members.
+func (s *Server) handleAPITeam(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/v1/teams/")
+	logf(r.Context(), "Handling API team request for team: '%s'", name)
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	ctx := r.Context()
+
+	var team store.Team
+	found := false
+	if s.authorizedAdmin(r) {
+		teams, err := s.store.Teams(ctx)
+		if err != nil {
+			logf(ctx, "Error fetching teams: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching team")
+			return
+		}
+		for _, t := range teams {
+			if t.Name == name {
+				team, found = t, true
+			}
+		}
+	} else {
+		member, ok := s.accountName(r)
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+		var err error
+		if team, found, err = s.teamOf(ctx, member); err != nil {
+			logf(ctx, "Error looking up the team of %s: %v", member, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching team")
+			return
+		}
+		if !found || team.Name != name {
+			writeAPIError(w, http.StatusForbidden, errCodeForbidden, "Not a member of this team")
+			return
+		}
+	}
+	if !found {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Team not found")
+		return
+	}
+
+	stats, err := s.teamStats(ctx, team)
+	if err != nil {
+		logf(ctx, "Error fetching the links of team %s: %v", team.Name, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching team")
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) handleTeams(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling teams request")
+	if s.cfg.API.AdminKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.authorizedDashboard(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="shorty admin"`)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.showTeams(w, r)
+	case http.MethodPost:
+		s.changeTeams(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showTeams(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	page := teamsPage{Message: r.URL.Query().Get("message"), Error: r.URL.Query().Get("error")}
+
+	teams, err := s.store.Teams(ctx)
+	if err != nil {
+		logf(ctx, "Error fetching teams: %v", err)
+		httpError(w, "Error fetching teams", http.StatusInternalServerError)
+		return
+	}
+	for _, team := range teams {
+		stats, err := s.teamStats(ctx, team)
+		if err != nil {
+			logf(ctx, "Error fetching the links of team %s: %v", team.Name, err)
+			httpError(w, "Error fetching teams", http.StatusInternalServerError)
+			return
+		}
+		page.Teams = append(page.Teams, stats)
+	}
+	if page.CSRFToken, err = csrfToken(w, r); err != nil {
+		logf(ctx, "Error generating CSRF token: %v", err)
+		httpError(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := s.templates.lookup("teams.html")
+	if err != nil {
+		logf(ctx, "Error loading teams template: %v", err)
+		httpError(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		logf(ctx, "Error executing teams template: %v", err)
+	}
+}
+
+// changeTeams applies an action posted from teams.html, then sends the
+// browser back to the list.
+func (s *Server) changeTeams(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes())
+	if err := r.ParseForm(); err != nil {
+		httpError(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	if !validCSRF(r) {
+		logln(r.Context(), "Rejected teams request with missing or invalid CSRF token")
+		httpError(w, "Invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	back := func(param, note string) {
+		http.Redirect(w, r, "/admin/teams?"+url.Values{param: {note}}.Encode(), http.StatusSeeOther)
+	}
+	team := strings.TrimSpace(r.PostFormValue("team"))
+	member := strings.TrimSpace(r.PostFormValue("member"))
+
+	switch action := r.PostFormValue("action"); action {
+	case "create":
+		if validateAlias(team) != nil {
+			back("error", "Team names may only contain letters, digits, '-' and '_'")
+			return
+		}
+		if err := s.store.CreateTeam(ctx, team); err == store.ErrTeamExists {
+			back("error", "There is already a team called "+team)
+			return
+		} else if err != nil {
+			logf(ctx, "Error creating team %s: %v", team, err)
+			back("error", "Failed to create team "+team)
+			return
+		}
+		logf(ctx, "Created team %s", team)
+		back("message", "Created team "+team)
+
+	case "delete":
+		deleted, err := s.store.DeleteTeam(ctx, team)
+		if err != nil {
+			logf(ctx, "Error deleting team %s: %v", team, err)
+			back("error", "Failed to delete team "+team)
+			return
+		}
+		if !deleted {
+			back("error", "No such team: "+team)
+			return
+		}
+		logf(ctx, "Deleted team %s", team)
+		back("message", "Deleted team "+team+"; its links are kept")
+
+	case "add":
+		if !s.knownMember(member) {
+			back("error", "No basicAuth user or API key is called "+member)
+			return
+		}
+		if err := s.store.SetTeamMember(ctx, team, member); err == store.ErrNotFound {
+			back("error", "No such team: "+team)
+			return
+		} else if err != nil {
+			logf(ctx, "Error adding %s to team %s: %v", member, team, err)
+			back("error", "Failed to add "+member+" to "+team)
+			return
+		}
+		logf(ctx, "Added %s to team %s", member, team)
+		back("message", "Added "+member+" to "+team)
+
+	case "remove":
+		removed, err := s.store.RemoveTeamMember(ctx, member)
+		if err != nil {
+			logf(ctx, "Error removing %s from its team: %v", member, err)
+			back("error", "Failed to remove "+member)
+			return
+		}
+		if !removed {
+			back("error", member+" is not in a team")
+			return
+		}
+		logf(ctx, "Removed %s from its team", member)
+		back("message", "Removed "+member+" from its team")
+
+	default:
+		back("error", "Unknown action")
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func postTeams(srv *Server, form url.Values) *httptest.ResponseRecorder {
+	token := strings.Repeat("ab", csrfTokenBytes)
+	form.Set(csrfFieldName, token)
+	req := httptest.NewRequest("POST", "/admin/teams", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	req.SetBasicAuth("admin", "secret")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestValidateTeamMembers(t *testing.T) {
+	users := map[string]string{"alice": "hunter2"}
+	if err := validateTeamMembers(users, []config.APIKey{{Name: "ci", Key: "k1"}}); err != nil {
+		t.Errorf("validateTeamMembers returned an error: %v", err)
+	}
+	if err := validateTeamMembers(users, []config.APIKey{{Name: "alice", Key: "k1"}}); err == nil {
+		t.Error("validateTeamMembers accepted an API key named like a user")
+	}
+
+	cfg := &config.Config{}
+	cfg.BasicAuth.Users = users
+	cfg.API.Keys = []config.APIKey{{Name: "alice", Key: "k1"}}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted an API key named like a basicAuth user")
+	}
+}
+
+func TestTeams(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.BasicAuth.Users = map[string]string{"alice": "hunter2", "bob": "swordfish"}
+	srv.cfg.API.Keys = []config.APIKey{{Name: "ci", Key: "ci-key"}}
+	ctx := context.Background()
+
+	t.Run("Admin", func(t *testing.T) {
+		for _, form := range []url.Values{
+			{"action": {"create"}, "team": {"marketing"}},
+			{"action": {"add"}, "team": {"marketing"}, "member": {"alice"}},
+			{"action": {"add"}, "team": {"marketing"}, "member": {"ci"}},
+		} {
+			rr := postTeams(srv, form)
+			if status := rr.Code; status != http.StatusSeeOther {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
+			}
+			if loc := rr.Header().Get("Location"); !strings.Contains(loc, "message=") {
+				t.Errorf("%s redirected to %s", form.Get("action"), loc)
+			}
+		}
+		for _, form := range []url.Values{
+			{"action": {"create"}, "team": {"marketing"}},
+			{"action": {"create"}, "team": {"bad name"}},
+			{"action": {"add"}, "team": {"marketing"}, "member": {"mallory"}},
+			{"action": {"add"}, "team": {"sales"}, "member": {"bob"}},
+		} {
+			if loc := postTeams(srv, form).Header().Get("Location"); !strings.Contains(loc, "error=") {
+				t.Errorf("%s %v redirected to %s", form.Get("action"), form, loc)
+			}
+		}
+
+		teams, err := st.Teams(ctx)
+		if err != nil || len(teams) != 1 || strings.Join(teams[0].Members, ",") != "alice,ci" {
+			t.Errorf("Teams returned %+v, %v", teams, err)
+		}
+
+		req := httptest.NewRequest("GET", "/admin/teams", nil)
+		req.SetBasicAuth("admin", "secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if !strings.Contains(rr.Body.String(), "marketing") {
+			t.Error("teams page does not list the team")
+		}
+	})
+
+	var shortURLs []string
+	t.Run("Member Links", func(t *testing.T) {
+		req := newCreateRequest(t, "url=https://example.com")
+		req.SetBasicAuth("alice", "hunter2")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		req = httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "https://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+
+		// Bob isn't in a team, so his link is nobody's.
+		req = newCreateRequest(t, "url=https://example.org")
+		req.SetBasicAuth("bob", "swordfish")
+		srv.ServeHTTP(httptest.NewRecorder(), req)
+
+		links, err := st.TeamLinks(ctx, "marketing")
+		if err != nil || len(links) != 2 {
+			t.Fatalf("TeamLinks returned %+v, %v", links, err)
+		}
+		if links[0].ShortURL == links[1].ShortURL {
+			t.Errorf("team links share the short URL %s", links[0].ShortURL)
+		}
+		for _, link := range links {
+			shortURLs = append(shortURLs, link.ShortURL)
+		}
+	})
+
+	t.Run("Team Page", func(t *testing.T) {
+		srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/_/"+shortURLs[0], nil))
+
+		req := httptest.NewRequest("GET", "/team", nil)
+		req.SetBasicAuth("alice", "hunter2")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		for _, want := range append([]string{"marketing"}, shortURLs...) {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("team page does not contain %q", want)
+			}
+		}
+
+		req = httptest.NewRequest("GET", "/team", nil)
+		req.SetBasicAuth("bob", "swordfish")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusForbidden)
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/team", nil))
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("API", func(t *testing.T) {
+		get := func(path string, auth func(*http.Request)) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", path, nil)
+			if auth != nil {
+				auth(req)
+			}
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, req)
+			return rr
+		}
+
+		for name, auth := range map[string]func(*http.Request){
+			"Key":   func(r *http.Request) { r.Header.Set("Authorization", "Bearer ci-key") },
+			"User":  func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") },
+			"Admin": func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") },
+		} {
+			rr := get("/api/v1/teams/marketing", auth)
+			if status := rr.Code; status != http.StatusOK {
+				t.Errorf("%s: handler returned wrong status code: got %v want %v", name, status, http.StatusOK)
+				continue
+			}
+			var stats TeamStats
+			if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+				t.Fatal(err)
+			}
+			if stats.Team != "marketing" || stats.LinkCount != 2 || stats.VisitCount != 1 || len(stats.Members) != 2 {
+				t.Errorf("%s: team stats returned %+v", name, stats)
+			}
+			if len(stats.TopLinks) != 2 || stats.TopLinks[0].ShortURL != shortURLs[0] {
+				t.Errorf("%s: team stats returned top links %+v", name, stats.TopLinks)
+			}
+			if len(stats.Links) != 2 || stats.Links[0].ShortURL != shortURLs[1] {
+				t.Errorf("%s: team stats returned links %+v", name, stats.Links)
+			}
+		}
+
+		checkAPIError(t, get("/api/v1/teams/marketing", func(r *http.Request) { r.SetBasicAuth("bob", "swordfish") }), http.StatusForbidden, errCodeForbidden)
+		checkAPIError(t, get("/api/v1/teams/marketing", nil), http.StatusUnauthorized, errCodeUnauthorized)
+		checkAPIError(t, get("/api/v1/teams/sales", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }), http.StatusNotFound, errCodeNotFound)
+	})
+
+	t.Run("Remove and Delete", func(t *testing.T) {
+		postTeams(srv, url.Values{"action": {"remove"}, "member": {"alice"}})
+		if _, ok, err := srv.teamOf(ctx, "alice"); ok || err != nil {
+			t.Errorf("teamOf returned %v, %v after removing alice", ok, err)
+		}
+		postTeams(srv, url.Values{"action": {"delete"}, "team": {"marketing"}})
+		if teams, err := st.Teams(ctx); err != nil || len(teams) != 0 {
+			t.Errorf("Teams returned %+v, %v after deleting the team", teams, err)
+		}
+		if _, err := st.LongURL(ctx, shortURLs[0]); err != nil {
+			t.Errorf("deleting a team removed its link: %v", err)
+		}
+	})
+}
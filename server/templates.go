@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+	"text/template"
+)
+
+// templateFiles lists every page template shorty renders. All of them are
+// parsed at startup so a broken template stops the server before it serves
+// any traffic.
+var templateFiles = []string{
+	"index.html",
+	"short.html",
+	"stats.html",
+	"link_stats.html",
+	"limit.html",
+	"card.html",
+	"dashboard.html",
+	"admin.html",
+	"moderation.html",
+	"report.html",
+	"removed.html",
+	"takedowns.html",
+	"teams.html",
+	"team.html",
+	"account.html",
+	"shorten.html",
+	"stale.html",
+	"duplicates.html",
+	"digest.txt",
+}
+
+// templateCache holds the parsed page templates. When reload is set the
+// templates are re-parsed from fsys on every lookup, which is handy while
+// editing the HTML during development.
+type templateCache struct {
+	fsys   fs.FS
+	reload bool
+
+	mu  sync.RWMutex
+	set map[string]*template.Template
+}
+
+func loadTemplates(fsys fs.FS, reload bool) (*templateCache, error) {
+	c := &templateCache{
+		fsys:   fsys,
+		reload: reload,
+		set:    make(map[string]*template.Template),
+	}
+	for _, name := range templateFiles {
+		tmpl, err := parseTemplate(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		c.set[name] = tmpl
+	}
+	return c, nil
+}
+
+func parseTemplate(fsys fs.FS, name string) (*template.Template, error) {
+	tmpl, err := template.ParseFS(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+func (c *templateCache) lookup(name string) (*template.Template, error) {
+	if c.reload {
+		tmpl, err := parseTemplate(c.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.set[name] = tmpl
+		c.mu.Unlock()
+		return tmpl, nil
+	}
+
+	c.mu.RLock()
+	tmpl, ok := c.set[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("template %s not loaded", name)
+	}
+	return tmpl, nil
+}
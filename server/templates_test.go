@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	cache, err := loadTemplates(defaultTemplates, false)
+	if err != nil {
+		t.Fatalf("loadTemplates returned an error: %v", err)
+	}
+
+	for _, name := range templateFiles {
+		if _, err := cache.lookup(name); err != nil {
+			t.Errorf("lookup(%q) returned an error: %v", name, err)
+		}
+	}
+
+	if _, err := cache.lookup("missing.html"); err == nil {
+		t.Error("Expected an error for an unknown template, got nil")
+	}
+}
+
+func TestLoadTemplatesReload(t *testing.T) {
+	cache, err := loadTemplates(defaultTemplates, true)
+	if err != nil {
+		t.Fatalf("loadTemplates returned an error: %v", err)
+	}
+
+	first, err := cache.lookup("short.html")
+	if err != nil {
+		t.Fatalf("lookup returned an error: %v", err)
+	}
+	second, err := cache.lookup("short.html")
+	if err != nil {
+		t.Fatalf("lookup returned an error: %v", err)
+	}
+	if first == second {
+		t.Error("Expected reload mode to re-parse the template on each lookup")
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// One instance can serve several short domains. Each tenant is a Server of
+// its own, with its own store, so its codes, stats and admin pages are
+// separate from the main domain's, and the main server hands it the
+// requests for its hosts.
+
+// AddTenant serves the requests for host with tenant instead of s. Tenants
+// must be added before the server starts handling requests.
+func (s *Server) AddTenant(host string, tenant *Server) {
+	if s.tenants == nil {
+		s.tenants = make(map[string]*Server)
+	}
+	s.tenants[strings.ToLower(host)] = tenant
+}
+
+// requestHost returns the host r was sent to, lowercased and without the
+// port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
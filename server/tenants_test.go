@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestHost(t *testing.T) {
+	for host, want := range map[string]string{
+		"go.acme.com":      "go.acme.com",
+		"Go.Acme.com:8080": "go.acme.com",
+		"127.0.0.1:9130":   "127.0.0.1",
+		"[::1]:9130":       "::1",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		if got := requestHost(req); got != want {
+			t.Errorf("requestHost(%q) = %q want %q", host, got, want)
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	srv, mainStore := newMemoryServer(t)
+	tenant, tenantStore := newMemoryServer(t)
+	srv.AddTenant("Go.Acme.com", tenant)
+	ctx := context.Background()
+
+	send := func(method, host, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Host = host
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The same alias can be taken on both domains.
+	for host, longURL := range map[string]string{"sho.rt": "https://example.com/main", "go.acme.com:8080": "https://example.com/acme"} {
+		rr := send("POST", host, "/api/v1/links", `{"url": "`+longURL+`", "alias": "docs"}`)
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", host, status, http.StatusCreated)
+		}
+	}
+	if got, err := mainStore.LongURL(ctx, "docs"); err != nil || got != "https://example.com/main" {
+		t.Errorf("main store returned %q, %v", got, err)
+	}
+	if got, err := tenantStore.LongURL(ctx, "docs"); err != nil || got != "https://example.com/acme" {
+		t.Errorf("tenant store returned %q, %v", got, err)
+	}
+
+	for host, want := range map[string]string{"sho.rt": "https://example.com/main", "GO.ACME.COM": "https://example.com/acme"} {
+		rr := send("GET", host, "/_/docs", "")
+		if status := rr.Code; status != http.StatusFound {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", host, status, http.StatusFound)
+		}
+		if got := rr.Header().Get("Location"); got != want {
+			t.Errorf("%s: handler redirected to %q want %q", host, got, want)
+		}
+	}
+
+	// Stats are counted where the visit was made.
+	if link, err := tenantStore.Link(ctx, "docs"); err != nil || link.VisitCount != 1 {
+		t.Errorf("tenant link returned %+v, %v want 1 visit", link, err)
+	}
+	if count, err := tenantStore.Count(ctx); err != nil || count != 1 {
+		t.Errorf("tenant store has %d links, %v want 1", count, err)
+	}
+}
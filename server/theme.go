@@ -0,0 +1,39 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// The default theme is compiled into the binary so shorty runs from any
+// directory. Self-hosters can replace individual files by dropping them into
+// the configured templates/ and static/ directories.
+
+//go:embed index.html short.html stats.html link_stats.html limit.html card.html dashboard.html admin.html moderation.html report.html removed.html takedowns.html teams.html team.html account.html shorten.html stale.html duplicates.html digest.txt
+var defaultTemplates embed.FS
+
+//go:embed condensed.css logo.png donutlogo.png
+var defaultStatic embed.FS
+
+// overlayFS serves files from upper when they exist there and from lower
+// otherwise, so an override directory only needs the files it changes.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	return o.lower.Open(name)
+}
+
+func newThemeFS(dir string, defaults fs.FS) fs.FS {
+	if dir == "" {
+		return defaults
+	}
+	return overlayFS{upper: os.DirFS(dir), lower: defaults}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestThemeFSOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "condensed.css"), []byte("custom"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := newThemeFS(dir, defaultStatic)
+
+	data, err := fs.ReadFile(fsys, "condensed.css")
+	if err != nil {
+		t.Fatalf("ReadFile returned an error: %v", err)
+	}
+	if string(data) != "custom" {
+		t.Errorf("override was not used: got %q want %q", data, "custom")
+	}
+
+	if _, err := fs.ReadFile(fsys, "logo.png"); err != nil {
+		t.Errorf("missing override did not fall back to the default: %v", err)
+	}
+}
+
+func TestThemeFSMissingDir(t *testing.T) {
+	fsys := newThemeFS(filepath.Join(t.TempDir(), "does-not-exist"), defaultTemplates)
+
+	if _, err := loadTemplates(fsys, false); err != nil {
+		t.Errorf("loadTemplates should fall back to defaults: %v", err)
+	}
+}
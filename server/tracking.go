@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters added by ad networks and mailing
+// tools to follow people around. Any parameter starting with "utm_" is a
+// tracker as well.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"gclsrc":  true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"yclid":   true,
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
+
+// stripTracking removes tracking parameters from the query string of
+// longURL. The remaining parameters keep their order and encoding, so a
+// URL without trackers comes back unchanged and still dedupes against
+// existing links.
+func stripTracking(longURL string) string {
+	before, fragment, hasFragment := strings.Cut(longURL, "#")
+	base, query, hasQuery := strings.Cut(before, "?")
+	if !hasQuery {
+		return longURL
+	}
+
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if !isTrackingParam(key) {
+			kept = append(kept, param)
+		}
+	}
+
+	stripped := base
+	if len(kept) > 0 {
+		stripped += "?" + strings.Join(kept, "&")
+	}
+	if hasFragment {
+		stripped += "#" + fragment
+	}
+	return stripped
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStripTracking(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/page":                                       "https://example.com/page",
+		"https://example.com/page?id=7&utm_source=news&utm_medium=email": "https://example.com/page?id=7",
+		"https://example.com/page?fbclid=abc":                            "https://example.com/page",
+		"https://example.com/page?b=2&gclid=x&a=1#reviews":               "https://example.com/page?b=2&a=1#reviews",
+		"https://example.com/page?UTM_Campaign=spring&q=a%20b":           "https://example.com/page?q=a%20b",
+		"https://example.com/page?utm_source=x#top":                      "https://example.com/page#top",
+	}
+	for longURL, want := range tests {
+		if got := stripTracking(longURL); got != want {
+			t.Errorf("stripTracking(%q) returned wrong URL: got %v want %v", longURL, got, want)
+		}
+	}
+}
+
+func TestCreateStripsTracking(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Links.StripTracking = true
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com/page?id=7"); err != nil {
+		t.Fatal(err)
+	}
+
+	shortURL, err := srv.shortenURL(ctx, "https://example.com/page?id=7&fbclid=abc", "")
+	if err != nil {
+		t.Fatalf("shortenURL returned an error: %v", err)
+	}
+	if shortURL != "abc123" {
+		t.Errorf("shortenURL returned wrong short URL: got %v want %v", shortURL, "abc123")
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// With trash.retention set, deleting a link moves it to the trash instead
+// of removing it. It stops redirecting straight away but can be restored
+// until the retention period runs out, when the sweeper purges it.
+
+const trashSweepInterval = time.Hour
+
+// deleteLink trashes shortURL, or deletes it for good when permanent is set
+// or the trash is off, and reports whether it was found.
+func (s *Server) deleteLink(ctx context.Context, shortURL string, permanent bool) (bool, error) {
+	before := s.auditSnapshot(ctx, shortURL)
+	if permanent || s.cfg.Trash.Retention.Duration <= 0 {
+		deleted, err := s.store.Delete(ctx, shortURL)
+		if deleted {
+			s.audit(ctx, shortURL, store.AuditDelete, before, nil)
+		}
+		return deleted, err
+	}
+	trashed, err := s.store.Trash(ctx, shortURL)
+	if trashed {
+		s.audit(ctx, shortURL, store.AuditTrash, before, nil)
+	}
+	return trashed, err
+}
+
+// sweepTrash purges the links that have been in the trash longer than
+// trash.retention.
+func (s *Server) sweepTrash(ctx context.Context) {
+	purged, err := s.store.PurgeTrash(ctx, time.Now().Add(-s.cfg.Trash.Retention.Duration))
+	if err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d link(s) from the trash", purged)
+	}
+}
+
+// startTrashSweeper purges expired links from the trash every
+// trashSweepInterval until ctx is cancelled. It does nothing when the
+// trash is off.
+func (s *Server) startTrashSweeper(ctx context.Context) {
+	if s.cfg.Trash.Retention.Duration <= 0 {
+		return
+	}
+	log.Printf("Keeping deleted links in the trash for %v", s.cfg.Trash.Retention.Duration)
+
+	go func() {
+		s.sweepTrash(ctx)
+		ticker := time.NewTicker(trashSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if s.lead(ctx, "trash", trashSweepInterval) {
+					s.sweepTrash(ctx)
+				}
+			}
+		}
+	}()
+}
+
+// handleAdminTrash serves GET /api/v1/admin/trash, which lists the trash,
+// and POST /api/v1/admin/trash/{shortURL}, which restores a link.
+func (s *Server) handleAdminTrash(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling trash request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	shortURL := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/admin/trash"), "/")
+	if shortURL == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		links, err := s.store.Trashed(r.Context())
+		if err != nil {
+			logf(r.Context(), "Error listing trash: %v", err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error listing trash")
+			return
+		}
+		if links == nil {
+			links = []store.TrashedLink{}
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Links     []store.TrashedLink `json:"links"`
+			Retention string              `json:"retention"`
+		}{links, s.cfg.Trash.Retention.Duration.String()})
+		return
+	}
+
+	if strings.Contains(shortURL, "/") {
+		writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := withActor(r.Context(), "admin")
+	if err := s.store.Restore(ctx, shortURL); err != nil {
+		if err == store.ErrNotFound {
+			writeAPIError(w, http.StatusNotFound, errCodeNotFound, "Short URL is not in the trash")
+			return
+		}
+		logf(ctx, "Error restoring short URL %s: %v", shortURL, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Failed to restore short URL")
+		return
+	}
+	logln(ctx, "Restored short URL from the trash:", shortURL)
+	s.audit(ctx, shortURL, store.AuditRestore, nil, s.auditSnapshot(ctx, shortURL))
+	s.writeLink(w, r, shortURL)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestDeleteToTrash(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Trash.Retention.Duration = 24 * time.Hour
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Trash", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/v1/links/abc123", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+		if trash, err := st.Trashed(ctx); err != nil || len(trash) != 1 || trash[0].ShortURL != "abc123" {
+			t.Errorf("trash holds %+v, %v want abc123", trash, err)
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/api/v1/links/abc123?permanent=true", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
+		}
+		if exists, err := st.Exists(ctx, "abc123"); err != nil || exists {
+			t.Errorf("Exists after a permanent delete returned %v, %v want false", exists, err)
+		}
+	})
+}
+
+func TestHandleAdminTrash(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.Trash.Retention.Duration = 24 * time.Hour
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "live01"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := st.Trash(ctx, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/admin/trash", nil))
+
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/admin/trash", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var body struct {
+			Links []store.TrashedLink `json:"links"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Links) != 1 || body.Links[0].ShortURL != "abc123" || body.Links[0].DeletedAt.IsZero() {
+			t.Errorf("handler returned unexpected trash: %+v", body.Links)
+		}
+	})
+
+	t.Run("Restore Not Trashed", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/admin/trash/live01", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		checkAPIError(t, rr, http.StatusNotFound, errCodeNotFound)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/v1/admin/trash/abc123", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+
+		if status := rr.Code; status >= 300 {
+			t.Errorf("handler returned wrong status code: got %v", status)
+		}
+		if longURL, err := st.LongURL(ctx, "abc123"); err != nil || longURL != "https://example.com/abc123" {
+			t.Errorf("LongURL after the restore returned %q, %v", longURL, err)
+		}
+	})
+}
+
+func TestSweepTrash(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Trash.Retention.Duration = time.Nanosecond
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "live01"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, shortURL := range []string{"abc123", "def456"} {
+		if _, err := st.Trash(ctx, shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deletion times are kept to the second.
+	time.Sleep(time.Second)
+
+	srv.sweepTrash(ctx)
+	if trash, err := st.Trashed(ctx); err != nil || len(trash) != 0 {
+		t.Errorf("trash after the sweep holds %+v, %v", trash, err)
+	}
+	if count, err := st.Count(ctx); err != nil || count != 1 {
+		t.Errorf("Count after the sweep returned %v, %v want 1", count, err)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Trending links are the links with the most clicks over the last day or
+// week, rather than over their lifetime as the popular links are. They are
+// counted from the click log and its rollups, so they need
+// analytics.clickLog, and bots are left out.
+
+const (
+	defaultTrendingLinks = 10
+	maxTrendingLinks     = 100
+)
+
+// trendingWindow is a span of time trending links are counted over.
+type trendingWindow struct {
+	Name   string
+	Label  string
+	Length time.Duration
+}
+
+// trendingWindows are the windows there are, in the order the stats page
+// shows them. The first is the API's default.
+var trendingWindows = []trendingWindow{
+	{Name: "24h", Label: "24 Hours", Length: 24 * time.Hour},
+	{Name: "7d", Label: "7 Days", Length: 7 * 24 * time.Hour},
+}
+
+// trendingList is one window's trending links, for stats.html.
+type trendingList struct {
+	trendingWindow
+	Links []store.LinkClicks
+}
+
+// pageTrending returns the trending links of every window for the stats
+// page, or nil when the click log is off or can't be read.
+func (s *Server) pageTrending(r *http.Request) []trendingList {
+	if !s.cfg.Analytics.ClickLog {
+		return nil
+	}
+	now := time.Now()
+	lists := make([]trendingList, len(trendingWindows))
+	for i, window := range trendingWindows {
+		links, err := s.store.TopLinks(r.Context(), now.Add(-window.Length), defaultTrendingLinks)
+		if err != nil {
+			logf(r.Context(), "Error fetching trending links: %v", err)
+			return nil
+		}
+		lists[i] = trendingList{trendingWindow: window, Links: links}
+	}
+	return lists
+}
+
+type trendingResponse struct {
+	Window string             `json:"window"`
+	Since  time.Time          `json:"since"`
+	Links  []store.LinkClicks `json:"links"`
+}
+
+// handleAPITrending serves GET /api/v1/stats/trending, the links with the
+// most clicks over the ?window= (24h or 7d), capped with ?limit=.
+func (s *Server) handleAPITrending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	window := trendingWindows[0]
+	if value := r.URL.Query().Get("window"); value != "" {
+		found := false
+		for _, candidate := range trendingWindows {
+			if candidate.Name == value {
+				window, found = candidate, true
+			}
+		}
+		if !found {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Window must be 24h or 7d")
+			return
+		}
+	}
+
+	limit := defaultTrendingLinks
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeAPIError(w, http.StatusBadRequest, errCodeInvalidForm, "Limit must be a positive number")
+			return
+		}
+		if n < maxTrendingLinks {
+			limit = n
+		} else {
+			limit = maxTrendingLinks
+		}
+	}
+
+	if !s.cfg.Analytics.ClickLog {
+		writeAPIError(w, http.StatusServiceUnavailable, errCodeClickLogDisabled, "The click log is turned off")
+		return
+	}
+
+	since := time.Now().UTC().Add(-window.Length).Truncate(time.Second)
+	links, err := s.store.TopLinks(r.Context(), since, limit)
+	if err != nil {
+		logf(r.Context(), "Error fetching trending links: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error fetching trending links")
+		return
+	}
+	if links == nil {
+		links = []store.LinkClicks{}
+	}
+	writeRevalidatedJSON(w, r, trendingResponse{Window: window.Name, Since: since, Links: links}, false)
+}
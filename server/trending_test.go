@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestTrending(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+
+	// old was busy last week, new is busy today, and their lifetime visits
+	// don't matter.
+	for _, shortURL := range []string{"old", "new"} {
+		if err := st.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	clicks := []store.Click{
+		{ShortURL: "old", At: now.Add(-3 * 24 * time.Hour)},
+		{ShortURL: "old", At: now.Add(-4 * 24 * time.Hour)},
+		{ShortURL: "old", At: now.Add(-5 * 24 * time.Hour)},
+		{ShortURL: "new", At: now.Add(-time.Hour)},
+		{ShortURL: "new", At: now.Add(-time.Minute)},
+		{ShortURL: "new", Bot: "crawler", At: now.Add(-time.Minute)},
+	}
+	for _, click := range clicks {
+		if err := st.RecordClick(ctx, click); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trending := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/stats/trending"+query, nil))
+		return rr
+	}
+	checkAPIError(t, trending(""), http.StatusServiceUnavailable, errCodeClickLogDisabled)
+
+	srv.cfg.Analytics.ClickLog = true
+	for query, want := range map[string]string{
+		"":                   "new:2",
+		"?window=7d":         "old:3,new:2",
+		"?window=7d&limit=1": "old:3",
+	} {
+		rr := trending(query)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", query, status, http.StatusOK)
+		}
+		var resp trendingResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, link := range resp.Links {
+			got = append(got, link.ShortURL+":"+strconv.Itoa(link.Clicks))
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("%s: handler returned %v want %s", query, got, want)
+		}
+	}
+	checkAPIError(t, trending("?window=30d"), http.StatusBadRequest, errCodeInvalidForm)
+	checkAPIError(t, trending("?limit=0"), http.StatusBadRequest, errCodeInvalidForm)
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/stats", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, "Trending: Last 24 Hours") || !strings.Contains(body, "Trending: Last 7 Days") {
+		t.Error("stats page does not list trending links")
+	}
+}
+
+func TestStatsEscapesLinks(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.Analytics.ClickLog = true
+	ctx := context.Background()
+	if err := st.Create(ctx, "abc123", `https://example.com/"><script>alert(1)</script>`); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RecordClick(ctx, store.Click{ShortURL: "abc123", At: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/stats", nil))
+
+	body := rr.Body.String()
+	if strings.Contains(body, "<script>alert") {
+		t.Error("stats page has an unescaped long URL")
+	}
+	// Popular, recent and both trending tables.
+	if n := strings.Count(body, ">https://example.com/&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;</a>"); n != 4 {
+		t.Errorf("stats page shows the escaped long URL %d time(s), want 4", n)
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Clients of the JSON API can each be given a key in api.keys. A link
+// created with a key remembers it, and what the key creates and the
+// redirects of its links are counted month by month in the store, so every
+// replica sees the same totals. Once a key has used up a monthly quota,
+// creating with it answers 429 and its links stop redirecting until the
+// next month starts.
+
+// UsageReport is what one API key used this month, against its quotas,
+// and in the months before.
+type UsageReport struct {
+	Key           string `json:"key"`
+	Month         string `json:"month"`
+	Creates       int    `json:"creates"`
+	CreateQuota   int    `json:"createQuota,omitempty"`
+	Redirects     int    `json:"redirects"`
+	RedirectQuota int    `json:"redirectQuota,omitempty"`
+	// ResetsAt is when the next month starts and the counts start over.
+	ResetsAt time.Time     `json:"resetsAt"`
+	History  []store.Usage `json:"history"`
+}
+
+// validateAPIKeys checks every api.keys entry has a name and a key of its
+// own, known scopes and quotas that make sense.
+func validateAPIKeys(keys []config.APIKey, adminKey string) error {
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for i, key := range keys {
+		if key.Name == "" || strings.ContainsRune(key.Name, 0) {
+			return fmt.Errorf("api.keys entry %d needs a name", i)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("api.keys has two keys named %q", key.Name)
+		}
+		names[key.Name] = true
+		if key.Key == "" {
+			return fmt.Errorf("api key %q has no key", key.Name)
+		}
+		if tokens[key.Key] || key.Key == adminKey {
+			return fmt.Errorf("api key %q reuses another key", key.Name)
+		}
+		tokens[key.Key] = true
+		for _, scope := range key.Scopes {
+			if !validScope(scope) {
+				return fmt.Errorf("api key %q has unknown scope %q", key.Name, scope)
+			}
+		}
+		if key.MonthlyCreates < 0 || key.MonthlyRedirects < 0 {
+			return fmt.Errorf("api key %q has a negative quota", key.Name)
+		}
+	}
+	return nil
+}
+
+type apiKeyKey struct{}
+
+// withAPIKey records in ctx the name of the API key a link is being
+// created with.
+func withAPIKey(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, name)
+}
+
+// apiKeyFrom returns the API key name recorded in ctx, if any.
+func apiKeyFrom(ctx context.Context) string {
+	name, _ := ctx.Value(apiKeyKey{}).(string)
+	return name
+}
+
+// apiKeyFor returns the api.keys entry whose key r carries as a bearer
+// token.
+func (s *Server) apiKeyFor(r *http.Request) (config.APIKey, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return config.APIKey{}, false
+	}
+	for _, key := range s.cfg.API.Keys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 {
+			return key, true
+		}
+	}
+	return config.APIKey{}, false
+}
+
+// apiKeyNamed returns the api.keys entry called name.
+func (s *Server) apiKeyNamed(name string) (config.APIKey, bool) {
+	for _, key := range s.cfg.API.Keys {
+		if key.Name == name {
+			return key, true
+		}
+	}
+	return config.APIKey{}, false
+}
+
+// usageMonth returns the calendar month t falls in, as usage is recorded,
+// and when the next one starts. Months are in UTC.
+func usageMonth(t time.Time) (string, time.Time) {
+	t = t.UTC()
+	return t.Format("2006-01"), time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// monthUsage returns what the API key called name used in month.
+func (s *Server) monthUsage(ctx context.Context, name, month string) (store.Usage, error) {
+	usage, err := s.store.Usage(ctx, name)
+	if err != nil {
+		return store.Usage{}, err
+	}
+	for _, u := range usage {
+		if u.Month == month {
+			return u, nil
+		}
+	}
+	return store.Usage{Month: month}, nil
+}
+
+// recordUsage counts creates and redirects for the API key called name
+// this month. A count that can't be saved is logged rather than failing
+// the request.
+func (s *Server) recordUsage(ctx context.Context, name string, creates, redirects int) {
+	month, _ := usageMonth(time.Now())
+	if err := s.store.RecordUsage(ctx, name, month, creates, redirects); err != nil {
+		logf(ctx, "Error recording usage of API key %s: %v", name, err)
+	}
+}
+
+// setRateLimitHeaders tells a client how much of a quota of limit it has
+// left after using used, and when it resets.
+func setRateLimitHeaders(w http.ResponseWriter, limit, used int, reset time.Time) {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
+
+// retryAfter is the Retry-After value for a quota that resets at reset.
+func retryAfter(reset time.Time) string {
+	return strconv.Itoa(int(time.Until(reset).Seconds()) + 1)
+}
+
+// createQuota returns how many links key has created this month, when
+// the month resets, and whether key may create another.
+func (s *Server) createQuota(ctx context.Context, key config.APIKey) (int, time.Time, bool, error) {
+	month, reset := usageMonth(time.Now())
+	usage, err := s.monthUsage(ctx, key.Name, month)
+	if err != nil {
+		return 0, reset, false, err
+	}
+	if key.MonthlyCreates > 0 && usage.Creates >= key.MonthlyCreates {
+		logf(ctx, "API key %s is over its monthly quota of %d links", key.Name, key.MonthlyCreates)
+		return usage.Creates, reset, false, nil
+	}
+	return usage.Creates, reset, true, nil
+}
+
+// checkCreateQuota answers 429 and returns false once key has created its
+// monthly quota of links. Otherwise it returns how many it has created
+// this month, and sets the X-RateLimit headers for a key with a quota.
+func (s *Server) checkCreateQuota(w http.ResponseWriter, r *http.Request, key config.APIKey) (int, bool) {
+	created, reset, ok, err := s.createQuota(r.Context(), key)
+	if err != nil {
+		logf(r.Context(), "Error reading usage of API key %s: %v", key.Name, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading API key usage")
+		return 0, false
+	}
+	if key.MonthlyCreates > 0 {
+		setRateLimitHeaders(w, key.MonthlyCreates, created, reset)
+	}
+	if !ok {
+		w.Header().Set("Retry-After", retryAfter(reset))
+		writeAPIError(w, http.StatusTooManyRequests, errCodeQuotaExceeded, "This API key has created its monthly quota of links")
+		return created, false
+	}
+	return created, true
+}
+
+// allowKeyRedirect counts a redirect of a link created with an API key,
+// or answers 429 and returns false once the key's links have used up its
+// monthly quota of redirects.
+func (s *Server) allowKeyRedirect(w http.ResponseWriter, r *http.Request, shortURL string, opts store.Options) bool {
+	if opts.APIKey == "" {
+		return true
+	}
+	if key, ok := s.apiKeyNamed(opts.APIKey); ok && key.MonthlyRedirects > 0 {
+		month, reset := usageMonth(time.Now())
+		usage, err := s.monthUsage(r.Context(), key.Name, month)
+		if err != nil {
+			logf(r.Context(), "Error reading usage of API key %s: %v", key.Name, err)
+		} else if usage.Redirects >= key.MonthlyRedirects {
+			logf(r.Context(), "Short URL '%s' is over the monthly redirect quota of API key %s", shortURL, key.Name)
+			w.Header().Set("Retry-After", retryAfter(reset))
+			httpError(w, "This link has used up its redirects for the month", http.StatusTooManyRequests)
+			return false
+		}
+	}
+	s.recordUsage(r.Context(), opts.APIKey, 0, 1)
+	return true
+}
+
+// usageReport reports what key used.
+func (s *Server) usageReport(ctx context.Context, key config.APIKey) (UsageReport, error) {
+	month, reset := usageMonth(time.Now())
+	history, err := s.store.Usage(ctx, key.Name)
+	if err != nil {
+		return UsageReport{}, err
+	}
+	report := UsageReport{
+		Key:           key.Name,
+		Month:         month,
+		CreateQuota:   key.MonthlyCreates,
+		RedirectQuota: key.MonthlyRedirects,
+		ResetsAt:      reset,
+		History:       []store.Usage{},
+	}
+	for _, u := range history {
+		if u.Month == month {
+			report.Creates, report.Redirects = u.Creates, u.Redirects
+		}
+		report.History = append(report.History, u)
+	}
+	return report, nil
+}
+
+// handleAPIUsage reports the usage of the API key the request carries.
+func (s *Server) handleAPIUsage(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling API usage request")
+	key, ok := s.apiKeyFor(r)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	report, err := s.usageReport(r.Context(), key)
+	if err != nil {
+		logf(r.Context(), "Error reading usage of API key %s: %v", key.Name, err)
+		writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading API key usage")
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleAdminUsage reports the usage of every API key.
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	logln(r.Context(), "Handling usage request")
+	if !s.authorizedAdmin(r) {
+		writeAPIError(w, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeAPIError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	reports := []UsageReport{}
+	for _, key := range s.cfg.API.Keys {
+		report, err := s.usageReport(r.Context(), key)
+		if err != nil {
+			logf(r.Context(), "Error reading usage of API key %s: %v", key.Name, err)
+			writeAPIError(w, http.StatusInternalServerError, errCodeInternal, "Error reading API key usage")
+			return
+		}
+		reports = append(reports, report)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Keys []UsageReport `json:"keys"`
+	}{reports})
+}
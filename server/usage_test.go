@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestValidateAPIKeys(t *testing.T) {
+	valid := []config.APIKey{{Name: "ci", Key: "k1", MonthlyCreates: 10}, {Name: "bot", Key: "k2"}}
+	if err := validateAPIKeys(valid, "admin"); err != nil {
+		t.Errorf("validateAPIKeys returned an error: %v", err)
+	}
+	for name, keys := range map[string][]config.APIKey{
+		"No Name":        {{Key: "k1"}},
+		"No Key":         {{Name: "ci"}},
+		"Duplicate Name": {{Name: "ci", Key: "k1"}, {Name: "ci", Key: "k2"}},
+		"Duplicate Key":  {{Name: "ci", Key: "k1"}, {Name: "bot", Key: "k1"}},
+		"Admin Key":      {{Name: "ci", Key: "admin"}},
+		"Negative Quota": {{Name: "ci", Key: "k1", MonthlyRedirects: -1}},
+	} {
+		if err := validateAPIKeys(keys, "admin"); err == nil {
+			t.Errorf("validateAPIKeys accepted %s", name)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.API.Keys = []config.APIKey{{Name: "ci"}}
+	if _, err := New(cfg, store.NewMemory()); err == nil {
+		t.Error("New accepted an api.keys entry without a key")
+	}
+}
+
+func TestUsageMonth(t *testing.T) {
+	month, reset := usageMonth(time.Date(2024, time.December, 31, 23, 0, 0, 0, time.UTC))
+	if month != "2024-12" || !reset.Equal(time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("usageMonth returned %s, %v", month, reset)
+	}
+}
+
+func TestAPIKeyQuotas(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	srv.cfg.API.AdminKey = "secret"
+	srv.cfg.API.Keys = []config.APIKey{{Name: "ci", Key: "ci-key", MonthlyCreates: 2, MonthlyRedirects: 1}}
+	ctx := context.Background()
+
+	create := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(`{"url": "https://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+
+	var shortURLs []string
+	t.Run("Create", func(t *testing.T) {
+		for i, remaining := range []string{"1", "0"} {
+			rr := create("ci-key")
+			if status := rr.Code; status != http.StatusCreated {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+			}
+			if got := rr.Header().Get("X-RateLimit-Limit"); got != "2" {
+				t.Errorf("handler returned X-RateLimit-Limit %q want 2", got)
+			}
+			if got := rr.Header().Get("X-RateLimit-Remaining"); got != remaining {
+				t.Errorf("create %d returned X-RateLimit-Remaining %q want %s", i, got, remaining)
+			}
+			if rr.Header().Get("X-RateLimit-Reset") == "" {
+				t.Error("handler returned no X-RateLimit-Reset")
+			}
+			var resp linkResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			shortURLs = append(shortURLs, resp.ShortURL)
+		}
+		if shortURLs[0] == shortURLs[1] {
+			t.Errorf("creates with an API key shared the short URL %s", shortURLs[0])
+		}
+		if opts, err := st.Options(ctx, shortURLs[0]); err != nil || opts.APIKey != "ci" {
+			t.Errorf("Options of a link created with an API key returned %+v, %v", opts, err)
+		}
+	})
+
+	t.Run("Create Quota", func(t *testing.T) {
+		rr := create("ci-key")
+		if rr.Header().Get("Retry-After") == "" {
+			t.Error("handler returned no Retry-After")
+		}
+		checkAPIError(t, rr, http.StatusTooManyRequests, errCodeQuotaExceeded)
+
+		// Without a key the request is anonymous and has no quota.
+		if rr := create(""); rr.Code != http.StatusCreated || rr.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("anonymous create returned %v with X-RateLimit-Limit %q", rr.Code, rr.Header().Get("X-RateLimit-Limit"))
+		}
+	})
+
+	t.Run("Redirect Quota", func(t *testing.T) {
+		for _, want := range []int{http.StatusFound, http.StatusTooManyRequests} {
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/"+shortURLs[0], nil))
+			if status := rr.Code; status != want {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, want)
+			}
+		}
+	})
+
+	t.Run("Report", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/usage", nil)
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		var report UsageReport
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		month, _ := usageMonth(time.Now())
+		if report.Key != "ci" || report.Month != month || report.Creates != 2 || report.CreateQuota != 2 || report.Redirects != 1 || report.RedirectQuota != 1 || len(report.History) != 1 {
+			t.Errorf("usage returned %+v", report)
+		}
+
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/usage", nil))
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+
+	t.Run("Admin Report", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/v1/admin/usage", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		var resp struct {
+			Keys []UsageReport `json:"keys"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Keys) != 1 || resp.Keys[0].Key != "ci" || resp.Keys[0].Creates != 2 {
+			t.Errorf("admin usage returned %+v", resp.Keys)
+		}
+
+		req = httptest.NewRequest("GET", "/api/v1/admin/usage", nil)
+		req.Header.Set("Authorization", "Bearer ci-key")
+		rr = httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		checkAPIError(t, rr, http.StatusUnauthorized, errCodeUnauthorized)
+	})
+}
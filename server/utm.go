@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// UTM tags are stored with a link rather than baked into its long URL, and
+// added to the destination on every redirect, so campaigns can be tagged
+// without hand-editing URLs.
+
+const maxUTMLength = 256
+
+// trimUTM strips surrounding whitespace from the UTM tags in opts.
+func trimUTM(opts *store.Options) {
+	opts.UTMSource = strings.TrimSpace(opts.UTMSource)
+	opts.UTMMedium = strings.TrimSpace(opts.UTMMedium)
+	opts.UTMCampaign = strings.TrimSpace(opts.UTMCampaign)
+}
+
+func validateUTM(opts store.Options) error {
+	for _, tag := range []string{opts.UTMSource, opts.UTMMedium, opts.UTMCampaign} {
+		if len(tag) > maxUTMLength {
+			return fmt.Errorf("UTM tags may be at most %d characters", maxUTMLength)
+		}
+	}
+	return nil
+}
+
+// utmQuery returns the link's UTM tags as query parameters.
+func utmQuery(opts store.Options) url.Values {
+	query := url.Values{}
+	for key, tag := range map[string]string{
+		"utm_source":   opts.UTMSource,
+		"utm_medium":   opts.UTMMedium,
+		"utm_campaign": opts.UTMCampaign,
+	} {
+		if tag != "" {
+			query.Set(key, tag)
+		}
+	}
+	return query
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestUTMQuery(t *testing.T) {
+	query := utmQuery(store.Options{UTMSource: "newsletter", UTMCampaign: "spring sale"})
+	if got, want := query.Encode(), "utm_campaign=spring+sale&utm_source=newsletter"; got != want {
+		t.Errorf("utmQuery returned wrong query: got %v want %v", got, want)
+	}
+	if query := utmQuery(store.Options{}); len(query) != 0 {
+		t.Errorf("utmQuery of a link without tags returned %v", query)
+	}
+}
+
+func TestCreateWithUTM(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, newCreateRequest(t, "url=https://example.com&utm_source=newsletter&utm_medium=+email+"))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	shortURL, err := st.ShortURLFor(ctx, "https://example.com")
+	if err != nil {
+		t.Fatalf("ShortURLFor returned an error: %v", err)
+	}
+	if opts, err := st.Options(ctx, shortURL); err != nil || opts.UTMSource != "newsletter" || opts.UTMMedium != "email" || opts.UTMCampaign != "" {
+		t.Errorf("link has options %+v, %v", opts, err)
+	}
+}
+
+func TestRedirectUTM(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "sale", "https://example.com/sale?utm_medium=web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SetOptions(ctx, "sale", store.Options{PassQuery: true, UTMSource: "newsletter", UTMMedium: "email"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/sale?utm_source=visitor&ref=friend", nil))
+
+	want := "https://example.com/sale?utm_medium=web&utm_source=newsletter&ref=friend"
+	if location := rr.Header().Get("Location"); location != want {
+		t.Errorf("handler returned wrong redirect location: got %v want %v", location, want)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// A split link has two or more variants and sends each visitor to one of
+// them, picked in proportion to the variants' weights, so the destinations
+// can be compared as an experiment.
+
+const (
+	maxVariants      = 10
+	maxVariantWeight = 10000
+)
+
+// normalizeVariants checks variants for a new split link and fills in the
+// defaults: unnamed variants are called "a", "b", "c" and so on, and an
+// unset weight counts as 1. Visit counts in the request are ignored.
+func normalizeVariants(variants []store.Variant) ([]store.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, fmt.Errorf("a split link needs between 2 and %d variants", maxVariants)
+	}
+
+	normalized := make([]store.Variant, len(variants))
+	names := make(map[string]bool)
+	for i, v := range variants {
+		if v.Name == "" {
+			v.Name = string(rune('a' + i))
+		}
+		if validateAlias(v.Name) != nil {
+			return nil, fmt.Errorf("variant names may only contain letters, digits, '-' and '_', up to %d characters", maxAliasLength)
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("variant %q is listed twice", v.Name)
+		}
+		names[v.Name] = true
+
+		if !validLongURL(v.URL) {
+			return nil, fmt.Errorf("invalid URL for variant %q", v.Name)
+		}
+		if len(v.URL) > 2048 {
+			return nil, fmt.Errorf("URL for variant %q is too long", v.Name)
+		}
+
+		if v.Weight == 0 {
+			v.Weight = 1
+		}
+		if v.Weight < 0 || v.Weight > maxVariantWeight {
+			return nil, fmt.Errorf("variant weights must be between 1 and %d", maxVariantWeight)
+		}
+		v.VisitCount = 0
+		normalized[i] = v
+	}
+	return normalized, nil
+}
+
+// pickVariant returns the variant that n falls on when the variants'
+// weights are laid end to end. n must be less than the total weight.
+func pickVariant(variants []store.Variant, n int) store.Variant {
+	for _, v := range variants {
+		if n < v.Weight {
+			return v
+		}
+		n -= v.Weight
+	}
+	return variants[len(variants)-1]
+}
+
+// variantFor picks the variant of shortURL to serve to this visitor. ok is
+// false for an ordinary link, or if the variants can't be read, in which
+// case the visitor gets the link's long URL.
+func (s *Server) variantFor(r *http.Request, shortURL string) (variant store.Variant, ok bool) {
+	variants, err := s.store.Variants(r.Context(), shortURL)
+	if err != nil {
+		logf(r.Context(), "Error fetching variants for short URL '%s': %v", shortURL, err)
+		return store.Variant{}, false
+	}
+	total := 0
+	for _, v := range variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return store.Variant{}, false
+	}
+
+	variant = pickVariant(variants, rand.Intn(total))
+	logf(r.Context(), "Serving variant '%s' of '%s': '%s'", variant.Name, shortURL, variant.URL)
+	return variant, true
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/store"
+)
+
+func TestNormalizeVariants(t *testing.T) {
+	variants, err := normalizeVariants([]store.Variant{
+		{URL: "https://example.com/a", VisitCount: 7},
+		{Name: "green", URL: "https://example.com/b", Weight: 3},
+	})
+	if err != nil {
+		t.Fatalf("normalizeVariants returned an error: %v", err)
+	}
+	want := []store.Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "green", URL: "https://example.com/b", Weight: 3},
+	}
+	if len(variants) != len(want) || variants[0] != want[0] || variants[1] != want[1] {
+		t.Errorf("normalizeVariants returned %+v want %+v", variants, want)
+	}
+
+	invalid := map[string][]store.Variant{
+		"One Variant":    {{URL: "https://example.com/a"}},
+		"Invalid URL":    {{URL: "https://example.com/a"}, {URL: "not a url"}},
+		"Script URL":     {{URL: "https://example.com/a"}, {URL: "javascript:alert(1)"}},
+		"Duplicate Name": {{Name: "x", URL: "https://example.com/a"}, {Name: "x", URL: "https://example.com/b"}},
+		"Invalid Name":   {{Name: "x y", URL: "https://example.com/a"}, {URL: "https://example.com/b"}},
+		"Bad Weight":     {{URL: "https://example.com/a", Weight: -1}, {URL: "https://example.com/b"}},
+	}
+	for name, variants := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := normalizeVariants(variants); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+func TestPickVariant(t *testing.T) {
+	variants := []store.Variant{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}}
+	for n, want := range []string{"a", "a", "a", "b"} {
+		if got := pickVariant(variants, n); got.Name != want {
+			t.Errorf("pickVariant(%d) returned wrong variant: got %v want %v", n, got.Name, want)
+		}
+	}
+}
+
+func TestRedirectVariants(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "split", "https://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	err := st.SetVariants(ctx, "split", []store.Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 0},
+		{Name: "b", URL: "https://example.com/b", Weight: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/split", nil))
+
+	if location := rr.Header().Get("Location"); location != "https://example.com/b" {
+		t.Errorf("handler returned wrong redirect location: got %v want %v", location, "https://example.com/b")
+	}
+	variants, err := st.Variants(ctx, "split")
+	if err != nil || len(variants) != 2 || variants[0].VisitCount != 0 || variants[1].VisitCount != 1 {
+		t.Errorf("variants have %+v, %v want one visit to b", variants, err)
+	}
+}
+
+func TestHandleAPILinksVariants(t *testing.T) {
+	srv, st := newMemoryServer(t)
+
+	t.Run("Valid", func(t *testing.T) {
+		body := `{"variants": [{"url": "https://example.com/a"}, {"url": "https://example.com/b", "weight": 2}]}`
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body)))
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+		}
+		var link linkResponse
+		if err := json.NewDecoder(rr.Body).Decode(&link); err != nil {
+			t.Fatal(err)
+		}
+		if len(link.Variants) != 2 || link.Variants[1].Name != "b" || link.Variants[1].Weight != 2 {
+			t.Errorf("handler returned wrong variants: %+v", link.Variants)
+		}
+		if variants, err := st.Variants(context.Background(), link.ShortURL); err != nil || len(variants) != 2 {
+			t.Errorf("store has variants %+v, %v", variants, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		body := `{"variants": [{"url": "https://example.com/a"}]}`
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/links", strings.NewReader(body)))
+
+		checkAPIError(t, rr, http.StatusBadRequest, errCodeInvalidVariants)
+	})
+}
+
+func TestLinkStatsEscapesVariants(t *testing.T) {
+	srv, st := newMemoryServer(t)
+	ctx := context.Background()
+	if err := st.Create(ctx, "split", "https://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	err := st.SetVariants(ctx, "split", []store.Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: `https://example.com/"><script>alert(1)</script>`, Weight: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/_/split/stats", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if body := rr.Body.String(); strings.Contains(body, "<script>alert") || !strings.Contains(body, "&lt;script&gt;alert(1)") {
+		t.Error("link stats page doesn't escape variant URLs")
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/donuts-are-good/shorty/outbound"
+)
+
+// verifyReachable rejects longURL when it clearly doesn't lead anywhere:
+// its host doesn't exist, it points at an internal address, it redirects
+// too often, or the server says the page is gone. Timeouts and server
+// errors are let through, since they are often temporary. Only http and
+// https URLs are checked.
+func verifyReachable(ctx context.Context, client *outbound.Client, longURL string) error {
+	u, err := url.Parse(longURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
+	}
+
+	status, err := client.Status(ctx, longURL)
+	if err != nil {
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			return fmt.Errorf("%s does not exist", u.Hostname())
+		case errors.Is(err, outbound.ErrBlockedAddress):
+			return outbound.ErrBlockedAddress
+		case errors.Is(err, outbound.ErrTooManyRedirects):
+			return outbound.ErrTooManyRedirects
+		}
+		return nil
+	}
+	if status == http.StatusNotFound || status == http.StatusGone {
+		return fmt.Errorf("destination returned %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
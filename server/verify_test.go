@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/outbound"
+)
+
+func TestVerifyReachable(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/error":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	t.Run("Internal Address", func(t *testing.T) {
+		client := outbound.New(outbound.Config{})
+		if err := verifyReachable(context.Background(), client, target.URL); err != outbound.ErrBlockedAddress {
+			t.Errorf("verifyReachable returned %v, want %v", err, outbound.ErrBlockedAddress)
+		}
+	})
+
+	// The test server listens on loopback, so the rest need a client that
+	// allows it.
+	client := outbound.New(outbound.Config{AllowPrivate: true})
+
+	tests := map[string]bool{
+		"/":      false,
+		"/error": false,
+		"/gone":  true,
+		"/loop":  true,
+	}
+	for path, wantErr := range tests {
+		err := verifyReachable(context.Background(), client, target.URL+path)
+		if (err != nil) != wantErr {
+			t.Errorf("verifyReachable(%q) returned %v, want error %v", path, err, wantErr)
+		}
+	}
+
+	if err := verifyReachable(context.Background(), client, "myapp://item/1"); err != nil {
+		t.Errorf("verifyReachable of a deep link returned %v", err)
+	}
+}
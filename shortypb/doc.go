@@ -0,0 +1,6 @@
+// Package shortypb holds the protobuf and gRPC definitions for shorty's
+// gRPC API. The .pb.go files are generated from shorty.proto; regenerate
+// them with `go generate` after editing it.
+package shortypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shorty.proto
@@ -0,0 +1,233 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.3
+// source: shorty.proto
+
+// Typed RPC access to shorty for internal services. It mirrors the JSON API
+// under /api/v1/.
+
+package shortypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Shorty_CreateLink_FullMethodName = "/shorty.v1.Shorty/CreateLink"
+	Shorty_ExpandLink_FullMethodName = "/shorty.v1.Shorty/ExpandLink"
+	Shorty_DeleteLink_FullMethodName = "/shorty.v1.Shorty/DeleteLink"
+	Shorty_GetStats_FullMethodName   = "/shorty.v1.Shorty/GetStats"
+)
+
+// ShortyClient is the client API for Shorty service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ShortyClient interface {
+	// CreateLink shortens a URL, optionally under a custom alias.
+	CreateLink(ctx context.Context, in *CreateLinkRequest, opts ...grpc.CallOption) (*Link, error)
+	// ExpandLink looks up where a short URL points without counting a visit.
+	ExpandLink(ctx context.Context, in *ExpandLinkRequest, opts ...grpc.CallOption) (*ExpandLinkResponse, error)
+	// DeleteLink removes a link. It requires the admin key as a bearer token
+	// in the "authorization" metadata.
+	DeleteLink(ctx context.Context, in *DeleteLinkRequest, opts ...grpc.CallOption) (*DeleteLinkResponse, error)
+	// GetStats returns a link and its visit count.
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Link, error)
+}
+
+type shortyClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewShortyClient(cc grpc.ClientConnInterface) ShortyClient {
+	return &shortyClient{cc}
+}
+
+func (c *shortyClient) CreateLink(ctx context.Context, in *CreateLinkRequest, opts ...grpc.CallOption) (*Link, error) {
+	out := new(Link)
+	err := c.cc.Invoke(ctx, Shorty_CreateLink_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) ExpandLink(ctx context.Context, in *ExpandLinkRequest, opts ...grpc.CallOption) (*ExpandLinkResponse, error) {
+	out := new(ExpandLinkResponse)
+	err := c.cc.Invoke(ctx, Shorty_ExpandLink_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) DeleteLink(ctx context.Context, in *DeleteLinkRequest, opts ...grpc.CallOption) (*DeleteLinkResponse, error) {
+	out := new(DeleteLinkResponse)
+	err := c.cc.Invoke(ctx, Shorty_DeleteLink_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortyClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Link, error) {
+	out := new(Link)
+	err := c.cc.Invoke(ctx, Shorty_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ShortyServer is the server API for Shorty service.
+// All implementations must embed UnimplementedShortyServer
+// for forward compatibility
+type ShortyServer interface {
+	// CreateLink shortens a URL, optionally under a custom alias.
+	CreateLink(context.Context, *CreateLinkRequest) (*Link, error)
+	// ExpandLink looks up where a short URL points without counting a visit.
+	ExpandLink(context.Context, *ExpandLinkRequest) (*ExpandLinkResponse, error)
+	// DeleteLink removes a link. It requires the admin key as a bearer token
+	// in the "authorization" metadata.
+	DeleteLink(context.Context, *DeleteLinkRequest) (*DeleteLinkResponse, error)
+	// GetStats returns a link and its visit count.
+	GetStats(context.Context, *GetStatsRequest) (*Link, error)
+	mustEmbedUnimplementedShortyServer()
+}
+
+// UnimplementedShortyServer must be embedded to have forward compatible implementations.
+type UnimplementedShortyServer struct {
+}
+
+func (UnimplementedShortyServer) CreateLink(context.Context, *CreateLinkRequest) (*Link, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateLink not implemented")
+}
+func (UnimplementedShortyServer) ExpandLink(context.Context, *ExpandLinkRequest) (*ExpandLinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExpandLink not implemented")
+}
+func (UnimplementedShortyServer) DeleteLink(context.Context, *DeleteLinkRequest) (*DeleteLinkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteLink not implemented")
+}
+func (UnimplementedShortyServer) GetStats(context.Context, *GetStatsRequest) (*Link, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedShortyServer) mustEmbedUnimplementedShortyServer() {}
+
+// UnsafeShortyServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ShortyServer will
+// result in compilation errors.
+type UnsafeShortyServer interface {
+	mustEmbedUnimplementedShortyServer()
+}
+
+func RegisterShortyServer(s grpc.ServiceRegistrar, srv ShortyServer) {
+	s.RegisterService(&Shorty_ServiceDesc, srv)
+}
+
+func _Shorty_CreateLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).CreateLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shorty_CreateLink_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).CreateLink(ctx, req.(*CreateLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_ExpandLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpandLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).ExpandLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shorty_ExpandLink_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).ExpandLink(ctx, req.(*ExpandLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_DeleteLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).DeleteLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shorty_DeleteLink_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).DeleteLink(ctx, req.(*DeleteLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shorty_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortyServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shorty_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortyServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Shorty_ServiceDesc is the grpc.ServiceDesc for Shorty service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Shorty_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "shorty.v1.Shorty",
+	HandlerType: (*ShortyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateLink",
+			Handler:    _Shorty_CreateLink_Handler,
+		},
+		{
+			MethodName: "ExpandLink",
+			Handler:    _Shorty_ExpandLink_Handler,
+		},
+		{
+			MethodName: "DeleteLink",
+			Handler:    _Shorty_DeleteLink_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Shorty_GetStats_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "shorty.proto",
+}
@@ -0,0 +1,157 @@
+// Package shortytest runs a real shorty server in the test process against
+// a throwaway SQLite database, so end-to-end tests can exercise the HTTP
+// interface without mocking SQL.
+//
+// A typical test looks like:
+//
+//	srv := shortytest.New(t, shortytest.Options{})
+//	srv.Seed(t, shortytest.Link{ShortURL: "abc123", LongURL: "https://example.com"})
+//	resp, err := srv.Client().Get(srv.URL + "/_/abc123")
+package shortytest
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	"github.com/donuts-are-good/shorty/server"
+	"github.com/donuts-are-good/shorty/store"
+)
+
+// Link is a row seeded into the server's url_mapping table.
+type Link struct {
+	ShortURL   string
+	LongURL    string
+	VisitCount int
+	CreatedAt  time.Time
+}
+
+// Options controls how the test server is configured. The zero value uses
+// the built-in theme and the short URL settings of the shipped
+// shorty.config.
+type Options struct {
+	// Dir is where theme overrides are read from, in its templates/ and
+	// static/ subdirectories. It defaults to a fresh temporary directory,
+	// so the built-in theme is used.
+	Dir string
+
+	// Length and Charset configure short URL generation. They default to
+	// the values in the shipped shorty.config.
+	Length  int
+	Charset string
+
+	// AdminKey is the API key accepted for admin calls such as deleting
+	// links. Admin calls are disabled when it is empty.
+	AdminKey string
+}
+
+// Server is a running shorty instance backed by a temporary database.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:54321.
+	URL string
+	// DBPath is the path of the SQLite database the server is using.
+	DBPath string
+
+	db *sql.DB
+}
+
+// New starts a shorty server in the test process, on an httptest server
+// with a free port, and registers its shutdown with t.Cleanup.
+func New(t testing.TB, opts Options) *Server {
+	t.Helper()
+
+	if opts.Length == 0 {
+		opts.Length = 8
+	}
+	if opts.Charset == "" {
+		opts.Charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	}
+	tmp := t.TempDir()
+	if opts.Dir == "" {
+		opts.Dir = tmp
+	}
+
+	s := &Server{DBPath: filepath.Join(tmp, "url_mapping.db")}
+	cfg := &config.Config{}
+	cfg.Database.Name = s.DBPath
+	cfg.ShortURL.Length = opts.Length
+	cfg.ShortURL.Charset = opts.Charset
+	cfg.Theme.Templates = filepath.Join(opts.Dir, "templates")
+	cfg.Theme.Static = filepath.Join(opts.Dir, "static")
+	cfg.API.AdminKey = opts.AdminKey
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		t.Fatalf("shortytest: failed to open database: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	srv, err := server.New(cfg, st)
+	if err != nil {
+		t.Fatalf("shortytest: failed to create server: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := srv.Start(ctx); err != nil {
+		cancel()
+		t.Fatalf("shortytest: failed to start server: %v", err)
+	}
+	ts := httptest.NewServer(srv)
+	s.URL = ts.URL
+	t.Cleanup(func() {
+		ts.Close()
+		cancel()
+		srv.Drain(context.Background())
+	})
+
+	s.db, err = store.OpenDB(cfg)
+	if err != nil {
+		t.Fatalf("shortytest: failed to open database: %v", err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+
+	return s
+}
+
+// Seed inserts links directly into the server's database.
+func (s *Server) Seed(t testing.TB, links ...Link) {
+	t.Helper()
+
+	for _, link := range links {
+		createdAt := link.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now().UTC()
+		}
+		_, err := s.db.Exec(`INSERT INTO url_mapping (short_url, long_url, visit_count, created_at) VALUES (?, ?, ?, ?)`,
+			link.ShortURL, link.LongURL, link.VisitCount, createdAt.UTC().Format(time.RFC3339))
+		if err != nil {
+			t.Fatalf("shortytest: failed to seed link '%s': %v", link.ShortURL, err)
+		}
+	}
+}
+
+// VisitCount returns the stored visit count for a short URL.
+func (s *Server) VisitCount(t testing.TB, shortURL string) int {
+	t.Helper()
+
+	var count int
+	err := s.db.QueryRow(`SELECT visit_count FROM url_mapping WHERE short_url = ?`, shortURL).Scan(&count)
+	if err != nil {
+		t.Fatalf("shortytest: failed to read visit count for '%s': %v", shortURL, err)
+	}
+	return count
+}
+
+// Client returns an HTTP client that does not follow redirects, so tests
+// can assert on the Location header shorty sends back.
+func (s *Server) Client() *http.Client {
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package shortytest
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedirectSeededLink(t *testing.T) {
+	srv := New(t, Options{})
+	srv.Seed(t, Link{ShortURL: "abc123", LongURL: "https://example.com"})
+
+	resp, err := srv.Client().Get(srv.URL + "/_/abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("redirect returned wrong status code: got %v want %v", resp.StatusCode, http.StatusFound)
+	}
+	if location := resp.Header.Get("Location"); location != "https://example.com" {
+		t.Errorf("redirect returned wrong location: got %v want %v", location, "https://example.com")
+	}
+	if count := srv.VisitCount(t, "abc123"); count != 1 {
+		t.Errorf("visit count was not incremented: got %v want %v", count, 1)
+	}
+}
+
+func TestCreateLink(t *testing.T) {
+	srv := New(t, Options{Length: 6})
+
+	token := strings.Repeat("ab", 32)
+	form := url.Values{"url": {"https://example.org/some/page"}, "csrf_token": {token}}
+	req, err := http.NewRequest("POST", srv.URL+"/create", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "shorty_csrf", Value: token})
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("create returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestThemeOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "static", "condensed.css"), []byte("body { color: red; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(t, Options{Dir: dir})
+
+	resp, err := srv.Client().Get(srv.URL + "/static/condensed.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "body { color: red; }" {
+		t.Errorf("static override was not served: got %q", body)
+	}
+
+	resp, err = srv.Client().Get(srv.URL + "/static/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("default static file was not served: got status %v", resp.StatusCode)
+	}
+}
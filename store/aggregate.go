@@ -0,0 +1,264 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The backends without SQL, Memory and Bolt, work out with these what
+// SQLite's queries compute, so all three agree on every figure.
+
+// storedTime drops what SQLite wouldn't keep of t: its zone and anything
+// below a second.
+func storedTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+func storedNow() time.Time {
+	return storedTime(time.Now())
+}
+
+// limited returns how many of n items a SQL LIMIT of limit keeps. A
+// negative limit keeps them all.
+func limited(n, limit int) int {
+	if limit >= 0 && n > limit {
+		return limit
+	}
+	return n
+}
+
+// mostCommon returns the value counted most often, the first by name on a
+// tie, or "" if there are none.
+func mostCommon(counts map[string]int) string {
+	var top string
+	for value, n := range counts {
+		if top == "" || n > counts[top] || (n == counts[top] && value < top) {
+			top = value
+		}
+	}
+	return top
+}
+
+// humanClicks returns the clicks by people on shortURL, or on every link
+// when shortURL is empty, in the order given.
+func humanClicks(clicks []Click, shortURL string) []Click {
+	var human []Click
+	for _, click := range clicks {
+		if click.Bot == "" && (shortURL == "" || click.ShortURL == shortURL) {
+			human = append(human, click)
+		}
+	}
+	return human
+}
+
+// sortedTags returns tags sorted and without repeats, as SQLite keeps
+// them.
+func sortedTags(tags []string) []string {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, tag := range sorted {
+		if i == 0 || tag != sorted[i-1] {
+			unique = append(unique, tag)
+		}
+	}
+	return unique
+}
+
+// hasTag reports whether the sorted tags include tag.
+func hasTag(tags []string, tag string) bool {
+	i := sort.SearchStrings(tags, tag)
+	return i < len(tags) && tags[i] == tag
+}
+
+type rollupKey struct {
+	shortURL string
+	day      string
+}
+
+// summarizeDays rolls clicks up into one ClickRollup per link and day.
+// Bots' clicks are left out.
+func summarizeDays(clicks []Click) map[rollupKey]*ClickRollup {
+	type tally struct {
+		ips       map[string]bool
+		referrers map[string]int
+		countries map[string]int
+	}
+	rollups := make(map[rollupKey]*ClickRollup)
+	tallies := make(map[rollupKey]*tally)
+	for _, click := range humanClicks(clicks, "") {
+		key := rollupKey{click.ShortURL, click.At.Format("2006-01-02")}
+		rollup, t := rollups[key], tallies[key]
+		if rollup == nil {
+			day, _ := time.Parse("2006-01-02", key.day)
+			rollup = &ClickRollup{ShortURL: click.ShortURL, Day: day}
+			t = &tally{ips: map[string]bool{}, referrers: map[string]int{}, countries: map[string]int{}}
+			rollups[key], tallies[key] = rollup, t
+		}
+		rollup.Clicks++
+		t.ips[click.IP] = true
+		if click.Referrer != "" {
+			t.referrers[click.Referrer]++
+		}
+		if click.Country != "" {
+			t.countries[click.Country]++
+		}
+	}
+	for key, rollup := range rollups {
+		t := tallies[key]
+		rollup.Uniques = len(t.ips)
+		rollup.TopReferrer = mostCommon(t.referrers)
+		rollup.TopCountry = mostCommon(t.countries)
+	}
+	return rollups
+}
+
+// sortRollups orders rollups by day, then by short URL.
+func sortRollups(rollups []ClickRollup) {
+	sort.Slice(rollups, func(i, j int) bool {
+		if !rollups[i].Day.Equal(rollups[j].Day) {
+			return rollups[i].Day.Before(rollups[j].Day)
+		}
+		return rollups[i].ShortURL < rollups[j].ShortURL
+	})
+}
+
+// topLinks adds up the clicks by people since since and the rollups of the
+// days since then, per link, and returns up to limit links most clicked
+// first. longURL looks up a link, and reports false for one that is
+// missing or trashed, which is left out.
+func topLinks(clicks []Click, rollups []ClickRollup, since time.Time, limit int, longURL func(shortURL string) (string, bool)) []LinkClicks {
+	since = storedTime(since)
+	sinceDay := since.Format("2006-01-02")
+	counts := make(map[string]int)
+	for _, click := range humanClicks(clicks, "") {
+		if !click.At.Before(since) {
+			counts[click.ShortURL]++
+		}
+	}
+	for _, rollup := range rollups {
+		if rollup.Day.Format("2006-01-02") >= sinceDay {
+			counts[rollup.ShortURL] += rollup.Clicks
+		}
+	}
+
+	var top []LinkClicks
+	for shortURL, n := range counts {
+		if long, ok := longURL(shortURL); ok {
+			top = append(top, LinkClicks{ShortURL: shortURL, LongURL: long, Clicks: n})
+		}
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Clicks != top[j].Clicks {
+			return top[i].Clicks > top[j].Clicks
+		}
+		return top[i].ShortURL < top[j].ShortURL
+	})
+	return top[:limited(len(top), limit)]
+}
+
+// clickSeries counts clicks and, for daily series, rollups since since per
+// interval, oldest first. The caller picks the link.
+func clickSeries(clicks []Click, rollups []ClickRollup, since time.Time, interval Interval) ([]ClickCount, error) {
+	var truncate func(time.Time) time.Time
+	switch interval {
+	case Hourly:
+		truncate = func(t time.Time) time.Time { return t.Truncate(time.Hour) }
+	case Daily:
+		truncate = func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC) }
+	default:
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+
+	since = storedTime(since)
+	buckets := make(map[time.Time]int)
+	for _, click := range humanClicks(clicks, "") {
+		if !click.At.Before(since) {
+			buckets[truncate(click.At)]++
+		}
+	}
+	// Rolled up days have no hours, so only daily series read them.
+	if interval == Daily {
+		sinceDay := since.Format("2006-01-02")
+		for _, rollup := range rollups {
+			if rollup.Day.Format("2006-01-02") >= sinceDay {
+				buckets[rollup.Day] += rollup.Clicks
+			}
+		}
+	}
+
+	var series []ClickCount
+	for t, n := range buckets {
+		series = append(series, ClickCount{Time: t, Clicks: n})
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+	return series, nil
+}
+
+// clickHeatmap counts the clicks by people by weekday and hour.
+func clickHeatmap(clicks []Click) Heatmap {
+	var heatmap Heatmap
+	for _, click := range humanClicks(clicks, "") {
+		heatmap[click.At.Weekday()][click.At.Hour()]++
+	}
+	return heatmap
+}
+
+// clickBursts finds the links with at least minClicks clicks by people
+// from at most maxIPs addresses since since, busiest first.
+func clickBursts(clicks []Click, since time.Time, minClicks, maxIPs int) []Anomaly {
+	since = storedTime(since)
+	counts := make(map[string]int)
+	ips := make(map[string]map[string]bool)
+	for _, click := range humanClicks(clicks, "") {
+		if click.At.Before(since) {
+			continue
+		}
+		counts[click.ShortURL]++
+		if ips[click.ShortURL] == nil {
+			ips[click.ShortURL] = make(map[string]bool)
+		}
+		ips[click.ShortURL][click.IP] = true
+	}
+
+	var bursts []Anomaly
+	for shortURL, n := range counts {
+		if n >= minClicks && len(ips[shortURL]) <= maxIPs {
+			bursts = append(bursts, Anomaly{ShortURL: shortURL, Clicks: n, UniqueIPs: len(ips[shortURL]), WindowStart: since})
+		}
+	}
+	sort.Slice(bursts, func(i, j int) bool {
+		if bursts[i].Clicks != bursts[j].Clicks {
+			return bursts[i].Clicks > bursts[j].Clicks
+		}
+		return bursts[i].ShortURL < bursts[j].ShortURL
+	})
+	return bursts
+}
+
+// linkStatsFigures works out the stats page from every live link, oldest
+// first.
+func linkStatsFigures(links []LinkStats) Stats {
+	var stats Stats
+	// Clicks on the links created today, by the local date as on SQLite.
+	today := time.Now()
+	start, end := today.Format("2006-01-02"), today.AddDate(0, 0, 1).Format("2006-01-02")
+	for _, link := range links {
+		stats.TotalLinks++
+		stats.TotalClicks += link.VisitCount
+		if created := link.CreatedAt.Format("2006-01-02 15:04:05"); created >= start && created < end {
+			stats.ClicksToday += link.VisitCount
+		}
+	}
+
+	popular := append([]LinkStats(nil), links...)
+	sort.SliceStable(popular, func(i, j int) bool { return popular[i].VisitCount > popular[j].VisitCount })
+	stats.PopularLinks = popular[:limited(len(popular), statsLinks)]
+	stats.MostClickedLinks = stats.PopularLinks
+
+	recent := append([]LinkStats(nil), links...)
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].CreatedAt.After(recent[j].CreatedAt) })
+	stats.RecentLinks = recent[:limited(len(recent), statsLinks)]
+	return stats
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Backups use SQLite's online backup API where the driver exposes it, which
+// copies a consistent snapshot page by page while the server keeps running.
+// Copying the live file with cp can capture a half-written transaction.
+// Other drivers, such as the pure-Go one, get VACUUM INTO, which writes a
+// consistent, compacted copy from a single read transaction.
+
+// Backup writes a consistent snapshot of db to destPath.
+func Backup(ctx context.Context, db *sql.DB, destPath string) error {
+	if ok, err := onlineBackup(ctx, db, destPath); ok {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `VACUUM INTO ?`, destPath)
+	return err
+}
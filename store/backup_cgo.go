@@ -0,0 +1,63 @@
+//go:build cgo
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// onlineBackup copies db to destPath with go-sqlite3's backup API. It
+// reports false, leaving the backup to the caller, when db was opened with
+// another driver.
+func onlineBackup(ctx context.Context, db *sql.DB, destPath string) (bool, error) {
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return true, err
+	}
+	defer srcConn.Close()
+
+	var ok bool
+	srcConn.Raw(func(srcRaw interface{}) error {
+		_, ok = srcRaw.(*sqlite3.SQLiteConn)
+		return nil
+	})
+	if !ok {
+		return false, nil
+	}
+
+	destDB, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return true, err
+	}
+	defer destDB.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return true, err
+	}
+	defer destConn.Close()
+
+	return true, destConn.Raw(func(destRaw interface{}) error {
+		return srcConn.Raw(func(srcRaw interface{}) error {
+			dest, ok := destRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("backup destination is not a SQLite connection")
+			}
+			src := srcRaw.(*sqlite3.SQLiteConn)
+
+			backup, err := dest.Backup("main", src, "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !cgo
+
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// go-sqlite3 and its backup API only exist in builds with cgo, so every
+// backup goes through VACUUM INTO.
+func onlineBackup(ctx context.Context, db *sql.DB, destPath string) (bool, error) {
+	return false, nil
+}
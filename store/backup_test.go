@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	srcDB := openTestDB(t)
+	if _, err := Migrate(srcDB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcDB.Exec(`INSERT INTO url_mapping (short_url, long_url) VALUES ('abc123', 'https://example.com')`); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := Backup(context.Background(), srcDB, path); err != nil {
+		t.Fatalf("Backup returned an error: %v", err)
+	}
+
+	backupDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backupDB.Close()
+
+	var longURL string
+	if err := backupDB.QueryRow(`SELECT long_url FROM url_mapping WHERE short_url = 'abc123'`).Scan(&longURL); err != nil {
+		t.Fatalf("Backup is missing the seeded link: %v", err)
+	}
+	if longURL != "https://example.com" {
+		t.Errorf("Backup returned wrong long URL: got %v want %v", longURL, "https://example.com")
+	}
+}
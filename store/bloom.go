@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// BloomFiltered is a Store that keeps a Bloom filter of every short URL in
+// memory. A short URL the filter has never seen certainly doesn't exist, so
+// LongURL and Exists answer it without a query: a redirect to a mistyped or
+// made-up code costs nothing, and so does checking that a new random code
+// is free. Codes the filter has seen are looked up as usual.
+//
+// The filter only learns about links created through it. It must be the
+// only writer of the store: with other replicas or programs adding links,
+// their links would look missing.
+type BloomFiltered struct {
+	Store
+
+	mu sync.RWMutex
+	// layers grow the filter as links are added: when the newest layer is
+	// full, a new one twice its size takes the new codes.
+	layers []*bloomLayer
+}
+
+const (
+	// bloomBitsPerItem and bloomHashes give about a 1% false positive rate
+	// per layer.
+	bloomBitsPerItem = 10
+	bloomHashes      = 7
+	minBloomCapacity = 1 << 16
+)
+
+// NewBloomFiltered loads every short URL in st, trashed ones included, into
+// a new filter.
+func NewBloomFiltered(ctx context.Context, st Store) (*BloomFiltered, error) {
+	var codes []string
+	if err := st.ForEachShortURL(ctx, func(shortURL string) {
+		codes = append(codes, shortURL)
+	}); err != nil {
+		return nil, err
+	}
+
+	capacity := 2 * len(codes)
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+	b := &BloomFiltered{Store: st, layers: []*bloomLayer{newBloomLayer(capacity)}}
+	for _, code := range codes {
+		b.add(code)
+	}
+	return b, nil
+}
+
+// Unwrap returns the store behind the filter.
+func (b *BloomFiltered) Unwrap() Store {
+	return b.Store
+}
+
+func (b *BloomFiltered) add(shortURL string) {
+	h1, h2 := bloomHash(shortURL)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	layer := b.layers[len(b.layers)-1]
+	if layer.n >= layer.capacity {
+		layer = newBloomLayer(2 * layer.capacity)
+		b.layers = append(b.layers, layer)
+	}
+	layer.add(h1, h2)
+}
+
+// mayContain reports whether shortURL may exist. False means it doesn't.
+func (b *BloomFiltered) mayContain(shortURL string) bool {
+	h1, h2 := bloomHash(shortURL)
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, layer := range b.layers {
+		if layer.contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BloomFiltered) LongURL(ctx context.Context, shortURL string) (string, error) {
+	if !b.mayContain(shortURL) {
+		return "", ErrNotFound
+	}
+	return b.Store.LongURL(ctx, shortURL)
+}
+
+func (b *BloomFiltered) Exists(ctx context.Context, shortURL string) (bool, error) {
+	if !b.mayContain(shortURL) {
+		return false, nil
+	}
+	return b.Store.Exists(ctx, shortURL)
+}
+
+func (b *BloomFiltered) Create(ctx context.Context, shortURL, longURL string) error {
+	err := b.Store.Create(ctx, shortURL, longURL)
+	if err == nil || err == ErrExists {
+		b.add(shortURL)
+	}
+	return err
+}
+
+// bloomLayer is a plain Bloom filter sized for capacity codes.
+type bloomLayer struct {
+	bits     []uint64
+	m        uint64
+	n        int
+	capacity int
+}
+
+func newBloomLayer(capacity int) *bloomLayer {
+	m := uint64(capacity) * bloomBitsPerItem
+	return &bloomLayer{bits: make([]uint64, (m+63)/64), m: m, capacity: capacity}
+}
+
+func (l *bloomLayer) add(h1, h2 uint64) {
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % l.m
+		l.bits[bit/64] |= 1 << (bit % 64)
+	}
+	l.n++
+}
+
+func (l *bloomLayer) contains(h1, h2 uint64) bool {
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % l.m
+		if l.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHash returns the two halves of the 128-bit FNV-1a hash of s, which
+// combine into the filter's hash functions.
+func bloomHash(s string) (h1, h2 uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+	for i := 0; i < 8; i++ {
+		h1 = h1<<8 | uint64(sum[i])
+		h2 = h2<<8 | uint64(sum[8+i])
+	}
+	// An even step could cycle through only some of the bits.
+	return h1, h2 | 1
+}
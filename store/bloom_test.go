@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestBloomLayer(t *testing.T) {
+	layer := newBloomLayer(1000)
+	for i := 0; i < 1000; i++ {
+		layer.add(bloomHash(fmt.Sprintf("code%d", i)))
+	}
+	for i := 0; i < 1000; i++ {
+		if !layer.contains(bloomHash(fmt.Sprintf("code%d", i))) {
+			t.Fatalf("filter lost code%d", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if layer.contains(bloomHash(fmt.Sprintf("other%d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("filter matched %d of 10000 unknown codes, want about 1%%", falsePositives)
+	}
+}
+
+func TestBloomFiltered(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	if err := s.Create(ctx, "before", "https://example.com/before"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Trash(ctx, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewBloomFiltered(ctx, s)
+	if err != nil {
+		t.Fatalf("NewBloomFiltered returned an error: %v", err)
+	}
+	// Trashed codes stay taken.
+	if exists, err := b.Exists(ctx, "before"); err != nil || !exists {
+		t.Errorf("Exists of a trashed code returned %v, %v", exists, err)
+	}
+	if exists, err := b.Exists(ctx, "missing"); err != nil || exists {
+		t.Errorf("Exists of an unknown code returned %v, %v", exists, err)
+	}
+	if _, err := b.LongURL(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("LongURL of an unknown code returned %v, want ErrNotFound", err)
+	}
+
+	if err := b.Create(ctx, "after", "https://example.com/after"); err != nil {
+		t.Fatal(err)
+	}
+	if longURL, err := b.LongURL(ctx, "after"); err != nil || longURL != "https://example.com/after" {
+		t.Errorf("LongURL of a new link returned %q, %v", longURL, err)
+	}
+	// Links added behind the filter's back are missed; that is why it has
+	// to be the only writer.
+	if err := s.Create(ctx, "behind", "https://example.com/behind"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.LongURL(ctx, "behind"); err != ErrNotFound {
+		t.Errorf("LongURL of a link added behind the filter returned %v, want ErrNotFound", err)
+	}
+	if b.Unwrap() != s {
+		t.Error("Unwrap didn't return the filtered store")
+	}
+}
+
+func TestBloomFilteredGrows(t *testing.T) {
+	b := &BloomFiltered{layers: []*bloomLayer{newBloomLayer(10)}}
+	for i := 0; i < 25; i++ {
+		b.add(fmt.Sprintf("code%d", i))
+	}
+	if len(b.layers) != 2 {
+		t.Errorf("filter has %d layers after 25 codes, want 2", len(b.layers))
+	}
+	for i := 0; i < 25; i++ {
+		if !b.mayContain(fmt.Sprintf("code%d", i)) {
+			t.Errorf("filter lost code%d", i)
+		}
+	}
+}
@@ -0,0 +1,1735 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt is a Store kept in a single bbolt file. It is pure Go, so a shorty
+// that only uses Bolt builds without cgo and cross-compiles like any other
+// Go program.
+//
+// Links are looked up by key, but Bolt has no query planner: the stats,
+// the link lists and every click figure read the whole bucket they need.
+// It suits small and medium instances; SQLite does better with millions of
+// links or clicks.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// Buckets of a Bolt file.
+var (
+	boltLinks     = []byte("links")
+	boltLongURLs  = []byte("long_urls")
+	boltVariants  = []byte("variants")
+	boltTags      = []byte("tags")
+	boltHealth    = []byte("health")
+	boltAudit     = []byte("audit_log")
+	boltClicks    = []byte("clicks")
+	boltRollups   = []byte("click_rollups")
+	boltAnomalies = []byte("anomalies")
+	boltAlerts    = []byte("alerts")
+	boltLeases    = []byte("leases")
+	boltSequence  = []byte("short_url_sequence")
+	boltBanned    = []byte("banned_domains")
+	boltTakedowns = []byte("takedowns")
+	boltUsage     = []byte("api_key_usage")
+	boltTeams     = []byte("teams")
+	boltMembers   = []byte("team_members")
+	boltSessions  = []byte("session_links")
+)
+
+var boltBuckets = [][]byte{boltLinks, boltLongURLs, boltVariants, boltTags, boltHealth, boltAudit, boltClicks, boltRollups, boltAnomalies, boltAlerts, boltLeases, boltSequence, boltBanned, boltTakedowns, boltUsage, boltTeams, boltMembers, boltSessions}
+
+// boltLink is a link as it is stored in the links bucket.
+type boltLink struct {
+	LongURL    string    `json:"longURL"`
+	VisitCount int       `json:"visitCount"`
+	BotVisits  int       `json:"botVisits"`
+	CreatedAt  time.Time `json:"createdAt"`
+	DeletedAt  time.Time `json:"deletedAt"`
+	// Seq orders links by creation, as SQLite's rowid does.
+	Seq      uint64    `json:"seq"`
+	Targets  Targets   `json:"targets"`
+	Card     Card      `json:"card"`
+	Options  Options   `json:"options"`
+	Disabled bool      `json:"disabled"`
+	Flag     *boltFlag `json:"flag,omitempty"`
+	// Takedown is the ID of the notice the link was taken down under.
+	Takedown int64 `json:"takedown,omitempty"`
+	// APIKey names the API key the link was created with.
+	APIKey string `json:"apiKey,omitempty"`
+	// Team owns the link.
+	Team string `json:"team,omitempty"`
+	// Owner is the account that created the link.
+	Owner string `json:"owner,omitempty"`
+}
+
+// boltFlag is set while a link waits in the moderation queue.
+type boltFlag struct {
+	Source    string    `json:"source"`
+	Reason    string    `json:"reason"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+func (l *boltLink) trashed() bool {
+	return !l.DeletedAt.IsZero()
+}
+
+func (l *boltLink) stats(shortURL string) LinkStats {
+	return LinkStats{ShortURL: shortURL, LongURL: l.LongURL, VisitCount: l.VisitCount, CreatedAt: l.CreatedAt}
+}
+
+func (l *boltLink) options() Options {
+	opts := l.Options
+	opts.Disabled = l.Disabled
+	opts.Flagged = l.Flag != nil
+	opts.TakenDown = l.Takedown != 0
+	opts.APIKey = l.APIKey
+	return opts
+}
+
+type boltLease struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// OpenBolt opens the Bolt file at path, creating it if needed. It waits up
+// to a second for another process holding the file to let go.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt buckets: %v", err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Close closes the Bolt file.
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+// boltKey encodes an ID so keys sort in numeric order.
+func boltKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// longURLKey indexes a link under its long URL, in creation order.
+func longURLKey(longURL string, seq uint64) []byte {
+	return append(append([]byte(longURL), 0), boltKey(seq)...)
+}
+
+// rollupBoltKey keys a rollup by link and day, so one link's rollups sit
+// together.
+func rollupBoltKey(shortURL, day string) []byte {
+	return []byte(shortURL + "\x00" + day)
+}
+
+func getJSON(bucket *bolt.Bucket, key []byte, v interface{}) (bool, error) {
+	data := bucket.Get(key)
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("error decoding record '%s': %v", key, err)
+	}
+	return true, nil
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// appendJSON stores v under the bucket's next sequence number and returns
+// it.
+func appendJSON(bucket *bolt.Bucket, v interface{}) (uint64, error) {
+	id, err := bucket.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	return id, putJSON(bucket, boltKey(id), v)
+}
+
+// getLink returns the stored link at shortURL, or nil if there is none.
+func getLink(tx *bolt.Tx, shortURL string) (*boltLink, error) {
+	var l boltLink
+	found, err := getJSON(tx.Bucket(boltLinks), []byte(shortURL), &l)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// getLiveLink returns the link at shortURL unless it is missing or trashed.
+func getLiveLink(tx *bolt.Tx, shortURL string) (*boltLink, error) {
+	l, err := getLink(tx, shortURL)
+	if err != nil || l == nil || l.trashed() {
+		return nil, err
+	}
+	return l, nil
+}
+
+func putLink(tx *bolt.Tx, shortURL string, l *boltLink) error {
+	return putJSON(tx.Bucket(boltLinks), []byte(shortURL), l)
+}
+
+// updateLink applies change to the link at shortURL and reports whether
+// there was one. change returns false to leave the link as it was.
+func (b *Bolt) updateLink(shortURL string, change func(l *boltLink) bool) (bool, error) {
+	var changed bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		l, err := getLink(tx, shortURL)
+		if err != nil || l == nil || !change(l) {
+			return err
+		}
+		changed = true
+		return putLink(tx, shortURL, l)
+	})
+	return changed, err
+}
+
+// forEachLink calls fn with every link, oldest first.
+func forEachLink(tx *bolt.Tx, fn func(shortURL string, l *boltLink)) error {
+	type entry struct {
+		shortURL string
+		link     *boltLink
+	}
+	var links []entry
+	err := tx.Bucket(boltLinks).ForEach(func(k, v []byte) error {
+		var l boltLink
+		if err := json.Unmarshal(v, &l); err != nil {
+			return fmt.Errorf("error decoding link '%s': %v", k, err)
+		}
+		links = append(links, entry{string(k), &l})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].link.Seq < links[j].link.Seq })
+	for _, e := range links {
+		fn(e.shortURL, e.link)
+	}
+	return nil
+}
+
+// liveLinks returns every link that isn't trashed, oldest first.
+func liveLinks(tx *bolt.Tx) ([]LinkStats, error) {
+	var links []LinkStats
+	err := forEachLink(tx, func(shortURL string, l *boltLink) {
+		if !l.trashed() {
+			links = append(links, l.stats(shortURL))
+		}
+	})
+	return links, err
+}
+
+// readAll calls fn with the ID and data of every record of a bucket keyed
+// by ID, in ID order.
+func readAll(bucket *bolt.Bucket, fn func(id uint64, data []byte) error) error {
+	return bucket.ForEach(func(k, v []byte) error {
+		return fn(binary.BigEndian.Uint64(k), v)
+	})
+}
+
+func allClicks(tx *bolt.Tx) ([]Click, error) {
+	var clicks []Click
+	err := readAll(tx.Bucket(boltClicks), func(id uint64, data []byte) error {
+		var click Click
+		if err := json.Unmarshal(data, &boltClick{&click}); err != nil {
+			return fmt.Errorf("error decoding click %d: %v", id, err)
+		}
+		clicks = append(clicks, click)
+		return nil
+	})
+	return clicks, err
+}
+
+// boltClick stores a Click with its IP, which Click leaves out of JSON.
+type boltClick struct {
+	*Click
+}
+
+func (c boltClick) MarshalJSON() ([]byte, error) {
+	type plain Click
+	return json.Marshal(struct {
+		*plain
+		IP string `json:"ip,omitempty"`
+	}{(*plain)(c.Click), c.IP})
+}
+
+func (c *boltClick) UnmarshalJSON(data []byte) error {
+	type plain Click
+	v := struct {
+		*plain
+		IP string `json:"ip,omitempty"`
+	}{plain: (*plain)(c.Click)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	c.IP = v.IP
+	return nil
+}
+
+func allRollups(tx *bolt.Tx, shortURL string) ([]ClickRollup, error) {
+	var rollups []ClickRollup
+	err := tx.Bucket(boltRollups).ForEach(func(k, v []byte) error {
+		var rollup ClickRollup
+		if err := json.Unmarshal(v, &rollup); err != nil {
+			return fmt.Errorf("error decoding rollup '%s': %v", k, err)
+		}
+		if shortURL == "" || rollup.ShortURL == shortURL {
+			rollups = append(rollups, rollup)
+		}
+		return nil
+	})
+	return rollups, err
+}
+
+func (b *Bolt) LongURL(ctx context.Context, shortURL string) (string, error) {
+	var longURL string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getLiveLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		longURL = l.LongURL
+		return nil
+	})
+	return longURL, err
+}
+
+func (b *Bolt) ShortURLFor(ctx context.Context, longURL string) (string, error) {
+	var shortURL string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := append([]byte(longURL), 0)
+		c := tx.Bucket(boltLongURLs).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			l, err := getLiveLink(tx, string(v))
+			if err != nil {
+				return err
+			}
+			if l != nil && !l.Disabled && !l.Options.Expired(time.Now()) {
+				shortURL = string(v)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return shortURL, err
+}
+
+func (b *Bolt) Exists(ctx context.Context, shortURL string) (bool, error) {
+	var exists bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket(boltLinks).Get([]byte(shortURL)) != nil
+		return nil
+	})
+	return exists, err
+}
+
+func (b *Bolt) NextID(ctx context.Context) (uint64, error) {
+	var id uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = tx.Bucket(boltSequence).NextSequence()
+		return err
+	})
+	return id, err
+}
+
+func (b *Bolt) Create(ctx context.Context, shortURL, longURL string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		links := tx.Bucket(boltLinks)
+		if links.Get([]byte(shortURL)) != nil {
+			return ErrExists
+		}
+		seq, err := links.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltLongURLs).Put(longURLKey(longURL, seq), []byte(shortURL)); err != nil {
+			return err
+		}
+		return putLink(tx, shortURL, &boltLink{LongURL: longURL, CreatedAt: storedNow(), Seq: seq})
+	})
+}
+
+func (b *Bolt) Delete(ctx context.Context, shortURL string) (bool, error) {
+	var deleted bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		l, err := getLink(tx, shortURL)
+		if err != nil || l == nil {
+			return err
+		}
+		deleted = true
+		return deleteLink(tx, shortURL, l)
+	})
+	return deleted, err
+}
+
+// deleteLink removes a link and what belongs to it, as the SQLite triggers
+// do.
+func deleteLink(tx *bolt.Tx, shortURL string, l *boltLink) error {
+	if err := tx.Bucket(boltLinks).Delete([]byte(shortURL)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(boltLongURLs).Delete(longURLKey(l.LongURL, l.Seq)); err != nil {
+		return err
+	}
+	for _, bucket := range [][]byte{boltVariants, boltTags, boltHealth} {
+		if err := tx.Bucket(bucket).Delete([]byte(shortURL)); err != nil {
+			return err
+		}
+	}
+
+	// Deleting under a cursor skips keys, so collect them first.
+	var stale [][]byte
+	prefix := []byte(shortURL + "\x00")
+	c := tx.Bucket(boltRollups).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		stale = append(stale, k)
+	}
+	if err := deleteKeys(tx.Bucket(boltRollups), stale); err != nil {
+		return err
+	}
+	for _, bucket := range [][]byte{boltClicks, boltAlerts} {
+		stale = stale[:0]
+		err := tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			var owner struct {
+				ShortURL string `json:"shortURL"`
+			}
+			if err := json.Unmarshal(v, &owner); err != nil {
+				return err
+			}
+			if owner.ShortURL == shortURL {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := deleteKeys(tx.Bucket(bucket), stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteKeys(bucket *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Trash(ctx context.Context, shortURL string) (bool, error) {
+	return b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.trashed() {
+			return false
+		}
+		l.DeletedAt = storedNow()
+		return true
+	})
+}
+
+func (b *Bolt) Restore(ctx context.Context, shortURL string) error {
+	restored, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		if !l.trashed() {
+			return false
+		}
+		l.DeletedAt = time.Time{}
+		return true
+	})
+	if err == nil && !restored {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) Trashed(ctx context.Context) ([]TrashedLink, error) {
+	var trashed []TrashedLink
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return forEachLink(tx, func(shortURL string, l *boltLink) {
+			if l.trashed() {
+				trashed = append(trashed, TrashedLink{LinkStats: l.stats(shortURL), DeletedAt: l.DeletedAt})
+			}
+		})
+	})
+	sort.SliceStable(trashed, func(i, j int) bool { return trashed[i].DeletedAt.After(trashed[j].DeletedAt) })
+	return trashed, err
+}
+
+func (b *Bolt) PurgeTrash(ctx context.Context, cutoff time.Time) (int64, error) {
+	cutoff = storedTime(cutoff)
+	var purged int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		expired := make(map[string]*boltLink)
+		err := forEachLink(tx, func(shortURL string, l *boltLink) {
+			if l.trashed() && l.DeletedAt.Before(cutoff) {
+				expired[shortURL] = l
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for shortURL, l := range expired {
+			if err := deleteLink(tx, shortURL, l); err != nil {
+				return err
+			}
+			purged++
+		}
+		return nil
+	})
+	return purged, err
+}
+
+func (b *Bolt) SetActive(ctx context.Context, shortURL string, active bool) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.trashed() {
+			return false
+		}
+		l.Disabled = !active
+		if active {
+			l.Takedown = 0
+		}
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) SetLongURL(ctx context.Context, shortURL, longURL string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		l, err := getLiveLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		index := tx.Bucket(boltLongURLs)
+		if err := index.Delete(longURLKey(l.LongURL, l.Seq)); err != nil {
+			return err
+		}
+		if err := index.Put(longURLKey(longURL, l.Seq), []byte(shortURL)); err != nil {
+			return err
+		}
+		l.LongURL = longURL
+		return putLink(tx, shortURL, l)
+	})
+}
+
+// recordVisit counts a visit with count unless the link is missing or has
+// reached its MaxClicks. Visits come with every redirect, so they are
+// batched into shared transactions.
+func (b *Bolt) recordVisit(shortURL string, count func(l *boltLink)) (bool, error) {
+	var counted bool
+	err := b.db.Batch(func(tx *bolt.Tx) error {
+		counted = false
+		l, err := getLink(tx, shortURL)
+		if err != nil || l == nil {
+			return err
+		}
+		if l.Options.MaxClicks > 0 && l.VisitCount >= l.Options.MaxClicks {
+			return nil
+		}
+		count(l)
+		counted = true
+		return putLink(tx, shortURL, l)
+	})
+	return counted, err
+}
+
+func (b *Bolt) RecordVisit(ctx context.Context, shortURL string) (bool, error) {
+	return b.recordVisit(shortURL, func(l *boltLink) { l.VisitCount++ })
+}
+
+func (b *Bolt) RecordBotVisit(ctx context.Context, shortURL string) (bool, error) {
+	return b.recordVisit(shortURL, func(l *boltLink) { l.BotVisits++ })
+}
+
+func (b *Bolt) BotVisits(ctx context.Context, shortURL string) (int, error) {
+	var visits int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getLiveLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		visits = l.BotVisits
+		return nil
+	})
+	return visits, err
+}
+
+func (b *Bolt) Targets(ctx context.Context, shortURL string) (Targets, error) {
+	var targets Targets
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		targets = l.Targets
+		return nil
+	})
+	return targets, err
+}
+
+func (b *Bolt) SetTargets(ctx context.Context, shortURL string, targets Targets) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		l.Targets = targets
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) Card(ctx context.Context, shortURL string) (Card, error) {
+	var card Card
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		card = l.Card
+		return nil
+	})
+	return card, err
+}
+
+func (b *Bolt) SetCard(ctx context.Context, shortURL string, card Card) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		l.Card = card
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) Options(ctx context.Context, shortURL string) (Options, error) {
+	var opts Options
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		opts = l.options()
+		return nil
+	})
+	return opts, err
+}
+
+func (b *Bolt) SetOptions(ctx context.Context, shortURL string, opts Options) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		// Disabled belongs to SetActive, Flagged to FlagLink, TakenDown to
+		// TakeDown and APIKey to SetAPIKey, and all four are stored apart.
+		opts.Disabled, opts.Flagged, opts.TakenDown, opts.APIKey = false, false, false, ""
+		if opts.ExpiresAt != nil {
+			expiresAt := storedTime(*opts.ExpiresAt)
+			opts.ExpiresAt = &expiresAt
+		}
+		l.Options = opts
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) Variants(ctx context.Context, shortURL string) ([]Variant, error) {
+	var variants []Variant
+	err := b.db.View(func(tx *bolt.Tx) error {
+		_, err := getJSON(tx.Bucket(boltVariants), []byte(shortURL), &variants)
+		return err
+	})
+	return variants, err
+}
+
+func (b *Bolt) SetVariants(ctx context.Context, shortURL string, variants []Variant) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltVariants)
+		if len(variants) == 0 {
+			return bucket.Delete([]byte(shortURL))
+		}
+		stored := make([]Variant, len(variants))
+		for i, v := range variants {
+			stored[i] = Variant{Name: v.Name, URL: v.URL, Weight: v.Weight}
+		}
+		return putJSON(bucket, []byte(shortURL), stored)
+	})
+}
+
+func (b *Bolt) RecordVariantVisit(ctx context.Context, shortURL, name string) error {
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltVariants)
+		var variants []Variant
+		found, err := getJSON(bucket, []byte(shortURL), &variants)
+		if err != nil || !found {
+			return err
+		}
+		for i := range variants {
+			if variants[i].Name == name {
+				variants[i].VisitCount++
+			}
+		}
+		return putJSON(bucket, []byte(shortURL), variants)
+	})
+}
+
+func (b *Bolt) Tags(ctx context.Context, shortURL string) ([]string, error) {
+	var tags []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		_, err := getJSON(tx.Bucket(boltTags), []byte(shortURL), &tags)
+		return err
+	})
+	return tags, err
+}
+
+func (b *Bolt) SetTags(ctx context.Context, shortURL string, tags []string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTags)
+		if len(tags) == 0 {
+			return bucket.Delete([]byte(shortURL))
+		}
+		return putJSON(bucket, []byte(shortURL), sortedTags(tags))
+	})
+}
+
+func (b *Bolt) TaggedLinks(ctx context.Context, tag string) ([]string, error) {
+	var tagged []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTags)
+		var decodeErr error
+		err := forEachLink(tx, func(shortURL string, l *boltLink) {
+			var tags []string
+			if _, err := getJSON(bucket, []byte(shortURL), &tags); err != nil {
+				decodeErr = err
+			}
+			if !l.trashed() && hasTag(tags, tag) {
+				tagged = append(tagged, shortURL)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return decodeErr
+	})
+	return tagged, err
+}
+
+func (b *Bolt) SearchLinks(ctx context.Context, query string, limit int) ([]LinkStats, error) {
+	var found []LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		links, err := liveLinks(tx)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(boltTags)
+		found, err = searchLinks(links, func(shortURL string) ([]string, error) {
+			var tags []string
+			_, err := getJSON(bucket, []byte(shortURL), &tags)
+			return tags, err
+		}, query, limit)
+		return err
+	})
+	return found, err
+}
+
+func (b *Bolt) FlagLink(ctx context.Context, shortURL, source, reason string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		l, err := getLiveLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		if l.Flag != nil {
+			return nil
+		}
+		l.Flag = &boltFlag{Source: source, Reason: reason, FlaggedAt: storedNow()}
+		return putLink(tx, shortURL, l)
+	})
+}
+
+func (b *Bolt) ClearFlag(ctx context.Context, shortURL string) (bool, error) {
+	return b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.Flag == nil {
+			return false
+		}
+		l.Flag = nil
+		return true
+	})
+}
+
+func (b *Bolt) FlaggedLinks(ctx context.Context) ([]FlaggedLink, error) {
+	var flagged []FlaggedLink
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return forEachLink(tx, func(shortURL string, l *boltLink) {
+			if l.Flag != nil && !l.trashed() {
+				flagged = append(flagged, FlaggedLink{LinkStats: l.stats(shortURL), Source: l.Flag.Source, Reason: l.Flag.Reason, FlaggedAt: l.Flag.FlaggedAt})
+			}
+		})
+	})
+	sort.SliceStable(flagged, func(i, j int) bool { return flagged[i].FlaggedAt.Before(flagged[j].FlaggedAt) })
+	return flagged, err
+}
+
+func (b *Bolt) BanDomain(ctx context.Context, domain, reason string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBanned)
+		banned := BannedDomain{Domain: domain, BannedAt: storedNow()}
+		if _, err := getJSON(bucket, []byte(domain), &banned); err != nil {
+			return err
+		}
+		banned.Reason = reason
+		return putJSON(bucket, []byte(domain), banned)
+	})
+}
+
+func (b *Bolt) UnbanDomain(ctx context.Context, domain string) (bool, error) {
+	var found bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBanned)
+		found = bucket.Get([]byte(domain)) != nil
+		return bucket.Delete([]byte(domain))
+	})
+	return found, err
+}
+
+func (b *Bolt) BannedDomains(ctx context.Context) ([]BannedDomain, error) {
+	var domains []BannedDomain
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBanned).ForEach(func(k, v []byte) error {
+			var domain BannedDomain
+			if err := json.Unmarshal(v, &domain); err != nil {
+				return fmt.Errorf("error decoding banned domain '%s': %v", k, err)
+			}
+			domains = append(domains, domain)
+			return nil
+		})
+	})
+	return domains, err
+}
+
+func (b *Bolt) Health(ctx context.Context, shortURL string) (Health, error) {
+	var health Health
+	err := b.db.View(func(tx *bolt.Tx) error {
+		found, err := getJSON(tx.Bucket(boltHealth), []byte(shortURL), &health)
+		if err == nil && !found {
+			return ErrNotFound
+		}
+		return err
+	})
+	return health, err
+}
+
+func (b *Bolt) SetHealth(ctx context.Context, shortURL string, health Health) error {
+	health.CheckedAt = storedTime(health.CheckedAt)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(boltHealth), []byte(shortURL), health)
+	})
+}
+
+func (b *Bolt) BrokenLinks(ctx context.Context) ([]BrokenLink, error) {
+	var broken []BrokenLink
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltHealth).ForEach(func(k, v []byte) error {
+			var health Health
+			if err := json.Unmarshal(v, &health); err != nil {
+				return fmt.Errorf("error decoding health of '%s': %v", k, err)
+			}
+			if health.Healthy() {
+				return nil
+			}
+			l, err := getLiveLink(tx, string(k))
+			if err != nil || l == nil {
+				return err
+			}
+			broken = append(broken, BrokenLink{ShortURL: string(k), LongURL: l.LongURL, Health: health})
+			return nil
+		})
+	})
+	sort.SliceStable(broken, func(i, j int) bool { return broken[i].CheckedAt.After(broken[j].CheckedAt) })
+	return broken, err
+}
+
+func (b *Bolt) Links(ctx context.Context) ([]LinkStats, error) {
+	var links []LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		links, err = liveLinks(tx)
+		return err
+	})
+	return links, err
+}
+
+func (b *Bolt) LinksSince(ctx context.Context, since time.Time) ([]LinkStats, error) {
+	since = storedTime(since)
+	var links []LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		all, err := liveLinks(tx)
+		for i := len(all) - 1; i >= 0; i-- {
+			if !all[i].CreatedAt.Before(since) {
+				links = append(links, all[i])
+			}
+		}
+		return err
+	})
+	return links, err
+}
+
+func (b *Bolt) TopLinks(ctx context.Context, since time.Time, limit int) ([]LinkClicks, error) {
+	var top []LinkClicks
+	err := b.db.View(func(tx *bolt.Tx) error {
+		clicks, err := allClicks(tx)
+		if err != nil {
+			return err
+		}
+		rollups, err := allRollups(tx, "")
+		if err != nil {
+			return err
+		}
+		var lookupErr error
+		top = topLinks(clicks, rollups, since, limit, func(shortURL string) (string, bool) {
+			l, err := getLiveLink(tx, shortURL)
+			if err != nil {
+				lookupErr = err
+			}
+			if l == nil {
+				return "", false
+			}
+			return l.LongURL, true
+		})
+		return lookupErr
+	})
+	return top, err
+}
+
+func (b *Bolt) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	entry.CreatedAt = storedTime(entry.CreatedAt)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAudit)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = int64(id)
+		return putJSON(bucket, boltKey(id), entry)
+	})
+}
+
+func (b *Bolt) AuditLog(ctx context.Context, shortURL string, limit int) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltAudit).Cursor()
+		for k, v := c.Last(); k != nil && (limit < 0 || len(entries) < limit); k, v = c.Prev() {
+			var entry AuditEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return fmt.Errorf("error decoding audit entry %d: %v", binary.BigEndian.Uint64(k), err)
+			}
+			if shortURL == "" || entry.ShortURL == shortURL {
+				entries = append(entries, entry)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (b *Bolt) RecordClick(ctx context.Context, click Click) error {
+	click.At = storedTime(click.At)
+	return b.db.Batch(func(tx *bolt.Tx) error {
+		_, err := appendJSON(tx.Bucket(boltClicks), boltClick{&click})
+		return err
+	})
+}
+
+func (b *Bolt) RecentClicks(ctx context.Context, shortURL string, limit int) ([]Click, error) {
+	var clicks []Click
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltClicks).Cursor()
+		for k, v := c.Last(); k != nil && (limit < 0 || len(clicks) < limit); k, v = c.Prev() {
+			var click Click
+			if err := json.Unmarshal(v, &boltClick{&click}); err != nil {
+				return fmt.Errorf("error decoding click %d: %v", binary.BigEndian.Uint64(k), err)
+			}
+			if click.Bot == "" && (shortURL == "" || click.ShortURL == shortURL) {
+				clicks = append(clicks, click)
+			}
+		}
+		return nil
+	})
+	return clicks, err
+}
+
+func (b *Bolt) ClickSeries(ctx context.Context, shortURL string, since time.Time, interval Interval) ([]ClickCount, error) {
+	var series []ClickCount
+	err := b.db.View(func(tx *bolt.Tx) error {
+		clicks, err := allClicks(tx)
+		if err != nil {
+			return err
+		}
+		rollups, err := allRollups(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		series, err = clickSeries(humanClicks(clicks, shortURL), rollups, since, interval)
+		return err
+	})
+	return series, err
+}
+
+func (b *Bolt) ClickHeatmap(ctx context.Context, shortURL string) (Heatmap, error) {
+	var heatmap Heatmap
+	err := b.db.View(func(tx *bolt.Tx) error {
+		clicks, err := allClicks(tx)
+		heatmap = clickHeatmap(humanClicks(clicks, shortURL))
+		return err
+	})
+	return heatmap, err
+}
+
+func (b *Bolt) ClickBursts(ctx context.Context, since time.Time, minClicks, maxIPs int) ([]Anomaly, error) {
+	var bursts []Anomaly
+	err := b.db.View(func(tx *bolt.Tx) error {
+		clicks, err := allClicks(tx)
+		bursts = clickBursts(clicks, since, minClicks, maxIPs)
+		return err
+	})
+	return bursts, err
+}
+
+// oldClicks returns the keys and clicks of the click log from before
+// cutoff.
+func oldClicks(tx *bolt.Tx, cutoff time.Time) ([][]byte, []Click, error) {
+	var keys [][]byte
+	var clicks []Click
+	err := tx.Bucket(boltClicks).ForEach(func(k, v []byte) error {
+		var click Click
+		if err := json.Unmarshal(v, &boltClick{&click}); err != nil {
+			return fmt.Errorf("error decoding click %d: %v", binary.BigEndian.Uint64(k), err)
+		}
+		if click.At.Before(cutoff) {
+			keys = append(keys, k)
+			clicks = append(clicks, click)
+		}
+		return nil
+	})
+	return keys, clicks, err
+}
+
+func (b *Bolt) RollupClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	cutoff = storedTime(cutoff)
+	var compacted int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		keys, clicks, err := oldClicks(tx, cutoff)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket(boltRollups)
+		// A day compacted again, say after the clock went back, adds to
+		// its rollup. Uniques may then count an address twice.
+		for key, day := range summarizeDays(clicks) {
+			k := rollupBoltKey(key.shortURL, key.day)
+			var rollup ClickRollup
+			found, err := getJSON(bucket, k, &rollup)
+			if err != nil {
+				return err
+			}
+			if found {
+				rollup.Clicks += day.Clicks
+				rollup.Uniques += day.Uniques
+				day = &rollup
+			}
+			if err := putJSON(bucket, k, day); err != nil {
+				return err
+			}
+		}
+		compacted = int64(len(keys))
+		return deleteKeys(tx.Bucket(boltClicks), keys)
+	})
+	return compacted, err
+}
+
+func (b *Bolt) PurgeClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	cutoff = storedTime(cutoff)
+	var purged int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		keys, _, err := oldClicks(tx, cutoff)
+		if err != nil {
+			return err
+		}
+		purged = int64(len(keys))
+		return deleteKeys(tx.Bucket(boltClicks), keys)
+	})
+	return purged, err
+}
+
+func (b *Bolt) PurgeRollups(ctx context.Context, cutoff time.Time) (int64, error) {
+	cutoffDay := cutoff.UTC().Format("2006-01-02")
+	var purged int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRollups)
+		var stale [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if _, day, _ := bytes.Cut(k, []byte{0}); string(day) < cutoffDay {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		purged = int64(len(stale))
+		return deleteKeys(bucket, stale)
+	})
+	return purged, err
+}
+
+func (b *Bolt) ClickRollups(ctx context.Context, shortURL string, since time.Time) ([]ClickRollup, error) {
+	sinceDay := since.UTC().Format("2006-01-02")
+	var rollups []ClickRollup
+	err := b.db.View(func(tx *bolt.Tx) error {
+		all, err := allRollups(tx, shortURL)
+		for _, rollup := range all {
+			if rollup.Day.Format("2006-01-02") >= sinceDay {
+				rollups = append(rollups, rollup)
+			}
+		}
+		return err
+	})
+	sortRollups(rollups)
+	return rollups, err
+}
+
+func (b *Bolt) RecordAnomaly(ctx context.Context, anomaly Anomaly) error {
+	anomaly.WindowStart = storedTime(anomaly.WindowStart)
+	anomaly.DetectedAt = storedTime(anomaly.DetectedAt)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAnomalies)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		anomaly.ID = int64(id)
+		return putJSON(bucket, boltKey(id), anomaly)
+	})
+}
+
+func (b *Bolt) Anomalies(ctx context.Context, limit int) ([]Anomaly, error) {
+	var anomalies []Anomaly
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltAnomalies).Cursor()
+		for k, v := c.Last(); k != nil && (limit < 0 || len(anomalies) < limit); k, v = c.Prev() {
+			var anomaly Anomaly
+			if err := json.Unmarshal(v, &anomaly); err != nil {
+				return fmt.Errorf("error decoding anomaly %d: %v", binary.BigEndian.Uint64(k), err)
+			}
+			anomalies = append(anomalies, anomaly)
+		}
+		return nil
+	})
+	return anomalies, err
+}
+
+// boltAlert stores an Alert with the fields it leaves out of JSON.
+type boltAlert struct {
+	Alert
+	LastCount  int       `json:"lastCount"`
+	LastChange time.Time `json:"lastChange"`
+}
+
+func newBoltAlert(alert Alert) boltAlert {
+	alert.LastChange = storedTime(alert.LastChange)
+	alert.CreatedAt = storedTime(alert.CreatedAt)
+	if alert.FiredAt != nil {
+		firedAt := storedTime(*alert.FiredAt)
+		alert.FiredAt = &firedAt
+	}
+	return boltAlert{Alert: alert, LastCount: alert.LastCount, LastChange: alert.LastChange}
+}
+
+func (a boltAlert) alert() Alert {
+	alert := a.Alert
+	alert.LastCount, alert.LastChange = a.LastCount, a.LastChange
+	return alert
+}
+
+func (b *Bolt) CreateTakedown(ctx context.Context, takedown Takedown) (int64, error) {
+	var id uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTakedowns)
+		var err error
+		if id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		takedown.ID = int64(id)
+		takedown.CreatedAt = storedTime(takedown.CreatedAt)
+		takedown.Links = nil
+		return putJSON(bucket, boltKey(id), takedown)
+	})
+	return int64(id), err
+}
+
+func (b *Bolt) TakeDown(ctx context.Context, shortURL string, id int64) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.trashed() {
+			return false
+		}
+		l.Disabled = true
+		l.Takedown = id
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) Takedowns(ctx context.Context) ([]Takedown, error) {
+	var takedowns []Takedown
+	err := b.db.View(func(tx *bolt.Tx) error {
+		byID := make(map[int64]int)
+		c := tx.Bucket(boltTakedowns).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var takedown Takedown
+			if err := json.Unmarshal(v, &takedown); err != nil {
+				return fmt.Errorf("error decoding takedown %d: %v", binary.BigEndian.Uint64(k), err)
+			}
+			byID[takedown.ID] = len(takedowns)
+			takedowns = append(takedowns, takedown)
+		}
+		return forEachLink(tx, func(shortURL string, l *boltLink) {
+			if i, ok := byID[l.Takedown]; ok && l.Takedown != 0 && !l.trashed() {
+				takedowns[i].Links = append(takedowns[i].Links, shortURL)
+			}
+		})
+	})
+	return takedowns, err
+}
+
+func (b *Bolt) SetAPIKey(ctx context.Context, shortURL, key string) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.trashed() {
+			return false
+		}
+		l.APIKey = key
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+// usageBoltKey keys an API key's usage by month, so one key's months sit
+// together in order.
+func usageBoltKey(key, month string) []byte {
+	return append(append([]byte(key), 0), month...)
+}
+
+func (b *Bolt) RecordUsage(ctx context.Context, key, month string, creates, redirects int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltUsage)
+		k := usageBoltKey(key, month)
+		usage := Usage{Month: month}
+		if _, err := getJSON(bucket, k, &usage); err != nil {
+			return err
+		}
+		usage.Creates += creates
+		usage.Redirects += redirects
+		return putJSON(bucket, k, usage)
+	})
+}
+
+func (b *Bolt) Usage(ctx context.Context, key string) ([]Usage, error) {
+	var usage []Usage
+	err := b.db.View(func(tx *bolt.Tx) error {
+		prefix := usageBoltKey(key, "")
+		c := tx.Bucket(boltUsage).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var u Usage
+			if err := json.Unmarshal(v, &u); err != nil {
+				return fmt.Errorf("error decoding usage '%s': %v", k, err)
+			}
+			// Newest month first.
+			usage = append([]Usage{u}, usage...)
+		}
+		return nil
+	})
+	return usage, err
+}
+
+func (b *Bolt) DeleteUsage(ctx context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		prefix := usageBoltKey(key, "")
+		c := tx.Bucket(boltUsage).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Bolt) SetOwner(ctx context.Context, shortURL, owner string) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.trashed() {
+			return false
+		}
+		l.Owner = owner
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) OwnerLinks(ctx context.Context, owner string) ([]LinkStats, error) {
+	var links []LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return forEachLink(tx, func(shortURL string, l *boltLink) {
+			// Links created with an API key before owners were recorded
+			// belong to the key.
+			mine := l.Owner == owner || l.Owner == "" && l.APIKey == owner
+			if mine && !l.trashed() {
+				links = append(links, l.stats(shortURL))
+			}
+		})
+	})
+	return links, err
+}
+
+// sessionBoltKey keys a session link by session, so a session's links sit
+// together.
+func sessionBoltKey(session, shortURL string) []byte {
+	return append(append([]byte(session), 0), shortURL...)
+}
+
+// boltSessionLink is a session link as it is stored, under its session and
+// short URL. Seq orders the links, latest last.
+type boltSessionLink struct {
+	Seq uint64    `json:"seq"`
+	At  time.Time `json:"at"`
+}
+
+func (b *Bolt) AddSessionLink(ctx context.Context, session, shortURL string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSessions)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return putJSON(bucket, sessionBoltKey(session, shortURL), boltSessionLink{Seq: seq, At: storedNow()})
+	})
+}
+
+func (b *Bolt) SessionLinks(ctx context.Context, session string, limit int) ([]LinkStats, error) {
+	var links []LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		type entry struct {
+			shortURL string
+			seq      uint64
+		}
+		var entries []entry
+		prefix := sessionBoltKey(session, "")
+		c := tx.Bucket(boltSessions).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var l boltSessionLink
+			if err := json.Unmarshal(v, &l); err != nil {
+				return fmt.Errorf("error decoding session link '%s': %v", k, err)
+			}
+			entries = append(entries, entry{shortURL: string(k[len(prefix):]), seq: l.Seq})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].seq > entries[j].seq })
+		for _, e := range entries {
+			if len(links) == limit {
+				break
+			}
+			l, err := getLiveLink(tx, e.shortURL)
+			if err != nil {
+				return err
+			}
+			if l != nil {
+				links = append(links, l.stats(e.shortURL))
+			}
+		}
+		return nil
+	})
+	return links, err
+}
+
+func (b *Bolt) PurgeSessionLinks(ctx context.Context, cutoff time.Time) (int64, error) {
+	var purged int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var old [][]byte
+		err := tx.Bucket(boltSessions).ForEach(func(k, v []byte) error {
+			var l boltSessionLink
+			if err := json.Unmarshal(v, &l); err != nil {
+				return fmt.Errorf("error decoding session link '%s': %v", k, err)
+			}
+			if l.At.Before(cutoff) {
+				old = append(old, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range old {
+			if err := tx.Bucket(boltSessions).Delete(k); err != nil {
+				return err
+			}
+		}
+		purged = int64(len(old))
+		return nil
+	})
+	return purged, err
+}
+
+func (b *Bolt) CreateTeam(ctx context.Context, name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltTeams)
+		if bucket.Get([]byte(name)) != nil {
+			return ErrTeamExists
+		}
+		return putJSON(bucket, []byte(name), Team{Name: name, CreatedAt: storedTime(time.Now())})
+	})
+}
+
+func (b *Bolt) DeleteTeam(ctx context.Context, name string) (bool, error) {
+	var found bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		teams := tx.Bucket(boltTeams)
+		if found = teams.Get([]byte(name)) != nil; !found {
+			return nil
+		}
+		if err := teams.Delete([]byte(name)); err != nil {
+			return err
+		}
+		members := tx.Bucket(boltMembers)
+		var gone [][]byte
+		if err := members.ForEach(func(k, v []byte) error {
+			if string(v) == name {
+				gone = append(gone, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range gone {
+			if err := members.Delete(k); err != nil {
+				return err
+			}
+		}
+		var owned []string
+		if err := forEachLink(tx, func(shortURL string, l *boltLink) {
+			if l.Team == name {
+				owned = append(owned, shortURL)
+			}
+		}); err != nil {
+			return err
+		}
+		for _, shortURL := range owned {
+			l, err := getLink(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			l.Team = ""
+			if err := putLink(tx, shortURL, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (b *Bolt) Teams(ctx context.Context) ([]Team, error) {
+	var teams []Team
+	err := b.db.View(func(tx *bolt.Tx) error {
+		byName := make(map[string]int)
+		err := tx.Bucket(boltTeams).ForEach(func(k, v []byte) error {
+			var team Team
+			if err := json.Unmarshal(v, &team); err != nil {
+				return fmt.Errorf("error decoding team '%s': %v", k, err)
+			}
+			team.Members = nil
+			byName[team.Name] = len(teams)
+			teams = append(teams, team)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltMembers).ForEach(func(k, v []byte) error {
+			if i, ok := byName[string(v)]; ok {
+				teams[i].Members = append(teams[i].Members, string(k))
+			}
+			return nil
+		})
+	})
+	return teams, err
+}
+
+func (b *Bolt) SetTeamMember(ctx context.Context, team, member string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltTeams).Get([]byte(team)) == nil {
+			return ErrNotFound
+		}
+		return tx.Bucket(boltMembers).Put([]byte(member), []byte(team))
+	})
+}
+
+func (b *Bolt) RemoveTeamMember(ctx context.Context, member string) (bool, error) {
+	var found bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		members := tx.Bucket(boltMembers)
+		if found = members.Get([]byte(member)) != nil; !found {
+			return nil
+		}
+		return members.Delete([]byte(member))
+	})
+	return found, err
+}
+
+func (b *Bolt) SetTeam(ctx context.Context, shortURL, team string) error {
+	found, err := b.updateLink(shortURL, func(l *boltLink) bool {
+		if l.trashed() {
+			return false
+		}
+		l.Team = team
+		return true
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bolt) TeamLinks(ctx context.Context, team string) ([]LinkStats, error) {
+	var links []LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return forEachLink(tx, func(shortURL string, l *boltLink) {
+			if l.Team == team && !l.trashed() {
+				links = append(links, l.stats(shortURL))
+			}
+		})
+	})
+	return links, err
+}
+
+func (b *Bolt) CreateAlert(ctx context.Context, alert Alert) (int64, error) {
+	var id uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAlerts)
+		var err error
+		if id, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		alert.ID = int64(id)
+		return putJSON(bucket, boltKey(id), newBoltAlert(alert))
+	})
+	return int64(id), err
+}
+
+func (b *Bolt) Alerts(ctx context.Context, shortURL string) ([]Alert, error) {
+	var alerts []Alert
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return readAll(tx.Bucket(boltAlerts), func(id uint64, data []byte) error {
+			var stored boltAlert
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return fmt.Errorf("error decoding alert %d: %v", id, err)
+			}
+			if shortURL == "" || stored.ShortURL == shortURL {
+				alerts = append(alerts, stored.alert())
+			}
+			return nil
+		})
+	})
+	return alerts, err
+}
+
+func (b *Bolt) UpdateAlert(ctx context.Context, alert Alert) error {
+	update := newBoltAlert(alert)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAlerts)
+		var stored boltAlert
+		found, err := getJSON(bucket, boltKey(uint64(alert.ID)), &stored)
+		if err != nil || !found {
+			return err
+		}
+		stored.LastCount, stored.LastChange, stored.FiredAt = update.LastCount, update.LastChange, update.FiredAt
+		return putJSON(bucket, boltKey(uint64(alert.ID)), stored)
+	})
+}
+
+func (b *Bolt) DeleteAlert(ctx context.Context, shortURL string, id int64) (bool, error) {
+	var deleted bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltAlerts)
+		var stored boltAlert
+		found, err := getJSON(bucket, boltKey(uint64(id)), &stored)
+		if err != nil || !found || stored.ShortURL != shortURL {
+			return err
+		}
+		deleted = true
+		return bucket.Delete(boltKey(uint64(id)))
+	})
+	return deleted, err
+}
+
+func (b *Bolt) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	var acquired bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltLeases)
+		now := storedNow()
+		var lease boltLease
+		found, err := getJSON(bucket, []byte(name), &lease)
+		if err != nil {
+			return err
+		}
+		if found && lease.Holder != holder && lease.ExpiresAt.After(now) {
+			return nil
+		}
+		acquired = true
+		return putJSON(bucket, []byte(name), boltLease{Holder: holder, ExpiresAt: storedTime(now.Add(ttl))})
+	})
+	return acquired, err
+}
+
+func (b *Bolt) Link(ctx context.Context, shortURL string) (LinkStats, error) {
+	var stats LinkStats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		l, err := getLiveLink(tx, shortURL)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrNotFound
+		}
+		stats = l.stats(shortURL)
+		return nil
+	})
+	return stats, err
+}
+
+func (b *Bolt) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := b.db.View(func(tx *bolt.Tx) error {
+		links, err := liveLinks(tx)
+		stats = linkStatsFigures(links)
+		return err
+	})
+	return stats, err
+}
+
+// ForEachShortURL collects the codes in one read, then calls fn outside
+// it, so fn may use the store.
+func (b *Bolt) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	var codes []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLinks).ForEach(func(k, v []byte) error {
+			codes = append(codes, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, shortURL := range codes {
+		fn(shortURL)
+	}
+	return nil
+}
+
+func (b *Bolt) HotLinks(ctx context.Context, limit int) ([]HotLink, error) {
+	type entry struct {
+		visits int
+		link   HotLink
+	}
+	var entries []entry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return forEachLink(tx, func(shortURL string, l *boltLink) {
+			if !l.trashed() {
+				entries = append(entries, entry{l.VisitCount, HotLink{ShortURL: shortURL, LongURL: l.LongURL, Options: l.options()}})
+			}
+		})
+	})
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].visits > entries[j].visits })
+	var hot []HotLink
+	for _, e := range entries[:limited(len(entries), limit)] {
+		hot = append(hot, e.link)
+	}
+	return hot, err
+}
+
+func (b *Bolt) Count(ctx context.Context) (int, error) {
+	var count int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLinks).ForEach(func(k, v []byte) error {
+			var l boltLink
+			if err := json.Unmarshal(v, &l); err != nil {
+				return fmt.Errorf("error decoding link '%s': %v", k, err)
+			}
+			if !l.trashed() {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestBolt(t *testing.T) *Bolt {
+	t.Helper()
+
+	b, err := OpenBolt(filepath.Join(t.TempDir(), "test.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// Bolt is held to the same tests as SQLite.
+
+func TestBoltRoundTrip(t *testing.T) {
+	testRoundTrip(t, newTestBolt(t))
+}
+
+func TestBoltClickLog(t *testing.T) {
+	testClickLog(t, newTestBolt(t))
+}
+
+func TestBoltAnomalies(t *testing.T) {
+	testAnomalies(t, newTestBolt(t))
+}
+
+func TestBoltClickRollups(t *testing.T) {
+	testClickRollups(t, newTestBolt(t))
+}
+
+func TestBoltLinksSinceAndTopLinks(t *testing.T) {
+	b := newTestBolt(t)
+	testLinksSinceAndTopLinks(t, b, func(shortURL string) {
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			l, err := getLink(tx, shortURL)
+			if err != nil {
+				return err
+			}
+			l.CreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			return putLink(tx, shortURL, l)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestBoltAlerts(t *testing.T) {
+	testAlerts(t, newTestBolt(t))
+}
+
+func TestBoltEditLink(t *testing.T) {
+	testEditLink(t, newTestBolt(t))
+}
+
+func TestBoltModeration(t *testing.T) {
+	testModeration(t, newTestBolt(t))
+}
+
+func TestBoltTakedowns(t *testing.T) {
+	testTakedowns(t, newTestBolt(t))
+}
+
+func TestBoltAPIKeyUsage(t *testing.T) {
+	testAPIKeyUsage(t, newTestBolt(t))
+}
+
+func TestBoltTeams(t *testing.T) {
+	testTeams(t, newTestBolt(t))
+}
+
+func TestBoltOwners(t *testing.T) {
+	testOwners(t, newTestBolt(t))
+}
+
+func TestBoltSessionLinks(t *testing.T) {
+	testSessionLinks(t, newTestBolt(t))
+}
+
+func TestBoltSearchLinks(t *testing.T) {
+	testSearchLinks(t, newTestBolt(t))
+}
+
+func TestBoltCard(t *testing.T) {
+	testCard(t, newTestBolt(t))
+}
+
+func TestBoltAcquireLease(t *testing.T) {
+	testAcquireLease(t, newTestBolt(t))
+}
+
+func TestBoltReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.bolt")
+	b, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := b.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.RecordVisit(ctx, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	click := Click{ShortURL: "abc123", IP: "192.0.2.1", At: time.Now()}
+	if err := b.RecordClick(ctx, click); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenBolt(path)
+	if err != nil {
+		t.Fatalf("OpenBolt of an existing file returned an error: %v", err)
+	}
+	defer b.Close()
+	if link, err := b.Link(ctx, "abc123"); err != nil || link.VisitCount != 1 {
+		t.Errorf("Link after reopening returned %+v, %v", link, err)
+	}
+	if clicks, err := b.RecentClicks(ctx, "", 10); err != nil || len(clicks) != 1 || clicks[0].IP != click.IP {
+		t.Errorf("RecentClicks after reopening returned %+v, %v", clicks, err)
+	}
+	if stats, err := b.Stats(ctx); err != nil || stats.TotalLinks != 1 || stats.TotalClicks != 1 {
+		t.Errorf("Stats after reopening returned %+v, %v", stats, err)
+	}
+}
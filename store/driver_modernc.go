@@ -0,0 +1,8 @@
+//go:build !cgo || modernc
+
+package store
+
+// modernc.org/sqlite registers itself as the "sqlite" driver, for
+// database.driver. Builds without cgo have no other working SQLite, so
+// they always link it in; cgo builds add it with -tags modernc.
+import _ "modernc.org/sqlite"
@@ -0,0 +1,34 @@
+//go:build !cgo || modernc
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func TestOpenModernc(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Database.Name = filepath.Join(t.TempDir(), "shorty.db")
+	cfg.Database.Driver = config.DriverModernc
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("Open with the modernc driver returned an error: %v", err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	if err := s.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if longURL, err := s.LongURL(ctx, "abc123"); err != nil || longURL != "https://example.com" {
+		t.Errorf("LongURL returned %q, %v", longURL, err)
+	}
+	var journalMode string
+	if err := s.db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode); err != nil || journalMode != "wal" {
+		t.Errorf("journal_mode is %q, %v want wal", journalMode, err)
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"sync"
+)
+
+// HotCached is a Store that keeps the most visited links in memory, so the
+// redirects that make up most of the traffic are answered without a query.
+// LongURL and Options of a cached link come from memory; every other call,
+// and every link that isn't cached, goes to the embedded Store.
+//
+// The cache holds whatever the last Preload loaded. Changes made through it
+// drop the link from the cache until the next Preload. Changes made by
+// anyone else, such as another replica, reach redirects at the next
+// Preload.
+type HotCached struct {
+	Store
+
+	mu    sync.RWMutex
+	links map[string]HotLink
+	// dropped collects the links changed while a Preload is reading, so
+	// it doesn't bring back what they were before.
+	dropped map[string]bool
+}
+
+// NewHotCached returns st with an empty cache in front of it.
+func NewHotCached(st Store) *HotCached {
+	return &HotCached{Store: st}
+}
+
+// Unwrap returns the store behind the cache.
+func (c *HotCached) Unwrap() Store {
+	return c.Store
+}
+
+// Preload replaces the cache with the limit most visited links and returns
+// how many were loaded.
+func (c *HotCached) Preload(ctx context.Context, limit int) (int, error) {
+	c.mu.Lock()
+	c.dropped = make(map[string]bool)
+	c.mu.Unlock()
+
+	hot, err := c.Store.HotLinks(ctx, limit)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := c.dropped
+	c.dropped = nil
+	if err != nil {
+		return 0, err
+	}
+	links := make(map[string]HotLink, len(hot))
+	for _, link := range hot {
+		if !dropped[link.ShortURL] {
+			links[link.ShortURL] = link
+		}
+	}
+	c.links = links
+	return len(links), nil
+}
+
+func (c *HotCached) cached(shortURL string) (HotLink, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	link, ok := c.links[shortURL]
+	return link, ok
+}
+
+// drop forgets shortURL, whose link is about to change.
+func (c *HotCached) drop(shortURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.links, shortURL)
+	if c.dropped != nil {
+		c.dropped[shortURL] = true
+	}
+}
+
+func (c *HotCached) LongURL(ctx context.Context, shortURL string) (string, error) {
+	if link, ok := c.cached(shortURL); ok {
+		return link.LongURL, nil
+	}
+	return c.Store.LongURL(ctx, shortURL)
+}
+
+func (c *HotCached) Options(ctx context.Context, shortURL string) (Options, error) {
+	if link, ok := c.cached(shortURL); ok {
+		return link.Options, nil
+	}
+	return c.Store.Options(ctx, shortURL)
+}
+
+func (c *HotCached) Delete(ctx context.Context, shortURL string) (bool, error) {
+	c.drop(shortURL)
+	return c.Store.Delete(ctx, shortURL)
+}
+
+func (c *HotCached) Trash(ctx context.Context, shortURL string) (bool, error) {
+	c.drop(shortURL)
+	return c.Store.Trash(ctx, shortURL)
+}
+
+func (c *HotCached) SetActive(ctx context.Context, shortURL string, active bool) error {
+	c.drop(shortURL)
+	return c.Store.SetActive(ctx, shortURL, active)
+}
+
+func (c *HotCached) SetOptions(ctx context.Context, shortURL string, opts Options) error {
+	c.drop(shortURL)
+	return c.Store.SetOptions(ctx, shortURL, opts)
+}
+
+func (c *HotCached) SetLongURL(ctx context.Context, shortURL, longURL string) error {
+	c.drop(shortURL)
+	return c.Store.SetLongURL(ctx, shortURL, longURL)
+}
+
+func (c *HotCached) FlagLink(ctx context.Context, shortURL, source, reason string) error {
+	c.drop(shortURL)
+	return c.Store.FlagLink(ctx, shortURL, source, reason)
+}
+
+func (c *HotCached) ClearFlag(ctx context.Context, shortURL string) (bool, error) {
+	c.drop(shortURL)
+	return c.Store.ClearFlag(ctx, shortURL)
+}
+
+func (c *HotCached) TakeDown(ctx context.Context, shortURL string, id int64) error {
+	c.drop(shortURL)
+	return c.Store.TakeDown(ctx, shortURL, id)
+}
+
+func (c *HotCached) SetAPIKey(ctx context.Context, shortURL, key string) error {
+	c.drop(shortURL)
+	return c.Store.SetAPIKey(ctx, shortURL, key)
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestHotCached(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	visits := map[string]int{"cold": 1, "warm": 2, "hot": 3, "trashed": 4}
+	for shortURL, n := range visits {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			if _, err := s.RecordVisit(ctx, shortURL); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := s.Trash(ctx, "trashed"); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewHotCached(s)
+	loaded, err := c.Preload(ctx, 2)
+	if err != nil || loaded != 2 {
+		t.Fatalf("Preload returned %d, %v, want the 2 most visited links", loaded, err)
+	}
+
+	// Change the links behind the cache's back: the cached ones keep
+	// their old destination until the next Preload.
+	if _, err := s.DB().Exec(`UPDATE url_mapping SET long_url = 'https://example.org/' || short_url`); err != nil {
+		t.Fatal(err)
+	}
+	for shortURL, want := range map[string]string{
+		"hot":  "https://example.com/hot",
+		"warm": "https://example.com/warm",
+		"cold": "https://example.org/cold",
+	} {
+		if longURL, err := c.LongURL(ctx, shortURL); err != nil || longURL != want {
+			t.Errorf("LongURL(%q) returned %q, %v, want %q", shortURL, longURL, err, want)
+		}
+	}
+	if _, err := c.LongURL(ctx, "trashed"); err != ErrNotFound {
+		t.Errorf("LongURL of a trashed link returned %v, want ErrNotFound", err)
+	}
+
+	// Changes made through the cache drop the link from it.
+	if err := c.SetActive(ctx, "hot", false); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := c.Options(ctx, "hot"); err != nil || !opts.Disabled {
+		t.Errorf("Options of a disabled link returned %+v, %v", opts, err)
+	}
+	if longURL, err := c.LongURL(ctx, "hot"); err != nil || longURL != "https://example.org/hot" {
+		t.Errorf("LongURL of a changed link returned %q, %v", longURL, err)
+	}
+
+	if _, err := c.Preload(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if longURL, err := c.LongURL(ctx, "warm"); err != nil || longURL != "https://example.org/warm" {
+		t.Errorf("LongURL after a Preload returned %q, %v", longURL, err)
+	}
+	if opts, err := c.Options(ctx, "hot"); err != nil || !opts.Disabled {
+		t.Errorf("Options after a Preload returned %+v, %v", opts, err)
+	}
+	if c.Unwrap() != s {
+		t.Error("Unwrap didn't return the cached store")
+	}
+}
@@ -0,0 +1,29 @@
+package store
+
+import "errors"
+
+// SQLite result codes for a database another connection holds, before
+// extended codes are masked off.
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// Locked reports whether err is SQLite giving up on a lock, SQLITE_BUSY or
+// SQLITE_LOCKED, from either driver. Other errors, including ones that
+// merely mention a lock, are not.
+func Locked(err error) bool {
+	if err == nil {
+		return false
+	}
+	// modernc.org/sqlite's *Error has a Code method. It's matched by its
+	// method rather than its type so the package needn't be linked in.
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) {
+		switch coded.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return true
+		}
+	}
+	return driverLocked(err)
+}
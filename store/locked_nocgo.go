@@ -0,0 +1,9 @@
+//go:build !cgo
+
+package store
+
+// go-sqlite3 only exists in builds with cgo, so there are no errors of its
+// to look for.
+func driverLocked(err error) bool {
+	return false
+}
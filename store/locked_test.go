@@ -0,0 +1,48 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.db")
+	holder, err := sql.Open("sqlite3", path+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	if _, err := holder.Exec(`CREATE TABLE t (n INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := holder.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO t VALUES (1)`); err != nil {
+		t.Fatal(err)
+	}
+
+	waiter, err := sql.Open("sqlite3", path+"?_busy_timeout=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Close()
+	_, err = waiter.Exec(`INSERT INTO t VALUES (2)`)
+	if err == nil {
+		t.Fatal("Write while another connection held the lock succeeded")
+	}
+	if !Locked(err) {
+		t.Errorf("Locked(%v) = false, want true", err)
+	}
+
+	for _, err := range []error{nil, ErrNotFound, errors.New("database is locked"), fmt.Errorf("error querying: %v", err)} {
+		if Locked(err) {
+			t.Errorf("Locked(%v) = true, want false", err)
+		}
+	}
+}
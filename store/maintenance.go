@@ -0,0 +1,53 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Vacuum rebuilds the database file, returning pages freed by
+// deleted links to the filesystem. VACUUM may renumber rows, so the search
+// index is rebuilt after it.
+func Vacuum(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `VACUUM`); err != nil {
+		return err
+	}
+	return RebuildSearchIndex(ctx, db)
+}
+
+// Analyze refreshes the statistics SQLite's query planner uses to
+// pick indexes.
+func Analyze(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `ANALYZE`)
+	return err
+}
+
+// IntegrityCheck runs PRAGMA integrity_check and returns the problems it
+// found. An empty result means the database is healthy.
+func IntegrityCheck(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// Size returns the size of the database in bytes, from its page count. The
+// write-ahead log isn't included.
+func Size(ctx context.Context, db *sql.DB) (int64, error) {
+	var size int64
+	err := db.QueryRowContext(ctx, `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size)
+	return size, err
+}
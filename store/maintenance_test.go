@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMaintenanceTasks(t *testing.T) {
+	testDB := openTestDB(t)
+	if _, err := Migrate(testDB); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := Vacuum(ctx, testDB); err != nil {
+		t.Errorf("Vacuum returned an error: %v", err)
+	}
+	if err := Analyze(ctx, testDB); err != nil {
+		t.Errorf("Analyze returned an error: %v", err)
+	}
+
+	problems, err := IntegrityCheck(ctx, testDB)
+	if err != nil {
+		t.Fatalf("IntegrityCheck returned an error: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("IntegrityCheck reported problems on a fresh database: %v", problems)
+	}
+
+	if size, err := Size(ctx, testDB); err != nil || size <= 0 {
+		t.Errorf("Size returned %v, %v", size, err)
+	}
+}
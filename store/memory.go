@@ -0,0 +1,1144 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps everything in maps in memory and loses it
+// all when the process exits. It needs no database, which makes it handy
+// for tests and throwaway demos. It behaves like SQLite, down to times
+// being kept to the second.
+type Memory struct {
+	mu sync.RWMutex
+
+	links     map[string]*memoryLink
+	variants  map[string][]Variant
+	tags      map[string][]string
+	health    map[string]Health
+	audit     []AuditEntry
+	clicks    []Click
+	rollups   map[rollupKey]*ClickRollup
+	anomalies []Anomaly
+	alerts    []Alert
+	leases    map[string]memoryLease
+	banned    map[string]BannedDomain
+	takedowns []Takedown
+	usage     map[usageKey]Usage
+	teams     map[string]Team
+	// members maps each team member to its team.
+	members map[string]string
+	// sessionLinks are in the order they were recorded.
+	sessionLinks []memorySessionLink
+
+	// Counters for the IDs the SQLite tables hand out.
+	seq, nextID, auditID, anomalyID, alertID, takedownID int64
+}
+
+type memoryLink struct {
+	LinkStats
+	// seq orders links by creation, as SQLite's rowid does.
+	seq       int64
+	botVisits int
+	targets   Targets
+	card      Card
+	opts      Options
+	deletedAt time.Time
+	// flag is set while the link waits in the moderation queue.
+	flag *FlaggedLink
+	// takedown is the ID of the notice the link was taken down under.
+	takedown int64
+	// team owns the link.
+	team string
+	// owner is the account that created the link.
+	owner string
+}
+
+type memorySessionLink struct {
+	session  string
+	shortURL string
+	at       time.Time
+}
+
+func (l *memoryLink) trashed() bool {
+	return !l.deletedAt.IsZero()
+}
+
+// usageKey identifies what one API key used in one month.
+type usageKey struct {
+	key, month string
+}
+
+type memoryLease struct {
+	holder    string
+	expiresAt time.Time
+}
+
+// NewMemory returns an empty Memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		links:    make(map[string]*memoryLink),
+		variants: make(map[string][]Variant),
+		tags:     make(map[string][]string),
+		health:   make(map[string]Health),
+		rollups:  make(map[rollupKey]*ClickRollup),
+		leases:   make(map[string]memoryLease),
+		banned:   make(map[string]BannedDomain),
+		usage:    make(map[usageKey]Usage),
+		teams:    make(map[string]Team),
+		members:  make(map[string]string),
+	}
+}
+
+// live returns the link at shortURL unless it is missing or trashed.
+func (m *Memory) live(shortURL string) (*memoryLink, bool) {
+	link, ok := m.links[shortURL]
+	if !ok || link.trashed() {
+		return nil, false
+	}
+	return link, true
+}
+
+// sortedLinks returns the links that pass keep, oldest first.
+func (m *Memory) sortedLinks(keep func(*memoryLink) bool) []*memoryLink {
+	var links []*memoryLink
+	for _, link := range m.links {
+		if keep(link) {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].seq < links[j].seq })
+	return links
+}
+
+func isLive(link *memoryLink) bool {
+	return !link.trashed()
+}
+
+func linkStats(links []*memoryLink) []LinkStats {
+	var stats []LinkStats
+	for _, link := range links {
+		stats = append(stats, link.LinkStats)
+	}
+	return stats
+}
+
+func (m *Memory) LongURL(ctx context.Context, shortURL string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return link.LongURL, nil
+}
+
+func (m *Memory) ShortURLFor(ctx context.Context, longURL string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	links := m.sortedLinks(func(link *memoryLink) bool {
+		return link.LongURL == longURL && !link.opts.Disabled && !link.opts.Expired(time.Now()) && !link.trashed()
+	})
+	if len(links) == 0 {
+		return "", ErrNotFound
+	}
+	return links[0].ShortURL, nil
+}
+
+func (m *Memory) Exists(ctx context.Context, shortURL string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.links[shortURL]
+	return ok, nil
+}
+
+func (m *Memory) NextID(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	return uint64(m.nextID), nil
+}
+
+func (m *Memory) Create(ctx context.Context, shortURL, longURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.links[shortURL]; ok {
+		return ErrExists
+	}
+	m.seq++
+	m.links[shortURL] = &memoryLink{
+		LinkStats: LinkStats{ShortURL: shortURL, LongURL: longURL, CreatedAt: storedNow()},
+		seq:       m.seq,
+	}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, shortURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.links[shortURL]; !ok {
+		return false, nil
+	}
+	m.delete(shortURL)
+	return true, nil
+}
+
+// delete removes a link and what belongs to it, as the SQLite triggers
+// do.
+func (m *Memory) delete(shortURL string) {
+	delete(m.links, shortURL)
+	delete(m.variants, shortURL)
+	delete(m.tags, shortURL)
+	delete(m.health, shortURL)
+	clicks := m.clicks[:0]
+	for _, click := range m.clicks {
+		if click.ShortURL != shortURL {
+			clicks = append(clicks, click)
+		}
+	}
+	m.clicks = clicks
+	for key := range m.rollups {
+		if key.shortURL == shortURL {
+			delete(m.rollups, key)
+		}
+	}
+	alerts := m.alerts[:0]
+	for _, alert := range m.alerts {
+		if alert.ShortURL != shortURL {
+			alerts = append(alerts, alert)
+		}
+	}
+	m.alerts = alerts
+}
+
+func (m *Memory) Trash(ctx context.Context, shortURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return false, nil
+	}
+	link.deletedAt = storedNow()
+	return true, nil
+}
+
+func (m *Memory) Restore(ctx context.Context, shortURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok || !link.trashed() {
+		return ErrNotFound
+	}
+	link.deletedAt = time.Time{}
+	return nil
+}
+
+func (m *Memory) Trashed(ctx context.Context) ([]TrashedLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var trashed []TrashedLink
+	for _, link := range m.sortedLinks((*memoryLink).trashed) {
+		trashed = append(trashed, TrashedLink{LinkStats: link.LinkStats, DeletedAt: link.deletedAt})
+	}
+	sort.SliceStable(trashed, func(i, j int) bool { return trashed[i].DeletedAt.After(trashed[j].DeletedAt) })
+	return trashed, nil
+}
+
+func (m *Memory) PurgeTrash(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff = storedTime(cutoff)
+	var purged int64
+	for shortURL, link := range m.links {
+		if link.trashed() && link.deletedAt.Before(cutoff) {
+			m.delete(shortURL)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *Memory) SetActive(ctx context.Context, shortURL string, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	link.opts.Disabled = !active
+	if active {
+		link.takedown = 0
+		link.opts.TakenDown = false
+	}
+	return nil
+}
+
+func (m *Memory) SetLongURL(ctx context.Context, shortURL, longURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	link.LongURL = longURL
+	return nil
+}
+
+// underCap reports whether link may have another visit under its
+// MaxClicks.
+func (l *memoryLink) underCap() bool {
+	return l.opts.MaxClicks == 0 || l.VisitCount < l.opts.MaxClicks
+}
+
+func (m *Memory) RecordVisit(ctx context.Context, shortURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok || !link.underCap() {
+		return false, nil
+	}
+	link.VisitCount++
+	return true, nil
+}
+
+func (m *Memory) RecordBotVisit(ctx context.Context, shortURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok || !link.underCap() {
+		return false, nil
+	}
+	link.botVisits++
+	return true, nil
+}
+
+func (m *Memory) BotVisits(ctx context.Context, shortURL string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return link.botVisits, nil
+}
+
+func (m *Memory) Targets(ctx context.Context, shortURL string) (Targets, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.links[shortURL]
+	if !ok {
+		return Targets{}, ErrNotFound
+	}
+	return link.targets, nil
+}
+
+func (m *Memory) SetTargets(ctx context.Context, shortURL string, targets Targets) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	link.targets = targets
+	return nil
+}
+
+func (m *Memory) Card(ctx context.Context, shortURL string) (Card, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.links[shortURL]
+	if !ok {
+		return Card{}, ErrNotFound
+	}
+	return link.card, nil
+}
+
+func (m *Memory) SetCard(ctx context.Context, shortURL string, card Card) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	link.card = card
+	return nil
+}
+
+func (m *Memory) Options(ctx context.Context, shortURL string) (Options, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.links[shortURL]
+	if !ok {
+		return Options{}, ErrNotFound
+	}
+	return link.opts, nil
+}
+
+func (m *Memory) SetOptions(ctx context.Context, shortURL string, opts Options) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok {
+		return ErrNotFound
+	}
+	// Disabled belongs to SetActive, Flagged to FlagLink, TakenDown to
+	// TakeDown and APIKey to SetAPIKey.
+	opts.Disabled = link.opts.Disabled
+	opts.Flagged = link.opts.Flagged
+	opts.TakenDown = link.opts.TakenDown
+	opts.APIKey = link.opts.APIKey
+	if opts.ExpiresAt != nil {
+		expiresAt := storedTime(*opts.ExpiresAt)
+		opts.ExpiresAt = &expiresAt
+	}
+	link.opts = opts
+	return nil
+}
+
+func (m *Memory) Variants(ctx context.Context, shortURL string) ([]Variant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Variant(nil), m.variants[shortURL]...), nil
+}
+
+func (m *Memory) SetVariants(ctx context.Context, shortURL string, variants []Variant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(variants) == 0 {
+		delete(m.variants, shortURL)
+		return nil
+	}
+	stored := make([]Variant, len(variants))
+	for i, v := range variants {
+		stored[i] = Variant{Name: v.Name, URL: v.URL, Weight: v.Weight}
+	}
+	m.variants[shortURL] = stored
+	return nil
+}
+
+func (m *Memory) Tags(ctx context.Context, shortURL string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.tags[shortURL]...), nil
+}
+
+func (m *Memory) SetTags(ctx context.Context, shortURL string, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(tags) == 0 {
+		delete(m.tags, shortURL)
+		return nil
+	}
+	m.tags[shortURL] = sortedTags(tags)
+	return nil
+}
+
+func (m *Memory) TaggedLinks(ctx context.Context, tag string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var tagged []string
+	for _, link := range m.sortedLinks(isLive) {
+		if hasTag(m.tags[link.ShortURL], tag) {
+			tagged = append(tagged, link.ShortURL)
+		}
+	}
+	return tagged, nil
+}
+
+func (m *Memory) SearchLinks(ctx context.Context, query string, limit int) ([]LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return searchLinks(linkStats(m.sortedLinks(isLive)), func(shortURL string) ([]string, error) {
+		return m.tags[shortURL], nil
+	}, query, limit)
+}
+
+func (m *Memory) FlagLink(ctx context.Context, shortURL, source, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	if link.flag == nil {
+		link.flag = &FlaggedLink{Source: source, Reason: reason, FlaggedAt: storedNow()}
+		link.opts.Flagged = true
+	}
+	return nil
+}
+
+func (m *Memory) ClearFlag(ctx context.Context, shortURL string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.links[shortURL]
+	if !ok || link.flag == nil {
+		return false, nil
+	}
+	link.flag = nil
+	link.opts.Flagged = false
+	return true, nil
+}
+
+func (m *Memory) FlaggedLinks(ctx context.Context) ([]FlaggedLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var flagged []FlaggedLink
+	for _, link := range m.sortedLinks(isLive) {
+		if link.flag != nil {
+			l := *link.flag
+			l.LinkStats = link.LinkStats
+			flagged = append(flagged, l)
+		}
+	}
+	sort.SliceStable(flagged, func(i, j int) bool { return flagged[i].FlaggedAt.Before(flagged[j].FlaggedAt) })
+	return flagged, nil
+}
+
+func (m *Memory) BanDomain(ctx context.Context, domain, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	banned, ok := m.banned[domain]
+	if !ok {
+		banned = BannedDomain{Domain: domain, BannedAt: storedNow()}
+	}
+	banned.Reason = reason
+	m.banned[domain] = banned
+	return nil
+}
+
+func (m *Memory) UnbanDomain(ctx context.Context, domain string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.banned[domain]
+	delete(m.banned, domain)
+	return ok, nil
+}
+
+func (m *Memory) BannedDomains(ctx context.Context) ([]BannedDomain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var domains []BannedDomain
+	for _, domain := range m.banned {
+		domains = append(domains, domain)
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Domain < domains[j].Domain })
+	return domains, nil
+}
+
+func (m *Memory) CreateTakedown(ctx context.Context, takedown Takedown) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.takedownID++
+	takedown.ID = m.takedownID
+	takedown.CreatedAt = storedTime(takedown.CreatedAt)
+	takedown.Links = nil
+	m.takedowns = append(m.takedowns, takedown)
+	return takedown.ID, nil
+}
+
+func (m *Memory) TakeDown(ctx context.Context, shortURL string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	link.takedown = id
+	link.opts.Disabled = true
+	link.opts.TakenDown = true
+	return nil
+}
+
+func (m *Memory) Takedowns(ctx context.Context) ([]Takedown, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	links := m.sortedLinks(isLive)
+	var takedowns []Takedown
+	for i := len(m.takedowns) - 1; i >= 0; i-- {
+		takedown := m.takedowns[i]
+		for _, link := range links {
+			if link.takedown == takedown.ID {
+				takedown.Links = append(takedown.Links, link.ShortURL)
+			}
+		}
+		takedowns = append(takedowns, takedown)
+	}
+	return takedowns, nil
+}
+
+func (m *Memory) SetAPIKey(ctx context.Context, shortURL, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	link.opts.APIKey = key
+	return nil
+}
+
+func (m *Memory) RecordUsage(ctx context.Context, key, month string, creates, redirects int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := usageKey{key, month}
+	usage := m.usage[k]
+	usage.Month = month
+	usage.Creates += creates
+	usage.Redirects += redirects
+	m.usage[k] = usage
+	return nil
+}
+
+func (m *Memory) Usage(ctx context.Context, key string) ([]Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var usage []Usage
+	for k, u := range m.usage {
+		if k.key == key {
+			usage = append(usage, u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Month > usage[j].Month })
+	return usage, nil
+}
+
+func (m *Memory) DeleteUsage(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.usage {
+		if k.key == key {
+			delete(m.usage, k)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) SetOwner(ctx context.Context, shortURL, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	link.owner = owner
+	return nil
+}
+
+func (m *Memory) OwnerLinks(ctx context.Context, owner string) ([]LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return linkStats(m.sortedLinks(func(link *memoryLink) bool {
+		return isLive(link) && link.owner == owner
+	})), nil
+}
+
+func (m *Memory) AddSessionLink(ctx context.Context, session, shortURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.sessionLinks[:0]
+	for _, l := range m.sessionLinks {
+		if l.session != session || l.shortURL != shortURL {
+			kept = append(kept, l)
+		}
+	}
+	m.sessionLinks = append(kept, memorySessionLink{session: session, shortURL: shortURL, at: storedNow()})
+	return nil
+}
+
+func (m *Memory) SessionLinks(ctx context.Context, session string, limit int) ([]LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var links []LinkStats
+	for i := len(m.sessionLinks) - 1; i >= 0 && len(links) < limit; i-- {
+		l := m.sessionLinks[i]
+		if l.session != session {
+			continue
+		}
+		if link, ok := m.live(l.shortURL); ok {
+			links = append(links, link.LinkStats)
+		}
+	}
+	return links, nil
+}
+
+func (m *Memory) PurgeSessionLinks(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var purged int64
+	kept := m.sessionLinks[:0]
+	for _, l := range m.sessionLinks {
+		if l.at.Before(cutoff) {
+			purged++
+			continue
+		}
+		kept = append(kept, l)
+	}
+	m.sessionLinks = kept
+	return purged, nil
+}
+
+func (m *Memory) CreateTeam(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.teams[name]; ok {
+		return ErrTeamExists
+	}
+	m.teams[name] = Team{Name: name, CreatedAt: storedTime(time.Now())}
+	return nil
+}
+
+func (m *Memory) DeleteTeam(ctx context.Context, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.teams[name]; !ok {
+		return false, nil
+	}
+	delete(m.teams, name)
+	for member, team := range m.members {
+		if team == name {
+			delete(m.members, member)
+		}
+	}
+	for _, link := range m.links {
+		if link.team == name {
+			link.team = ""
+		}
+	}
+	return true, nil
+}
+
+func (m *Memory) Teams(ctx context.Context) ([]Team, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var teams []Team
+	for _, team := range m.teams {
+		team.Members = nil
+		for member, name := range m.members {
+			if name == team.Name {
+				team.Members = append(team.Members, member)
+			}
+		}
+		sort.Strings(team.Members)
+		teams = append(teams, team)
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, nil
+}
+
+func (m *Memory) SetTeamMember(ctx context.Context, team, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.teams[team]; !ok {
+		return ErrNotFound
+	}
+	m.members[member] = team
+	return nil
+}
+
+func (m *Memory) RemoveTeamMember(ctx context.Context, member string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.members[member]
+	delete(m.members, member)
+	return ok, nil
+}
+
+func (m *Memory) SetTeam(ctx context.Context, shortURL, team string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return ErrNotFound
+	}
+	link.team = team
+	return nil
+}
+
+func (m *Memory) TeamLinks(ctx context.Context, team string) ([]LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return linkStats(m.sortedLinks(func(link *memoryLink) bool {
+		return isLive(link) && link.team == team
+	})), nil
+}
+
+func (m *Memory) RecordVariantVisit(ctx context.Context, shortURL, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	variants := m.variants[shortURL]
+	for i := range variants {
+		if variants[i].Name == name {
+			variants[i].VisitCount++
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Health(ctx context.Context, shortURL string) (Health, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	health, ok := m.health[shortURL]
+	if !ok {
+		return Health{}, ErrNotFound
+	}
+	return health, nil
+}
+
+func (m *Memory) SetHealth(ctx context.Context, shortURL string, health Health) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health.CheckedAt = storedTime(health.CheckedAt)
+	m.health[shortURL] = health
+	return nil
+}
+
+func (m *Memory) BrokenLinks(ctx context.Context) ([]BrokenLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var broken []BrokenLink
+	for shortURL, health := range m.health {
+		link, ok := m.live(shortURL)
+		if !ok || health.Healthy() {
+			continue
+		}
+		broken = append(broken, BrokenLink{ShortURL: shortURL, LongURL: link.LongURL, Health: health})
+	}
+	sort.Slice(broken, func(i, j int) bool {
+		if !broken[i].CheckedAt.Equal(broken[j].CheckedAt) {
+			return broken[i].CheckedAt.After(broken[j].CheckedAt)
+		}
+		return broken[i].ShortURL < broken[j].ShortURL
+	})
+	return broken, nil
+}
+
+func (m *Memory) Links(ctx context.Context) ([]LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return linkStats(m.sortedLinks(isLive)), nil
+}
+
+func (m *Memory) LinksSince(ctx context.Context, since time.Time) ([]LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	since = storedTime(since)
+	links := m.sortedLinks(func(link *memoryLink) bool {
+		return !link.trashed() && !link.CreatedAt.Before(since)
+	})
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+	return linkStats(links), nil
+}
+
+func (m *Memory) TopLinks(ctx context.Context, since time.Time, limit int) ([]LinkClicks, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return topLinks(m.clicks, m.rollupList(), since, limit, func(shortURL string) (string, bool) {
+		link, ok := m.live(shortURL)
+		if !ok {
+			return "", false
+		}
+		return link.LongURL, true
+	}), nil
+}
+
+// rollupList returns every rollup, in no order.
+func (m *Memory) rollupList() []ClickRollup {
+	var rollups []ClickRollup
+	for _, rollup := range m.rollups {
+		rollups = append(rollups, *rollup)
+	}
+	return rollups
+}
+
+func (m *Memory) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auditID++
+	entry.ID = m.auditID
+	entry.CreatedAt = storedTime(entry.CreatedAt)
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+func (m *Memory) AuditLog(ctx context.Context, shortURL string, limit int) ([]AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []AuditEntry
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if shortURL == "" || m.audit[i].ShortURL == shortURL {
+			entries = append(entries, m.audit[i])
+		}
+	}
+	return entries[:limited(len(entries), limit)], nil
+}
+
+func (m *Memory) RecordClick(ctx context.Context, click Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	click.At = storedTime(click.At)
+	m.clicks = append(m.clicks, click)
+	return nil
+}
+
+func (m *Memory) RecentClicks(ctx context.Context, shortURL string, limit int) ([]Click, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	clicks := humanClicks(m.clicks, shortURL)
+	for i, j := 0, len(clicks)-1; i < j; i, j = i+1, j-1 {
+		clicks[i], clicks[j] = clicks[j], clicks[i]
+	}
+	return clicks[:limited(len(clicks), limit)], nil
+}
+
+func (m *Memory) ClickSeries(ctx context.Context, shortURL string, since time.Time, interval Interval) ([]ClickCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rollups []ClickRollup
+	for _, rollup := range m.rollupList() {
+		if shortURL == "" || rollup.ShortURL == shortURL {
+			rollups = append(rollups, rollup)
+		}
+	}
+	return clickSeries(humanClicks(m.clicks, shortURL), rollups, since, interval)
+}
+
+func (m *Memory) ClickHeatmap(ctx context.Context, shortURL string) (Heatmap, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return clickHeatmap(humanClicks(m.clicks, shortURL)), nil
+}
+
+func (m *Memory) ClickBursts(ctx context.Context, since time.Time, minClicks, maxIPs int) ([]Anomaly, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return clickBursts(m.clicks, since, minClicks, maxIPs), nil
+}
+
+func (m *Memory) RollupClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff = storedTime(cutoff)
+
+	var old, kept []Click
+	for _, click := range m.clicks {
+		if click.At.Before(cutoff) {
+			old = append(old, click)
+		} else {
+			kept = append(kept, click)
+		}
+	}
+	m.clicks = kept
+
+	// A day compacted again, say after the clock went back, adds to its
+	// rollup. Uniques may then count an address twice.
+	for key, day := range summarizeDays(old) {
+		if rollup, ok := m.rollups[key]; ok {
+			rollup.Clicks += day.Clicks
+			rollup.Uniques += day.Uniques
+			continue
+		}
+		m.rollups[key] = day
+	}
+	return int64(len(old)), nil
+}
+
+func (m *Memory) PurgeClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff = storedTime(cutoff)
+	kept := m.clicks[:0]
+	var purged int64
+	for _, click := range m.clicks {
+		if click.At.Before(cutoff) {
+			purged++
+			continue
+		}
+		kept = append(kept, click)
+	}
+	m.clicks = kept
+	return purged, nil
+}
+
+func (m *Memory) PurgeRollups(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoffDay := cutoff.UTC().Format("2006-01-02")
+	var purged int64
+	for key := range m.rollups {
+		if key.day < cutoffDay {
+			delete(m.rollups, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *Memory) ClickRollups(ctx context.Context, shortURL string, since time.Time) ([]ClickRollup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sinceDay := since.UTC().Format("2006-01-02")
+	var rollups []ClickRollup
+	for key, rollup := range m.rollups {
+		if key.day >= sinceDay && (shortURL == "" || key.shortURL == shortURL) {
+			rollups = append(rollups, *rollup)
+		}
+	}
+	sortRollups(rollups)
+	return rollups, nil
+}
+
+func (m *Memory) RecordAnomaly(ctx context.Context, anomaly Anomaly) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.anomalyID++
+	anomaly.ID = m.anomalyID
+	anomaly.WindowStart = storedTime(anomaly.WindowStart)
+	anomaly.DetectedAt = storedTime(anomaly.DetectedAt)
+	m.anomalies = append(m.anomalies, anomaly)
+	return nil
+}
+
+func (m *Memory) Anomalies(ctx context.Context, limit int) ([]Anomaly, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var anomalies []Anomaly
+	for i := len(m.anomalies) - 1; i >= 0; i-- {
+		anomalies = append(anomalies, m.anomalies[i])
+	}
+	return anomalies[:limited(len(anomalies), limit)], nil
+}
+
+// memoryAlert copies alert with its times kept as SQLite would.
+func memoryAlert(alert Alert) Alert {
+	alert.LastChange = storedTime(alert.LastChange)
+	alert.CreatedAt = storedTime(alert.CreatedAt)
+	if alert.FiredAt != nil {
+		firedAt := storedTime(*alert.FiredAt)
+		alert.FiredAt = &firedAt
+	}
+	return alert
+}
+
+func (m *Memory) CreateAlert(ctx context.Context, alert Alert) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alertID++
+	alert = memoryAlert(alert)
+	alert.ID = m.alertID
+	m.alerts = append(m.alerts, alert)
+	return alert.ID, nil
+}
+
+func (m *Memory) Alerts(ctx context.Context, shortURL string) ([]Alert, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var alerts []Alert
+	for _, alert := range m.alerts {
+		if shortURL == "" || alert.ShortURL == shortURL {
+			alerts = append(alerts, memoryAlert(alert))
+		}
+	}
+	return alerts, nil
+}
+
+func (m *Memory) UpdateAlert(ctx context.Context, alert Alert) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	alert = memoryAlert(alert)
+	for i := range m.alerts {
+		if m.alerts[i].ID == alert.ID {
+			m.alerts[i].LastCount = alert.LastCount
+			m.alerts[i].LastChange = alert.LastChange
+			m.alerts[i].FiredAt = alert.FiredAt
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteAlert(ctx context.Context, shortURL string, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, alert := range m.alerts {
+		if alert.ID == id && alert.ShortURL == shortURL {
+			m.alerts = append(m.alerts[:i], m.alerts[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := storedNow()
+	lease, ok := m.leases[name]
+	if ok && lease.holder != holder && lease.expiresAt.After(now) {
+		return false, nil
+	}
+	m.leases[name] = memoryLease{holder: holder, expiresAt: storedTime(now.Add(ttl))}
+	return true, nil
+}
+
+func (m *Memory) Link(ctx context.Context, shortURL string) (LinkStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.live(shortURL)
+	if !ok {
+		return LinkStats{}, ErrNotFound
+	}
+	return link.LinkStats, nil
+}
+
+func (m *Memory) Stats(ctx context.Context) (Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return linkStatsFigures(linkStats(m.sortedLinks(isLive))), nil
+}
+
+func (m *Memory) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	m.mu.RLock()
+	var codes []string
+	for shortURL := range m.links {
+		codes = append(codes, shortURL)
+	}
+	m.mu.RUnlock()
+	// fn runs unlocked, so it may call back into the store.
+	for _, shortURL := range codes {
+		fn(shortURL)
+	}
+	return nil
+}
+
+func (m *Memory) HotLinks(ctx context.Context, limit int) ([]HotLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	links := m.sortedLinks(isLive)
+	sort.SliceStable(links, func(i, j int) bool { return links[i].VisitCount > links[j].VisitCount })
+	var hot []HotLink
+	for _, link := range links[:limited(len(links), limit)] {
+		hot = append(hot, HotLink{ShortURL: link.ShortURL, LongURL: link.LongURL, Options: link.opts})
+	}
+	return hot, nil
+}
+
+func (m *Memory) Count(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, link := range m.links {
+		if !link.trashed() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Close does nothing: there is nothing to release, and the links are lost
+// when the process exits anyway.
+func (m *Memory) Close() error {
+	return nil
+}
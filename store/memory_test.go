@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Memory is held to the same tests as SQLite, so it can stand in for it.
+
+func TestMemoryRoundTrip(t *testing.T) {
+	testRoundTrip(t, NewMemory())
+}
+
+func TestMemoryClickLog(t *testing.T) {
+	testClickLog(t, NewMemory())
+}
+
+func TestMemoryAnomalies(t *testing.T) {
+	testAnomalies(t, NewMemory())
+}
+
+func TestMemoryClickRollups(t *testing.T) {
+	testClickRollups(t, NewMemory())
+}
+
+func TestMemoryLinksSinceAndTopLinks(t *testing.T) {
+	m := NewMemory()
+	testLinksSinceAndTopLinks(t, m, func(shortURL string) {
+		m.links[shortURL].CreatedAt = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	})
+}
+
+func TestMemoryAlerts(t *testing.T) {
+	testAlerts(t, NewMemory())
+}
+
+func TestMemoryEditLink(t *testing.T) {
+	testEditLink(t, NewMemory())
+}
+
+func TestMemoryModeration(t *testing.T) {
+	testModeration(t, NewMemory())
+}
+
+func TestMemoryTakedowns(t *testing.T) {
+	testTakedowns(t, NewMemory())
+}
+
+func TestMemoryAPIKeyUsage(t *testing.T) {
+	testAPIKeyUsage(t, NewMemory())
+}
+
+func TestMemoryTeams(t *testing.T) {
+	testTeams(t, NewMemory())
+}
+
+func TestMemoryOwners(t *testing.T) {
+	testOwners(t, NewMemory())
+}
+
+func TestMemorySessionLinks(t *testing.T) {
+	testSessionLinks(t, NewMemory())
+}
+
+func TestMemorySearchLinks(t *testing.T) {
+	testSearchLinks(t, NewMemory())
+}
+
+func TestMemoryCard(t *testing.T) {
+	testCard(t, NewMemory())
+}
+
+func TestMemoryAcquireLease(t *testing.T) {
+	testAcquireLease(t, NewMemory())
+}
+
+func TestMemoryStats(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	for i, shortURL := range []string{"quiet", "busy", "gone"} {
+		if err := m.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+		for v := 0; v < i+1; v++ {
+			if _, err := m.RecordVisit(ctx, shortURL); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := m.Trash(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := m.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats returned an error: %v", err)
+	}
+	if stats.TotalLinks != 2 || stats.TotalClicks != 3 {
+		t.Errorf("Stats counted %d links and %d clicks, want 2 and 3", stats.TotalLinks, stats.TotalClicks)
+	}
+	if len(stats.PopularLinks) != 2 || stats.PopularLinks[0].ShortURL != "busy" {
+		t.Errorf("Stats returned popular links %+v", stats.PopularLinks)
+	}
+	if count, err := m.Count(ctx); err != nil || count != 2 {
+		t.Errorf("Count returned %v, %v want 2", count, err)
+	}
+	if hot, err := m.HotLinks(ctx, 1); err != nil || len(hot) != 1 || hot[0].ShortURL != "busy" {
+		t.Errorf("HotLinks returned %+v, %v", hot, err)
+	}
+
+	var codes int
+	if err := m.ForEachShortURL(ctx, func(string) { codes++ }); err != nil || codes != 3 {
+		t.Errorf("ForEachShortURL saw %d codes, %v want 3 with the trashed one", codes, err)
+	}
+}
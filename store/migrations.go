@@ -0,0 +1,628 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Migration is one step of the database schema. Steps run in order, each in
+// its own transaction, and the highest applied version is recorded in the
+// schema_version table. Never edit a migration that has shipped; add a new
+// one to the end of the list instead.
+type Migration struct {
+	Version     int
+	Description string
+	up          func(tx *sql.Tx) error
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create url_mapping table",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS url_mapping (
+				short_url TEXT PRIMARY KEY,
+				long_url TEXT NOT NULL,
+				visit_count INTEGER DEFAULT 0,
+				created_at TEXT DEFAULT CURRENT_TIMESTAMP
+			)`)
+			return err
+		},
+	},
+	{
+		// Databases created before created_at existed are brought up to
+		// date here; on fresh databases the column is already there.
+		Version:     2,
+		Description: "add created_at column",
+		up: func(tx *sql.Tx) error {
+			exists, err := columnExists(tx, "url_mapping", "created_at")
+			if err != nil || exists {
+				return err
+			}
+			if _, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN created_at TEXT`); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE url_mapping SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL`)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "add per-platform targets",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN ios_url TEXT`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN android_url TEXT`)
+			return err
+		},
+	},
+	{
+		// Variants go when their link does; the trigger saves every
+		// delete from having to remember them.
+		Version:     4,
+		Description: "add A/B link variants",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE link_variants (
+				short_url TEXT NOT NULL,
+				name TEXT NOT NULL,
+				url TEXT NOT NULL,
+				weight INTEGER NOT NULL,
+				visit_count INTEGER DEFAULT 0,
+				PRIMARY KEY (short_url, name)
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TRIGGER delete_link_variants AFTER DELETE ON url_mapping
+				BEGIN
+					DELETE FROM link_variants WHERE short_url = OLD.short_url;
+				END`)
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "add query passthrough option",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN pass_query INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "add prefix link option",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN prefix INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "add UTM tags",
+		up: func(tx *sql.Tx) error {
+			for _, column := range []string{"utm_source", "utm_medium", "utm_campaign"} {
+				if _, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     8,
+		Description: "add link health checks",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN fallback_url TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE TABLE link_health (
+				short_url TEXT PRIMARY KEY,
+				status_code INTEGER NOT NULL,
+				error TEXT NOT NULL,
+				checked_at TEXT NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TRIGGER delete_link_health AFTER DELETE ON url_mapping
+				BEGIN
+					DELETE FROM link_health WHERE short_url = OLD.short_url;
+				END`)
+			return err
+		},
+	},
+	{
+		Version:     9,
+		Description: "add click caps",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+	},
+	{
+		Version:     10,
+		Description: "add active flag",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN active INTEGER NOT NULL DEFAULT 1`)
+			return err
+		},
+	},
+	{
+		// Audit entries outlive their links, so there is no delete
+		// trigger.
+		Version:     11,
+		Description: "add audit log",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE audit_log (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT NOT NULL,
+				action TEXT NOT NULL,
+				actor TEXT NOT NULL,
+				before TEXT NOT NULL DEFAULT '',
+				after TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX idx_audit_log_short_url ON audit_log (short_url)`)
+			return err
+		},
+	},
+	{
+		Version:     12,
+		Description: "add trash",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN deleted_at TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+	},
+	{
+		// AUTOINCREMENT never hands out an id twice, even after the row
+		// holding it is deleted, so the table stays empty.
+		Version:     13,
+		Description: "add short URL sequence",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE short_url_sequence (id INTEGER PRIMARY KEY AUTOINCREMENT)`)
+			return err
+		},
+	},
+	{
+		Version:     14,
+		Description: "add click log",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE clicks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT NOT NULL,
+				referrer TEXT NOT NULL DEFAULT '',
+				user_agent TEXT NOT NULL DEFAULT '',
+				ip TEXT NOT NULL DEFAULT '',
+				clicked_at TEXT NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`CREATE INDEX idx_clicks_clicked_at ON clicks (clicked_at)`); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`CREATE INDEX idx_clicks_short_url ON clicks (short_url, clicked_at)`); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TRIGGER delete_clicks AFTER DELETE ON url_mapping
+				BEGIN
+					DELETE FROM clicks WHERE short_url = OLD.short_url;
+				END`)
+			return err
+		},
+	},
+	{
+		Version:     15,
+		Description: "count bot visits apart",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN bot_visit_count INTEGER NOT NULL DEFAULT 0`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`ALTER TABLE clicks ADD COLUMN bot TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+	},
+	{
+		// Like the audit log, anomalies are kept after their link goes.
+		Version:     16,
+		Description: "add click anomalies",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE anomalies (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT NOT NULL,
+				clicks INTEGER NOT NULL,
+				unique_ips INTEGER NOT NULL,
+				window_start TEXT NOT NULL,
+				detected_at TEXT NOT NULL
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     17,
+		Description: "add daily click rollups",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE clicks ADD COLUMN country TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`CREATE TABLE click_rollups (
+				short_url TEXT NOT NULL,
+				day TEXT NOT NULL,
+				clicks INTEGER NOT NULL,
+				uniques INTEGER NOT NULL,
+				top_referrer TEXT NOT NULL DEFAULT '',
+				top_country TEXT NOT NULL DEFAULT '',
+				PRIMARY KEY (short_url, day)
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TRIGGER delete_click_rollups AFTER DELETE ON url_mapping
+				BEGIN
+					DELETE FROM click_rollups WHERE short_url = OLD.short_url;
+				END`)
+			return err
+		},
+	},
+	{
+		Version:     18,
+		Description: "add per-link analytics opt-out",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN no_analytics INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+	},
+	{
+		Version:     19,
+		Description: "add link alerts",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE alerts (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				short_url TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				threshold INTEGER NOT NULL,
+				email TEXT NOT NULL DEFAULT '',
+				webhook TEXT NOT NULL DEFAULT '',
+				last_count INTEGER NOT NULL DEFAULT 0,
+				last_change TEXT NOT NULL,
+				fired_at TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`CREATE INDEX idx_alerts_short_url ON alerts (short_url)`); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TRIGGER delete_alerts AFTER DELETE ON url_mapping
+				BEGIN
+					DELETE FROM alerts WHERE short_url = OLD.short_url;
+				END`)
+			return err
+		},
+	},
+	{
+		Version:     20,
+		Description: "add job leases",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE leases (
+				name TEXT PRIMARY KEY,
+				holder TEXT NOT NULL,
+				expires_at TEXT NOT NULL
+			)`)
+			return err
+		},
+	},
+	{
+		// The stats page lists the most visited and newest links, and
+		// creating a link looks up whether its long URL is already short.
+		Version:     21,
+		Description: "index links by visits, age and long URL",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE INDEX idx_url_mapping_visit_count ON url_mapping (visit_count)`,
+				`CREATE INDEX idx_url_mapping_created_at ON url_mapping (created_at)`,
+				`CREATE INDEX idx_url_mapping_long_url ON url_mapping (long_url)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     22,
+		Description: "add link expiry",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN expires_at TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+	},
+	{
+		Version:     23,
+		Description: "add link tags",
+		up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE link_tags (
+				short_url TEXT NOT NULL,
+				tag TEXT NOT NULL,
+				PRIMARY KEY (short_url, tag)
+			)`)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`CREATE INDEX idx_link_tags_tag ON link_tags (tag)`); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TRIGGER delete_link_tags AFTER DELETE ON url_mapping
+				BEGIN
+					DELETE FROM link_tags WHERE short_url = OLD.short_url;
+				END`)
+			return err
+		},
+	},
+	{
+		// A flagged link waits for a moderator with its flag on the link
+		// itself, so the redirect reads it with the other options.
+		Version:     24,
+		Description: "add the moderation queue and banned domains",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`ALTER TABLE url_mapping ADD COLUMN flagged_at TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE url_mapping ADD COLUMN flag_source TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE url_mapping ADD COLUMN flag_reason TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX idx_url_mapping_flagged_at ON url_mapping (flagged_at) WHERE flagged_at != ''`,
+				`CREATE TABLE banned_domains (
+					domain TEXT PRIMARY KEY,
+					reason TEXT NOT NULL DEFAULT '',
+					banned_at TEXT NOT NULL DEFAULT (datetime('now'))
+				)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// A taken down link points at its notice, so the redirect can
+		// tell it from one disabled for other reasons.
+		Version:     25,
+		Description: "add takedown notices",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE TABLE takedowns (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					kind TEXT NOT NULL,
+					target TEXT NOT NULL,
+					requester TEXT NOT NULL DEFAULT '',
+					reference TEXT NOT NULL DEFAULT '',
+					reason TEXT NOT NULL DEFAULT '',
+					created_at TEXT NOT NULL
+				)`,
+				`ALTER TABLE url_mapping ADD COLUMN takedown_id INTEGER NOT NULL DEFAULT 0`,
+				`CREATE INDEX idx_url_mapping_takedown_id ON url_mapping (takedown_id) WHERE takedown_id != 0`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Links remember the API key that created them so their
+		// redirects count towards its monthly quota.
+		Version:     26,
+		Description: "add API key usage",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE TABLE api_key_usage (
+					api_key TEXT NOT NULL,
+					month TEXT NOT NULL,
+					creates INTEGER NOT NULL DEFAULT 0,
+					redirects INTEGER NOT NULL DEFAULT 0,
+					PRIMARY KEY (api_key, month)
+				)`,
+				`ALTER TABLE url_mapping ADD COLUMN api_key TEXT NOT NULL DEFAULT ''`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// A member is in at most one team, so the links it creates have
+		// one owner.
+		Version:     27,
+		Description: "add teams",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE TABLE teams (
+					name TEXT PRIMARY KEY,
+					created_at TEXT NOT NULL
+				)`,
+				`CREATE TABLE team_members (
+					member TEXT PRIMARY KEY,
+					team TEXT NOT NULL REFERENCES teams (name)
+				)`,
+				`ALTER TABLE url_mapping ADD COLUMN team TEXT NOT NULL DEFAULT ''`,
+				`CREATE INDEX idx_url_mapping_team ON url_mapping (team) WHERE team != ''`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Links created with an API key so far belong to the key.
+		Version:     28,
+		Description: "add link owners",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`ALTER TABLE url_mapping ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+				`UPDATE url_mapping SET owner = api_key WHERE api_key != ''`,
+				`CREATE INDEX idx_url_mapping_owner ON url_mapping (owner) WHERE owner != ''`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Browsers without an account still get to see the links they
+		// made, including existing links the create form handed them,
+		// so these don't go in url_mapping.owner. The rowid orders them.
+		Version:     29,
+		Description: "add session links",
+		up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE TABLE session_links (
+					session TEXT NOT NULL,
+					short_url TEXT NOT NULL,
+					created_at TEXT NOT NULL,
+					UNIQUE (session, short_url)
+				)`,
+				`CREATE INDEX idx_session_links_created_at ON session_links (created_at)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		// Links were created with SQLite's "2006-01-02 15:04:05", and
+		// other tools write RFC 3339 or Unix seconds. Each is rewritten
+		// as RFC 3339 in UTC, which new links get, so created_at sorts and
+		// compares as a string. NULLs become empty, and values SQLite can't
+		// read are left alone and logged; both read as an unknown time.
+		Version:     30,
+		Description: "store link creation times as RFC 3339",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`UPDATE url_mapping SET created_at = COALESCE(
+				CASE WHEN created_at != '' AND created_at NOT GLOB '*[^0-9]*'
+					THEN strftime('%Y-%m-%dT%H:%M:%SZ', created_at, 'unixepoch')
+					ELSE strftime('%Y-%m-%dT%H:%M:%SZ', created_at)
+				END, created_at, '')`); err != nil {
+				return err
+			}
+			rows, err := tx.Query(`SELECT short_url, created_at FROM url_mapping WHERE created_at != '' AND strftime('%s', created_at) IS NULL`)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var shortURL, createdAt string
+				if err := rows.Scan(&shortURL, &createdAt); err != nil {
+					return err
+				}
+				log.Printf("Left created_at %q of short URL '%s' as it is, it isn't a time", createdAt, shortURL)
+			}
+			return rows.Err()
+		},
+	},
+	{
+		Version:     31,
+		Description: "add link preview cards",
+		up: func(tx *sql.Tx) error {
+			for _, column := range []string{"card_title", "card_description", "card_image"} {
+				if _, err := tx.Exec(`ALTER TABLE url_mapping ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&exists)
+	return exists, err
+}
+
+func ensureSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TEXT DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return err
+}
+
+// SchemaVersion returns the highest migration version applied to db.
+func SchemaVersion(db *sql.DB) (int, error) {
+	if err := ensureSchemaVersionTable(db); err != nil {
+		return 0, err
+	}
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// Migrate applies every pending migration and returns how many ran.
+func Migrate(db *sql.DB) (int, error) {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %v", m.Version, m.Description, err)
+		}
+		log.Printf("Applied migration %d: %s", m.Version, m.Description)
+		applied++
+	}
+	return applied, nil
+}
+
+// Pending returns the migrations that have not been applied to db yet.
+func Pending(db *sql.DB) ([]Migration, error) {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := m.up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, description) VALUES (?, ?)`, m.Version, m.Description); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
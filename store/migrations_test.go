@@ -0,0 +1,165 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	testDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+	return testDB
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	testDB := openTestDB(t)
+
+	applied, err := Migrate(testDB)
+	if err != nil {
+		t.Fatalf("Migrate returned an error: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("Migrate applied wrong number of migrations: got %v want %v", applied, len(migrations))
+	}
+
+	version, err := SchemaVersion(testDB)
+	if err != nil {
+		t.Fatalf("SchemaVersion returned an error: %v", err)
+	}
+	if want := migrations[len(migrations)-1].Version; version != want {
+		t.Errorf("SchemaVersion returned wrong version: got %v want %v", version, want)
+	}
+
+	applied, err = Migrate(testDB)
+	if err != nil {
+		t.Fatalf("second Migrate returned an error: %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("second Migrate should be a no-op, applied %v", applied)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	testDB := openTestDB(t)
+
+	// A database from before created_at and schema_version existed.
+	_, err := testDB.Exec(`CREATE TABLE url_mapping (
+		short_url TEXT PRIMARY KEY,
+		long_url TEXT NOT NULL,
+		visit_count INTEGER DEFAULT 0
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testDB.Exec(`INSERT INTO url_mapping (short_url, long_url) VALUES ('abc123', 'https://example.com')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Migrate(testDB); err != nil {
+		t.Fatalf("Migrate returned an error: %v", err)
+	}
+
+	var createdAt sql.NullString
+	err = testDB.QueryRow(`SELECT created_at FROM url_mapping WHERE short_url = 'abc123'`).Scan(&createdAt)
+	if err != nil {
+		t.Fatalf("Failed to read created_at: %v", err)
+	}
+	if !createdAt.Valid || createdAt.String == "" {
+		t.Error("Migrate did not backfill created_at on existing rows")
+	}
+}
+
+func TestMigrateCreatedAt(t *testing.T) {
+	testDB := openTestDB(t)
+	if _, err := Migrate(testDB); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows written before RFC 3339 or by other tools, migrated again.
+	for shortURL, createdAt := range map[string]string{
+		"legacy": "2024-01-02 15:04:05",
+		"offset": "2024-01-02T17:04:05+02:00",
+		"epoch":  "1704207845",
+		"broken": "yesterday",
+	} {
+		if _, err := testDB.Exec(`INSERT INTO url_mapping (short_url, long_url, created_at) VALUES (?, 'https://example.com', ?)`, shortURL, createdAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := testDB.Exec(`INSERT INTO url_mapping (short_url, long_url, created_at) VALUES ('missing', 'https://example.com', NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testDB.Exec(`DELETE FROM schema_version WHERE version = 30`); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.Version == 30 {
+			if err := applyMigration(testDB, m); err != nil {
+				t.Fatalf("Migration 30 returned an error: %v", err)
+			}
+		}
+	}
+
+	for shortURL, want := range map[string]string{
+		"legacy":  "2024-01-02T15:04:05Z",
+		"offset":  "2024-01-02T15:04:05Z",
+		"epoch":   "2024-01-02T15:04:05Z",
+		"broken":  "yesterday",
+		"missing": "",
+	} {
+		var createdAt string
+		if err := testDB.QueryRow(`SELECT created_at FROM url_mapping WHERE short_url = ?`, shortURL).Scan(&createdAt); err != nil {
+			t.Fatal(err)
+		}
+		if createdAt != want {
+			t.Errorf("Migrate rewrote %s's created_at to %q want %q", shortURL, createdAt, want)
+		}
+	}
+
+	// Links whose creation time can't be read are still listed.
+	s, err := NewSQLite(testDB, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.Links(context.Background())
+	if err != nil || len(links) != 5 {
+		t.Fatalf("Links returned %d links, %v want 5", len(links), err)
+	}
+	for _, link := range links {
+		if (link.ShortURL == "broken" || link.ShortURL == "missing") != link.CreatedAt.IsZero() {
+			t.Errorf("Links returned created_at %v for %s", link.CreatedAt, link.ShortURL)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	testDB := openTestDB(t)
+
+	pending, err := Pending(testDB)
+	if err != nil {
+		t.Fatalf("Pending returned an error: %v", err)
+	}
+	if len(pending) != len(migrations) {
+		t.Errorf("Pending returned wrong number of migrations: got %v want %v", len(pending), len(migrations))
+	}
+
+	if _, err := Migrate(testDB); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = Pending(testDB)
+	if err != nil {
+		t.Fatalf("Pending returned an error: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Pending returned migrations after Migrate: %v", pending)
+	}
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Replicated is a Store that reads from a replica of the database and writes
+// to the primary. Redirect lookups and stats go to Replica. Everything else
+// goes to the embedded primary Store: creations, visit counts, and the
+// reads a write depends on, such as checking whether a code is taken.
+//
+// A replica lags the primary. So a lookup that finds no link on the replica
+// is tried again on the primary, which keeps a link working the moment it's
+// made. Other changes, such as disabling a link, reach redirects once the
+// replica catches up.
+type Replicated struct {
+	Store
+	Replica Store
+}
+
+// NewReplicated routes reads to replica and everything else to primary.
+func NewReplicated(primary, replica Store) *Replicated {
+	return &Replicated{Store: primary, Replica: replica}
+}
+
+// Unwrap returns the primary store.
+func (r *Replicated) Unwrap() Store {
+	return r.Store
+}
+
+func (r *Replicated) LongURL(ctx context.Context, shortURL string) (string, error) {
+	longURL, err := r.Replica.LongURL(ctx, shortURL)
+	if err == ErrNotFound {
+		return r.Store.LongURL(ctx, shortURL)
+	}
+	return longURL, err
+}
+
+func (r *Replicated) Targets(ctx context.Context, shortURL string) (Targets, error) {
+	targets, err := r.Replica.Targets(ctx, shortURL)
+	if err == ErrNotFound {
+		return r.Store.Targets(ctx, shortURL)
+	}
+	return targets, err
+}
+
+func (r *Replicated) Card(ctx context.Context, shortURL string) (Card, error) {
+	card, err := r.Replica.Card(ctx, shortURL)
+	if err == ErrNotFound {
+		return r.Store.Card(ctx, shortURL)
+	}
+	return card, err
+}
+
+func (r *Replicated) Options(ctx context.Context, shortURL string) (Options, error) {
+	opts, err := r.Replica.Options(ctx, shortURL)
+	if err == ErrNotFound {
+		return r.Store.Options(ctx, shortURL)
+	}
+	return opts, err
+}
+
+// Variants reads the primary while the replica hasn't got the link yet.
+// A missing link has no variants rather than ErrNotFound, so only an empty
+// answer for a link the replica doesn't know goes to the primary.
+func (r *Replicated) Variants(ctx context.Context, shortURL string) ([]Variant, error) {
+	variants, err := r.Replica.Variants(ctx, shortURL)
+	if err != nil || len(variants) > 0 {
+		return variants, err
+	}
+	if _, err := r.Replica.LongURL(ctx, shortURL); err == ErrNotFound {
+		return r.Store.Variants(ctx, shortURL)
+	}
+	return variants, nil
+}
+
+func (r *Replicated) BotVisits(ctx context.Context, shortURL string) (int, error) {
+	visits, err := r.Replica.BotVisits(ctx, shortURL)
+	if err == ErrNotFound {
+		return r.Store.BotVisits(ctx, shortURL)
+	}
+	return visits, err
+}
+
+func (r *Replicated) Link(ctx context.Context, shortURL string) (LinkStats, error) {
+	link, err := r.Replica.Link(ctx, shortURL)
+	if err == ErrNotFound {
+		return r.Store.Link(ctx, shortURL)
+	}
+	return link, err
+}
+
+func (r *Replicated) Links(ctx context.Context) ([]LinkStats, error) {
+	return r.Replica.Links(ctx)
+}
+
+func (r *Replicated) LinksSince(ctx context.Context, since time.Time) ([]LinkStats, error) {
+	return r.Replica.LinksSince(ctx, since)
+}
+
+func (r *Replicated) SearchLinks(ctx context.Context, query string, limit int) ([]LinkStats, error) {
+	return r.Replica.SearchLinks(ctx, query, limit)
+}
+
+func (r *Replicated) TopLinks(ctx context.Context, since time.Time, limit int) ([]LinkClicks, error) {
+	return r.Replica.TopLinks(ctx, since, limit)
+}
+
+func (r *Replicated) RecentClicks(ctx context.Context, shortURL string, limit int) ([]Click, error) {
+	return r.Replica.RecentClicks(ctx, shortURL, limit)
+}
+
+func (r *Replicated) ClickSeries(ctx context.Context, shortURL string, since time.Time, interval Interval) ([]ClickCount, error) {
+	return r.Replica.ClickSeries(ctx, shortURL, since, interval)
+}
+
+func (r *Replicated) ClickHeatmap(ctx context.Context, shortURL string) (Heatmap, error) {
+	return r.Replica.ClickHeatmap(ctx, shortURL)
+}
+
+func (r *Replicated) ClickRollups(ctx context.Context, shortURL string, since time.Time) ([]ClickRollup, error) {
+	return r.Replica.ClickRollups(ctx, shortURL, since)
+}
+
+func (r *Replicated) Stats(ctx context.Context) (Stats, error) {
+	return r.Replica.Stats(ctx)
+}
+
+func (r *Replicated) HotLinks(ctx context.Context, limit int) ([]HotLink, error) {
+	return r.Replica.HotLinks(ctx, limit)
+}
+
+func (r *Replicated) Count(ctx context.Context) (int, error) {
+	return r.Replica.Count(ctx)
+}
+
+// Close closes the replica and the primary.
+func (r *Replicated) Close() error {
+	return errors.Join(r.Replica.Close(), r.Store.Close())
+}
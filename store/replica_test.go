@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/donuts-are-good/shorty/config"
+)
+
+func newTestSQLite(t *testing.T) *SQLite {
+	t.Helper()
+
+	testDB := openTestDB(t)
+	if _, err := Migrate(testDB); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSQLite(testDB, config.DefaultQueryTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReplicated(t *testing.T) {
+	primary, replica := newTestSQLite(t), newTestSQLite(t)
+	r := NewReplicated(primary, replica)
+	ctx := context.Background()
+
+	// Writes go to the primary only.
+	if err := r.Create(ctx, "fresh", "https://example.com/fresh"); err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	if _, err := replica.LongURL(ctx, "fresh"); err != ErrNotFound {
+		t.Errorf("Create reached the replica: LongURL returned %v, want ErrNotFound", err)
+	}
+
+	// A link the replica hasn't caught up with is found on the primary.
+	if longURL, err := r.LongURL(ctx, "fresh"); err != nil || longURL != "https://example.com/fresh" {
+		t.Errorf("LongURL of a new link returned %q, %v", longURL, err)
+	}
+	if _, err := r.Options(ctx, "fresh"); err != nil {
+		t.Errorf("Options of a new link returned an error: %v", err)
+	}
+	split := []Variant{{Name: "a", URL: "https://example.com/a", Weight: 1}, {Name: "b", URL: "https://example.com/b", Weight: 1}}
+	if err := r.SetVariants(ctx, "fresh", split); err != nil {
+		t.Fatal(err)
+	}
+	if variants, err := r.Variants(ctx, "fresh"); err != nil || len(variants) != 2 {
+		t.Errorf("Variants of a new split link returned %+v, %v", variants, err)
+	}
+	if _, err := r.LongURL(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("LongURL of an unknown link returned %v, want ErrNotFound", err)
+	}
+
+	// Reads come from the replica once it has the link.
+	if err := replica.Create(ctx, "fresh", "https://example.com/replicated"); err != nil {
+		t.Fatal(err)
+	}
+	if longURL, err := r.LongURL(ctx, "fresh"); err != nil || longURL != "https://example.com/replicated" {
+		t.Errorf("LongURL returned %q, %v, want the replica's URL", longURL, err)
+	}
+	if _, err := r.RecordVisit(ctx, "fresh"); err != nil {
+		t.Fatalf("RecordVisit returned an error: %v", err)
+	}
+	if link, err := primary.Link(ctx, "fresh"); err != nil || link.VisitCount != 1 {
+		t.Errorf("primary has %+v, %v, want the visit counted there", link, err)
+	}
+	if stats, err := r.Stats(ctx); err != nil || stats.TotalClicks != 0 {
+		t.Errorf("Stats returned %+v, %v, want the replica's figures", stats, err)
+	}
+}
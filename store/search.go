@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// Searching links with LIKE reads every row. Where SQLite has the FTS5
+// extension, links are also indexed in links_fts with the trigram
+// tokenizer, which finds any substring of three or more characters from
+// the index. go-sqlite3 only has FTS5 when built with -tags sqlite_fts5;
+// the pure-Go driver always has it. Whether the index can exist depends on
+// the build rather than the schema version, so Open sets it up instead of
+// a migration, and takes its triggers down again when a build without
+// FTS5 opens the database, as every write would fail on them. The index
+// is keyed by url_mapping's rowid, which VACUUM may renumber, so Vacuum
+// rebuilds it.
+
+// minIndexedSearch is the shortest query the trigram index can answer.
+const minIndexedSearch = 3
+
+var searchIndexTriggers = []struct{ name, body string }{
+	{"links_fts_insert", `AFTER INSERT ON url_mapping BEGIN
+		INSERT INTO links_fts (rowid, short_url, long_url, tags) VALUES (NEW.rowid, NEW.short_url, NEW.long_url, '');
+	END`},
+	{"links_fts_update", `AFTER UPDATE OF long_url ON url_mapping BEGIN
+		UPDATE links_fts SET long_url = NEW.long_url WHERE rowid = NEW.rowid;
+	END`},
+	{"links_fts_delete", `AFTER DELETE ON url_mapping BEGIN
+		DELETE FROM links_fts WHERE rowid = OLD.rowid;
+	END`},
+	{"links_fts_tag_insert", `AFTER INSERT ON link_tags BEGIN
+		UPDATE links_fts SET tags = (SELECT group_concat(tag, ' ') FROM link_tags WHERE short_url = NEW.short_url)
+		WHERE rowid = (SELECT rowid FROM url_mapping WHERE short_url = NEW.short_url);
+	END`},
+	{"links_fts_tag_delete", `AFTER DELETE ON link_tags BEGIN
+		UPDATE links_fts SET tags = COALESCE((SELECT group_concat(tag, ' ') FROM link_tags WHERE short_url = OLD.short_url), '')
+		WHERE rowid = (SELECT rowid FROM url_mapping WHERE short_url = OLD.short_url);
+	END`},
+}
+
+// hasFTS5 reports whether db's SQLite has the FTS5 extension.
+func hasFTS5(ctx context.Context, db *sql.DB) bool {
+	if _, err := db.ExecContext(ctx, `CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x)`); err != nil {
+		return false
+	}
+	db.ExecContext(ctx, `DROP TABLE temp.fts5_probe`)
+	return true
+}
+
+// searchIndexed reports whether db keeps links_fts up to date.
+func searchIndexed(ctx context.Context, db *sql.DB) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'links\_fts\_%' ESCAPE '\'`).Scan(&n)
+	return n == len(searchIndexTriggers), err
+}
+
+// EnsureSearchIndex builds the search index of a migrated database if its
+// SQLite has FTS5 and the index isn't kept up to date yet, or takes the
+// index's triggers down if it lacks FTS5. It reports whether the index is
+// in use.
+func EnsureSearchIndex(ctx context.Context, db *sql.DB) (bool, error) {
+	if !hasFTS5(ctx, db) {
+		for _, trigger := range searchIndexTriggers {
+			if _, err := db.ExecContext(ctx, `DROP TRIGGER IF EXISTS `+trigger.name); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	indexed, err := searchIndexed(ctx, db)
+	if err != nil || indexed {
+		return indexed, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `CREATE VIRTUAL TABLE IF NOT EXISTS links_fts USING fts5(short_url, long_url, tags, tokenize = 'trigram')`); err != nil {
+		return false, err
+	}
+	for _, trigger := range searchIndexTriggers {
+		if _, err := tx.ExecContext(ctx, `DROP TRIGGER IF EXISTS `+trigger.name); err != nil {
+			return false, err
+		}
+		if _, err := tx.ExecContext(ctx, `CREATE TRIGGER `+trigger.name+` `+trigger.body); err != nil {
+			return false, err
+		}
+	}
+	n, err := fillSearchIndex(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	log.Printf("Built the search index for %d link(s)", n)
+	return true, nil
+}
+
+// RebuildSearchIndex indexes every link again, if db keeps a search index.
+func RebuildSearchIndex(ctx context.Context, db *sql.DB) error {
+	if indexed, err := searchIndexed(ctx, db); err != nil || !indexed {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := fillSearchIndex(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// fillSearchIndex replaces the contents of links_fts with every link and
+// returns how many there are.
+func fillSearchIndex(ctx context.Context, tx *sql.Tx) (int64, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM links_fts`); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO links_fts (rowid, short_url, long_url, tags)
+		SELECT rowid, short_url, long_url,
+			COALESCE((SELECT group_concat(tag, ' ') FROM link_tags t WHERE t.short_url = m.short_url), '')
+		FROM url_mapping m
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// likePattern returns a LIKE pattern matching values that contain query,
+// for use with ESCAPE '\'.
+func likePattern(query string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+}
+
+func (s *SQLite) SearchLinks(ctx context.Context, query string, limit int) ([]LinkStats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	s.searchOnce.Do(func() {
+		indexed, err := searchIndexed(ctx, s.db)
+		if err == nil && indexed {
+			// A build without FTS5 can't read the index another build made.
+			_, err = s.db.ExecContext(ctx, `SELECT rowid FROM links_fts LIMIT 0`)
+		}
+		s.searchIndex = err == nil && indexed
+	})
+
+	if s.searchIndex && utf8.RuneCountInString(query) >= minIndexedSearch {
+		return s.queryLinks(ctx, `
+			SELECT m.short_url, m.long_url, m.visit_count, m.created_at
+			FROM links_fts JOIN url_mapping m ON m.rowid = links_fts.rowid
+			WHERE links_fts MATCH ? AND m.deleted_at = ''
+			ORDER BY m.rowid DESC
+			LIMIT ?
+		`, `"`+strings.ReplaceAll(query, `"`, `""`)+`"`, limit)
+	}
+	pattern := likePattern(query)
+	return s.queryLinks(ctx, `
+		SELECT short_url, long_url, visit_count, created_at
+		FROM url_mapping m
+		WHERE deleted_at = '' AND (
+			short_url LIKE ? ESCAPE '\' OR long_url LIKE ? ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM link_tags t WHERE t.short_url = m.short_url AND t.tag LIKE ? ESCAPE '\')
+		)
+		ORDER BY rowid DESC
+		LIMIT ?
+	`, pattern, pattern, pattern, limit)
+}
+
+// searchLinks returns up to limit of links, given oldest first, whose short
+// URL, long URL or one of whose tags contains query, ignoring case, newest
+// first.
+func searchLinks(links []LinkStats, tags func(shortURL string) ([]string, error), query string, limit int) ([]LinkStats, error) {
+	query = strings.ToLower(query)
+	var found []LinkStats
+	for i := len(links) - 1; i >= 0 && (limit < 0 || len(found) < limit); i-- {
+		link := links[i]
+		if strings.Contains(strings.ToLower(link.ShortURL), query) || strings.Contains(strings.ToLower(link.LongURL), query) {
+			found = append(found, link)
+			continue
+		}
+		linkTags, err := tags(link.ShortURL)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tags of %s: %v", link.ShortURL, err)
+		}
+		for _, tag := range linkTags {
+			if strings.Contains(strings.ToLower(tag), query) {
+				found = append(found, link)
+				break
+			}
+		}
+	}
+	return found, nil
+}
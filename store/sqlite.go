@@ -0,0 +1,1803 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/donuts-are-good/shorty/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Queries on the redirect and create paths run on every request, so they
+// are prepared once at startup instead of being re-parsed by SQLite each
+// time.
+const (
+	getLongURLQuery     = `SELECT long_url FROM url_mapping WHERE short_url = ? AND deleted_at = ''`
+	shortURLExistsQuery = `SELECT EXISTS(SELECT 1 FROM url_mapping WHERE short_url=?)`
+	incrementVisitQuery = `UPDATE url_mapping SET visit_count = visit_count + 1 WHERE short_url = ? AND (max_clicks = 0 OR visit_count < max_clicks)`
+	getVariantsQuery    = `SELECT name, url, weight, visit_count FROM link_variants WHERE short_url = ? ORDER BY rowid`
+	getOptionsQuery     = `SELECT pass_query, prefix, utm_source, utm_medium, utm_campaign, fallback_url, max_clicks, no_analytics, expires_at, active, flagged_at != '', takedown_id != 0, api_key FROM url_mapping WHERE short_url = ?`
+)
+
+// SQLite is the default Store, backed by a SQLite database.
+type SQLite struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+
+	stmts struct {
+		getLongURL     *sql.Stmt
+		shortURLExists *sql.Stmt
+		incrementVisit *sql.Stmt
+		getVariants    *sql.Stmt
+		getOptions     *sql.Stmt
+	}
+
+	// searchIndex is whether SearchLinks can use links_fts, checked once.
+	searchOnce  sync.Once
+	searchIndex bool
+}
+
+// OpenDB connects to the SQLite database named in cfg without touching its
+// schema.
+func OpenDB(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open(cfg.Driver(), cfg.DatabaseDSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+	return db, nil
+}
+
+// OpenReplicaDB connects to the read replica named in cfg, or returns nil
+// without one.
+func OpenReplicaDB(cfg *config.Config) (*sql.DB, error) {
+	dsn := cfg.ReadDatabaseDSN()
+	if dsn == "" {
+		return nil, nil
+	}
+	db, err := sql.Open(cfg.Driver(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to read replica: %v", err)
+	}
+	return db, nil
+}
+
+// Open connects to the configured database, applies pending migrations and
+// returns a ready Store.
+func Open(cfg *config.Config) (*SQLite, error) {
+	db, err := OpenDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if _, err := EnsureSearchIndex(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up the search index: %v", err)
+	}
+	s, err := NewSQLite(db, cfg.QueryTimeout())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLite wraps an already migrated database. Every call is bounded by
+// queryTimeout.
+func NewSQLite(db *sql.DB, queryTimeout time.Duration) (*SQLite, error) {
+	s := &SQLite{db: db, queryTimeout: queryTimeout}
+	var err error
+	if s.stmts.getLongURL, err = db.Prepare(getLongURLQuery); err != nil {
+		return nil, fmt.Errorf("error preparing long URL lookup: %v", err)
+	}
+	if s.stmts.shortURLExists, err = db.Prepare(shortURLExistsQuery); err != nil {
+		return nil, fmt.Errorf("error preparing short URL existence check: %v", err)
+	}
+	if s.stmts.incrementVisit, err = db.Prepare(incrementVisitQuery); err != nil {
+		return nil, fmt.Errorf("error preparing visit count update: %v", err)
+	}
+	if s.stmts.getVariants, err = db.Prepare(getVariantsQuery); err != nil {
+		return nil, fmt.Errorf("error preparing variants lookup: %v", err)
+	}
+	if s.stmts.getOptions, err = db.Prepare(getOptionsQuery); err != nil {
+		return nil, fmt.Errorf("error preparing options lookup: %v", err)
+	}
+	return s, nil
+}
+
+// DB returns the underlying database, for maintenance and backups.
+func (s *SQLite) DB() *sql.DB {
+	return s.db
+}
+
+// Close closes the prepared statements and the database.
+func (s *SQLite) Close() error {
+	for _, stmt := range []*sql.Stmt{s.stmts.getLongURL, s.stmts.shortURLExists, s.stmts.incrementVisit, s.stmts.getVariants, s.stmts.getOptions} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return s.db.Close()
+}
+
+// withTimeout bounds a database call by the configured query timeout, so a
+// locked database or slow disk can't hold a request open forever.
+func (s *SQLite) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+func (s *SQLite) LongURL(ctx context.Context, shortURL string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var longURL string
+	err := s.stmts.getLongURL.QueryRowContext(ctx, shortURL).Scan(&longURL)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("No long URL found in DB for short URL '%s'", shortURL)
+			return "", ErrNotFound
+		}
+		log.Printf("Error querying DB for short URL '%s': %v", shortURL, err)
+		return "", err
+	}
+	log.Printf("Fetched long URL from DB for '%s': '%s'", shortURL, longURL)
+	return longURL, nil
+}
+
+func (s *SQLite) ShortURLFor(ctx context.Context, longURL string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var shortURL string
+	err := s.db.QueryRowContext(ctx, `SELECT short_url FROM url_mapping WHERE long_url = ? AND active = 1 AND deleted_at = '' AND (expires_at = '' OR expires_at > datetime('now')) ORDER BY rowid ASC LIMIT 1`, longURL).Scan(&shortURL)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	return shortURL, err
+}
+
+func (s *SQLite) Exists(ctx context.Context, shortURL string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := s.stmts.shortURLExists.QueryRowContext(ctx, shortURL).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (s *SQLite) NextID(ctx context.Context) (uint64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `INSERT INTO short_url_sequence DEFAULT VALUES`)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM short_url_sequence WHERE id = ?`, id); err != nil {
+		return 0, err
+	}
+	return uint64(id), nil
+}
+
+func (s *SQLite) Create(ctx context.Context, shortURL, longURL string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO url_mapping (short_url, long_url, created_at) VALUES (?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))`, shortURL, longURL)
+	if err != nil {
+		// Lost a race with another request for the same short URL.
+		if exists, existsErr := s.Exists(ctx, shortURL); existsErr == nil && exists {
+			return ErrExists
+		}
+		log.Printf("Error inserting short URL '%s' into DB: %v", shortURL, err)
+		return err
+	}
+	log.Printf("Successfully saved short URL to DB: '%s' -> '%s'", shortURL, longURL)
+	return nil
+}
+
+func (s *SQLite) Delete(ctx context.Context, shortURL string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM url_mapping WHERE short_url = ?`, shortURL)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (s *SQLite) Trash(ctx context.Context, shortURL string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET deleted_at = datetime('now') WHERE short_url = ? AND deleted_at = ''`, shortURL)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+func (s *SQLite) Restore(ctx context.Context, shortURL string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET deleted_at = '' WHERE short_url = ? AND deleted_at != ''`, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Trashed(ctx context.Context) ([]TrashedLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT short_url, long_url, visit_count, created_at, deleted_at FROM url_mapping WHERE deleted_at != '' ORDER BY deleted_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []TrashedLink
+	for rows.Next() {
+		var link TrashedLink
+		var createdAtStr, deletedAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr, &deletedAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		if link.DeletedAt, err = time.Parse("2006-01-02 15:04:05", deletedAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing deleted_at time: %v", err)
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) PurgeTrash(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM url_mapping WHERE deleted_at != '' AND deleted_at < ?`, cutoff.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLite) SetActive(ctx context.Context, shortURL string, active bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Switching a link back on lifts any takedown.
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET active = ?, takedown_id = CASE WHEN ? THEN 0 ELSE takedown_id END WHERE short_url = ? AND deleted_at = ''`, active, active, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) SetLongURL(ctx context.Context, shortURL, longURL string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET long_url = ? WHERE short_url = ? AND deleted_at = ''`, longURL, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) RecordVisit(ctx context.Context, shortURL string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.stmts.incrementVisit.ExecContext(ctx, shortURL)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	log.Printf("Updated visit count for '%s', rows affected: %d", shortURL, rowsAffected)
+	return rowsAffected > 0, nil
+}
+
+func (s *SQLite) RecordBotVisit(ctx context.Context, shortURL string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET bot_visit_count = bot_visit_count + 1 WHERE short_url = ? AND (max_clicks = 0 OR visit_count < max_clicks)`, shortURL)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	log.Printf("Updated bot visit count for '%s', rows affected: %d", shortURL, rowsAffected)
+	return rowsAffected > 0, nil
+}
+
+func (s *SQLite) BotVisits(ctx context.Context, shortURL string) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var visits int
+	err := s.db.QueryRowContext(ctx, `SELECT bot_visit_count FROM url_mapping WHERE short_url = ? AND deleted_at = ''`, shortURL).Scan(&visits)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return visits, err
+}
+
+func (s *SQLite) Targets(ctx context.Context, shortURL string) (Targets, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var targets Targets
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(ios_url, ''), COALESCE(android_url, '') FROM url_mapping WHERE short_url = ?`, shortURL).
+		Scan(&targets.IOS, &targets.Android)
+	if err == sql.ErrNoRows {
+		return targets, ErrNotFound
+	}
+	return targets, err
+}
+
+func (s *SQLite) SetTargets(ctx context.Context, shortURL string, targets Targets) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET ios_url = NULLIF(?, ''), android_url = NULLIF(?, '') WHERE short_url = ?`,
+		targets.IOS, targets.Android, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Card(ctx context.Context, shortURL string) (Card, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var card Card
+	err := s.db.QueryRowContext(ctx, `SELECT card_title, card_description, card_image FROM url_mapping WHERE short_url = ?`, shortURL).
+		Scan(&card.Title, &card.Description, &card.Image)
+	if err == sql.ErrNoRows {
+		return card, ErrNotFound
+	}
+	return card, err
+}
+
+func (s *SQLite) SetCard(ctx context.Context, shortURL string, card Card) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET card_title = ?, card_description = ?, card_image = ? WHERE short_url = ?`,
+		card.Title, card.Description, card.Image, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Options(ctx context.Context, shortURL string) (Options, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var opts Options
+	var expiresAtStr string
+	var active bool
+	err := s.stmts.getOptions.QueryRowContext(ctx, shortURL).
+		Scan(&opts.PassQuery, &opts.Prefix, &opts.UTMSource, &opts.UTMMedium, &opts.UTMCampaign, &opts.FallbackURL, &opts.MaxClicks, &opts.NoAnalytics, &expiresAtStr, &active, &opts.Flagged, &opts.TakenDown, &opts.APIKey)
+	if err == sql.ErrNoRows {
+		return opts, ErrNotFound
+	}
+	if err != nil {
+		return opts, err
+	}
+	opts.Disabled = !active
+	opts.ExpiresAt, err = parseExpiry(expiresAtStr)
+	return opts, err
+}
+
+// createdAtLayouts are the layouts parseCreatedAt accepts: RFC 3339, which
+// links are created with, SQLite's own datetime layout, which they were
+// created with before, and the variants other tools write.
+var createdAtLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseCreatedAt reads a link's created_at column. Times without a zone are
+// taken as UTC, like SQLite's, and a bare number as Unix seconds. A value
+// it can't read, say one another tool wrote, is logged and read as the zero
+// time, so one odd row doesn't break every page listing links.
+func parseCreatedAt(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range createdAtLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC()
+	}
+	log.Printf("Can't read created_at %q, leaving the link's creation time unknown", s)
+	return time.Time{}
+}
+
+// formatCreatedAt writes t the way links' created_at is stored, for
+// comparing with it.
+func formatCreatedAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseExpiry reads an expires_at column, which is empty for a link that
+// never expires.
+func parseExpiry(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", s)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expires_at time: %v", err)
+	}
+	return &t, nil
+}
+
+// formatExpiry writes t for an expires_at column.
+func formatExpiry(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func (s *SQLite) SetOptions(ctx context.Context, shortURL string, opts Options) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET pass_query = ?, prefix = ?, utm_source = ?, utm_medium = ?, utm_campaign = ?, fallback_url = ?, max_clicks = ?, no_analytics = ?, expires_at = ? WHERE short_url = ?`,
+		opts.PassQuery, opts.Prefix, opts.UTMSource, opts.UTMMedium, opts.UTMCampaign, opts.FallbackURL, opts.MaxClicks, opts.NoAnalytics, formatExpiry(opts.ExpiresAt), shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Variants(ctx context.Context, shortURL string) ([]Variant, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.stmts.getVariants.QueryContext(ctx, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variants []Variant
+	for rows.Next() {
+		var v Variant
+		if err := rows.Scan(&v.Name, &v.URL, &v.Weight, &v.VisitCount); err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+	return variants, rows.Err()
+}
+
+func (s *SQLite) SetVariants(ctx context.Context, shortURL string, variants []Variant) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_variants WHERE short_url = ?`, shortURL); err != nil {
+		return err
+	}
+	for _, v := range variants {
+		_, err := tx.ExecContext(ctx, `INSERT INTO link_variants (short_url, name, url, weight) VALUES (?, ?, ?, ?)`,
+			shortURL, v.Name, v.URL, v.Weight)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) RecordVariantVisit(ctx context.Context, shortURL, name string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE link_variants SET visit_count = visit_count + 1 WHERE short_url = ? AND name = ?`, shortURL, name)
+	return err
+}
+
+func (s *SQLite) Tags(ctx context.Context, shortURL string) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.queryStrings(ctx, `SELECT tag FROM link_tags WHERE short_url = ? ORDER BY tag`, shortURL)
+}
+
+func (s *SQLite) SetTags(ctx context.Context, shortURL string, tags []string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_tags WHERE short_url = ?`, shortURL); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO link_tags (short_url, tag) VALUES (?, ?)`, shortURL, tag); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) TaggedLinks(ctx context.Context, tag string) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.queryStrings(ctx, `
+		SELECT m.short_url FROM link_tags t JOIN url_mapping m ON m.short_url = t.short_url
+		WHERE t.tag = ? AND m.deleted_at = '' ORDER BY m.rowid`, tag)
+}
+
+// queryStrings runs a query for a single text column and returns its
+// values.
+func (s *SQLite) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+func (s *SQLite) FlagLink(ctx context.Context, shortURL, source, reason string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM url_mapping WHERE short_url = ? AND deleted_at = '')`, shortURL).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE url_mapping SET flagged_at = datetime('now'), flag_source = ?, flag_reason = ? WHERE short_url = ? AND flagged_at = ''`, source, reason, shortURL)
+	return err
+}
+
+func (s *SQLite) ClearFlag(ctx context.Context, shortURL string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET flagged_at = '', flag_source = '', flag_reason = '' WHERE short_url = ? AND flagged_at != ''`, shortURL)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+func (s *SQLite) FlaggedLinks(ctx context.Context) ([]FlaggedLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT short_url, long_url, visit_count, created_at, flag_source, flag_reason, flagged_at FROM url_mapping WHERE flagged_at != '' AND deleted_at = '' ORDER BY flagged_at, rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []FlaggedLink
+	for rows.Next() {
+		var link FlaggedLink
+		var createdAtStr, flaggedAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr, &link.Source, &link.Reason, &flaggedAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		if link.FlaggedAt, err = time.Parse("2006-01-02 15:04:05", flaggedAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing flagged_at time: %v", err)
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) BanDomain(ctx context.Context, domain, reason string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO banned_domains (domain, reason) VALUES (?, ?) ON CONFLICT(domain) DO UPDATE SET reason = excluded.reason`, domain, reason)
+	return err
+}
+
+func (s *SQLite) UnbanDomain(ctx context.Context, domain string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM banned_domains WHERE domain = ?`, domain)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+func (s *SQLite) BannedDomains(ctx context.Context) ([]BannedDomain, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT domain, reason, banned_at FROM banned_domains ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []BannedDomain
+	for rows.Next() {
+		var domain BannedDomain
+		var bannedAtStr string
+		if err := rows.Scan(&domain.Domain, &domain.Reason, &bannedAtStr); err != nil {
+			return nil, err
+		}
+		if domain.BannedAt, err = time.Parse("2006-01-02 15:04:05", bannedAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing banned_at time: %v", err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+func (s *SQLite) CreateTakedown(ctx context.Context, takedown Takedown) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO takedowns (kind, target, requester, reference, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, takedown.Kind, takedown.Target, takedown.Requester, takedown.Reference, takedown.Reason,
+		takedown.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLite) TakeDown(ctx context.Context, shortURL string, id int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET active = 0, takedown_id = ? WHERE short_url = ? AND deleted_at = ''`, id, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Takedowns(ctx context.Context) ([]Takedown, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT id, kind, target, requester, reference, reason, created_at FROM takedowns ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var takedowns []Takedown
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var t Takedown
+		var createdAtStr string
+		if err := rows.Scan(&t.ID, &t.Kind, &t.Target, &t.Requester, &t.Reference, &t.Reason, &createdAtStr); err != nil {
+			return nil, err
+		}
+		if t.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing created_at time: %v", err)
+		}
+		byID[t.ID] = len(takedowns)
+		takedowns = append(takedowns, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	links, err := s.db.QueryContext(ctx, `SELECT takedown_id, short_url FROM url_mapping WHERE takedown_id != 0 AND deleted_at = '' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer links.Close()
+	for links.Next() {
+		var id int64
+		var shortURL string
+		if err := links.Scan(&id, &shortURL); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[id]; ok {
+			takedowns[i].Links = append(takedowns[i].Links, shortURL)
+		}
+	}
+	return takedowns, links.Err()
+}
+
+func (s *SQLite) SetAPIKey(ctx context.Context, shortURL, key string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET api_key = ? WHERE short_url = ? AND deleted_at = ''`, key, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) RecordUsage(ctx context.Context, key, month string, creates, redirects int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_key_usage (api_key, month, creates, redirects) VALUES (?, ?, ?, ?)
+		ON CONFLICT (api_key, month) DO UPDATE SET creates = creates + excluded.creates, redirects = redirects + excluded.redirects
+	`, key, month, creates, redirects)
+	return err
+}
+
+func (s *SQLite) Usage(ctx context.Context, key string) ([]Usage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT month, creates, redirects FROM api_key_usage WHERE api_key = ? ORDER BY month DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []Usage
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Month, &u.Creates, &u.Redirects); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+func (s *SQLite) DeleteUsage(ctx context.Context, key string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM api_key_usage WHERE api_key = ?`, key)
+	return err
+}
+
+func (s *SQLite) SetOwner(ctx context.Context, shortURL, owner string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET owner = ? WHERE short_url = ? AND deleted_at = ''`, owner, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) OwnerLinks(ctx context.Context, owner string) ([]LinkStats, error) {
+	return s.linksWhere(ctx, `owner = ?`, owner)
+}
+
+func (s *SQLite) AddSessionLink(ctx context.Context, session, shortURL string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// REPLACE deletes the earlier row, so the link gets a new rowid and
+	// comes first again.
+	_, err := s.db.ExecContext(ctx, `REPLACE INTO session_links (session, short_url, created_at) VALUES (?, ?, ?)`,
+		session, shortURL, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+func (s *SQLite) SessionLinks(ctx context.Context, session string, limit int) ([]LinkStats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT u.short_url, u.long_url, u.visit_count, u.created_at
+		FROM session_links s JOIN url_mapping u ON u.short_url = s.short_url
+		WHERE s.session = ? AND u.deleted_at = ''
+		ORDER BY s.rowid DESC LIMIT ?`, session, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkStats
+	for rows.Next() {
+		var link LinkStats
+		var createdAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) PurgeSessionLinks(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM session_links WHERE created_at < ?`, cutoff.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLite) CreateTeam(ctx context.Context, name string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `INSERT INTO teams (name, created_at) VALUES (?, ?) ON CONFLICT (name) DO NOTHING`,
+		name, time.Now().UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrTeamExists
+	}
+	return nil
+}
+
+func (s *SQLite) DeleteTeam(ctx context.Context, name string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM teams WHERE name = ?`, name)
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return false, nil
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM team_members WHERE team = ?`, name); err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE url_mapping SET team = '' WHERE team = ?`, name); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *SQLite) Teams(ctx context.Context) ([]Team, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT name, created_at FROM teams ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []Team
+	byName := make(map[string]int)
+	for rows.Next() {
+		var t Team
+		var createdAtStr string
+		if err := rows.Scan(&t.Name, &createdAtStr); err != nil {
+			return nil, err
+		}
+		if t.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing created_at time: %v", err)
+		}
+		byName[t.Name] = len(teams)
+		teams = append(teams, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members, err := s.db.QueryContext(ctx, `SELECT team, member FROM team_members ORDER BY member`)
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+	for members.Next() {
+		var team, member string
+		if err := members.Scan(&team, &member); err != nil {
+			return nil, err
+		}
+		if i, ok := byName[team]; ok {
+			teams[i].Members = append(teams[i].Members, member)
+		}
+	}
+	return teams, members.Err()
+}
+
+func (s *SQLite) SetTeamMember(ctx context.Context, team, member string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO team_members (member, team) SELECT ?, name FROM teams WHERE name = ?
+		ON CONFLICT (member) DO UPDATE SET team = excluded.team
+	`, member, team)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) RemoveTeamMember(ctx context.Context, member string) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM team_members WHERE member = ?`, member)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+func (s *SQLite) SetTeam(ctx context.Context, shortURL, team string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `UPDATE url_mapping SET team = ? WHERE short_url = ? AND deleted_at = ''`, team, shortURL)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) TeamLinks(ctx context.Context, team string) ([]LinkStats, error) {
+	return s.linksWhere(ctx, `team = ?`, team)
+}
+
+// linksWhere returns the live links matching cond, in creation order.
+func (s *SQLite) linksWhere(ctx context.Context, cond string, args ...interface{}) ([]LinkStats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT short_url, long_url, visit_count, created_at FROM url_mapping WHERE `+cond+` AND deleted_at = '' ORDER BY rowid`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkStats
+	for rows.Next() {
+		var link LinkStats
+		var createdAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) Health(ctx context.Context, shortURL string) (Health, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var health Health
+	var checkedAtStr string
+	err := s.db.QueryRowContext(ctx, `SELECT status_code, error, checked_at FROM link_health WHERE short_url = ?`, shortURL).
+		Scan(&health.StatusCode, &health.Error, &checkedAtStr)
+	if err == sql.ErrNoRows {
+		return health, ErrNotFound
+	}
+	if err != nil {
+		return health, err
+	}
+	health.CheckedAt, err = time.Parse("2006-01-02 15:04:05", checkedAtStr)
+	if err != nil {
+		return health, fmt.Errorf("error parsing checked_at time: %v", err)
+	}
+	return health, nil
+}
+
+func (s *SQLite) SetHealth(ctx context.Context, shortURL string, health Health) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO link_health (short_url, status_code, error, checked_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(short_url) DO UPDATE SET status_code = excluded.status_code, error = excluded.error, checked_at = excluded.checked_at
+	`, shortURL, health.StatusCode, health.Error, health.CheckedAt.UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+func (s *SQLite) BrokenLinks(ctx context.Context) ([]BrokenLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT h.short_url, m.long_url, h.status_code, h.error, h.checked_at
+		FROM link_health h JOIN url_mapping m ON m.short_url = h.short_url
+		WHERE m.deleted_at = '' AND (h.error != '' OR h.status_code = 0 OR h.status_code >= 400)
+		ORDER BY h.checked_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var broken []BrokenLink
+	for rows.Next() {
+		var link BrokenLink
+		var checkedAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.StatusCode, &link.Error, &checkedAtStr); err != nil {
+			return nil, err
+		}
+		link.CheckedAt, err = time.Parse("2006-01-02 15:04:05", checkedAtStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing checked_at time: %v", err)
+		}
+		broken = append(broken, link)
+	}
+	return broken, rows.Err()
+}
+
+func (s *SQLite) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO audit_log (short_url, action, actor, before, after, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.ShortURL, entry.Action, entry.Actor, string(entry.Before), string(entry.After), entry.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+func (s *SQLite) AuditLog(ctx context.Context, shortURL string, limit int) ([]AuditEntry, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, short_url, action, actor, before, after, created_at
+		FROM audit_log
+		WHERE ? = '' OR short_url = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, shortURL, shortURL, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after, createdAtStr string
+		if err := rows.Scan(&entry.ID, &entry.ShortURL, &entry.Action, &entry.Actor, &before, &after, &createdAtStr); err != nil {
+			return nil, err
+		}
+		if before != "" {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != "" {
+			entry.After = json.RawMessage(after)
+		}
+		entry.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing created_at time: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLite) Links(ctx context.Context) ([]LinkStats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT short_url, long_url, visit_count, created_at FROM url_mapping WHERE deleted_at = '' ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkStats
+	for rows.Next() {
+		var link LinkStats
+		var createdAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) LinksSince(ctx context.Context, since time.Time) ([]LinkStats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT short_url, long_url, visit_count, created_at
+		FROM url_mapping
+		WHERE created_at >= ? AND deleted_at = ''
+		ORDER BY rowid DESC
+	`, formatCreatedAt(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkStats
+	for rows.Next() {
+		var link LinkStats
+		var createdAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) TopLinks(ctx context.Context, since time.Time, limit int) ([]LinkClicks, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sinceStr := since.UTC().Format("2006-01-02 15:04:05")
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.short_url, m.long_url, t.clicks
+		FROM (
+			SELECT short_url, SUM(clicks) AS clicks FROM (
+				SELECT short_url, COUNT(*) AS clicks
+				FROM clicks
+				WHERE clicked_at >= ? AND bot = ''
+				GROUP BY short_url
+				UNION ALL
+				SELECT short_url, clicks
+				FROM click_rollups
+				WHERE day >= substr(?, 1, 10)
+			)
+			GROUP BY short_url
+		) t
+		JOIN url_mapping m ON m.short_url = t.short_url AND m.deleted_at = ''
+		ORDER BY t.clicks DESC, m.short_url
+		LIMIT ?
+	`, sinceStr, sinceStr, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkClicks
+	for rows.Next() {
+		var link LinkClicks
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.Clicks); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) CreateAlert(ctx context.Context, alert Alert) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO alerts (short_url, kind, threshold, email, webhook, last_count, last_change, fired_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, alert.ShortURL, alert.Kind, alert.Threshold, alert.Email, alert.Webhook, alert.LastCount,
+		alert.LastChange.UTC().Format("2006-01-02 15:04:05"), formatFiredAt(alert.FiredAt),
+		alert.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *SQLite) Alerts(ctx context.Context, shortURL string) ([]Alert, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, short_url, kind, threshold, email, webhook, last_count, last_change, fired_at, created_at
+		FROM alerts
+		WHERE ? = '' OR short_url = ?
+		ORDER BY id
+	`, shortURL, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []Alert
+	for rows.Next() {
+		var alert Alert
+		var lastChangeStr, firedAtStr, createdAtStr string
+		if err := rows.Scan(&alert.ID, &alert.ShortURL, &alert.Kind, &alert.Threshold, &alert.Email, &alert.Webhook,
+			&alert.LastCount, &lastChangeStr, &firedAtStr, &createdAtStr); err != nil {
+			return nil, err
+		}
+		if alert.LastChange, err = time.Parse("2006-01-02 15:04:05", lastChangeStr); err != nil {
+			return nil, fmt.Errorf("error parsing last_change time: %v", err)
+		}
+		if firedAtStr != "" {
+			firedAt, err := time.Parse("2006-01-02 15:04:05", firedAtStr)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing fired_at time: %v", err)
+			}
+			alert.FiredAt = &firedAt
+		}
+		if alert.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing created_at time: %v", err)
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+func (s *SQLite) UpdateAlert(ctx context.Context, alert Alert) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE alerts SET last_count = ?, last_change = ?, fired_at = ? WHERE id = ?`,
+		alert.LastCount, alert.LastChange.UTC().Format("2006-01-02 15:04:05"), formatFiredAt(alert.FiredAt), alert.ID)
+	return err
+}
+
+func (s *SQLite) DeleteAlert(ctx context.Context, shortURL string, id int64) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM alerts WHERE short_url = ? AND id = ?`, shortURL, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLite) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= ?
+	`, name, holder, now.Add(ttl).Format("2006-01-02 15:04:05"), now.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// formatFiredAt stores a rule that hasn't fired as an empty string.
+func formatFiredAt(firedAt *time.Time) string {
+	if firedAt == nil {
+		return ""
+	}
+	return firedAt.UTC().Format("2006-01-02 15:04:05")
+}
+
+func (s *SQLite) Link(ctx context.Context, shortURL string) (LinkStats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var stats LinkStats
+	var createdAtStr string
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT short_url, long_url, visit_count, created_at 
+		FROM url_mapping 
+		WHERE short_url = ? AND deleted_at = ''
+	`, shortURL).Scan(&stats.ShortURL, &stats.LongURL, &stats.VisitCount, &createdAtStr)
+
+	if err == sql.ErrNoRows {
+		return stats, ErrNotFound
+	}
+	if err != nil {
+		return stats, err
+	}
+
+	stats.CreatedAt = parseCreatedAt(createdAtStr)
+
+	return stats, nil
+}
+
+func (s *SQLite) RecordClick(ctx context.Context, click Click) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO clicks (short_url, referrer, user_agent, ip, country, bot, clicked_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		click.ShortURL, click.Referrer, click.UserAgent, click.IP, click.Country, click.Bot, click.At.UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+func (s *SQLite) RecentClicks(ctx context.Context, shortURL string, limit int) ([]Click, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT short_url, referrer, user_agent, ip, country, clicked_at
+		FROM clicks
+		WHERE bot = '' AND (? = '' OR short_url = ?)
+		ORDER BY id DESC
+		LIMIT ?
+	`, shortURL, shortURL, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clicks []Click
+	for rows.Next() {
+		var click Click
+		var clickedAtStr string
+		if err := rows.Scan(&click.ShortURL, &click.Referrer, &click.UserAgent, &click.IP, &click.Country, &clickedAtStr); err != nil {
+			return nil, err
+		}
+		click.At, err = time.Parse("2006-01-02 15:04:05", clickedAtStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing clicked_at time: %v", err)
+		}
+		clicks = append(clicks, click)
+	}
+	return clicks, rows.Err()
+}
+
+// intervalFormats truncate clicked_at to the start of its interval.
+var intervalFormats = map[Interval]string{
+	Hourly: "%Y-%m-%d %H:00:00",
+	Daily:  "%Y-%m-%d 00:00:00",
+}
+
+func (s *SQLite) ClickSeries(ctx context.Context, shortURL string, since time.Time, interval Interval) ([]ClickCount, error) {
+	format, ok := intervalFormats[interval]
+	if !ok {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// Rolled up days have no hours, so only daily series read them.
+	sinceStr := since.UTC().Format("2006-01-02 15:04:05")
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT bucket, SUM(clicks) FROM (
+			SELECT strftime(?, clicked_at) AS bucket, COUNT(*) AS clicks
+			FROM clicks
+			WHERE clicked_at >= ? AND bot = '' AND (? = '' OR short_url = ?)
+			GROUP BY bucket
+			UNION ALL
+			SELECT day || ' 00:00:00', clicks
+			FROM click_rollups
+			WHERE ? = 'day' AND day >= substr(?, 1, 10) AND (? = '' OR short_url = ?)
+		)
+		GROUP BY bucket
+		ORDER BY bucket
+	`, format, sinceStr, shortURL, shortURL, string(interval), sinceStr, shortURL, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []ClickCount
+	for rows.Next() {
+		var count ClickCount
+		var bucket string
+		if err := rows.Scan(&bucket, &count.Clicks); err != nil {
+			return nil, err
+		}
+		count.Time, err = time.Parse("2006-01-02 15:04:05", bucket)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing click interval: %v", err)
+		}
+		series = append(series, count)
+	}
+	return series, rows.Err()
+}
+
+func (s *SQLite) RollupClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// A day compacted again, say after the clock went back, adds to its
+	// rollup. Uniques may then count an address twice.
+	cutoffStr := cutoff.UTC().Format("2006-01-02 15:04:05")
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO click_rollups (short_url, day, clicks, uniques, top_referrer, top_country)
+		SELECT short_url, day, COUNT(*), COUNT(DISTINCT ip),
+			COALESCE((SELECT referrer FROM clicks r
+				WHERE r.short_url = c.short_url AND r.clicked_at >= c.day AND r.clicked_at < date(c.day, '+1 day')
+					AND r.bot = '' AND r.referrer != ''
+				GROUP BY referrer ORDER BY COUNT(*) DESC, referrer LIMIT 1), ''),
+			COALESCE((SELECT country FROM clicks r
+				WHERE r.short_url = c.short_url AND r.clicked_at >= c.day AND r.clicked_at < date(c.day, '+1 day')
+					AND r.bot = '' AND r.country != ''
+				GROUP BY country ORDER BY COUNT(*) DESC, country LIMIT 1), '')
+		FROM (SELECT short_url, substr(clicked_at, 1, 10) AS day, ip FROM clicks WHERE clicked_at < ? AND bot = '') c
+		GROUP BY short_url, day
+		ON CONFLICT (short_url, day) DO UPDATE SET
+			clicks = clicks + excluded.clicks,
+			uniques = uniques + excluded.uniques
+	`, cutoffStr)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM clicks WHERE clicked_at < ?`, cutoffStr)
+	if err != nil {
+		return 0, err
+	}
+	compacted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return compacted, tx.Commit()
+}
+
+func (s *SQLite) PurgeClicks(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM clicks WHERE clicked_at < ?`, cutoff.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLite) PurgeRollups(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM click_rollups WHERE day < ?`, cutoff.UTC().Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLite) ClickRollups(ctx context.Context, shortURL string, since time.Time) ([]ClickRollup, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT short_url, day, clicks, uniques, top_referrer, top_country
+		FROM click_rollups
+		WHERE day >= ? AND (? = '' OR short_url = ?)
+		ORDER BY day, short_url
+	`, since.UTC().Format("2006-01-02"), shortURL, shortURL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rollups []ClickRollup
+	for rows.Next() {
+		var rollup ClickRollup
+		var dayStr string
+		if err := rows.Scan(&rollup.ShortURL, &dayStr, &rollup.Clicks, &rollup.Uniques, &rollup.TopReferrer, &rollup.TopCountry); err != nil {
+			return nil, err
+		}
+		rollup.Day, err = time.Parse("2006-01-02", dayStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing rollup day: %v", err)
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+func (s *SQLite) ClickHeatmap(ctx context.Context, shortURL string) (Heatmap, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var heatmap Heatmap
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT CAST(strftime('%w', clicked_at) AS INTEGER) AS weekday, CAST(strftime('%H', clicked_at) AS INTEGER) AS hour, COUNT(*)
+		FROM clicks
+		WHERE bot = '' AND (? = '' OR short_url = ?)
+		GROUP BY weekday, hour
+	`, shortURL, shortURL)
+	if err != nil {
+		return heatmap, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var weekday, hour, clicks int
+		if err := rows.Scan(&weekday, &hour, &clicks); err != nil {
+			return heatmap, err
+		}
+		if weekday < 0 || weekday > 6 || hour < 0 || hour > 23 {
+			return heatmap, fmt.Errorf("click at weekday %d hour %d is out of range", weekday, hour)
+		}
+		heatmap[weekday][hour] = clicks
+	}
+	return heatmap, rows.Err()
+}
+
+func (s *SQLite) ClickBursts(ctx context.Context, since time.Time, minClicks, maxIPs int) ([]Anomaly, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT short_url, COUNT(*) AS clicks, COUNT(DISTINCT ip) AS unique_ips
+		FROM clicks
+		WHERE clicked_at >= ? AND bot = ''
+		GROUP BY short_url
+		HAVING clicks >= ? AND unique_ips <= ?
+		ORDER BY clicks DESC
+	`, since.UTC().Format("2006-01-02 15:04:05"), minClicks, maxIPs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bursts []Anomaly
+	for rows.Next() {
+		burst := Anomaly{WindowStart: since.UTC()}
+		if err := rows.Scan(&burst.ShortURL, &burst.Clicks, &burst.UniqueIPs); err != nil {
+			return nil, err
+		}
+		bursts = append(bursts, burst)
+	}
+	return bursts, rows.Err()
+}
+
+func (s *SQLite) RecordAnomaly(ctx context.Context, anomaly Anomaly) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `INSERT INTO anomalies (short_url, clicks, unique_ips, window_start, detected_at) VALUES (?, ?, ?, ?, ?)`,
+		anomaly.ShortURL, anomaly.Clicks, anomaly.UniqueIPs,
+		anomaly.WindowStart.UTC().Format("2006-01-02 15:04:05"), anomaly.DetectedAt.UTC().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+func (s *SQLite) Anomalies(ctx context.Context, limit int) ([]Anomaly, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, short_url, clicks, unique_ips, window_start, detected_at
+		FROM anomalies
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anomalies []Anomaly
+	for rows.Next() {
+		var anomaly Anomaly
+		var windowStartStr, detectedAtStr string
+		if err := rows.Scan(&anomaly.ID, &anomaly.ShortURL, &anomaly.Clicks, &anomaly.UniqueIPs, &windowStartStr, &detectedAtStr); err != nil {
+			return nil, err
+		}
+		if anomaly.WindowStart, err = time.Parse("2006-01-02 15:04:05", windowStartStr); err != nil {
+			return nil, fmt.Errorf("error parsing window_start time: %v", err)
+		}
+		if anomaly.DetectedAt, err = time.Parse("2006-01-02 15:04:05", detectedAtStr); err != nil {
+			return nil, fmt.Errorf("error parsing detected_at time: %v", err)
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	return anomalies, rows.Err()
+}
+
+// ForEachShortURL reads the whole table, so it isn't bounded by the query
+// timeout.
+func (s *SQLite) ForEachShortURL(ctx context.Context, fn func(shortURL string)) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT short_url FROM url_mapping`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var shortURL string
+		if err := rows.Scan(&shortURL); err != nil {
+			return err
+		}
+		fn(shortURL)
+	}
+	return rows.Err()
+}
+
+func (s *SQLite) HotLinks(ctx context.Context, limit int) ([]HotLink, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `SELECT short_url, long_url, pass_query, prefix, utm_source, utm_medium, utm_campaign, fallback_url, max_clicks, no_analytics, expires_at, active, flagged_at != '', takedown_id != 0, api_key FROM url_mapping WHERE deleted_at = '' ORDER BY visit_count DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []HotLink
+	for rows.Next() {
+		var link HotLink
+		var expiresAtStr string
+		var active bool
+		opts := &link.Options
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &opts.PassQuery, &opts.Prefix, &opts.UTMSource, &opts.UTMMedium, &opts.UTMCampaign, &opts.FallbackURL, &opts.MaxClicks, &opts.NoAnalytics, &expiresAtStr, &active, &opts.Flagged, &opts.TakenDown, &opts.APIKey); err != nil {
+			return nil, err
+		}
+		opts.Disabled = !active
+		if opts.ExpiresAt, err = parseExpiry(expiresAtStr); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) Count(ctx context.Context) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM url_mapping WHERE deleted_at = ''`).Scan(&count)
+	return count, err
+}
+
+// statsLinks is how many links each list on the stats page shows.
+const statsLinks = 10
+
+// Stats runs one bounded query per figure, so the indexes on visit_count
+// and created_at keep it fast however many links there are.
+func (s *SQLite) Stats(ctx context.Context) (Stats, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var stats Stats
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(visit_count), 0) FROM url_mapping WHERE deleted_at = ''").
+		Scan(&stats.TotalLinks, &stats.TotalClicks)
+	if err != nil {
+		return stats, err
+	}
+
+	// Clicks on the links created today. A range rather than DATE() lets
+	// the created_at index find them.
+	today := time.Now()
+	err = s.db.QueryRowContext(ctx, "SELECT COALESCE(SUM(visit_count), 0) FROM url_mapping WHERE created_at >= ? AND created_at < ? AND deleted_at = ''",
+		today.Format("2006-01-02"), today.AddDate(0, 0, 1).Format("2006-01-02")).Scan(&stats.ClicksToday)
+	if err != nil {
+		return stats, err
+	}
+
+	popular, err := s.queryLinks(ctx, "SELECT short_url, long_url, visit_count, created_at FROM url_mapping WHERE deleted_at = '' ORDER BY visit_count DESC LIMIT ?", statsLinks)
+	if err != nil {
+		return stats, err
+	}
+	stats.PopularLinks = popular
+	stats.MostClickedLinks = popular
+
+	stats.RecentLinks, err = s.queryLinks(ctx, "SELECT short_url, long_url, visit_count, created_at FROM url_mapping WHERE deleted_at = '' ORDER BY created_at DESC LIMIT ?", statsLinks)
+	return stats, err
+}
+
+// queryLinks runs a query selecting short_url, long_url, visit_count and
+// created_at, and returns its rows.
+func (s *SQLite) queryLinks(ctx context.Context, query string, args ...interface{}) ([]LinkStats, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkStats
+	for rows.Next() {
+		var link LinkStats
+		var createdAtStr string
+		if err := rows.Scan(&link.ShortURL, &link.LongURL, &link.VisitCount, &createdAtStr); err != nil {
+			return nil, err
+		}
+		link.CreatedAt = parseCreatedAt(createdAtStr)
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
@@ -0,0 +1,1498 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/donuts-are-good/shorty/config"
+)
+
+// newMockSQLite returns a SQLite store on top of a sqlmock database, with
+// the hot-path statements already prepared.
+func newMockSQLite(t *testing.T) (*SQLite, sqlmock.Sqlmock) {
+	t.Helper()
+
+	// Create a mock database
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+
+	mock.ExpectPrepare("SELECT long_url FROM url_mapping WHERE short_url")
+	mock.ExpectPrepare("SELECT EXISTS")
+	mock.ExpectPrepare("UPDATE url_mapping SET visit_count")
+	mock.ExpectPrepare("SELECT name, url, weight, visit_count FROM link_variants")
+	mock.ExpectPrepare("SELECT pass_query, prefix")
+	s, err := NewSQLite(mockDB, config.DefaultQueryTimeout)
+	if err != nil {
+		t.Fatalf("Failed to prepare statements: %v", err)
+	}
+	return s, mock
+}
+
+// openBenchDB creates a temporary SQLite database holding n links named
+// link0..link(n-1).
+func openBenchDB(b *testing.B, n int) *sql.DB {
+	b.Helper()
+
+	benchDB, err := sql.Open("sqlite3", filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { benchDB.Close() })
+
+	if _, err := Migrate(benchDB); err != nil {
+		b.Fatal(err)
+	}
+
+	tx, err := benchDB.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		_, err := tx.Exec(`INSERT INTO url_mapping (short_url, long_url) VALUES (?, ?)`,
+			fmt.Sprintf("link%d", i), fmt.Sprintf("https://example.com/%d", i))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return benchDB
+}
+
+func BenchmarkGetLongURLUnprepared(b *testing.B) {
+	benchDB := openBenchDB(b, 1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var longURL string
+		err := benchDB.QueryRow(getLongURLQuery, fmt.Sprintf("link%d", i%1000)).Scan(&longURL)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetLongURLPrepared(b *testing.B) {
+	benchDB := openBenchDB(b, 1000)
+	stmt, err := benchDB.Prepare(getLongURLQuery)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stmt.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var longURL string
+		err := stmt.QueryRow(fmt.Sprintf("link%d", i%1000)).Scan(&longURL)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLongURL(b *testing.B) {
+	s, err := NewSQLite(openBenchDB(b, 1000), config.DefaultQueryTimeout)
+	if err != nil {
+		b.Fatal(err)
+	}
+	// LongURL logs every lookup.
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.LongURL(ctx, fmt.Sprintf("link%d", i%1000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGetLongURL(t *testing.T) {
+	s, mock := newMockSQLite(t)
+
+	t.Run("Existing Short URL", func(t *testing.T) {
+		shortURL := "abc123"
+		expectedLongURL := "https://example.com"
+
+		mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+			WithArgs(shortURL).
+			WillReturnRows(sqlmock.NewRows([]string{"long_url"}).AddRow(expectedLongURL))
+
+		longURL, err := s.LongURL(context.Background(), shortURL)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if longURL != expectedLongURL {
+			t.Errorf("getLongURL returned wrong URL: got %v want %v", longURL, expectedLongURL)
+		}
+	})
+
+	t.Run("Non-existent Short URL", func(t *testing.T) {
+		shortURL := "nonexistent"
+
+		mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+			WithArgs(shortURL).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := s.LongURL(context.Background(), shortURL)
+		if err == nil {
+			t.Error("Expected an error, got nil")
+		}
+	})
+
+	t.Run("Query Timeout", func(t *testing.T) {
+		shortURL := "slow"
+		s.queryTimeout = 10 * time.Millisecond
+		defer func() { s.queryTimeout = config.DefaultQueryTimeout }()
+
+		mock.ExpectQuery("SELECT long_url FROM url_mapping WHERE short_url").
+			WithArgs(shortURL).
+			WillDelayFor(time.Second).
+			WillReturnRows(sqlmock.NewRows([]string{"long_url"}).AddRow("https://example.com"))
+
+		_, err := s.LongURL(context.Background(), shortURL)
+		if err == nil {
+			t.Error("Expected the query to be cancelled, got nil")
+		}
+	})
+}
+
+func TestGetStats(t *testing.T) {
+	s, mock := newMockSQLite(t)
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	// Rows written by other tools may hold RFC 3339 instead.
+	nowRFC3339 := time.Now().UTC().Format(time.RFC3339)
+	mock.ExpectQuery("SELECT COUNT.*, COALESCE.*FROM url_mapping").WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).AddRow(10, 100))
+	mock.ExpectQuery("SELECT COALESCE.*FROM url_mapping WHERE created_at >= .* AND created_at < .*").WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(5))
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at FROM url_mapping .* ORDER BY visit_count DESC LIMIT").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("abc123", "https://example.com", 50, now).
+			AddRow("def456", "https://example.org", 30, nowRFC3339))
+	mock.ExpectQuery("SELECT short_url, long_url, visit_count, created_at FROM url_mapping .* ORDER BY created_at DESC LIMIT").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"short_url", "long_url", "visit_count", "created_at"}).
+			AddRow("def456", "https://example.org", 30, now))
+
+	stats, err := s.Stats(context.Background())
+	if err != nil {
+		t.Fatalf("getStats returned an error: %v", err)
+	}
+
+	if stats.TotalLinks != 10 {
+		t.Errorf("getStats returned wrong TotalLinks: got %v want %v", stats.TotalLinks, 10)
+	}
+
+	if stats.TotalClicks != 100 {
+		t.Errorf("getStats returned wrong TotalClicks: got %v want %v", stats.TotalClicks, 100)
+	}
+
+	if stats.ClicksToday != 5 {
+		t.Errorf("getStats returned wrong ClicksToday: got %v want %v", stats.ClicksToday, 5)
+	}
+
+	if len(stats.PopularLinks) != 2 {
+		t.Errorf("getStats returned wrong number of PopularLinks: got %v want %v", len(stats.PopularLinks), 2)
+	}
+
+	if len(stats.RecentLinks) != 1 || stats.RecentLinks[0].ShortURL != "def456" {
+		t.Errorf("getStats returned wrong RecentLinks: %+v", stats.RecentLinks)
+	}
+}
+
+func TestParseCreatedAt(t *testing.T) {
+	want := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, s := range []string{
+		"2024-01-02T15:04:05Z",
+		"2024-01-02 15:04:05",
+		"2024-01-02T15:04:05",
+		"2024-01-02T17:04:05+02:00",
+		"2024-01-02 15:04:05.000",
+		" 2024-01-02 15:04:05Z ",
+		"1704207845",
+	} {
+		if got := parseCreatedAt(s); !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("parseCreatedAt(%q) returned %v want %v", s, got, want)
+		}
+	}
+	for _, s := range []string{"yesterday", ""} {
+		if got := parseCreatedAt(s); !got.IsZero() {
+			t.Errorf("parseCreatedAt(%q) returned %v want the zero time", s, got)
+		}
+	}
+	if got := formatCreatedAt(want.In(time.FixedZone("", 7200))); got != "2024-01-02T15:04:05Z" {
+		t.Errorf("formatCreatedAt returned %q want 2024-01-02T15:04:05Z", got)
+	}
+}
+
+func TestShortURLExists(t *testing.T) {
+	s, mock := newMockSQLite(t)
+
+	t.Run("Existing Short URL", func(t *testing.T) {
+		shortURL := "abc123"
+
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs(shortURL).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+		exists, err := s.Exists(context.Background(), shortURL)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if !exists {
+			t.Errorf("shortURLExists returned false for existing URL")
+		}
+	})
+
+	t.Run("Non-existent Short URL", func(t *testing.T) {
+		shortURL := "nonexistent"
+
+		mock.ExpectQuery("SELECT EXISTS").
+			WithArgs(shortURL).
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		exists, err := s.Exists(context.Background(), shortURL)
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if exists {
+			t.Errorf("shortURLExists returned true for non-existent URL")
+		}
+	})
+}
+
+// Add more edge cases to existing tests
+
+func TestSQLiteRoundTrip(t *testing.T) {
+	testRoundTrip(t, newTestSQLite(t))
+}
+
+// testRoundTrip runs a link through every change a Store supports.
+func testRoundTrip(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if err := s.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatalf("Create returned an error: %v", err)
+	}
+	if err := s.Create(ctx, "abc123", "https://example.org"); err != ErrExists {
+		t.Errorf("Create of a taken short URL returned %v, want ErrExists", err)
+	}
+
+	if shortURL, err := s.ShortURLFor(ctx, "https://example.com"); err != nil || shortURL != "abc123" {
+		t.Errorf("ShortURLFor returned %q, %v", shortURL, err)
+	}
+	if _, err := s.ShortURLFor(ctx, "https://missing.example"); err != ErrNotFound {
+		t.Errorf("ShortURLFor of an unknown URL returned %v, want ErrNotFound", err)
+	}
+
+	first, err := s.NextID(ctx)
+	if err != nil {
+		t.Fatalf("NextID returned an error: %v", err)
+	}
+	if next, err := s.NextID(ctx); err != nil || next != first+1 {
+		t.Errorf("NextID returned %v, %v want %v", next, err, first+1)
+	}
+
+	if found, err := s.RecordVisit(ctx, "abc123"); err != nil || !found {
+		t.Errorf("RecordVisit returned %v, %v", found, err)
+	}
+	link, err := s.Link(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("Link returned an error: %v", err)
+	}
+	if link.VisitCount != 1 || link.LongURL != "https://example.com" {
+		t.Errorf("Link returned unexpected link: %+v", link)
+	}
+	if found, err := s.RecordBotVisit(ctx, "abc123"); err != nil || !found {
+		t.Errorf("RecordBotVisit returned %v, %v", found, err)
+	}
+	if visits, err := s.BotVisits(ctx, "abc123"); err != nil || visits != 1 {
+		t.Errorf("BotVisits returned %v, %v want 1", visits, err)
+	}
+	if _, err := s.BotVisits(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("BotVisits of an unknown link returned %v, want ErrNotFound", err)
+	}
+
+	want := Targets{IOS: "https://apps.apple.com/app/id1", Android: "market://details?id=example"}
+	if err := s.SetTargets(ctx, "abc123", want); err != nil {
+		t.Fatalf("SetTargets returned an error: %v", err)
+	}
+	if targets, err := s.Targets(ctx, "abc123"); err != nil || targets != want {
+		t.Errorf("Targets returned %+v, %v want %+v", targets, err, want)
+	}
+	if err := s.SetTargets(ctx, "missing", want); err != ErrNotFound {
+		t.Errorf("SetTargets of an unknown link returned %v, want ErrNotFound", err)
+	}
+
+	wantOpts := Options{PassQuery: true, Prefix: true, UTMSource: "newsletter", UTMCampaign: "launch", FallbackURL: "https://example.org", MaxClicks: 2}
+	if err := s.SetOptions(ctx, "abc123", wantOpts); err != nil {
+		t.Fatalf("SetOptions returned an error: %v", err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || opts != wantOpts {
+		t.Errorf("Options returned %+v, %v want %+v", opts, err, wantOpts)
+	}
+	untracked := Options{NoAnalytics: true}
+	if err := s.SetOptions(ctx, "abc123", untracked); err != nil {
+		t.Fatalf("SetOptions returned an error: %v", err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || opts != untracked {
+		t.Errorf("Options returned %+v, %v want %+v", opts, err, untracked)
+	}
+	if err := s.SetOptions(ctx, "abc123", wantOpts); err != nil {
+		t.Fatalf("SetOptions returned an error: %v", err)
+	}
+	if _, err := s.Options(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Options of an unknown link returned %v, want ErrNotFound", err)
+	}
+	if err := s.SetActive(ctx, "abc123", false); err != nil {
+		t.Fatalf("SetActive returned an error: %v", err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || !opts.Disabled {
+		t.Errorf("Options of a disabled link returned %+v, %v", opts, err)
+	}
+	if _, err := s.ShortURLFor(ctx, "https://example.com"); err != ErrNotFound {
+		t.Errorf("ShortURLFor matched a disabled link, returned %v", err)
+	}
+	if err := s.SetActive(ctx, "abc123", true); err != nil {
+		t.Fatalf("SetActive returned an error: %v", err)
+	}
+	if err := s.SetActive(ctx, "missing", false); err != ErrNotFound {
+		t.Errorf("SetActive of an unknown link returned %v, want ErrNotFound", err)
+	}
+	for i, want := range []bool{true, false} {
+		if found, err := s.RecordVisit(ctx, "abc123"); err != nil || found != want {
+			t.Errorf("Visit %d to a link capped at 2 clicks returned %v, %v want %v", i+2, found, err, want)
+		}
+	}
+
+	if _, err := s.Health(ctx, "abc123"); err != ErrNotFound {
+		t.Errorf("Health of an unchecked link returned %v, want ErrNotFound", err)
+	}
+	checkedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, health := range []Health{{StatusCode: 200, CheckedAt: checkedAt}, {StatusCode: 404, CheckedAt: checkedAt}} {
+		if err := s.SetHealth(ctx, "abc123", health); err != nil {
+			t.Fatalf("SetHealth returned an error: %v", err)
+		}
+	}
+	if health, err := s.Health(ctx, "abc123"); err != nil || health.StatusCode != 404 || !health.CheckedAt.Equal(checkedAt) {
+		t.Errorf("Health returned %+v, %v", health, err)
+	}
+	broken, err := s.BrokenLinks(ctx)
+	if err != nil || len(broken) != 1 || broken[0].ShortURL != "abc123" || broken[0].LongURL != "https://example.com" {
+		t.Errorf("BrokenLinks returned %+v, %v", broken, err)
+	}
+	for _, entry := range []AuditEntry{
+		{ShortURL: "abc123", Action: AuditCreate, Actor: "web 192.0.2.1", After: json.RawMessage(`{"longURL":"https://example.com"}`), CreatedAt: checkedAt},
+		{ShortURL: "other", Action: AuditDelete, Actor: "admin", Before: json.RawMessage(`{"longURL":"https://example.org"}`), CreatedAt: checkedAt},
+	} {
+		if err := s.RecordAudit(ctx, entry); err != nil {
+			t.Fatalf("RecordAudit returned an error: %v", err)
+		}
+	}
+	if entries, err := s.AuditLog(ctx, "", 10); err != nil || len(entries) != 2 || entries[0].ShortURL != "other" {
+		t.Errorf("AuditLog returned %+v, %v", entries, err)
+	}
+	entries, err := s.AuditLog(ctx, "abc123", 10)
+	if err != nil || len(entries) != 1 || entries[0].Actor != "web 192.0.2.1" || entries[0].Before != nil || string(entries[0].After) != `{"longURL":"https://example.com"}` {
+		t.Errorf("AuditLog of one link returned %+v, %v", entries, err)
+	}
+	if links, err := s.Links(ctx); err != nil || len(links) != 1 || links[0].ShortURL != "abc123" {
+		t.Errorf("Links returned %+v, %v", links, err)
+	}
+
+	variants := []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 3},
+		{Name: "b", URL: "https://example.com/b", Weight: 1},
+	}
+	if err := s.SetVariants(ctx, "abc123", variants); err != nil {
+		t.Fatalf("SetVariants returned an error: %v", err)
+	}
+	if err := s.RecordVariantVisit(ctx, "abc123", "b"); err != nil {
+		t.Fatalf("RecordVariantVisit returned an error: %v", err)
+	}
+	variants[1].VisitCount = 1
+	got, err := s.Variants(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("Variants returned an error: %v", err)
+	}
+	if len(got) != len(variants) || got[0] != variants[0] || got[1] != variants[1] {
+		t.Errorf("Variants returned %+v want %+v", got, variants)
+	}
+
+	if trashed, err := s.Trash(ctx, "abc123"); err != nil || !trashed {
+		t.Errorf("Trash returned %v, %v", trashed, err)
+	}
+	if _, err := s.LongURL(ctx, "abc123"); err != ErrNotFound {
+		t.Errorf("LongURL of a trashed link returned %v, want ErrNotFound", err)
+	}
+	if exists, err := s.Exists(ctx, "abc123"); err != nil || !exists {
+		t.Errorf("Exists of a trashed link returned %v, %v want true", exists, err)
+	}
+	if trash, err := s.Trashed(ctx); err != nil || len(trash) != 1 || trash[0].ShortURL != "abc123" {
+		t.Errorf("Trashed returned %+v, %v", trash, err)
+	}
+	if err := s.Restore(ctx, "abc123"); err != nil {
+		t.Errorf("Restore returned an error: %v", err)
+	}
+	if err := s.Restore(ctx, "abc123"); err != ErrNotFound {
+		t.Errorf("Restore of a link that isn't trashed returned %v, want ErrNotFound", err)
+	}
+	if _, err := s.Trash(ctx, "abc123"); err != nil {
+		t.Fatalf("Trash returned an error: %v", err)
+	}
+	if purged, err := s.PurgeTrash(ctx, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("PurgeTrash of recent deletions returned %v, %v want 0", purged, err)
+	}
+	if err := s.Restore(ctx, "abc123"); err != nil {
+		t.Errorf("Restore returned an error: %v", err)
+	}
+
+	if deleted, err := s.Delete(ctx, "abc123"); err != nil || !deleted {
+		t.Errorf("Delete returned %v, %v", deleted, err)
+	}
+	if _, err := s.LongURL(ctx, "abc123"); err != ErrNotFound {
+		t.Errorf("LongURL of a deleted link returned %v, want ErrNotFound", err)
+	}
+	if got, err := s.Variants(ctx, "abc123"); err != nil || len(got) != 0 {
+		t.Errorf("Variants of a deleted link returned %+v, %v", got, err)
+	}
+}
+
+func TestSQLiteClickLog(t *testing.T) {
+	testClickLog(t, newTestSQLite(t))
+}
+
+// testClickLog checks the click log and the series and heatmap read from it.
+func testClickLog(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	clicks := []Click{
+		{ShortURL: "abc123", Referrer: "https://news.example", At: now.Add(-25 * time.Hour)},
+		{ShortURL: "abc123", At: now.Add(-2*time.Hour + time.Minute)},
+		{ShortURL: "def456", At: now.Add(-2*time.Hour + 2*time.Minute)},
+		{ShortURL: "abc123", UserAgent: "curl/8.0", IP: "192.0.2.1", At: now.Add(time.Minute)},
+		{ShortURL: "abc123", UserAgent: "Googlebot/2.1", Bot: "user-agent", At: now.Add(2 * time.Minute)},
+	}
+	for _, click := range clicks {
+		if err := s.RecordClick(ctx, click); err != nil {
+			t.Fatalf("RecordClick returned an error: %v", err)
+		}
+	}
+
+	recent, err := s.RecentClicks(ctx, "abc123", 2)
+	if err != nil {
+		t.Fatalf("RecentClicks returned an error: %v", err)
+	}
+	if len(recent) != 2 || recent[0].UserAgent != "curl/8.0" || recent[0].IP != "192.0.2.1" || !recent[0].At.Equal(clicks[3].At) {
+		t.Errorf("RecentClicks returned %+v", recent)
+	}
+
+	series, err := s.ClickSeries(ctx, "", now.Add(-24*time.Hour), Hourly)
+	if err != nil {
+		t.Fatalf("ClickSeries returned an error: %v", err)
+	}
+	want := []ClickCount{{Time: now.Add(-2 * time.Hour), Clicks: 2}, {Time: now, Clicks: 1}}
+	if len(series) != len(want) {
+		t.Fatalf("ClickSeries returned %+v, want %+v", series, want)
+	}
+	for i := range want {
+		if !series[i].Time.Equal(want[i].Time) || series[i].Clicks != want[i].Clicks {
+			t.Errorf("ClickSeries[%d] = %+v, want %+v", i, series[i], want[i])
+		}
+	}
+
+	daily, err := s.ClickSeries(ctx, "abc123", now.Add(-48*time.Hour), Daily)
+	if err != nil {
+		t.Fatalf("ClickSeries returned an error: %v", err)
+	}
+	total := 0
+	for _, count := range daily {
+		total += count.Clicks
+		if count.Time.Hour() != 0 {
+			t.Errorf("daily interval starts at %v, want midnight", count.Time)
+		}
+	}
+	if total != 3 {
+		t.Errorf("daily ClickSeries counted %d clicks, want 3", total)
+	}
+
+	heatmap, err := s.ClickHeatmap(ctx, "abc123")
+	if err != nil {
+		t.Fatalf("ClickHeatmap returned an error: %v", err)
+	}
+	last := clicks[3].At
+	if heatmap[last.Weekday()][last.Hour()] != 1 {
+		t.Errorf("ClickHeatmap missed the click at %v: %v", last, heatmap)
+	}
+	if hours := heatmap.ByHour(); hours[last.Hour()] < 1 {
+		t.Errorf("ByHour returned %v", hours)
+	}
+	total = 0
+	for _, clicks := range heatmap.ByWeekday() {
+		total += clicks
+	}
+	if total != 3 {
+		t.Errorf("ClickHeatmap counted %d clicks, want 3", total)
+	}
+
+	if _, err := s.ClickSeries(ctx, "", now, Interval("week")); err == nil {
+		t.Error("ClickSeries with an unknown interval returned no error")
+	}
+}
+
+func TestSQLiteAnomalies(t *testing.T) {
+	testAnomalies(t, newTestSQLite(t))
+}
+
+// testAnomalies checks that bursts from few addresses are found and logged.
+func testAnomalies(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	record := func(click Click) {
+		if err := s.RecordClick(ctx, click); err != nil {
+			t.Fatalf("RecordClick returned an error: %v", err)
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		record(Click{ShortURL: "burst", IP: "192.0.2.1", At: now})
+		record(Click{ShortURL: "popular", IP: fmt.Sprintf("192.0.2.%d", i+10), At: now})
+		record(Click{ShortURL: "crawled", IP: "192.0.2.2", Bot: "user-agent", At: now})
+	}
+	record(Click{ShortURL: "old", IP: "192.0.2.3", At: now.Add(-time.Hour)})
+
+	bursts, err := s.ClickBursts(ctx, now.Add(-10*time.Minute), 5, 2)
+	if err != nil {
+		t.Fatalf("ClickBursts returned an error: %v", err)
+	}
+	if len(bursts) != 1 || bursts[0].ShortURL != "burst" || bursts[0].Clicks != 5 || bursts[0].UniqueIPs != 1 {
+		t.Fatalf("ClickBursts returned %+v", bursts)
+	}
+
+	bursts[0].DetectedAt = now
+	if err := s.RecordAnomaly(ctx, bursts[0]); err != nil {
+		t.Fatalf("RecordAnomaly returned an error: %v", err)
+	}
+	anomalies, err := s.Anomalies(ctx, 10)
+	if err != nil {
+		t.Fatalf("Anomalies returned an error: %v", err)
+	}
+	if len(anomalies) != 1 || anomalies[0].ShortURL != "burst" || !anomalies[0].DetectedAt.Equal(now) || anomalies[0].ID == 0 {
+		t.Errorf("Anomalies returned %+v", anomalies)
+	}
+}
+
+func TestSQLiteClickRollups(t *testing.T) {
+	testClickRollups(t, newTestSQLite(t))
+}
+
+// testClickRollups checks that old clicks are compacted into daily rollups
+// that the series still count.
+func testClickRollups(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	day := today.AddDate(0, 0, -3)
+	clicks := []Click{
+		{ShortURL: "abc123", Referrer: "https://news.example", IP: "192.0.2.1", Country: "NL", At: day.Add(time.Hour)},
+		{ShortURL: "abc123", Referrer: "https://news.example", IP: "192.0.2.1", Country: "DE", At: day.Add(2 * time.Hour)},
+		{ShortURL: "abc123", Referrer: "https://blog.example", IP: "192.0.2.2", Country: "NL", At: day.Add(3 * time.Hour)},
+		{ShortURL: "abc123", IP: "192.0.2.3", At: day.Add(-time.Hour)},
+		{ShortURL: "abc123", UserAgent: "Googlebot/2.1", Bot: "user-agent", At: day.Add(4 * time.Hour)},
+		{ShortURL: "abc123", IP: "192.0.2.4", At: today.Add(time.Minute)},
+	}
+	for _, click := range clicks {
+		if err := s.RecordClick(ctx, click); err != nil {
+			t.Fatalf("RecordClick returned an error: %v", err)
+		}
+	}
+
+	compacted, err := s.RollupClicks(ctx, today.AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatalf("RollupClicks returned an error: %v", err)
+	}
+	if compacted != 5 {
+		t.Errorf("RollupClicks compacted %d clicks, want 5", compacted)
+	}
+
+	rollups, err := s.ClickRollups(ctx, "abc123", day)
+	if err != nil {
+		t.Fatalf("ClickRollups returned an error: %v", err)
+	}
+	want := ClickRollup{ShortURL: "abc123", Day: day, Clicks: 3, Uniques: 2, TopReferrer: "https://news.example", TopCountry: "NL"}
+	if len(rollups) != 1 || rollups[0] != want {
+		t.Errorf("ClickRollups returned %+v, want %+v", rollups, want)
+	}
+	if all, err := s.ClickRollups(ctx, "", day.AddDate(0, 0, -1)); err != nil || len(all) != 2 {
+		t.Errorf("ClickRollups of every link returned %+v, %v", all, err)
+	}
+
+	recent, err := s.RecentClicks(ctx, "", 10)
+	if err != nil || len(recent) != 1 {
+		t.Errorf("RecentClicks after the rollup returned %+v, %v", recent, err)
+	}
+
+	daily, err := s.ClickSeries(ctx, "abc123", day.AddDate(0, 0, -1), Daily)
+	if err != nil {
+		t.Fatalf("ClickSeries returned an error: %v", err)
+	}
+	total := 0
+	for _, count := range daily {
+		total += count.Clicks
+	}
+	if len(daily) != 3 || total != 5 {
+		t.Errorf("daily ClickSeries returned %+v, want rolled up and raw days", daily)
+	}
+	if hourly, err := s.ClickSeries(ctx, "abc123", day.AddDate(0, 0, -1), Hourly); err != nil || len(hourly) != 1 {
+		t.Errorf("hourly ClickSeries returned %+v, %v, want raw clicks only", hourly, err)
+	}
+
+	if purged, err := s.PurgeRollups(ctx, day); err != nil || purged != 1 {
+		t.Errorf("PurgeRollups returned %v, %v want 1", purged, err)
+	}
+	if all, err := s.ClickRollups(ctx, "", day.AddDate(0, 0, -1)); err != nil || len(all) != 1 || !all[0].Day.Equal(day) {
+		t.Errorf("ClickRollups after the purge returned %+v, %v", all, err)
+	}
+	if purged, err := s.PurgeClicks(ctx, today); err != nil || purged != 0 {
+		t.Errorf("PurgeClicks of today's clicks returned %v, %v want 0", purged, err)
+	}
+	if purged, err := s.PurgeClicks(ctx, today.Add(time.Hour)); err != nil || purged != 1 {
+		t.Errorf("PurgeClicks returned %v, %v want 1", purged, err)
+	}
+}
+
+func TestSQLiteLinksSinceAndTopLinks(t *testing.T) {
+	s := newTestSQLite(t)
+	testLinksSinceAndTopLinks(t, s, func(shortURL string) {
+		if _, err := s.DB().Exec(`UPDATE url_mapping SET created_at = '2020-01-01 00:00:00' WHERE short_url = ?`, shortURL); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// testLinksSinceAndTopLinks checks which links count as new and which as
+// most clicked. backdate makes a link look years old.
+func testLinksSinceAndTopLinks(t *testing.T, s Store, backdate func(shortURL string)) {
+	ctx := context.Background()
+
+	for _, shortURL := range []string{"old", "abc123", "def456", "gone"} {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatalf("Create returned an error: %v", err)
+		}
+	}
+	backdate("old")
+	if _, err := s.Trash(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	links, err := s.LinksSince(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("LinksSince returned an error: %v", err)
+	}
+	if len(links) != 2 || links[0].ShortURL != "def456" || links[1].ShortURL != "abc123" {
+		t.Errorf("LinksSince returned %+v", links)
+	}
+
+	clicks := []Click{
+		{ShortURL: "abc123", At: now.Add(-time.Hour)},
+		{ShortURL: "def456", At: now.Add(-time.Hour)},
+		{ShortURL: "def456", At: now.Add(-time.Hour)},
+		{ShortURL: "def456", Bot: "user-agent", At: now.Add(-time.Hour)},
+		{ShortURL: "gone", At: now.Add(-time.Hour)},
+		{ShortURL: "old", At: now.AddDate(0, 0, -2)},
+		{ShortURL: "old", At: now.AddDate(0, 0, -30)},
+	}
+	for _, click := range clicks {
+		if err := s.RecordClick(ctx, click); err != nil {
+			t.Fatalf("RecordClick returned an error: %v", err)
+		}
+	}
+	today := now.Truncate(24 * time.Hour)
+	if _, err := s.RollupClicks(ctx, today.AddDate(0, 0, -1)); err != nil {
+		t.Fatalf("RollupClicks returned an error: %v", err)
+	}
+
+	top, err := s.TopLinks(ctx, now.AddDate(0, 0, -7), 10)
+	if err != nil {
+		t.Fatalf("TopLinks returned an error: %v", err)
+	}
+	want := []LinkClicks{
+		{ShortURL: "def456", LongURL: "https://example.com/def456", Clicks: 2},
+		{ShortURL: "abc123", LongURL: "https://example.com/abc123", Clicks: 1},
+		{ShortURL: "old", LongURL: "https://example.com/old", Clicks: 1},
+	}
+	if len(top) != len(want) {
+		t.Fatalf("TopLinks returned %+v, want %+v", top, want)
+	}
+	for i := range want {
+		if top[i] != want[i] {
+			t.Errorf("TopLinks[%d] = %+v, want %+v", i, top[i], want[i])
+		}
+	}
+	if top, err := s.TopLinks(ctx, now.AddDate(0, 0, -7), 1); err != nil || len(top) != 1 {
+		t.Errorf("TopLinks with a limit of 1 returned %+v, %v", top, err)
+	}
+	if top, err := s.TopLinks(ctx, now.AddDate(0, 0, -7), -1); err != nil || len(top) != len(want) {
+		t.Errorf("TopLinks with no limit returned %+v, %v", top, err)
+	}
+}
+
+func TestSQLiteAlerts(t *testing.T) {
+	testAlerts(t, newTestSQLite(t))
+}
+
+// testAlerts checks that alert rules are kept, updated and deleted with
+// their link.
+func testAlerts(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if err := s.Create(ctx, "watched", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	id, err := s.CreateAlert(ctx, Alert{ShortURL: "watched", Kind: AlertClicks, Threshold: 1000, Email: "ops@example.com", LastChange: now, CreatedAt: now})
+	if err != nil {
+		t.Fatalf("CreateAlert returned an error: %v", err)
+	}
+
+	alerts, err := s.Alerts(ctx, "watched")
+	if err != nil {
+		t.Fatalf("Alerts returned an error: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].ID != id || alerts[0].Threshold != 1000 || alerts[0].FiredAt != nil || !alerts[0].CreatedAt.Equal(now) {
+		t.Fatalf("Alerts returned %+v", alerts)
+	}
+
+	alerts[0].LastCount = 1000
+	alerts[0].FiredAt = &now
+	if err := s.UpdateAlert(ctx, alerts[0]); err != nil {
+		t.Fatalf("UpdateAlert returned an error: %v", err)
+	}
+	if alerts, err = s.Alerts(ctx, ""); err != nil || len(alerts) != 1 || alerts[0].LastCount != 1000 || alerts[0].FiredAt == nil || !alerts[0].FiredAt.Equal(now) {
+		t.Fatalf("Alerts after UpdateAlert returned %+v, %v", alerts, err)
+	}
+
+	if deleted, err := s.DeleteAlert(ctx, "other", id); err != nil || deleted {
+		t.Errorf("DeleteAlert on another link returned %v, %v", deleted, err)
+	}
+	if deleted, err := s.DeleteAlert(ctx, "watched", id); err != nil || !deleted {
+		t.Errorf("DeleteAlert returned %v, %v", deleted, err)
+	}
+
+	if _, err := s.CreateAlert(ctx, Alert{ShortURL: "watched", Kind: AlertIdle, Threshold: 7, Webhook: "https://hooks.example.com", LastChange: now, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(ctx, "watched"); err != nil {
+		t.Fatal(err)
+	}
+	if alerts, err = s.Alerts(ctx, ""); err != nil || len(alerts) != 0 {
+		t.Errorf("Alerts after deleting the link returned %+v, %v", alerts, err)
+	}
+}
+
+func TestSQLiteAcquireLease(t *testing.T) {
+	testAcquireLease(t, newTestSQLite(t))
+}
+
+// testAcquireLease checks that a lease has one holder until it expires.
+func testAcquireLease(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	acquire := func(name, holder string, ttl time.Duration, want bool) {
+		t.Helper()
+		got, err := s.AcquireLease(ctx, name, holder, ttl)
+		if err != nil {
+			t.Fatalf("AcquireLease returned an error: %v", err)
+		}
+		if got != want {
+			t.Errorf("AcquireLease(%q, %q) = %v, want %v", name, holder, got, want)
+		}
+	}
+	acquire("backups", "a", time.Hour, true)
+	acquire("backups", "b", time.Hour, false)
+	acquire("backups", "a", time.Hour, true)
+	acquire("digest", "b", time.Hour, true)
+
+	// An expired lease goes to whoever asks next.
+	acquire("sweep", "a", -time.Second, true)
+	acquire("sweep", "b", time.Hour, true)
+	acquire("sweep", "a", time.Hour, false)
+}
+
+func TestSQLiteEditLink(t *testing.T) {
+	testEditLink(t, newTestSQLite(t))
+}
+
+func TestSQLiteModeration(t *testing.T) {
+	testModeration(t, newTestSQLite(t))
+}
+
+// testEditLink checks the changes an admin makes to a link: its long URL,
+// expiry and tags.
+func testEditLink(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "gone01"} {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.SetLongURL(ctx, "abc123", "https://example.org/moved"); err != nil {
+		t.Fatalf("SetLongURL returned an error: %v", err)
+	}
+	if longURL, err := s.LongURL(ctx, "abc123"); err != nil || longURL != "https://example.org/moved" {
+		t.Errorf("LongURL after SetLongURL returned %q, %v", longURL, err)
+	}
+	if shortURL, err := s.ShortURLFor(ctx, "https://example.org/moved"); err != nil || shortURL != "abc123" {
+		t.Errorf("ShortURLFor of the new long URL returned %q, %v", shortURL, err)
+	}
+	if _, err := s.ShortURLFor(ctx, "https://example.com/abc123"); err != ErrNotFound {
+		t.Errorf("ShortURLFor of the old long URL returned %v, want ErrNotFound", err)
+	}
+	if err := s.SetLongURL(ctx, "missing", "https://example.org"); err != ErrNotFound {
+		t.Errorf("SetLongURL of a missing link returned %v, want ErrNotFound", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	if err := s.SetOptions(ctx, "abc123", Options{ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := s.Options(ctx, "abc123")
+	if err != nil || opts.ExpiresAt == nil || !opts.ExpiresAt.Equal(expiresAt) || opts.Expired(time.Now()) {
+		t.Errorf("Options after setting an expiry returned %+v, %v", opts, err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	if err := s.SetOptions(ctx, "def456", Options{ExpiresAt: &expired}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ShortURLFor(ctx, "https://example.com/def456"); err != ErrNotFound {
+		t.Errorf("ShortURLFor of an expired link returned %v, want ErrNotFound", err)
+	}
+
+	if err := s.SetTags(ctx, "abc123", []string{"promo", "launch", "promo"}); err != nil {
+		t.Fatalf("SetTags returned an error: %v", err)
+	}
+	if err := s.SetTags(ctx, "def456", []string{"promo"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTags(ctx, "gone01", []string{"promo"}); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.Tags(ctx, "abc123"); err != nil || strings.Join(tags, ",") != "launch,promo" {
+		t.Errorf("Tags returned %v, %v want [launch promo]", tags, err)
+	}
+	if tags, err := s.Tags(ctx, "missing"); err != nil || len(tags) != 0 {
+		t.Errorf("Tags of an untagged link returned %v, %v", tags, err)
+	}
+	if _, err := s.Trash(ctx, "gone01"); err != nil {
+		t.Fatal(err)
+	}
+	if tagged, err := s.TaggedLinks(ctx, "promo"); err != nil || strings.Join(tagged, ",") != "abc123,def456" {
+		t.Errorf("TaggedLinks returned %v, %v want [abc123 def456]", tagged, err)
+	}
+
+	if err := s.SetTags(ctx, "abc123", nil); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.Tags(ctx, "abc123"); err != nil || len(tags) != 0 {
+		t.Errorf("Tags after clearing them returned %v, %v", tags, err)
+	}
+	if _, err := s.Delete(ctx, "def456"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := s.Tags(ctx, "def456"); err != nil || len(tags) != 0 {
+		t.Errorf("Tags of a deleted link returned %v, %v", tags, err)
+	}
+}
+
+func testModeration(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "gone01"} {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.FlagLink(ctx, "abc123", "report", "phishing"); err != nil {
+		t.Fatalf("FlagLink returned an error: %v", err)
+	}
+	if err := s.FlagLink(ctx, "abc123", "policy", "later flag"); err != nil {
+		t.Fatal(err)
+	}
+	for _, shortURL := range []string{"def456", "gone01"} {
+		if err := s.FlagLink(ctx, shortURL, "policy", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.FlagLink(ctx, "missing", "report", ""); err != ErrNotFound {
+		t.Errorf("FlagLink of a missing link returned %v, want ErrNotFound", err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || !opts.Flagged {
+		t.Errorf("Options of a flagged link returned %+v, %v", opts, err)
+	}
+	// Setting options doesn't clear the flag.
+	if err := s.SetOptions(ctx, "abc123", Options{PassQuery: true}); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || !opts.Flagged || !opts.PassQuery {
+		t.Errorf("Options after SetOptions on a flagged link returned %+v, %v", opts, err)
+	}
+	if _, err := s.Trash(ctx, "gone01"); err != nil {
+		t.Fatal(err)
+	}
+
+	flagged, err := s.FlaggedLinks(ctx)
+	if err != nil {
+		t.Fatalf("FlaggedLinks returned an error: %v", err)
+	}
+	if len(flagged) != 2 || flagged[0].ShortURL != "abc123" || flagged[1].ShortURL != "def456" {
+		t.Fatalf("FlaggedLinks returned %+v, want abc123 and def456", flagged)
+	}
+	if link := flagged[0]; link.Source != "report" || link.Reason != "phishing" || link.LongURL != "https://example.com/abc123" || link.FlaggedAt.IsZero() {
+		t.Errorf("FlaggedLinks returned %+v, want the first flag kept", link)
+	}
+
+	if cleared, err := s.ClearFlag(ctx, "abc123"); err != nil || !cleared {
+		t.Errorf("ClearFlag returned %v, %v want true", cleared, err)
+	}
+	if cleared, err := s.ClearFlag(ctx, "abc123"); err != nil || cleared {
+		t.Errorf("ClearFlag of an unflagged link returned %v, %v want false", cleared, err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || opts.Flagged {
+		t.Errorf("Options after ClearFlag returned %+v, %v", opts, err)
+	}
+	if flagged, err := s.FlaggedLinks(ctx); err != nil || len(flagged) != 1 || flagged[0].ShortURL != "def456" {
+		t.Errorf("FlaggedLinks after ClearFlag returned %+v, %v", flagged, err)
+	}
+
+	if err := s.BanDomain(ctx, "example.net", "spam"); err != nil {
+		t.Fatalf("BanDomain returned an error: %v", err)
+	}
+	if err := s.BanDomain(ctx, "bad.example", "malware"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.BanDomain(ctx, "example.net", "phishing"); err != nil {
+		t.Fatal(err)
+	}
+	banned, err := s.BannedDomains(ctx)
+	if err != nil {
+		t.Fatalf("BannedDomains returned an error: %v", err)
+	}
+	if len(banned) != 2 || banned[0].Domain != "bad.example" || banned[1].Domain != "example.net" || banned[1].Reason != "phishing" || banned[1].BannedAt.IsZero() {
+		t.Errorf("BannedDomains returned %+v", banned)
+	}
+	if unbanned, err := s.UnbanDomain(ctx, "example.net"); err != nil || !unbanned {
+		t.Errorf("UnbanDomain returned %v, %v want true", unbanned, err)
+	}
+	if unbanned, err := s.UnbanDomain(ctx, "example.net"); err != nil || unbanned {
+		t.Errorf("UnbanDomain of a domain that isn't banned returned %v, %v want false", unbanned, err)
+	}
+	if banned, err := s.BannedDomains(ctx); err != nil || len(banned) != 1 {
+		t.Errorf("BannedDomains after UnbanDomain returned %+v, %v", banned, err)
+	}
+}
+
+func TestSQLiteTakedowns(t *testing.T) {
+	testTakedowns(t, newTestSQLite(t))
+}
+
+// testTakedowns checks takedown notices and the links taken down under
+// them.
+func testTakedowns(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "ghi789"} {
+		if err := s.Create(ctx, shortURL, "https://bad.example/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := s.CreateTakedown(ctx, Takedown{Kind: TakedownLink, Target: "abc123", Requester: "abuse@example.net", Reference: "T-1", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("CreateTakedown returned an error: %v", err)
+	}
+	second, err := s.CreateTakedown(ctx, Takedown{Kind: TakedownDomain, Target: "bad.example", Reason: "malware", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == 0 || second <= first {
+		t.Errorf("CreateTakedown returned IDs %d and %d, want increasing IDs", first, second)
+	}
+
+	if err := s.TakeDown(ctx, "abc123", first); err != nil {
+		t.Fatalf("TakeDown returned an error: %v", err)
+	}
+	for _, shortURL := range []string{"def456", "ghi789"} {
+		if err := s.TakeDown(ctx, shortURL, second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.TakeDown(ctx, "missing", first); err != ErrNotFound {
+		t.Errorf("TakeDown of a missing link returned %v want ErrNotFound", err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || !opts.Disabled || !opts.TakenDown {
+		t.Errorf("Options after TakeDown returned %+v, %v want disabled and taken down", opts, err)
+	}
+
+	// Other changes keep the takedown; switching the link back on lifts it.
+	if err := s.SetOptions(ctx, "abc123", Options{PassQuery: true}); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || !opts.TakenDown {
+		t.Errorf("Options after SetOptions returned %+v, %v want taken down", opts, err)
+	}
+	if err := s.SetActive(ctx, "ghi789", true); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := s.Options(ctx, "ghi789"); err != nil || opts.Disabled || opts.TakenDown {
+		t.Errorf("Options after SetActive returned %+v, %v want active", opts, err)
+	}
+
+	takedowns, err := s.Takedowns(ctx)
+	if err != nil {
+		t.Fatalf("Takedowns returned an error: %v", err)
+	}
+	if len(takedowns) != 2 {
+		t.Fatalf("Takedowns returned %d notices want 2", len(takedowns))
+	}
+	if got := takedowns[0]; got.ID != second || got.Kind != TakedownDomain || got.Target != "bad.example" || got.Reason != "malware" || strings.Join(got.Links, ",") != "def456" {
+		t.Errorf("Takedowns returned %+v first", got)
+	}
+	if got := takedowns[1]; got.ID != first || got.Requester != "abuse@example.net" || got.Reference != "T-1" || got.CreatedAt.IsZero() || strings.Join(got.Links, ",") != "abc123" {
+		t.Errorf("Takedowns returned %+v second", got)
+	}
+}
+
+func TestSQLiteAPIKeyUsage(t *testing.T) {
+	testAPIKeyUsage(t, newTestSQLite(t))
+}
+
+// testAPIKeyUsage checks links remember their API key and usage adds up
+// per key and month.
+func testAPIKeyUsage(t *testing.T, s Store) {
+	ctx := context.Background()
+	if err := s.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetAPIKey(ctx, "abc123", "ci"); err != nil {
+		t.Fatalf("SetAPIKey returned an error: %v", err)
+	}
+	if err := s.SetAPIKey(ctx, "missing", "ci"); err != ErrNotFound {
+		t.Errorf("SetAPIKey of a missing link returned %v want ErrNotFound", err)
+	}
+	if err := s.SetOptions(ctx, "abc123", Options{PassQuery: true}); err != nil {
+		t.Fatal(err)
+	}
+	if opts, err := s.Options(ctx, "abc123"); err != nil || opts.APIKey != "ci" || !opts.PassQuery {
+		t.Errorf("Options returned %+v, %v want API key ci", opts, err)
+	}
+
+	for _, u := range []struct {
+		key, month         string
+		creates, redirects int
+	}{
+		{"ci", "2024-01", 1, 0},
+		{"ci", "2024-01", 0, 5},
+		{"ci", "2024-02", 2, 1},
+		{"ci-other", "2024-02", 9, 9},
+	} {
+		if err := s.RecordUsage(ctx, u.key, u.month, u.creates, u.redirects); err != nil {
+			t.Fatalf("RecordUsage returned an error: %v", err)
+		}
+	}
+	usage, err := s.Usage(ctx, "ci")
+	if err != nil {
+		t.Fatalf("Usage returned an error: %v", err)
+	}
+	want := []Usage{{Month: "2024-02", Creates: 2, Redirects: 1}, {Month: "2024-01", Creates: 1, Redirects: 5}}
+	if len(usage) != len(want) || usage[0] != want[0] || usage[1] != want[1] {
+		t.Errorf("Usage returned %+v want %+v", usage, want)
+	}
+	if usage, err := s.Usage(ctx, "unknown"); err != nil || len(usage) != 0 {
+		t.Errorf("Usage of an unused key returned %+v, %v", usage, err)
+	}
+}
+
+func TestSQLiteTeams(t *testing.T) {
+	testTeams(t, newTestSQLite(t))
+}
+
+// testTeams checks teams, their members and the links they own.
+func testTeams(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, name := range []string{"marketing", "engineering"} {
+		if err := s.CreateTeam(ctx, name); err != nil {
+			t.Fatalf("CreateTeam returned an error: %v", err)
+		}
+	}
+	if err := s.CreateTeam(ctx, "marketing"); err != ErrTeamExists {
+		t.Errorf("CreateTeam of an existing team returned %v want ErrTeamExists", err)
+	}
+
+	for member, team := range map[string]string{"carol": "marketing", "alice": "marketing", "bob": "engineering"} {
+		if err := s.SetTeamMember(ctx, team, member); err != nil {
+			t.Fatalf("SetTeamMember returned an error: %v", err)
+		}
+	}
+	if err := s.SetTeamMember(ctx, "sales", "dave"); err != ErrNotFound {
+		t.Errorf("SetTeamMember of a missing team returned %v want ErrNotFound", err)
+	}
+	// A member moves rather than joining a second team.
+	if err := s.SetTeamMember(ctx, "engineering", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := s.RemoveTeamMember(ctx, "bob"); err != nil || !removed {
+		t.Errorf("RemoveTeamMember returned %v, %v want true", removed, err)
+	}
+	if removed, err := s.RemoveTeamMember(ctx, "bob"); err != nil || removed {
+		t.Errorf("RemoveTeamMember of a non-member returned %v, %v want false", removed, err)
+	}
+
+	teams, err := s.Teams(ctx)
+	if err != nil {
+		t.Fatalf("Teams returned an error: %v", err)
+	}
+	if len(teams) != 2 {
+		t.Fatalf("Teams returned %+v want 2 teams", teams)
+	}
+	if got := teams[0]; got.Name != "engineering" || strings.Join(got.Members, ",") != "carol" || got.CreatedAt.IsZero() {
+		t.Errorf("Teams returned %+v first", got)
+	}
+	if got := teams[1]; got.Name != "marketing" || strings.Join(got.Members, ",") != "alice" {
+		t.Errorf("Teams returned %+v second", got)
+	}
+
+	for _, shortURL := range []string{"abc123", "def456", "ghi789"} {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, shortURL := range []string{"abc123", "ghi789"} {
+		if err := s.SetTeam(ctx, shortURL, "marketing"); err != nil {
+			t.Fatalf("SetTeam returned an error: %v", err)
+		}
+	}
+	if err := s.SetTeam(ctx, "missing", "marketing"); err != ErrNotFound {
+		t.Errorf("SetTeam of a missing link returned %v want ErrNotFound", err)
+	}
+	if _, err := s.Trash(ctx, "ghi789"); err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.TeamLinks(ctx, "marketing")
+	if err != nil {
+		t.Fatalf("TeamLinks returned an error: %v", err)
+	}
+	if len(links) != 1 || links[0].ShortURL != "abc123" || links[0].LongURL != "https://example.com/abc123" {
+		t.Errorf("TeamLinks returned %+v want abc123", links)
+	}
+
+	if deleted, err := s.DeleteTeam(ctx, "marketing"); err != nil || !deleted {
+		t.Fatalf("DeleteTeam returned %v, %v want true", deleted, err)
+	}
+	if deleted, err := s.DeleteTeam(ctx, "marketing"); err != nil || deleted {
+		t.Errorf("DeleteTeam of a missing team returned %v, %v want false", deleted, err)
+	}
+	if links, err := s.TeamLinks(ctx, "marketing"); err != nil || len(links) != 0 {
+		t.Errorf("TeamLinks of a deleted team returned %+v, %v", links, err)
+	}
+	if teams, err := s.Teams(ctx); err != nil || len(teams) != 1 {
+		t.Errorf("Teams after DeleteTeam returned %+v, %v", teams, err)
+	}
+	// Recreating the team doesn't bring back its old members or links.
+	if err := s.CreateTeam(ctx, "marketing"); err != nil {
+		t.Fatal(err)
+	}
+	if teams, err := s.Teams(ctx); err != nil || len(teams[1].Members) != 0 {
+		t.Errorf("Teams after recreating a team returned %+v, %v", teams, err)
+	}
+}
+
+func TestSQLiteOwners(t *testing.T) {
+	testOwners(t, newTestSQLite(t))
+}
+
+// testOwners checks links remember the account that created them and an
+// API key's usage can be forgotten.
+func testOwners(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "ghi789"} {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, shortURL := range []string{"abc123", "ghi789"} {
+		if err := s.SetOwner(ctx, shortURL, "alice"); err != nil {
+			t.Fatalf("SetOwner returned an error: %v", err)
+		}
+	}
+	if err := s.SetOwner(ctx, "def456", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetOwner(ctx, "missing", "alice"); err != ErrNotFound {
+		t.Errorf("SetOwner of a missing link returned %v want ErrNotFound", err)
+	}
+	if _, err := s.Trash(ctx, "ghi789"); err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.OwnerLinks(ctx, "alice")
+	if err != nil {
+		t.Fatalf("OwnerLinks returned an error: %v", err)
+	}
+	if len(links) != 1 || links[0].ShortURL != "abc123" || links[0].LongURL != "https://example.com/abc123" {
+		t.Errorf("OwnerLinks returned %+v want abc123", links)
+	}
+
+	if err := s.SetOwner(ctx, "abc123", ""); err != nil {
+		t.Fatal(err)
+	}
+	if links, err := s.OwnerLinks(ctx, "alice"); err != nil || len(links) != 0 {
+		t.Errorf("OwnerLinks after clearing the owner returned %+v, %v", links, err)
+	}
+
+	for _, key := range []string{"ci", "ci-other"} {
+		for _, month := range []string{"2024-01", "2024-02"} {
+			if err := s.RecordUsage(ctx, key, month, 1, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := s.DeleteUsage(ctx, "ci"); err != nil {
+		t.Fatalf("DeleteUsage returned an error: %v", err)
+	}
+	if usage, err := s.Usage(ctx, "ci"); err != nil || len(usage) != 0 {
+		t.Errorf("Usage after DeleteUsage returned %+v, %v", usage, err)
+	}
+	if usage, err := s.Usage(ctx, "ci-other"); err != nil || len(usage) != 2 {
+		t.Errorf("DeleteUsage removed another key's usage: %+v, %v", usage, err)
+	}
+}
+
+func TestSQLiteSessionLinks(t *testing.T) {
+	testSessionLinks(t, newTestSQLite(t))
+}
+
+// testSessionLinks checks a session's links come latest first, again after
+// being handed out again, and are forgotten once purged.
+func testSessionLinks(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, shortURL := range []string{"abc123", "def456", "ghi789", "jkl012"} {
+		if err := s.Create(ctx, shortURL, "https://example.com/"+shortURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []struct{ session, shortURL string }{
+		{"s1", "abc123"}, {"s1", "def456"}, {"s2", "def456"}, {"s1", "ghi789"}, {"s1", "jkl012"}, {"s1", "abc123"},
+	} {
+		if err := s.AddSessionLink(ctx, l.session, l.shortURL); err != nil {
+			t.Fatalf("AddSessionLink returned an error: %v", err)
+		}
+	}
+	if _, err := s.Trash(ctx, "ghi789"); err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := s.SessionLinks(ctx, "s1", 10)
+	if err != nil {
+		t.Fatalf("SessionLinks returned an error: %v", err)
+	}
+	var got []string
+	for _, link := range links {
+		got = append(got, link.ShortURL)
+	}
+	if strings.Join(got, ",") != "abc123,jkl012,def456" {
+		t.Errorf("SessionLinks returned %v want abc123,jkl012,def456", got)
+	}
+	if links, err := s.SessionLinks(ctx, "s1", 2); err != nil || len(links) != 2 {
+		t.Errorf("SessionLinks with a limit of 2 returned %+v, %v", links, err)
+	}
+	if links, err := s.SessionLinks(ctx, "s3", 10); err != nil || len(links) != 0 {
+		t.Errorf("SessionLinks of an unknown session returned %+v, %v", links, err)
+	}
+
+	if purged, err := s.PurgeSessionLinks(ctx, time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("PurgeSessionLinks of an hour ago returned %d, %v", purged, err)
+	}
+	// abc123 was recorded twice for s1 but kept once.
+	if purged, err := s.PurgeSessionLinks(ctx, time.Now().Add(time.Hour)); err != nil || purged != 5 {
+		t.Errorf("PurgeSessionLinks returned %d, %v want 5", purged, err)
+	}
+	if links, err := s.SessionLinks(ctx, "s2", 10); err != nil || len(links) != 0 {
+		t.Errorf("SessionLinks after purging returned %+v, %v", links, err)
+	}
+}
+
+func TestSQLiteSearchLinks(t *testing.T) {
+	testSearchLinks(t, newTestSQLite(t))
+}
+
+func TestSQLiteSearchIndex(t *testing.T) {
+	s := newTestSQLite(t)
+	indexed, err := EnsureSearchIndex(context.Background(), s.DB())
+	if err != nil {
+		t.Fatalf("EnsureSearchIndex returned an error: %v", err)
+	}
+	if !indexed {
+		t.Skip("SQLite was built without FTS5")
+	}
+	testSearchLinks(t, s)
+	if !s.searchIndex {
+		t.Error("SearchLinks did not use the search index")
+	}
+	if err := Vacuum(context.Background(), s.DB()); err != nil {
+		t.Fatalf("Vacuum returned an error: %v", err)
+	}
+	if links, err := s.SearchLinks(context.Background(), "golang", -1); err != nil || len(links) != 1 {
+		t.Errorf("SearchLinks after Vacuum returned %+v, %v", links, err)
+	}
+}
+
+// testSearchLinks checks links are found by any part of their short URL,
+// long URL or tags, ignoring case, and stop being found once changed.
+func testSearchLinks(t *testing.T, s Store) {
+	ctx := context.Background()
+	for _, l := range []struct{ shortURL, longURL string }{
+		{"abc123", "https://example.com/Docs/Guide"},
+		{"def456", "https://golang.org/pkg"},
+		{"ghi789", "https://example.org/news"},
+		{"jkl012", "https://example.net/100%"},
+	} {
+		if err := s.Create(ctx, l.shortURL, l.longURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetTags(ctx, "ghi789", []string{"release-notes"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Trash(ctx, "jkl012"); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string, limit int) string {
+		t.Helper()
+		links, err := s.SearchLinks(ctx, query, limit)
+		if err != nil {
+			t.Fatalf("SearchLinks(%q) returned an error: %v", query, err)
+		}
+		var got []string
+		for _, link := range links {
+			got = append(got, link.ShortURL)
+		}
+		return strings.Join(got, ",")
+	}
+	for _, c := range []struct {
+		query string
+		limit int
+		want  string
+	}{
+		{"example", -1, "ghi789,abc123"},
+		{"example", 1, "ghi789"},
+		{"DOCS/guide", -1, "abc123"},
+		{"Release", -1, "ghi789"},
+		{"f45", -1, "def456"},
+		{"go", -1, "def456"},
+		{"%", -1, ""},
+		{"nowhere", -1, ""},
+	} {
+		if got := search(c.query, c.limit); got != c.want {
+			t.Errorf("SearchLinks(%q, %d) returned %q want %q", c.query, c.limit, got, c.want)
+		}
+	}
+
+	if err := s.SetLongURL(ctx, "abc123", "https://example.com/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetTags(ctx, "ghi789", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("guide", -1); got != "" {
+		t.Errorf("SearchLinks found a changed long URL: %q", got)
+	}
+	if got := search("release", -1); got != "" {
+		t.Errorf("SearchLinks found a removed tag: %q", got)
+	}
+	if got := search("moved", -1); got != "abc123" {
+		t.Errorf("SearchLinks(%q) returned %q want abc123", "moved", got)
+	}
+}
+
+func TestStatsUseIndexes(t *testing.T) {
+	s := newTestSQLite(t)
+	for query, index := range map[string]string{
+		"SELECT short_url FROM url_mapping WHERE deleted_at = '' ORDER BY visit_count DESC LIMIT 10": "idx_url_mapping_visit_count",
+		"SELECT short_url FROM url_mapping WHERE deleted_at = '' ORDER BY created_at DESC LIMIT 10":  "idx_url_mapping_created_at",
+		"SELECT short_url FROM url_mapping WHERE long_url = 'https://example.com'":                   "idx_url_mapping_long_url",
+	} {
+		rows, err := s.DB().Query("EXPLAIN QUERY PLAN " + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		if !strings.Contains(strings.Join(plan, "\n"), index) {
+			t.Errorf("%s doesn't use %s: %v", query, index, plan)
+		}
+	}
+}
+
+func TestSQLiteCard(t *testing.T) {
+	testCard(t, newTestSQLite(t))
+}
+
+// testCard checks a link's preview card is stored, replaced and removed,
+// and that links without one have none.
+func testCard(t *testing.T, s Store) {
+	ctx := context.Background()
+	if err := s.Create(ctx, "abc123", "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if card, err := s.Card(ctx, "abc123"); err != nil || !card.IsZero() {
+		t.Errorf("Card of a new link returned %+v, %v want none", card, err)
+	}
+
+	want := Card{Title: "Launch", Description: "Everything new", Image: "https://example.com/launch.png"}
+	if err := s.SetCard(ctx, "abc123", want); err != nil {
+		t.Fatal(err)
+	}
+	if card, err := s.Card(ctx, "abc123"); err != nil || card != want {
+		t.Errorf("Card returned %+v, %v want %+v", card, err, want)
+	}
+
+	if err := s.SetCard(ctx, "abc123", Card{}); err != nil {
+		t.Fatal(err)
+	}
+	if card, err := s.Card(ctx, "abc123"); err != nil || !card.IsZero() {
+		t.Errorf("Card after removing it returned %+v, %v want none", card, err)
+	}
+
+	if _, err := s.Card(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Card of a missing link returned %v want ErrNotFound", err)
+	}
+	if err := s.SetCard(ctx, "missing", want); err != ErrNotFound {
+		t.Errorf("SetCard on a missing link returned %v want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,639 @@
+// Package store persists shorty's links. SQLite is the built-in backend;
+// programs embedding shorty can supply their own by implementing Store.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a short URL or long URL has no mapping.
+	ErrNotFound = errors.New("short URL not found")
+	// ErrExists is returned by Create when the short URL is already taken.
+	ErrExists = errors.New("short URL already exists")
+	// ErrTeamExists is returned by CreateTeam when the name is taken.
+	ErrTeamExists = errors.New("team already exists")
+)
+
+// Store is the storage the server runs on. Methods must be safe for
+// concurrent use.
+type Store interface {
+	// LongURL returns the destination of shortURL, or ErrNotFound.
+	LongURL(ctx context.Context, shortURL string) (string, error)
+	// ShortURLFor returns the oldest short URL pointing at longURL, or
+	// ErrNotFound.
+	ShortURLFor(ctx context.Context, longURL string) (string, error)
+	// Exists reports whether shortURL is taken.
+	Exists(ctx context.Context, shortURL string) (bool, error)
+	// NextID returns the next number in a sequence that never repeats,
+	// for sequential short codes.
+	NextID(ctx context.Context) (uint64, error)
+	// Create stores a new mapping. It returns ErrExists if shortURL is
+	// already taken.
+	Create(ctx context.Context, shortURL, longURL string) error
+	// Delete removes a mapping and reports whether it existed.
+	Delete(ctx context.Context, shortURL string) (bool, error)
+	// Trash moves shortURL to the trash and reports whether it was found.
+	// Trashed links stop redirecting and drop out of lookups and stats,
+	// but keep their code until they are restored or purged.
+	Trash(ctx context.Context, shortURL string) (bool, error)
+	// Restore takes shortURL out of the trash, or returns ErrNotFound.
+	Restore(ctx context.Context, shortURL string) error
+	// Trashed returns the links in the trash, most recently deleted first.
+	Trashed(ctx context.Context) ([]TrashedLink, error)
+	// PurgeTrash deletes the links trashed before cutoff for good and
+	// returns how many there were.
+	PurgeTrash(ctx context.Context, cutoff time.Time) (int64, error)
+	// SetActive disables or re-enables shortURL, or returns ErrNotFound.
+	SetActive(ctx context.Context, shortURL string, active bool) error
+	// SetLongURL points shortURL somewhere else, or returns ErrNotFound.
+	SetLongURL(ctx context.Context, shortURL, longURL string) error
+	// RecordVisit adds one to the visit count of shortURL and reports
+	// whether the visit was counted. It isn't when the link doesn't exist
+	// or has reached its MaxClicks.
+	RecordVisit(ctx context.Context, shortURL string) (bool, error)
+	// RecordBotVisit is RecordVisit for a visit from a bot, which is
+	// counted apart and doesn't use up MaxClicks.
+	RecordBotVisit(ctx context.Context, shortURL string) (bool, error)
+	// BotVisits returns how many bot visits shortURL has had.
+	BotVisits(ctx context.Context, shortURL string) (int, error)
+	// Targets returns the per-platform destinations of shortURL, or
+	// ErrNotFound.
+	Targets(ctx context.Context, shortURL string) (Targets, error)
+	// SetTargets replaces the per-platform destinations of shortURL.
+	SetTargets(ctx context.Context, shortURL string, targets Targets) error
+	// Card returns the preview card of shortURL, or ErrNotFound.
+	Card(ctx context.Context, shortURL string) (Card, error)
+	// SetCard replaces the preview card of shortURL.
+	SetCard(ctx context.Context, shortURL string, card Card) error
+	// Options returns the per-link options of shortURL, or ErrNotFound.
+	Options(ctx context.Context, shortURL string) (Options, error)
+	// SetOptions replaces the per-link options of shortURL.
+	SetOptions(ctx context.Context, shortURL string, opts Options) error
+	// Variants returns the A/B variants of shortURL, in the order they were
+	// set. It returns none for an ordinary link.
+	Variants(ctx context.Context, shortURL string) ([]Variant, error)
+	// SetVariants replaces the variants of shortURL and resets their visit
+	// counts.
+	SetVariants(ctx context.Context, shortURL string, variants []Variant) error
+	// RecordVariantVisit adds one to the visit count of a variant.
+	RecordVariantVisit(ctx context.Context, shortURL, name string) error
+	// Tags returns the tags of shortURL, sorted. It returns none for an
+	// untagged link.
+	Tags(ctx context.Context, shortURL string) ([]string, error)
+	// SetTags replaces the tags of shortURL.
+	SetTags(ctx context.Context, shortURL string, tags []string) error
+	// TaggedLinks returns the short URLs tagged tag, oldest first. Trashed
+	// links are left out.
+	TaggedLinks(ctx context.Context, tag string) ([]string, error)
+	// SearchLinks returns up to limit links whose short URL, long URL or
+	// one of whose tags contains query, ignoring case, newest first.
+	// Trashed links are left out. A negative limit returns them all.
+	SearchLinks(ctx context.Context, query string, limit int) ([]LinkStats, error)
+	// FlagLink puts shortURL in the moderation queue, where it stops
+	// redirecting until ClearFlag takes it out. A link already in the
+	// queue keeps its first flag. It returns ErrNotFound if there is no
+	// such link.
+	FlagLink(ctx context.Context, shortURL, source, reason string) error
+	// ClearFlag takes shortURL out of the moderation queue and reports
+	// whether it was in it.
+	ClearFlag(ctx context.Context, shortURL string) (bool, error)
+	// FlaggedLinks returns the links in the moderation queue, longest
+	// waiting first. Trashed links are left out.
+	FlaggedLinks(ctx context.Context) ([]FlaggedLink, error)
+	// BanDomain adds domain to the banned domains, or replaces the reason
+	// it was banned for.
+	BanDomain(ctx context.Context, domain, reason string) error
+	// UnbanDomain lifts the ban on domain and reports whether there was
+	// one.
+	UnbanDomain(ctx context.Context, domain string) (bool, error)
+	// BannedDomains returns the banned domains in alphabetical order.
+	BannedDomains(ctx context.Context) ([]BannedDomain, error)
+	// CreateTakedown records a takedown notice and returns its ID.
+	CreateTakedown(ctx context.Context, takedown Takedown) (int64, error)
+	// TakeDown disables shortURL under takedown notice id. It answers with
+	// a removal notice instead of redirecting until SetActive switches it
+	// back on. It returns ErrNotFound if there is no such link.
+	TakeDown(ctx context.Context, shortURL string, id int64) error
+	// Takedowns returns the takedown notices, newest first, each with the
+	// links still taken down under it.
+	Takedowns(ctx context.Context) ([]Takedown, error)
+	// SetAPIKey records that shortURL was created with the API key named
+	// key, so its redirects count towards that key's usage. It returns
+	// ErrNotFound if there is no such link.
+	SetAPIKey(ctx context.Context, shortURL, key string) error
+	// RecordUsage adds creates and redirects to what the API key named key
+	// used in month, written "2006-01".
+	RecordUsage(ctx context.Context, key, month string, creates, redirects int) error
+	// Usage returns what the API key named key used, month by month,
+	// newest first.
+	Usage(ctx context.Context, key string) ([]Usage, error)
+	// DeleteUsage forgets what the API key named key used.
+	DeleteUsage(ctx context.Context, key string) error
+	// SetOwner records the account, a basicAuth user or an API key, that
+	// created shortURL. It returns ErrNotFound if there is no such link.
+	SetOwner(ctx context.Context, shortURL, owner string) error
+	// OwnerLinks returns the links owner created, in creation order.
+	OwnerLinks(ctx context.Context, owner string) ([]LinkStats, error)
+	// AddSessionLink records shortURL as the latest link the browser
+	// session session made, whether it was new or an existing one.
+	AddSessionLink(ctx context.Context, session, shortURL string) error
+	// SessionLinks returns up to limit live links session made, latest
+	// first.
+	SessionLinks(ctx context.Context, session string, limit int) ([]LinkStats, error)
+	// PurgeSessionLinks forgets the session links recorded before cutoff
+	// and returns how many it forgot.
+	PurgeSessionLinks(ctx context.Context, cutoff time.Time) (int64, error)
+	// CreateTeam adds an empty team. It returns ErrTeamExists if there is
+	// one by that name.
+	CreateTeam(ctx context.Context, name string) error
+	// DeleteTeam removes a team and its memberships. Its links stay, owned
+	// by no team. It reports whether there was such a team.
+	DeleteTeam(ctx context.Context, name string) (bool, error)
+	// Teams returns the teams in alphabetical order, each with its members
+	// in alphabetical order.
+	Teams(ctx context.Context) ([]Team, error)
+	// SetTeamMember puts member in team, taking it out of any other. It
+	// returns ErrNotFound if there is no such team.
+	SetTeamMember(ctx context.Context, team, member string) error
+	// RemoveTeamMember takes member out of its team. It reports whether
+	// member was in one.
+	RemoveTeamMember(ctx context.Context, member string) (bool, error)
+	// SetTeam makes team the owner of shortURL. It returns ErrNotFound if
+	// there is no such link.
+	SetTeam(ctx context.Context, shortURL, team string) error
+	// TeamLinks returns the links team owns, in creation order.
+	TeamLinks(ctx context.Context, team string) ([]LinkStats, error)
+	// Health returns the result of the last health check of shortURL's long
+	// URL, or ErrNotFound if it hasn't been checked.
+	Health(ctx context.Context, shortURL string) (Health, error)
+	// SetHealth records the result of a health check.
+	SetHealth(ctx context.Context, shortURL string, health Health) error
+	// BrokenLinks returns the links whose last health check failed, most
+	// recently checked first.
+	BrokenLinks(ctx context.Context) ([]BrokenLink, error)
+	// Links returns every link, oldest first.
+	Links(ctx context.Context) ([]LinkStats, error)
+	// LinksSince returns the links created since since, newest first.
+	LinksSince(ctx context.Context, since time.Time) ([]LinkStats, error)
+	// TopLinks returns up to limit links by their clicks since since,
+	// logged or rolled up, most clicked first. Bots are left out. A negative
+	// limit returns them all.
+	TopLinks(ctx context.Context, since time.Time, limit int) ([]LinkClicks, error)
+	// RecordAudit appends an entry to the audit log.
+	RecordAudit(ctx context.Context, entry AuditEntry) error
+	// AuditLog returns up to limit audit entries, newest first, for
+	// shortURL, or for every link when shortURL is empty.
+	AuditLog(ctx context.Context, shortURL string, limit int) ([]AuditEntry, error)
+	// RecordClick appends a counted visit to the click log.
+	RecordClick(ctx context.Context, click Click) error
+	// RecentClicks returns up to limit clicks, newest first, on shortURL,
+	// or on every link when shortURL is empty. Clicks by bots are left out
+	// of RecentClicks, ClickSeries and ClickHeatmap.
+	RecentClicks(ctx context.Context, shortURL string, limit int) ([]Click, error)
+	// ClickSeries counts the clicks on shortURL, or on every link when
+	// shortURL is empty, made since since, per interval and oldest first.
+	// Intervals without clicks are left out. Daily series include rolled
+	// up days.
+	ClickSeries(ctx context.Context, shortURL string, since time.Time, interval Interval) ([]ClickCount, error)
+	// ClickHeatmap counts the logged clicks on shortURL, or on every link
+	// when shortURL is empty, by day of the week and hour of the day.
+	ClickHeatmap(ctx context.Context, shortURL string) (Heatmap, error)
+	// ClickBursts returns the links that got at least minClicks clicks
+	// from at most maxIPs addresses since since, busiest first. Bots are
+	// left out.
+	ClickBursts(ctx context.Context, since time.Time, minClicks, maxIPs int) ([]Anomaly, error)
+	// RollupClicks compacts the clicks made before cutoff into one
+	// ClickRollup per link and day, deletes them from the click log and
+	// returns how many were compacted. Bots' clicks are deleted without
+	// being counted. cutoff should be a midnight UTC, so no day is split.
+	RollupClicks(ctx context.Context, cutoff time.Time) (int64, error)
+	// PurgeClicks deletes the clicks made before cutoff from the click log
+	// and returns how many there were.
+	PurgeClicks(ctx context.Context, cutoff time.Time) (int64, error)
+	// PurgeRollups deletes the rollups of days before cutoff and returns
+	// how many there were.
+	PurgeRollups(ctx context.Context, cutoff time.Time) (int64, error)
+	// ClickRollups returns the rollups of shortURL, or of every link when
+	// shortURL is empty, for the days since since, oldest first.
+	ClickRollups(ctx context.Context, shortURL string, since time.Time) ([]ClickRollup, error)
+	// RecordAnomaly appends a burst to the anomaly log.
+	RecordAnomaly(ctx context.Context, anomaly Anomaly) error
+	// Anomalies returns up to limit logged bursts, newest first.
+	Anomalies(ctx context.Context, limit int) ([]Anomaly, error)
+	// CreateAlert stores a new alert rule and returns its ID.
+	CreateAlert(ctx context.Context, alert Alert) (int64, error)
+	// Alerts returns the alert rules on shortURL, or on every link when
+	// shortURL is empty, oldest first.
+	Alerts(ctx context.Context, shortURL string) ([]Alert, error)
+	// UpdateAlert saves the LastCount, LastChange and FiredAt of an alert
+	// rule.
+	UpdateAlert(ctx context.Context, alert Alert) error
+	// DeleteAlert removes alert rule id from shortURL and reports whether
+	// it existed.
+	DeleteAlert(ctx context.Context, shortURL string, id int64) (bool, error)
+	// AcquireLease takes or renews the lease called name for holder until
+	// ttl from now, and reports whether holder has it. A lease held by
+	// someone else can only be taken once it has expired. Replicas sharing
+	// a store use leases so scheduled jobs run on one of them at a time.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// Link returns a link with its visit count, or ErrNotFound.
+	Link(ctx context.Context, shortURL string) (LinkStats, error)
+	// Stats returns the figures shown on the stats page.
+	Stats(ctx context.Context) (Stats, error)
+	// ForEachShortURL calls fn with every short URL taken, trashed ones
+	// included.
+	ForEachShortURL(ctx context.Context, fn func(shortURL string)) error
+	// HotLinks returns up to limit links by visit count, most visited
+	// first, with what a redirect needs to know about them. Trashed links
+	// are left out.
+	HotLinks(ctx context.Context, limit int) ([]HotLink, error)
+	// Count returns the number of stored links.
+	Count(ctx context.Context) (int, error)
+	// Close releases the store's resources.
+	Close() error
+}
+
+// Platforms that can have a target of their own.
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+// Targets are per-platform destinations, such as an App Store page or an
+// app deep link, that replace the long URL for visitors on that platform.
+// Everyone else gets the long URL.
+type Targets struct {
+	IOS     string `json:"ios,omitempty"`
+	Android string `json:"android,omitempty"`
+}
+
+// IsZero reports whether no platform has a target.
+func (t Targets) IsZero() bool {
+	return t.IOS == "" && t.Android == ""
+}
+
+// For returns the target for platform, or "" if it has none.
+func (t Targets) For(platform string) string {
+	switch platform {
+	case PlatformIOS:
+		return t.IOS
+	case PlatformAndroid:
+		return t.Android
+	}
+	return ""
+}
+
+// Card is what link unfurlers, such as Slack or X building a preview of a
+// shared link, are shown of a link instead of being redirected. Fields left
+// empty fall back to a description of the destination.
+type Card struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Image is the URL of the preview image.
+	Image string `json:"image,omitempty"`
+}
+
+// IsZero reports whether the card sets nothing.
+func (c Card) IsZero() bool {
+	return c == Card{}
+}
+
+// Options are per-link switches that change how a visitor's destination is
+// built.
+type Options struct {
+	// PassQuery adds the query string of the visited short URL to the
+	// destination.
+	PassQuery bool `json:"passQuery,omitempty"`
+	// Prefix makes the link an alias for a whole site: anything after the
+	// short code is appended to the destination's path.
+	Prefix bool `json:"prefix,omitempty"`
+	// UTM campaign tags added to the destination's query string.
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	// FallbackURL replaces the long URL while health checks find it down.
+	FallbackURL string `json:"fallbackURL,omitempty"`
+	// MaxClicks stops the link redirecting once it has been visited this
+	// many times. 0 means no limit.
+	MaxClicks int `json:"maxClicks,omitempty"`
+	// NoAnalytics links redirect without counting or logging the visit.
+	// They can't have a MaxClicks, which needs the count.
+	NoAnalytics bool `json:"noAnalytics,omitempty"`
+	// ExpiresAt, when set, is when the link stops redirecting.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Disabled links answer 410 Gone instead of redirecting, and keep
+	// their stats. It is set with SetActive; SetOptions leaves it alone.
+	Disabled bool `json:"-"`
+	// Flagged links wait in the moderation queue and don't redirect until
+	// a moderator approves them. It is set with FlagLink and ClearFlag;
+	// SetOptions leaves it alone.
+	Flagged bool `json:"-"`
+	// TakenDown links were disabled under a takedown notice and answer
+	// with a removal notice. It is set with TakeDown and cleared when
+	// SetActive switches the link back on; SetOptions leaves it alone.
+	TakenDown bool `json:"-"`
+	// APIKey names the API key the link was created with. It is set with
+	// SetAPIKey; SetOptions leaves it alone.
+	APIKey string `json:"-"`
+}
+
+// IsZero reports whether every option is off.
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// Expired reports whether the link has passed its ExpiresAt by now.
+func (o Options) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)
+}
+
+// Variant is one destination of an A/B split link. Each visit goes to one
+// variant, picked at random in proportion to its weight.
+type Variant struct {
+	Name       string `json:"name"`
+	URL        string `json:"url"`
+	Weight     int    `json:"weight"`
+	VisitCount int    `json:"visitCount"`
+}
+
+// Health is the outcome of requesting a link's long URL. StatusCode is
+// zero when no response came back, and Error says why.
+type Health struct {
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+// Healthy reports whether the long URL answered without an error status.
+func (h Health) Healthy() bool {
+	return h.Error == "" && h.StatusCode > 0 && h.StatusCode < 400
+}
+
+// Describe returns the HTTP status or the error, for display.
+func (h Health) Describe() string {
+	if h.Error != "" {
+		return h.Error
+	}
+	return fmt.Sprintf("HTTP %d", h.StatusCode)
+}
+
+func (h Health) FormattedCheckedAt() string {
+	return h.CheckedAt.Format("2006-01-02 15:04:05")
+}
+
+// BrokenLink is a link whose last health check failed.
+type BrokenLink struct {
+	ShortURL string `json:"shortURL"`
+	LongURL  string `json:"longURL"`
+	Health
+}
+
+// TrashedLink is a deleted link waiting in the trash.
+type TrashedLink struct {
+	LinkStats
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+func (l TrashedLink) FormattedDeletedAt() string {
+	return l.DeletedAt.Format("2006-01-02 15:04:05")
+}
+
+// FlaggedLink is a link waiting in the moderation queue.
+type FlaggedLink struct {
+	LinkStats
+	// Source says what flagged the link, such as "policy" or "report".
+	Source    string    `json:"source"`
+	Reason    string    `json:"reason,omitempty"`
+	FlaggedAt time.Time `json:"flaggedAt"`
+}
+
+func (l FlaggedLink) FormattedFlaggedAt() string {
+	return l.FlaggedAt.Format("2006-01-02 15:04:05")
+}
+
+// BannedDomain is a domain new links may not point at. A ban covers the
+// domain's subdomains too.
+type BannedDomain struct {
+	Domain   string    `json:"domain"`
+	Reason   string    `json:"reason,omitempty"`
+	BannedAt time.Time `json:"bannedAt"`
+}
+
+func (d BannedDomain) FormattedBannedAt() string {
+	return d.BannedAt.Format("2006-01-02 15:04:05")
+}
+
+// What a takedown notice names.
+const (
+	TakedownLink   = "link"
+	TakedownDomain = "domain"
+)
+
+// Takedown is a request from outside, such as an abuse desk or a rights
+// holder, to remove a link or every link to a domain.
+type Takedown struct {
+	ID int64 `json:"id"`
+	// Kind is TakedownLink or TakedownDomain, and Target the short URL or
+	// domain it names.
+	Kind      string    `json:"kind"`
+	Target    string    `json:"target"`
+	Requester string    `json:"requester,omitempty"`
+	Reference string    `json:"reference,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Links are the short URLs still taken down under the notice.
+	Links []string `json:"links,omitempty"`
+}
+
+func (t Takedown) FormattedCreatedAt() string {
+	return t.CreatedAt.Format("2006-01-02 15:04:05")
+}
+
+// Team is a group of people who own links together, such as a marketing
+// department. Members are the basicAuth users and API keys in it, by name.
+type Team struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Members   []string  `json:"members"`
+}
+
+func (t Team) FormattedCreatedAt() string {
+	return t.CreatedAt.Format("2006-01-02 15:04:05")
+}
+
+// Usage is what one API key used in one month: the links it created and
+// the redirects of the links it created.
+type Usage struct {
+	Month     string `json:"month"`
+	Creates   int    `json:"creates"`
+	Redirects int    `json:"redirects"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditCreate   = "create"
+	AuditUpdate   = "update"
+	AuditDisable  = "disable"
+	AuditEnable   = "enable"
+	AuditDelete   = "delete"
+	AuditTrash    = "trash"
+	AuditRestore  = "restore"
+	AuditFlag     = "flag"
+	AuditApprove  = "approve"
+	AuditTakedown = "takedown"
+)
+
+// AuditEntry is one change to a link. Before and After are JSON snapshots
+// of the link; Before is empty for a create and After for a delete.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	ShortURL  string          `json:"shortURL"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Click is one counted visit in the click log. Bot says why the visitor
+// was taken for a bot, and is empty for people.
+type Click struct {
+	ShortURL  string    `json:"shortURL"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	IP        string    `json:"-"`
+	Country   string    `json:"country,omitempty"`
+	Bot       string    `json:"bot,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+func (c Click) FormattedAt() string {
+	return c.At.Format("2006-01-02 15:04:05")
+}
+
+// Interval is the length of the buckets ClickSeries counts clicks in.
+type Interval string
+
+const (
+	Hourly Interval = "hour"
+	Daily  Interval = "day"
+)
+
+// ClickCount is the number of clicks in the interval starting at Time.
+type ClickCount struct {
+	Time   time.Time `json:"time"`
+	Clicks int       `json:"clicks"`
+}
+
+// ClickRollup sums up a day of clicks on a link once its raw clicks have
+// been compacted. Uniques counts distinct addresses; TopReferrer and
+// TopCountry are empty when no click had one.
+type ClickRollup struct {
+	ShortURL    string    `json:"shortURL"`
+	Day         time.Time `json:"day"`
+	Clicks      int       `json:"clicks"`
+	Uniques     int       `json:"uniques"`
+	TopReferrer string    `json:"topReferrer,omitempty"`
+	TopCountry  string    `json:"topCountry,omitempty"`
+}
+
+// LinkClicks is a link with its clicks over some period.
+type LinkClicks struct {
+	ShortURL string `json:"shortURL"`
+	LongURL  string `json:"longURL"`
+	Clicks   int    `json:"clicks"`
+}
+
+// HotLink is a link's destination and options, as a redirect reads them.
+type HotLink struct {
+	ShortURL string  `json:"shortURL"`
+	LongURL  string  `json:"longURL"`
+	Options  Options `json:"options"`
+}
+
+// Heatmap counts clicks by day of the week, Sunday first, and hour of the
+// day, in UTC.
+type Heatmap [7][24]int
+
+// ByHour adds up the clicks in each hour of the day.
+func (h Heatmap) ByHour() [24]int {
+	var hours [24]int
+	for _, day := range h {
+		for hour, clicks := range day {
+			hours[hour] += clicks
+		}
+	}
+	return hours
+}
+
+// ByWeekday adds up the clicks on each day of the week.
+func (h Heatmap) ByWeekday() [7]int {
+	var days [7]int
+	for weekday, day := range h {
+		for _, clicks := range day {
+			days[weekday] += clicks
+		}
+	}
+	return days
+}
+
+// Anomaly is a burst of clicks on a link from few addresses, starting at
+// WindowStart. ID and DetectedAt are set once it is logged.
+type Anomaly struct {
+	ID          int64     `json:"id,omitempty"`
+	ShortURL    string    `json:"shortURL"`
+	Clicks      int       `json:"clicks"`
+	UniqueIPs   int       `json:"uniqueIPs"`
+	WindowStart time.Time `json:"windowStart"`
+	DetectedAt  time.Time `json:"detectedAt,omitempty"`
+}
+
+// Kinds of alert rule.
+const (
+	// AlertClicks fires once a link has Threshold visits.
+	AlertClicks = "clicks"
+	// AlertIdle fires once a link has gone Threshold days without a visit,
+	// and again after every quiet spell that follows a visit.
+	AlertIdle = "idle"
+)
+
+// Alert is a rule that notifies Email, Webhook or both when a link's
+// visits cross a threshold. LastCount and LastChange track the visit count
+// for idle rules; FiredAt is nil until the rule fires.
+type Alert struct {
+	ID         int64      `json:"id"`
+	ShortURL   string     `json:"shortURL"`
+	Kind       string     `json:"kind"`
+	Threshold  int        `json:"threshold"`
+	Email      string     `json:"email,omitempty"`
+	Webhook    string     `json:"webhook,omitempty"`
+	LastCount  int        `json:"-"`
+	LastChange time.Time  `json:"-"`
+	FiredAt    *time.Time `json:"firedAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type LinkStats struct {
+	ShortURL   string    `json:"shortURL"`
+	LongURL    string    `json:"longURL"`
+	VisitCount int       `json:"visitCount"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (l LinkStats) FormattedCreatedAt() string {
+	return l.CreatedAt.Format("2006-01-02 15:04:05")
+}
+
+type Stats struct {
+	TotalLinks       int
+	TotalClicks      int
+	ClicksToday      int
+	PopularLinks     []LinkStats
+	RecentLinks      []LinkStats
+	MostClickedLinks []LinkStats
+}